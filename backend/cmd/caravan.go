@@ -3,7 +3,9 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net"
@@ -16,13 +18,22 @@ import (
 
 	"github.com/rs/cors"
 
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/auth"
 	"github.com/caravan-nomad/caravan/backend/pkg/cache"
 	"github.com/caravan-nomad/caravan/backend/pkg/config"
+	"github.com/caravan-nomad/caravan/backend/pkg/execpolicy"
+	"github.com/caravan-nomad/caravan/backend/pkg/forwarded"
+	"github.com/caravan-nomad/caravan/backend/pkg/freeze"
+	"github.com/caravan-nomad/caravan/backend/pkg/killswitch"
+	"github.com/caravan-nomad/caravan/backend/pkg/lint"
 	"github.com/caravan-nomad/caravan/backend/pkg/logger"
 	"github.com/caravan-nomad/caravan/backend/pkg/nomad"
 	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
+	"github.com/caravan-nomad/caravan/backend/pkg/nsscope"
 	"github.com/caravan-nomad/caravan/backend/pkg/plugins"
 	"github.com/caravan-nomad/caravan/backend/pkg/spa"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
 	"github.com/caravan-nomad/caravan/backend/pkg/telemetry"
 )
 
@@ -40,14 +51,35 @@ type CaravanConfig struct {
 	ProxyURLs           []string
 	TLSCertPath         string
 	TLSKeyPath          string
-	NomadConfigStore    nomadconfig.ContextStore
-	cache               cache.Cache[interface{}]
-	multiplexer         *Multiplexer
-	nomadHandler        *nomad.Handler
+	TrustedProxies      forwarded.Proxies
+	EnableGraphQL       bool
+	// SlowRequestThreshold is how long a request may take before a warning
+	// is logged, 0 for never
+	SlowRequestThreshold time.Duration
+	NomadConfigStore     nomadconfig.ContextStore
+	cache                cache.Cache[interface{}]
+	multiplexer          *Multiplexer
+	nomadHandler         *nomad.Handler
+	dataStore            store.Store
+	storeBackend         string
 }
 
+// buildString is the version of the build, set at link time by the release
+var buildString = "unknown"
+
+// freezeWarningPeriod is how far ahead /config reports upcoming freeze windows
+const freezeWarningPeriod = 7 * 24 * time.Hour
+
 type clientConfig struct {
-	Clusters []Cluster `json:"clusters"`
+	Clusters      []Cluster            `json:"clusters"`
+	FreezeWindows []freeze.Occurrence  `json:"freezeWindows,omitempty"`
+	DryRun        bool                 `json:"dryRun,omitempty"`
+	ExecPresets   []execpolicy.Preset  `json:"execPresets,omitempty"`
+	Announcements []nomad.Announcement `json:"announcements,omitempty"`
+	// DisplayTimezone is the time zone times are shown in, empty for the
+	// browser's own
+	DisplayTimezone string           `json:"displayTimezone,omitempty"`
+	ListLimits      nomad.ListLimits `json:"listLimits"`
 }
 
 // returns True if a file exists.
@@ -124,37 +156,69 @@ func serveWithNoCacheHeader(fs http.Handler) http.HandlerFunc {
 	}
 }
 
-// requestLogger is a middleware that logs requests and records metrics
-func requestLogger(next http.Handler, devMode bool) http.Handler {
+// requestLogger is a middleware that logs requests and records metrics.
+// Requests to a cluster are tagged with its name, and requests slower than
+// slowThreshold are logged as warnings with the time spent waiting on Nomad,
+// to tell a slow cluster from a slow Caravan.
+func requestLogger(next http.Handler, devMode bool, slowThreshold time.Duration) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
 		// Create a response wrapper to capture status code
 		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
+		ctx, upstream := nomadconfig.WithUpstreamTiming(r.Context())
+
 		// Call the next handler
-		next.ServeHTTP(rw, r)
+		next.ServeHTTP(rw, r.WithContext(ctx))
 
 		// Record metrics (always)
 		duration := time.Since(start)
 		telemetry.RecordHTTPRequest(r.Method, r.URL.Path, rw.statusCode, duration.Seconds())
 
+		fields := map[string]string{
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"status":   fmt.Sprintf("%d", rw.statusCode),
+			"duration": duration.String(),
+			"client":   forwarded.ClientIP(r),
+		}
+		if cluster := requestCluster(r.URL.Path); cluster != "" {
+			fields["cluster"] = cluster
+		}
+
+		// Streams and WebSockets stay open for as long as they are followed
+		if slowThreshold > 0 && duration > slowThreshold && !rw.streamed {
+			fields["upstream"] = upstream.Duration().String()
+			fields["upstreamCalls"] = fmt.Sprintf("%d", upstream.Calls())
+			logger.Log(logger.LevelWarn, fields, nil, "slow request")
+			return
+		}
+
 		// Only log requests in dev mode
 		if devMode {
-			logger.Log(logger.LevelInfo, map[string]string{
-				"method":   r.Method,
-				"path":     r.URL.Path,
-				"status":   fmt.Sprintf("%d", rw.statusCode),
-				"duration": duration.String(),
-			}, nil, "")
+			logger.Log(logger.LevelInfo, fields, nil, "")
 		}
 	})
 }
 
+// requestCluster returns the cluster a request path is about, "" if none
+func requestCluster(path string) string {
+	_, rest, ok := strings.Cut(path, "/api/clusters/")
+	if !ok {
+		return ""
+	}
+
+	cluster, _, _ := strings.Cut(rest, "/")
+	return cluster
+}
+
 // responseWriter wraps http.ResponseWriter to capture status code
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	// streamed is set once the response is hijacked or flushed
+	streamed bool
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -164,6 +228,7 @@ func (rw *responseWriter) WriteHeader(code int) {
 
 // Hijack implements http.Hijacker interface to support WebSocket upgrades
 func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	rw.streamed = true
 	if hijacker, ok := rw.ResponseWriter.(http.Hijacker); ok {
 		return hijacker.Hijack()
 	}
@@ -172,6 +237,7 @@ func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 
 // Flush implements http.Flusher interface for streaming responses
 func (rw *responseWriter) Flush() {
+	rw.streamed = true
 	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
 		flusher.Flush()
 	}
@@ -221,20 +287,50 @@ func addNomadRoutes(config *CaravanConfig, mux *http.ServeMux) {
 	mux.HandleFunc("POST /api/clusters/{cluster}/v1/auth/login", authHandler.Login)
 	mux.HandleFunc("POST /api/clusters/{cluster}/v1/auth/logout", authHandler.Logout)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/auth/check", authHandler.CheckAuth)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/auth/onetime/exchange", authHandler.ExchangeOneTimeToken)
 
 	// Cluster health endpoint - checks if cluster is reachable and auth is valid
 	mux.HandleFunc("GET /api/clusters/{cluster}/health", h.ClusterHealth)
 
+	// Device (GPU) inventory across the cluster's nodes
+	mux.HandleFunc("GET /api/clusters/{cluster}/devices", h.GetDevices)
+
+	// Service dependency graph of the jobs of a cluster
+	mux.HandleFunc("GET /api/clusters/{cluster}/graph", h.GetServiceGraph)
+
 	// Jobs - use query param for jobID to handle slashes in job names (e.g., periodic jobs)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/jobs", h.ListJobs)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/jobs/evaluation-churn", h.GetEvaluationChurn) // ?namespace=
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/jobs/stop", h.StopJobs)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job", h.GetJob)                        // ?id=jobID
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/detail", h.GetJobDetail)            // ?id=jobID
 	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job", h.UpdateJob)                    // ?id=jobID
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job/plan", h.PlanJob)                  // ?diff=true
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job/preflight", h.PreflightJob)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job/run-hcl", h.RunHCL)                // ?plan=true
 	mux.HandleFunc("DELETE /api/clusters/{cluster}/v1/job", h.DeleteJob)                  // ?id=jobID
 	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job/dispatch", h.DispatchJob)         // ?id=jobID
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job/evaluate", h.EvaluateJob)         // ?id=jobID
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/allocations", h.GetJobAllocations)   // ?id=jobID
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/versions", h.GetJobVersions)         // ?id=jobID
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/diff", h.GetJobDiff)                 // ?id=jobID&from=N&to=M
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/children", h.GetJobChildren)         // ?id=jobID
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/dispatches", h.GetJobDispatches)     // ?id=jobID
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/coverage", h.GetSystemJobCoverage)   // ?id=jobID
+	mux.HandleFunc("PUT /api/clusters/{cluster}/v1/job/version/annotation", h.PutJobVersionAnnotation) // ?id=jobID&version=N
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/evaluations", h.GetJobEvaluations)   // ?id=jobID
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/deployments", h.GetJobDeployments)   // ?id=jobID&all=true
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/deployment", h.GetJobLatestDeployment) // ?id=jobID
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/deployment-history", h.GetJobDeploymentHistory) // ?id=jobID
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/history", h.GetJobHistory)                       // ?id=jobID
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/deployment-watch", h.GetDeploymentWatch)         // ?id=jobID
+	mux.HandleFunc("PUT /api/clusters/{cluster}/v1/job/deployment-watch", h.PutDeploymentWatch)         // ?id=jobID
+	mux.HandleFunc("DELETE /api/clusters/{cluster}/v1/job/deployment-watch", h.DeleteDeploymentWatch)   // ?id=jobID
 	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job/scale", h.ScaleJob)                 // ?id=jobID
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/scale-status", h.GetJobScaleStatus)  // ?id=jobID
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/events", h.StreamJobEvents)          // ?id=jobID
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/actions", h.ListJobActions)          // ?id=jobID
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/action", h.RunJobAction)             // ?id=jobID&action=name&allocID=&task=
 
 	// Allocations
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocations", h.ListAllocations)
@@ -243,16 +339,23 @@ func addNomadRoutes(config *CaravanConfig, mux *http.ServeMux) {
 	mux.HandleFunc("POST /api/clusters/{cluster}/v1/allocation/{allocID}/stop", h.StopAllocation)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/logs/{task}", h.StreamLogs)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/stats", h.GetAllocationStats)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/connect", h.GetAllocationConnect)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/exec/{task}", h.ExecAllocation)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/allocation/{allocID}/upload/{task}", h.UploadAllocFile)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/download/{task}", h.DownloadAllocFile)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/fs", h.GetAllocFS)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/file", h.ReadAllocFile)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/file/stream", h.StreamAllocFile)
 
 	// Nodes
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/nodes", h.ListNodes)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/node/{nodeID}", h.GetNode)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/node/{nodeID}/detail", h.GetNodeDetail)
 	mux.HandleFunc("POST /api/clusters/{cluster}/v1/node/{nodeID}/drain", h.DrainNode)
 	mux.HandleFunc("POST /api/clusters/{cluster}/v1/node/{nodeID}/eligibility", h.SetEligibility)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/node/{nodeID}/purge", h.PurgeNode)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/node/{nodeID}/allocations", h.GetNodeAllocations)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/node/{nodeID}/networks", h.GetNodeNetworks)
 
 	// Namespaces
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/namespaces", h.ListNamespaces)
@@ -268,8 +371,16 @@ func addNomadRoutes(config *CaravanConfig, mux *http.ServeMux) {
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/acl/tokens", h.ListACLTokens)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/acl/token/self", h.GetSelfToken)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/acl/token/{tokenID}", h.GetACLToken)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/acl/token/{tokenID}/activity", h.GetACLTokenActivity) // ?staleAfter=
+	mux.HandleFunc("DELETE /api/clusters/{cluster}/v1/acl/token/{tokenID}", h.DeleteACLToken)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/acl/token/onetime", h.CreateOneTimeToken)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/acl/policies", h.ListACLPolicies)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/acl/policy/{policyName}", h.GetACLPolicy)
+	mux.HandleFunc("DELETE /api/clusters/{cluster}/v1/acl/policy/{policyName}", h.DeleteACLPolicy)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/acl/bootstrap", h.BootstrapACL)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/acl/impersonation", h.GetImpersonation)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/acl/impersonation", h.StartImpersonation)
+	mux.HandleFunc("DELETE /api/clusters/{cluster}/v1/acl/impersonation", h.StopImpersonation)
 
 	// ACL OIDC Authentication
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/acl/auth-methods", h.ListAuthMethods)
@@ -281,6 +392,10 @@ func addNomadRoutes(config *CaravanConfig, mux *http.ServeMux) {
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/evaluation/{evalID}", h.GetEvaluation)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/evaluation/{evalID}/allocations", h.GetEvaluationAllocations)
 
+	// System
+	mux.HandleFunc("PUT /api/clusters/{cluster}/v1/system/gc", h.GarbageCollect)
+	mux.HandleFunc("PUT /api/clusters/{cluster}/v1/system/reconcile/summaries", h.ReconcileSummaries)
+
 	// Deployments
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/deployments", h.ListDeployments)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/deployment/{deployID}", h.GetDeployment)
@@ -288,13 +403,112 @@ func addNomadRoutes(config *CaravanConfig, mux *http.ServeMux) {
 	mux.HandleFunc("POST /api/clusters/{cluster}/v1/deployment/{deployID}/fail", h.FailDeployment)
 	mux.HandleFunc("POST /api/clusters/{cluster}/v1/deployment/{deployID}/pause", h.PauseDeployment)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/deployment/{deployID}/allocations", h.GetDeploymentAllocations)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/deployment/{deployID}/canary-compare", h.GetCanaryComparison)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/deployment/{deployID}/watch", h.StreamDeploymentProgress)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/utils/constraint-check", h.CheckConstraints)
 
 	// Services
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/services", h.ListServices)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/service/{serviceName}", h.GetService)
 
+	// Nomad Autoscaler
+	mux.HandleFunc("GET /api/clusters/{cluster}/autoscaler/health", h.GetAutoscalerHealth)
+	mux.HandleFunc("GET /api/clusters/{cluster}/autoscaler/metrics", h.GetAutoscalerMetrics)
+	mux.HandleFunc("GET /api/clusters/{cluster}/autoscaler/policies", h.ListAutoscalerPolicies)
+	mux.HandleFunc("GET /api/clusters/{cluster}/autoscaler/policy/{policyID}", h.GetAutoscalerPolicy)
+
 	// Events (Server-Sent Events)
 	mux.HandleFunc("GET /api/clusters/{cluster}/v1/event/stream", h.StreamEvents)
+	// Live updates of one object, cheaper than the event stream
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/watch", h.Watch) // ?type=job&id=jobID
+
+	// Event forwarding to external sinks
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/event/forward", h.ListEventForwards)
+	mux.HandleFunc("PUT /api/clusters/{cluster}/v1/event/forward", h.PutEventForward)
+	mux.HandleFunc("DELETE /api/clusters/{cluster}/v1/event/forward", h.DeleteEventForward) // ?id=forwardID
+
+	// Job specification tooling for the editor
+	mux.HandleFunc("POST /api/utils/hcl/format", h.FormatHCL)
+	mux.HandleFunc("POST /api/utils/hcl/validate", h.ValidateHCL) // ?cluster=
+	mux.HandleFunc("POST /api/utils/job/to-hcl", h.JobToHCL)
+	mux.HandleFunc("POST /api/utils/job/lint", h.LintJob) // ?cluster=
+	mux.HandleFunc("GET /api/utils/job/lint/rules", h.LintRules)
+	mux.HandleFunc("GET /api/utils/cron/next", h.CronNext) // ?expr=&tz=&count=
+
+	// Token vault: one place to keep a browser's tokens for all clusters
+	mux.HandleFunc("GET /api/tokens", h.ListVaultTokens)
+	mux.HandleFunc("PUT /api/tokens", h.RegisterVaultToken)
+	mux.HandleFunc("DELETE /api/tokens", h.DeleteVault)
+	mux.HandleFunc("DELETE /api/tokens/{cluster}", h.DeleteVaultToken)
+
+	// Single sign-on into every cluster sharing an auth method, tokens go to the vault
+	mux.HandleFunc("GET /api/sso/methods", h.ListSSOMethods)
+	mux.HandleFunc("POST /api/sso/oidc/start", h.StartSSO)
+	mux.HandleFunc("POST /api/sso/oidc/complete", h.CompleteSSO)
+	mux.HandleFunc("POST /api/sso/jwt", h.LoginSSOJWT)
+
+	// Signed links to a view of a cluster
+	mux.HandleFunc("POST /api/share-links", h.CreateShareLink)
+	mux.HandleFunc("GET /api/share-links/{token}", authHandler.OpenShareLink)
+
+	// Saved searches, private to their owner unless shared
+	mux.HandleFunc("GET /api/views", h.ListViews) // ?cluster=
+	mux.HandleFunc("POST /api/views", h.CreateView)
+	mux.HandleFunc("GET /api/views/{id}", h.GetView)
+	mux.HandleFunc("PUT /api/views/{id}", h.UpdateView)
+	mux.HandleFunc("DELETE /api/views/{id}", h.DeleteView)
+
+	// Registry of the teams owning jobs, shown on their pages
+	mux.HandleFunc("GET /api/ownership", h.ListOwnershipRules)
+	mux.HandleFunc("POST /api/ownership", h.CreateOwnershipRule)
+	mux.HandleFunc("PUT /api/ownership/{id}", h.UpdateOwnershipRule)
+	mux.HandleFunc("DELETE /api/ownership/{id}", h.DeleteOwnershipRule)
+
+	// Runbook rules, hinting at remediations of matching failures
+	mux.HandleFunc("GET /api/runbooks", h.ListRunbookRules)
+	mux.HandleFunc("POST /api/runbooks", h.CreateRunbookRule)
+	mux.HandleFunc("PUT /api/runbooks/{id}", h.UpdateRunbookRule)
+	mux.HandleFunc("DELETE /api/runbooks/{id}", h.DeleteRunbookRule)
+
+	// Announcements shown to every user, also pushed over the multiplexer
+	mux.HandleFunc("GET /api/announcements", h.ListAnnouncements)
+	mux.HandleFunc("POST /api/announcements", h.CreateAnnouncement)
+	mux.HandleFunc("PUT /api/announcements/{id}", h.UpdateAnnouncement)
+	mux.HandleFunc("DELETE /api/announcements/{id}", h.DeleteAnnouncement)
+
+	// Audit log of the clusters the caller manages
+	mux.HandleFunc("GET /api/audit", h.ListAuditLog) // ?from=&to=&cluster=&action=&sort=&cursor=&limit=
+
+	// Approvals of destructive actions (two-person rule)
+	mux.HandleFunc("GET /api/approvals", h.ListApprovals) // ?status=pending
+	mux.HandleFunc("GET /api/approvals/{id}", h.GetApproval)
+	mux.HandleFunc("POST /api/approvals/{id}/approve", h.ApproveApproval)
+	mux.HandleFunc("POST /api/approvals/{id}/reject", h.RejectApproval)
+	mux.HandleFunc("DELETE /api/approvals/{id}", h.CancelApproval)
+
+	// Open SSE streams, WebSockets and multiplexer subscriptions
+	mux.HandleFunc("GET /api/admin/streams", h.ListStreams)
+	mux.HandleFunc("DELETE /api/admin/streams/{id}", h.CloseStream)
+
+	// Cache and client pool state, to debug stale data without a restart
+	mux.HandleFunc("GET /api/admin/cache", h.GetCacheStats)           // ?cluster=
+	mux.HandleFunc("DELETE /api/admin/cache", h.PurgeCache)           // ?cluster=
+	mux.HandleFunc("GET /api/admin/clients", h.ListPooledClients)     // ?cluster=
+	mux.HandleFunc("DELETE /api/admin/clients", h.PurgePooledClients) // ?cluster=
+
+	// Kill switches turning expensive features off during an incident
+	mux.HandleFunc("GET /api/admin/kill-switches", h.ListKillSwitches)
+	mux.HandleFunc("PUT /api/admin/kill-switches/{feature}", h.ToggleKillSwitch)
+
+	// Sessions of the Caravan admin, with the password saved during setup
+	mux.HandleFunc("POST /api/admin/login", h.AdminLogin)
+	mux.HandleFunc("POST /api/admin/logout", h.AdminLogout)
+
+	// GraphQL gateway over the data of all clusters
+	if config.EnableGraphQL {
+		mux.HandleFunc("GET /api/graphql", h.GraphQL)
+		mux.HandleFunc("POST /api/graphql", h.GraphQL)
+	}
 }
 
 // getConfig returns the configuration for the frontend
@@ -319,7 +533,14 @@ func (c *CaravanConfig) getConfig(w http.ResponseWriter, r *http.Request) {
 	})
 
 	clientConf := clientConfig{
-		Clusters: clusters,
+		Clusters:      clusters,
+		FreezeWindows: c.nomadHandler.FreezeWindows(freezeWarningPeriod),
+		DryRun:        c.nomadHandler.DryRun(),
+		ExecPresets:   c.nomadHandler.ExecPresets(),
+		Announcements: c.nomadHandler.Announcements(r.Context()),
+
+		DisplayTimezone: c.nomadHandler.DisplayTimezone(),
+		ListLimits:      c.nomadHandler.ListLimits(),
 	}
 
 	if err := json.NewEncoder(w).Encode(clientConf); err != nil {
@@ -327,39 +548,99 @@ func (c *CaravanConfig) getConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// versionInfo is the response of /api/version
+type versionInfo struct {
+	Version string       `json:"version"`
+	Store   storeVersion `json:"store"`
+}
+
+// storeVersion is the schema version of the data store, for the stores with
+// a schema
+type storeVersion struct {
+	Backend             string `json:"backend"`
+	SchemaVersion       *int   `json:"schemaVersion,omitempty"`
+	LatestSchemaVersion *int   `json:"latestSchemaVersion,omitempty"`
+	Dirty               bool   `json:"dirty,omitempty"`
+}
+
+// getVersion returns the version of Caravan and of its store's schema
+func (c *CaravanConfig) getVersion(w http.ResponseWriter, r *http.Request) {
+	info := versionInfo{Version: buildString, Store: storeVersion{Backend: c.storeBackend}}
+
+	if m, ok := store.AsMigrator(c.dataStore); ok {
+		version, dirty, err := m.SchemaVersion(r.Context())
+		if err != nil {
+			logger.Log(logger.LevelWarn, nil, err, "reading the store schema version")
+		} else {
+			latest := m.LatestSchemaVersion()
+			info.Store.SchemaVersion = &version
+			info.Store.LatestSchemaVersion = &latest
+			info.Store.Dirty = dirty
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		logger.Log(logger.LevelError, nil, err, "encoding version")
+	}
+}
+
 // createCaravanHandler creates the main HTTP handler
 func createCaravanHandler(config *CaravanConfig) http.Handler {
-	config.StaticPluginDir = os.Getenv("CARAVAN_STATIC_PLUGINS_DIR")
+	// Live reload for plugin developers
+	var reloader *devReloader
+	if config.DevMode {
+		reloader = newDevReloader()
+	}
 
 	// Populate plugins cache
 	plugins.PopulatePluginsCache(config.StaticPluginDir, config.UserPluginDir, config.PluginDir, config.cache)
 
 	// Watch plugins for changes
 	if config.WatchPluginsChanges {
-		pluginEventChan := make(chan string)
-		go plugins.Watch(config.PluginDir, pluginEventChan)
-
-		if config.UserPluginDir != "" {
-			userPluginEventChan := make(chan string)
-			go plugins.Watch(config.UserPluginDir, userPluginEventChan)
-			go func() {
-				for event := range userPluginEventChan {
-					pluginEventChan <- event
-				}
-			}()
+		fsEvents := make(chan string)
+		for _, dir := range []string{config.PluginDir, config.UserPluginDir, config.StaticPluginDir} {
+			if dir != "" {
+				go plugins.Watch(dir, fsEvents)
+			}
 		}
 
-		go plugins.HandlePluginEvents(
-			config.StaticPluginDir,
-			config.UserPluginDir,
-			config.PluginDir,
-			pluginEventChan,
-			config.cache,
-		)
+		// Refresh the plugins once per burst of changes rather than per file
+		pluginEventChan := make(chan string)
+		go plugins.Debounce(fsEvents, pluginEventChan, plugins.WatchDebounce)
+
+		// Connected frontends reload the plugins that changed
+		go func() {
+			for range pluginEventChan {
+				pluginList := plugins.RefreshPluginsCache(
+					config.StaticPluginDir,
+					config.UserPluginDir,
+					config.PluginDir,
+					config.cache,
+				)
+				config.multiplexer.NotifyPluginsChanged(pluginList)
+				reloader.notify(reloadKindPlugins)
+			}
+		}()
 	}
 
 	if config.StaticDir != "" {
 		baseURLReplace(config.StaticDir, config.BaseURL)
+
+		// Watched only once the base URL is in, which rewrites its files
+		if reloader != nil && config.WatchPluginsChanges {
+			staticEvents := make(chan string)
+			go plugins.Watch(config.StaticDir, staticEvents)
+
+			staticChanges := make(chan string)
+			go plugins.Debounce(staticEvents, staticChanges, plugins.WatchDebounce)
+
+			go func() {
+				for range staticChanges {
+					reloader.notify(reloadKindStatic)
+				}
+			}()
+		}
 	}
 
 	// Setup router
@@ -373,6 +654,11 @@ func createCaravanHandler(config *CaravanConfig) http.Handler {
 
 	// Configuration endpoint
 	mux.HandleFunc("GET /config", config.getConfig)
+	mux.HandleFunc("GET /api/version", config.getVersion)
+
+	if reloader != nil {
+		mux.Handle("GET /api/dev/reload", reloader)
+	}
 
 	// Websocket multiplexer for event streaming
 	mux.HandleFunc("/wsMultiplexer", config.multiplexer.HandleClientWebSocket)
@@ -429,7 +715,16 @@ func createCaravanHandler(config *CaravanConfig) http.Handler {
 	})
 
 	// Apply request logging (verbose in dev mode) and CORS
-	return c.Handler(requestLogger(mux, config.DevMode))
+	// A dry run still reports a freeze that would have blocked the request
+	var handler http.Handler = config.nomadHandler.StreamRegistryMiddleware(mux)
+	handler = config.nomadHandler.DryRunMiddleware(handler)
+	handler = config.nomadHandler.FreezeMiddleware(handler)
+	handler = config.nomadHandler.NamespaceScopeMiddleware(handler)
+	handler = config.nomadHandler.KillSwitchMiddleware(handler)
+	handler = config.nomadHandler.TokenVaultMiddleware(handler)
+
+	// Client addresses and path prefixes of trusted proxies apply to everything
+	return config.TrustedProxies.Middleware(c.Handler(requestLogger(handler, config.DevMode, config.SlowRequestThreshold)))
 }
 
 // addClusterSetupRoute adds routes for dynamic cluster management under /api prefix
@@ -447,6 +742,8 @@ func (c *CaravanConfig) addClusterSetupRoute(mux *http.ServeMux) {
 				AuthType: ctx.AuthType(),
 				Metadata: ctx.Metadata,
 				Error:    ctx.Error,
+
+				Autoscaler: ctx.AutoscalerAddress != "",
 			})
 		}
 
@@ -468,9 +765,11 @@ func (c *CaravanConfig) addClusterSetupRoute(mux *http.ServeMux) {
 			Region    string `json:"region"`
 			Namespace string `json:"namespace"`
 			Token     string `json:"token"`
+			// AutoscalerAddress is the optional Nomad Autoscaler agent of the cluster
+			AutoscalerAddress string `json:"autoscalerAddress"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			apierror.Write(w, r, apierror.FromStatus(http.StatusBadRequest, err))
 			return
 		}
 
@@ -481,10 +780,18 @@ func (c *CaravanConfig) addClusterSetupRoute(mux *http.ServeMux) {
 			Namespace: req.Namespace,
 			Token:     req.Token,
 			Source:    nomadconfig.DynamicCluster,
+
+			AutoscalerAddress: req.AutoscalerAddress,
+		}
+
+		// Clusters without ACLs skip the login screen; an unreachable
+		// cluster is still added, with the login screen
+		if err := ctx.ProbeACL(r.Context()); err != nil {
+			logger.Log(logger.LevelWarn, map[string]string{"cluster": req.Name}, err, "probing ACLs")
 		}
 
 		if err := c.NomadConfigStore.AddContext(ctx); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			apierror.Write(w, r, apierror.FromStatus(http.StatusInternalServerError, err).WithCluster(req.Name))
 			return
 		}
 
@@ -503,7 +810,8 @@ func (c *CaravanConfig) addClusterSetupRoute(mux *http.ServeMux) {
 		clusterName := r.PathValue("clusterName")
 
 		if err := c.NomadConfigStore.RemoveContext(clusterName); err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			apierror.Write(w, r, apierror.New(http.StatusNotFound, apierror.CodeClusterNotFound, err.Error()).
+				WithCluster(clusterName))
 			return
 		}
 
@@ -516,9 +824,53 @@ func (c *CaravanConfig) addClusterSetupRoute(mux *http.ServeMux) {
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
 	})
+
+	// First-run setup wizard: validate and add the first cluster, then log in
+	mux.HandleFunc("GET /api/setup", c.nomadHandler.GetSetupStatus)
+	mux.HandleFunc("POST /api/setup/validate", c.nomadHandler.ValidateSetupCluster)
+	mux.HandleFunc("POST /api/setup/cluster", c.nomadHandler.AddSetupCluster)
+	mux.HandleFunc("PUT /api/setup/admin", c.nomadHandler.SaveSetupAdmin)
 }
 
 func main() {
+	// caravan migrate [flags] [action] manages the schema of the data store
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		conf, err := config.Parse(append([]string{os.Args[0]}, os.Args[2:]...))
+		if err != nil {
+			logger.Log(logger.LevelError, nil, err, "parsing configuration")
+			os.Exit(1)
+		}
+
+		if err := runMigrate(conf); errors.Is(err, errMigrateUsage) {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			os.Exit(2)
+		} else if err != nil {
+			logger.Log(logger.LevelError, nil, err, "migrating data store")
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	// caravan rotate-key [flags] re-encrypts the stored secrets under a new key
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		conf, err := config.Parse(append([]string{os.Args[0]}, os.Args[2:]...))
+		if err != nil {
+			logger.Log(logger.LevelError, nil, err, "parsing configuration")
+			os.Exit(1)
+		}
+
+		if err := runRotateKey(conf); errors.Is(err, errRotateKeyUsage) {
+			fmt.Fprintln(os.Stderr, rotateKeyUsage)
+			os.Exit(2)
+		} else if err != nil {
+			logger.Log(logger.LevelError, nil, err, "rotating the store encryption key")
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	// Parse configuration using the config package
 	conf, err := config.Parse(os.Args)
 	if err != nil {
@@ -526,34 +878,161 @@ func main() {
 		os.Exit(1)
 	}
 
+	if conf.Version {
+		fmt.Println(buildString)
+		return
+	}
+
 	// Initialize cache
 	cacheInstance := cache.New[interface{}]()
 
+	// Cookie attributes, for the deployment's TLS setup
+	auth.Cookies, err = auth.ParseCookieOptions(conf.CookieSecure, conf.CookieSameSite, conf.CookieDomain, conf.CookieTTL)
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "parsing cookie options")
+		os.Exit(1)
+	}
+
+	trustedProxies, err := forwarded.ParseProxies(conf.TrustedProxies)
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "parsing trusted proxies")
+		os.Exit(1)
+	}
+
+	// Limit concurrent requests to each cluster, before any client is created
+	nomadconfig.RequestLimit = conf.MaxUpstreamRequests
+
 	// Initialize Nomad config store
 	nomadConfigStore := nomadconfig.NewInMemoryContextStore()
 
-	// Initialize Nomad handler
-	nomadHandler := nomad.NewHandler(nomadConfigStore)
+	// Initialize the store for Caravan's own data
+	dataStore, err := openDataStore(conf)
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "opening data store")
+		os.Exit(1)
+	}
+	defer dataStore.Close()
+
+	if conf.Demo {
+		if err := startDemoCluster(context.Background(), nomadConfigStore); err != nil {
+			logger.Log(logger.LevelError, nil, err, "starting demo cluster")
+			os.Exit(1)
+		}
+	}
+
+	// Load change freeze windows
+	var freezeSchedule *freeze.Schedule
+	if conf.FreezeWindowsFile != "" {
+		freezeSchedule, err = freeze.Load(conf.FreezeWindowsFile)
+		if err != nil {
+			logger.Log(logger.LevelError, nil, err, "loading freeze windows")
+			os.Exit(1)
+		}
+	}
+
+	// Load exec restrictions
+	var execPolicy *execpolicy.Policy
+	if conf.ExecPolicyFile != "" {
+		execPolicy, err = execpolicy.Load(conf.ExecPolicyFile)
+		if err != nil {
+			logger.Log(logger.LevelError, nil, err, "loading exec policy")
+			os.Exit(1)
+		}
+	}
+
+	// Load job lint rules
+	var linter *lint.Linter
+	if conf.LintRulesFile != "" {
+		linter, err = lint.Load(conf.LintRulesFile)
+		if err != nil {
+			logger.Log(logger.LevelError, nil, err, "loading lint rules")
+			os.Exit(1)
+		}
+	}
+
+	// Load namespace scopes
+	var scopes *nsscope.Scopes
+	if conf.NamespaceScopesFile != "" {
+		scopes, err = nsscope.Load(conf.NamespaceScopesFile)
+		if err != nil {
+			logger.Log(logger.LevelError, nil, err, "loading namespace scopes")
+			os.Exit(1)
+		}
+	}
+
+	killSwitches, err := killswitch.Parse(conf.DisabledFeatures)
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "parsing disabled features")
+		os.Exit(1)
+	}
+
+	// Without a display time zone, times are shown in each browser's own
+	var displayLocation *time.Location
+	if conf.DisplayTimezone != "" {
+		displayLocation, err = time.LoadLocation(conf.DisplayTimezone)
+		if err != nil {
+			logger.Log(logger.LevelError, nil, err, "loading the display time zone")
+			os.Exit(1)
+		}
+	}
 
 	// Initialize multiplexer for WebSocket connections
 	multiplexer := NewMultiplexer(nomadConfigStore)
+	if conf.WSCompression {
+		multiplexer.EnableCompression()
+	}
+	multiplexer.SetWriteTimeout(conf.StreamWriteTimeout)
+
+	// Initialize Nomad handler
+	nomadHandler := nomad.NewHandler(nomadConfigStore,
+		nomad.WithWSCompression(conf.WSCompression),
+		nomad.WithStore(dataStore),
+		nomad.WithFreezeSchedule(freezeSchedule),
+		nomad.WithApprovals(conf.RequireApprovals),
+		nomad.WithDryRun(conf.DryRun),
+		nomad.WithKillSwitches(killSwitches),
+		nomad.WithResponseCache(conf.ResponseCacheTTL),
+		nomad.WithExecPolicy(execPolicy),
+		nomad.WithFileTransferLimit(conf.FileTransferMaxBytes),
+		nomad.WithStreamWriteTimeout(conf.StreamWriteTimeout),
+		nomad.WithLinter(linter),
+		nomad.WithNamespaceScopes(scopes),
+		nomad.WithAnnouncementListener(multiplexer.NotifyAnnouncements),
+		nomad.WithDisplayTimezone(displayLocation),
+		nomad.WithListLimits(nomad.ListLimits{DefaultPageSize: conf.DefaultPageSize, MaxListSize: conf.MaxListSize}),
+	)
+
+	multiplexer.TrackStreams(nomadHandler.Streams())
+	multiplexer.ScopeNamespaces(nomadHandler.NamespaceScope)
+
+	go nomadHandler.TrackDeployments(context.Background(), conf.DeploymentHistoryInterval)
+	go nomadHandler.WatchDeployments(context.Background(), conf.DeploymentWatchInterval)
+	go nomadHandler.TrackJobHistory(context.Background(), conf.JobHistoryRetention)
+	go nomadHandler.TrackEvaluations(context.Background())
+	go nomadHandler.ExportClusterMetrics(context.Background(), conf.ClusterMetricsInterval)
 
 	caravanConfig := &CaravanConfig{
-		ListenAddr:          conf.ListenAddr,
-		DevMode:             conf.DevMode,
-		WatchPluginsChanges: conf.WatchPluginsChanges,
-		Port:                conf.Port,
-		StaticDir:           conf.StaticDir,
-		PluginDir:           conf.PluginsDir,
-		UserPluginDir:       conf.UserPluginsDir,
-		BaseURL:             conf.BaseURL,
-		ProxyURLs:           strings.Split(conf.ProxyURLs, ","),
-		TLSCertPath:         conf.TLSCertPath,
-		TLSKeyPath:          conf.TLSKeyPath,
-		NomadConfigStore:    nomadConfigStore,
-		cache:               cacheInstance,
-		multiplexer:         multiplexer,
-		nomadHandler:        nomadHandler,
+		ListenAddr:           conf.ListenAddr,
+		DevMode:              conf.DevMode,
+		WatchPluginsChanges:  conf.WatchPluginsChanges,
+		Port:                 conf.Port,
+		StaticDir:            conf.StaticDir,
+		PluginDir:            conf.PluginsDir,
+		StaticPluginDir:      conf.StaticPluginsDir,
+		UserPluginDir:        conf.UserPluginsDir,
+		BaseURL:              conf.BaseURL,
+		ProxyURLs:            strings.Split(conf.ProxyURLs, ","),
+		TLSCertPath:          conf.TLSCertPath,
+		TLSKeyPath:           conf.TLSKeyPath,
+		TrustedProxies:       trustedProxies,
+		EnableGraphQL:        conf.EnableGraphQL,
+		SlowRequestThreshold: conf.SlowRequestThreshold,
+		NomadConfigStore:     nomadConfigStore,
+		cache:                cacheInstance,
+		multiplexer:          multiplexer,
+		nomadHandler:         nomadHandler,
+		dataStore:            dataStore,
+		storeBackend:         store.DefaultBackend(conf.Store, conf.DataDir),
 	}
 
 	handler := createCaravanHandler(caravanConfig)
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/cache"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomad"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadtest"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden responses of caravan_testdata/golden")
+
+const goldenDir = "caravan_testdata/golden"
+
+// goldenCase is a request to the full Caravan handler whose response is
+// compared with caravan_testdata/golden/{name}.json. Cases run in order
+// against the same cluster, so the ones changing it come after the reads.
+type goldenCase struct {
+	name   string
+	method string
+	// path may use the IDs of the fixture, such as {alloc}, and the ones
+	// captured by earlier cases
+	path string
+	body string
+	// capture names the "id" of the response, for the paths of later cases
+	capture string
+	// statusOnly skips comparing bodies that change between runs
+	statusOnly bool
+}
+
+// scaledWebJob is the fixture's web job with one more allocation
+var scaledWebJob = func() string {
+	job, err := json.Marshal(nomadtest.ServiceJob("web", 3))
+	if err != nil {
+		panic(err)
+	}
+
+	return string(job)
+}()
+
+var goldenCases = []goldenCase{
+	{name: "health", method: "GET", path: "/health"},
+	{name: "version", method: "GET", path: "/api/version"},
+	{name: "config", method: "GET", path: "/config"},
+	{name: "metrics", method: "GET", path: "/metrics", statusOnly: true},
+	{name: "plugins", method: "GET", path: "/plugins"},
+	{name: "clusters", method: "GET", path: "/api/clusters"},
+	{name: "setup", method: "GET", path: "/api/setup"},
+
+	// Clusters
+	{name: "cluster-health", method: "GET", path: "/api/clusters/test/health"},
+	{name: "cluster-devices", method: "GET", path: "/api/clusters/test/devices"},
+	{name: "cluster-graph", method: "GET", path: "/api/clusters/test/graph"},
+	{name: "auth-check", method: "GET", path: "/api/clusters/test/v1/auth/check"},
+
+	// Jobs
+	{name: "jobs", method: "GET", path: "/api/clusters/test/v1/jobs"},
+	{name: "jobs-evaluation-churn", method: "GET", path: "/api/clusters/test/v1/jobs/evaluation-churn"},
+	{name: "job", method: "GET", path: "/api/clusters/test/v1/job?id=web"},
+	{name: "job-missing", method: "GET", path: "/api/clusters/test/v1/job?id=missing"},
+	{name: "job-no-id", method: "GET", path: "/api/clusters/test/v1/job"},
+	{name: "job-detail", method: "GET", path: "/api/clusters/test/v1/job/detail?id=web"},
+	{name: "job-allocations", method: "GET", path: "/api/clusters/test/v1/job/allocations?id=web"},
+	{name: "job-versions", method: "GET", path: "/api/clusters/test/v1/job/versions?id=web"},
+	{name: "job-diff", method: "GET", path: "/api/clusters/test/v1/job/diff?id=web&from=0&to=0"},
+	{name: "job-children", method: "GET", path: "/api/clusters/test/v1/job/children?id=report"},
+	{name: "job-dispatches", method: "GET", path: "/api/clusters/test/v1/job/dispatches?id=report"},
+	{name: "job-coverage", method: "GET", path: "/api/clusters/test/v1/job/coverage?id=web"},
+	{name: "job-evaluations", method: "GET", path: "/api/clusters/test/v1/job/evaluations?id=web"},
+	{name: "job-deployments", method: "GET", path: "/api/clusters/test/v1/job/deployments?id=web"},
+	{name: "job-deployment", method: "GET", path: "/api/clusters/test/v1/job/deployment?id=web"},
+	{name: "job-deployment-history", method: "GET", path: "/api/clusters/test/v1/job/deployment-history?id=web"},
+	{name: "job-history", method: "GET", path: "/api/clusters/test/v1/job/history?id=web"},
+	{name: "job-deployment-watch", method: "GET", path: "/api/clusters/test/v1/job/deployment-watch?id=web"},
+	{name: "job-scale-status", method: "GET", path: "/api/clusters/test/v1/job/scale-status?id=web"},
+	{name: "job-actions", method: "GET", path: "/api/clusters/test/v1/job/actions?id=web"},
+	{name: "job-plan", method: "POST", path: "/api/clusters/test/v1/job/plan?diff=true", body: scaledWebJob},
+	{name: "job-preflight", method: "POST", path: "/api/clusters/test/v1/job/preflight", body: `{"job": ` + scaledWebJob + `}`},
+
+	// Allocations
+	{name: "allocations", method: "GET", path: "/api/clusters/test/v1/allocations"},
+	{name: "allocation", method: "GET", path: "/api/clusters/test/v1/allocation/{alloc}"},
+	{name: "allocation-missing", method: "GET", path: "/api/clusters/test/v1/allocation/missing"},
+	{name: "allocation-stats", method: "GET", path: "/api/clusters/test/v1/allocation/{alloc}/stats", statusOnly: true},
+	{name: "allocation-fs", method: "GET", path: "/api/clusters/test/v1/allocation/{alloc}/fs?path=/"},
+	{name: "allocation-file", method: "GET", path: "/api/clusters/test/v1/allocation/{alloc}/file?path=/missing"},
+
+	// Nodes
+	{name: "nodes", method: "GET", path: "/api/clusters/test/v1/nodes"},
+	{name: "node", method: "GET", path: "/api/clusters/test/v1/node/{node}"},
+	{name: "node-detail", method: "GET", path: "/api/clusters/test/v1/node/{node}/detail", statusOnly: true},
+	{name: "node-allocations", method: "GET", path: "/api/clusters/test/v1/node/{node}/allocations"},
+	{name: "node-networks", method: "GET", path: "/api/clusters/test/v1/node/{node}/networks"},
+
+	// Namespaces and variables
+	{name: "namespaces", method: "GET", path: "/api/clusters/test/v1/namespaces"},
+	{name: "namespace", method: "GET", path: "/api/clusters/test/v1/namespace/default"},
+	{name: "vars", method: "GET", path: "/api/clusters/test/v1/vars"},
+	{name: "var-missing", method: "GET", path: "/api/clusters/test/v1/var?path=missing"},
+
+	// ACLs
+	{name: "acl-tokens", method: "GET", path: "/api/clusters/test/v1/acl/tokens"},
+	{name: "acl-token-self", method: "GET", path: "/api/clusters/test/v1/acl/token/self"},
+	{name: "acl-token", method: "GET", path: "/api/clusters/test/v1/acl/token/{accessor}"},
+	{name: "acl-token-activity", method: "GET", path: "/api/clusters/test/v1/acl/token/{accessor}/activity"},
+	{name: "acl-policies", method: "GET", path: "/api/clusters/test/v1/acl/policies"},
+	{name: "acl-policy", method: "GET", path: "/api/clusters/test/v1/acl/policy/missing"},
+	{name: "acl-impersonation", method: "GET", path: "/api/clusters/test/v1/acl/impersonation"},
+	{name: "acl-auth-methods", method: "GET", path: "/api/clusters/test/v1/acl/auth-methods"},
+
+	// Evaluations and deployments
+	{name: "evaluations", method: "GET", path: "/api/clusters/test/v1/evaluations"},
+	{name: "evaluation", method: "GET", path: "/api/clusters/test/v1/evaluation/{eval}"},
+	{name: "evaluation-allocations", method: "GET", path: "/api/clusters/test/v1/evaluation/{eval}/allocations"},
+	{name: "deployments", method: "GET", path: "/api/clusters/test/v1/deployments"},
+	{name: "deployment", method: "GET", path: "/api/clusters/test/v1/deployment/{deployment}"},
+	{name: "deployment-allocations", method: "GET", path: "/api/clusters/test/v1/deployment/{deployment}/allocations"},
+	{name: "deployment-canary-compare", method: "GET", path: "/api/clusters/test/v1/deployment/{deployment}/canary-compare",
+		statusOnly: true},
+
+	// Services, autoscaler and events
+	{name: "services", method: "GET", path: "/api/clusters/test/v1/services"},
+	{name: "service", method: "GET", path: "/api/clusters/test/v1/service/missing"},
+	{name: "autoscaler-health", method: "GET", path: "/api/clusters/test/autoscaler/health"},
+	{name: "autoscaler-metrics", method: "GET", path: "/api/clusters/test/autoscaler/metrics"},
+	{name: "autoscaler-policies", method: "GET", path: "/api/clusters/test/autoscaler/policies"},
+	{name: "autoscaler-policy", method: "GET", path: "/api/clusters/test/autoscaler/policy/missing"},
+	{name: "event-forward", method: "GET", path: "/api/clusters/test/v1/event/forward"},
+	{name: "constraint-check", method: "POST", path: "/api/clusters/test/v1/utils/constraint-check",
+		body: `{"constraints": [{"LTarget": "${attr.kernel.name}", "RTarget": "linux", "Operand": "="}]}`},
+
+	// Utilities
+	{name: "hcl-format", method: "POST", path: "/api/utils/hcl/format", body: `{"hcl": "job \"web\" {\ntype=\"service\"\n}"}`},
+	{name: "hcl-validate", method: "POST", path: "/api/utils/hcl/validate", body: `{"hcl": "job \"web\" {"}`},
+	{name: "job-to-hcl", method: "POST", path: "/api/utils/job/to-hcl", body: `{"ID": "web", "Name": "web", "Type": "service"}`},
+	{name: "job-lint", method: "POST", path: "/api/utils/job/lint", body: `{"job": ` + scaledWebJob + `}`},
+	{name: "job-lint-rules", method: "GET", path: "/api/utils/job/lint/rules"},
+	{name: "cron-next", method: "GET", path: "/api/utils/cron/next?expr=0+0+1+1+*&tz=UTC&count=2", statusOnly: true},
+	{name: "graphql", method: "POST", path: "/api/graphql", body: `{"query": "{ clusters { name } }"}`},
+	{name: "graphql-get", method: "GET", path: "/api/graphql?query=%7B+clusters+%7B+name+%7D+%7D"},
+
+	// Caravan's own data
+	{name: "tokens", method: "GET", path: "/api/tokens"},
+	{name: "sso-methods", method: "GET", path: "/api/sso/methods"},
+	{name: "views", method: "GET", path: "/api/views"},
+	{name: "view-create", method: "POST", path: "/api/views", capture: "view",
+		body: `{"name": "Failing", "cluster": "test", "page": "/jobs", "filters": {"status": "dead"}}`},
+	{name: "view", method: "GET", path: "/api/views/{view}"},
+	{name: "view-update", method: "PUT", path: "/api/views/{view}",
+		body: `{"name": "Dead jobs", "cluster": "test", "page": "/jobs", "filters": {"status": "dead"}}`},
+	{name: "view-delete", method: "DELETE", path: "/api/views/{view}"},
+	{name: "ownership", method: "GET", path: "/api/ownership"},
+	{name: "ownership-create", method: "POST", path: "/api/ownership", capture: "ownership",
+		body: `{"cluster": "test", "metaKey": "team"}`},
+	{name: "ownership-update", method: "PUT", path: "/api/ownership/{ownership}", body: `{"cluster": "test", "metaKey": "owner"}`},
+	{name: "ownership-delete", method: "DELETE", path: "/api/ownership/{ownership}"},
+	{name: "runbooks", method: "GET", path: "/api/runbooks"},
+	{name: "runbook-create", method: "POST", path: "/api/runbooks", capture: "runbook",
+		body: `{"name": "OOM", "pattern": "OOM killed", "hint": "Raise the memory"}`},
+	{name: "runbook-update", method: "PUT", path: "/api/runbooks/{runbook}",
+		body: `{"name": "OOM", "pattern": "OOM", "hint": "Raise the memory"}`},
+	{name: "runbook-delete", method: "DELETE", path: "/api/runbooks/{runbook}"},
+	{name: "announcements", method: "GET", path: "/api/announcements"},
+	{name: "announcement-create", method: "POST", path: "/api/announcements", capture: "announcement",
+		body: `{"message": "Maintenance at 10:00"}`},
+	{name: "announcement-update", method: "PUT", path: "/api/announcements/{announcement}",
+		body: `{"message": "Maintenance at 11:00", "severity": "warning"}`},
+	{name: "announcement-delete", method: "DELETE", path: "/api/announcements/{announcement}"},
+	{name: "share-link-create", method: "POST", path: "/api/share-links", statusOnly: true,
+		body: `{"cluster": "test", "path": "/jobs"}`},
+	{name: "share-link-invalid", method: "GET", path: "/api/share-links/invalid"},
+	{name: "approvals", method: "GET", path: "/api/approvals"},
+	{name: "approval-missing", method: "GET", path: "/api/approvals/missing"},
+	{name: "approval-approve-missing", method: "POST", path: "/api/approvals/missing/approve"},
+	{name: "approval-reject-missing", method: "POST", path: "/api/approvals/missing/reject"},
+	{name: "approval-cancel-missing", method: "DELETE", path: "/api/approvals/missing"},
+
+	// Administration
+	{name: "admin-streams", method: "GET", path: "/api/admin/streams"},
+	{name: "admin-stream-close-missing", method: "DELETE", path: "/api/admin/streams/missing"},
+	{name: "admin-cache", method: "GET", path: "/api/admin/cache"},
+	{name: "admin-cache-purge", method: "DELETE", path: "/api/admin/cache"},
+	{name: "admin-clients", method: "GET", path: "/api/admin/clients?cluster=test", statusOnly: true},
+	{name: "admin-clients-purge", method: "DELETE", path: "/api/admin/clients"},
+	{name: "admin-kill-switches", method: "GET", path: "/api/admin/kill-switches"},
+	{name: "admin-kill-switch-toggle", method: "PUT", path: "/api/admin/kill-switches/logs", statusOnly: true,
+		body: `{"disabled": false}`},
+	{name: "admin-login", method: "POST", path: "/api/admin/login", body: `{"password": "no password is set"}`},
+	{name: "admin-logout", method: "POST", path: "/api/admin/logout"},
+
+	// Changes to the cluster, once everything was read
+	{name: "job-annotate", method: "PUT", path: "/api/clusters/test/v1/job/version/annotation?id=web&version=0",
+		body: `{"description": "First release"}`},
+	{name: "job-deployment-watch-set", method: "PUT", path: "/api/clusters/test/v1/job/deployment-watch?id=web",
+		body: `{"notify": {"type": "webhook", "url": "http://127.0.0.1:1/hook"}}`},
+	{name: "job-deployment-watch-delete", method: "DELETE", path: "/api/clusters/test/v1/job/deployment-watch?id=web"},
+	{name: "job-scale", method: "POST", path: "/api/clusters/test/v1/job/scale?id=web", statusOnly: true,
+		body: `{"target": {"group": "web"}, "count": 3}`},
+	{name: "job-evaluate", method: "POST", path: "/api/clusters/test/v1/job/evaluate?id=web", statusOnly: true},
+	{name: "job-dispatch", method: "POST", path: "/api/clusters/test/v1/job/dispatch?id=web", body: `{}`},
+	{name: "job-update-no-id", method: "POST", path: "/api/clusters/test/v1/job", body: `{}`},
+	{name: "job-run-hcl-invalid", method: "POST", path: "/api/clusters/test/v1/job/run-hcl", body: `{"hcl": "job {"}`},
+	{name: "allocation-restart", method: "POST", path: "/api/clusters/test/v1/allocation/{alloc}/restart", body: `{}`},
+	{name: "allocation-stop", method: "POST", path: "/api/clusters/test/v1/allocation/{alloc}/stop", statusOnly: true},
+	{name: "node-eligibility", method: "POST", path: "/api/clusters/test/v1/node/{node}/eligibility",
+		statusOnly: true, body: `{"eligible": true}`},
+	{name: "node-drain-invalid", method: "POST", path: "/api/clusters/test/v1/node/{node}/drain", body: `{`},
+	{name: "node-purge-missing", method: "POST", path: "/api/clusters/test/v1/node/missing/purge"},
+	{name: "var-put", method: "PUT", path: "/api/clusters/test/v1/var?path=app/config", statusOnly: true,
+		body: `{"Path": "app/config", "Items": {"level": "debug"}}`},
+	{name: "var", method: "GET", path: "/api/clusters/test/v1/var?path=app/config", statusOnly: true},
+	{name: "var-delete", method: "DELETE", path: "/api/clusters/test/v1/var?path=app/config"},
+	{name: "deployment-promote", method: "POST", path: "/api/clusters/test/v1/deployment/{deployment}/promote", statusOnly: true},
+	{name: "deployment-pause", method: "POST", path: "/api/clusters/test/v1/deployment/{deployment}/pause", statusOnly: true,
+		body: `{"pause": true}`},
+	{name: "deployment-fail", method: "POST", path: "/api/clusters/test/v1/deployment/{deployment}/fail", statusOnly: true},
+	{name: "event-forward-set-invalid", method: "PUT", path: "/api/clusters/test/v1/event/forward", body: `{`},
+	{name: "event-forward-delete", method: "DELETE", path: "/api/clusters/test/v1/event/forward?id=missing"},
+	{name: "system-gc", method: "PUT", path: "/api/clusters/test/v1/system/gc"},
+	{name: "system-reconcile-summaries", method: "PUT", path: "/api/clusters/test/v1/system/reconcile/summaries"},
+	{name: "jobs-stop-invalid", method: "POST", path: "/api/clusters/test/v1/jobs/stop", body: `{"jobs": [{"namespace": "default"}]}`},
+	{name: "job-delete", method: "DELETE", path: "/api/clusters/test/v1/job?id=report", statusOnly: true},
+
+	// Authentication
+	{name: "auth-login-invalid", method: "POST", path: "/api/clusters/test/v1/auth/login", body: `{`},
+	{name: "auth-logout", method: "POST", path: "/api/clusters/test/v1/auth/logout"},
+	{name: "auth-onetime-exchange-invalid", method: "POST", path: "/api/clusters/test/v1/auth/onetime/exchange", body: `{`},
+	{name: "acl-token-onetime", method: "POST", path: "/api/clusters/test/v1/acl/token/onetime"},
+	{name: "acl-token-delete", method: "DELETE", path: "/api/clusters/test/v1/acl/token/missing"},
+	{name: "acl-policy-delete", method: "DELETE", path: "/api/clusters/test/v1/acl/policy/missing"},
+	{name: "acl-bootstrap-unconfirmed", method: "POST", path: "/api/clusters/test/v1/acl/bootstrap", statusOnly: true,
+		body: `{}`},
+	{name: "acl-impersonation-start-invalid", method: "POST", path: "/api/clusters/test/v1/acl/impersonation", body: `{`},
+	{name: "acl-impersonation-stop", method: "DELETE", path: "/api/clusters/test/v1/acl/impersonation"},
+	{name: "acl-oidc-auth-url-invalid", method: "POST", path: "/api/clusters/test/v1/acl/oidc/auth-url", body: `{`},
+	{name: "acl-oidc-complete-auth-invalid", method: "POST", path: "/api/clusters/test/v1/acl/oidc/complete-auth", body: `{`},
+	{name: "sso-oidc-start-invalid", method: "POST", path: "/api/sso/oidc/start", body: `{`},
+	{name: "sso-oidc-complete-invalid", method: "POST", path: "/api/sso/oidc/complete", body: `{`},
+	{name: "sso-jwt-invalid", method: "POST", path: "/api/sso/jwt", body: `{`},
+	{name: "token-register-invalid", method: "PUT", path: "/api/tokens", body: `{`},
+	{name: "token-forget", method: "DELETE", path: "/api/tokens/test"},
+	{name: "tokens-forget", method: "DELETE", path: "/api/tokens"},
+	{name: "audit", method: "GET", path: "/api/audit", statusOnly: true},
+
+	// Cluster setup, last as it changes the clusters
+	{name: "setup-validate", method: "POST", path: "/api/setup/validate", body: `{"address": "{nomad}"}`},
+	{name: "setup-cluster", method: "POST", path: "/api/setup/cluster", body: `{"name": "dev", "address": "{nomad}"}`},
+	{name: "setup-admin-short", method: "PUT", path: "/api/setup/admin", body: `{"password": "short"}`},
+	{name: "cluster-add", method: "POST", path: "/api/cluster", body: `{"name": "staging", "server": "{nomad}"}`},
+	{name: "cluster-delete", method: "DELETE", path: "/api/cluster/staging"},
+}
+
+// untestedRoutes are the routes without a golden case, with the reason
+var untestedRoutes = map[string]string{
+	"/plugins/":           "serves the files of the plugin directory",
+	"/user-plugins/":      "serves the files of the plugin directory",
+	"/static-plugins/":    "serves the files of the plugin directory",
+	"/{path...}":          "serves the frontend build",
+	"GET /api/dev/reload": "only served in dev mode",
+	"/wsMultiplexer":      "WebSocket",
+
+	"GET /api/clusters/{cluster}/v1/job/events":                           "event stream",
+	"GET /api/clusters/{cluster}/v1/job/action":                           "WebSocket",
+	"GET /api/clusters/{cluster}/v1/allocation/{allocID}/logs/{task}":     "log stream",
+	"GET /api/clusters/{cluster}/v1/allocation/{allocID}/file/stream":     "file stream",
+	"GET /api/clusters/{cluster}/v1/allocation/{allocID}/connect":         "WebSocket",
+	"GET /api/clusters/{cluster}/v1/allocation/{allocID}/exec/{task}":     "WebSocket",
+	"POST /api/clusters/{cluster}/v1/allocation/{allocID}/upload/{task}":  "runs an exec session",
+	"GET /api/clusters/{cluster}/v1/allocation/{allocID}/download/{task}": "runs an exec session",
+	"GET /api/clusters/{cluster}/v1/deployment/{deployID}/watch":          "event stream",
+	"GET /api/clusters/{cluster}/v1/event/stream":                         "event stream",
+	"GET /api/clusters/{cluster}/v1/watch":                                "event stream",
+}
+
+// goldenFixture is Caravan serving a fake cluster named test, running a
+// service job web and a batch job report, with ACLs enabled
+type goldenFixture struct {
+	srv *httptest.Server
+	// token is the secret of the management token requests are sent with
+	token string
+	// ids are the values of the placeholders of paths and bodies
+	ids map[string]string
+}
+
+func newGoldenFixture(t *testing.T) *goldenFixture {
+	t.Helper()
+
+	nomadSrv := nomadtest.NewServer(t)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 2))
+	nomadSrv.RunJob(t, nomadtest.BatchJob("report"))
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+
+	configStore := nomadSrv.ContextStore("test")
+	dataStore := store.NewMemory()
+	nomadHandler := nomad.NewHandler(configStore, nomad.WithStore(dataStore))
+	t.Cleanup(func() { nomadHandler.InvalidateClient("test") })
+
+	srv := httptest.NewServer(createCaravanHandler(&CaravanConfig{
+		EnableGraphQL:    true,
+		NomadConfigStore: configStore,
+		cache:            cache.New[interface{}](),
+		multiplexer:      NewMultiplexer(configStore),
+		nomadHandler:     nomadHandler,
+		dataStore:        dataStore,
+		storeBackend:     "memory",
+	}))
+	t.Cleanup(srv.Close)
+
+	client := nomadSrv.Client(t, admin.SecretID)
+	evals, _, err := client.Jobs().Evaluations("web", nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, evals)
+	deployment, _, err := client.Jobs().LatestDeployment("web", nil)
+	require.NoError(t, err)
+	require.NotNil(t, deployment)
+
+	return &goldenFixture{srv: srv, token: admin.SecretID, ids: map[string]string{
+		"nomad":      nomadSrv.URL,
+		"accessor":   admin.AccessorID,
+		"secret":     admin.SecretID,
+		"alloc":      allocs[0].ID,
+		"node":       allocs[0].NodeID,
+		"eval":       evals[0].ID,
+		"deployment": deployment.ID,
+	}}
+}
+
+// expand fills in the placeholders of s
+func (f *goldenFixture) expand(s string) string {
+	for name, id := range f.ids {
+		s = strings.ReplaceAll(s, "{"+name+"}", id)
+	}
+
+	return s
+}
+
+var (
+	uuidPattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	timePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}`)
+	// timeKeys hold times, in nanoseconds or seconds, that change between runs
+	timeKeys = regexp.MustCompile(`(Time|At|Timestamp|Seconds)$|^(time|createdAt|expiresAt|startedAt|ageSeconds)$`)
+)
+
+// normalize replaces what changes between runs in a JSON response, such as
+// IDs and times, with placeholders, and indents it
+func (f *goldenFixture) normalize(t *testing.T, body []byte) json.RawMessage {
+	t.Helper()
+
+	// The fixture's IDs keep their name, so that references can be checked
+	names := make([]string, 0, len(f.ids))
+	for name := range f.ids {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if f.ids[name] == "" {
+			continue
+		}
+		body = bytes.ReplaceAll(body, []byte(f.ids[name]), []byte("<"+name+">"))
+	}
+	body = bytes.ReplaceAll(body, []byte(strings.TrimPrefix(f.srv.URL, "http://")), []byte("<caravan>"))
+
+	var v interface{}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return json.RawMessage("null")
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		// Not JSON, such as plain text errors
+		v = string(body)
+	}
+
+	return indentJSON(t, normalizeValue("", v))
+}
+
+// indentJSON encodes v indented, leaving placeholders such as <alloc> as is
+func indentJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	require.NoError(t, encoder.Encode(v))
+
+	return buf.Bytes()
+}
+
+func normalizeValue(key string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			v[k] = normalizeValue(k, value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = normalizeValue(key, value)
+		}
+	case string:
+		if timePattern.MatchString(v) {
+			return "<time>"
+		}
+		return uuidPattern.ReplaceAllString(v, "<uuid>")
+	case float64:
+		if v != 0 && timeKeys.MatchString(key) {
+			return "<time>"
+		}
+	}
+
+	return v
+}
+
+// goldenResponse is the content of a golden file
+type goldenResponse struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// TestGoldenResponses runs the golden cases against the full handler. Run
+// it with -update to rewrite the golden files after intended changes.
+func TestGoldenResponses(t *testing.T) {
+	f := newGoldenFixture(t)
+
+	if *updateGolden {
+		require.NoError(t, os.RemoveAll(goldenDir))
+		require.NoError(t, os.MkdirAll(goldenDir, 0o755))
+	}
+
+	for _, c := range goldenCases {
+		req, err := http.NewRequest(c.method, f.srv.URL+f.expand(c.path), strings.NewReader(f.expand(c.body)))
+		require.NoError(t, err)
+		req.Header.Set("X-Nomad-Token", f.token)
+		if c.body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err, c.name)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err, c.name)
+
+		if c.capture != "" {
+			var created struct{ ID string }
+			require.NoError(t, json.Unmarshal(body, &created), c.name)
+			require.NotEmpty(t, created.ID, "%s: %s", c.name, body)
+			f.ids[c.capture] = created.ID
+		}
+
+		got := goldenResponse{Status: resp.StatusCode}
+		if !c.statusOnly {
+			got.Body = f.normalize(t, body)
+		}
+
+		gotJSON := indentJSON(t, got)
+
+		path := filepath.Join(goldenDir, c.name+".json")
+		if *updateGolden {
+			require.NoError(t, os.WriteFile(path, gotJSON, 0o644))
+			continue
+		}
+
+		want, err := os.ReadFile(path)
+		require.NoError(t, err, "%s has no golden file, run the test with -update", c.name)
+		assert.JSONEq(t, string(want), string(gotJSON), "%s %s", c.method, c.path)
+	}
+}
+
+// TestGoldenCoverage checks that every route of caravan.go has a golden
+// case, or a reason not to
+func TestGoldenCoverage(t *testing.T) {
+	source, err := os.ReadFile("caravan.go")
+	require.NoError(t, err)
+
+	routes := make(map[string]bool)
+	mux := http.NewServeMux()
+	for _, m := range regexp.MustCompile(`mux\.Handle(?:Func)?\("([^"]+)"`).FindAllSubmatch(source, -1) {
+		pattern := string(m[1])
+		if _, ok := routes[pattern]; !ok {
+			routes[pattern] = false
+			mux.HandleFunc(pattern, func(http.ResponseWriter, *http.Request) {})
+		}
+	}
+	require.NotEmpty(t, routes)
+
+	ids := &goldenFixture{ids: map[string]string{
+		"nomad": "http://127.0.0.1:4646", "accessor": "t", "secret": "s", "alloc": "a", "node": "n", "eval": "e", "deployment": "d",
+		"view": "v", "ownership": "o", "runbook": "r", "announcement": "m",
+	}}
+	for _, c := range goldenCases {
+		req := httptest.NewRequest(c.method, ids.expand(c.path), nil)
+		_, pattern := mux.Handler(req)
+		if _, ok := routes[pattern]; assert.True(t, ok, "%s %s matches no route", c.method, c.path) {
+			routes[pattern] = true
+		}
+	}
+
+	for pattern, covered := range routes {
+		_, untested := untestedRoutes[pattern]
+		assert.True(t, covered || untested, "route %q has no golden case", pattern)
+		assert.False(t, covered && untested, "route %q has a golden case but is listed as untested", pattern)
+	}
+	for pattern := range untestedRoutes {
+		_, ok := routes[pattern]
+		assert.True(t, ok, "untested route %q is not in caravan.go", pattern)
+	}
+}
@@ -8,6 +8,8 @@ type Cluster struct {
 	AuthType string                 `json:"auth_type"`
 	Metadata map[string]interface{} `json:"meta_data"`
 	Error    string                 `json:"error,omitempty"`
+	// Autoscaler is true when a Nomad Autoscaler agent is configured
+	Autoscaler bool `json:"autoscaler"`
 }
 
 // ClusterReq represents a request to add a new Nomad cluster
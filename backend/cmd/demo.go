@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadfake"
+)
+
+const (
+	// demoClusterName is the name of the cluster --demo adds
+	demoClusterName = "demo"
+	// demoActivityInterval is how often the demo cluster generates logs and events
+	demoActivityInterval = 2 * time.Second
+)
+
+// startDemoCluster serves a simulated Nomad cluster on a loopback port and
+// adds it as a context, so it goes through the same clients, proxy and
+// WebSocket paths as a real cluster.
+func startDemoCluster(ctx context.Context, store nomadconfig.ContextStore) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	demo := nomadfake.NewDemo()
+
+	go func() {
+		if err := http.Serve(listener, demo.Handler()); err != nil {
+			logger.Log(logger.LevelError, nil, err, "serving demo cluster")
+		}
+	}()
+
+	go demo.Simulate(ctx, demoActivityInterval)
+
+	address := "http://" + listener.Addr().String()
+	logger.Log(logger.LevelInfo, map[string]string{"cluster": demoClusterName, "address": address},
+		nil, "Demo cluster started")
+
+	nomadCtx := &nomadconfig.Context{
+		Name:    demoClusterName,
+		Address: address,
+		Region:  "global",
+		Source:  nomadconfig.Demo,
+	}
+	if err := nomadCtx.ProbeACL(ctx); err != nil {
+		return err
+	}
+
+	return store.AddContext(nomadCtx)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+)
+
+const (
+	// reloadKindPlugins is sent when a plugin changed on disk
+	reloadKindPlugins = "plugins"
+	// reloadKindStatic is sent when a file of the static frontend changed
+	reloadKindStatic = "static"
+)
+
+// devReloadEvent tells a browser what to reload
+type devReloadEvent struct {
+	Kind string    `json:"kind"`
+	Time time.Time `json:"time"`
+}
+
+// devReloader fans change notifications out to the browsers listening on
+// /api/dev/reload, so plugin developers see their changes without
+// refreshing. A nil devReloader ignores notifications.
+type devReloader struct {
+	mu        sync.Mutex
+	listeners map[chan devReloadEvent]struct{}
+}
+
+func newDevReloader() *devReloader {
+	return &devReloader{listeners: make(map[chan devReloadEvent]struct{})}
+}
+
+// notify sends an event to every listener. A listener that has not taken
+// its previous event yet misses this one; it reloads anyway.
+func (d *devReloader) notify(kind string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	event := devReloadEvent{Kind: kind, Time: time.Now().UTC()}
+	for ch := range d.listeners {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// ServeHTTP handles GET /api/dev/reload, streaming a "reload" server-sent
+// event for every change until the browser goes away
+func (d *devReloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan devReloadEvent, 1)
+
+	d.mu.Lock()
+	d.listeners[ch] = struct{}{}
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		delete(d.listeners, ch)
+		d.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event := <-ch:
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.Log(logger.LevelError, nil, err, "marshaling reload event")
+				continue
+			}
+
+			fmt.Fprintf(w, "event: reload\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			// Keeps proxies from closing the idle stream
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/config"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+const migrateUsage = `usage: caravan migrate [flags] [version | up | goto <version> | force <version>]
+
+  version           print the schema version of the store (default)
+  up                migrate to the latest version this build knows
+  goto <version>    migrate up or down to version, such as before a downgrade
+  force <version>   record the schema at version without migrating, once a
+                    failed migration was fixed by hand
+
+The store flags, such as --store and --store-dsn, select the store.`
+
+// errMigrateUsage is returned for arguments runMigrate does not understand
+var errMigrateUsage = errors.New(migrateUsage)
+
+// runMigrate runs the migrate subcommand, with the arguments left after the
+// flags of conf
+func runMigrate(conf *config.Config) error {
+	args := conf.Args
+	action := "version"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	var target int
+	switch action {
+	case "version", "up":
+		if len(args) != 0 {
+			return errMigrateUsage
+		}
+	case "goto", "force":
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+		v, err := strconv.Atoi(args[0])
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+		target = v
+	default:
+		return errMigrateUsage
+	}
+
+	backend := store.DefaultBackend(conf.Store, conf.DataDir)
+
+	s, err := store.Open(conf.Store, conf.DataDir, conf.StoreDSN)
+	if err != nil {
+		return fmt.Errorf("opening data store: %w", err)
+	}
+	defer s.Close()
+
+	m, ok := store.AsMigrator(s)
+	if !ok {
+		return fmt.Errorf("the %s store has no schema to migrate", backend)
+	}
+
+	ctx := context.Background()
+
+	switch action {
+	case "up":
+		err = store.Migrate(ctx, s)
+	case "goto":
+		err = m.MigrateTo(ctx, target)
+	case "force":
+		err = m.ForceSchemaVersion(ctx, target)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := m.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s store at schema version %d of %d", backend, version, m.LatestSchemaVersion())
+	if dirty {
+		fmt.Print(", dirty")
+	}
+	fmt.Println()
+
+	return nil
+}
@@ -1,17 +1,26 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/coder/websocket"
 	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/jsonpatch"
 	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomad"
 	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
+	"github.com/caravan-nomad/caravan/backend/pkg/nsscope"
+	"github.com/caravan-nomad/caravan/backend/pkg/plugins"
+	"github.com/caravan-nomad/caravan/backend/pkg/streams"
+	"github.com/caravan-nomad/caravan/backend/pkg/telemetry"
 )
 
 const (
@@ -30,8 +39,20 @@ const (
 	HeartbeatInterval = 30 * time.Second
 	// CleanupRoutineInterval is the interval at which the multiplexer cleans up unused connections.
 	CleanupRoutineInterval = 5 * time.Minute
+	// DefaultWriteTimeout is how long a write to a client may block before the
+	// client is taken to have stopped reading, and is dropped.
+	DefaultWriteTimeout = 10 * time.Second
 )
 
+// maxPooledMessage is the largest buffer put back in messageBuffers, so that
+// one huge event does not stay allocated
+const maxPooledMessage = 256 << 10
+
+// messageBuffers holds the buffers messages to clients are encoded in
+var messageBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
 // ConnectionState represents the current state of a connection.
 type ConnectionState string
 
@@ -54,6 +75,16 @@ type Connection struct {
 	mu        sync.RWMutex
 	closed    bool
 	Token     string
+	// Patches sends JSON patches from the last payload of each object
+	// instead of the whole payload
+	Patches bool
+	// snapshots holds the last payload sent for each object, by topic,
+	// namespace and key. Only the streaming goroutine uses it.
+	snapshots map[string]interface{}
+	// stream registers the subscription while it is open
+	stream *streams.Stream
+	// scope holds the namespaces whose events the token may see
+	scope nsscope.Scope
 }
 
 // Message represents a WebSocket message structure.
@@ -64,13 +95,26 @@ type Message struct {
 	Data      string `json:"data,omitempty"`
 	Type      string `json:"type"`
 	Error     string `json:"error,omitempty"`
+	// Patches asks a subscription for RFC 6902 patches in place of the
+	// payloads of objects already sent
+	Patches bool `json:"patches,omitempty"`
 }
 
 // Multiplexer manages multiple WebSocket connections for Nomad event streams.
 type Multiplexer struct {
 	connections      map[string]*Connection
+	clients          map[*WSConnLock]struct{}
 	mutex            sync.RWMutex
 	nomadConfigStore nomadconfig.ContextStore
+	// compressionMode is negotiated with clients connecting to the multiplexer.
+	compressionMode websocket.CompressionMode
+	// streams registers the subscriptions, nil if they are not tracked
+	streams *streams.Registry
+	// writeTimeout bounds writes to clients
+	writeTimeout time.Duration
+	// namespaceScope returns the namespaces a token may see, nil if they
+	// are not limited
+	namespaceScope func(cluster, token string) nsscope.Scope
 }
 
 // WSConnLock provides a thread-safe wrapper around a WebSocket connection.
@@ -78,23 +122,58 @@ type WSConnLock struct {
 	conn    *websocket.Conn
 	writeMu sync.Mutex
 	ctx     context.Context
+	// writeTimeout bounds each write, after which the connection is closed
+	writeTimeout time.Duration
 }
 
 // NewWSConnLock creates a new WSConnLock instance.
 func NewWSConnLock(conn *websocket.Conn, ctx context.Context) *WSConnLock {
-	return &WSConnLock{conn: conn, ctx: ctx}
+	return &WSConnLock{conn: conn, ctx: ctx, writeTimeout: DefaultWriteTimeout}
 }
 
 // WriteJSON writes JSON to the connection.
 func (c *WSConnLock) WriteJSON(v interface{}) error {
+	_, err := c.writeJSON(v)
+	return err
+}
+
+// writeJSON writes JSON to the connection, encoded in a pooled buffer, and
+// returns its size
+func (c *WSConnLock) writeJSON(v interface{}) (int, error) {
+	buf := messageBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledMessage {
+			buf.Reset()
+			messageBuffers.Put(buf)
+		}
+	}()
+
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return 0, err
+	}
+	// Without the newline Encode ends with, as json.Marshal would
+	data := buf.Bytes()[:buf.Len()-1]
+
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
+	return len(data), c.write(websocket.MessageText, data)
+}
 
-	data, err := json.Marshal(v)
-	if err != nil {
-		return err
+// write writes a message within the write timeout, and counts it
+func (c *WSConnLock) write(messageType websocket.MessageType, data []byte) error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.writeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.conn.Write(ctx, messageType, data)
+	switch {
+	case err == nil:
+		telemetry.RecordStreamWrite("multiplexer", len(data), 1, time.Since(start).Seconds())
+	case errors.Is(err, context.DeadlineExceeded):
+		telemetry.RecordStreamWriteTimeout("multiplexer")
 	}
-	return c.conn.Write(c.ctx, websocket.MessageText, data)
+
+	return err
 }
 
 // ReadJSON reads JSON from the connection.
@@ -115,7 +194,7 @@ func (c *WSConnLock) ReadMessage() (websocket.MessageType, []byte, error) {
 func (c *WSConnLock) WriteMessage(messageType websocket.MessageType, data []byte) error {
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
-	return c.conn.Write(c.ctx, messageType, data)
+	return c.write(messageType, data)
 }
 
 // Close closes the connection.
@@ -132,14 +211,45 @@ func (c *WSConnLock) CloseNow() error {
 func NewMultiplexer(nomadConfigStore nomadconfig.ContextStore) *Multiplexer {
 	return &Multiplexer{
 		connections:      make(map[string]*Connection),
+		clients:          make(map[*WSConnLock]struct{}),
 		nomadConfigStore: nomadConfigStore,
+		compressionMode:  websocket.CompressionDisabled,
+		writeTimeout:     DefaultWriteTimeout,
+	}
+}
+
+// EnableCompression makes the multiplexer negotiate permessage-deflate with clients.
+// Event streams are highly repetitive JSON, so this cuts bandwidth considerably
+// on large clusters at the cost of some CPU.
+func (m *Multiplexer) EnableCompression() {
+	m.compressionMode = websocket.CompressionContextTakeover
+}
+
+// SetWriteTimeout sets how long a write to a client may block before the
+// client is dropped.
+func (m *Multiplexer) SetWriteTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		m.writeTimeout = timeout
 	}
 }
 
+// TrackStreams registers the subscriptions in registry while they are open,
+// so that they can be listed and closed through the admin API.
+func (m *Multiplexer) TrackStreams(registry *streams.Registry) {
+	m.streams = registry
+}
+
+// ScopeNamespaces limits the events of each subscription to the namespaces
+// scope returns for its cluster and token.
+func (m *Multiplexer) ScopeNamespaces(scope func(cluster, token string) nsscope.Scope) {
+	m.namespaceScope = scope
+}
+
 // HandleClientWebSocket handles incoming WebSocket connections from clients.
 func (m *Multiplexer) HandleClientWebSocket(w http.ResponseWriter, r *http.Request) {
 	clientConn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"}, // Allow all origins for now
+		OriginPatterns:  []string{"*"}, // Allow all origins for now
+		CompressionMode: m.compressionMode,
 	})
 	if err != nil {
 		logger.Log(logger.LevelError, nil, err, "upgrading connection")
@@ -149,6 +259,17 @@ func (m *Multiplexer) HandleClientWebSocket(w http.ResponseWriter, r *http.Reque
 
 	ctx := r.Context()
 	lockClientConn := NewWSConnLock(clientConn, ctx)
+	lockClientConn.writeTimeout = m.writeTimeout
+
+	m.mutex.Lock()
+	m.clients[lockClientConn] = struct{}{}
+	m.mutex.Unlock()
+
+	defer func() {
+		m.mutex.Lock()
+		delete(m.clients, lockClientConn)
+		m.mutex.Unlock()
+	}()
 
 	for {
 		var msg Message
@@ -175,7 +296,48 @@ func (m *Multiplexer) HandleClientWebSocket(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	m.cleanupConnections()
+	m.cleanupConnections(lockClientConn)
+}
+
+// Broadcast sends a message to every connected client, subscribed to a
+// cluster or not.
+func (m *Multiplexer) Broadcast(msg Message) {
+	m.mutex.RLock()
+	clients := make([]*WSConnLock, 0, len(m.clients))
+	for client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.mutex.RUnlock()
+
+	for _, client := range clients {
+		if err := client.WriteJSON(msg); err != nil {
+			logger.Log(logger.LevelError, nil, err, "broadcasting message to client")
+		}
+	}
+}
+
+// NotifyPluginsChanged tells the clients the new plugin list, so they can
+// reload the plugins whose hash changed without reloading the page.
+func (m *Multiplexer) NotifyPluginsChanged(pluginList []plugins.PluginMetadata) {
+	data, err := json.Marshal(pluginList)
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "marshaling plugin list")
+		return
+	}
+
+	m.Broadcast(Message{Type: "PLUGINS", Data: string(data)})
+}
+
+// NotifyAnnouncements tells the clients the active announcements, so banners
+// show up and go away without reloading the page.
+func (m *Multiplexer) NotifyAnnouncements(announcements []nomad.Announcement) {
+	data, err := json.Marshal(announcements)
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "marshaling announcements")
+		return
+	}
+
+	m.Broadcast(Message{Type: "ANNOUNCEMENTS", Data: string(data)})
 }
 
 // handleSubscribe handles a subscribe request for Nomad events.
@@ -204,12 +366,30 @@ func (m *Multiplexer) handleSubscribe(msg Message, clientConn *WSConnLock, r *ht
 		Done:      make(chan struct{}),
 		cancel:    cancel,
 		Token:     token,
+		Patches:   msg.Patches,
+		snapshots: make(map[string]interface{}),
+		scope:     nsscope.Unrestricted,
 		Status: ConnectionStatus{
 			State:   StateConnecting,
 			LastMsg: time.Now(),
 		},
 	}
 
+	if m.namespaceScope != nil {
+		conn.scope = m.namespaceScope(msg.ClusterID, token)
+	}
+
+	if m.streams != nil {
+		conn.stream = m.streams.Open(streams.Options{
+			Kind:    streams.KindSubscription,
+			Cluster: msg.ClusterID,
+			User:    msg.UserID,
+			Token:   token,
+			Topic:   strings.Join(conn.Topics, ","),
+			Close:   func() { m.CloseConnection(msg.ClusterID, msg.UserID) },
+		})
+	}
+
 	m.mutex.Lock()
 	m.connections[connKey] = conn
 	m.mutex.Unlock()
@@ -292,34 +472,101 @@ func (m *Multiplexer) sendEvent(conn *Connection, event api.Event) {
 	}
 	conn.mu.Unlock()
 
-	eventData, err := json.Marshal(map[string]interface{}{
-		"topic":   event.Topic,
-		"type":    event.Type,
-		"key":     event.Key,
-		"index":   event.Index,
-		"payload": event.Payload,
-	})
+	// Events of objects outside of the token's namespaces are dropped
+	if namespace, ok := eventNamespace(event); ok && !conn.scope.Allows(namespace) {
+		return
+	}
+
+	data := map[string]interface{}{
+		"topic": event.Topic,
+		"type":  event.Type,
+		"key":   event.Key,
+		"index": event.Index,
+	}
+	if conn.Patches {
+		conn.setPayloadOrPatch(event, data)
+	} else {
+		data["payload"] = event.Payload
+	}
+
+	eventData, err := json.Marshal(data)
 	if err != nil {
 		logger.Log(logger.LevelError, nil, err, "marshaling event")
 		return
 	}
 
-	msg := Message{
+	n, err := conn.Client.writeJSON(Message{
 		ClusterID: conn.ClusterID,
 		UserID:    conn.UserID,
 		Data:      string(eventData),
 		Type:      "DATA",
-	}
-
-	if err := conn.Client.WriteJSON(msg); err != nil {
+	})
+	if err != nil {
 		logger.Log(logger.LevelError, nil, err, "writing event to client")
 	}
+	conn.stream.Sent(n)
 
 	conn.mu.Lock()
 	conn.Status.LastMsg = time.Now()
 	conn.mu.Unlock()
 }
 
+// setPayloadOrPatch sets the payload of the event in data, or the patch to
+// it from the last payload sent for the same object when the patch is
+// smaller. Payloads of deregistered objects are always sent whole, and
+// forgotten. The namespace is sent along, for clients to find the object a
+// patch applies to.
+func (conn *Connection) setPayloadOrPatch(event api.Event, data map[string]interface{}) {
+	namespace, _ := eventNamespace(event)
+	key := string(event.Topic) + "/" + namespace + "/" + event.Key
+	previous, seen := conn.snapshots[key]
+	data["namespace"] = namespace
+	data["payload"] = event.Payload
+
+	if strings.Contains(event.Type, "Deregist") || strings.Contains(event.Type, "Delete") {
+		delete(conn.snapshots, key)
+		return
+	}
+	conn.snapshots[key] = event.Payload
+
+	if !seen {
+		return
+	}
+
+	patch, err := jsonpatch.Diff(previous, event.Payload)
+	if err != nil {
+		logger.Log(logger.LevelWarn, nil, err, "computing event patch")
+		return
+	}
+
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		return
+	}
+	payloadData, err := json.Marshal(event.Payload)
+	if err != nil || len(patchData) >= len(payloadData) {
+		return
+	}
+
+	delete(data, "payload")
+	data["patch"] = json.RawMessage(patchData)
+}
+
+// eventNamespace returns the namespace of the object in an event payload,
+// since job IDs are only unique within a namespace. Objects such as nodes
+// have none.
+func eventNamespace(event api.Event) (string, bool) {
+	for _, object := range event.Payload {
+		if fields, ok := object.(map[string]interface{}); ok {
+			if namespace, ok := fields["Namespace"].(string); ok {
+				return namespace, true
+			}
+		}
+	}
+
+	return "", false
+}
+
 // updateStatus updates the connection status.
 func (conn *Connection) updateStatus(state ConnectionState, err error) {
 	conn.mu.Lock()
@@ -384,6 +631,7 @@ func (m *Multiplexer) cleanupConnection(conn *Connection) {
 	}
 
 	conn.closed = true
+	conn.stream.Done()
 
 	if conn.cancel != nil {
 		conn.cancel()
@@ -412,6 +660,7 @@ func (m *Multiplexer) CloseConnection(clusterID, userID string) {
 	conn.mu.Lock()
 	if !conn.closed {
 		conn.closed = true
+		conn.stream.Done()
 		if conn.cancel != nil {
 			conn.cancel()
 		}
@@ -420,22 +669,33 @@ func (m *Multiplexer) CloseConnection(clusterID, userID string) {
 	conn.mu.Unlock()
 }
 
-// cleanupConnections cleans up all connections.
-func (m *Multiplexer) cleanupConnections() {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// cleanupConnections cleans up the connections of a client that left,
+// leaving those of the other clients streaming.
+func (m *Multiplexer) cleanupConnections(client *WSConnLock) {
+	// The connections are closed after releasing the multiplexer's lock,
+	// which cleanupConnection takes while holding a connection's
+	var closing []*Connection
 
+	m.mutex.Lock()
 	for key, conn := range m.connections {
+		if conn.Client == client {
+			closing = append(closing, conn)
+			delete(m.connections, key)
+		}
+	}
+	m.mutex.Unlock()
+
+	for _, conn := range closing {
 		conn.mu.Lock()
 		if !conn.closed {
 			conn.closed = true
+			conn.stream.Done()
 			if conn.cancel != nil {
 				conn.cancel()
 			}
 			close(conn.Done)
 		}
 		conn.mu.Unlock()
-		delete(m.connections, key)
 	}
 }
 
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadtest"
+	"github.com/caravan-nomad/caravan/backend/pkg/nsscope"
+	"github.com/caravan-nomad/caravan/backend/pkg/streams"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkGoroutines fails the test if goroutines it started are still running
+// once it has ended and its servers are closed. It must be called before
+// anything else registers a cleanup. Like goleak's IgnoreCurrent, which is
+// not a dependency, goroutines are told apart by ID rather than counted, so
+// that one ending cannot hide another leaking.
+func checkGoroutines(t *testing.T) {
+	t.Helper()
+
+	before := map[string]bool{}
+	for id := range goroutines() {
+		before[id] = true
+	}
+
+	t.Cleanup(func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var leaked []string
+			for id, stack := range goroutines() {
+				if !before[id] {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 {
+				return
+			}
+
+			if time.Now().After(deadline) {
+				t.Errorf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// goroutines returns the stacks of the running goroutines by goroutine ID
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	stacks := map[string]string{}
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		// Each stack starts with "goroutine 42 [running]:"
+		header, _, _ := strings.Cut(stack, "\n")
+		if fields := strings.Fields(header); len(fields) > 1 && fields[0] == "goroutine" {
+			stacks[fields[1]] = stack
+		}
+	}
+
+	return stacks
+}
+
+// multiplexerFixture is a multiplexer streaming the events of a fake cluster
+// named test
+type multiplexerFixture struct {
+	nomad   *nomadtest.Server
+	m       *Multiplexer
+	streams *streams.Registry
+	url     string
+}
+
+func newMultiplexerFixture(t *testing.T) *multiplexerFixture {
+	t.Helper()
+
+	nomadSrv := nomadtest.NewServer(t)
+	registry := streams.NewRegistry()
+	m := NewMultiplexer(nomadSrv.ContextStore("test"))
+	m.TrackStreams(registry)
+
+	srv := httptest.NewServer(http.HandlerFunc(m.HandleClientWebSocket))
+	t.Cleanup(srv.Close)
+
+	return &multiplexerFixture{nomad: nomadSrv, m: m, streams: registry, url: "ws" + strings.TrimPrefix(srv.URL, "http")}
+}
+
+func (f *multiplexerFixture) dial(ctx context.Context, t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.Dial(ctx, f.url, nil)
+	require.NoError(t, err)
+	// Event payloads are larger than the default limit
+	conn.SetReadLimit(-1)
+
+	return conn
+}
+
+// connections returns the number of open subscriptions
+func (f *multiplexerFixture) connections() int {
+	f.m.mutex.RLock()
+	defer f.m.mutex.RUnlock()
+
+	return len(f.m.connections)
+}
+
+// publish publishes n job events, each with a payload of about size bytes
+func (f *multiplexerFixture) publish(n, size int) {
+	filler := strings.Repeat("x", size)
+	for i := range n {
+		id := fmt.Sprintf("job-%d", i%50)
+		f.nomad.Publish(api.TopicJob, "JobRegistered", id, "default", map[string]interface{}{
+			"Job": map[string]interface{}{"ID": id, "Namespace": "default", "Meta": map[string]string{"filler": filler}},
+		})
+	}
+}
+
+func sendMessage(ctx context.Context, t *testing.T, conn *websocket.Conn, msg Message) {
+	t.Helper()
+
+	data, err := json.Marshal(msg)
+	require.NoError(t, err)
+	require.NoError(t, conn.Write(ctx, websocket.MessageText, data))
+}
+
+// readUntil reads messages from conn until one of the given type arrives
+func readUntil(ctx context.Context, conn *websocket.Conn, typ string) (Message, error) {
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return Message{}, err
+		}
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return Message{}, err
+		}
+		if msg.Type == typ {
+			return msg, nil
+		}
+	}
+}
+
+// eventually waits for cond to hold
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+
+	assert.Eventually(t, cond, 5*time.Second, 10*time.Millisecond, msg)
+}
+
+func TestMultiplexerSubscribeChurn(t *testing.T) {
+	checkGoroutines(t)
+	f := newMultiplexerFixture(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Events keep flowing while clients come and go
+	publishing, stopPublishing := context.WithCancel(ctx)
+	var publisher sync.WaitGroup
+	publisher.Add(1)
+	go func() {
+		defer publisher.Done()
+		for publishing.Err() == nil {
+			f.publish(10, 256)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	const clients, cycles = 20, 10
+
+	var wg sync.WaitGroup
+	for c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn := f.dial(ctx, t)
+			defer conn.CloseNow()
+
+			user := fmt.Sprintf("user-%d", c)
+
+			// Drain the connection, as a browser would
+			go func() {
+				for {
+					if _, _, err := conn.Read(ctx); err != nil {
+						return
+					}
+				}
+			}()
+
+			for i := range cycles {
+				sendMessage(ctx, t, conn, Message{Type: "SUBSCRIBE", ClusterID: "test", UserID: user, Patches: i%2 == 0})
+				if i%3 == 0 {
+					time.Sleep(5 * time.Millisecond)
+				}
+				sendMessage(ctx, t, conn, Message{Type: "UNSUBSCRIBE", ClusterID: "test", UserID: user})
+			}
+
+			// Half the clients leave subscribed, the others unsubscribed
+			if c%2 == 0 {
+				sendMessage(ctx, t, conn, Message{Type: "SUBSCRIBE", ClusterID: "test", UserID: user})
+			}
+
+			conn.Close(websocket.StatusNormalClosure, "")
+		}()
+	}
+
+	wg.Wait()
+	stopPublishing()
+	publisher.Wait()
+
+	eventually(t, func() bool { return f.connections() == 0 }, "subscriptions are closed with their clients")
+	eventually(t, func() bool { return len(f.streams.List()) == 0 }, "subscriptions are unregistered")
+}
+
+func TestMultiplexerClientsAreIndependent(t *testing.T) {
+	checkGoroutines(t)
+	f := newMultiplexerFixture(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stays := f.dial(ctx, t)
+	defer stays.CloseNow()
+	sendMessage(ctx, t, stays, Message{Type: "SUBSCRIBE", ClusterID: "test", UserID: "stays"})
+	_, err := readUntil(ctx, stays, "STATUS")
+	require.NoError(t, err)
+
+	leaves := f.dial(ctx, t)
+	sendMessage(ctx, t, leaves, Message{Type: "SUBSCRIBE", ClusterID: "test", UserID: "leaves"})
+	_, err = readUntil(ctx, leaves, "STATUS")
+	require.NoError(t, err)
+	require.Equal(t, 2, f.connections())
+
+	// A client leaving only closes its own subscriptions
+	leaves.Close(websocket.StatusNormalClosure, "")
+	eventually(t, func() bool { return f.connections() == 1 }, "the leaving client's subscription is closed")
+
+	f.publish(1, 16)
+	msg, err := readUntil(ctx, stays, "DATA")
+	require.NoError(t, err)
+	assert.Equal(t, "stays", msg.UserID)
+
+	stays.Close(websocket.StatusNormalClosure, "")
+	eventually(t, func() bool { return f.connections() == 0 }, "every subscription is closed")
+}
+
+func TestMultiplexerSlowClient(t *testing.T) {
+	checkGoroutines(t)
+	f := newMultiplexerFixture(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// The slow client subscribes and then stops reading
+	slow := f.dial(ctx, t)
+	defer slow.CloseNow()
+	sendMessage(ctx, t, slow, Message{Type: "SUBSCRIBE", ClusterID: "test", UserID: "slow"})
+	_, err := readUntil(ctx, slow, "STATUS")
+	require.NoError(t, err)
+
+	fast := f.dial(ctx, t)
+	defer fast.CloseNow()
+	sendMessage(ctx, t, fast, Message{Type: "SUBSCRIBE", ClusterID: "test", UserID: "fast"})
+	_, err = readUntil(ctx, fast, "STATUS")
+	require.NoError(t, err)
+
+	var received sync.WaitGroup
+	var events atomic.Int64
+	received.Add(1)
+	go func() {
+		defer received.Done()
+		for {
+			if _, err := readUntil(ctx, fast, "DATA"); err != nil {
+				return
+			}
+			events.Add(1)
+		}
+	}()
+
+	// settle waits for the fast client to have read what reached it
+	settle := func() {
+		for last := int64(-1); events.Load() != last; time.Sleep(200 * time.Millisecond) {
+			last = events.Load()
+		}
+	}
+
+	heap := func() uint64 {
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		return stats.HeapAlloc
+	}
+
+	// Far more events than the slow client's socket buffers hold. The fake
+	// cluster keeps recent events, so memory grows while they fill up, but
+	// more events must back up towards Nomad rather than pile up here.
+	const n, size = 2000, 8192
+	f.publish(n, size)
+	settle()
+	filled := heap()
+
+	f.publish(2*n, size)
+	settle()
+	if after := heap(); after > filled {
+		assert.Less(t, after-filled, uint64(n*size), "memory grows with the events a slow client does not read")
+	}
+
+	fast.Close(websocket.StatusNormalClosure, "")
+	received.Wait()
+	assert.Positive(t, events.Load(), "the slow client holds back the others")
+
+	// The slow client can still unsubscribe, and leave
+	sendMessage(ctx, t, slow, Message{Type: "UNSUBSCRIBE", ClusterID: "test", UserID: "slow"})
+	slow.CloseNow()
+	eventually(t, func() bool { return f.connections() == 0 }, "the slow client's subscription is closed")
+}
+
+func TestMultiplexerNamespaceScope(t *testing.T) {
+	checkGoroutines(t)
+	f := newMultiplexerFixture(t)
+
+	scopes, err := nsscope.Parse([]byte(`{"rules": [{"namespaces": ["team-a"]}]}`))
+	require.NoError(t, err)
+	f.m.ScopeNamespaces(func(cluster, _ string) nsscope.Scope {
+		return scopes.For(cluster, nsscope.Identity{})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn := f.dial(ctx, t)
+	defer conn.CloseNow()
+	sendMessage(ctx, t, conn, Message{Type: "SUBSCRIBE", ClusterID: "test", UserID: "alice"})
+	_, err = readUntil(ctx, conn, "STATUS")
+	require.NoError(t, err)
+
+	f.publish(1, 16)
+	f.nomad.Publish(api.TopicNode, "NodeRegistration", "node-1", "", map[string]interface{}{
+		"Node": map[string]interface{}{"ID": "node-1"},
+	})
+
+	msg, err := readUntil(ctx, conn, "DATA")
+	require.NoError(t, err)
+
+	var event struct{ Topic string }
+	require.NoError(t, json.Unmarshal([]byte(msg.Data), &event))
+	assert.Equal(t, "Node", event.Topic, "events of other namespaces are dropped, those of nodes are not")
+
+	conn.Close(websocket.StatusNormalClosure, "")
+	eventually(t, func() bool { return f.connections() == 0 }, "the subscription is closed")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/config"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomad"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+const rotateKeyUsage = `usage: caravan rotate-key [flags] [new]
+
+  (no action)   re-encrypt the stored secrets under --store-encryption-key,
+                reading them with it or one of --store-previous-keys
+  new           print a new random master key
+
+To rotate a local master key, move it to --store-previous-keys, set the new
+one as --store-encryption-key and run caravan rotate-key, then drop the
+previous key. Stored secrets still in plain, from before encryption was
+enabled, are encrypted too. The store flags, such as --store and
+--store-dsn, select the store.`
+
+// errRotateKeyUsage is returned for arguments runRotateKey does not understand
+var errRotateKeyUsage = errors.New(rotateKeyUsage)
+
+// runRotateKey runs the rotate-key subcommand, with the arguments left after
+// the flags of conf
+func runRotateKey(conf *config.Config) error {
+	switch {
+	case len(conf.Args) == 1 && conf.Args[0] == "new":
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(key))
+		return nil
+	case len(conf.Args) != 0:
+		return errRotateKeyUsage
+	case conf.StoreEncryptionKey == "":
+		return errors.New("--store-encryption-key is not set")
+	}
+
+	s, err := openDataStore(conf)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	n, err := store.Reencrypt(context.Background(), s)
+	if err != nil {
+		return fmt.Errorf("re-encrypting the stored secrets, after %d values: %w", n, err)
+	}
+
+	fmt.Printf("re-encrypted %d values of the %s store\n", n, store.DefaultBackend(conf.Store, conf.DataDir))
+
+	return nil
+}
+
+// openDataStore opens and migrates the store of conf, encrypting the secret
+// buckets when it has a master key
+func openDataStore(conf *config.Config) (store.Store, error) {
+	s, err := store.Open(conf.Store, conf.DataDir, conf.StoreDSN)
+	if err != nil {
+		return nil, fmt.Errorf("opening data store: %w", err)
+	}
+
+	// Refuses schemas of newer builds, which this one may misread
+	if err := store.Migrate(context.Background(), s); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("migrating data store: %w", err)
+	}
+
+	if conf.StoreEncryptionKey == "" {
+		if conf.StorePreviousKeys != "" {
+			s.Close()
+			return nil, errors.New("--store-previous-keys needs --store-encryption-key")
+		}
+		return s, nil
+	}
+
+	keys, err := store.OpenKeyring(conf.StoreEncryptionKey, conf.StorePreviousKeys)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("opening the store encryption key: %w", err)
+	}
+
+	return store.NewEncrypted(s, keys, nomad.SecretBuckets...), nil
+}
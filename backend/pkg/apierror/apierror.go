@@ -0,0 +1,173 @@
+// Package apierror defines the error response schema shared by all Caravan API
+// endpoints.
+//
+// Every error is written as
+//
+//	{"error": {"code": "...", "message": "...", "details": ..., "cluster": "...", "upstreamStatus": 403}}
+//
+// so that the frontend and automation can branch on the machine-readable code
+// instead of parsing English messages.
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/i18n"
+)
+
+// Code is a machine-readable error code.
+type Code string
+
+const (
+	// CodeBadRequest means the request was malformed or missing required input.
+	CodeBadRequest Code = "BAD_REQUEST"
+	// CodeUnauthorized means the request carried no usable credentials.
+	CodeUnauthorized Code = "UNAUTHORIZED"
+	// CodePermissionDenied means the token is valid but lacks the required capability.
+	CodePermissionDenied Code = "PERMISSION_DENIED"
+	// CodeTokenExpired means the Nomad ACL token has expired.
+	CodeTokenExpired Code = "TOKEN_EXPIRED"
+	// CodeTokenInvalid means the Nomad ACL token is unknown to the cluster.
+	CodeTokenInvalid Code = "TOKEN_INVALID"
+	// CodeNotFound means the requested resource does not exist.
+	CodeNotFound Code = "NOT_FOUND"
+	// CodeClusterNotFound means the cluster is not configured in Caravan.
+	CodeClusterNotFound Code = "CLUSTER_NOT_FOUND"
+	// CodeConflict means the request conflicts with the current state of the resource.
+	CodeConflict Code = "CONFLICT"
+	// CodeChangeFrozen means the change was rejected because a freeze window is active.
+	CodeChangeFrozen Code = "CHANGE_FROZEN"
+	// CodeTooManyRequests means the client has to wait before trying again.
+	CodeTooManyRequests Code = "TOO_MANY_REQUESTS"
+	// CodeListTooLarge means a list is too large to return without pagination.
+	CodeListTooLarge Code = "LIST_TOO_LARGE"
+	// CodeNomadUnreachable means Caravan could not connect to the Nomad cluster.
+	CodeNomadUnreachable Code = "NOMAD_UNREACHABLE"
+	// CodeAutoscalerUnreachable means Caravan could not query the cluster's Nomad Autoscaler agent.
+	CodeAutoscalerUnreachable Code = "AUTOSCALER_UNREACHABLE"
+	// CodeFeatureDisabled means an operator switched the feature off, e.g. to shed load during an incident.
+	CodeFeatureDisabled Code = "FEATURE_DISABLED"
+	// CodeInternal is used for all other failures.
+	CodeInternal Code = "INTERNAL_ERROR"
+)
+
+// Error is the body of an error response.
+type Error struct {
+	Code           Code        `json:"code"`
+	Message        string      `json:"message"`
+	Details        interface{} `json:"details,omitempty"`
+	Cluster        string      `json:"cluster,omitempty"`
+	UpstreamStatus int         `json:"upstreamStatus,omitempty"`
+
+	// status is the HTTP status code the error is written with.
+	status int
+}
+
+// Envelope wraps an Error for serialization.
+type Envelope struct {
+	Error *Error `json:"error"`
+}
+
+// New creates an error with the given HTTP status, code and message.
+func New(status int, code Code, message string) *Error {
+	return &Error{
+		Code:    code,
+		Message: message,
+		status:  status,
+	}
+}
+
+// FromStatus creates an error from err, deriving the code from the HTTP status.
+func FromStatus(status int, err error) *Error {
+	return New(status, CodeForStatus(status), err.Error())
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+// Status returns the HTTP status code of the error.
+func (e *Error) Status() int {
+	if e.status == 0 {
+		return http.StatusInternalServerError
+	}
+
+	return e.status
+}
+
+// WithCluster sets the cluster the error relates to.
+func (e *Error) WithCluster(cluster string) *Error {
+	e.Cluster = cluster
+	return e
+}
+
+// WithDetails attaches additional, code specific details.
+func (e *Error) WithDetails(details interface{}) *Error {
+	e.Details = details
+	return e
+}
+
+// WithUpstreamStatus records the status code Nomad responded with.
+func (e *Error) WithUpstreamStatus(status int) *Error {
+	e.UpstreamStatus = status
+	return e
+}
+
+// CodeForStatus returns the default code for an HTTP status.
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodePermissionDenied
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return CodeNomadUnreachable
+	default:
+		return CodeInternal
+	}
+}
+
+// Write writes e as a JSON error response, localizing the message for the
+// request's Accept-Language header. r may be nil.
+func Write(w http.ResponseWriter, r *http.Request, e *Error) {
+	if r != nil {
+		lang := i18n.Default.Negotiate(r.Header.Get("Accept-Language"))
+		localize(e, lang)
+		w.Header().Set("Content-Language", lang)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status())
+	json.NewEncoder(w).Encode(Envelope{Error: e})
+}
+
+// localize replaces the message with its translation from the message catalog.
+// English messages are kept as they are since they carry the specific reason;
+// for other languages the original message is kept in details unless the
+// error already has details of its own.
+func localize(e *Error, lang string) {
+	if lang == i18n.DefaultLanguage {
+		return
+	}
+
+	msg, ok := i18n.Default.Message(lang, string(e.Code))
+	if !ok || msg == e.Message {
+		return
+	}
+
+	if e.Details == nil {
+		e.Details = e.Message
+	}
+
+	e.Message = msg
+}
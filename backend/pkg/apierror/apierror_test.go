@@ -0,0 +1,95 @@
+package apierror_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeForStatus(t *testing.T) {
+	tests := map[int]apierror.Code{
+		http.StatusBadRequest:          apierror.CodeBadRequest,
+		http.StatusUnauthorized:        apierror.CodeUnauthorized,
+		http.StatusForbidden:           apierror.CodePermissionDenied,
+		http.StatusNotFound:            apierror.CodeNotFound,
+		http.StatusConflict:            apierror.CodeConflict,
+		http.StatusTooManyRequests:     apierror.CodeTooManyRequests,
+		http.StatusBadGateway:          apierror.CodeNomadUnreachable,
+		http.StatusInternalServerError: apierror.CodeInternal,
+		http.StatusTeapot:              apierror.CodeInternal,
+	}
+
+	for status, code := range tests {
+		assert.Equal(t, code, apierror.CodeForStatus(status), "status %d", status)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	rec := httptest.NewRecorder()
+
+	apierror.Write(rec, nil, apierror.FromStatus(http.StatusForbidden, errors.New("Permission denied")).
+		WithCluster("prod").
+		WithUpstreamStatus(http.StatusForbidden).
+		WithDetails(map[string]string{"capability": "submit-job"}))
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var body struct {
+		Error struct {
+			Code           string            `json:"code"`
+			Message        string            `json:"message"`
+			Details        map[string]string `json:"details"`
+			Cluster        string            `json:"cluster"`
+			UpstreamStatus int               `json:"upstreamStatus"`
+		} `json:"error"`
+	}
+
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "PERMISSION_DENIED", body.Error.Code)
+	assert.Equal(t, "Permission denied", body.Error.Message)
+	assert.Equal(t, "prod", body.Error.Cluster)
+	assert.Equal(t, http.StatusForbidden, body.Error.UpstreamStatus)
+	assert.Equal(t, "submit-job", body.Error.Details["capability"])
+}
+
+func TestStatusDefaultsToInternalServerError(t *testing.T) {
+	e := &apierror.Error{Code: apierror.CodeInternal}
+	assert.Equal(t, http.StatusInternalServerError, e.Status())
+}
+
+func TestWriteLocalized(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "de-DE,de;q=0.9,en;q=0.8")
+
+	apierror.Write(rec, req, apierror.New(http.StatusNotFound, apierror.CodeClusterNotFound, "context not found: prod"))
+
+	assert.Equal(t, "de", rec.Header().Get("Content-Language"))
+
+	var body apierror.Envelope
+
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "Der Cluster ist in Caravan nicht konfiguriert.", body.Error.Message)
+	assert.Equal(t, "context not found: prod", body.Error.Details)
+}
+
+func TestWriteKeepsEnglishMessage(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "en-US")
+
+	apierror.Write(rec, req, apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, "job id is required"))
+
+	var body apierror.Envelope
+
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "job id is required", body.Error.Message)
+	assert.Nil(t, body.Error.Details)
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 )
 
 const (
@@ -13,6 +14,94 @@ const (
 	chunkSize = 3800
 )
 
+// SecureMode decides when cookies are marked Secure.
+type SecureMode string
+
+const (
+	// SecureAuto marks cookies Secure when the request came over TLS, to
+	// Caravan or to a TLS-terminating proxy in front of it.
+	SecureAuto   SecureMode = "auto"
+	SecureAlways SecureMode = "always"
+	SecureNever  SecureMode = "never"
+)
+
+// CookieOptions are the attributes of the cookies Caravan sets.
+type CookieOptions struct {
+	Secure SecureMode
+	// SameSite is Strict for secure cookies and Lax otherwise when unset.
+	SameSite http.SameSite
+	// Domain lets subdomains share the cookies; host-only when empty.
+	Domain string
+	// TTL is how long the authentication cookies are kept.
+	TTL time.Duration
+}
+
+// Cookies holds the cookie attributes, set once at startup.
+var Cookies = CookieOptions{Secure: SecureAuto, TTL: 24 * time.Hour}
+
+// ParseCookieOptions builds cookie options from their flag values. An empty
+// sameSite, like "auto", derives it from the Secure bit.
+func ParseCookieOptions(secure, sameSite, domain string, ttl time.Duration) (CookieOptions, error) {
+	opts := CookieOptions{Secure: SecureMode(strings.ToLower(secure)), Domain: domain, TTL: ttl}
+
+	switch opts.Secure {
+	case SecureAuto, SecureAlways, SecureNever:
+	case "":
+		opts.Secure = SecureAuto
+	default:
+		return CookieOptions{}, fmt.Errorf("invalid cookie secure mode %q, expected auto, always or never", secure)
+	}
+
+	switch strings.ToLower(sameSite) {
+	case "", "auto":
+	case "lax":
+		opts.SameSite = http.SameSiteLaxMode
+	case "strict":
+		opts.SameSite = http.SameSiteStrictMode
+	case "none":
+		// Browsers drop SameSite=None cookies that are not Secure
+		if opts.Secure == SecureNever {
+			return CookieOptions{}, errors.New("cookie SameSite=None requires secure cookies")
+		}
+		opts.SameSite = http.SameSiteNoneMode
+	default:
+		return CookieOptions{}, fmt.Errorf("invalid cookie SameSite %q, expected auto, lax, strict or none", sameSite)
+	}
+
+	if ttl <= 0 {
+		return CookieOptions{}, fmt.Errorf("invalid cookie TTL %s", ttl)
+	}
+
+	return opts, nil
+}
+
+// NewCookie returns an HTTP-only cookie with the configured attributes. A
+// negative maxAge clears the cookie.
+func NewCookie(r *http.Request, name, value, path string, maxAge int) *http.Cookie {
+	secure := IsSecureContext(r)
+
+	// Use SameSiteLaxMode for development (cross-origin between localhost:3000 and localhost:4466)
+	// In production with HTTPS, we can use SameSiteStrictMode
+	sameSite := Cookies.SameSite
+	if sameSite == 0 || (sameSite == http.SameSiteNoneMode && !secure) {
+		sameSite = http.SameSiteLaxMode
+		if secure {
+			sameSite = http.SameSiteStrictMode
+		}
+	}
+
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: sameSite,
+		Domain:   Cookies.Domain,
+		Path:     path,
+		MaxAge:   maxAge,
+	}
+}
+
 // GetCookiePath returns the full cookie path including baseURL.
 // The path must end with "/" to ensure browser sends cookie for all sub-paths.
 func GetCookiePath(baseURL, cluster string) string {
@@ -40,16 +129,38 @@ func SanitizeClusterName(cluster string) string {
 
 // IsSecureContext determines if we should use secure cookies.
 func IsSecureContext(r *http.Request) bool {
+	switch Cookies.Secure {
+	case SecureAlways:
+		return true
+	case SecureNever:
+		return false
+	}
+
 	// Check if request came over HTTPS
 	if r.TLS != nil {
 		return true
 	}
 
-	// Check X-Forwarded-Proto header (for reverse proxies)
-	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "https" {
+	// Check the headers of reverse proxies. Chained proxies append their
+	// own value, the first is the one the client connected with.
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	if strings.EqualFold(strings.TrimSpace(proto), "https") {
 		return true
 	}
 
+	if strings.EqualFold(r.Header.Get("X-Forwarded-Ssl"), "on") {
+		return true
+	}
+
+	if forwarded, _, _ := strings.Cut(r.Header.Get("Forwarded"), ","); forwarded != "" {
+		for _, pair := range strings.Split(forwarded, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if strings.EqualFold(key, "proto") && strings.EqualFold(strings.Trim(value, `"`), "https") {
+				return true
+			}
+		}
+	}
+
 	// Check if we're in localhost/development (allow insecure for dev)
 	host := r.Host
 	if strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.0.0.1") {
@@ -74,27 +185,11 @@ func SetTokenCookie(w http.ResponseWriter, r *http.Request, cluster, token, base
 	// Clear any existing cookies
 	ClearTokenCookie(w, r, cluster, baseURL)
 
-	secure := IsSecureContext(r)
-
 	// if token is larger than maxCookieSize, split it into multiple cookies
 	chunks := splitToken(token, chunkSize)
 	for i, chunk := range chunks {
-		// Use SameSiteLaxMode for development (cross-origin between localhost:3000 and localhost:4466)
-		// In production with HTTPS, we can use SameSiteStrictMode
-		sameSite := http.SameSiteLaxMode
-		if secure {
-			sameSite = http.SameSiteStrictMode
-		}
-
-		cookie := &http.Cookie{
-			Name:     fmt.Sprintf("caravan-auth-%s.%d", sanitizedCluster, i),
-			Value:    chunk,
-			HttpOnly: true,
-			Secure:   secure,
-			SameSite: sameSite,
-			Path:     GetCookiePath(baseURL, cluster),
-			MaxAge:   86400, // 24 hours
-		}
+		cookie := NewCookie(r, fmt.Sprintf("caravan-auth-%s.%d", sanitizedCluster, i), chunk,
+			GetCookiePath(baseURL, cluster), int(Cookies.TTL.Seconds()))
 
 		http.SetCookie(w, cookie)
 	}
@@ -133,8 +228,6 @@ func ClearTokenCookie(w http.ResponseWriter, r *http.Request, cluster, baseURL s
 		return
 	}
 
-	secure := IsSecureContext(r)
-
 	// clear chunked cookies
 	for i := 0; ; i++ {
 		cookieName := fmt.Sprintf("caravan-auth-%s.%d", sanitizedCluster, i)
@@ -145,21 +238,7 @@ func ClearTokenCookie(w http.ResponseWriter, r *http.Request, cluster, baseURL s
 			break
 		}
 
-		// Use SameSiteLaxMode for development
-		sameSite := http.SameSiteLaxMode
-		if secure {
-			sameSite = http.SameSiteStrictMode
-		}
-
-		cookie := &http.Cookie{
-			Name:     cookieName,
-			Value:    "",
-			HttpOnly: true,
-			Secure:   secure,
-			SameSite: sameSite,
-			Path:     GetCookiePath(baseURL, cluster),
-			MaxAge:   -1,
-		}
+		cookie := NewCookie(r, cookieName, "", GetCookiePath(baseURL, cluster), -1)
 		http.SetCookie(w, cookie)
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/caravan-nomad/caravan/backend/pkg/auth"
 )
@@ -266,3 +267,49 @@ func TestClearAuthCookie(t *testing.T) {
 		t.Errorf("Expected MaxAge to be -1, got %d", cookie.MaxAge)
 	}
 }
+
+func TestCookieOptions(t *testing.T) {
+	defaults := auth.Cookies
+	t.Cleanup(func() { auth.Cookies = defaults })
+
+	if _, err := auth.ParseCookieOptions("never", "none", "", time.Hour); err == nil {
+		t.Error("Expected SameSite=None without Secure to be rejected")
+	}
+	if _, err := auth.ParseCookieOptions("sometimes", "", "", time.Hour); err == nil {
+		t.Error("Expected an unknown secure mode to be rejected")
+	}
+
+	opts, err := auth.ParseCookieOptions("auto", "none", "example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("ParseCookieOptions failed: %v", err)
+	}
+	auth.Cookies = opts
+
+	// A TLS-terminating proxy in front of Caravan
+	req := httptest.NewRequest("GET", "http://caravan.example.com", nil)
+	req.Header.Set("X-Forwarded-Proto", "https, http")
+
+	cookie := auth.NewCookie(req, "name", "value", "/", 60)
+	if !cookie.Secure || cookie.SameSite != http.SameSiteNoneMode || cookie.Domain != "example.com" {
+		t.Errorf("Expected a secure SameSite=None cookie for example.com, got %+v", cookie)
+	}
+
+	// Plain HTTP, as in development: SameSite=None would be dropped
+	req = httptest.NewRequest("GET", localhostOrigin, nil)
+
+	cookie = auth.NewCookie(req, "name", "value", "/", 60)
+	if cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("Expected an insecure SameSite=Lax cookie, got %+v", cookie)
+	}
+
+	auth.Cookies.Secure = auth.SecureAlways
+	if !auth.NewCookie(req, "name", "value", "/", 60).Secure {
+		t.Error("Expected cookies to always be secure")
+	}
+
+	w := httptest.NewRecorder()
+	auth.SetTokenCookie(w, req, "test-cluster", "test-token", "")
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge != 3600 {
+		t.Errorf("Expected the token cookie to be kept for the configured TTL, got %+v", cookies)
+	}
+}
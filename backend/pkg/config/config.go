@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/knadh/koanf"
 	"github.com/knadh/koanf/providers/basicflag"
@@ -19,6 +20,12 @@ import (
 const (
 	defaultPort = 4466
 	osWindows   = "windows"
+
+	// Streams, exec sessions and blocking queries don't count against it
+	defaultMaxUpstreamRequests = 64
+
+	defaultPageSize    = 500
+	defaultMaxListSize = 10000
 )
 
 type Config struct {
@@ -31,12 +38,53 @@ type Config struct {
 	Port                  uint   `koanf:"port"`
 	StaticDir             string `koanf:"html-static-dir"`
 	PluginsDir            string `koanf:"plugins-dir"`
+	StaticPluginsDir      string `koanf:"static-plugins-dir"`
 	UserPluginsDir        string `koanf:"user-plugins-dir"`
 	BaseURL               string `koanf:"base-url"`
 	ProxyURLs             string `koanf:"proxy-urls"`
+	TrustedProxies        string `koanf:"trusted-proxies"`
+	WSCompression         bool   `koanf:"ws-compression"`
+	EnableGraphQL         bool   `koanf:"enable-graphql"`
+	FreezeWindowsFile     string `koanf:"freeze-windows-file"`
+	ExecPolicyFile        string `koanf:"exec-policy-file"`
+	FileTransferMaxBytes  int64  `koanf:"file-transfer-max-bytes"`
+	LintRulesFile         string `koanf:"lint-rules-file"`
+	NamespaceScopesFile   string `koanf:"namespace-scopes-file"`
+	RequireApprovals      bool   `koanf:"require-approvals"`
+	DryRun                bool   `koanf:"dry-run"`
+	DisabledFeatures      string `koanf:"disabled-features"`
+	DisplayTimezone       string `koanf:"display-timezone"`
+	Demo                  bool   `koanf:"demo"`
+	// Upstream requests
+	MaxUpstreamRequests int           `koanf:"max-upstream-requests"`
+	ResponseCacheTTL    time.Duration `koanf:"response-cache-ttl"`
+	DefaultPageSize     int           `koanf:"default-page-size"`
+	MaxListSize         int           `koanf:"max-list-size"`
+	// ClusterMetricsInterval is how often the per-cluster gauges of /metrics are refreshed
+	ClusterMetricsInterval time.Duration `koanf:"cluster-metrics-interval"`
+	// SlowRequestThreshold is how long a request may take before it is logged as slow
+	SlowRequestThreshold time.Duration `koanf:"slow-request-threshold"`
+	// StreamWriteTimeout is how long a write to an SSE stream or WebSocket may block
+	StreamWriteTimeout time.Duration `koanf:"stream-write-timeout"`
+	// Storage
+	DataDir                   string        `koanf:"data-dir"`
+	Store                     string        `koanf:"store"`
+	StoreDSN                  string        `koanf:"store-dsn"`
+	StoreEncryptionKey        string        `koanf:"store-encryption-key"`
+	StorePreviousKeys         string        `koanf:"store-previous-keys"`
+	DeploymentHistoryInterval time.Duration `koanf:"deployment-history-interval"`
+	DeploymentWatchInterval   time.Duration `koanf:"deployment-watch-interval"`
+	JobHistoryRetention       time.Duration `koanf:"job-history-retention"`
 	// TLS config
 	TLSCertPath string `koanf:"tls-cert-path"`
 	TLSKeyPath  string `koanf:"tls-key-path"`
+	// Cookies
+	CookieSecure   string        `koanf:"cookie-secure"`
+	CookieSameSite string        `koanf:"cookie-samesite"`
+	CookieDomain   string        `koanf:"cookie-domain"`
+	CookieTTL      time.Duration `koanf:"cookie-ttl"`
+	// Args are the arguments left after the flags, such as those of a subcommand
+	Args []string `koanf:"-"`
 }
 
 func (c *Config) Validate() error {
@@ -44,6 +92,26 @@ func (c *Config) Validate() error {
 		return errors.New("base-url needs to start with a '/' or be empty")
 	}
 
+	if c.DisplayTimezone != "" {
+		if _, err := time.LoadLocation(c.DisplayTimezone); err != nil {
+			return fmt.Errorf("display-timezone: %w", err)
+		}
+	}
+
+	if c.DefaultPageSize < 1 || c.MaxListSize > 0 && c.DefaultPageSize > c.MaxListSize {
+		return errors.New("default-page-size must be positive and at most max-list-size")
+	}
+
+	if c.StaticPluginsDir != "" {
+		info, err := os.Stat(c.StaticPluginsDir)
+		if err != nil {
+			return fmt.Errorf("static-plugins-dir: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("static-plugins-dir %q is not a directory", c.StaticPluginsDir)
+		}
+	}
+
 	return nil
 }
 
@@ -168,6 +236,8 @@ func Parse(args []string) (*Config, error) {
 		return nil, err
 	}
 
+	config.Args = f.Args()
+
 	// 7. Validate parsed config.
 	if err := config.Validate(); err != nil {
 		logger.Log(logger.LevelError, nil, err, "validating config")
@@ -182,6 +252,8 @@ func flagset() *flag.FlagSet {
 
 	addGeneralFlags(f)
 	addTLSFlags(f)
+	addStorageFlags(f)
+	addCookieFlags(f)
 
 	return f
 }
@@ -196,10 +268,73 @@ func addGeneralFlags(f *flag.FlagSet) {
 	f.String("html-static-dir", "", "Static HTML directory to serve")
 	f.String("plugins-dir", defaultPluginDir(), "Specify the plugins directory to build the backend with")
 	f.String("user-plugins-dir", defaultUserPluginDir(), "Specify the user-installed plugins directory")
+	// CARAVAN_STATIC_PLUGINS_DIR predates the flag and is still honored
+	f.String("static-plugins-dir", os.Getenv("CARAVAN_STATIC_PLUGINS_DIR"),
+		"Directory of the plugins shipped with Caravan, served read-only under /static-plugins/")
 	f.String("base-url", "", "Base URL path. eg. /caravan")
 	f.String("listen-addr", "", "Address to listen on; default is empty, which means listening to any address")
 	f.Uint("port", defaultPort, "Port to listen from")
 	f.String("proxy-urls", "", "Allow proxy requests to specified URLs")
+	f.String("trusted-proxies", "",
+		"Comma-separated addresses and CIDRs of reverse proxies whose X-Forwarded-For and X-Forwarded-Prefix are honored")
+	f.Bool("ws-compression", false, "Negotiate permessage-deflate compression on WebSocket connections")
+	f.Bool("enable-graphql", false, "Serve the GraphQL API at /api/graphql")
+	f.Bool("require-approvals", false,
+		"Require a second user to approve destructive actions such as purges and ACL deletes; needs ACLs enabled")
+	f.Bool("dry-run", false, "Validate and plan mutating requests but never send writes to Nomad")
+	f.String("disabled-features", "",
+		"Comma-separated features to start switched off: events, logs, aggregation; managers can toggle them at runtime")
+	f.String("display-timezone", "",
+		"IANA time zone the UI shows times in, such as Europe/Berlin; empty uses each browser's own")
+	f.String("freeze-windows-file", "", "JSON file with freeze windows during which changes to clusters are blocked")
+	f.String("exec-policy-file", "",
+		"JSON file restricting exec commands, with preset commands and the shell forced in each namespace")
+	f.Int64("file-transfer-max-bytes", 32<<20, "Largest file that may be uploaded to or downloaded from a task")
+	f.String("lint-rules-file", "", "JSON file overriding the built-in job lint rules and adding custom ones")
+	f.String("namespace-scopes-file", "",
+		"JSON file mapping token policies and roles to the namespaces they may use through Caravan")
+	f.Bool("demo", false, "Add a simulated cluster with sample jobs and live activity, no Nomad needed")
+	f.Int("max-upstream-requests", defaultMaxUpstreamRequests,
+		"Maximum concurrent requests to each Nomad cluster; more are queued. 0 disables the limit")
+	f.Duration("response-cache-ttl", 2*time.Second,
+		"How long job, node and namespace lists are cached per token; 0 disables the cache")
+	f.Int("default-page-size", defaultPageSize, "Page size of paginated lists that do not ask for one with per_page")
+	f.Int("max-list-size", defaultMaxListSize,
+		"Most items a list returns without pagination, and the largest page; larger lists are refused with 413. 0 disables the limit")
+	f.Duration("cluster-metrics-interval", 0,
+		"How often to refresh the per-cluster job, allocation, node and evaluation gauges of /metrics; 0 disables them")
+	f.Duration("slow-request-threshold", 3*time.Second,
+		"Log requests taking longer with a warning giving their cluster and time spent in Nomad; 0 disables the warnings")
+	f.Duration("stream-write-timeout", 10*time.Second,
+		"How long a write to a log, event or exec stream may block before its client is taken to have stopped reading and is dropped")
+}
+
+func addStorageFlags(f *flag.FlagSet) {
+	f.String("data-dir", "", "Directory to persist Caravan data in; data is kept in memory if empty")
+	f.String("store", "",
+		"Where to persist Caravan data: memory, file, sqlite (needs a cgo build) or postgres; "+
+			"empty uses files in data-dir, or memory without it")
+	f.String("store-dsn", "",
+		"Postgres connection string of the postgres store, or database path of the sqlite store (default <data-dir>/caravan.db)")
+	f.String("store-encryption-key", "",
+		"Master key encrypting the stored secrets, such as tokens: a base64 32-byte key, or vault-transit:<mount>/<key> "+
+			"for a Vault Transit key reached with VAULT_ADDR and VAULT_TOKEN; secrets are stored in plain if empty")
+	f.String("store-previous-keys", "",
+		"Comma-separated master keys rotated out, still decrypting the secrets until caravan rotate-key re-encrypts them")
+	f.Duration("deployment-history-interval", 5*time.Minute,
+		"How often to poll all clusters for finished deployments the event stream missed; 0 disables tracking")
+	f.Duration("deployment-watch-interval", 30*time.Second,
+		"How often to check the deployments of jobs with auto-promote or auto-fail settings; 0 disables the watcher")
+	f.Duration("job-history-retention", 90*24*time.Hour,
+		"How long job registrations seen in the event stream are kept, past Nomad's garbage collection; 0 disables tracking")
+}
+
+func addCookieFlags(f *flag.FlagSet) {
+	f.String("cookie-secure", "auto",
+		"When to mark cookies Secure: auto (requests over TLS, directly or via X-Forwarded-Proto), always or never")
+	f.String("cookie-samesite", "auto", "SameSite of cookies: auto (Strict when secure, else Lax), lax, strict or none")
+	f.String("cookie-domain", "", "Domain of cookies, to share them with subdomains; host-only if empty")
+	f.Duration("cookie-ttl", 24*time.Hour, "How long authentication cookies are kept")
 }
 
 func addTLSFlags(f *flag.FlagSet) {
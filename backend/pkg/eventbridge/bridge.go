@@ -0,0 +1,358 @@
+// Package eventbridge forwards Nomad events to external systems.
+//
+// A Forward subscribes to a cluster's event stream for a set of topics and
+// delivers every batch of matching events to a Sink. The Bridge owns all
+// running forwards and restarts their subscriptions when the stream drops,
+// resuming from the last delivered index.
+package eventbridge
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/hashicorp/nomad/api"
+)
+
+const (
+	// sendAttempts is how often a batch is offered to a sink before it is dropped.
+	sendAttempts = 3
+	// minBackoff and maxBackoff bound the delay between reconnects and retries.
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Event is a Nomad event as delivered to sinks.
+type Event struct {
+	Cluster string                 `json:"cluster"`
+	Topic   string                 `json:"topic"`
+	Type    string                 `json:"type"`
+	Key     string                 `json:"key"`
+	Index   uint64                 `json:"index"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+}
+
+// Forward configures which events of a cluster are forwarded to which sink.
+type Forward struct {
+	ID string `json:"id"`
+	// Topics uses the Nomad CLI syntax: "Job" for all job events or
+	// "Job:example" for the events of a single key. Empty means all topics.
+	Topics []string `json:"topics"`
+	// Namespace restricts the stream to one namespace, "*" for all.
+	Namespace string     `json:"namespace,omitempty"`
+	Sink      SinkConfig `json:"sink"`
+}
+
+// ParseTopics converts the topic filters of a forward to the form expected by
+// the Nomad event stream API.
+func ParseTopics(filters []string) (map[api.Topic][]string, error) {
+	topics := make(map[api.Topic][]string)
+
+	if len(filters) == 0 {
+		topics[api.TopicAll] = []string{"*"}
+		return topics, nil
+	}
+
+	for _, filter := range filters {
+		topic, key, found := strings.Cut(strings.TrimSpace(filter), ":")
+		if topic == "" {
+			return nil, fmt.Errorf("invalid topic filter %q", filter)
+		}
+
+		if !found || key == "" {
+			key = "*"
+		}
+
+		topics[api.Topic(topic)] = append(topics[api.Topic(topic)], key)
+	}
+
+	return topics, nil
+}
+
+// Subscriber opens an event stream for the given topics starting at index.
+type Subscriber func(ctx context.Context, topics map[api.Topic][]string, index uint64) (<-chan *api.Events, error)
+
+// Status reports the state of a running forward.
+type Status struct {
+	Forward
+	Cluster   string    `json:"cluster"`
+	Connected bool      `json:"connected"`
+	Forwarded uint64    `json:"forwarded"`
+	Dropped   uint64    `json:"dropped"`
+	LastIndex uint64    `json:"lastIndex"`
+	LastError string    `json:"lastError,omitempty"`
+	LastSent  time.Time `json:"lastSent,omitempty"`
+}
+
+type forwarder struct {
+	cluster   string
+	fwd       Forward
+	topics    map[api.Topic][]string
+	sink      Sink
+	subscribe Subscriber
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Bridge manages the forwards of all clusters.
+type Bridge struct {
+	mu         sync.Mutex
+	forwarders map[string]*forwarder
+}
+
+// New creates an empty bridge.
+func New() *Bridge {
+	return &Bridge{forwarders: make(map[string]*forwarder)}
+}
+
+func forwarderKey(cluster, id string) string {
+	return cluster + "/" + id
+}
+
+// Start runs fwd for cluster, replacing a forward with the same ID.
+func (b *Bridge) Start(cluster string, fwd Forward, subscribe Subscriber) error {
+	if fwd.ID == "" {
+		return fmt.Errorf("forward id is required")
+	}
+
+	topics, err := ParseTopics(fwd.Topics)
+	if err != nil {
+		return err
+	}
+
+	sink, err := NewSink(fwd.Sink)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &forwarder{
+		cluster:   cluster,
+		fwd:       fwd,
+		topics:    topics,
+		sink:      sink,
+		subscribe: subscribe,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		status:    Status{Forward: fwd, Cluster: cluster},
+	}
+
+	b.mu.Lock()
+	old := b.forwarders[forwarderKey(cluster, fwd.ID)]
+	b.forwarders[forwarderKey(cluster, fwd.ID)] = f
+	b.mu.Unlock()
+
+	if old != nil {
+		old.stop()
+	}
+
+	go f.run(ctx)
+
+	return nil
+}
+
+// Stop stops and removes a forward. It returns false if there was none.
+func (b *Bridge) Stop(cluster, id string) bool {
+	b.mu.Lock()
+	f, ok := b.forwarders[forwarderKey(cluster, id)]
+	delete(b.forwarders, forwarderKey(cluster, id))
+	b.mu.Unlock()
+
+	if ok {
+		f.stop()
+	}
+
+	return ok
+}
+
+// List returns the status of the forwards of a cluster, sorted by ID.
+func (b *Bridge) List(cluster string) []Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	statuses := []Status{}
+
+	for _, f := range b.forwarders {
+		if f.cluster == cluster {
+			statuses = append(statuses, f.snapshot())
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+
+	return statuses
+}
+
+// Close stops all forwards.
+func (b *Bridge) Close() {
+	b.mu.Lock()
+	forwarders := b.forwarders
+	b.forwarders = make(map[string]*forwarder)
+	b.mu.Unlock()
+
+	for _, f := range forwarders {
+		f.stop()
+	}
+}
+
+func (f *forwarder) stop() {
+	f.cancel()
+	<-f.done
+
+	if err := f.sink.Close(); err != nil {
+		logger.Log(logger.LevelWarn, map[string]string{"cluster": f.cluster, "forward": f.fwd.ID},
+			err, "closing event sink")
+	}
+}
+
+func (f *forwarder) snapshot() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.status
+}
+
+func (f *forwarder) update(fn func(s *Status)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fn(&f.status)
+}
+
+// run subscribes to the event stream and forwards events until ctx is done,
+// reconnecting with exponential backoff when the stream fails.
+func (f *forwarder) run(ctx context.Context) {
+	defer close(f.done)
+
+	backoff := minBackoff
+
+	for {
+		err := f.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		f.update(func(s *Status) {
+			s.Connected = false
+			if err != nil {
+				s.LastError = err.Error()
+			}
+		})
+
+		logger.Log(logger.LevelWarn, map[string]string{"cluster": f.cluster, "forward": f.fwd.ID},
+			err, "event forward stream ended, reconnecting")
+
+		if !sleep(ctx, backoff) {
+			return
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (f *forwarder) stream(ctx context.Context) error {
+	index := f.snapshot().LastIndex
+	if index > 0 {
+		index++
+	}
+
+	eventsCh, err := f.subscribe(ctx, f.topics, index)
+	if err != nil {
+		return err
+	}
+
+	f.update(func(s *Status) { s.Connected = true })
+
+	for {
+		select {
+		case events, ok := <-eventsCh:
+			if !ok {
+				return fmt.Errorf("event stream closed")
+			}
+
+			if events.Err != nil {
+				return events.Err
+			}
+
+			// Nomad sends empty heartbeat frames to keep the stream open.
+			if len(events.Events) == 0 {
+				continue
+			}
+
+			f.deliver(ctx, events)
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// deliver sends a batch to the sink, retrying a few times before dropping it
+// so a sink outage does not stall the Nomad stream indefinitely.
+func (f *forwarder) deliver(ctx context.Context, events *api.Events) {
+	batch := make([]Event, 0, len(events.Events))
+	for _, e := range events.Events {
+		batch = append(batch, Event{
+			Cluster: f.cluster,
+			Topic:   string(e.Topic),
+			Type:    e.Type,
+			Key:     e.Key,
+			Index:   e.Index,
+			Payload: e.Payload,
+		})
+	}
+
+	var err error
+
+	backoff := minBackoff
+
+	for attempt := 0; attempt < sendAttempts; attempt++ {
+		if err = f.sink.Send(ctx, batch); err == nil {
+			break
+		}
+
+		if attempt < sendAttempts-1 && !sleep(ctx, backoff) {
+			return
+		}
+
+		backoff *= 2
+	}
+
+	f.update(func(s *Status) {
+		s.LastIndex = events.Index
+		if err != nil {
+			s.Dropped += uint64(len(batch))
+			s.LastError = err.Error()
+			return
+		}
+
+		s.Forwarded += uint64(len(batch))
+		s.LastSent = time.Now()
+	})
+
+	if err != nil {
+		logger.Log(logger.LevelError, map[string]string{"cluster": f.cluster, "forward": f.fwd.ID},
+			err, "dropping events after failed deliveries")
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
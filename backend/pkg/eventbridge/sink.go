@@ -0,0 +1,172 @@
+package eventbridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SinkConfig describes where forwarded events are delivered.
+type SinkConfig struct {
+	// Type selects the sink implementation, e.g. "webhook".
+	Type string `json:"type"`
+	// URL is the endpoint (webhook) or broker address of the sink.
+	URL string `json:"url"`
+	// Topic is the destination topic or subject for message broker sinks.
+	Topic string `json:"topic,omitempty"`
+	// Headers are added to every request made by HTTP based sinks.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// redactedValue replaces secrets in the sink configurations shown to users.
+const redactedValue = "redacted"
+
+// Redacted returns the configuration without its secrets, for showing it:
+// the values of the headers, which carry the sink's credentials, and the
+// password of the URL.
+func (c SinkConfig) Redacted() SinkConfig {
+	if u, err := url.Parse(c.URL); err == nil {
+		c.URL = u.Redacted()
+	}
+
+	if c.Headers != nil {
+		headers := make(map[string]string, len(c.Headers))
+		for name := range c.Headers {
+			headers[name] = redactedValue
+		}
+		c.Headers = headers
+	}
+
+	return c
+}
+
+// Sink delivers batches of events to an external system.
+type Sink interface {
+	// Send delivers events. Returning an error makes the forwarder retry the batch.
+	Send(ctx context.Context, events []Event) error
+	// Close releases the resources held by the sink.
+	Close() error
+}
+
+// SinkFactory creates a Sink from its configuration.
+type SinkFactory func(cfg SinkConfig) (Sink, error)
+
+var (
+	sinkFactories = map[string]SinkFactory{
+		"webhook": NewWebhookSink,
+	}
+	sinkFactoriesMutex sync.RWMutex
+)
+
+// RegisterSink makes a sink type available to forwarders. Message broker sinks
+// such as Kafka or NATS are registered this way by builds that include their
+// client libraries.
+func RegisterSink(typ string, factory SinkFactory) {
+	sinkFactoriesMutex.Lock()
+	defer sinkFactoriesMutex.Unlock()
+
+	sinkFactories[typ] = factory
+}
+
+// SinkTypes returns the registered sink types, sorted.
+func SinkTypes() []string {
+	sinkFactoriesMutex.RLock()
+	defer sinkFactoriesMutex.RUnlock()
+
+	types := make([]string, 0, len(sinkFactories))
+	for typ := range sinkFactories {
+		types = append(types, typ)
+	}
+
+	sort.Strings(types)
+
+	return types
+}
+
+// NewSink creates a sink of the configured type.
+func NewSink(cfg SinkConfig) (Sink, error) {
+	sinkFactoriesMutex.RLock()
+	factory, ok := sinkFactories[cfg.Type]
+	sinkFactoriesMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown sink type %q, available: %v", cfg.Type, SinkTypes())
+	}
+
+	return factory(cfg)
+}
+
+// WebhookTimeout bounds a single webhook delivery.
+const WebhookTimeout = 10 * time.Second
+
+// WebhookSink POSTs batches of events as JSON to an HTTP endpoint.
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// webhookPayload is the body sent to webhook endpoints.
+type webhookPayload struct {
+	Events []Event `json:"events"`
+}
+
+// NewWebhookSink creates a webhook sink.
+func NewWebhookSink(cfg SinkConfig) (Sink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhook sink requires a url")
+	}
+
+	return &WebhookSink{
+		url:     cfg.URL,
+		headers: cfg.Headers,
+		client:  &http.Client{Timeout: WebhookTimeout},
+	}, nil
+}
+
+// Send implements Sink.
+func (s *WebhookSink) Send(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(webhookPayload{Events: events})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so the connection can be reused.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Close implements Sink.
+func (s *WebhookSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
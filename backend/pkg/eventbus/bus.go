@@ -0,0 +1,209 @@
+// Package eventbus shares the Nomad event stream of each cluster between the
+// components that keep state derived from it, such as the response cache or
+// the deployment history.
+//
+// The bus multiplexes a single upstream stream per cluster to any number of
+// subscribers, so each component reacts to changes the same way instead of
+// running its own stream or polling loop. The stream is opened when a cluster
+// gets its first subscriber and closed after the last one leaves.
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// Source opens the event stream of a cluster, starting after index. The bus
+// asks for every topic and lets Nomad drop the events the token cannot read.
+type Source func(ctx context.Context, cluster string, topics map[api.Topic][]string,
+	index uint64) (<-chan *api.Events, error)
+
+// Handler is called with each event of the topics it subscribed to. Handlers
+// run on the cluster's stream, so they must return quickly.
+type Handler func(event api.Event)
+
+// Bus delivers the events of each cluster to its subscribers.
+type Bus struct {
+	source Source
+
+	mu     sync.Mutex
+	feeds  map[string]*feed
+	nextID int
+}
+
+type subscription struct {
+	topics map[api.Topic]bool
+	fn     Handler
+}
+
+// feed follows the stream of one cluster
+type feed struct {
+	cancel context.CancelFunc
+	subs   map[int]subscription
+}
+
+// New creates a bus that opens cluster streams with source.
+func New(source Source) *Bus {
+	return &Bus{
+		source: source,
+		feeds:  make(map[string]*feed),
+	}
+}
+
+// Subscribe calls fn with the cluster's events of the given topics, or of all
+// topics if none are given, until unsubscribe is called. If the cluster has no
+// stream yet, it starts after index after, so a subscriber that has just read
+// state at that index misses no change to it.
+func (b *Bus) Subscribe(cluster string, topics []api.Topic, after uint64, fn Handler) (unsubscribe func()) {
+	sub := subscription{fn: fn}
+	if len(topics) > 0 {
+		sub.topics = make(map[api.Topic]bool, len(topics))
+		for _, topic := range topics {
+			sub.topics[topic] = true
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	f, ok := b.feeds[cluster]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		f = &feed{cancel: cancel, subs: make(map[int]subscription)}
+		b.feeds[cluster] = f
+
+		go b.follow(ctx, cluster, after)
+	}
+
+	id := b.nextID
+	b.nextID++
+	f.subs[id] = sub
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() { b.unsubscribe(cluster, f, id) })
+	}
+}
+
+func (b *Bus) unsubscribe(cluster string, f *feed, id int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(f.subs, id)
+
+	if len(f.subs) == 0 && b.feeds[cluster] == f {
+		f.cancel()
+		delete(b.feeds, cluster)
+	}
+}
+
+// Publish delivers events to the subscribers of a cluster. The bus publishes
+// what it reads from its streams; other components holding events, and
+// tests, can publish too.
+func (b *Bus) Publish(cluster string, events []api.Event) {
+	b.mu.Lock()
+	f, ok := b.feeds[cluster]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+
+	subs := make([]subscription, 0, len(f.subs))
+	for _, sub := range f.subs {
+		subs = append(subs, sub)
+	}
+	b.mu.Unlock()
+
+	for _, event := range events {
+		for _, sub := range subs {
+			if sub.topics == nil || sub.topics[event.Topic] {
+				sub.fn(event)
+			}
+		}
+	}
+}
+
+// Close stops the streams of all clusters.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for cluster, f := range b.feeds {
+		f.cancel()
+		delete(b.feeds, cluster)
+	}
+}
+
+// follow publishes the events of a cluster until ctx is done, reconnecting
+// with exponential backoff and resuming after the last index it saw.
+func (b *Bus) follow(ctx context.Context, cluster string, index uint64) {
+	backoff := minBackoff
+
+	for {
+		err := b.stream(ctx, cluster, &index)
+		if ctx.Err() != nil {
+			return
+		}
+
+		logger.Log(logger.LevelWarn, map[string]string{"cluster": cluster}, err,
+			"event bus stream ended, reconnecting")
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (b *Bus) stream(ctx context.Context, cluster string, index *uint64) error {
+	start := *index
+	if start > 0 {
+		start++
+	}
+
+	eventsCh, err := b.source(ctx, cluster, map[api.Topic][]string{api.TopicAll: {"*"}}, start)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case events, ok := <-eventsCh:
+			if !ok {
+				return errors.New("event stream closed")
+			}
+
+			if events.Err != nil {
+				return events.Err
+			}
+
+			// Nomad sends empty heartbeat frames to keep the stream open.
+			if len(events.Events) == 0 {
+				continue
+			}
+
+			if events.Index > *index {
+				*index = events.Index
+			}
+
+			b.Publish(cluster, events.Events)
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
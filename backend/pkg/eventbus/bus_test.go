@@ -0,0 +1,117 @@
+package eventbus_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/eventbus"
+	"github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSource hands out one stream per call and reports the index it was opened at
+type fakeSource struct {
+	streams chan chan *api.Events
+	opened  chan uint64
+	closed  chan struct{}
+}
+
+func newFakeSource() *fakeSource {
+	return &fakeSource{
+		streams: make(chan chan *api.Events, 4),
+		opened:  make(chan uint64, 4),
+		closed:  make(chan struct{}, 4),
+	}
+}
+
+func (s *fakeSource) open(ctx context.Context, _ string, topics map[api.Topic][]string,
+	index uint64,
+) (<-chan *api.Events, error) {
+	ch := make(chan *api.Events, 4)
+	s.streams <- ch
+	s.opened <- index
+
+	go func() {
+		<-ctx.Done()
+		s.closed <- struct{}{}
+	}()
+
+	return ch, nil
+}
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+		return *new(T)
+	}
+}
+
+func TestSubscribersGetTheirTopics(t *testing.T) {
+	source := newFakeSource()
+	bus := eventbus.New(source.open)
+	defer bus.Close()
+
+	jobs := make(chan api.Event, 4)
+	all := make(chan api.Event, 4)
+
+	unsubscribeJobs := bus.Subscribe("prod", []api.Topic{api.TopicJob}, 10, func(e api.Event) { jobs <- e })
+	defer unsubscribeJobs()
+	unsubscribeAll := bus.Subscribe("prod", nil, 0, func(e api.Event) { all <- e })
+	defer unsubscribeAll()
+
+	assert.Equal(t, uint64(11), receive(t, source.opened), "the stream starts after the first subscriber's index")
+
+	stream := receive(t, source.streams)
+	stream <- &api.Events{Index: 12, Events: []api.Event{
+		{Topic: api.TopicNode, Key: "node-1", Index: 12},
+		{Topic: api.TopicJob, Key: "web", Index: 12},
+	}}
+
+	assert.Equal(t, "web", receive(t, jobs).Key)
+	assert.Equal(t, "node-1", receive(t, all).Key)
+	assert.Equal(t, "web", receive(t, all).Key)
+	assert.Empty(t, jobs)
+}
+
+func TestStreamClosesWithLastSubscriber(t *testing.T) {
+	source := newFakeSource()
+	bus := eventbus.New(source.open)
+	defer bus.Close()
+
+	first := bus.Subscribe("prod", nil, 0, func(api.Event) {})
+	second := bus.Subscribe("prod", nil, 0, func(api.Event) {})
+	receive(t, source.streams)
+
+	first()
+	first()
+	assert.Empty(t, source.closed)
+
+	second()
+	receive(t, source.closed)
+}
+
+func TestStreamResumesAfterLastIndex(t *testing.T) {
+	source := newFakeSource()
+	bus := eventbus.New(source.open)
+	defer bus.Close()
+
+	events := make(chan api.Event, 4)
+	unsubscribe := bus.Subscribe("prod", nil, 0, func(e api.Event) { events <- e })
+	defer unsubscribe()
+
+	require.Equal(t, uint64(0), receive(t, source.opened))
+
+	stream := receive(t, source.streams)
+	stream <- &api.Events{Index: 7, Events: []api.Event{{Topic: api.TopicJob, Key: "web", Index: 7}}}
+	receive(t, events)
+	close(stream)
+
+	assert.Equal(t, uint64(8), receive(t, source.opened))
+}
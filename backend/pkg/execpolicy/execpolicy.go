@@ -0,0 +1,187 @@
+// Package execpolicy restricts the commands users may exec into allocations.
+//
+// A policy allows or denies commands by their program, offers preset commands
+// for the UI's quick actions and can force the shell interactive sessions use
+// in a namespace, e.g. because its images only ship /bin/bash.
+package execpolicy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// shells are the programs treated as interactive shells. In a namespace with
+// a forced shell, they are replaced by it.
+var shells = map[string]bool{
+	"sh":   true,
+	"ash":  true,
+	"bash": true,
+	"dash": true,
+	"zsh":  true,
+	"ksh":  true,
+	"fish": true,
+}
+
+// Preset is a command offered as a quick action in the exec terminal.
+type Preset struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Command is split on spaces, as commands given to exec are.
+	Command string `json:"command"`
+	// Namespaces limit where the preset is offered, empty means all.
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Config is the content of an exec policy file.
+type Config struct {
+	// Allow lists the programs that may be run, empty allows all. Entries
+	// match the full path or the base name of the program and may be glob
+	// patterns such as "/usr/bin/*".
+	Allow []string `json:"allow,omitempty"`
+	// Deny lists programs that may never be run. It wins over Allow.
+	Deny    []string `json:"deny,omitempty"`
+	Presets []Preset `json:"presets,omitempty"`
+	// Shells maps namespaces to the shell sessions in them must use. "*"
+	// applies to namespaces without an entry of their own.
+	Shells map[string]string `json:"shells,omitempty"`
+}
+
+// Policy is a validated exec policy. A nil policy allows everything.
+type Policy struct {
+	cfg Config
+}
+
+// ErrDenied is returned for commands the policy does not allow.
+var ErrDenied = errors.New("command is not allowed by the exec policy")
+
+// Load reads a policy from a JSON file.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading exec policy: %w", err)
+	}
+
+	return Parse(data)
+}
+
+// Parse parses and validates a JSON policy.
+func Parse(data []byte) (*Policy, error) {
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing exec policy: %w", err)
+	}
+
+	return New(cfg)
+}
+
+// New validates cfg and creates a policy from it.
+func New(cfg Config) (*Policy, error) {
+	for _, pattern := range append(append([]string{}, cfg.Allow...), cfg.Deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid command pattern %q: %w", pattern, err)
+		}
+	}
+
+	p := &Policy{cfg: cfg}
+
+	for namespace, shell := range cfg.Shells {
+		if shell == "" {
+			return nil, fmt.Errorf("empty shell for namespace %q", namespace)
+		}
+
+		if err := p.Check([]string{shell}); err != nil {
+			return nil, fmt.Errorf("shell %q of namespace %q: %w", shell, namespace, err)
+		}
+	}
+
+	for i, preset := range cfg.Presets {
+		if preset.Name == "" {
+			return nil, fmt.Errorf("preset %d has no name", i)
+		}
+
+		if err := p.Check(strings.Fields(preset.Command)); err != nil {
+			return nil, fmt.Errorf("preset %s: %w", preset.Name, err)
+		}
+	}
+
+	return p, nil
+}
+
+// Check returns ErrDenied if the policy does not allow command.
+func (p *Policy) Check(command []string) error {
+	if p == nil {
+		return nil
+	}
+
+	if len(command) == 0 || command[0] == "" {
+		return fmt.Errorf("%w: empty command", ErrDenied)
+	}
+
+	program := command[0]
+
+	if matchAny(p.cfg.Deny, program) {
+		return fmt.Errorf("%w: %s", ErrDenied, program)
+	}
+
+	if len(p.cfg.Allow) > 0 && !matchAny(p.cfg.Allow, program) {
+		return fmt.Errorf("%w: %s", ErrDenied, program)
+	}
+
+	return nil
+}
+
+// Command returns the command to run in a namespace for the one requested:
+// in a namespace with a forced shell, sessions that ask for no command or for
+// another shell get the forced one. The result is not checked.
+func (p *Policy) Command(namespace string, command []string) []string {
+	shell := p.Shell(namespace)
+	if shell == "" {
+		return command
+	}
+
+	if len(command) == 0 || command[0] == "" || shells[path.Base(command[0])] {
+		return []string{shell}
+	}
+
+	return command
+}
+
+// Shell returns the shell forced in a namespace, "" if there is none.
+func (p *Policy) Shell(namespace string) string {
+	if p == nil {
+		return ""
+	}
+
+	if shell, ok := p.cfg.Shells[namespace]; ok {
+		return shell
+	}
+
+	return p.cfg.Shells["*"]
+}
+
+// Presets returns the preset commands, for all namespaces.
+func (p *Policy) Presets() []Preset {
+	if p == nil {
+		return nil
+	}
+
+	return p.cfg.Presets
+}
+
+func matchAny(patterns []string, program string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, program); ok {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, path.Base(program)); ok {
+			return true
+		}
+	}
+
+	return false
+}
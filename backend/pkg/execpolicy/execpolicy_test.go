@@ -0,0 +1,51 @@
+package execpolicy_test
+
+import (
+	"testing"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/execpolicy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowAndDeny(t *testing.T) {
+	p, err := execpolicy.Parse([]byte(`{
+		"allow": ["/bin/*", "cat", "ls"],
+		"deny": ["rm"]
+	}`))
+	require.NoError(t, err)
+
+	assert.NoError(t, p.Check([]string{"/bin/sh"}))
+	assert.NoError(t, p.Check([]string{"/usr/bin/cat", "/etc/hosts"}), "base names match any path")
+	assert.ErrorIs(t, p.Check([]string{"/bin/rm", "-rf", "/"}), execpolicy.ErrDenied, "deny wins over allow")
+	assert.ErrorIs(t, p.Check([]string{"/usr/bin/python3"}), execpolicy.ErrDenied)
+	assert.ErrorIs(t, p.Check(nil), execpolicy.ErrDenied)
+}
+
+func TestForcedShell(t *testing.T) {
+	p, err := execpolicy.Parse([]byte(`{
+		"shells": {"legacy": "/bin/bash", "*": "/bin/sh"}
+	}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"/bin/bash"}, p.Command("legacy", nil))
+	assert.Equal(t, []string{"/bin/bash"}, p.Command("legacy", []string{"/bin/zsh"}))
+	assert.Equal(t, []string{"/bin/sh"}, p.Command("default", []string{"bash"}))
+	assert.Equal(t, []string{"ls", "-l"}, p.Command("legacy", []string{"ls", "-l"}), "other commands run as given")
+}
+
+func TestPresetsMustBeAllowed(t *testing.T) {
+	_, err := execpolicy.Parse([]byte(`{
+		"allow": ["sh"],
+		"presets": [{"name": "Disk usage", "command": "df -h"}]
+	}`))
+	assert.ErrorIs(t, err, execpolicy.ErrDenied)
+}
+
+func TestNilPolicyAllowsAll(t *testing.T) {
+	var p *execpolicy.Policy
+
+	assert.NoError(t, p.Check([]string{"rm", "-rf", "/"}))
+	assert.Equal(t, []string{"bash"}, p.Command("default", []string{"bash"}))
+	assert.Empty(t, p.Presets())
+}
@@ -0,0 +1,216 @@
+// Package fanout runs independent calls concurrently with a bounded level of
+// parallelism, an optional per-call timeout and partial-failure semantics.
+//
+// It is used by the aggregate endpoints (composite detail views, multi-cluster
+// listings, batch operations) so that every one of them limits concurrency and
+// reports failures the same way: a failing call never hides the results of the
+// calls that succeeded.
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLimit is the number of calls run concurrently when Options.Limit is not set.
+const DefaultLimit = 8
+
+// Options configures how calls are fanned out.
+type Options struct {
+	// Limit is the maximum number of calls running at the same time.
+	// Zero or a negative value means DefaultLimit.
+	Limit int
+	// Timeout bounds each individual call. Zero means no per-call timeout.
+	Timeout time.Duration
+	// FailFast cancels the calls that have not finished yet as soon as one fails.
+	FailFast bool
+}
+
+func (o Options) limit() int {
+	if o.Limit <= 0 {
+		return DefaultLimit
+	}
+
+	return o.Limit
+}
+
+// Group runs named calls concurrently. The zero value is not usable, use New.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	opts   Options
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+// New creates a Group whose calls derive their context from ctx.
+func New(ctx context.Context, opts Options) *Group {
+	ctx, cancel := context.WithCancel(ctx)
+
+	return &Group{
+		ctx:    ctx,
+		cancel: cancel,
+		opts:   opts,
+		sem:    make(chan struct{}, opts.limit()),
+		errs:   make(map[string]error),
+	}
+}
+
+// Go schedules fn under the given name. Names should be unique within a group,
+// they are used to report which call failed.
+func (g *Group) Go(name string, fn func(ctx context.Context) error) {
+	g.wg.Add(1)
+
+	go func() {
+		defer g.wg.Done()
+
+		if err := g.ctx.Err(); err != nil {
+			g.setErr(name, err)
+			return
+		}
+
+		select {
+		case g.sem <- struct{}{}:
+			defer func() { <-g.sem }()
+		case <-g.ctx.Done():
+			g.setErr(name, g.ctx.Err())
+			return
+		}
+
+		if err := g.call(fn); err != nil {
+			g.setErr(name, err)
+
+			if g.opts.FailFast {
+				g.cancel()
+			}
+		}
+	}()
+}
+
+// call runs fn with the per-call timeout applied and turns panics into errors,
+// so that a single misbehaving call cannot take down the whole request.
+func (g *Group) call(fn func(ctx context.Context) error) (err error) {
+	ctx := g.ctx
+
+	if g.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, g.opts.Timeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return fn(ctx)
+}
+
+func (g *Group) setErr(name string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.errs[name] = err
+}
+
+// Wait blocks until all scheduled calls have finished and returns the errors
+// keyed by call name. A nil map means every call succeeded.
+func (g *Group) Wait() Errors {
+	g.wg.Wait()
+	g.cancel()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.errs) == 0 {
+		return nil
+	}
+
+	return Errors(g.errs)
+}
+
+// Errors holds the failures of a group keyed by call name.
+type Errors map[string]error
+
+// Messages returns the error messages keyed by call name, suitable for
+// including in a JSON response next to the partial results.
+func (e Errors) Messages() map[string]string {
+	if len(e) == 0 {
+		return nil
+	}
+
+	msgs := make(map[string]string, len(e))
+	for name, err := range e {
+		msgs[name] = err.Error()
+	}
+
+	return msgs
+}
+
+// Err joins all errors into one, ordered by call name. It returns nil when
+// there are no errors.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	errs := make([]error, 0, len(names))
+	for _, name := range names {
+		errs = append(errs, fmt.Errorf("%s: %w", name, e[name]))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Result is the outcome of one call made by Map.
+type Result[T any] struct {
+	Value T
+	Err   error
+}
+
+// Map calls fn for every item concurrently and returns the results in the
+// same order as items. Each item gets its own Result, so callers can tell
+// exactly which items failed.
+func Map[In, Out any](ctx context.Context, items []In, opts Options,
+	fn func(ctx context.Context, item In) (Out, error),
+) []Result[Out] {
+	results := make([]Result[Out], len(items))
+	g := New(ctx, opts)
+
+	for i, item := range items {
+		g.Go(fmt.Sprint(i), func(ctx context.Context) error {
+			value, err := fn(ctx, item)
+			results[i] = Result[Out]{Value: value, Err: err}
+
+			return err
+		})
+	}
+
+	errs := g.Wait()
+
+	// Calls that never started (FailFast or a cancelled parent context) did not
+	// get to record their result, so fill those in from the group errors.
+	for i := range results {
+		if err, ok := errs[fmt.Sprint(i)]; ok && results[i].Err == nil {
+			results[i].Err = err
+		}
+	}
+
+	return results
+}
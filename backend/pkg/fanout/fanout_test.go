@@ -0,0 +1,146 @@
+package fanout_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupPartialFailure(t *testing.T) {
+	t.Parallel()
+
+	g := fanout.New(context.Background(), fanout.Options{})
+
+	var ok atomic.Bool
+
+	g.Go("ok", func(ctx context.Context) error {
+		ok.Store(true)
+		return nil
+	})
+	g.Go("broken", func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+
+	errs := g.Wait()
+	require.Len(t, errs, 1)
+	assert.True(t, ok.Load())
+	assert.EqualError(t, errs["broken"], "boom")
+	assert.Equal(t, map[string]string{"broken": "boom"}, errs.Messages())
+	assert.ErrorContains(t, errs.Err(), "broken: boom")
+}
+
+func TestGroupNoErrors(t *testing.T) {
+	t.Parallel()
+
+	g := fanout.New(context.Background(), fanout.Options{})
+	g.Go("a", func(ctx context.Context) error { return nil })
+
+	errs := g.Wait()
+	assert.Nil(t, errs)
+	assert.Nil(t, errs.Messages())
+	assert.NoError(t, errs.Err())
+}
+
+func TestGroupLimit(t *testing.T) {
+	t.Parallel()
+
+	const limit = 3
+
+	var running, maxRunning atomic.Int32
+
+	g := fanout.New(context.Background(), fanout.Options{Limit: limit})
+
+	for i := 0; i < 20; i++ {
+		g.Go(string(rune('a'+i)), func(ctx context.Context) error {
+			n := running.Add(1)
+			defer running.Add(-1)
+
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+
+			return nil
+		})
+	}
+
+	assert.Nil(t, g.Wait())
+	assert.LessOrEqual(t, maxRunning.Load(), int32(limit))
+}
+
+func TestGroupTimeout(t *testing.T) {
+	t.Parallel()
+
+	g := fanout.New(context.Background(), fanout.Options{Timeout: 20 * time.Millisecond})
+	g.Go("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	errs := g.Wait()
+	assert.ErrorIs(t, errs["slow"], context.DeadlineExceeded)
+}
+
+func TestGroupFailFast(t *testing.T) {
+	t.Parallel()
+
+	g := fanout.New(context.Background(), fanout.Options{Limit: 1, FailFast: true})
+	g.Go("first", func(ctx context.Context) error {
+		return errors.New("boom")
+	})
+
+	// Give the first call a chance to grab the only slot before scheduling
+	// the second one.
+	time.Sleep(10 * time.Millisecond)
+
+	g.Go("second", func(ctx context.Context) error {
+		return nil
+	})
+
+	errs := g.Wait()
+	assert.EqualError(t, errs["first"], "boom")
+	assert.ErrorIs(t, errs["second"], context.Canceled)
+}
+
+func TestGroupRecoversPanics(t *testing.T) {
+	t.Parallel()
+
+	g := fanout.New(context.Background(), fanout.Options{})
+	g.Go("panics", func(ctx context.Context) error {
+		panic("oops")
+	})
+
+	errs := g.Wait()
+	assert.EqualError(t, errs["panics"], "panic: oops")
+}
+
+func TestMap(t *testing.T) {
+	t.Parallel()
+
+	items := []int{1, 2, 3, 4}
+	results := fanout.Map(context.Background(), items, fanout.Options{Limit: 2},
+		func(ctx context.Context, n int) (int, error) {
+			if n == 3 {
+				return 0, errors.New("three")
+			}
+
+			return n * n, nil
+		})
+
+	require.Len(t, results, len(items))
+	assert.Equal(t, 1, results[0].Value)
+	assert.Equal(t, 4, results[1].Value)
+	assert.EqualError(t, results[2].Err, "three")
+	assert.Equal(t, 16, results[3].Value)
+	assert.NoError(t, results[3].Err)
+}
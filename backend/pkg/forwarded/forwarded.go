@@ -0,0 +1,229 @@
+// Package forwarded makes Caravan aware of the reverse proxies in front of
+// it: the client address they forward in X-Forwarded-For, and the path
+// prefix they strip, given in X-Forwarded-Prefix.
+//
+// The headers are only believed from trusted proxies. Anyone can send them,
+// so trusting them from any peer would let a client pick the address it is
+// logged and rate limited under.
+package forwarded
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// Proxies are the trusted proxies, by address or network.
+type Proxies []netip.Prefix
+
+// ParseProxies parses a comma-separated list of IP addresses and CIDR
+// networks, such as "10.0.0.0/8,192.168.1.10".
+func ParseProxies(s string) (Proxies, error) {
+	var proxies Proxies
+
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if strings.Contains(field, "/") {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy network %q: %w", field, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy address %q: %w", field, err)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return proxies, nil
+}
+
+// Trusts reports whether addr is one of the proxies.
+func (p Proxies) Trusts(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type contextKey struct{}
+
+// info is what the middleware learned about a request
+type info struct {
+	clientIP string
+	prefix   string
+	trusted  bool
+}
+
+// Middleware records the client address and path prefix of each request,
+// for ClientIP and Prefix. Requests that do not come from a trusted proxy
+// have their X-Forwarded headers removed, so no handler acts on them.
+func (p Proxies) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := remoteAddr(r)
+
+		i := info{clientIP: peer.String()}
+		if !peer.IsValid() {
+			i.clientIP = r.RemoteAddr
+		}
+
+		if peer.IsValid() && p.Trusts(peer) {
+			i.trusted = true
+			if client := p.client(r.Header.Values("X-Forwarded-For")); client.IsValid() {
+				i.clientIP = client.String()
+			}
+			i.prefix = cleanPrefix(r.Header.Get("X-Forwarded-Prefix"))
+		} else if len(p) > 0 {
+			r.Header.Del("X-Forwarded-For")
+			r.Header.Del("X-Forwarded-Prefix")
+			r.Header.Del("X-Forwarded-Proto")
+			r.Header.Del("X-Forwarded-Host")
+			r.Header.Del("X-Forwarded-Ssl")
+			r.Header.Del("Forwarded")
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, i)))
+	})
+}
+
+// client returns the address X-Forwarded-For gives for the client. Each
+// proxy appends the address it got the request from, so the client is the
+// last address that is not a trusted proxy.
+func (p Proxies) client(headers []string) netip.Addr {
+	var addrs []string
+	for _, h := range headers {
+		addrs = append(addrs, strings.Split(h, ",")...)
+	}
+
+	var client netip.Addr
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(addrs[i]))
+		if err != nil {
+			break
+		}
+
+		client = addr.Unmap()
+		if !p.Trusts(client) {
+			break
+		}
+	}
+
+	return client
+}
+
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
+
+// cleanPrefix returns prefix as "/path" without a trailing slash, "" if
+// there is none or it is not a plain path
+func cleanPrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" || strings.ContainsAny(prefix, "?#\\") || strings.Contains(prefix, "..") {
+		return ""
+	}
+
+	return "/" + prefix
+}
+
+func fromContext(ctx context.Context) info {
+	i, _ := ctx.Value(contextKey{}).(info)
+	return i
+}
+
+// ClientIP returns the address of the client that made the request, as far
+// as the trusted proxies tell.
+func ClientIP(r *http.Request) string {
+	if ip := ClientIPFromContext(r.Context()); ip != "" {
+		return ip
+	}
+
+	if addr := remoteAddr(r); addr.IsValid() {
+		return addr.String()
+	}
+
+	return r.RemoteAddr
+}
+
+// ClientIPFromContext returns the client address of the request ctx belongs
+// to, "" if it did not go through the middleware.
+func ClientIPFromContext(ctx context.Context) string {
+	return fromContext(ctx).clientIP
+}
+
+// Prefix returns the path prefix a proxy stripped from the request, "" if
+// none did.
+func Prefix(r *http.Request) string {
+	return fromContext(r.Context()).prefix
+}
+
+// BasePath returns the path Caravan is served under as the browser sees it:
+// the proxy's prefix followed by Caravan's own base URL.
+func BasePath(r *http.Request, baseURL string) string {
+	baseURL = strings.Trim(baseURL, "/")
+	if baseURL != "" {
+		baseURL = "/" + baseURL
+	}
+
+	return Prefix(r) + baseURL
+}
+
+// ResolveURL makes a URL relative to Caravan, such as "/oidc/callback",
+// absolute as the browser sees it, including the proxy's prefix. Absolute
+// URLs are returned as they are.
+func ResolveURL(r *http.Request, ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil || u.IsAbs() || u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return ref
+	}
+
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(firstValue(r.Header.Get("X-Forwarded-Proto")), "https") {
+		scheme = "https"
+	}
+
+	host := r.Host
+	if forwardedHost := firstValue(r.Header.Get("X-Forwarded-Host")); forwardedHost != "" {
+		if fromContext(r.Context()).trusted {
+			host = forwardedHost
+		}
+	}
+
+	u.Scheme = scheme
+	u.Host = host
+	u.Path = Prefix(r) + u.Path
+
+	return u.String()
+}
+
+func firstValue(header string) string {
+	value, _, _ := strings.Cut(header, ",")
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,73 @@
+package forwarded_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/forwarded"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs a request from remoteAddr through the middleware and returns
+// the request the handler got
+func serve(t *testing.T, proxies forwarded.Proxies, remoteAddr string, header http.Header) *http.Request {
+	t.Helper()
+
+	var got *http.Request
+	h := proxies.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "http://caravan:4466/api/clusters", nil)
+	req.RemoteAddr = remoteAddr
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	require.NotNil(t, got)
+
+	return got
+}
+
+func TestParseProxies(t *testing.T) {
+	proxies, err := forwarded.ParseProxies("10.0.0.0/8, 192.168.1.10,,::1")
+	require.NoError(t, err)
+	assert.Len(t, proxies, 3)
+
+	_, err = forwarded.ParseProxies("10.0.0.0/33")
+	assert.Error(t, err)
+	_, err = forwarded.ParseProxies("proxy.local")
+	assert.Error(t, err)
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := forwarded.ParseProxies("10.0.0.0/8")
+	require.NoError(t, err)
+
+	header := http.Header{
+		"X-Forwarded-For":    {"203.0.113.9, 198.51.100.7", "10.0.0.2"},
+		"X-Forwarded-Prefix": {"/nomad/"},
+		"X-Forwarded-Host":   {"ops.example.com"},
+		"X-Forwarded-Proto":  {"https"},
+	}
+
+	r := serve(t, proxies, "10.0.0.1:51234", header)
+	assert.Equal(t, "198.51.100.7", forwarded.ClientIP(r), "the last address that is not a trusted proxy")
+	assert.Equal(t, "/nomad", forwarded.Prefix(r))
+	assert.Equal(t, "/nomad/caravan", forwarded.BasePath(r, "/caravan/"))
+	assert.Equal(t, "https://ops.example.com/nomad/oidc/callback", forwarded.ResolveURL(r, "/oidc/callback"))
+	assert.Equal(t, "http://localhost:3000/cb", forwarded.ResolveURL(r, "http://localhost:3000/cb"))
+
+	r = serve(t, proxies, "198.51.100.7:40000", header)
+	assert.Equal(t, "198.51.100.7", forwarded.ClientIP(r), "untrusted peers cannot pick their address")
+	assert.Empty(t, forwarded.Prefix(r))
+	assert.Empty(t, r.Header.Get("X-Forwarded-Proto"), "forwarded headers of untrusted peers are dropped")
+	assert.Equal(t, "http://caravan:4466/oidc/callback", forwarded.ResolveURL(r, "/oidc/callback"))
+
+	r = serve(t, nil, "198.51.100.7:40000", header)
+	assert.Equal(t, "198.51.100.7", forwarded.ClientIP(r))
+	assert.Equal(t, "https", r.Header.Get("X-Forwarded-Proto"), "without trusted proxies the headers are left alone")
+}
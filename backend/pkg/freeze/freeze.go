@@ -0,0 +1,270 @@
+// Package freeze implements change freeze windows: periods during which
+// changes to a cluster are blocked, e.g. over a release weekend or during
+// business hours of a peak sales day.
+//
+// Windows are either recurring, given as a cron expression for the start and
+// a duration, or fixed, given as a start and end time. A window can be
+// limited to some clusters and namespaces.
+package freeze
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/cronexpr"
+)
+
+// Duration is a time.Duration that is written as a string such as "8h" in JSON.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Window is a freeze window.
+type Window struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+	// Clusters and Namespaces limit the window, empty means all.
+	Clusters   []string `json:"clusters,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Cron is the start of a recurring window, which lasts Duration.
+	Cron     string   `json:"cron,omitempty"`
+	Duration Duration `json:"duration,omitempty"`
+	// Start and End bound a fixed window.
+	Start time.Time `json:"start,omitempty"`
+	End   time.Time `json:"end,omitempty"`
+	// Timezone the cron expression is evaluated in, UTC if empty.
+	Timezone string `json:"timezone,omitempty"`
+
+	expr     *cronexpr.Expression
+	location *time.Location
+}
+
+// Config is the content of a freeze windows file.
+type Config struct {
+	Windows []*Window `json:"windows"`
+	// OverridePolicies are the Nomad ACL policies whose holders may make
+	// changes during a freeze. Management tokens can always override.
+	OverridePolicies []string `json:"overridePolicies,omitempty"`
+}
+
+// Schedule is a validated set of freeze windows.
+type Schedule struct {
+	windows          []*Window
+	overridePolicies []string
+}
+
+// Occurrence is a window together with the period it is, or will next be, active.
+type Occurrence struct {
+	Name       string    `json:"name"`
+	Reason     string    `json:"reason,omitempty"`
+	Clusters   []string  `json:"clusters,omitempty"`
+	Namespaces []string  `json:"namespaces,omitempty"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Active     bool      `json:"active"`
+}
+
+// Load reads a schedule from a JSON file.
+func Load(path string) (*Schedule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading freeze windows: %w", err)
+	}
+
+	return Parse(data)
+}
+
+// Parse parses and validates a JSON schedule.
+func Parse(data []byte) (*Schedule, error) {
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing freeze windows: %w", err)
+	}
+
+	return New(cfg)
+}
+
+// New validates cfg and creates a schedule from it.
+func New(cfg Config) (*Schedule, error) {
+	for i, w := range cfg.Windows {
+		if err := w.init(); err != nil {
+			return nil, fmt.Errorf("freeze window %d (%s): %w", i, w.Name, err)
+		}
+	}
+
+	return &Schedule{windows: cfg.Windows, overridePolicies: cfg.OverridePolicies}, nil
+}
+
+func (w *Window) init() error {
+	w.location = time.UTC
+
+	if w.Timezone != "" {
+		loc, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return err
+		}
+
+		w.location = loc
+	}
+
+	switch {
+	case w.Cron != "":
+		if !w.Start.IsZero() || !w.End.IsZero() {
+			return errors.New("cron windows cannot have a start or end")
+		}
+
+		if w.Duration <= 0 {
+			return errors.New("cron windows need a positive duration")
+		}
+
+		expr, err := cronexpr.Parse(w.Cron)
+		if err != nil {
+			return err
+		}
+
+		w.expr = expr
+
+	case !w.Start.IsZero() && !w.End.IsZero():
+		if !w.End.After(w.Start) {
+			return errors.New("end must be after start")
+		}
+
+	default:
+		return errors.New("either cron and duration or start and end are required")
+	}
+
+	return nil
+}
+
+// occurrence returns the period of the window that contains now or, if the
+// window is not active, the next one. ok is false if there is none.
+func (w *Window) occurrence(now time.Time) (start, end time.Time, ok bool) {
+	if w.expr == nil {
+		if now.Before(w.End) {
+			return w.Start, w.End, true
+		}
+
+		return time.Time{}, time.Time{}, false
+	}
+
+	d := time.Duration(w.Duration)
+
+	// The earliest start that could still cover now is now - d.
+	start = w.expr.Next(now.In(w.location).Add(-d).Add(-time.Nanosecond))
+	if start.IsZero() {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return start.UTC(), start.Add(d).UTC(), true
+}
+
+func (w *Window) applies(cluster, namespace string) bool {
+	return matches(w.Clusters, cluster) && (namespace == "*" || matches(w.Namespaces, namespace))
+}
+
+func matches(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if v == value || v == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Active returns the windows in effect for a cluster and namespace at now.
+func (s *Schedule) Active(cluster, namespace string, now time.Time) []Occurrence {
+	if s == nil {
+		return nil
+	}
+
+	var active []Occurrence
+
+	for _, w := range s.windows {
+		if !w.applies(cluster, namespace) {
+			continue
+		}
+
+		if o, ok := w.occurrenceAt(now); ok && o.Active {
+			active = append(active, o)
+		}
+	}
+
+	return active
+}
+
+// Upcoming returns the windows that are active at now or start before
+// now + within, sorted by start.
+func (s *Schedule) Upcoming(now time.Time, within time.Duration) []Occurrence {
+	if s == nil {
+		return nil
+	}
+
+	occurrences := []Occurrence{}
+
+	for _, w := range s.windows {
+		if o, ok := w.occurrenceAt(now); ok && o.Start.Before(now.Add(within)) {
+			occurrences = append(occurrences, o)
+		}
+	}
+
+	sort.Slice(occurrences, func(i, j int) bool {
+		return occurrences[i].Start.Before(occurrences[j].Start)
+	})
+
+	return occurrences
+}
+
+func (w *Window) occurrenceAt(now time.Time) (Occurrence, bool) {
+	start, end, ok := w.occurrence(now)
+	if !ok {
+		return Occurrence{}, false
+	}
+
+	return Occurrence{
+		Name:       w.Name,
+		Reason:     w.Reason,
+		Clusters:   w.Clusters,
+		Namespaces: w.Namespaces,
+		Start:      start,
+		End:        end,
+		Active:     !now.Before(start) && now.Before(end),
+	}, true
+}
+
+// OverridePolicies returns the ACL policies allowed to make changes during a freeze.
+func (s *Schedule) OverridePolicies() []string {
+	if s == nil {
+		return nil
+	}
+
+	return s.overridePolicies
+}
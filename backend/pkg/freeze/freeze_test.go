@@ -0,0 +1,93 @@
+package freeze_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/freeze"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCronWindow(t *testing.T) {
+	s, err := freeze.Parse([]byte(`{
+		"windows": [{
+			"name": "weekend",
+			"reason": "No changes over the weekend",
+			"cron": "0 0 18 * * FRI *",
+			"duration": "62h",
+			"clusters": ["prod"]
+		}]
+	}`))
+	require.NoError(t, err)
+
+	saturday := time.Date(2025, 6, 7, 12, 0, 0, 0, time.UTC)
+	monday := time.Date(2025, 6, 9, 9, 0, 0, 0, time.UTC)
+
+	active := s.Active("prod", "default", saturday)
+	require.Len(t, active, 1)
+	assert.Equal(t, "weekend", active[0].Name)
+	assert.Equal(t, time.Date(2025, 6, 6, 18, 0, 0, 0, time.UTC), active[0].Start)
+	assert.Equal(t, time.Date(2025, 6, 9, 8, 0, 0, 0, time.UTC), active[0].End)
+
+	assert.Empty(t, s.Active("staging", "default", saturday))
+	assert.Empty(t, s.Active("prod", "default", monday))
+
+	upcoming := s.Upcoming(monday, 7*24*time.Hour)
+	require.Len(t, upcoming, 1)
+	assert.False(t, upcoming[0].Active)
+	assert.Equal(t, time.Date(2025, 6, 13, 18, 0, 0, 0, time.UTC), upcoming[0].Start)
+}
+
+func TestFixedWindow(t *testing.T) {
+	s, err := freeze.Parse([]byte(`{
+		"windows": [{
+			"name": "black-friday",
+			"start": "2025-11-28T00:00:00Z",
+			"end": "2025-12-01T00:00:00Z",
+			"namespaces": ["shop"]
+		}],
+		"overridePolicies": ["sre"]
+	}`))
+	require.NoError(t, err)
+
+	during := time.Date(2025, 11, 29, 0, 0, 0, 0, time.UTC)
+
+	assert.Len(t, s.Active("any", "shop", during), 1)
+	assert.Len(t, s.Active("any", "*", during), 1)
+	assert.Empty(t, s.Active("any", "default", during))
+	assert.Empty(t, s.Active("any", "shop", time.Date(2025, 12, 2, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, []string{"sre"}, s.OverridePolicies())
+}
+
+func TestTimezone(t *testing.T) {
+	s, err := freeze.Parse([]byte(`{
+		"windows": [{"name": "business-hours", "cron": "0 0 9 * * MON-FRI *", "duration": "8h", "timezone": "Asia/Kolkata"}]
+	}`))
+	require.NoError(t, err)
+
+	// 10:00 IST on a Wednesday
+	assert.Len(t, s.Active("prod", "default", time.Date(2025, 6, 4, 4, 30, 0, 0, time.UTC)), 1)
+	// 18:00 IST
+	assert.Empty(t, s.Active("prod", "default", time.Date(2025, 6, 4, 12, 30, 0, 0, time.UTC)))
+}
+
+func TestInvalidWindows(t *testing.T) {
+	for _, cfg := range []string{
+		`{"windows": [{"name": "a"}]}`,
+		`{"windows": [{"name": "a", "cron": "0 0 * * *"}]}`,
+		`{"windows": [{"name": "a", "cron": "not cron", "duration": "1h"}]}`,
+		`{"windows": [{"name": "a", "start": "2025-01-02T00:00:00Z", "end": "2025-01-01T00:00:00Z"}]}`,
+		`{"windows": [{"name": "a", "cron": "0 0 * * *", "duration": "1h", "timezone": "Mars/Olympus"}]}`,
+	} {
+		_, err := freeze.Parse([]byte(cfg))
+		assert.Error(t, err, cfg)
+	}
+}
+
+func TestNilSchedule(t *testing.T) {
+	var s *freeze.Schedule
+
+	assert.Empty(t, s.Active("prod", "default", time.Now()))
+	assert.Empty(t, s.Upcoming(time.Now(), time.Hour))
+}
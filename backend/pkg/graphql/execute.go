@@ -0,0 +1,637 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+)
+
+// DefaultMaxDepth bounds the nesting of selections when Schema.MaxDepth is not set.
+const DefaultMaxDepth = 10
+
+// DefaultMaxResolverCalls bounds the resolvers a request runs when
+// Schema.MaxResolverCalls is not set.
+const DefaultMaxResolverCalls = 500
+
+// Request is a GraphQL request as sent over HTTP.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response is the result of executing a request.
+type Response struct {
+	Data   interface{} `json:"data"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is a GraphQL error. Path points at the field that failed.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// ResolveParams are passed to field resolvers.
+type ResolveParams struct {
+	// Source is the value of the parent object.
+	Source interface{}
+	// Args are the field arguments with variables substituted.
+	Args map[string]interface{}
+}
+
+// ResolveFunc resolves the value of a field.
+type ResolveFunc func(ctx context.Context, p ResolveParams) (interface{}, error)
+
+// FieldDef defines a field of an object type.
+type FieldDef struct {
+	// Type is the object type of the field value, nil for leaf values.
+	Type *Object
+	// Resolve computes the field value. Fields without a resolver read the
+	// matching property of the parent value.
+	Resolve ResolveFunc
+}
+
+// Object is an object type. Fields that are not defined explicitly are
+// resolved from the exported fields of the underlying Go value, matched
+// case-insensitively, so that Nomad API structs can be queried directly and
+// only relationships need resolvers.
+type Object struct {
+	Name   string
+	Fields map[string]*FieldDef
+}
+
+// NewObject creates an object type without explicit fields.
+func NewObject(name string) *Object {
+	return &Object{Name: name, Fields: make(map[string]*FieldDef)}
+}
+
+// AddField defines a field and returns the object for chaining.
+func (o *Object) AddField(name string, def *FieldDef) *Object {
+	o.Fields[name] = def
+	return o
+}
+
+type scopedValue struct {
+	ctx   context.Context
+	value interface{}
+}
+
+// Scoped wraps a resolved value so that the resolvers below it run with ctx,
+// e.g. to hand a cluster's client to the fields of that cluster.
+func Scoped(ctx context.Context, value interface{}) interface{} {
+	return scopedValue{ctx: ctx, value: value}
+}
+
+// Schema is an executable schema. Only queries are supported.
+type Schema struct {
+	Query *Object
+	// Concurrency bounds the resolvers a request runs in parallel, however
+	// deeply they are nested.
+	Concurrency int
+	// MaxDepth bounds the nesting of selections.
+	MaxDepth int
+	// MaxResolverCalls bounds the resolvers a request runs in total. Each
+	// item of a list runs the resolvers selected below it, so the nesting of
+	// lists multiplies them.
+	MaxResolverCalls int
+}
+
+// Execute parses and runs a request.
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	if op.Type != "query" {
+		return &Response{Errors: []*Error{{Message: fmt.Sprintf("%s operations are not supported", op.Type)}}}
+	}
+
+	vars := make(map[string]interface{}, len(op.Variables))
+	for _, def := range op.Variables {
+		if v, ok := req.Variables[def.Name]; ok {
+			vars[def.Name] = v
+		} else if def.Default != nil {
+			vars[def.Name] = def.Default
+		}
+	}
+
+	e := &executor{schema: s, doc: doc, vars: vars, maxCalls: s.MaxResolverCalls}
+	if e.maxCalls <= 0 {
+		e.maxCalls = DefaultMaxResolverCalls
+	}
+	if s.Concurrency > 0 {
+		e.slots = make(chan struct{}, s.Concurrency)
+	}
+
+	data := e.executeSelections(ctx, s.Query, nil, op.Selections, nil)
+
+	return &Response{Data: data, Errors: e.errors}
+}
+
+func selectOperation(doc *Document, name string) (*Operation, error) {
+	if name == "" {
+		if len(doc.Operations) > 1 {
+			return nil, fmt.Errorf("operationName is required when the document has several operations")
+		}
+
+		return doc.Operations[0], nil
+	}
+
+	for _, op := range doc.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+type executor struct {
+	schema *Schema
+	doc    *Document
+	vars   map[string]interface{}
+
+	// slots bounds the resolvers running at once, nil for no bound
+	slots chan struct{}
+	// calls counts the resolvers run, up to maxCalls
+	calls    atomic.Int64
+	maxCalls int
+	// overBudget is set once a resolver was refused for exceeding maxCalls
+	overBudget atomic.Bool
+
+	mu     sync.Mutex
+	errors []*Error
+}
+
+// resolve runs a resolver within the request's budget of calls and
+// concurrency. Only the resolver holds a slot, not the resolvers of the
+// fields below it, which could otherwise wait on their parent forever.
+func (e *executor) resolve(ctx context.Context, def *FieldDef, p ResolveParams, path []interface{}) (interface{}, bool) {
+	if e.calls.Add(1) > int64(e.maxCalls) {
+		// Only the first refused field is reported, not each of thousands
+		if e.overBudget.CompareAndSwap(false, true) {
+			e.addError(path, fmt.Errorf("query exceeds the limit of %d resolver calls", e.maxCalls))
+		}
+		return nil, false
+	}
+
+	if e.slots != nil {
+		select {
+		case e.slots <- struct{}{}:
+			defer func() { <-e.slots }()
+		case <-ctx.Done():
+			e.addError(path, ctx.Err())
+			return nil, false
+		}
+	}
+
+	value, err := def.Resolve(ctx, p)
+	if err != nil {
+		e.addError(path, err)
+		return nil, false
+	}
+
+	return value, true
+}
+
+func (e *executor) addError(path []interface{}, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.errors = append(e.errors, &Error{Message: err.Error(), Path: path})
+}
+
+// fieldGroup is a response key with all the fields selected under it.
+type fieldGroup struct {
+	key    string
+	fields []*Field
+}
+
+func (e *executor) executeSelections(ctx context.Context, obj *Object, source interface{},
+	selections []Selection, path []interface{},
+) *orderedMap {
+	maxDepth := e.schema.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+
+	if fieldDepth(path) > maxDepth {
+		e.addError(path, fmt.Errorf("query exceeds the maximum depth of %d", maxDepth))
+		return nil
+	}
+
+	groups := e.collectFields(obj, selections, nil, map[string]bool{})
+	result := &orderedMap{values: make(map[string]interface{}, len(groups))}
+	values := make([]interface{}, len(groups))
+
+	resolve := func(ctx context.Context, i int) {
+		values[i] = e.executeField(ctx, obj, source, groups[i], appendPath(path, groups[i].key))
+	}
+
+	if e.hasResolvers(obj, groups) > 1 {
+		g := fanout.New(ctx, fanout.Options{Limit: e.schema.Concurrency})
+
+		for i := range groups {
+			g.Go(groups[i].key, func(ctx context.Context) error {
+				resolve(ctx, i)
+				return nil
+			})
+		}
+
+		for key, err := range g.Wait() {
+			e.addError(appendPath(path, key), err)
+		}
+	} else {
+		for i := range groups {
+			resolve(ctx, i)
+		}
+	}
+
+	for i, group := range groups {
+		result.keys = append(result.keys, group.key)
+		result.values[group.key] = values[i]
+	}
+
+	return result
+}
+
+// hasResolvers counts the selected fields backed by a resolver, which are the
+// ones worth running concurrently.
+func (e *executor) hasResolvers(obj *Object, groups []fieldGroup) int {
+	if obj == nil {
+		return 0
+	}
+
+	n := 0
+
+	for _, group := range groups {
+		if def := obj.Fields[group.fields[0].Name]; def != nil && def.Resolve != nil {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (e *executor) executeField(ctx context.Context, obj *Object, source interface{},
+	group fieldGroup, path []interface{},
+) interface{} {
+	field := group.fields[0]
+
+	if field.Name == "__typename" {
+		return typeName(obj, source)
+	}
+
+	args, err := e.arguments(field.Arguments)
+	if err != nil {
+		e.addError(path, err)
+		return nil
+	}
+
+	var def *FieldDef
+	if obj != nil {
+		def = obj.Fields[field.Name]
+	}
+
+	var value interface{}
+
+	if def != nil && def.Resolve != nil {
+		var ok bool
+		if value, ok = e.resolve(ctx, def, ResolveParams{Source: source, Args: args}, path); !ok {
+			return nil
+		}
+	} else {
+		value, err = defaultResolve(source, field.Name)
+		if err != nil {
+			e.addError(path, fmt.Errorf("cannot query field %q on type %q", field.Name, typeName(obj, source)))
+			return nil
+		}
+	}
+
+	var selections []Selection
+	for _, f := range group.fields {
+		selections = append(selections, f.Selections...)
+	}
+
+	var fieldType *Object
+	if def != nil {
+		fieldType = def.Type
+	}
+
+	return e.completeValue(ctx, fieldType, field.Name, value, selections, path)
+}
+
+func (e *executor) completeValue(ctx context.Context, obj *Object, name string, value interface{},
+	selections []Selection, path []interface{},
+) interface{} {
+	if sv, ok := value.(scopedValue); ok {
+		ctx, value = sv.ctx, sv.value
+	}
+
+	v := reflect.ValueOf(value)
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return nil
+		}
+
+		v = v.Elem()
+	}
+
+	if !v.IsValid() {
+		return nil
+	}
+
+	if len(selections) == 0 {
+		if obj != nil {
+			e.addError(path, fmt.Errorf("field %q of type %q must have a selection of subfields", name, obj.Name))
+			return nil
+		}
+
+		return v.Interface()
+	}
+
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+
+		items := make([]interface{}, v.Len())
+		indexes := make([]int, v.Len())
+
+		for i := range indexes {
+			indexes[i] = i
+		}
+
+		complete := func(ctx context.Context, i int) (interface{}, error) {
+			return e.completeValue(ctx, obj, name, v.Index(i).Interface(), selections, appendPath(path, i)), nil
+		}
+
+		groups := e.collectFields(obj, selections, nil, map[string]bool{})
+		if e.hasResolvers(obj, groups) > 0 {
+			for i, r := range fanout.Map(ctx, indexes, fanout.Options{Limit: e.schema.Concurrency}, complete) {
+				if r.Err != nil {
+					e.addError(appendPath(path, i), r.Err)
+				}
+
+				items[i] = r.Value
+			}
+		} else {
+			for i := range indexes {
+				items[i], _ = complete(ctx, i)
+			}
+		}
+
+		return items
+
+	case reflect.Struct, reflect.Map:
+		return e.executeSelections(ctx, obj, v.Interface(), selections, path)
+	}
+
+	e.addError(path, fmt.Errorf("field %q is a leaf and cannot have a selection of subfields", name))
+
+	return nil
+}
+
+// collectFields flattens fragments and applies @skip/@include, grouping the
+// fields by response key in selection order.
+func (e *executor) collectFields(obj *Object, selections []Selection, groups []fieldGroup,
+	visited map[string]bool,
+) []fieldGroup {
+	for _, sel := range selections {
+		switch s := sel.(type) {
+		case *Field:
+			if !e.included(s.Directives) {
+				continue
+			}
+
+			key := s.ResponseKey()
+			found := false
+
+			for i := range groups {
+				if groups[i].key == key {
+					groups[i].fields = append(groups[i].fields, s)
+					found = true
+
+					break
+				}
+			}
+
+			if !found {
+				groups = append(groups, fieldGroup{key: key, fields: []*Field{s}})
+			}
+
+		case *FragmentSpread:
+			frag, ok := e.doc.Fragments[s.Name]
+			if !ok || visited[s.Name] || !e.included(s.Directives) || !typeMatches(obj, frag.TypeCondition) {
+				continue
+			}
+
+			visited[s.Name] = true
+			groups = e.collectFields(obj, frag.Selections, groups, visited)
+
+		case *InlineFragment:
+			if !e.included(s.Directives) || !typeMatches(obj, s.TypeCondition) {
+				continue
+			}
+
+			groups = e.collectFields(obj, s.Selections, groups, visited)
+		}
+	}
+
+	return groups
+}
+
+func typeMatches(obj *Object, condition string) bool {
+	return condition == "" || obj == nil || obj.Name == condition
+}
+
+func (e *executor) included(directives []*Directive) bool {
+	for _, d := range directives {
+		args, err := e.arguments(d.Arguments)
+		if err != nil {
+			continue
+		}
+
+		cond, _ := args["if"].(bool)
+
+		switch d.Name {
+		case "skip":
+			if cond {
+				return false
+			}
+		case "include":
+			if !cond {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (e *executor) arguments(args map[string]interface{}) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(args))
+
+	for name, v := range args {
+		value, err := e.value(v)
+		if err != nil {
+			return nil, err
+		}
+
+		resolved[name] = value
+	}
+
+	return resolved, nil
+}
+
+func (e *executor) value(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case Variable:
+		return e.vars[string(v)], nil
+	case Enum:
+		return string(v), nil
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			value, err := e.value(item)
+			if err != nil {
+				return nil, err
+			}
+
+			list[i] = value
+		}
+
+		return list, nil
+	case map[string]interface{}:
+		return e.arguments(v)
+	}
+
+	return v, nil
+}
+
+// defaultResolve reads a property of a struct or map. It returns an error if
+// the source has no such property.
+func defaultResolve(source interface{}, name string) (interface{}, error) {
+	v := reflect.ValueOf(source)
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return nil, nil
+		}
+
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		f := v.FieldByNameFunc(func(n string) bool { return strings.EqualFold(n, name) })
+		if f.IsValid() && f.CanInterface() {
+			return f.Interface(), nil
+		}
+
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			f := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+			if f.IsValid() {
+				return f.Interface(), nil
+			}
+
+			return nil, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no field %q", name)
+}
+
+func typeName(obj *Object, source interface{}) string {
+	if obj != nil {
+		return obj.Name
+	}
+
+	t := reflect.TypeOf(source)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == nil {
+		return ""
+	}
+
+	return t.Name()
+}
+
+// fieldDepth returns the number of fields in path, ignoring list indexes.
+func fieldDepth(path []interface{}) int {
+	n := 0
+
+	for _, elem := range path {
+		if _, ok := elem.(string); ok {
+			n++
+		}
+	}
+
+	return n
+}
+
+func appendPath(path []interface{}, elem interface{}) []interface{} {
+	p := make([]interface{}, len(path), len(path)+1)
+	copy(p, path)
+
+	return append(p, elem)
+}
+
+// orderedMap is a JSON object that keeps the order of the selection set, as
+// required by the GraphQL specification.
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,227 @@
+package graphql_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type job struct {
+	ID     string
+	Name   *string
+	Status string
+}
+
+type alloc struct {
+	ID    string
+	JobID string
+}
+
+func testSchema() *graphql.Schema {
+	name := "Web"
+	jobs := []*job{{ID: "web", Name: &name, Status: "running"}, {ID: "batch", Status: "dead"}}
+
+	allocType := graphql.NewObject("Allocation")
+	jobType := graphql.NewObject("Job").
+		AddField("allocations", &graphql.FieldDef{
+			Type: allocType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				j := p.Source.(job)
+				if j.ID == "batch" {
+					return nil, errors.New("permission denied")
+				}
+
+				return []alloc{{ID: "a1", JobID: j.ID}}, nil
+			},
+		})
+
+	query := graphql.NewObject("Query").
+		AddField("jobs", &graphql.FieldDef{
+			Type: jobType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				return jobs, nil
+			},
+		}).
+		AddField("job", &graphql.FieldDef{
+			Type: jobType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				for _, j := range jobs {
+					if j.ID == p.Args["id"] {
+						return j, nil
+					}
+				}
+
+				return nil, nil
+			},
+		})
+
+	return &graphql.Schema{Query: query}
+}
+
+func execute(t *testing.T, req graphql.Request) (string, []*graphql.Error) {
+	t.Helper()
+
+	resp := testSchema().Execute(context.Background(), req)
+
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+
+	return string(data), resp.Errors
+}
+
+func TestExecuteNested(t *testing.T) {
+	data, errs := execute(t, graphql.Request{Query: `
+		# all jobs with their allocations
+		{
+			jobs {
+				id
+				name
+				allocs: allocations { id jobID __typename }
+			}
+		}`})
+
+	assert.JSONEq(t, `{"jobs": [
+		{"id": "web", "name": "Web", "allocs": [{"id": "a1", "jobID": "web", "__typename": "Allocation"}]},
+		{"id": "batch", "name": null, "allocs": null}
+	]}`, data)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "permission denied", errs[0].Message)
+	assert.Equal(t, []interface{}{"jobs", 1, "allocs"}, errs[0].Path)
+}
+
+func TestExecuteKeepsSelectionOrder(t *testing.T) {
+	data, errs := execute(t, graphql.Request{Query: `{ job(id: "web") { status id } }`})
+
+	assert.Empty(t, errs)
+	assert.Equal(t, `{"job":{"status":"running","id":"web"}}`, data)
+}
+
+func TestExecuteVariablesFragmentsAndDirectives(t *testing.T) {
+	data, errs := execute(t, graphql.Request{
+		Query: `
+			query Job($id: String!, $withStatus: Boolean = false) {
+				job(id: $id) {
+					...jobFields
+					status @include(if: $withStatus)
+					... on Job { name }
+				}
+			}
+			fragment jobFields on Job { id }`,
+		Variables: map[string]interface{}{"id": "web"},
+	})
+
+	assert.Empty(t, errs)
+	assert.JSONEq(t, `{"job": {"id": "web", "name": "Web"}}`, data)
+}
+
+func TestExecuteErrors(t *testing.T) {
+	tests := map[string]string{
+		`{ job(id: "web") { missing } }`:                  `cannot query field "missing" on type "Job"`,
+		`{ jobs }`:                                        `field "jobs" of type "Job" must have a selection of subfields`,
+		`{ job(id: "web") { id { x } } }`:                 `field "id" is a leaf and cannot have a selection of subfields`,
+		`mutation { stop }`:                               `mutation operations are not supported`,
+		`{ job(id: "web") { id }`:                         `syntax error at 1:24: unexpected end of document`,
+		`query A { jobs { id } } query B { jobs { id } }`: `operationName is required when the document has several operations`,
+	}
+
+	for query, msg := range tests {
+		_, errs := execute(t, graphql.Request{Query: query})
+		if assert.Len(t, errs, 1, query) {
+			assert.Equal(t, msg, errs[0].Message, query)
+		}
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	doc, err := graphql.Parse(`{ f(a: 1, b: -2.5e1, c: "x\nA", d: [true, null], e: {k: ENUM}) }`)
+	require.NoError(t, err)
+
+	field := doc.Operations[0].Selections[0].(*graphql.Field)
+	assert.Equal(t, map[string]interface{}{
+		"a": int64(1),
+		"b": -25.0,
+		"c": "x\nA",
+		"d": []interface{}{true, nil},
+		"e": map[string]interface{}{"k": graphql.Enum("ENUM")},
+	}, field.Arguments)
+}
+
+func TestScoped(t *testing.T) {
+	type key struct{}
+
+	leaf := graphql.NewObject("Leaf").
+		AddField("scope", &graphql.FieldDef{
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				return ctx.Value(key{}), nil
+			},
+		})
+
+	query := graphql.NewObject("Query").
+		AddField("items", &graphql.FieldDef{
+			Type: leaf,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				return []interface{}{
+					graphql.Scoped(context.WithValue(ctx, key{}, "a"), struct{}{}),
+					graphql.Scoped(context.WithValue(ctx, key{}, "b"), struct{}{}),
+				}, nil
+			},
+		})
+
+	resp := (&graphql.Schema{Query: query}).Execute(context.Background(), graphql.Request{Query: `{ items { scope } }`})
+	require.Empty(t, resp.Errors)
+
+	data, err := json.Marshal(resp.Data)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"items": [{"scope": "a"}, {"scope": "b"}]}`, string(data))
+}
+
+// fanoutSchema has ten items of ten children each, whose values are
+// resolved one by one, and records how many resolvers ran at once
+func fanoutSchema(running, peak *atomic.Int64) *graphql.Object {
+	track := func() func() {
+		n := running.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(2 * time.Millisecond)
+		return func() { running.Add(-1) }
+	}
+	list := func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+		defer track()()
+		return make([]struct{}, 10), nil
+	}
+
+	child := graphql.NewObject("Child").
+		AddField("value", &graphql.FieldDef{
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				defer track()()
+				return 1, nil
+			},
+		})
+	item := graphql.NewObject("Item").AddField("children", &graphql.FieldDef{Type: child, Resolve: list})
+
+	return graphql.NewObject("Query").AddField("items", &graphql.FieldDef{Type: item, Resolve: list})
+}
+
+func TestExecuteLimits(t *testing.T) {
+	var running, peak atomic.Int64
+	schema := &graphql.Schema{Query: fanoutSchema(&running, &peak), Concurrency: 3}
+
+	// The concurrency holds for the whole request, not per selection set
+	resp := schema.Execute(context.Background(), graphql.Request{Query: `{ items { children { value } } }`})
+	require.Empty(t, resp.Errors)
+	assert.LessOrEqual(t, peak.Load(), int64(3))
+
+	// The 111 resolvers of the query go over a budget of 50
+	schema.MaxResolverCalls = 50
+	resp = schema.Execute(context.Background(), graphql.Request{Query: `{ items { children { value } } }`})
+	if assert.Len(t, resp.Errors, 1) {
+		assert.Equal(t, "query exceeds the limit of 50 resolver calls", resp.Errors[0].Message)
+	}
+}
@@ -0,0 +1,781 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Document is a parsed GraphQL request document.
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation is a query, mutation or subscription in a document.
+type Operation struct {
+	Type       string
+	Name       string
+	Variables  []*VariableDefinition
+	Selections []Selection
+}
+
+// VariableDefinition declares a variable of an operation.
+type VariableDefinition struct {
+	Name    string
+	Default interface{}
+}
+
+// Fragment is a named fragment definition.
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	Selections    []Selection
+}
+
+// Selection is a field, a fragment spread or an inline fragment.
+type Selection interface {
+	selection()
+}
+
+// Field selects a field of an object.
+type Field struct {
+	Alias      string
+	Name       string
+	Arguments  map[string]interface{}
+	Directives []*Directive
+	Selections []Selection
+}
+
+// FragmentSpread includes a named fragment.
+type FragmentSpread struct {
+	Name       string
+	Directives []*Directive
+}
+
+// InlineFragment includes selections conditionally on the object type.
+type InlineFragment struct {
+	TypeCondition string
+	Directives    []*Directive
+	Selections    []Selection
+}
+
+// Directive is a directive such as @include(if: $flag).
+type Directive struct {
+	Name      string
+	Arguments map[string]interface{}
+}
+
+func (*Field) selection()          {}
+func (*FragmentSpread) selection() {}
+func (*InlineFragment) selection() {}
+
+// ResponseKey returns the key the field is written under in the response.
+func (f *Field) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+
+	return f.Name
+}
+
+// Variable is a reference to an operation variable in an argument value.
+type Variable string
+
+// Enum is an enum value literal.
+type Enum string
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenString
+	tokenInt
+	tokenFloat
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+type parser struct {
+	src string
+	pos int
+	tok token
+}
+
+// Parse parses a GraphQL document. Only executable definitions (operations and
+// fragments) are supported, type system definitions are rejected.
+func Parse(src string) (*Document, error) {
+	p := &parser{src: src}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	doc := &Document{Fragments: make(map[string]*Fragment)}
+
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.isPunct("{"):
+			sel, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+
+			doc.Operations = append(doc.Operations, &Operation{Type: "query", Selections: sel})
+
+		case p.isName("query"), p.isName("mutation"), p.isName("subscription"):
+			op, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+
+			doc.Operations = append(doc.Operations, op)
+
+		case p.isName("fragment"):
+			frag, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+
+			doc.Fragments[frag.Name] = frag
+
+		default:
+			return nil, p.errorf("unexpected %q", p.tok.value)
+		}
+	}
+
+	if len(doc.Operations) == 0 {
+		return nil, fmt.Errorf("document contains no operations")
+	}
+
+	return doc, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	line := 1 + strings.Count(p.src[:p.tok.pos], "\n")
+	col := p.tok.pos - strings.LastIndex(p.src[:p.tok.pos], "\n")
+
+	return fmt.Errorf("syntax error at %d:%d: %s", line, col, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) isPunct(v string) bool {
+	return p.tok.kind == tokenPunct && p.tok.value == v
+}
+
+func (p *parser) isName(v string) bool {
+	return p.tok.kind == tokenName && p.tok.value == v
+}
+
+func (p *parser) expectPunct(v string) error {
+	if !p.isPunct(v) {
+		return p.errorf("expected %q, found %q", v, p.tok.value)
+	}
+
+	return p.next()
+}
+
+func (p *parser) expectName() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.errorf("expected name, found %q", p.tok.value)
+	}
+
+	name := p.tok.value
+
+	return name, p.next()
+}
+
+func (p *parser) parseOperation() (*Operation, error) {
+	op := &Operation{Type: p.tok.value}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenName {
+		op.Name = p.tok.value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.isPunct("(") {
+		vars, err := p.parseVariableDefinitions()
+		if err != nil {
+			return nil, err
+		}
+
+		op.Variables = vars
+	}
+
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+
+	sel, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	op.Selections = sel
+
+	return op, nil
+}
+
+func (p *parser) parseVariableDefinitions() ([]*VariableDefinition, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	var defs []*VariableDefinition
+
+	for !p.isPunct(")") {
+		if err := p.expectPunct("$"); err != nil {
+			return nil, err
+		}
+
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expectPunct(":"); err != nil {
+			return nil, err
+		}
+
+		if err := p.skipType(); err != nil {
+			return nil, err
+		}
+
+		def := &VariableDefinition{Name: name}
+
+		if p.isPunct("=") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+
+			if def.Default, err = p.parseValue(true); err != nil {
+				return nil, err
+			}
+		}
+
+		defs = append(defs, def)
+	}
+
+	return defs, p.next()
+}
+
+// skipType consumes a type reference such as [String!]!. Variables are not
+// type checked, resolvers validate their arguments themselves.
+func (p *parser) skipType() error {
+	if p.isPunct("[") {
+		if err := p.next(); err != nil {
+			return err
+		}
+
+		if err := p.skipType(); err != nil {
+			return err
+		}
+
+		if err := p.expectPunct("]"); err != nil {
+			return err
+		}
+	} else if _, err := p.expectName(); err != nil {
+		return err
+	}
+
+	if p.isPunct("!") {
+		return p.next()
+	}
+
+	return nil
+}
+
+func (p *parser) parseFragment() (*Fragment, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.isName("on") {
+		return nil, p.errorf("expected \"on\", found %q", p.tok.value)
+	}
+
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	typeCondition, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.parseDirectives(); err != nil {
+		return nil, err
+	}
+
+	sel, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Fragment{Name: name, TypeCondition: typeCondition, Selections: sel}, nil
+}
+
+func (p *parser) parseSelectionSet() ([]Selection, error) {
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+
+	var selections []Selection
+
+	for !p.isPunct("}") {
+		if p.tok.kind == tokenEOF {
+			return nil, p.errorf("unexpected end of document")
+		}
+
+		sel, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+
+		selections = append(selections, sel)
+	}
+
+	if len(selections) == 0 {
+		return nil, p.errorf("empty selection set")
+	}
+
+	return selections, p.next()
+}
+
+func (p *parser) parseSelection() (Selection, error) {
+	if p.isPunct("...") {
+		return p.parseFragmentSelection()
+	}
+
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	field := &Field{Name: name}
+
+	if p.isPunct(":") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		field.Alias = name
+		if field.Name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.isPunct("(") {
+		if field.Arguments, err = p.parseArguments(); err != nil {
+			return nil, err
+		}
+	}
+
+	if field.Directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+
+	if p.isPunct("{") {
+		if field.Selections, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+
+	return field, nil
+}
+
+func (p *parser) parseFragmentSelection() (Selection, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenName && !p.isName("on") {
+		name := p.tok.value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		directives, err := p.parseDirectives()
+		if err != nil {
+			return nil, err
+		}
+
+		return &FragmentSpread{Name: name, Directives: directives}, nil
+	}
+
+	inline := &InlineFragment{}
+
+	if p.isName("on") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+
+		inline.TypeCondition = name
+	}
+
+	var err error
+	if inline.Directives, err = p.parseDirectives(); err != nil {
+		return nil, err
+	}
+
+	if inline.Selections, err = p.parseSelectionSet(); err != nil {
+		return nil, err
+	}
+
+	return inline, nil
+}
+
+func (p *parser) parseDirectives() ([]*Directive, error) {
+	var directives []*Directive
+
+	for p.isPunct("@") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+
+		d := &Directive{Name: name}
+
+		if p.isPunct("(") {
+			if d.Arguments, err = p.parseArguments(); err != nil {
+				return nil, err
+			}
+		}
+
+		directives = append(directives, d)
+	}
+
+	return directives, nil
+}
+
+func (p *parser) parseArguments() (map[string]interface{}, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+
+	args := make(map[string]interface{})
+
+	for !p.isPunct(")") {
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expectPunct(":"); err != nil {
+			return nil, err
+		}
+
+		if args[name], err = p.parseValue(false); err != nil {
+			return nil, err
+		}
+	}
+
+	return args, p.next()
+}
+
+// parseValue parses an argument value. Variables are not allowed in constant
+// positions such as variable defaults.
+func (p *parser) parseValue(constant bool) (interface{}, error) {
+	tok := p.tok
+
+	switch {
+	case p.isPunct("$") && !constant:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		name, err := p.expectName()
+
+		return Variable(name), err
+
+	case p.isPunct("["):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		list := []interface{}{}
+
+		for !p.isPunct("]") {
+			v, err := p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, v)
+		}
+
+		return list, p.next()
+
+	case p.isPunct("{"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+
+		obj := map[string]interface{}{}
+
+		for !p.isPunct("}") {
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := p.expectPunct(":"); err != nil {
+				return nil, err
+			}
+
+			if obj[name], err = p.parseValue(constant); err != nil {
+				return nil, err
+			}
+		}
+
+		return obj, p.next()
+
+	case tok.kind == tokenString:
+		return tok.value, p.next()
+
+	case tok.kind == tokenInt:
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid int %q", tok.value)
+		}
+
+		return n, p.next()
+
+	case tok.kind == tokenFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, p.errorf("invalid float %q", tok.value)
+		}
+
+		return f, p.next()
+
+	case tok.kind == tokenName:
+		var v interface{}
+
+		switch tok.value {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			v = Enum(tok.value)
+		}
+
+		return v, p.next()
+	}
+
+	return nil, p.errorf("unexpected %q", tok.value)
+}
+
+// next advances to the next token, skipping whitespace, commas and comments.
+func (p *parser) next() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+
+			continue
+		}
+
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.pos++
+			continue
+		}
+
+		// Byte order mark.
+		if strings.HasPrefix(p.src[p.pos:], "\uFEFF") {
+			p.pos += len("\uFEFF")
+			continue
+		}
+
+		break
+	}
+
+	start := p.pos
+
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokenEOF, value: "<EOF>", pos: start}
+		return nil
+	}
+
+	c := p.src[p.pos]
+
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.pos += 3
+		p.tok = token{kind: tokenPunct, value: "...", pos: start}
+
+	case strings.ContainsRune("!$():=@[]{}|&", rune(c)):
+		p.pos++
+		p.tok = token{kind: tokenPunct, value: string(c), pos: start}
+
+	case c == '_' || isLetter(c):
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+
+		p.tok = token{kind: tokenName, value: p.src[start:p.pos], pos: start}
+
+	case c == '-' || isDigit(c):
+		return p.lexNumber()
+
+	case c == '"':
+		return p.lexString()
+
+	default:
+		p.tok = token{pos: start}
+		return p.errorf("unexpected character %q", c)
+	}
+
+	return nil
+}
+
+func (p *parser) lexNumber() error {
+	start := p.pos
+	kind := tokenInt
+
+	if p.src[p.pos] == '-' {
+		p.pos++
+	}
+
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+
+		switch {
+		case isDigit(c):
+		case c == '.' || c == 'e' || c == 'E':
+			kind = tokenFloat
+		case (c == '+' || c == '-') && kind == tokenFloat:
+		default:
+			p.tok = token{kind: kind, value: p.src[start:p.pos], pos: start}
+			return nil
+		}
+
+		p.pos++
+	}
+
+	p.tok = token{kind: kind, value: p.src[start:p.pos], pos: start}
+
+	return nil
+}
+
+func (p *parser) lexString() error {
+	start := p.pos
+
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		end := strings.Index(p.src[p.pos+3:], `"""`)
+		if end < 0 {
+			p.tok = token{pos: start}
+			return p.errorf("unterminated block string")
+		}
+
+		p.tok = token{kind: tokenString, value: strings.TrimSpace(p.src[p.pos+3 : p.pos+3+end]), pos: start}
+		p.pos += end + 6
+
+		return nil
+	}
+
+	p.pos++
+
+	var sb strings.Builder
+
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+
+		switch c {
+		case '"':
+			p.pos++
+			p.tok = token{kind: tokenString, value: sb.String(), pos: start}
+
+			return nil
+
+		case '\n':
+			p.tok = token{pos: start}
+			return p.errorf("unterminated string")
+
+		case '\\':
+			if p.pos+1 >= len(p.src) {
+				p.tok = token{pos: start}
+				return p.errorf("unterminated string")
+			}
+
+			esc := p.src[p.pos+1]
+			p.pos += 2
+
+			switch esc {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'u':
+				if p.pos+4 > len(p.src) {
+					p.tok = token{pos: start}
+					return p.errorf("invalid unicode escape")
+				}
+
+				r, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+				if err != nil {
+					p.tok = token{pos: start}
+					return p.errorf("invalid unicode escape")
+				}
+
+				sb.WriteRune(rune(r))
+				p.pos += 4
+			default:
+				sb.WriteByte(esc)
+			}
+
+		default:
+			r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+			sb.WriteRune(r)
+			p.pos += size
+		}
+	}
+
+	p.tok = token{pos: start}
+
+	return p.errorf("unterminated string")
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
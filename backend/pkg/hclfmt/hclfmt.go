@@ -0,0 +1,378 @@
+// Package hclfmt formats HCL job specifications and reports their syntax
+// errors, for the job editor.
+//
+// It does not parse HCL into a syntax tree. A small lexer tracks brackets,
+// quoted templates, heredocs and comments, which is enough to re-indent
+// blocks, align the equals signs of consecutive attributes the way
+// `nomad fmt` does and point at unbalanced brackets or unterminated strings.
+// Everything else, including heredoc bodies, is left as written. Schema
+// validation needs the real parser and is done by Nomad.
+package hclfmt
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Severities of diagnostics
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// indent is the indentation of one nesting level
+const indent = "  "
+
+// Pos is a position in the source. Line and Column start at 1; Column
+// counts characters, Byte is the offset in bytes.
+type Pos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Byte   int `json:"byte"`
+}
+
+// Range is the span of source a diagnostic applies to.
+type Range struct {
+	Start Pos `json:"start"`
+	End   Pos `json:"end"`
+}
+
+// Diagnostic is a problem found in the source. Range is nil when the
+// problem has no known location.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Summary  string `json:"summary"`
+	Detail   string `json:"detail,omitempty"`
+	Range    *Range `json:"range,omitempty"`
+}
+
+// HasErrors reports whether any of diags is an error.
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// line is a source line as seen by the lexer
+type line struct {
+	text string
+	// code is false for lines that start inside a heredoc, block comment or
+	// multi-line template, which are kept as written
+	code bool
+	// depth is the number of brackets open at the start of the line
+	depth int
+}
+
+// open is a bracket or quote the lexer has not seen closed yet
+type open struct {
+	char byte
+	pos  Pos
+}
+
+var heredocRe = regexp.MustCompile(`^<<-?([A-Za-z_][A-Za-z0-9_-]*)\s*$`)
+
+// closers maps closing brackets to the bracket they close
+var closers = map[byte]byte{'}': '{', ']': '[', ')': '('}
+
+// scan splits src into lines and reports its syntax errors
+func scan(src string) ([]line, []Diagnostic) {
+	var (
+		lines []line
+		diags []Diagnostic
+		stack []open
+
+		heredoc      string
+		heredocStart Pos
+		inComment    bool
+		commentStart Pos
+	)
+
+	top := func() byte {
+		if len(stack) == 0 {
+			return 0
+		}
+		return stack[len(stack)-1].char
+	}
+	depth := func() int {
+		n := 0
+		for _, o := range stack {
+			if o.char == '{' || o.char == '[' || o.char == '(' {
+				n++
+			}
+		}
+		return n
+	}
+	inString := func() bool {
+		for _, o := range stack {
+			if o.char == '"' {
+				return true
+			}
+		}
+		return false
+	}
+
+	offset := 0
+
+	for n, text := range strings.Split(src, "\n") {
+		pos := func(i int) Pos {
+			return Pos{Line: n + 1, Column: utf8.RuneCountInString(text[:i]) + 1, Byte: offset + i}
+		}
+
+		lines = append(lines, line{text: text, code: heredoc == "" && !inComment && !inString(), depth: depth()})
+
+		if heredoc != "" {
+			if strings.TrimSpace(text) == heredoc {
+				heredoc = ""
+			}
+			offset += len(text) + 1
+			continue
+		}
+
+	chars:
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			rest := text[i:]
+
+			if inComment {
+				if strings.HasPrefix(rest, "*/") {
+					inComment = false
+					i++
+				}
+				continue
+			}
+
+			if top() == '"' {
+				switch {
+				case c == '\\':
+					i++
+				case strings.HasPrefix(rest, "$${"), strings.HasPrefix(rest, "%%{"):
+					i += 2
+				case strings.HasPrefix(rest, "${"), strings.HasPrefix(rest, "%{"):
+					stack = append(stack, open{char: '$', pos: pos(i)})
+					i++
+				case c == '"':
+					stack = stack[:len(stack)-1]
+				}
+				continue
+			}
+
+			switch {
+			case c == '#', strings.HasPrefix(rest, "//"):
+				break chars
+			case strings.HasPrefix(rest, "/*"):
+				inComment = true
+				commentStart = pos(i)
+				i++
+			case c == '"':
+				stack = append(stack, open{char: '"', pos: pos(i)})
+			case strings.HasPrefix(rest, "<<"):
+				if m := heredocRe.FindStringSubmatch(rest); m != nil {
+					heredoc = m[1]
+					heredocStart = pos(i)
+					break chars
+				}
+			case c == '{', c == '[', c == '(':
+				stack = append(stack, open{char: c, pos: pos(i)})
+			case closers[c] != 0:
+				switch {
+				case c == '}' && top() == '$', top() == closers[c]:
+					stack = stack[:len(stack)-1]
+				default:
+					diags = append(diags, errorAt(pos(i), fmt.Sprintf("Unexpected %q", c),
+						"There is no open bracket for this one to close."))
+				}
+			}
+		}
+
+		// Quoted templates end on the line they start, interpolations
+		// inside them may span lines
+		if top() == '"' {
+			o := stack[len(stack)-1]
+			diags = append(diags, errorAt(o.pos, "Unterminated template string",
+				"No closing marker was found for the string."))
+			stack = stack[:len(stack)-1]
+		}
+
+		offset += len(text) + 1
+	}
+
+	switch {
+	case heredoc != "":
+		diags = append(diags, errorAt(heredocStart, "Unterminated template string",
+			fmt.Sprintf("No closing marker %q was found for the heredoc.", heredoc)))
+	case inComment:
+		diags = append(diags, errorAt(commentStart, "Unterminated comment",
+			"No closing \"*/\" was found for the comment."))
+	}
+
+	for _, o := range stack {
+		diags = append(diags, errorAt(o.pos, fmt.Sprintf("Unclosed %q", o.char),
+			"The bracket is never closed."))
+	}
+
+	return lines, diags
+}
+
+func errorAt(pos Pos, summary, detail string) Diagnostic {
+	end := pos
+	end.Column++
+	end.Byte++
+
+	return Diagnostic{
+		Severity: SeverityError,
+		Summary:  summary,
+		Detail:   detail,
+		Range:    &Range{Start: pos, End: end},
+	}
+}
+
+// Check returns the syntax errors of src.
+func Check(src []byte) []Diagnostic {
+	_, diags := scan(string(src))
+	return diags
+}
+
+// Format formats src. If src has syntax errors it is returned unchanged,
+// together with the errors.
+func Format(src []byte) ([]byte, []Diagnostic) {
+	lines, diags := scan(string(src))
+	if HasErrors(diags) {
+		return src, diags
+	}
+
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		if !l.code {
+			out[i] = l.text
+			continue
+		}
+
+		text := strings.TrimSpace(l.text)
+		if text == "" {
+			continue
+		}
+
+		level := l.depth - leadingClosers(text)
+		out[i] = strings.Repeat(indent, max(level, 0)) + text
+	}
+
+	alignAttributes(out, lines)
+
+	formatted := strings.TrimRight(strings.Join(out, "\n"), "\n")
+	if formatted != "" {
+		formatted += "\n"
+	}
+
+	return []byte(formatted), diags
+}
+
+// leadingClosers counts the closing brackets a line starts with, which
+// belong to the enclosing level
+func leadingClosers(text string) int {
+	n := 0
+	for n < len(text) && closers[text[n]] != 0 {
+		n++
+	}
+
+	return n
+}
+
+var attributeRe = regexp.MustCompile(`^(\s*)("(?:[^"\\]|\\.)*"|[A-Za-z_][A-Za-z0-9_-]*)\s*=\s*([^=].*|)$`)
+
+type attribute struct {
+	index               int
+	indent, name, value string
+}
+
+// alignAttributes aligns the equals signs of runs of consecutive
+// attributes at the same level. An attribute whose value spans lines is not
+// aligned and ends the run before it.
+func alignAttributes(out []string, lines []line) {
+	var run []attribute
+
+	flush := func() {
+		width := 0
+		for _, a := range run {
+			width = max(width, utf8.RuneCountInString(a.name))
+		}
+
+		for _, a := range run {
+			padding := strings.Repeat(" ", width-utf8.RuneCountInString(a.name))
+			out[a.index] = strings.TrimRight(a.indent+a.name+padding+" = "+a.value, " ")
+		}
+
+		run = nil
+	}
+
+	for i, text := range out {
+		m := attributeRe.FindStringSubmatch(text)
+		if !lines[i].code || m == nil {
+			flush()
+			continue
+		}
+
+		a := attribute{index: i, indent: m[1], name: m[2], value: m[3]}
+
+		if i+1 < len(lines) && (!lines[i+1].code || lines[i+1].depth != lines[i].depth) {
+			flush()
+			run = []attribute{a}
+			flush()
+			continue
+		}
+
+		if len(run) > 0 && run[0].indent != a.indent {
+			flush()
+		}
+
+		run = append(run, a)
+	}
+
+	flush()
+}
+
+// nomadDiagRe matches the diagnostics in Nomad's parse errors, such as
+// `input.hcl:3,5-9: Unsupported argument; An argument named "x" is not expected here.`
+var nomadDiagRe = regexp.MustCompile(`[\w.-]+:(\d+),(\d+)(?:-(?:(\d+),)?(\d+))?: ([^;\n]+)(?:; ([^\n]*))?`)
+
+// FromNomadError turns the message of a failed Nomad parse into diagnostics,
+// with a location wherever Nomad gave one.
+func FromNomadError(msg string) []Diagnostic {
+	matches := nomadDiagRe.FindAllStringSubmatch(msg, -1)
+	if len(matches) == 0 {
+		return []Diagnostic{{Severity: SeverityError, Summary: strings.TrimSpace(msg)}}
+	}
+
+	diags := make([]Diagnostic, 0, len(matches))
+	for _, m := range matches {
+		start := Pos{Line: atoi(m[1]), Column: atoi(m[2])}
+
+		end := start
+		if m[3] != "" {
+			end.Line = atoi(m[3])
+		}
+		if m[4] != "" {
+			end.Column = atoi(m[4])
+		}
+
+		diags = append(diags, Diagnostic{
+			Severity: SeverityError,
+			Summary:  strings.TrimSpace(m[5]),
+			Detail:   strings.TrimSpace(m[6]),
+			Range:    &Range{Start: start, End: end},
+		})
+	}
+
+	return diags
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
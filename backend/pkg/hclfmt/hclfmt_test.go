@@ -0,0 +1,111 @@
+package hclfmt_test
+
+import (
+	"testing"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/hclfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	src := `job "web" {
+datacenters = ["dc1"]
+    type="service"
+
+  group "web" {
+  count = 2
+      # keep the comment
+    task "server" {
+    driver = "docker"
+    config {
+    image = "nginx:${var.tag}"
+    args = [
+    "-g",
+    "daemon off;",
+    ]
+    }
+    template {
+      destination = "local/app.env"
+      data = <<EOT
+   UNTOUCHED=true
+EOT
+    }
+    }
+  }
+}`
+
+	want := `job "web" {
+  datacenters = ["dc1"]
+  type        = "service"
+
+  group "web" {
+    count = 2
+    # keep the comment
+    task "server" {
+      driver = "docker"
+      config {
+        image = "nginx:${var.tag}"
+        args = [
+          "-g",
+          "daemon off;",
+        ]
+      }
+      template {
+        destination = "local/app.env"
+        data = <<EOT
+   UNTOUCHED=true
+EOT
+      }
+    }
+  }
+}
+`
+
+	formatted, diags := hclfmt.Format([]byte(src))
+	assert.Empty(t, diags)
+	assert.Equal(t, want, string(formatted))
+
+	again, _ := hclfmt.Format(formatted)
+	assert.Equal(t, want, string(again), "formatting is idempotent")
+}
+
+func TestCheckReportsPositions(t *testing.T) {
+	diags := hclfmt.Check([]byte("job \"web\" {\n  type = \"service\n}\n"))
+	require.Len(t, diags, 1)
+	assert.Equal(t, "Unterminated template string", diags[0].Summary)
+	assert.Equal(t, hclfmt.Pos{Line: 2, Column: 10, Byte: 21}, diags[0].Range.Start)
+
+	diags = hclfmt.Check([]byte("job \"web\" {\n  group \"web\" {\n}\n"))
+	require.Len(t, diags, 1)
+	assert.Equal(t, `Unclosed '{'`, diags[0].Summary)
+	assert.Equal(t, 1, diags[0].Range.Start.Line)
+
+	diags = hclfmt.Check([]byte("job \"web\" {}\n}\n"))
+	require.Len(t, diags, 1)
+	assert.Equal(t, 2, diags[0].Range.Start.Line)
+
+	assert.Empty(t, hclfmt.Check([]byte(`meta = { "a}" = "${format("%s}", "{")}" } # }`)))
+}
+
+func TestFormatKeepsInvalidSource(t *testing.T) {
+	src := []byte("job \"web\" {\n  x = [1, 2\n}\n")
+
+	formatted, diags := hclfmt.Format(src)
+	assert.True(t, hclfmt.HasErrors(diags))
+	assert.Equal(t, src, formatted)
+}
+
+func TestFromNomadError(t *testing.T) {
+	diags := hclfmt.FromNomadError(`input.hcl:3,5-9: Unsupported argument; An argument named "cont" is not expected here.`)
+	require.Len(t, diags, 1)
+	assert.Equal(t, "Unsupported argument", diags[0].Summary)
+	assert.Equal(t, `An argument named "cont" is not expected here.`, diags[0].Detail)
+	assert.Equal(t, 3, diags[0].Range.Start.Line)
+	assert.Equal(t, 5, diags[0].Range.Start.Column)
+	assert.Equal(t, 9, diags[0].Range.End.Column)
+
+	diags = hclfmt.FromNomadError("something else broke")
+	require.Len(t, diags, 1)
+	assert.Nil(t, diags[0].Range)
+}
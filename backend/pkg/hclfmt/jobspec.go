@@ -0,0 +1,375 @@
+package hclfmt
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// configBlocks are the driver config keys that drivers declare as blocks
+// rather than attributes, such as Docker's mount and logging. Other nested
+// config values are written as attributes.
+var configBlocks = map[string]bool{
+	"auth":         true,
+	"config":       true,
+	"devices":      true,
+	"healthchecks": true,
+	"logging":      true,
+	"mount":        true,
+	"mounts":       true,
+}
+
+// blockNames renames blocks whose hcl tag is not the jobspec name
+var blockNames = map[string]string{
+	// Static ports are declared like dynamic ones, with a static value
+	"reserved_ports": "port",
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	identifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+)
+
+// JobToHCL writes a job as an HCL jobspec. The job is walked through the
+// hcl tags of the SDK structs, so fields Nomad sets on its own, such as the
+// status or indexes, are left out, as are empty values.
+func JobToHCL(job *api.Job) []byte {
+	var w hclWriter
+
+	id := ""
+	if job.ID != nil {
+		id = *job.ID
+	}
+
+	w.open("job", id)
+
+	// The ID is the label, the name only needs writing when it differs
+	copied := *job
+	copied.ID = nil
+	if copied.Name != nil && *copied.Name == id {
+		copied.Name = nil
+	}
+	w.body(reflect.ValueOf(copied))
+
+	w.close()
+
+	formatted, _ := Format([]byte(w.String()))
+
+	return formatted
+}
+
+// hclWriter writes HCL, unindented; Format indents it
+type hclWriter struct {
+	strings.Builder
+}
+
+func (w *hclWriter) open(name string, labels ...string) {
+	w.WriteString(name)
+	for _, label := range labels {
+		w.WriteString(" " + quote(label))
+	}
+	w.WriteString(" {\n")
+}
+
+func (w *hclWriter) close() {
+	w.WriteString("}\n")
+}
+
+func (w *hclWriter) attr(name, value string) {
+	w.WriteString(key(name) + " = " + value + "\n")
+}
+
+// body writes the fields of a struct
+func (w *hclWriter) body(v reflect.Value) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		name, kind, _ := strings.Cut(t.Field(i).Tag.Get("hcl"), ",")
+		if name == "" || kind == "label" || kind == "remain" {
+			continue
+		}
+
+		field := v.Field(i)
+		if isEmpty(field) {
+			continue
+		}
+
+		if renamed, ok := blockNames[name]; ok {
+			name = renamed
+		}
+
+		if kind == "block" || isBlockType(field.Type()) {
+			w.blocks(name, field)
+			continue
+		}
+
+		w.attr(name, value(field))
+	}
+}
+
+// blocks writes a field holding one or more blocks
+func (w *hclWriter) blocks(name string, v reflect.Value) {
+	v = deref(v)
+
+	switch v.Kind() {
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if !isEmpty(v.Index(i)) {
+				w.block(name, "", v.Index(i))
+			}
+		}
+	case reflect.Map:
+		if !isBlockType(v.Type().Elem()) {
+			w.mapBlock(name, v)
+			return
+		}
+
+		for _, k := range sortedKeys(v) {
+			w.block(name, k.String(), v.MapIndex(k))
+		}
+	default:
+		w.block(name, "", v)
+	}
+}
+
+// block writes a struct as a block. label is used if the struct has no
+// label field of its own, as for volumes keyed by name.
+func (w *hclWriter) block(name, label string, v reflect.Value) {
+	v = deref(v)
+
+	if l := labelOf(v); l != "" {
+		label = l
+	}
+
+	if label != "" {
+		w.open(name, label)
+	} else {
+		w.open(name)
+	}
+	w.body(v)
+	w.close()
+}
+
+// mapBlock writes a map as a block of attributes, such as meta or env
+func (w *hclWriter) mapBlock(name string, v reflect.Value) {
+	w.open(name)
+
+	for _, k := range sortedKeys(v) {
+		w.configValue(k.String(), v.MapIndex(k))
+	}
+
+	w.close()
+}
+
+// configValue writes a free-form value, such as a driver config entry
+func (w *hclWriter) configValue(name string, v reflect.Value) {
+	v = deref(v)
+
+	if configBlocks[name] {
+		switch v.Kind() {
+		case reflect.Map:
+			w.mapBlock(name, v)
+			return
+		case reflect.Slice:
+			if allMaps(v) {
+				for i := 0; i < v.Len(); i++ {
+					w.mapBlock(name, deref(v.Index(i)))
+				}
+				return
+			}
+		}
+	}
+
+	w.attr(name, value(v))
+}
+
+// value writes an attribute value as an HCL expression
+func value(v reflect.Value) string {
+	v = deref(v)
+
+	if !v.IsValid() {
+		return "null"
+	}
+
+	if v.Type() == durationType {
+		return quote(time.Duration(v.Int()).String())
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return quote(v.String())
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.Slice, reflect.Array:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = value(v.Index(i))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		entries := make([]string, 0, v.Len())
+		for _, k := range sortedKeys(v) {
+			entries = append(entries, key(fmt.Sprint(k.Interface()))+" = "+value(v.MapIndex(k)))
+		}
+		if len(entries) == 0 {
+			return "{}"
+		}
+		return "{\n" + strings.Join(entries, "\n") + "\n}"
+	case reflect.Struct:
+		var w hclWriter
+		w.body(v)
+		return "{\n" + w.String() + "}"
+	default:
+		return quote(fmt.Sprint(v.Interface()))
+	}
+}
+
+// quote writes a string as an HCL string. Nomad's runtime interpolations,
+// such as ${NOMAD_ALLOC_ID}, are escaped so HCL keeps them as they are.
+func quote(s string) string {
+	escaped := strings.NewReplacer("${", "$${", "%{", "%%{").Replace(s)
+
+	// Multi-line strings, such as templates, read better as heredocs. A
+	// heredoc always ends with a newline, so other strings stay quoted.
+	if strings.HasSuffix(escaped, "\n") && strings.Count(escaped, "\n") > 1 &&
+		!strings.Contains("\n"+escaped, "\nEOT\n") {
+		return "<<EOT\n" + escaped + "EOT"
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+
+	for _, r := range escaped {
+		switch {
+		case r == '"', r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// key writes an attribute or object key, quoted unless it is an identifier
+func key(k string) string {
+	if identifierRe.MatchString(k) {
+		return k
+	}
+
+	return strconv.Quote(k)
+}
+
+func deref(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+
+	return v
+}
+
+// isEmpty reports whether a field is left out: unset, empty or false. Zero
+// numbers are only left out when they are not pointers, since a pointer to
+// zero, such as a group count of 0, was set on purpose.
+func isEmpty(v reflect.Value) bool {
+	pointer := v.Kind() == reflect.Pointer
+	v = deref(v)
+
+	if !v.IsValid() {
+		return true
+	}
+
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0 && (!pointer || v.Type() == durationType)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0 && !pointer
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0 && !pointer
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			tag := v.Type().Field(i).Tag.Get("hcl")
+			if tag != "" && !strings.HasSuffix(tag, ",remain") && !isEmpty(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// isBlockType reports whether values of t are written as blocks
+func isBlockType(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+
+	return t.Kind() == reflect.Struct && t != durationType
+}
+
+// labelOf returns the value of a struct's label field
+func labelOf(v reflect.Value) string {
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		if _, kind, _ := strings.Cut(v.Type().Field(i).Tag.Get("hcl"), ","); kind == "label" {
+			if label := deref(v.Field(i)); label.Kind() == reflect.String {
+				return label.String()
+			}
+		}
+	}
+
+	return ""
+}
+
+func allMaps(v reflect.Value) bool {
+	for i := 0; i < v.Len(); i++ {
+		if deref(v.Index(i)).Kind() != reflect.Map {
+			return false
+		}
+	}
+
+	return v.Len() > 0
+}
+
+func sortedKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+
+	return keys
+}
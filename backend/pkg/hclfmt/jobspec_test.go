@@ -0,0 +1,71 @@
+package hclfmt_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/hclfmt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobToHCL(t *testing.T) {
+	job := api.NewServiceJob("web", "web", "global", 50)
+	job.Datacenters = []string{"dc1"}
+	job.Status = pointerOf("running")
+	job.Meta = map[string]string{"team": "platform", "owner.email": "ops@example.com"}
+
+	task := api.NewTask("server", "docker")
+	task.Config = map[string]interface{}{
+		"image": "nginx:1.27",
+		"ports": []interface{}{"http"},
+		"mount": []interface{}{
+			map[string]interface{}{"type": "bind", "source": "local", "target": "/etc/nginx"},
+		},
+	}
+	task.Env = map[string]string{"ALLOC": "${NOMAD_ALLOC_ID}"}
+	task.KillTimeout = pointerOf(10 * time.Second)
+	task.Templates = []*api.Template{{
+		EmbeddedTmpl: pointerOf("PORT={{ env \"NOMAD_PORT_http\" }}\nID=${NOMAD_ALLOC_ID}\n"),
+		DestPath:     pointerOf("local/app.env"),
+	}}
+
+	group := api.NewTaskGroup("web", 0).AddTask(task)
+	group.Networks = []*api.NetworkResource{{
+		DynamicPorts:  []api.Port{{Label: "http", To: 8080}},
+		ReservedPorts: []api.Port{{Label: "metrics", Value: 9100}},
+	}}
+	job.AddTaskGroup(group)
+
+	out := string(hclfmt.JobToHCL(job))
+
+	for _, want := range []string{
+		"job \"web\" {\n",
+		`  datacenters = ["dc1"]`,
+		`  priority    = 50`,
+		"    \"owner.email\" = \"ops@example.com\"\n",
+		"  group \"web\" {\n    count = 0\n",
+		"      port \"http\" {\n        to = 8080\n",
+		"      port \"metrics\" {\n        static = 9100\n",
+		"    task \"server\" {\n",
+		`        image = "nginx:1.27"`,
+		`        ports = ["http"]`,
+		"        mount {\n          source = \"local\"\n",
+		`        ALLOC = "$${NOMAD_ALLOC_ID}"`,
+		`      kill_timeout = "10s"`,
+		"        data = <<EOT\nPORT={{ env \"NOMAD_PORT_http\" }}\nID=$${NOMAD_ALLOC_ID}\nEOT\n",
+	} {
+		assert.Contains(t, out, want)
+	}
+
+	assert.NotContains(t, out, "running", "status is set by Nomad")
+	assert.NotContains(t, out, "name =", "name matches the ID")
+	assert.Empty(t, hclfmt.Check([]byte(out)))
+
+	again, _ := hclfmt.Format([]byte(out))
+	assert.Equal(t, out, string(again))
+}
+
+func pointerOf[T any](v T) *T {
+	return &v
+}
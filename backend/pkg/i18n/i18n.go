@@ -0,0 +1,196 @@
+// Package i18n provides a message catalog for server-side messages and
+// resolves the language to use from a request's Accept-Language header.
+//
+// Messages are keyed by the API error codes and shipped as JSON files in the
+// locales directory, one file per language, so new translations can be added
+// without touching any handler code.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLanguage is used when no requested language is available.
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+// Catalog holds translated messages keyed by language and message key.
+type Catalog struct {
+	fallback string
+	messages map[string]map[string]string
+	mutex    sync.RWMutex
+}
+
+// NewCatalog creates an empty catalog that falls back to the given language.
+func NewCatalog(fallback string) *Catalog {
+	return &Catalog{
+		fallback: fallback,
+		messages: make(map[string]map[string]string),
+	}
+}
+
+// Add registers messages for a language, replacing existing keys.
+func (c *Catalog) Add(lang string, messages map[string]string) {
+	lang = normalizeTag(lang)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.messages[lang] == nil {
+		c.messages[lang] = make(map[string]string, len(messages))
+	}
+
+	for key, msg := range messages {
+		c.messages[lang][key] = msg
+	}
+}
+
+// Languages returns the languages available in the catalog, sorted.
+func (c *Catalog) Languages() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	langs := make([]string, 0, len(c.messages))
+	for lang := range c.messages {
+		langs = append(langs, lang)
+	}
+
+	sort.Strings(langs)
+
+	return langs
+}
+
+// Message returns the message for key in lang, falling back to the catalog's
+// fallback language. The boolean is false if neither has the key.
+func (c *Catalog) Message(lang, key string) (string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if msg, ok := c.messages[normalizeTag(lang)][key]; ok {
+		return msg, true
+	}
+
+	msg, ok := c.messages[c.fallback][key]
+
+	return msg, ok
+}
+
+// Negotiate picks the best available language for an Accept-Language header.
+// A regional tag such as "de-AT" matches a catalog entry for "de".
+func (c *Catalog) Negotiate(acceptLanguage string) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for _, tag := range ParseAcceptLanguage(acceptLanguage) {
+		if tag == "*" {
+			return c.fallback
+		}
+
+		if _, ok := c.messages[tag]; ok {
+			return tag
+		}
+
+		if base, _, found := strings.Cut(tag, "-"); found {
+			if _, ok := c.messages[base]; ok {
+				return base
+			}
+		}
+	}
+
+	return c.fallback
+}
+
+// ParseAcceptLanguage returns the language tags of an Accept-Language header
+// ordered by preference. Tags with a quality of zero are dropped.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []weighted
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = normalizeTag(tag)
+
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+
+			q = parsed
+		}
+
+		if q <= 0 {
+			continue
+		}
+
+		tags = append(tags, weighted{tag: tag, q: q})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+
+	result := make([]string, 0, len(tags))
+	for _, t := range tags {
+		result = append(result, t.tag)
+	}
+
+	return result
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+// loadEmbedded adds all embedded locale files to the catalog.
+func loadEmbedded(c *Catalog) error {
+	entries, err := localesFS.ReadDir("locales")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		data, err := localesFS.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("parsing locale %s: %w", entry.Name(), err)
+		}
+
+		c.Add(strings.TrimSuffix(entry.Name(), ".json"), messages)
+	}
+
+	return nil
+}
+
+// Default is the catalog of built-in server messages.
+var Default = func() *Catalog {
+	c := NewCatalog(DefaultLanguage)
+	if err := loadEmbedded(c); err != nil {
+		panic(err)
+	}
+
+	return c
+}()
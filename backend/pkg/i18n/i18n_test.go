@@ -0,0 +1,123 @@
+package i18n_test
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/i18n"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []string
+	}{
+		{"", []string{}},
+		{"fr", []string{"fr"}},
+		{"de-DE,de;q=0.9,en;q=0.8", []string{"de-de", "de", "en"}},
+		{"en;q=0.5, es", []string{"es", "en"}},
+		{"pt_BR, fr;q=0", []string{"pt-br"}},
+		{"it;q=abc, ja", []string{"ja"}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, i18n.ParseAcceptLanguage(test.header), test.header)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	c := i18n.NewCatalog("en")
+	c.Add("en", map[string]string{"HELLO": "Hello"})
+	c.Add("de", map[string]string{"HELLO": "Hallo"})
+	c.Add("pt-BR", map[string]string{"HELLO": "Olá"})
+
+	assert.Equal(t, "en", c.Negotiate(""))
+	assert.Equal(t, "de", c.Negotiate("de-AT"))
+	assert.Equal(t, "pt-br", c.Negotiate("pt-BR,pt;q=0.9"))
+	assert.Equal(t, "en", c.Negotiate("ja, zh;q=0.5"))
+	assert.Equal(t, "de", c.Negotiate("ja, de;q=0.5"))
+	assert.Equal(t, "en", c.Negotiate("*"))
+	assert.Equal(t, []string{"de", "en", "pt-br"}, c.Languages())
+}
+
+func TestMessageFallback(t *testing.T) {
+	c := i18n.NewCatalog("en")
+	c.Add("en", map[string]string{"A": "a", "B": "b"})
+	c.Add("de", map[string]string{"A": "ä"})
+
+	msg, ok := c.Message("de", "A")
+	assert.True(t, ok)
+	assert.Equal(t, "ä", msg)
+
+	msg, ok = c.Message("de", "B")
+	assert.True(t, ok)
+	assert.Equal(t, "b", msg)
+
+	_, ok = c.Message("de", "C")
+	assert.False(t, ok)
+}
+
+func TestDefaultCatalogCoversAllLanguages(t *testing.T) {
+	en := []string{"BAD_REQUEST", "CLUSTER_NOT_FOUND", "TOKEN_EXPIRED", "NOMAD_UNREACHABLE", "INTERNAL_ERROR"}
+
+	for _, lang := range i18n.Default.Languages() {
+		for _, key := range en {
+			_, ok := i18n.Default.Message(lang, key)
+			assert.True(t, ok, "%s is missing %s", lang, key)
+		}
+	}
+}
+
+// TestLocalesCoverErrorCodes reads the codes off the apierror source, since
+// the package has no list of them, and the locale files themselves, since
+// the catalog falls back to English for a missing message
+func TestLocalesCoverErrorCodes(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "../apierror/apierror.go", nil, 0)
+	require.NoError(t, err)
+
+	var codes []string
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			if ident, ok := value.Type.(*ast.Ident); !ok || ident.Name != "Code" {
+				continue
+			}
+
+			for _, v := range value.Values {
+				code, err := strconv.Unquote(v.(*ast.BasicLit).Value)
+				require.NoError(t, err)
+				codes = append(codes, code)
+			}
+		}
+	}
+	require.NotEmpty(t, codes)
+
+	paths, err := filepath.Glob("locales/*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		var messages map[string]string
+		require.NoError(t, json.Unmarshal(data, &messages), path)
+
+		for _, code := range codes {
+			assert.NotEmpty(t, messages[code], "%s is missing %s", path, code)
+		}
+	}
+}
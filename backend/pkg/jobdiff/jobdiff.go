@@ -0,0 +1,326 @@
+// Package jobdiff compares two versions of a Nomad job, in the shape of the
+// diffs Nomad returns for plans and adjacent versions so that the UI renders
+// both the same way.
+//
+// Nomad diffs a version against the previous one only, so comparing versions
+// further apart is done here. Fields Nomad sets on registration, such as
+// indexes and the version itself, are left out. Task groups and tasks are
+// matched by name, lists of values as sets and other lists by position.
+package jobdiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// Diff types, as Nomad names them
+const (
+	TypeAdded   = "Added"
+	TypeDeleted = "Deleted"
+	TypeEdited  = "Edited"
+	TypeNone    = "None"
+)
+
+// registrationFields are set by Nomad on every registration, so they differ
+// between any two versions
+var registrationFields = []string{
+	"Version", "VersionTag", "Stable", "Status", "StatusDescription",
+	"SubmitTime", "CreateIndex", "ModifyIndex", "JobModifyIndex",
+}
+
+// Jobs returns the changes from old to new. Either may be nil, for a job
+// added or deleted.
+func Jobs(old, new *api.Job) (*api.JobDiff, error) {
+	o, err := toObject(old)
+	if err != nil {
+		return nil, err
+	}
+	n, err := toObject(new)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range registrationFields {
+		delete(o, f)
+		delete(n, f)
+	}
+
+	diff := &api.JobDiff{ID: jobID(old, new)}
+
+	oldGroups, newGroups := named(o["TaskGroups"]), named(n["TaskGroups"])
+	delete(o, "TaskGroups")
+	delete(n, "TaskGroups")
+
+	diff.Fields, diff.Objects = diffFields(o, n)
+
+	for _, name := range names(oldGroups, newGroups) {
+		if g := diffGroup(name, oldGroups[name], newGroups[name]); g != nil {
+			diff.TaskGroups = append(diff.TaskGroups, g)
+		}
+	}
+
+	diff.Type = diffType(old == nil, new == nil,
+		len(diff.Fields)+len(diff.Objects)+len(diff.TaskGroups) > 0)
+
+	return diff, nil
+}
+
+func jobID(old, new *api.Job) string {
+	for _, j := range []*api.Job{new, old} {
+		if j != nil && j.ID != nil {
+			return *j.ID
+		}
+	}
+
+	return ""
+}
+
+func diffGroup(name string, old, new map[string]any) *api.TaskGroupDiff {
+	oldTasks, newTasks := named(old["Tasks"]), named(new["Tasks"])
+	delete(old, "Tasks")
+	delete(new, "Tasks")
+
+	g := &api.TaskGroupDiff{Name: name}
+	g.Fields, g.Objects = diffFields(old, new)
+
+	for _, task := range names(oldTasks, newTasks) {
+		t := &api.TaskDiff{Name: task}
+		t.Fields, t.Objects = diffFields(oldTasks[task], newTasks[task])
+		if len(t.Fields)+len(t.Objects) == 0 && oldTasks[task] != nil && newTasks[task] != nil {
+			continue
+		}
+		t.Type = diffType(oldTasks[task] == nil, newTasks[task] == nil, true)
+		g.Tasks = append(g.Tasks, t)
+	}
+
+	if len(g.Fields)+len(g.Objects)+len(g.Tasks) == 0 && old != nil && new != nil {
+		return nil
+	}
+	g.Type = diffType(old == nil, new == nil, true)
+
+	return g
+}
+
+// diffFields diffs the fields of two objects, either of which may be nil,
+// returning the changed values and the changed nested objects
+func diffFields(old, new map[string]any) ([]*api.FieldDiff, []*api.ObjectDiff) {
+	var fields []*api.FieldDiff
+	var objects []*api.ObjectDiff
+
+	keys := map[string]bool{}
+	for k := range old {
+		keys[k] = true
+	}
+	for k := range new {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	for _, k := range sorted {
+		ov, nv := old[k], new[k]
+		if empty(ov) && empty(nv) {
+			continue
+		}
+
+		om, oObject := ov.(map[string]any)
+		nm, nObject := nv.(map[string]any)
+		ol, oList := ov.([]any)
+		nl, nList := nv.([]any)
+
+		switch {
+		case oObject || nObject:
+			if d := diffObject(k, om, nm); d != nil {
+				objects = append(objects, d)
+			}
+		case (oList || nList) && (hasObjects(ol) || hasObjects(nl)):
+			for i := 0; i < max(len(ol), len(nl)); i++ {
+				var oi, ni map[string]any
+				if i < len(ol) {
+					oi, _ = ol[i].(map[string]any)
+				}
+				if i < len(nl) {
+					ni, _ = nl[i].(map[string]any)
+				}
+				if d := diffObject(k, oi, ni); d != nil {
+					objects = append(objects, d)
+				}
+			}
+		case oList || nList:
+			fields = append(fields, diffSet(k, ol, nl)...)
+		default:
+			if d := diffField(k, ov, nv); d != nil {
+				fields = append(fields, d)
+			}
+		}
+	}
+
+	return fields, objects
+}
+
+func diffObject(name string, old, new map[string]any) *api.ObjectDiff {
+	if len(old) == 0 && len(new) == 0 {
+		return nil
+	}
+
+	d := &api.ObjectDiff{Name: name}
+	d.Fields, d.Objects = diffFields(old, new)
+	if len(d.Fields)+len(d.Objects) == 0 {
+		return nil
+	}
+	d.Type = diffType(len(old) == 0, len(new) == 0, true)
+
+	return d
+}
+
+func diffField(name string, old, new any) *api.FieldDiff {
+	o, n := format(old), format(new)
+	if o == n {
+		return nil
+	}
+
+	return &api.FieldDiff{Type: diffType(empty(old), empty(new), true), Name: name, Old: o, New: n}
+}
+
+// diffSet diffs lists of values, such as datacenters, by the values added
+// and deleted
+func diffSet(name string, old, new []any) []*api.FieldDiff {
+	count := map[string]int{}
+	for _, v := range old {
+		count[format(v)]--
+	}
+	for _, v := range new {
+		count[format(v)]++
+	}
+
+	values := make([]string, 0, len(count))
+	for v := range count {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+
+	var fields []*api.FieldDiff
+	for _, v := range values {
+		switch {
+		case count[v] > 0:
+			fields = append(fields, &api.FieldDiff{Type: TypeAdded, Name: name, New: v})
+		case count[v] < 0:
+			fields = append(fields, &api.FieldDiff{Type: TypeDeleted, Name: name, Old: v})
+		}
+	}
+
+	return fields
+}
+
+func diffType(added, deleted, changed bool) string {
+	switch {
+	case added && deleted:
+		return TypeNone
+	case added:
+		return TypeAdded
+	case deleted:
+		return TypeDeleted
+	case changed:
+		return TypeEdited
+	default:
+		return TypeNone
+	}
+}
+
+// toObject returns v as decoded from JSON, with numbers kept as written
+func toObject(v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var m map[string]any
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// named indexes a list of objects, such as task groups, by their Name
+func named(v any) map[string]map[string]any {
+	byName := map[string]map[string]any{}
+
+	list, _ := v.([]any)
+	for _, item := range list {
+		if m, ok := item.(map[string]any); ok {
+			name, _ := m["Name"].(string)
+			byName[name] = m
+		}
+	}
+
+	return byName
+}
+
+func names(old, new map[string]map[string]any) []string {
+	var sorted []string
+	for name := range old {
+		sorted = append(sorted, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+func hasObjects(list []any) bool {
+	for _, v := range list {
+		if _, ok := v.(map[string]any); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// empty treats missing, null, empty strings and empty collections alike, as
+// Nomad fills some of them in on registration
+func empty(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []any:
+		return len(v) == 0
+	case map[string]any:
+		return len(v) == 0
+	}
+
+	return false
+}
+
+func format(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	}
+
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,75 @@
+package jobdiff_test
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/jobdiff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func job(version uint64, image string, count int, datacenters ...string) *api.Job {
+	j := api.NewServiceJob("web", "web", "global", 50)
+	j.Version = &version
+	j.JobModifyIndex = pointerOf(version * 10)
+	j.Datacenters = datacenters
+
+	tg := api.NewTaskGroup("web", count)
+	tg.AddTask(api.NewTask("server", "docker").SetConfig("image", image))
+	j.AddTaskGroup(tg)
+
+	return j
+}
+
+func TestJobs(t *testing.T) {
+	old := job(1, "nginx:1.25", 2, "dc1")
+	new := job(7, "nginx:1.27", 3, "dc1", "dc2")
+	new.Meta = map[string]string{"team": "web"}
+
+	admin := api.NewTaskGroup("admin", 1)
+	admin.AddTask(api.NewTask("ui", "docker"))
+	new.AddTaskGroup(admin)
+
+	diff, err := jobdiff.Jobs(old, new)
+	require.NoError(t, err)
+
+	assert.Equal(t, jobdiff.TypeEdited, diff.Type)
+	assert.Equal(t, "web", diff.ID)
+	assert.Equal(t, []*api.FieldDiff{{Type: jobdiff.TypeAdded, Name: "Datacenters", New: "dc2"}}, diff.Fields,
+		"registration fields such as the version are left out")
+	require.Len(t, diff.Objects, 1)
+	assert.Equal(t, &api.ObjectDiff{Type: jobdiff.TypeAdded, Name: "Meta", Fields: []*api.FieldDiff{
+		{Type: jobdiff.TypeAdded, Name: "team", New: "web"},
+	}}, diff.Objects[0])
+
+	require.Len(t, diff.TaskGroups, 2)
+
+	added := diff.TaskGroups[0]
+	assert.Equal(t, "admin", added.Name)
+	assert.Equal(t, jobdiff.TypeAdded, added.Type)
+	require.Len(t, added.Tasks, 1)
+	assert.Equal(t, jobdiff.TypeAdded, added.Tasks[0].Type)
+
+	edited := diff.TaskGroups[1]
+	assert.Equal(t, jobdiff.TypeEdited, edited.Type)
+	assert.Equal(t, []*api.FieldDiff{{Type: jobdiff.TypeEdited, Name: "Count", Old: "2", New: "3"}}, edited.Fields)
+	require.Len(t, edited.Tasks, 1)
+	require.Len(t, edited.Tasks[0].Objects, 1)
+	assert.Equal(t, &api.ObjectDiff{Type: jobdiff.TypeEdited, Name: "Config", Fields: []*api.FieldDiff{
+		{Type: jobdiff.TypeEdited, Name: "image", Old: "nginx:1.25", New: "nginx:1.27"},
+	}}, edited.Tasks[0].Objects[0])
+
+	same, err := jobdiff.Jobs(old, job(2, "nginx:1.25", 2, "dc1"))
+	require.NoError(t, err)
+	assert.Equal(t, &api.JobDiff{Type: jobdiff.TypeNone, ID: "web"}, same)
+
+	deleted, err := jobdiff.Jobs(old, nil)
+	require.NoError(t, err)
+	assert.Equal(t, jobdiff.TypeDeleted, deleted.Type)
+	assert.Equal(t, jobdiff.TypeDeleted, deleted.TaskGroups[0].Type)
+}
+
+func pointerOf[T any](v T) *T {
+	return &v
+}
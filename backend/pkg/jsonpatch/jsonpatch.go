@@ -0,0 +1,284 @@
+// Package jsonpatch computes and applies RFC 6902 JSON patches.
+//
+// The live-updating streams send a patch from the last object a client
+// received to the new one instead of the whole object, since most updates
+// of a large job or allocation change a handful of fields. Diff only emits
+// add, remove and replace operations; arrays are compared by position, so
+// an item inserted at the front of a list replaces every item after it.
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operations
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+	OpTest    = "test"
+)
+
+// Operation is one operation of a patch
+type Operation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// MarshalJSON keeps the value of add and replace operations even when it
+// is null, which omitempty would drop
+func (o Operation) MarshalJSON() ([]byte, error) {
+	if o.Op == OpRemove {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+
+	return json.Marshal(struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value any    `json:"value"`
+	}{o.Op, o.Path, o.Value})
+}
+
+// Diff returns the patch turning from into to. Both are compared as the
+// JSON they encode to.
+func Diff(from, to any) ([]Operation, error) {
+	f, err := toValue(from)
+	if err != nil {
+		return nil, err
+	}
+	t, err := toValue(to)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []Operation{}
+	diff("", f, t, &ops)
+
+	return ops, nil
+}
+
+// toValue returns v as the maps, slices and scalars encoding/json decodes
+// JSON into
+func toValue(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func diff(path string, from, to any, ops *[]Operation) {
+	switch f := from.(type) {
+	case map[string]any:
+		t, ok := to.(map[string]any)
+		if !ok {
+			break
+		}
+
+		for _, key := range sortedKeys(f) {
+			if _, ok := t[key]; !ok {
+				*ops = append(*ops, Operation{Op: OpRemove, Path: path + "/" + escape(key)})
+			}
+		}
+		for _, key := range sortedKeys(t) {
+			if fv, ok := f[key]; ok {
+				diff(path+"/"+escape(key), fv, t[key], ops)
+			} else {
+				*ops = append(*ops, Operation{Op: OpAdd, Path: path + "/" + escape(key), Value: t[key]})
+			}
+		}
+		return
+	case []any:
+		t, ok := to.([]any)
+		if !ok {
+			break
+		}
+
+		common := min(len(f), len(t))
+		for i := 0; i < common; i++ {
+			diff(path+"/"+strconv.Itoa(i), f[i], t[i], ops)
+		}
+		// Remove from the end, so the indexes of the items left stay valid
+		for i := len(f) - 1; i >= common; i-- {
+			*ops = append(*ops, Operation{Op: OpRemove, Path: path + "/" + strconv.Itoa(i)})
+		}
+		for i := common; i < len(t); i++ {
+			*ops = append(*ops, Operation{Op: OpAdd, Path: path + "/-", Value: t[i]})
+		}
+		return
+	default:
+		// from is a scalar, so comparing it with anything is safe
+		if from == to {
+			return
+		}
+	}
+
+	*ops = append(*ops, Operation{Op: OpReplace, Path: path, Value: to})
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// escape escapes a key as a JSON pointer token
+func escape(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// unescape reverses escape
+func unescape(token string) string {
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+}
+
+// Apply returns doc with the patch applied. Doc must be decoded JSON and is
+// modified in place where possible; the result replaces it.
+func Apply(doc any, ops []Operation) (any, error) {
+	for _, op := range ops {
+		var err error
+		if doc, err = apply(doc, op); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", op.Op, op.Path, err)
+		}
+	}
+
+	return doc, nil
+}
+
+func apply(doc any, op Operation) (any, error) {
+	if op.Path == "" {
+		switch op.Op {
+		case OpAdd, OpReplace:
+			return op.Value, nil
+		case OpTest:
+			return doc, test(doc, op.Value)
+		default:
+			return nil, fmt.Errorf("unsupported operation on the whole document")
+		}
+	}
+	if !strings.HasPrefix(op.Path, "/") {
+		return nil, fmt.Errorf("path must start with /")
+	}
+
+	tokens := strings.Split(op.Path[1:], "/")
+	parent, err := resolve(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := unescape(tokens[len(tokens)-1])
+
+	switch p := parent.(type) {
+	case map[string]any:
+		_, exists := p[last]
+		switch op.Op {
+		case OpAdd:
+			p[last] = op.Value
+		case OpReplace, OpRemove, OpTest:
+			if !exists {
+				return nil, fmt.Errorf("no member %q", last)
+			}
+			switch op.Op {
+			case OpReplace:
+				p[last] = op.Value
+			case OpRemove:
+				delete(p, last)
+			case OpTest:
+				return doc, test(p[last], op.Value)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported operation")
+		}
+	case []any:
+		list, err := applyToList(p, last, op)
+		if err != nil {
+			return nil, err
+		}
+		if len(tokens) == 1 {
+			return list, nil
+		}
+		// The list may have moved, so set it again in its parent
+		return apply(doc, Operation{Op: OpReplace, Path: "/" + strings.Join(tokens[:len(tokens)-1], "/"), Value: list})
+	default:
+		return nil, fmt.Errorf("parent is not an object or an array")
+	}
+
+	return doc, nil
+}
+
+func applyToList(list []any, token string, op Operation) ([]any, error) {
+	if op.Op == OpAdd && token == "-" {
+		return append(list, op.Value), nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > len(list) || (i == len(list) && op.Op != OpAdd) {
+		return nil, fmt.Errorf("invalid index %q", token)
+	}
+
+	switch op.Op {
+	case OpAdd:
+		list = append(list, nil)
+		copy(list[i+1:], list[i:])
+		list[i] = op.Value
+	case OpReplace:
+		list[i] = op.Value
+	case OpRemove:
+		list = append(list[:i], list[i+1:]...)
+	case OpTest:
+		return list, test(list[i], op.Value)
+	default:
+		return nil, fmt.Errorf("unsupported operation")
+	}
+
+	return list, nil
+}
+
+func resolve(doc any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		token = unescape(token)
+		switch d := doc.(type) {
+		case map[string]any:
+			v, ok := d[token]
+			if !ok {
+				return nil, fmt.Errorf("no member %q", token)
+			}
+			doc = v
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil, fmt.Errorf("invalid index %q", token)
+			}
+			doc = d[i]
+		default:
+			return nil, fmt.Errorf("%q is not in an object or an array", token)
+		}
+	}
+
+	return doc, nil
+}
+
+func test(actual, expected any) error {
+	if ops, err := Diff(actual, expected); err != nil || len(ops) > 0 {
+		return fmt.Errorf("value differs")
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package jsonpatch_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/jsonpatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, s string) any {
+	t.Helper()
+
+	var v any
+	require.NoError(t, json.Unmarshal([]byte(s), &v))
+	return v
+}
+
+func TestDiff(t *testing.T) {
+	from := decode(t, `{"ID":"web","Status":"pending","Meta":{"a/b":"1","old":"x"},"Tags":["a","b","c"],"Count":1}`)
+	to := decode(t, `{"ID":"web","Status":"running","Meta":{"a/b":"2"},"Tags":["a"],"Count":1,"Stop":null}`)
+
+	ops, err := jsonpatch.Diff(from, to)
+	require.NoError(t, err)
+
+	assert.Equal(t, []jsonpatch.Operation{
+		{Op: jsonpatch.OpRemove, Path: "/Meta/old"},
+		{Op: jsonpatch.OpReplace, Path: "/Meta/a~1b", Value: "2"},
+		{Op: jsonpatch.OpReplace, Path: "/Status", Value: "running"},
+		{Op: jsonpatch.OpAdd, Path: "/Stop", Value: nil},
+		{Op: jsonpatch.OpRemove, Path: "/Tags/2"},
+		{Op: jsonpatch.OpRemove, Path: "/Tags/1"},
+	}, ops)
+
+	data, err := json.Marshal(ops[3])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"op":"add","path":"/Stop","value":null}`, string(data), "null values are kept")
+
+	ops, err = jsonpatch.Diff(to, to)
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+}
+
+func TestApplyReversesDiff(t *testing.T) {
+	cases := []struct{ from, to string }{
+		{`{"a":1}`, `{"a":2,"b":[1,2]}`},
+		{`{"list":[{"n":1}]}`, `{"list":[{"n":2},{"n":3},{"n":4}]}`},
+		{`{"list":[1,2,3]}`, `{"list":[]}`},
+		{`{"nested":{"x":{"y":true}}}`, `{"nested":{"x":"flat"}}`},
+		{`[1,{"a":"~"}]`, `[1,{"a":"/"},3]`},
+		{`{"a":1}`, `[1]`},
+	}
+
+	for _, c := range cases {
+		ops, err := jsonpatch.Diff(decode(t, c.from), decode(t, c.to))
+		require.NoError(t, err)
+
+		patched, err := jsonpatch.Apply(decode(t, c.from), ops)
+		require.NoError(t, err, c.from)
+		assert.Equal(t, decode(t, c.to), patched, c.from)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	_, err := jsonpatch.Apply(decode(t, `{"a":1}`), []jsonpatch.Operation{{Op: jsonpatch.OpRemove, Path: "/b"}})
+	assert.Error(t, err)
+
+	_, err = jsonpatch.Apply(decode(t, `{"a":[1]}`), []jsonpatch.Operation{{Op: jsonpatch.OpReplace, Path: "/a/3", Value: 1}})
+	assert.Error(t, err)
+
+	_, err = jsonpatch.Apply(decode(t, `{"a":1}`), []jsonpatch.Operation{{Op: jsonpatch.OpTest, Path: "/a", Value: 2.0}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,133 @@
+// Package killswitch turns expensive subsystems off at runtime, so that
+// operators can shed load during an incident without redeploying.
+//
+// Each switch covers a feature, such as event streaming. Features start
+// enabled unless the configuration disables them, and managers can toggle
+// them through the admin API. The state is kept in memory: a restart goes
+// back to the configuration.
+package killswitch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Features that can be switched off.
+const (
+	// Events are the event multiplexer, the event streams and watches.
+	Events = "events"
+	// Logs is the streaming of task logs and files.
+	Logs = "logs"
+	// Aggregation covers the endpoints combining many Nomad requests, such
+	// as the service graph, job and node details and GraphQL.
+	Aggregation = "aggregation"
+)
+
+// descriptions of the features, which also lists the known ones
+var descriptions = map[string]string{
+	Events:      "Event multiplexer, event streams and watches",
+	Logs:        "Task log and file streaming",
+	Aggregation: "Endpoints combining many Nomad requests: service graph, job and node details, GraphQL",
+}
+
+// Features are the names of the features, in the order they are listed.
+var Features = []string{Events, Logs, Aggregation}
+
+// ErrUnknownFeature is returned for names that are not a feature.
+var ErrUnknownFeature = errors.New("unknown feature")
+
+// Switch is the state of a feature's kill switch.
+type Switch struct {
+	Feature     string `json:"feature"`
+	Description string `json:"description"`
+	Disabled    bool   `json:"disabled"`
+	// Reason, ChangedBy and ChangedAt describe the last runtime change.
+	Reason    string    `json:"reason,omitempty"`
+	ChangedBy string    `json:"changedBy,omitempty"`
+	ChangedAt time.Time `json:"changedAt,omitempty"`
+}
+
+// Set holds the kill switches. A nil Set keeps every feature enabled.
+type Set struct {
+	mutex    sync.RWMutex
+	switches map[string]*Switch
+}
+
+// New returns the kill switches with the given features disabled.
+func New(disabled []string) (*Set, error) {
+	s := &Set{switches: make(map[string]*Switch, len(Features))}
+	for _, feature := range Features {
+		s.switches[feature] = &Switch{Feature: feature, Description: descriptions[feature]}
+	}
+
+	for _, feature := range disabled {
+		sw, ok := s.switches[feature]
+		if !ok {
+			return nil, fmt.Errorf("%w %q, use one of %s", ErrUnknownFeature, feature, strings.Join(Features, ", "))
+		}
+		sw.Disabled = true
+		sw.Reason = "disabled by configuration"
+	}
+
+	return s, nil
+}
+
+// Parse returns the kill switches with the comma-separated features of
+// list disabled.
+func Parse(list string) (*Set, error) {
+	var disabled []string
+	for _, feature := range strings.Split(list, ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			disabled = append(disabled, feature)
+		}
+	}
+
+	return New(disabled)
+}
+
+// Disabled reports whether a feature is switched off.
+func (s *Set) Disabled(feature string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	sw, ok := s.switches[feature]
+	return ok && sw.Disabled
+}
+
+// Toggle switches a feature off or back on, returning its new state.
+func (s *Set) Toggle(feature string, disabled bool, reason, by string) (Switch, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sw, ok := s.switches[feature]
+	if !ok {
+		return Switch{}, fmt.Errorf("%w %q", ErrUnknownFeature, feature)
+	}
+
+	sw.Disabled = disabled
+	sw.Reason = reason
+	sw.ChangedBy = by
+	sw.ChangedAt = time.Now().UTC()
+
+	return *sw, nil
+}
+
+// List returns the state of every switch.
+func (s *Set) List() []Switch {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	list := make([]Switch, 0, len(Features))
+	for _, feature := range Features {
+		list = append(list, *s.switches[feature])
+	}
+
+	return list
+}
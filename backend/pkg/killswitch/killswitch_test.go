@@ -0,0 +1,42 @@
+package killswitch_test
+
+import (
+	"testing"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/killswitch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSet(t *testing.T) {
+	set, err := killswitch.Parse(" logs, ")
+	require.NoError(t, err)
+
+	assert.True(t, set.Disabled(killswitch.Logs))
+	assert.False(t, set.Disabled(killswitch.Events))
+	assert.False(t, set.Disabled("unknown"))
+
+	sw, err := set.Toggle(killswitch.Events, true, "event storm", "alice")
+	require.NoError(t, err)
+	assert.True(t, sw.Disabled)
+	assert.Equal(t, "alice", sw.ChangedBy)
+	assert.True(t, set.Disabled(killswitch.Events))
+
+	_, err = set.Toggle(killswitch.Logs, false, "", "alice")
+	require.NoError(t, err)
+	assert.False(t, set.Disabled(killswitch.Logs))
+
+	list := set.List()
+	require.Len(t, list, len(killswitch.Features))
+	assert.Equal(t, killswitch.Events, list[0].Feature)
+	assert.NotEmpty(t, list[0].Description)
+
+	_, err = set.Toggle("unknown", true, "", "")
+	assert.ErrorIs(t, err, killswitch.ErrUnknownFeature)
+
+	_, err = killswitch.Parse("events,typo")
+	assert.ErrorIs(t, err, killswitch.ErrUnknownFeature)
+
+	var none *killswitch.Set
+	assert.False(t, none.Disabled(killswitch.Events))
+}
@@ -0,0 +1,316 @@
+// Package lint checks jobs against best practices before they are deployed,
+// such as services without health checks or images pinned to "latest".
+//
+// The built-in rules can be disabled or given another severity in a rules
+// file, which can also add custom rules that require or restrict fields of
+// the job, its groups or its tasks.
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// Severities of findings
+const (
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// Scopes of custom rules
+const (
+	ScopeJob   = "job"
+	ScopeGroup = "group"
+	ScopeTask  = "task"
+)
+
+// Finding is a rule a job breaks. Group and Task locate the offending part
+// of the job, they are empty for findings about the whole job.
+type Finding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Group    string `json:"group,omitempty"`
+	Task     string `json:"task,omitempty"`
+}
+
+// Rule describes a rule, as listed for the UI.
+type Rule struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Severity    string `json:"severity"`
+	Custom      bool   `json:"custom,omitempty"`
+
+	check func(job *api.Job) []Finding
+}
+
+// RuleConfig overrides a built-in rule.
+type RuleConfig struct {
+	Disabled bool   `json:"disabled,omitempty"`
+	Severity string `json:"severity,omitempty"`
+}
+
+// CustomRule checks a field of each job, group or task, given by Scope.
+// Field is a dot separated path in the JSON form of the object, such as
+// "Meta.owner" or "Config.image".
+type CustomRule struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Severity    string `json:"severity,omitempty"`
+	Scope       string `json:"scope"`
+	Field       string `json:"field"`
+	// Required reports objects where the field is missing or empty.
+	Required bool `json:"required,omitempty"`
+	// Pattern is a regular expression values of the field must match.
+	Pattern string `json:"pattern,omitempty"`
+	// Message is reported instead of a generated one.
+	Message string `json:"message,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Config is the content of a lint rules file.
+type Config struct {
+	// Rules overrides built-in rules by name.
+	Rules  map[string]RuleConfig `json:"rules,omitempty"`
+	Custom []CustomRule          `json:"custom,omitempty"`
+}
+
+// Linter checks jobs against a set of rules. A nil Linter applies the
+// built-in rules as they are.
+type Linter struct {
+	rules []Rule
+}
+
+// Load reads a linter's rules from a JSON file.
+func Load(path string) (*Linter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading lint rules: %w", err)
+	}
+
+	return Parse(data)
+}
+
+// Parse parses and validates JSON lint rules.
+func Parse(data []byte) (*Linter, error) {
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing lint rules: %w", err)
+	}
+
+	return New(cfg)
+}
+
+// New validates cfg and creates a linter from it.
+func New(cfg Config) (*Linter, error) {
+	l := &Linter{}
+	names := make(map[string]bool)
+
+	for _, rule := range builtinRules() {
+		names[rule.Name] = true
+
+		override := cfg.Rules[rule.Name]
+		if override.Disabled {
+			continue
+		}
+
+		if override.Severity != "" {
+			if !validSeverity(override.Severity) {
+				return nil, fmt.Errorf("rule %s: invalid severity %q", rule.Name, override.Severity)
+			}
+			rule.Severity = override.Severity
+		}
+
+		l.rules = append(l.rules, rule)
+	}
+
+	for name := range cfg.Rules {
+		if !names[name] {
+			return nil, fmt.Errorf("unknown rule %q", name)
+		}
+	}
+
+	for i := range cfg.Custom {
+		custom := cfg.Custom[i]
+
+		if custom.Name == "" {
+			return nil, fmt.Errorf("custom rule %d has no name", i)
+		}
+		if names[custom.Name] {
+			return nil, fmt.Errorf("duplicate rule %q", custom.Name)
+		}
+		names[custom.Name] = true
+
+		if err := custom.init(); err != nil {
+			return nil, fmt.Errorf("custom rule %s: %w", custom.Name, err)
+		}
+
+		l.rules = append(l.rules, Rule{
+			Name:        custom.Name,
+			Description: custom.Description,
+			Severity:    custom.Severity,
+			Custom:      true,
+			check:       custom.check,
+		})
+	}
+
+	return l, nil
+}
+
+// Rules returns the rules the linter applies.
+func (l *Linter) Rules() []Rule {
+	if l == nil {
+		return builtinRules()
+	}
+
+	return l.rules
+}
+
+// Lint returns the findings of all rules for job, never nil.
+func (l *Linter) Lint(job *api.Job) []Finding {
+	findings := []Finding{}
+
+	for _, rule := range l.Rules() {
+		for _, f := range rule.check(job) {
+			f.Rule = rule.Name
+			f.Severity = rule.Severity
+			findings = append(findings, f)
+		}
+	}
+
+	return findings
+}
+
+func validSeverity(s string) bool {
+	return s == SeverityWarning || s == SeverityInfo
+}
+
+func (c *CustomRule) init() error {
+	if c.Severity == "" {
+		c.Severity = SeverityWarning
+	}
+	if !validSeverity(c.Severity) {
+		return fmt.Errorf("invalid severity %q", c.Severity)
+	}
+
+	switch c.Scope {
+	case ScopeJob, ScopeGroup, ScopeTask:
+	default:
+		return fmt.Errorf("invalid scope %q", c.Scope)
+	}
+
+	if c.Field == "" {
+		return fmt.Errorf("no field")
+	}
+
+	if !c.Required && c.Pattern == "" {
+		return fmt.Errorf("the rule neither requires the field nor gives a pattern")
+	}
+
+	if c.Pattern != "" {
+		re, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		c.pattern = re
+	}
+
+	return nil
+}
+
+func (c *CustomRule) check(job *api.Job) []Finding {
+	var findings []Finding
+
+	report := func(object interface{}, group, task string) {
+		value, ok := lookup(object, c.Field)
+
+		var problem string
+		switch {
+		case !ok && c.Required:
+			problem = fmt.Sprintf("%s is not set", c.Field)
+		case ok && c.pattern != nil && !c.pattern.MatchString(value):
+			problem = fmt.Sprintf("%s %q does not match %s", c.Field, value, c.Pattern)
+		default:
+			return
+		}
+
+		if c.Message != "" {
+			problem = c.Message
+		}
+
+		findings = append(findings, Finding{Message: problem, Group: group, Task: task})
+	}
+
+	switch c.Scope {
+	case ScopeJob:
+		report(job, "", "")
+	case ScopeGroup:
+		for _, tg := range job.TaskGroups {
+			report(tg, str(tg.Name), "")
+		}
+	case ScopeTask:
+		for _, tg := range job.TaskGroups {
+			for _, task := range tg.Tasks {
+				report(task, str(tg.Name), task.Name)
+			}
+		}
+	}
+
+	return findings
+}
+
+// lookup returns the value at a dot separated path in the JSON form of
+// object. Values that are not strings are returned as JSON. Missing, null
+// and empty values are reported as not found.
+func lookup(object interface{}, field string) (string, bool) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return "", false
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", false
+	}
+
+	for _, part := range strings.Split(field, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		value = m[part]
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, v != ""
+	case []interface{}:
+		if len(v) == 0 {
+			return "", false
+		}
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return "", false
+		}
+	}
+
+	encoded, _ := json.Marshal(value)
+
+	return string(encoded), true
+}
+
+func str(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
@@ -0,0 +1,102 @@
+package lint_test
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/lint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testJob(image string) *api.Job {
+	task := api.NewTask("server", "docker")
+	task.Config = map[string]interface{}{"image": image}
+	task.Services = []*api.Service{{Name: "web"}}
+
+	job := api.NewServiceJob("web", "web", "global", 50)
+	job.AddTaskGroup(api.NewTaskGroup("web", 1).AddTask(task))
+
+	return job
+}
+
+func rules(findings []lint.Finding) []string {
+	var names []string
+	for _, f := range findings {
+		names = append(names, f.Rule)
+	}
+
+	return names
+}
+
+func TestBuiltinRules(t *testing.T) {
+	var l *lint.Linter
+
+	findings := l.Lint(testJob("nginx"))
+	assert.ElementsMatch(t, []string{"health-checks", "restart-policy", "image-tag", "resources", "placement"}, rules(findings))
+	assert.Equal(t, "web", findings[0].Group)
+	assert.Equal(t, "server", findings[0].Task)
+
+	job := testJob("registry.local:5000/nginx:1.27")
+	tg := job.TaskGroups[0]
+	tg.RestartPolicy = &api.RestartPolicy{}
+	tg.Spreads = []*api.Spread{{Attribute: "${node.datacenter}"}}
+	tg.Tasks[0].Services[0].Checks = []api.ServiceCheck{{Type: "http", Path: "/health"}}
+	tg.Tasks[0].Resources = &api.Resources{CPU: pointerOf(200), MemoryMB: pointerOf(256)}
+
+	assert.Empty(t, l.Lint(job))
+
+	assert.Contains(t, rules(l.Lint(testJob("nginx:latest"))), "image-tag")
+	assert.NotContains(t, rules(l.Lint(testJob("nginx@sha256:abcd"))), "image-tag")
+}
+
+func TestRulesFile(t *testing.T) {
+	l, err := lint.Parse([]byte(`{
+		"rules": {"placement": {"disabled": true}, "resources": {"severity": "info"}},
+		"custom": [
+			{"name": "owner", "scope": "job", "field": "Meta.owner", "required": true},
+			{"name": "registry", "scope": "task", "field": "Config.image", "pattern": "^registry\\.local/",
+			 "message": "Images must come from the internal registry"}
+		]
+	}`))
+	require.NoError(t, err)
+
+	findings := l.Lint(testJob("nginx:1.27"))
+	assert.ElementsMatch(t, []string{"health-checks", "restart-policy", "resources", "owner", "registry"}, rules(findings))
+
+	for _, f := range findings {
+		switch f.Rule {
+		case "resources":
+			assert.Equal(t, lint.SeverityInfo, f.Severity)
+		case "owner":
+			assert.Equal(t, lint.SeverityWarning, f.Severity)
+			assert.Equal(t, "Meta.owner is not set", f.Message)
+		case "registry":
+			assert.Equal(t, "Images must come from the internal registry", f.Message)
+			assert.Equal(t, "server", f.Task)
+		}
+	}
+
+	job := testJob("registry.local/nginx:1.27")
+	job.Meta = map[string]string{"owner": "platform"}
+	assert.NotContains(t, rules(l.Lint(job)), "owner")
+	assert.NotContains(t, rules(l.Lint(job)), "registry")
+}
+
+func TestInvalidRulesFile(t *testing.T) {
+	for _, cfg := range []string{
+		`{"rules": {"nope": {"disabled": true}}}`,
+		`{"rules": {"resources": {"severity": "fatal"}}}`,
+		`{"custom": [{"name": "resources", "scope": "job", "field": "Meta.x", "required": true}]}`,
+		`{"custom": [{"name": "x", "scope": "alloc", "field": "Meta.x", "required": true}]}`,
+		`{"custom": [{"name": "x", "scope": "job", "field": "Meta.x"}]}`,
+		`{"custom": [{"name": "x", "scope": "job", "field": "Meta.x", "pattern": "("}]}`,
+	} {
+		_, err := lint.Parse([]byte(cfg))
+		assert.Error(t, err, cfg)
+	}
+}
+
+func pointerOf[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,213 @@
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// imageDrivers are the task drivers whose config names an OCI image
+var imageDrivers = map[string]bool{
+	"docker": true,
+	"podman": true,
+}
+
+func builtinRules() []Rule {
+	return []Rule{
+		{
+			Name:        "health-checks",
+			Description: "Services of long-running jobs have health checks",
+			Severity:    SeverityWarning,
+			check:       checkHealthChecks,
+		},
+		{
+			Name:        "restart-policy",
+			Description: "Groups or tasks set a restart policy",
+			Severity:    SeverityInfo,
+			check:       checkRestartPolicy,
+		},
+		{
+			Name:        "image-tag",
+			Description: "Images are pinned to a tag or digest other than latest",
+			Severity:    SeverityWarning,
+			check:       checkImageTag,
+		},
+		{
+			Name:        "resources",
+			Description: "Tasks request CPU and memory",
+			Severity:    SeverityWarning,
+			check:       checkResources,
+		},
+		{
+			Name:        "placement",
+			Description: "Groups of service and batch jobs have constraints, affinities or spreads",
+			Severity:    SeverityInfo,
+			check:       checkPlacement,
+		},
+	}
+}
+
+func jobType(job *api.Job) string {
+	if job.Type == nil || *job.Type == "" {
+		return api.JobTypeService
+	}
+
+	return *job.Type
+}
+
+func checkHealthChecks(job *api.Job) []Finding {
+	if t := jobType(job); t != api.JobTypeService && t != api.JobTypeSystem {
+		return nil
+	}
+
+	var findings []Finding
+
+	for _, tg := range job.TaskGroups {
+		for _, svc := range tg.Services {
+			if len(svc.Checks) == 0 {
+				findings = append(findings, Finding{
+					Message: fmt.Sprintf("Service %q has no health checks, so failing instances keep receiving traffic", svc.Name),
+					Group:   str(tg.Name),
+				})
+			}
+		}
+
+		for _, task := range tg.Tasks {
+			for _, svc := range task.Services {
+				if len(svc.Checks) == 0 {
+					findings = append(findings, Finding{
+						Message: fmt.Sprintf("Service %q has no health checks, so failing instances keep receiving traffic", svc.Name),
+						Group:   str(tg.Name),
+						Task:    task.Name,
+					})
+				}
+			}
+		}
+	}
+
+	return findings
+}
+
+func checkRestartPolicy(job *api.Job) []Finding {
+	var findings []Finding
+
+	for _, tg := range job.TaskGroups {
+		if tg.RestartPolicy != nil {
+			continue
+		}
+
+		for _, task := range tg.Tasks {
+			if task.RestartPolicy == nil {
+				findings = append(findings, Finding{
+					Message: "No restart policy is set, Nomad's defaults decide how failures are retried",
+					Group:   str(tg.Name),
+					Task:    task.Name,
+				})
+			}
+		}
+	}
+
+	return findings
+}
+
+func checkImageTag(job *api.Job) []Finding {
+	var findings []Finding
+
+	for _, tg := range job.TaskGroups {
+		for _, task := range tg.Tasks {
+			if !imageDrivers[task.Driver] {
+				continue
+			}
+
+			image, _ := task.Config["image"].(string)
+			if image == "" || strings.Contains(image, "@") {
+				continue
+			}
+
+			tag := ""
+			if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+				tag = image[i+1:]
+			}
+
+			switch tag {
+			case "":
+				findings = append(findings, Finding{
+					Message: fmt.Sprintf("Image %q has no tag and resolves to latest", image),
+					Group:   str(tg.Name),
+					Task:    task.Name,
+				})
+			case "latest":
+				findings = append(findings, Finding{
+					Message: fmt.Sprintf("Image %q uses the latest tag, so deployments are not reproducible", image),
+					Group:   str(tg.Name),
+					Task:    task.Name,
+				})
+			}
+		}
+	}
+
+	return findings
+}
+
+func checkResources(job *api.Job) []Finding {
+	var findings []Finding
+
+	for _, tg := range job.TaskGroups {
+		for _, task := range tg.Tasks {
+			var missing []string
+
+			r := task.Resources
+			if r == nil || (r.CPU == nil && r.Cores == nil) {
+				missing = append(missing, "CPU")
+			}
+			if r == nil || r.MemoryMB == nil {
+				missing = append(missing, "memory")
+			}
+
+			if len(missing) > 0 {
+				findings = append(findings, Finding{
+					Message: fmt.Sprintf("No %s is requested, Nomad's small defaults apply", strings.Join(missing, " or ")),
+					Group:   str(tg.Name),
+					Task:    task.Name,
+				})
+			}
+		}
+	}
+
+	return findings
+}
+
+func checkPlacement(job *api.Job) []Finding {
+	if t := jobType(job); t != api.JobTypeService && t != api.JobTypeBatch {
+		return nil
+	}
+
+	if len(job.Constraints) > 0 || len(job.Affinities) > 0 || len(job.Spreads) > 0 {
+		return nil
+	}
+
+	var findings []Finding
+
+	for _, tg := range job.TaskGroups {
+		if len(tg.Constraints) > 0 || len(tg.Affinities) > 0 || len(tg.Spreads) > 0 {
+			continue
+		}
+
+		placed := false
+		for _, task := range tg.Tasks {
+			if len(task.Constraints) > 0 || len(task.Affinities) > 0 {
+				placed = true
+			}
+		}
+
+		if !placed {
+			findings = append(findings, Finding{
+				Message: "No constraints, affinities or spreads are set, allocations may land on any node",
+				Group:   str(tg.Name),
+			})
+		}
+	}
+
+	return findings
+}
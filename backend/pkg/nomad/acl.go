@@ -2,6 +2,9 @@ package nomad
 
 import (
 	"net/http"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
 )
 
 // ListACLTokens handles GET /clusters/{cluster}/v1/acl/tokens
@@ -11,14 +14,14 @@ func (h *Handler) ListACLTokens(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	tokens, _, err := client.ACLTokens().List(opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -33,14 +36,14 @@ func (h *Handler) GetACLToken(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	aclToken, _, err := client.ACLTokens().Info(tokenID, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -54,14 +57,14 @@ func (h *Handler) GetSelfToken(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	aclToken, _, err := client.ACLTokens().Self(opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -75,20 +78,35 @@ func (h *Handler) ListACLPolicies(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	policies, _, err := client.ACLPolicies().List(opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	writeJSON(w, policies)
 }
 
+// DeleteACLToken handles DELETE /clusters/{cluster}/v1/acl/token/{tokenID}
+func (h *Handler) DeleteACLToken(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	tokenID := r.PathValue("tokenID")
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	h.runDestructive(w, r, client, ActionACLTokenDelete, "", tokenID)
+}
+
 // GetACLPolicy handles GET /clusters/{cluster}/v1/acl/policy/{policyName}
 func (h *Handler) GetACLPolicy(w http.ResponseWriter, r *http.Request) {
 	clusterName := getClusterName(r)
@@ -97,16 +115,142 @@ func (h *Handler) GetACLPolicy(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	policy, _, err := client.ACLPolicies().Info(policyName, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	writeJSON(w, policy)
 }
+
+// DeleteACLPolicy handles DELETE /clusters/{cluster}/v1/acl/policy/{policyName}
+func (h *Handler) DeleteACLPolicy(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	policyName := r.PathValue("policyName")
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	h.runDestructive(w, r, client, ActionACLPolicyDelete, "", policyName)
+}
+
+// requireManagement answers 403 unless the caller holds a management token
+// on cluster. Everyone manages the clusters without ACLs; the returned token
+// is nil for those.
+//
+// When cluster is empty, Caravan's shared settings are managed: the caller
+// must be in a session of the Caravan admin, or hold a management token on
+// every cluster with ACLs. Clusters without ACLs, which anyone can add, only
+// give rights over themselves, so they do not count.
+func (h *Handler) requireManagement(w http.ResponseWriter, r *http.Request, cluster string) (*api.ACLToken, bool) {
+	if cluster != "" {
+		if self, ok := h.manages(r, cluster); ok {
+			return self, true
+		}
+
+		writeError(w, r, apierror.New(http.StatusForbidden, apierror.CodePermissionDenied,
+			"a management token is required"), http.StatusForbidden)
+
+		return nil, false
+	}
+
+	if admin := h.caravanAdmin(r); admin != nil {
+		return admin, true
+	}
+
+	if self := h.managesAll(r); self != nil {
+		return self, true
+	}
+
+	writeError(w, r, apierror.New(http.StatusForbidden, apierror.CodePermissionDenied,
+		"the Caravan admin or a management token on every cluster is required"), http.StatusForbidden)
+
+	return nil, false
+}
+
+// managesAll returns the caller's management token on the first cluster
+// with ACLs if they hold one on each of those clusters, nil otherwise or
+// when no cluster has ACLs
+func (h *Handler) managesAll(r *http.Request) *api.ACLToken {
+	var first *api.ACLToken
+
+	for _, c := range h.configStore.GetContexts() {
+		if c.ACLDisabled {
+			continue
+		}
+
+		self, ok := h.manages(r, c.Name)
+		if !ok {
+			return nil
+		}
+		if first == nil {
+			first = self
+		}
+	}
+
+	return first
+}
+
+// manages reports whether the caller holds a management token on cluster,
+// with that token, nil for clusters without ACLs
+func (h *Handler) manages(r *http.Request, cluster string) (*api.ACLToken, bool) {
+	nomadCtx, err := h.configStore.GetContext(cluster)
+	if err != nil {
+		return nil, false
+	}
+	if nomadCtx.ACLDisabled {
+		return nil, true
+	}
+
+	token := getTokenForCluster(r, cluster)
+	if token == "" {
+		return nil, false
+	}
+
+	client, err := h.GetClientWithToken(cluster, token)
+	if err != nil {
+		return nil, false
+	}
+
+	if self, _, err := client.ACLTokens().Self(nil); err == nil && self.Type == "management" {
+		return self, true
+	}
+
+	return nil, false
+}
+
+// reaches reports whether the caller holds a token Nomad accepts on cluster.
+// Everyone reaches the clusters without ACLs.
+func (h *Handler) reaches(r *http.Request, cluster string) bool {
+	nomadCtx, err := h.configStore.GetContext(cluster)
+	if err != nil {
+		return false
+	}
+	if nomadCtx.ACLDisabled {
+		return true
+	}
+
+	token := getTokenForCluster(r, cluster)
+	if token == "" {
+		return false
+	}
+
+	client, err := h.GetClientWithToken(cluster, token)
+	if err != nil {
+		return false
+	}
+
+	_, _, err = client.ACLTokens().Self(nil)
+
+	return err == nil
+}
@@ -0,0 +1,140 @@
+package nomad
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+)
+
+// aclBootstrapConfirmTTL is how long a confirmation token of an ACL
+// bootstrap stays valid
+const aclBootstrapConfirmTTL = 5 * time.Minute
+
+// ActionACLBootstrap is the audit action of an ACL bootstrap
+const ActionACLBootstrap = "acl.bootstrap"
+
+// ACLBootstrapRequest is the body of POST /clusters/{cluster}/v1/acl/bootstrap.
+// Without a confirmation token, the cluster is left untouched and a token is
+// returned to confirm the bootstrap with.
+type ACLBootstrapRequest struct {
+	ConfirmationToken string `json:"confirmationToken"`
+	// BootstrapSecret is the secret ID the bootstrap token gets instead of
+	// one Nomad generates
+	BootstrapSecret string `json:"bootstrapSecret,omitempty"`
+}
+
+// ACLBootstrapConfirmation is what a bootstrap has to be confirmed with
+type ACLBootstrapConfirmation struct {
+	Cluster           string    `json:"cluster"`
+	ConfirmationToken string    `json:"confirmationToken"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+}
+
+// ACLBootstrapResponse holds the management token created by an ACL
+// bootstrap. Caravan does not keep it, so this is the only time its secret
+// is shown.
+type ACLBootstrapResponse struct {
+	AccessorID string    `json:"accessorId"`
+	SecretID   string    `json:"secretId"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	CreateTime time.Time `json:"createTime"`
+}
+
+// aclBootstrapConfirmation is a pending ACL bootstrap
+type aclBootstrapConfirmation struct {
+	cluster string
+	expires time.Time
+}
+
+// BootstrapACL handles POST /clusters/{cluster}/v1/acl/bootstrap
+func (h *Handler) BootstrapACL(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+
+	var req ACLBootstrapRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	if req.ConfirmationToken == "" {
+		confirmation := ACLBootstrapConfirmation{
+			Cluster:           clusterName,
+			ConfirmationToken: newSessionID(),
+			ExpiresAt:         time.Now().Add(aclBootstrapConfirmTTL).UTC(),
+		}
+
+		h.bootstrapMutex.Lock()
+		if h.bootstrapConfirmations == nil {
+			h.bootstrapConfirmations = make(map[string]aclBootstrapConfirmation)
+		}
+		h.bootstrapConfirmations[confirmation.ConfirmationToken] = aclBootstrapConfirmation{
+			cluster: clusterName,
+			expires: confirmation.ExpiresAt,
+		}
+		h.bootstrapMutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(confirmation)
+		return
+	}
+
+	// Confirmation tokens are single use, even when the bootstrap fails
+	h.bootstrapMutex.Lock()
+	pending, ok := h.bootstrapConfirmations[req.ConfirmationToken]
+	delete(h.bootstrapConfirmations, req.ConfirmationToken)
+	for id, c := range h.bootstrapConfirmations {
+		if time.Now().After(c.expires) {
+			delete(h.bootstrapConfirmations, id)
+		}
+	}
+	h.bootstrapMutex.Unlock()
+
+	if !ok || pending.cluster != clusterName || time.Now().After(pending.expires) {
+		writeError(w, r, errors.New("the confirmation token is invalid or has expired"), http.StatusBadRequest)
+		return
+	}
+
+	entry := AuditEntry{Cluster: clusterName, Action: ActionACLBootstrap, Target: clusterName}
+
+	bootstrap, _, err := client.ACLTokens().BootstrapOpts(req.BootstrapSecret, nil)
+	if err != nil {
+		entry.Error = err.Error()
+		h.audit(r.Context(), entry)
+
+		if strings.Contains(err.Error(), "ACL bootstrap already done") {
+			writeError(w, r, apierror.New(http.StatusConflict, apierror.CodeConflict,
+				"the cluster's ACL system is already bootstrapped"), http.StatusConflict)
+			return
+		}
+		writeNomadError(w, r, err)
+		return
+	}
+
+	entry.Actor = bootstrap.Name
+	entry.ActorAccessor = bootstrap.AccessorID
+	h.audit(r.Context(), entry)
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, ACLBootstrapResponse{
+		AccessorID: bootstrap.AccessorID,
+		SecretID:   bootstrap.SecretID,
+		Name:       bootstrap.Name,
+		Type:       bootstrap.Type,
+		CreateTime: bootstrap.CreateTime,
+	})
+}
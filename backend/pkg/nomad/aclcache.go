@@ -0,0 +1,212 @@
+package nomad
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/cache"
+)
+
+// aclCacheTTL is how long a token or policy is served from the ACL cache.
+// It is short so that changes made outside of Caravan apply quickly.
+const aclCacheTTL = 30 * time.Second
+
+// aclCache keeps the tokens looked up by their secret and the policies
+// they read, so that checking who a request comes from, which happens on
+// most page loads, does not cost a round trip to Nomad each time.
+//
+// Tokens are keyed by their cluster and a hash of their secret, and policies
+// by the accessor of the token that read them, since what a token may read
+// depends on its ACLs.
+type aclCache struct {
+	tokens   cache.Cache[cachedACL[*api.ACLToken]]
+	policies cache.Cache[cachedACL[*api.ACLPolicy]]
+	lookups  cacheCounters
+}
+
+// Resources of the ACL cache, as reported by the admin API
+const (
+	cachedTokens   = "tokens"
+	cachedPolicies = "policies"
+)
+
+type cachedACL[T any] struct {
+	value T
+	meta  *api.QueryMeta
+}
+
+func newACLCache() *aclCache {
+	return &aclCache{
+		tokens:   cache.New[cachedACL[*api.ACLToken]](),
+		policies: cache.New[cachedACL[*api.ACLPolicy]](),
+	}
+}
+
+// aclCachingClient serves token and policy lookups from the ACL cache
+type aclCachingClient struct {
+	NomadAPI
+	acl     *aclCache
+	cluster string
+	token   string
+}
+
+// withACLCache wraps a client in the handler's ACL cache
+func (h *Handler) withACLCache(client NomadAPI, cluster, token string) NomadAPI {
+	return &aclCachingClient{NomadAPI: client, acl: h.aclCache, cluster: cluster, token: token}
+}
+
+func (c *aclCachingClient) ACLTokens() ACLTokensAPI {
+	return &cachingACLTokens{ACLTokensAPI: c.NomadAPI.ACLTokens(), c: c}
+}
+
+func (c *aclCachingClient) ACLPolicies() ACLPoliciesAPI {
+	return &cachingACLPolicies{ACLPoliciesAPI: c.NomadAPI.ACLPolicies(), c: c}
+}
+
+// aclCacheKey keys a lookup made with q, "" if it must go to Nomad: blocking
+// queries wait for changes
+func aclCacheKey(q *api.QueryOptions, parts ...string) string {
+	if q == nil {
+		return strings.Join(append(parts, ""), "\x00")
+	}
+
+	if q.WaitIndex > 0 {
+		return ""
+	}
+
+	return strings.Join(append(parts, q.Region), "\x00")
+}
+
+type cachingACLTokens struct {
+	ACLTokensAPI
+	c *aclCachingClient
+}
+
+func (t *cachingACLTokens) Self(q *api.QueryOptions) (*api.ACLToken, *api.QueryMeta, error) {
+	sum := sha256.Sum256([]byte(t.c.token))
+	key := aclCacheKey(q, t.c.cluster, hex.EncodeToString(sum[:]))
+	if key == "" {
+		return t.ACLTokensAPI.Self(q)
+	}
+
+	ctx := context.Background()
+
+	if entry, err := t.c.acl.tokens.Get(ctx, key); err == nil {
+		t.c.acl.lookups.record(t.c.cluster, cachedTokens, true)
+		token := *entry.value
+		return &token, entry.meta, nil
+	}
+	t.c.acl.lookups.record(t.c.cluster, cachedTokens, false)
+
+	token, meta, err := t.ACLTokensAPI.Self(q)
+	if err != nil || token == nil {
+		return token, meta, err
+	}
+
+	cached := *token
+	_ = t.c.acl.tokens.SetWithTTL(ctx, key, cachedACL[*api.ACLToken]{value: &cached, meta: meta}, aclCacheTTL)
+
+	return token, meta, nil
+}
+
+// Delete drops the deleted token from the cache, so its secret stops
+// working in Caravan right away
+func (t *cachingACLTokens) Delete(accessorID string, q *api.WriteOptions) (*api.WriteMeta, error) {
+	meta, err := t.ACLTokensAPI.Delete(accessorID, q)
+	if err != nil {
+		return meta, err
+	}
+
+	ctx := context.Background()
+	entries, _ := t.c.acl.tokens.GetAll(ctx, func(key string) bool {
+		return strings.HasPrefix(key, t.c.cluster+"\x00")
+	})
+	for key, entry := range entries {
+		if entry.value.AccessorID == accessorID {
+			_ = t.c.acl.tokens.Delete(ctx, key)
+		}
+	}
+
+	t.c.acl.dropPolicies(func(key string) bool {
+		return strings.HasPrefix(key, t.c.cluster+"\x00"+accessorID+"\x00")
+	})
+
+	return meta, nil
+}
+
+type cachingACLPolicies struct {
+	ACLPoliciesAPI
+	c *aclCachingClient
+}
+
+func (p *cachingACLPolicies) Info(policyName string, q *api.QueryOptions) (*api.ACLPolicy, *api.QueryMeta, error) {
+	self, _, err := p.c.ACLTokens().Self(nil)
+	if err != nil || self == nil {
+		return p.ACLPoliciesAPI.Info(policyName, q)
+	}
+
+	key := aclCacheKey(q, p.c.cluster, self.AccessorID, policyName)
+	if key == "" {
+		return p.ACLPoliciesAPI.Info(policyName, q)
+	}
+
+	ctx := context.Background()
+
+	if entry, err := p.c.acl.policies.Get(ctx, key); err == nil {
+		p.c.acl.lookups.record(p.c.cluster, cachedPolicies, true)
+		policy := *entry.value
+		return &policy, entry.meta, nil
+	}
+	p.c.acl.lookups.record(p.c.cluster, cachedPolicies, false)
+
+	policy, meta, err := p.ACLPoliciesAPI.Info(policyName, q)
+	if err != nil || policy == nil {
+		return policy, meta, err
+	}
+
+	cached := *policy
+	_ = p.c.acl.policies.SetWithTTL(ctx, key, cachedACL[*api.ACLPolicy]{value: &cached, meta: meta}, aclCacheTTL)
+
+	return policy, meta, nil
+}
+
+// Delete drops the deleted policy from the cache of every token
+func (p *cachingACLPolicies) Delete(policyName string, q *api.WriteOptions) (*api.WriteMeta, error) {
+	meta, err := p.ACLPoliciesAPI.Delete(policyName, q)
+	if err != nil {
+		return meta, err
+	}
+
+	p.c.acl.dropPolicies(func(key string) bool {
+		parts := strings.Split(key, "\x00")
+		return len(parts) >= 3 && parts[0] == p.c.cluster && parts[2] == policyName
+	})
+
+	return meta, nil
+}
+
+func (c *aclCache) dropPolicies(match cache.Matcher) {
+	ctx := context.Background()
+
+	entries, _ := c.policies.GetAll(ctx, match)
+	for key := range entries {
+		_ = c.policies.Delete(ctx, key)
+	}
+}
+
+// forget drops everything cached for a cluster
+func (c *aclCache) forget(cluster string) {
+	ctx := context.Background()
+	prefix := cluster + "\x00"
+
+	tokens, _ := c.tokens.GetAll(ctx, func(key string) bool { return strings.HasPrefix(key, prefix) })
+	for key := range tokens {
+		_ = c.tokens.Delete(ctx, key)
+	}
+
+	c.dropPolicies(func(key string) bool { return strings.HasPrefix(key, prefix) })
+}
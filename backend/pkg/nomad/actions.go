@@ -0,0 +1,180 @@
+package nomad
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/coder/websocket"
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+)
+
+// JobAction is an action declared by a task of a job
+type JobAction struct {
+	Name          string   `json:"name"`
+	Command       string   `json:"command"`
+	Args          []string `json:"args,omitempty"`
+	TaskName      string   `json:"taskName"`
+	TaskGroupName string   `json:"taskGroupName"`
+}
+
+// jobActions returns the actions the tasks of job declare
+func jobActions(job *api.Job) []JobAction {
+	actions := []JobAction{}
+	for _, tg := range job.TaskGroups {
+		for _, task := range tg.Tasks {
+			for _, action := range task.Actions {
+				actions = append(actions, JobAction{
+					Name:          action.Name,
+					Command:       action.Command,
+					Args:          action.Args,
+					TaskName:      task.Name,
+					TaskGroupName: stringValue(tg.Name),
+				})
+			}
+		}
+	}
+
+	return actions
+}
+
+// ListJobActions handles GET /clusters/{cluster}/v1/job/actions?id=jobID
+func (h *Handler) ListJobActions(w http.ResponseWriter, r *http.Request) {
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(getClusterName(r), getToken(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	job, _, err := client.Jobs().Info(jobID, getQueryOptions(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	writeJSON(w, jobActions(job))
+}
+
+// RunJobAction handles the WebSocket running an action of a job
+// GET /clusters/{cluster}/v1/job/action?id=jobID&action=name&allocID=&task=&tty=
+// The task is needed only when several tasks declare the action. Without an
+// allocation, a running allocation of the task's group is picked. The
+// output is streamed in the same messages as ExecAllocation's, and stdin can
+// be sent to the action as well; without tty=true it runs without a TTY.
+func (h *Handler) RunJobAction(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	query := r.URL.Query()
+	jobID := query.Get("id")
+	name := query.Get("action")
+	if jobID == "" || name == "" {
+		writeError(w, r, fmt.Errorf("job id and action are required"), http.StatusBadRequest)
+		return
+	}
+
+	if h.commandHeldBack(w, r, clusterName, query.Get("namespace"), token) {
+		return
+	}
+
+	clientConn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		OriginPatterns:  []string{"*"},
+		CompressionMode: h.wsCompression,
+	})
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "RunJobAction: Failed to upgrade client connection")
+		return
+	}
+	defer clientConn.CloseNow()
+
+	ctx := r.Context()
+
+	nomadCtx, err := h.configStore.GetContext(clusterName)
+	if err != nil {
+		sendWSError(ctx, clientConn, fmt.Sprintf("Failed to get cluster context: %v", err))
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		sendWSError(ctx, clientConn, fmt.Sprintf("Failed to create Nomad client: %v", err))
+		return
+	}
+
+	opts := getQueryOptions(r)
+	job, _, err := client.Jobs().Info(jobID, opts)
+	if err != nil {
+		sendWSError(ctx, clientConn, fmt.Sprintf("Failed to get job: %v", err))
+		return
+	}
+
+	var matches []JobAction
+	for _, action := range jobActions(job) {
+		if action.Name == name && (query.Get("task") == "" || action.TaskName == query.Get("task")) {
+			matches = append(matches, action)
+		}
+	}
+	if len(matches) == 0 {
+		sendWSError(ctx, clientConn, fmt.Sprintf("job %s has no action %q", jobID, name))
+		return
+	}
+	if len(matches) > 1 {
+		sendWSError(ctx, clientConn, fmt.Sprintf("several tasks declare action %q, pick one with task", name))
+		return
+	}
+	action := matches[0]
+
+	allocID := query.Get("allocID")
+	if allocID == "" {
+		allocs, _, err := client.Jobs().Allocations(jobID, false, opts)
+		if err != nil {
+			sendWSError(ctx, clientConn, fmt.Sprintf("Failed to list allocations: %v", err))
+			return
+		}
+
+		for _, alloc := range allocs {
+			if alloc.TaskGroup == action.TaskGroupName && alloc.ClientStatus == api.AllocClientStatusRunning {
+				allocID = alloc.ID
+				break
+			}
+		}
+		if allocID == "" {
+			sendWSError(ctx, clientConn, fmt.Sprintf("no running allocation of group %s", action.TaskGroupName))
+			return
+		}
+	}
+
+	logger.Log(logger.LevelInfo, map[string]string{
+		"cluster": clusterName,
+		"job":     jobID,
+		"action":  name,
+		"allocID": allocID,
+		"task":    action.TaskName,
+	}, nil, "RunJobAction: Starting action")
+
+	params := url.Values{}
+	params.Set("namespace", stringValue(job.Namespace))
+	params.Set("action", name)
+	params.Set("allocID", allocID)
+	params.Set("group", action.TaskGroupName)
+	params.Set("task", action.TaskName)
+	params.Set("tty", strconv.FormatBool(query.Get("tty") == "true"))
+	params.Set("command", "null")
+
+	nomadConn, err := h.dialNomadWebSocket(ctx, nomadCtx, token, "/v1/job/"+url.PathEscape(jobID)+"/action", params)
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "RunJobAction: Failed to connect to Nomad")
+		sendWSError(ctx, clientConn, err.Error())
+		return
+	}
+	defer nomadConn.CloseNow()
+
+	h.relayExec(ctx, "RunJobAction", clientConn, nomadConn, false)
+}
@@ -0,0 +1,293 @@
+package nomad
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/cache"
+)
+
+// pooledClient is the shared client of a cluster, used by the handler's
+// background work such as the event streams
+type pooledClient struct {
+	client    NomadAPI
+	createdAt time.Time
+	uses      atomic.Int64
+}
+
+// cacheCounters counts the hits and misses of a cache by cluster and
+// resource. The zero value is ready to use.
+type cacheCounters struct {
+	mutex  sync.Mutex
+	counts map[cacheCounterKey]*cacheCount
+}
+
+type cacheCounterKey struct{ cluster, resource string }
+
+type cacheCount struct{ hits, misses uint64 }
+
+func (c *cacheCounters) record(cluster, resource string, hit bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.counts == nil {
+		c.counts = make(map[cacheCounterKey]*cacheCount)
+	}
+
+	key := cacheCounterKey{cluster, resource}
+	count, ok := c.counts[key]
+	if !ok {
+		count = &cacheCount{}
+		c.counts[key] = count
+	}
+
+	if hit {
+		count.hits++
+	} else {
+		count.misses++
+	}
+}
+
+func (c *cacheCounters) get(cluster, resource string) cacheCount {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if count, ok := c.counts[cacheCounterKey{cluster, resource}]; ok {
+		return *count
+	}
+
+	return cacheCount{}
+}
+
+// countEntries counts the live entries of a cache whose keys start with
+// their cluster, by cluster
+func countEntries[T any](c cache.Cache[T]) map[string]int {
+	entries, _ := c.GetAll(context.Background(), nil)
+
+	counts := make(map[string]int)
+	for key := range entries {
+		cluster, _, _ := strings.Cut(key, "\x00")
+		counts[cluster]++
+	}
+
+	return counts
+}
+
+// CacheStats describe what a cache holds for a resource of a cluster
+type CacheStats struct {
+	// Cache is "response" for the cached lists and "acl" for the tokens and
+	// policies
+	Cache    string  `json:"cache"`
+	Cluster  string  `json:"cluster"`
+	Resource string  `json:"resource"`
+	Entries  int     `json:"entries"`
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRate  float64 `json:"hitRate"`
+}
+
+// CacheReport is the response of GET /api/admin/cache
+type CacheReport struct {
+	// ResponseCacheTTLSeconds is how long lists are cached, 0 if the
+	// response cache is disabled
+	ResponseCacheTTLSeconds float64      `json:"responseCacheTTLSeconds"`
+	Caches                  []CacheStats `json:"caches"`
+}
+
+func newCacheStats(name, cluster, resource string, entries int, count cacheCount) CacheStats {
+	stats := CacheStats{
+		Cache:    name,
+		Cluster:  cluster,
+		Resource: resource,
+		Entries:  entries,
+		Hits:     count.hits,
+		Misses:   count.misses,
+	}
+	if lookups := count.hits + count.misses; lookups > 0 {
+		stats.HitRate = float64(count.hits) / float64(lookups)
+	}
+
+	return stats
+}
+
+// clusterNames returns the names of the configured clusters, sorted
+func (h *Handler) clusterNames() []string {
+	var names []string
+	for _, c := range h.configStore.GetContexts() {
+		names = append(names, c.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// adminClusters returns the clusters an admin request is about: the one of
+// its cluster parameter, or all of them
+func (h *Handler) adminClusters(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	cluster := r.URL.Query().Get("cluster")
+	if cluster == "" {
+		return h.clusterNames(), true
+	}
+
+	if _, err := h.configStore.GetContext(cluster); err != nil {
+		writeError(w, r, errors.New("cluster not found"), http.StatusNotFound)
+		return nil, false
+	}
+
+	return []string{cluster}, true
+}
+
+// GetCacheStats handles GET /api/admin/cache?cluster=
+// It reports the entries, hits and misses of the response and ACL caches
+// by cluster and resource, for managers debugging stale pages.
+func (h *Handler) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireManagement(w, r, ""); !ok {
+		return
+	}
+
+	clusters, ok := h.adminClusters(w, r)
+	if !ok {
+		return
+	}
+
+	report := CacheReport{Caches: []CacheStats{}}
+
+	if rc := h.responseCache; rc != nil {
+		report.ResponseCacheTTLSeconds = rc.ttl.Seconds()
+
+		entries, _ := rc.entries.GetAll(context.Background(), nil)
+		counts := make(map[cacheCounterKey]int)
+		for key := range entries {
+			parts := strings.SplitN(key, "\x00", 3)
+			if len(parts) == 3 {
+				counts[cacheCounterKey{parts[0], parts[1]}]++
+			}
+		}
+
+		for _, cluster := range clusters {
+			for _, resource := range []string{cachedJobs, cachedNodes, cachedNamespaces} {
+				report.Caches = append(report.Caches, newCacheStats("response", cluster, resource,
+					counts[cacheCounterKey{cluster, resource}], rc.lookups.get(cluster, resource)))
+			}
+		}
+	}
+
+	tokens := countEntries(h.aclCache.tokens)
+	policies := countEntries(h.aclCache.policies)
+	for _, cluster := range clusters {
+		report.Caches = append(report.Caches,
+			newCacheStats("acl", cluster, cachedTokens, tokens[cluster], h.aclCache.lookups.get(cluster, cachedTokens)),
+			newCacheStats("acl", cluster, cachedPolicies, policies[cluster], h.aclCache.lookups.get(cluster, cachedPolicies)))
+	}
+
+	writeJSON(w, report)
+}
+
+// PurgeCache handles DELETE /api/admin/cache?cluster=
+// It drops what the response and ACL caches hold for the cluster, or for
+// all of them. The hit and miss counts are kept.
+func (h *Handler) PurgeCache(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireManagement(w, r, ""); !ok {
+		return
+	}
+
+	clusters, ok := h.adminClusters(w, r)
+	if !ok {
+		return
+	}
+
+	for _, cluster := range clusters {
+		if h.responseCache != nil {
+			h.responseCache.forget(cluster)
+		}
+		h.aclCache.forget(cluster)
+	}
+
+	// The accessors are keyed by a hash of their cluster and token, so they
+	// can only be dropped all at once
+	if h.responseCache != nil && r.URL.Query().Get("cluster") == "" {
+		ctx := context.Background()
+		accessors, _ := h.responseCache.accessors.GetAll(ctx, nil)
+		for key := range accessors {
+			_ = h.responseCache.accessors.Delete(ctx, key)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PooledClientInfo describes the shared client of a cluster
+type PooledClientInfo struct {
+	Cluster string `json:"cluster"`
+	Address string `json:"address"`
+	// Pooled reports whether a client is pooled; one is created on first use
+	Pooled     bool       `json:"pooled"`
+	CreatedAt  *time.Time `json:"createdAt,omitempty"`
+	AgeSeconds float64    `json:"ageSeconds,omitempty"`
+	Uses       int64      `json:"uses"`
+}
+
+// ListPooledClients handles GET /api/admin/clients?cluster=
+// It lists the configured clusters with their pooled client, if any.
+func (h *Handler) ListPooledClients(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireManagement(w, r, ""); !ok {
+		return
+	}
+
+	clusters, ok := h.adminClusters(w, r)
+	if !ok {
+		return
+	}
+
+	infos := make([]PooledClientInfo, 0, len(clusters))
+	for _, cluster := range clusters {
+		info := PooledClientInfo{Cluster: cluster}
+		if nomadCtx, err := h.configStore.GetContext(cluster); err == nil {
+			info.Address = nomadCtx.Address
+		}
+
+		h.mutex.RLock()
+		pooled, exists := h.clients[cluster]
+		h.mutex.RUnlock()
+
+		if exists {
+			createdAt := pooled.createdAt
+			info.Pooled = true
+			info.CreatedAt = &createdAt
+			info.AgeSeconds = time.Since(createdAt).Seconds()
+			info.Uses = pooled.uses.Load()
+		}
+
+		infos = append(infos, info)
+	}
+
+	writeJSON(w, infos)
+}
+
+// PurgePooledClients handles DELETE /api/admin/clients?cluster=
+// It drops the pooled client of the cluster, or of all of them, so that the
+// next use creates a new one. The caches are kept.
+func (h *Handler) PurgePooledClients(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireManagement(w, r, ""); !ok {
+		return
+	}
+
+	clusters, ok := h.adminClusters(w, r)
+	if !ok {
+		return
+	}
+
+	h.mutex.Lock()
+	for _, cluster := range clusters {
+		delete(h.clients, cluster)
+	}
+	h.mutex.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
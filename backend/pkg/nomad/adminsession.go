@@ -0,0 +1,156 @@
+package nomad
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/auth"
+)
+
+const (
+	// adminCookie carries the session of the Caravan admin
+	adminCookie = "caravan-admin"
+	// adminLoginKey is what failed admin logins are counted under, in place
+	// of a cluster
+	adminLoginKey = "caravan-admin"
+	// caravanAdminActor is the actor of what the Caravan admin does
+	caravanAdminActor = "caravan-admin"
+)
+
+// AdminLoginRequest is the body of POST /api/admin/login
+type AdminLoginRequest struct {
+	Password string `json:"password"`
+}
+
+// AdminLogin handles POST /api/admin/login. It checks the password saved by
+// the setup wizard and starts an admin session, which may change Caravan's
+// shared settings whatever the caller's Nomad tokens.
+func (h *Handler) AdminLogin(w http.ResponseWriter, r *http.Request) {
+	var req AdminLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	settings, err := h.adminSettings(r)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if settings == nil || settings.PasswordHash == "" {
+		apierror.Write(w, r, apierror.New(http.StatusNotFound, apierror.CodeNotFound,
+			"no admin password is configured"))
+		return
+	}
+
+	if !h.allowLogin(w, r, adminLoginKey) {
+		return
+	}
+
+	ok, err := settings.checkPassword(req.Password)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		wrong := apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, "wrong admin password")
+		h.loginFailed(r, adminLoginKey, wrong)
+		apierror.Write(w, r, wrong)
+		return
+	}
+	h.loginSucceeded(r)
+
+	expires := time.Now().Add(auth.Cookies.TTL)
+	http.SetCookie(w, auth.NewCookie(r, adminCookie, settings.sessionValue(expires), "/",
+		int(auth.Cookies.TTL.Seconds())))
+
+	h.audit(context.WithoutCancel(r.Context()), AuditEntry{Action: "admin-login", Actor: caravanAdminActor})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminLogout handles POST /api/admin/logout
+func (h *Handler) AdminLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, auth.NewCookie(r, adminCookie, "", "/", -1))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// caravanAdmin returns the identity of the caller if they are in an admin
+// session, nil otherwise
+func (h *Handler) caravanAdmin(r *http.Request) *api.ACLToken {
+	cookie, err := r.Cookie(adminCookie)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+
+	settings, err := h.adminSettings(r)
+	if err != nil || settings == nil || settings.PasswordHash == "" || !settings.validSession(cookie.Value) {
+		return nil
+	}
+
+	return &api.ACLToken{Name: caravanAdminActor, Type: "management"}
+}
+
+// checkPassword reports whether password is the admin password
+func (s *AdminSettings) checkPassword(password string) (bool, error) {
+	salt, err := hex.DecodeString(s.PasswordSalt)
+	if err != nil {
+		return false, fmt.Errorf("reading the admin password salt: %w", err)
+	}
+	want, err := hex.DecodeString(s.PasswordHash)
+	if err != nil {
+		return false, fmt.Errorf("reading the admin password hash: %w", err)
+	}
+
+	got, err := pbkdf2.Key(sha256.New, password, salt, s.PasswordIterations, len(want))
+	if err != nil {
+		return false, fmt.Errorf("hashing the password: %w", err)
+	}
+
+	return hmac.Equal(got, want), nil
+}
+
+// sessionValue returns the value of an admin session cookie expiring at
+// expires. It is signed with the password hash, so that sessions end when
+// the password changes.
+func (s *AdminSettings) sessionValue(expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+
+	return expiry + "." + base64.RawURLEncoding.EncodeToString(s.sessionMAC(expiry))
+}
+
+// validSession reports whether value is an unexpired admin session cookie
+func (s *AdminSettings) validSession(value string) bool {
+	expiry, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sum, s.sessionMAC(expiry)) {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+
+	return err == nil && time.Now().Unix() < unix
+}
+
+func (s *AdminSettings) sessionMAC(expiry string) []byte {
+	mac := hmac.New(sha256.New, []byte(s.PasswordHash))
+	mac.Write([]byte("admin-session." + expiry))
+
+	return mac.Sum(nil)
+}
@@ -1,10 +1,8 @@
 package nomad
 
 import (
-	"bufio"
 	"bytes"
 	"context"
-	"fmt"
 	"io"
 	"net/http"
 	"strconv"
@@ -19,18 +17,22 @@ func (h *Handler) ListAllocations(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	opts := getQueryOptions(r)
-	allocs, _, err := client.Allocations().List(opts)
+	opts, err := h.listOptions(r)
+	if err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	allocs, meta, err := client.Allocations().List(opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, allocs)
+	writeList(h, w, r, opts, allocs, meta)
 }
 
 // GetAllocation handles GET /clusters/{cluster}/v1/allocation/{allocID}
@@ -41,18 +43,20 @@ func (h *Handler) GetAllocation(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	alloc, _, err := client.Allocations().Info(allocID, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, alloc)
+	hints := h.runbookHints(r.Context(), clusterName, taskFailures(alloc.ID, alloc.TaskStates))
+
+	writeIndexed(w, r, alloc.ModifyIndex, AllocationWithHints{Allocation: alloc, RunbookHints: hints})
 }
 
 // RestartAllocation handles POST /clusters/{cluster}/v1/allocation/{allocID}/restart
@@ -63,7 +67,7 @@ func (h *Handler) RestartAllocation(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -73,7 +77,7 @@ func (h *Handler) RestartAllocation(w http.ResponseWriter, r *http.Request) {
 	opts := getQueryOptions(r)
 	err = client.Allocations().Restart(&api.Allocation{ID: allocID}, taskName, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -88,14 +92,14 @@ func (h *Handler) StopAllocation(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	resp, err := client.Allocations().Stop(&api.Allocation{ID: allocID}, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -111,7 +115,7 @@ func (h *Handler) StreamLogs(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -161,16 +165,11 @@ func (h *Handler) StreamLogs(w http.ResponseWriter, r *http.Request) {
 		}()
 	}
 
-	// Set up SSE headers for streaming
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	flusher, ok := w.(http.Flusher)
+	sse, ok := h.newSSEWriter(w, r, "logs")
 	if !ok {
-		writeError(w, fmt.Errorf("streaming not supported"), http.StatusInternalServerError)
 		return
 	}
+	defer sse.Close()
 
 	// Stream logs from the specified offset
 	frames, errCh := client.AllocFS().Logs(alloc, follow, task, logType, origin, offset, ctx.Done(), opts)
@@ -182,19 +181,21 @@ func (h *Handler) StreamLogs(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			if frame != nil && len(frame.Data) > 0 {
-				// Split the frame data into lines and send each as a separate SSE event
-				// This ensures proper SSE formatting since data fields can't contain raw newlines
-				scanner := bufio.NewScanner(bytes.NewReader(frame.Data))
-				for scanner.Scan() {
-					line := scanner.Text()
-					fmt.Fprintf(w, "data: %s\n\n", line)
-					flusher.Flush()
+				// Each line is an event of its own, as data fields can't
+				// contain raw newlines, and the frame goes out at once
+				for _, line := range logLines(frame.Data) {
+					sse.Event("", "", line)
+					if sse.Full() && sse.Flush() != nil {
+						return
+					}
+				}
+				if err := sse.Flush(); err != nil {
+					return
 				}
 			}
 		case err := <-errCh:
 			if err != nil && err != io.EOF {
-				fmt.Fprintf(w, "event: error\ndata: %s\n\n", err.Error())
-				flusher.Flush()
+				sse.Error(err)
 			}
 			return
 		case <-ctx.Done():
@@ -203,6 +204,25 @@ func (h *Handler) StreamLogs(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// logLines splits log data into lines without their line endings, cutting
+// lines longer than maxSSELine into several
+func logLines(data []byte) [][]byte {
+	var lines [][]byte
+	for len(data) > 0 {
+		line, rest, _ := bytes.Cut(data, []byte("\n"))
+		data = rest
+		line = bytes.TrimSuffix(line, []byte("\r"))
+
+		for len(line) > maxSSELine {
+			lines = append(lines, line[:maxSSELine])
+			line = line[maxSSELine:]
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
 // GetAllocationStats handles GET /clusters/{cluster}/v1/allocation/{allocID}/stats
 func (h *Handler) GetAllocationStats(w http.ResponseWriter, r *http.Request) {
 	clusterName := getClusterName(r)
@@ -211,7 +231,7 @@ func (h *Handler) GetAllocationStats(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -220,7 +240,7 @@ func (h *Handler) GetAllocationStats(w http.ResponseWriter, r *http.Request) {
 	opts.AuthToken = token // Required for client endpoints like /v1/client/allocation/stats
 	stats, err := client.Allocations().Stats(alloc, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
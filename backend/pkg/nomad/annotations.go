@@ -0,0 +1,186 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+// jobAnnotationsBucket holds one VersionAnnotation per annotated job version
+const jobAnnotationsBucket = "job-annotations"
+
+// VersionAnnotation is human context attached to a job version
+type VersionAnnotation struct {
+	Version  uint64 `json:"version"`
+	Reason   string `json:"reason,omitempty"`
+	Ticket   string `json:"ticket,omitempty"`
+	Deployer string `json:"deployer,omitempty"`
+	// Pinned marks a version as known good, e.g. as the target for reverts
+	Pinned    bool      `json:"pinned,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// annotationKey returns the store key of a version annotation. Versions are
+// zero padded so the annotations of a job list in version order.
+func annotationKey(cluster, namespace, jobID string, version uint64) string {
+	return jobKeyPrefix(cluster, namespace, jobID) + fmt.Sprintf("%020d", version)
+}
+
+func (h *Handler) putVersionAnnotation(ctx context.Context, cluster, namespace, jobID string, a VersionAnnotation) error {
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now().UTC()
+	}
+
+	return store.PutJSON(ctx, h.store, jobAnnotationsBucket, annotationKey(cluster, namespace, jobID, a.Version), a)
+}
+
+// versionAnnotations returns the annotations of a job keyed by version
+func (h *Handler) versionAnnotations(ctx context.Context, cluster, namespace, jobID string) (map[uint64]VersionAnnotation, error) {
+	annotations, err := store.ListJSON[VersionAnnotation](ctx, h.store, jobAnnotationsBucket,
+		jobKeyPrefix(cluster, namespace, jobID))
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint64]VersionAnnotation, len(annotations))
+	for _, a := range annotations {
+		byVersion[a.Version] = a
+	}
+
+	return byVersion, nil
+}
+
+// registeredVersion returns the version created by a registration. An
+// unchanged job spec keeps its version, so fall back to the current one.
+func registeredVersion(client NomadAPI, jobID string, resp *api.JobRegisterResponse, opts *api.QueryOptions) (uint64, error) {
+	versions, _, _, err := client.Jobs().Versions(jobID, false, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, v := range versions {
+		if v.JobModifyIndex != nil && *v.JobModifyIndex == resp.JobModifyIndex && v.Version != nil {
+			return *v.Version, nil
+		}
+	}
+
+	job, _, err := client.Jobs().Info(jobID, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	if job.Version == nil {
+		return 0, errors.New("job has no version")
+	}
+
+	return *job.Version, nil
+}
+
+// annotateRegistration stores the annotation sent along with a job registration
+func (h *Handler) annotateRegistration(r *http.Request, cluster string, client NomadAPI, job *api.Job,
+	resp *api.JobRegisterResponse, annotation VersionAnnotation,
+) error {
+	namespace := api.DefaultNamespace
+	if job.Namespace != nil && *job.Namespace != "" {
+		namespace = *job.Namespace
+	} else if ns := r.URL.Query().Get("namespace"); ns != "" {
+		namespace = ns
+	}
+
+	version, err := registeredVersion(client, *job.ID, resp, &api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		return err
+	}
+
+	annotation.Version = version
+	if annotation.Deployer == "" {
+		annotation.Deployer = deployerName(client)
+	}
+
+	return h.putVersionAnnotation(r.Context(), cluster, namespace, *job.ID, annotation)
+}
+
+// deployerName returns the name of the token used for a request, which is
+// the best guess at who deployed when no deployer was given
+func deployerName(client NomadAPI) string {
+	self, _, err := client.ACLTokens().Self(nil)
+	if err != nil || self == nil {
+		return ""
+	}
+
+	return self.Name
+}
+
+// PutJobVersionAnnotation handles PUT /clusters/{cluster}/v1/job/version/annotation?id=jobID&version=N
+func (h *Handler) PutJobVersionAnnotation(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	version, err := strconv.ParseUint(r.URL.Query().Get("version"), 10, 64)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("invalid version: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	var annotation VersionAnnotation
+	if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	// Make sure the version exists and the token may read the job
+	opts := getQueryOptions(r)
+	versions, _, _, err := client.Jobs().Versions(jobID, false, opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	found := false
+	for _, v := range versions {
+		if v.Version != nil && *v.Version == version {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		writeError(w, r, fmt.Errorf("job %q has no version %d", jobID, version), http.StatusNotFound)
+		return
+	}
+
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = api.DefaultNamespace
+	}
+
+	annotation.Version = version
+	if annotation.Deployer == "" {
+		annotation.Deployer = deployerName(client)
+	}
+
+	if err := h.putVersionAnnotation(r.Context(), clusterName, namespace, jobID, annotation); err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, annotation)
+}
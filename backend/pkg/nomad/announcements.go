@@ -0,0 +1,226 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+// announcementsBucket holds one Announcement per message
+const announcementsBucket = "announcements"
+
+// Severities of announcements
+const (
+	AnnouncementInfo     = "info"
+	AnnouncementWarning  = "warning"
+	AnnouncementCritical = "critical"
+)
+
+// Announcement is a message shown to every Caravan user, such as a warning
+// about an ongoing incident or a maintenance window
+type Announcement struct {
+	ID       string `json:"id"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+	// ExpiresAt is when the announcement stops being shown; nil keeps it
+	// until it is deleted
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	UpdatedBy string     `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// WithAnnouncementListener calls fn with the active announcements whenever
+// they change, such as to push them to connected browsers
+func WithAnnouncementListener(fn func([]Announcement)) Option {
+	return func(h *Handler) {
+		h.announcementListener = fn
+	}
+}
+
+// ListAnnouncements handles GET /api/announcements
+func (h *Handler) ListAnnouncements(w http.ResponseWriter, r *http.Request) {
+	announcements, err := h.activeAnnouncements(r.Context())
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, announcements)
+}
+
+// CreateAnnouncement handles POST /api/announcements
+func (h *Handler) CreateAnnouncement(w http.ResponseWriter, r *http.Request) {
+	h.saveAnnouncement(w, r, newID(), http.StatusCreated)
+}
+
+// UpdateAnnouncement handles PUT /api/announcements/{id}
+func (h *Handler) UpdateAnnouncement(w http.ResponseWriter, r *http.Request) {
+	var existing Announcement
+	if err := store.GetJSON(r.Context(), h.store, announcementsBucket, r.PathValue("id"), &existing); err != nil {
+		writeAnnouncementError(w, r, err)
+		return
+	}
+
+	h.saveAnnouncement(w, r, existing.ID, http.StatusOK)
+}
+
+// DeleteAnnouncement handles DELETE /api/announcements/{id}
+func (h *Handler) DeleteAnnouncement(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireManagement(w, r, ""); !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+
+	var announcement Announcement
+	if err := store.GetJSON(r.Context(), h.store, announcementsBucket, id, &announcement); err != nil {
+		writeAnnouncementError(w, r, err)
+		return
+	}
+
+	if err := h.store.Delete(r.Context(), announcementsBucket, id); err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	h.announcementsChanged(r.Context())
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Announcements returns the announcements to show now, most severe first.
+// They are part of the frontend configuration, so a failure to read them
+// is logged rather than failing it.
+func (h *Handler) Announcements(ctx context.Context) []Announcement {
+	announcements, err := h.activeAnnouncements(ctx)
+	if err != nil {
+		logger.Log(logger.LevelWarn, nil, err, "reading announcements")
+		return nil
+	}
+
+	return announcements
+}
+
+// saveAnnouncement validates the announcement in the request body and stores
+// it under id. Announcements reach the users of every cluster, so the
+// managers of any cluster may make them.
+func (h *Handler) saveAnnouncement(w http.ResponseWriter, r *http.Request, id string, status int) {
+	var announcement Announcement
+	if err := json.NewDecoder(r.Body).Decode(&announcement); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateAnnouncement(&announcement); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	self, ok := h.requireManagement(w, r, "")
+	if !ok {
+		return
+	}
+
+	announcement.ID = id
+	announcement.UpdatedAt = time.Now().UTC()
+	announcement.UpdatedBy = ""
+	if self != nil {
+		announcement.UpdatedBy = actorName(self)
+	}
+
+	if err := store.PutJSON(r.Context(), h.store, announcementsBucket, announcement.ID, announcement); err != nil {
+		writeError(w, r, fmt.Errorf("saving the announcement: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.announcementsChanged(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(announcement)
+}
+
+// announcementsChanged tells the listener the announcements now active
+func (h *Handler) announcementsChanged(ctx context.Context) {
+	if h.announcementListener == nil {
+		return
+	}
+
+	announcements, err := h.activeAnnouncements(ctx)
+	if err != nil {
+		logger.Log(logger.LevelWarn, nil, err, "reading announcements")
+		return
+	}
+
+	h.announcementListener(announcements)
+}
+
+// activeAnnouncements lists the announcements that have not expired, most
+// severe and then most recent first. Expired ones are removed on the way.
+func (h *Handler) activeAnnouncements(ctx context.Context) ([]Announcement, error) {
+	announcements, err := store.ListJSON[Announcement](ctx, h.store, announcementsBucket, "")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	active := []Announcement{}
+
+	for _, a := range announcements {
+		if a.ExpiresAt != nil && !a.ExpiresAt.After(now) {
+			if err := h.store.Delete(ctx, announcementsBucket, a.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+				return nil, err
+			}
+			continue
+		}
+		active = append(active, a)
+	}
+
+	rank := map[string]int{AnnouncementCritical: 0, AnnouncementWarning: 1, AnnouncementInfo: 2}
+	sort.SliceStable(active, func(i, j int) bool {
+		if rank[active[i].Severity] != rank[active[j].Severity] {
+			return rank[active[i].Severity] < rank[active[j].Severity]
+		}
+		return active[i].UpdatedAt.After(active[j].UpdatedAt)
+	})
+
+	return active, nil
+}
+
+func validateAnnouncement(a *Announcement) error {
+	a.Message = strings.TrimSpace(a.Message)
+	if a.Message == "" {
+		return errMissingField("message")
+	}
+
+	switch a.Severity {
+	case "":
+		a.Severity = AnnouncementInfo
+	case AnnouncementInfo, AnnouncementWarning, AnnouncementCritical:
+	default:
+		return fmt.Errorf("severity must be %q, %q or %q", AnnouncementInfo, AnnouncementWarning, AnnouncementCritical)
+	}
+
+	if a.ExpiresAt != nil && !a.ExpiresAt.After(time.Now()) {
+		return errors.New("expiresAt must be in the future")
+	}
+
+	return nil
+}
+
+func writeAnnouncementError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, r, errors.New("announcement not found"), http.StatusNotFound)
+		return
+	}
+
+	writeError(w, r, err, http.StatusInternalServerError)
+}
@@ -0,0 +1,455 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+// approvalsBucket holds one Approval per destructive action that was requested
+const approvalsBucket = "approvals"
+
+// Destructive actions that require an approval when the two-person rule is on
+const (
+	ActionJobPurge        = "job.purge"
+	ActionNodePurge       = "node.purge"
+	ActionACLTokenDelete  = "acl.token.delete"
+	ActionACLPolicyDelete = "acl.policy.delete"
+	ActionSystemGC        = "system.gc"
+)
+
+// Approval states
+const (
+	ApprovalPending  = "pending"
+	ApprovalRejected = "rejected"
+	ApprovalExecuted  = "executed"
+	ApprovalFailed    = "failed"
+	ApprovalCancelled = "cancelled"
+)
+
+// destructiveActions execute the destructive actions against Nomad, until
+// ctx is done
+var destructiveActions = map[string]func(ctx context.Context, client NomadAPI, namespace, target string) (interface{}, error){
+	ActionJobPurge: func(ctx context.Context, client NomadAPI, namespace, target string) (interface{}, error) {
+		evalID, meta, err := client.Jobs().Deregister(target, true,
+			(&api.WriteOptions{Namespace: namespace}).WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{"evalID": evalID, "writeMeta": meta}, nil
+	},
+	ActionNodePurge: func(ctx context.Context, client NomadAPI, _, target string) (interface{}, error) {
+		resp, _, err := client.Nodes().Purge(target, (&api.QueryOptions{}).WithContext(ctx))
+		return resp, err
+	},
+	ActionACLTokenDelete: func(ctx context.Context, client NomadAPI, _, target string) (interface{}, error) {
+		meta, err := client.ACLTokens().Delete(target, (&api.WriteOptions{}).WithContext(ctx))
+		return map[string]interface{}{"writeMeta": meta}, err
+	},
+	ActionACLPolicyDelete: func(ctx context.Context, client NomadAPI, _, target string) (interface{}, error) {
+		meta, err := client.ACLPolicies().Delete(target, (&api.WriteOptions{}).WithContext(ctx))
+		return map[string]interface{}{"writeMeta": meta}, err
+	},
+	ActionSystemGC: func(ctx context.Context, client NomadAPI, _, _ string) (interface{}, error) {
+		// The garbage collection call takes no options, and so no context
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return map[string]string{"status": "collected"}, client.System().GarbageCollect()
+	},
+}
+
+// Approval is a destructive action waiting for, or decided by, a second user
+type Approval struct {
+	ID          string    `json:"id"`
+	Cluster     string    `json:"cluster"`
+	Action      string    `json:"action"`
+	Namespace   string    `json:"namespace,omitempty"`
+	Target      string    `json:"target"`
+	Status      string    `json:"status"`
+	RequestedBy string    `json:"requestedBy"`
+	RequestedAt time.Time `json:"requestedAt"`
+	// RequesterAccessor identifies the requesting token so that it cannot
+	// approve its own request; the token secret itself is never stored
+	RequesterAccessor string `json:"requesterAccessor"`
+	// ImpersonatorAccessor identifies the admin's own token when the request
+	// was made while impersonating, as the admin is the requester too
+	ImpersonatorAccessor string      `json:"impersonatorAccessor,omitempty"`
+	DecidedBy            string      `json:"decidedBy,omitempty"`
+	DecidedAt            time.Time   `json:"decidedAt,omitempty"`
+	Result               interface{} `json:"result,omitempty"`
+	Error                string      `json:"error,omitempty"`
+}
+
+// requestedBy reports whether any of accessors made the request, either
+// with their token or by impersonating
+func (a *Approval) requestedBy(accessors ...string) bool {
+	for _, accessor := range accessors {
+		if accessor != "" && (accessor == a.RequesterAccessor || accessor == a.ImpersonatorAccessor) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WithApprovals turns on the two-person rule: destructive actions are
+// recorded as pending approvals and only run once another user approves them
+func WithApprovals(enabled bool) Option {
+	return func(h *Handler) {
+		h.requireApprovals = enabled
+	}
+}
+
+// tokenIdentity returns the accessor ID and a display name of the client's token
+func tokenIdentity(client NomadAPI) (accessor, name string, err error) {
+	self, _, err := client.ACLTokens().Self(nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	name = self.Name
+	if name == "" {
+		name = self.AccessorID
+	}
+
+	return self.AccessorID, name, nil
+}
+
+// impersonatorAccessor returns the accessor ID of the caller's own token on
+// cluster when they impersonate, "" when they act with their own token
+func (h *Handler) impersonatorAccessor(r *http.Request, cluster string) (string, error) {
+	if impersonationToken(r, cluster) == "" {
+		return "", nil
+	}
+
+	client, err := h.GetClientWithToken(cluster, ownToken(r, cluster))
+	if err != nil {
+		return "", err
+	}
+
+	accessor, _, err := tokenIdentity(client)
+
+	return accessor, err
+}
+
+// runDestructive executes a destructive action and writes its result, or,
+// with the two-person rule on, records it for approval and responds with
+// 202 Accepted and the pending approval
+func (h *Handler) runDestructive(w http.ResponseWriter, r *http.Request, client NomadAPI, action, namespace, target string) {
+	impersonator, err := h.impersonatorAccessor(r, getClusterName(r))
+	if err != nil && h.requireApprovals {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	result, approval, err := h.destructive(r.Context(), getClusterName(r), client, impersonator, action, namespace, target)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	if approval != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(approval)
+		return
+	}
+
+	writeJSON(w, result)
+}
+
+// destructive executes a destructive action and audits it, or, with the
+// two-person rule on, records it and returns the pending approval.
+// Impersonator is the accessor of the admin behind an impersonating client.
+func (h *Handler) destructive(ctx context.Context, cluster string, client NomadAPI, impersonator, action, namespace,
+	target string,
+) (interface{}, *Approval, error) {
+	accessor, name, err := tokenIdentity(client)
+	if err != nil && h.requireApprovals {
+		// Without an identity the two-person rule cannot be enforced
+		return nil, nil, err
+	}
+
+	if !h.requireApprovals {
+		result, err := destructiveActions[action](ctx, client, namespace, target)
+
+		entry := AuditEntry{
+			Cluster:       cluster,
+			Action:        action,
+			Namespace:     namespace,
+			Target:        target,
+			Actor:         name,
+			ActorAccessor: accessor,
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+
+		h.audit(ctx, entry)
+
+		return result, nil, err
+	}
+
+	approval := &Approval{
+		ID:                newID(),
+		Cluster:           cluster,
+		Action:            action,
+		Namespace:         namespace,
+		Target:            target,
+		Status:            ApprovalPending,
+		RequestedBy:       name,
+		RequestedAt:       time.Now().UTC(),
+		RequesterAccessor: accessor,
+	}
+	if impersonator != accessor {
+		approval.ImpersonatorAccessor = impersonator
+	}
+
+	if err := store.PutJSON(ctx, h.store, approvalsBucket, approval.ID, approval); err != nil {
+		return nil, nil, apierror.FromStatus(http.StatusInternalServerError, fmt.Errorf("storing approval: %w", err))
+	}
+
+	return nil, approval, nil
+}
+
+// ListApprovals handles GET /api/approvals?status=pending
+// Only the approvals of the clusters the caller has a valid token for are
+// listed, as they tell who asked for what to be destroyed.
+func (h *Handler) ListApprovals(w http.ResponseWriter, r *http.Request) {
+	reachable := map[string]bool{}
+	for _, c := range h.configStore.GetContexts() {
+		if h.reaches(r, c.Name) {
+			reachable[c.Name] = true
+		}
+	}
+	if len(reachable) == 0 {
+		writeError(w, r, apierror.New(http.StatusForbidden, apierror.CodePermissionDenied,
+			"a valid token is required"), http.StatusForbidden)
+		return
+	}
+
+	approvals, err := store.ListJSON[Approval](r.Context(), h.store, approvalsBucket, "")
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	filtered := []Approval{}
+
+	for _, a := range approvals {
+		if reachable[a.Cluster] && (status == "" || a.Status == status) {
+			filtered = append(filtered, a)
+		}
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		return filtered[i].RequestedAt.After(filtered[j].RequestedAt)
+	})
+
+	writeJSON(w, filtered)
+}
+
+// GetApproval handles GET /api/approvals/{id}
+func (h *Handler) GetApproval(w http.ResponseWriter, r *http.Request) {
+	approval, err := h.getApproval(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeApprovalError(w, r, err)
+		return
+	}
+
+	// The approvals of other clusters are not told apart from missing ones
+	if !h.reaches(r, approval.Cluster) {
+		writeApprovalError(w, r, store.ErrNotFound)
+		return
+	}
+
+	writeJSON(w, approval)
+}
+
+// ApproveApproval handles POST /api/approvals/{id}/approve. The action runs
+// with the approver's token, so the approver needs the Nomad permissions for it.
+func (h *Handler) ApproveApproval(w http.ResponseWriter, r *http.Request) {
+	h.decideApproval(w, r, true)
+}
+
+// RejectApproval handles POST /api/approvals/{id}/reject
+func (h *Handler) RejectApproval(w http.ResponseWriter, r *http.Request) {
+	h.decideApproval(w, r, false)
+}
+
+// CancelApproval handles DELETE /api/approvals/{id}. Only the requester may
+// withdraw a pending request, which is kept as cancelled.
+func (h *Handler) CancelApproval(w http.ResponseWriter, r *http.Request) {
+	h.approvalsMutex.Lock()
+	defer h.approvalsMutex.Unlock()
+
+	approval, err := h.getApproval(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeApprovalError(w, r, err)
+		return
+	}
+
+	// The approvals of other clusters are not told apart from missing ones
+	if !h.reaches(r, approval.Cluster) {
+		writeApprovalError(w, r, store.ErrNotFound)
+		return
+	}
+
+	client, err := h.GetClientWithToken(approval.Cluster, getTokenForCluster(r, approval.Cluster))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	accessor, name, err := tokenIdentity(client)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	impersonator, err := h.impersonatorAccessor(r, approval.Cluster)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	if !approval.requestedBy(accessor, impersonator) {
+		writeError(w, r, apierror.New(http.StatusForbidden, apierror.CodePermissionDenied,
+			"only the requester can cancel an approval"), http.StatusForbidden)
+		return
+	}
+
+	if approval.Status != ApprovalPending {
+		writeError(w, r, fmt.Errorf("approval is already %s", approval.Status), http.StatusConflict)
+		return
+	}
+
+	approval.Status = ApprovalCancelled
+	approval.DecidedBy = name
+	approval.DecidedAt = time.Now().UTC()
+
+	h.saveDecision(w, r, approval)
+}
+
+func (h *Handler) decideApproval(w http.ResponseWriter, r *http.Request, approve bool) {
+	// Serialize decisions so an approval cannot be executed twice
+	h.approvalsMutex.Lock()
+	defer h.approvalsMutex.Unlock()
+
+	approval, err := h.getApproval(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeApprovalError(w, r, err)
+		return
+	}
+
+	if approval.Status != ApprovalPending {
+		writeError(w, r, fmt.Errorf("approval is already %s", approval.Status), http.StatusConflict)
+		return
+	}
+
+	token := getTokenForCluster(r, approval.Cluster)
+
+	client, err := h.GetClientWithToken(approval.Cluster, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	accessor, name, err := tokenIdentity(client)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	// An admin may not decide their own request by impersonating, nor
+	// decide one they made while impersonating
+	impersonator, err := h.impersonatorAccessor(r, approval.Cluster)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	if approval.requestedBy(accessor, impersonator) {
+		writeError(w, r, errors.New("approvals must be decided by a different user than the requester"),
+			http.StatusForbidden)
+		return
+	}
+
+	approval.DecidedBy = name
+	approval.DecidedAt = time.Now().UTC()
+
+	if !approve {
+		approval.Status = ApprovalRejected
+		h.saveDecision(w, r, approval)
+
+		return
+	}
+
+	if active := h.activeFreeze(approval.Cluster, namespaceOrDefault(approval.Namespace), token); len(active) > 0 {
+		writeFrozen(w, r, approval.Cluster, active)
+		return
+	}
+
+	result, err := destructiveActions[approval.Action](r.Context(), client, approval.Namespace, approval.Target)
+
+	approval.Status = ApprovalExecuted
+	approval.Result = result
+
+	if err != nil {
+		approval.Status = ApprovalFailed
+		approval.Error = err.Error()
+	}
+
+	h.audit(r.Context(), AuditEntry{
+		Cluster:       approval.Cluster,
+		Action:        approval.Action,
+		Namespace:     approval.Namespace,
+		Target:        approval.Target,
+		Actor:         name,
+		ActorAccessor: accessor,
+		RequestedBy:   approval.RequestedBy,
+		ApprovalID:    approval.ID,
+		Error:         approval.Error,
+	})
+
+	h.saveDecision(w, r, approval)
+}
+
+func (h *Handler) saveDecision(w http.ResponseWriter, r *http.Request, approval *Approval) {
+	if err := store.PutJSON(r.Context(), h.store, approvalsBucket, approval.ID, approval); err != nil {
+		writeError(w, r, fmt.Errorf("storing approval: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, approval)
+}
+
+func (h *Handler) getApproval(ctx context.Context, id string) (*Approval, error) {
+	var approval Approval
+	if err := store.GetJSON(ctx, h.store, approvalsBucket, id, &approval); err != nil {
+		return nil, err
+	}
+
+	return &approval, nil
+}
+
+func writeApprovalError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, r, errors.New("approval not found"), http.StatusNotFound)
+		return
+	}
+
+	writeError(w, r, err, http.StatusInternalServerError)
+}
@@ -0,0 +1,139 @@
+package nomad
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/forwarded"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+// auditBucket holds one AuditEntry per destructive action Caravan executed
+const auditBucket = "audit"
+
+// AuditEntry records a destructive action and who was responsible for it
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Cluster   string    `json:"cluster"`
+	Action    string    `json:"action"`
+	Namespace string    `json:"namespace,omitempty"`
+	Target    string    `json:"target"`
+	Actor     string    `json:"actor"`
+	// ActorAccessor is the accessor ID of the actor's token, if it is known
+	ActorAccessor string `json:"actorAccessor,omitempty"`
+	// RequestedBy and ApprovalID are set for actions that went through an approval
+	RequestedBy string `json:"requestedBy,omitempty"`
+	ApprovalID  string `json:"approvalId,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// ClientIP is the address the action was requested from
+	ClientIP string `json:"clientIp,omitempty"`
+}
+
+// audit logs and stores an audit entry. Failing to store it is logged but
+// does not fail the action, which has already happened.
+func (h *Handler) audit(ctx context.Context, e AuditEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.ClientIP == "" {
+		e.ClientIP = forwarded.ClientIPFromContext(ctx)
+	}
+
+	fields := map[string]string{
+		"cluster": e.Cluster,
+		"action":  e.Action,
+		"target":  e.Target,
+		"actor":   e.Actor,
+	}
+	if e.ClientIP != "" {
+		fields["client"] = e.ClientIP
+	}
+	if e.ApprovalID != "" {
+		fields["approval"] = e.ApprovalID
+	}
+
+	if e.Error != "" {
+		fields["error"] = e.Error
+	}
+
+	logger.Log(logger.LevelInfo, fields, nil, "audit")
+
+	// Keys sort by time, the random suffix keeps entries of the same instant apart
+	key := timeKey(e.Time) + "-" + newID()
+	if err := store.PutJSON(ctx, h.store, auditBucket, key, e); err != nil {
+		logger.Log(logger.LevelWarn, fields, err, "storing audit entry")
+	}
+}
+
+// auditFields are the fields the audit log is filtered and sorted by
+var auditFields = listFields[AuditEntry]{
+	filter: map[string]func(*AuditEntry) string{
+		"cluster":   func(e *AuditEntry) string { return e.Cluster },
+		"action":    func(e *AuditEntry) string { return e.Action },
+		"namespace": func(e *AuditEntry) string { return e.Namespace },
+		"target":    func(e *AuditEntry) string { return e.Target },
+		"actor":     func(e *AuditEntry) string { return e.Actor },
+		"failed":    func(e *AuditEntry) string { return strconv.FormatBool(e.Error != "") },
+	},
+	sort: map[string]func(a, b *AuditEntry) bool{
+		"cluster": func(a, b *AuditEntry) bool { return a.Cluster < b.Cluster },
+		"action":  func(a, b *AuditEntry) bool { return a.Action < b.Action },
+		"actor":   func(a, b *AuditEntry) bool { return a.Actor < b.Actor },
+	},
+}
+
+// ListAuditLog handles GET /api/audit, with the paging, time range, sorting
+// and filters of listQuery. Entries are those of the clusters the caller
+// manages, or of the cluster filtered by. The Caravan admin also sees those
+// of Caravan itself, such as admin logins.
+func (h *Handler) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	q, err := listQuery(r, auditFields)
+	if err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if cluster := r.URL.Query().Get("cluster"); cluster != "" {
+		if _, ok := h.requireManagement(w, r, cluster); !ok {
+			return
+		}
+	} else {
+		managed := map[string]bool{}
+		for _, c := range h.configStore.GetContexts() {
+			if _, ok := h.manages(r, c.Name); ok {
+				managed[c.Name] = true
+			}
+		}
+		if h.caravanAdmin(r) != nil {
+			managed[""] = true
+			managed[adminLoginKey] = true
+		}
+		if len(managed) == 0 {
+			writeError(w, r, apierror.New(http.StatusForbidden, apierror.CodePermissionDenied,
+				"a management token is required"), http.StatusForbidden)
+			return
+		}
+
+		filter := q.Filter
+		q.Filter = func(e *AuditEntry) bool {
+			return managed[e.Cluster] && (filter == nil || filter(e))
+		}
+	}
+
+	page, err := store.QueryJSON(r.Context(), h.store, auditBucket, q)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, page)
+}
@@ -0,0 +1,185 @@
+package nomad
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+)
+
+// autoscalerClient talks to Nomad Autoscaler agents. Their API is small and
+// local to the cluster, so requests that take long mean the agent is stuck.
+var autoscalerClient = &http.Client{Timeout: 10 * time.Second}
+
+// AutoscalerHealth is the health of a cluster's Nomad Autoscaler agent
+type AutoscalerHealth struct {
+	Address    string `json:"address"`
+	Healthy    bool   `json:"healthy"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// autoscalerAddress returns the autoscaler agent of the request's cluster,
+// writing an error if none is configured
+func (h *Handler) autoscalerAddress(w http.ResponseWriter, r *http.Request) (string, bool) {
+	clusterName := getClusterName(r)
+
+	ctx, err := h.configStore.GetContext(clusterName)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return "", false
+	}
+
+	if ctx.AutoscalerAddress == "" {
+		apierror.Write(w, r, apierror.New(http.StatusNotFound, apierror.CodeNotFound,
+			"no Nomad Autoscaler is configured for this cluster").WithCluster(clusterName))
+		return "", false
+	}
+
+	// The agent's API has no ACLs, so only users with a valid token for the
+	// cluster may see it
+	client, err := h.GetClientWithToken(clusterName, getToken(r))
+	if err == nil {
+		_, _, err = client.ACLTokens().Self(nil)
+	}
+
+	if err != nil && !strings.Contains(err.Error(), "ACL support disabled") {
+		writeNomadError(w, r, err)
+		return "", false
+	}
+
+	return strings.TrimSuffix(ctx.AutoscalerAddress, "/"), true
+}
+
+// GetAutoscalerHealth handles GET /clusters/{cluster}/autoscaler/health
+// An unhealthy or unreachable agent is reported in the body, not as an error.
+func (h *Handler) GetAutoscalerHealth(w http.ResponseWriter, r *http.Request) {
+	addr, ok := h.autoscalerAddress(w, r)
+	if !ok {
+		return
+	}
+
+	health := AutoscalerHealth{Address: addr}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, addr+"/v1/health", nil)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := autoscalerClient.Do(req)
+	if err != nil {
+		health.Error = err.Error()
+		writeJSON(w, health)
+		return
+	}
+	defer resp.Body.Close()
+
+	health.StatusCode = resp.StatusCode
+	health.Healthy = resp.StatusCode == http.StatusOK
+	if !health.Healthy {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		health.Error = strings.TrimSpace(string(body))
+	}
+
+	writeJSON(w, health)
+}
+
+// GetAutoscalerMetrics handles GET /clusters/{cluster}/autoscaler/metrics
+// It proxies the agent's telemetry, which reports the policies it evaluates
+// and how long the evaluations take.
+func (h *Handler) GetAutoscalerMetrics(w http.ResponseWriter, r *http.Request) {
+	addr, ok := h.autoscalerAddress(w, r)
+	if !ok {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, addr+"/v1/metrics", nil)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := autoscalerClient.Do(req)
+	if err != nil {
+		apierror.Write(w, r, apierror.New(http.StatusBadGateway, apierror.CodeAutoscalerUnreachable, err.Error()).
+			WithCluster(getClusterName(r)))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		apierror.Write(w, r, apierror.New(http.StatusBadGateway, apierror.CodeAutoscalerUnreachable,
+			strings.TrimSpace(string(body))).WithCluster(getClusterName(r)).WithUpstreamStatus(resp.StatusCode))
+		return
+	}
+
+	var metrics json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&metrics); err != nil {
+		writeError(w, r, fmt.Errorf("invalid autoscaler metrics: %w", err), http.StatusBadGateway)
+		return
+	}
+
+	writeJSON(w, metrics)
+}
+
+// ListAutoscalerPolicies handles GET /clusters/{cluster}/autoscaler/policies?job=&type=
+// The agent has no policy API of its own: it evaluates the scaling policies
+// stored in Nomad, so they are listed from there with the user's token.
+func (h *Handler) ListAutoscalerPolicies(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	opts.Params = map[string]string{}
+	for _, param := range []string{"job", "type"} {
+		if v := r.URL.Query().Get(param); v != "" {
+			opts.Params[param] = v
+		}
+	}
+
+	policies, _, err := client.Scaling().ListPolicies(opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	if policies == nil {
+		policies = []*api.ScalingPolicyListStub{}
+	}
+
+	writeJSON(w, policies)
+}
+
+// GetAutoscalerPolicy handles GET /clusters/{cluster}/autoscaler/policy/{policyID}
+func (h *Handler) GetAutoscalerPolicy(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	policyID := r.PathValue("policyID")
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	policy, _, err := client.Scaling().GetPolicy(policyID, getQueryOptions(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	writeJSON(w, policy)
+}
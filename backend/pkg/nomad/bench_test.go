@@ -0,0 +1,132 @@
+package nomad
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadtest"
+)
+
+// Benchmarks of the proxy's hot paths, run against the budget of
+// scripts/bench-budget.txt by scripts/bench.sh. They live in the package to
+// reach its unexported helpers.
+
+// discardWriter is a streaming response writer that throws the body away
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+func (w *discardWriter) Flush()                      {}
+
+func BenchmarkGetQueryOptions(b *testing.B) {
+	r := httptest.NewRequest(http.MethodGet,
+		"/api/clusters/test/v1/allocations?namespace=prod&region=eu&prefix=web&per_page=100", nil)
+	r.SetPathValue("cluster", "test")
+
+	b.ReportAllocs()
+	for b.Loop() {
+		getQueryOptions(r)
+	}
+}
+
+// allocationStubs returns n allocation stubs shaped like those of a busy
+// service job
+func allocationStubs(n int) []*api.AllocationListStub {
+	created := time.Now().UnixNano()
+	stubs := make([]*api.AllocationListStub, n)
+	for i := range stubs {
+		stubs[i] = &api.AllocationListStub{
+			ID:            fmt.Sprintf("%08x-0000-4000-8000-%012x", i, i),
+			EvalID:        fmt.Sprintf("%08x-1111-4000-8000-%012x", i, i),
+			Name:          fmt.Sprintf("web.web[%d]", i),
+			Namespace:     "default",
+			NodeID:        fmt.Sprintf("%08x-2222-4000-8000-%012x", i%50, i%50),
+			NodeName:      fmt.Sprintf("client-%d", i%50),
+			JobID:         "web",
+			JobType:       api.JobTypeService,
+			JobVersion:    3,
+			TaskGroup:     "web",
+			DesiredStatus: api.AllocDesiredStatusRun,
+			ClientStatus:  api.AllocClientStatusRunning,
+			TaskStates: map[string]*api.TaskState{
+				"web": {
+					State:     "running",
+					StartedAt: time.Unix(0, created),
+					Events: []*api.TaskEvent{
+						{Type: api.TaskReceived, Time: created, DisplayMessage: "Task received by client"},
+						{Type: api.TaskStarted, Time: created, DisplayMessage: "Task started by client"},
+					},
+				},
+			},
+			CreateIndex: uint64(i),
+			ModifyIndex: uint64(i),
+			CreateTime:  created,
+			ModifyTime:  created,
+		}
+	}
+
+	return stubs
+}
+
+func BenchmarkWriteJSONAllocations(b *testing.B) {
+	for _, n := range []int{1000, 10000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			stubs := allocationStubs(n)
+			w := &discardWriter{header: http.Header{}}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				writeJSON(w, stubs)
+			}
+		})
+	}
+}
+
+func BenchmarkWriteJSONArrayAllocations(b *testing.B) {
+	for _, n := range []int{1000, 10000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			stubs := allocationStubs(n)
+			w := &discardWriter{header: http.Header{}}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				writeJSONArray(w, stubs)
+			}
+		})
+	}
+}
+
+func BenchmarkStreamLogs(b *testing.B) {
+	nomadSrv := nomadtest.NewServer(b)
+	allocs := nomadSrv.RunJob(b, nomadtest.ServiceJob("web", 1))
+
+	// About 1MB of log lines, which is what the fake keeps of a task log
+	line := strings.Repeat("x", 99) + "\n"
+	if err := nomadSrv.AppendLog(allocs[0].ID, "web", "stdout", []byte(strings.Repeat(line, 10000))); err != nil {
+		b.Fatal(err)
+	}
+
+	h := NewHandler(nomadSrv.ContextStore("test"))
+	b.Cleanup(func() { h.InvalidateClient("test") })
+
+	r := httptest.NewRequest(http.MethodGet, "/api/clusters/test/v1/allocation/"+allocs[0].ID+
+		"/logs/web?origin=start", nil)
+	r.SetPathValue("cluster", "test")
+	r.SetPathValue("allocID", allocs[0].ID)
+	r.SetPathValue("task", "web")
+
+	b.SetBytes(int64(10000 * len(line)))
+	b.ReportAllocs()
+	for b.Loop() {
+		h.StreamLogs(&discardWriter{header: http.Header{}}, r)
+	}
+}
+
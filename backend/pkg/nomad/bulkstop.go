@@ -0,0 +1,137 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+)
+
+// maxBulkStop is the most jobs stopped by one request
+const maxBulkStop = 500
+
+// StopJobsRequest is the body of the bulk stop endpoint
+type StopJobsRequest struct {
+	Jobs []StopJobTarget `json:"jobs"`
+}
+
+// StopJobTarget is a job to stop
+type StopJobTarget struct {
+	ID string `json:"id"`
+	// Namespace defaults to the namespace of the request
+	Namespace string `json:"namespace,omitempty"`
+	// Purge removes the job and its history for good, after an approval
+	// when the two-person rule is on
+	Purge bool `json:"purge,omitempty"`
+}
+
+// StopJobsResponse has the result of each job, in the order of the request
+type StopJobsResponse struct {
+	Results []StopJobResult `json:"results"`
+	Failed  int             `json:"failed"`
+}
+
+// StopJobResult is the outcome of stopping one job
+type StopJobResult struct {
+	ID        string `json:"id"`
+	Namespace string `json:"namespace"`
+	Purge     bool   `json:"purge,omitempty"`
+	EvalID    string `json:"evalId,omitempty"`
+	// Approval is the pending approval of a purge under the two-person rule
+	Approval *Approval       `json:"approval,omitempty"`
+	Error    *apierror.Error `json:"error,omitempty"`
+}
+
+// StopJobs handles POST /clusters/{cluster}/v1/jobs/stop
+// It deregisters the jobs concurrently. One job failing does not stop the
+// others; each result carries its own error.
+func (h *Handler) StopJobs(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+
+	var req StopJobsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Jobs) == 0 {
+		writeError(w, r, errors.New("jobs is required"), http.StatusBadRequest)
+		return
+	}
+	if len(req.Jobs) > maxBulkStop {
+		writeError(w, r, fmt.Errorf("at most %d jobs can be stopped at once", maxBulkStop), http.StatusBadRequest)
+		return
+	}
+	for _, job := range req.Jobs {
+		if job.ID == "" {
+			writeError(w, r, errors.New("every job needs an id"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	impersonator, err := h.impersonatorAccessor(r, clusterName)
+	if err != nil && h.requireApprovals {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	defaultNamespace := getWriteOptions(r).Namespace
+	resp := StopJobsResponse{Results: make([]StopJobResult, len(req.Jobs))}
+
+	g := fanout.New(r.Context(), fanout.Options{Timeout: compositeCallTimeout})
+	for i, job := range req.Jobs {
+		result := &resp.Results[i]
+		result.ID, result.Purge = job.ID, job.Purge
+		result.Namespace = job.Namespace
+		if result.Namespace == "" {
+			result.Namespace = namespaceOrDefault(defaultNamespace)
+		}
+
+		g.Go(strconv.Itoa(i), func(ctx context.Context) error {
+			err := h.stopJob(ctx, clusterName, client, impersonator, result)
+			if err != nil {
+				result.Error = classifyNomadError(err).WithCluster(clusterName)
+			}
+			return err
+		})
+	}
+	resp.Failed = len(g.Wait())
+
+	writeJSON(w, resp)
+}
+
+// stopJob deregisters the job of result, filling in the evaluation or the
+// pending approval
+func (h *Handler) stopJob(ctx context.Context, cluster string, client NomadAPI, impersonator string,
+	result *StopJobResult,
+) error {
+	if !result.Purge {
+		evalID, _, err := client.Jobs().Deregister(result.ID, false,
+			(&api.WriteOptions{Namespace: result.Namespace}).WithContext(ctx))
+		result.EvalID = evalID
+		return err
+	}
+
+	purged, approval, err := h.destructive(ctx, cluster, client, impersonator, ActionJobPurge, result.Namespace, result.ID)
+	if err != nil {
+		return err
+	}
+	result.Approval = approval
+	if m, ok := purged.(map[string]interface{}); ok {
+		result.EvalID, _ = m["evalID"].(string)
+	}
+
+	return nil
+}
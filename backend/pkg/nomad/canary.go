@@ -0,0 +1,237 @@
+package nomad
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+)
+
+// CanaryComparison sets the canaries of a deployment beside the stable
+// allocations of the same task groups, to help decide whether to promote or
+// fail it
+type CanaryComparison struct {
+	DeploymentID string                  `json:"deploymentId"`
+	JobID        string                  `json:"jobId"`
+	Namespace    string                  `json:"namespace"`
+	JobVersion   uint64                  `json:"jobVersion"`
+	Status       string                  `json:"status"`
+	Groups       []CanaryGroupComparison `json:"groups"`
+	// Errors maps the allocations whose stats or checks could not be read
+	// to the error; they are left out of the means
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// CanaryGroupComparison compares the canaries of one task group
+type CanaryGroupComparison struct {
+	TaskGroup string          `json:"taskGroup"`
+	Promoted  bool            `json:"promoted"`
+	Canary    AllocGroupStats `json:"canary"`
+	Stable    AllocGroupStats `json:"stable"`
+}
+
+// AllocGroupStats sums up a set of allocations
+type AllocGroupStats struct {
+	Count int `json:"count"`
+	// Means over the allocations whose stats could be read
+	MeanCPUPercent  float64 `json:"meanCpuPercent"`
+	MeanCPUTicks    float64 `json:"meanCpuTicks"`
+	MeanMemoryBytes float64 `json:"meanMemoryBytes"`
+	// Totals over all allocations
+	Restarts      uint64       `json:"restarts"`
+	CheckFailures int          `json:"checkFailures"`
+	Allocations   []AllocStats `json:"allocations"`
+}
+
+// AllocStats are the figures compared of one allocation
+type AllocStats struct {
+	ID            string  `json:"id"`
+	NodeID        string  `json:"nodeId"`
+	JobVersion    uint64  `json:"jobVersion"`
+	ClientStatus  string  `json:"clientStatus"`
+	Healthy       *bool   `json:"healthy,omitempty"`
+	CPUPercent    float64 `json:"cpuPercent"`
+	CPUTicks      float64 `json:"cpuTicks"`
+	MemoryBytes   uint64  `json:"memoryBytes"`
+	Restarts      uint64  `json:"restarts"`
+	Checks        int     `json:"checks"`
+	CheckFailures int     `json:"checkFailures"`
+	statsRead     bool
+}
+
+// GetCanaryComparison handles GET /clusters/{cluster}/v1/deployment/{deployID}/canary-compare
+//
+// The canaries are the allocations the deployment placed as such, the
+// stable allocations the other running ones of their task groups.
+func (h *Handler) GetCanaryComparison(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	deployID := r.PathValue("deployID")
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	deployment, _, err := client.Deployments().Info(deployID, opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts.Namespace = deployment.Namespace
+	allocs, _, err := client.Jobs().Allocations(deployment.JobID, false, opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	canaries := map[string]bool{}
+	for _, state := range deployment.TaskGroups {
+		for _, id := range state.PlacedCanaries {
+			canaries[id] = true
+		}
+	}
+
+	var compared []*api.AllocationListStub
+	for _, alloc := range allocs {
+		_, inDeployment := deployment.TaskGroups[alloc.TaskGroup]
+		if !inDeployment {
+			continue
+		}
+		if canaries[alloc.ID] || alloc.ClientStatus == api.AllocClientStatusRunning {
+			compared = append(compared, alloc)
+		}
+	}
+
+	statsOpts := getQueryOptions(r)
+	statsOpts.AuthToken = token // Required for client endpoints
+	results := fanout.Map(r.Context(), compared, fanout.Options{Timeout: compositeCallTimeout},
+		func(ctx context.Context, alloc *api.AllocationListStub) (AllocStats, error) {
+			return allocStats(ctx, client, alloc, statsOpts)
+		})
+
+	resp := CanaryComparison{
+		DeploymentID: deployment.ID,
+		JobID:        deployment.JobID,
+		Namespace:    deployment.Namespace,
+		JobVersion:   deployment.JobVersion,
+		Status:       deployment.Status,
+		Groups:       []CanaryGroupComparison{},
+		Errors:       map[string]string{},
+	}
+
+	groups := map[string]*CanaryGroupComparison{}
+	for name, state := range deployment.TaskGroups {
+		groups[name] = &CanaryGroupComparison{
+			TaskGroup: name,
+			Promoted:  state.Promoted,
+			Canary:    AllocGroupStats{Allocations: []AllocStats{}},
+			Stable:    AllocGroupStats{Allocations: []AllocStats{}},
+		}
+	}
+
+	for i, result := range results {
+		alloc := compared[i]
+		if result.Err != nil {
+			resp.Errors[alloc.ID] = result.Err.Error()
+		}
+
+		side := &groups[alloc.TaskGroup].Stable
+		if canaries[alloc.ID] {
+			side = &groups[alloc.TaskGroup].Canary
+		}
+		side.Allocations = append(side.Allocations, result.Value)
+	}
+
+	for _, group := range groups {
+		group.Canary.sum()
+		group.Stable.sum()
+		resp.Groups = append(resp.Groups, *group)
+	}
+	sort.Slice(resp.Groups, func(i, j int) bool { return resp.Groups[i].TaskGroup < resp.Groups[j].TaskGroup })
+
+	h.writeCompositeJSON(w, r, resp)
+}
+
+// allocStats reads the resource usage and check results of an allocation.
+// The figures known from the stub are returned even when reading fails.
+func allocStats(ctx context.Context, client NomadAPI, alloc *api.AllocationListStub,
+	opts *api.QueryOptions) (AllocStats, error) {
+	stats := AllocStats{
+		ID:           alloc.ID,
+		NodeID:       alloc.NodeID,
+		JobVersion:   alloc.JobVersion,
+		ClientStatus: alloc.ClientStatus,
+	}
+	if alloc.DeploymentStatus != nil {
+		stats.Healthy = alloc.DeploymentStatus.Healthy
+	}
+	for _, state := range alloc.TaskStates {
+		stats.Restarts += state.Restarts
+	}
+
+	if alloc.ClientStatus != api.AllocClientStatusRunning {
+		return stats, nil
+	}
+
+	usage, err := client.Allocations().Stats(&api.Allocation{ID: alloc.ID}, opts.WithContext(ctx))
+	if err != nil {
+		return stats, err
+	}
+	if usage.ResourceUsage != nil {
+		if cpu := usage.ResourceUsage.CpuStats; cpu != nil {
+			stats.CPUPercent = cpu.Percent
+			stats.CPUTicks = cpu.TotalTicks
+		}
+		if mem := usage.ResourceUsage.MemoryStats; mem != nil {
+			stats.MemoryBytes = mem.RSS
+			if stats.MemoryBytes == 0 {
+				stats.MemoryBytes = mem.Usage
+			}
+		}
+	}
+	stats.statsRead = true
+
+	checks, err := client.Allocations().Checks(alloc.ID, opts.WithContext(ctx))
+	if err != nil {
+		return stats, err
+	}
+	for _, check := range checks {
+		stats.Checks++
+		if check.Status == "failure" {
+			stats.CheckFailures++
+		}
+	}
+
+	return stats, nil
+}
+
+func (s *AllocGroupStats) sum() {
+	sort.Slice(s.Allocations, func(i, j int) bool { return s.Allocations[i].ID < s.Allocations[j].ID })
+
+	read := 0
+	for _, alloc := range s.Allocations {
+		s.Restarts += alloc.Restarts
+		s.CheckFailures += alloc.CheckFailures
+		if !alloc.statsRead {
+			continue
+		}
+
+		read++
+		s.MeanCPUPercent += alloc.CPUPercent
+		s.MeanCPUTicks += alloc.CPUTicks
+		s.MeanMemoryBytes += float64(alloc.MemoryBytes)
+	}
+
+	s.Count = len(s.Allocations)
+	if read > 0 {
+		s.MeanCPUPercent /= float64(read)
+		s.MeanCPUTicks /= float64(read)
+		s.MeanMemoryBytes /= float64(read)
+	}
+}
@@ -0,0 +1,248 @@
+package nomad
+
+import (
+	"context"
+	"io"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
+)
+
+// NomadAPI is the part of the Nomad API the handlers use. The handlers only
+// talk to Nomad through it, so tests can substitute mocks and decorators
+// such as caches can wrap the real client.
+//
+// The endpoint groups mirror the Nomad SDK, and *api.Jobs, *api.Nodes etc.
+// implement them; SDKClient adapts an *api.Client.
+type NomadAPI interface {
+	Jobs() JobsAPI
+	Allocations() AllocationsAPI
+	AllocFS() AllocFSAPI
+	Nodes() NodesAPI
+	Evaluations() EvaluationsAPI
+	Deployments() DeploymentsAPI
+	Namespaces() NamespacesAPI
+	Variables() VariablesAPI
+	Services() ServicesAPI
+	Recommendations() RecommendationsAPI
+	Scaling() ScalingAPI
+	ACLTokens() ACLTokensAPI
+	ACLPolicies() ACLPoliciesAPI
+	ACLAuthMethods() ACLAuthMethodsAPI
+	ACLAuth() ACLAuthAPI
+	EventStream() EventStreamAPI
+	Status() StatusAPI
+	System() SystemAPI
+}
+
+// JobsAPI is implemented by *api.Jobs
+type JobsAPI interface {
+	List(q *api.QueryOptions) ([]*api.JobListStub, *api.QueryMeta, error)
+	Info(jobID string, q *api.QueryOptions) (*api.Job, *api.QueryMeta, error)
+	Register(job *api.Job, q *api.WriteOptions) (*api.JobRegisterResponse, *api.WriteMeta, error)
+	EnforceRegister(job *api.Job, modifyIndex uint64, q *api.WriteOptions) (*api.JobRegisterResponse, *api.WriteMeta,
+		error)
+	Deregister(jobID string, purge bool, q *api.WriteOptions) (string, *api.WriteMeta, error)
+	Scale(jobID, group string, count *int, message string, error bool, meta map[string]interface{},
+		q *api.WriteOptions) (*api.JobRegisterResponse, *api.WriteMeta, error)
+	Plan(job *api.Job, diff bool, q *api.WriteOptions) (*api.JobPlanResponse, *api.WriteMeta, error)
+	Summary(jobID string, q *api.QueryOptions) (*api.JobSummary, *api.QueryMeta, error)
+	ScaleStatus(jobID string, q *api.QueryOptions) (*api.JobScaleStatusResponse, *api.QueryMeta, error)
+	LatestDeployment(jobID string, q *api.QueryOptions) (*api.Deployment, *api.QueryMeta, error)
+	Versions(jobID string, diffs bool, q *api.QueryOptions) ([]*api.Job, []*api.JobDiff, *api.QueryMeta, error)
+	Allocations(jobID string, allAllocs bool, q *api.QueryOptions) ([]*api.AllocationListStub, *api.QueryMeta, error)
+	Evaluations(jobID string, q *api.QueryOptions) ([]*api.Evaluation, *api.QueryMeta, error)
+	Deployments(jobID string, all bool, q *api.QueryOptions) ([]*api.Deployment, *api.QueryMeta, error)
+	Dispatch(jobID string, meta map[string]string, payload []byte, idPrefixTemplate string,
+		q *api.WriteOptions) (*api.JobDispatchResponse, *api.WriteMeta, error)
+	EvaluateWithOpts(jobID string, opts api.EvalOptions, q *api.WriteOptions) (string, *api.WriteMeta, error)
+	ParseHCLOpts(req *api.JobsParseRequest) (*api.Job, error)
+	Validate(job *api.Job, q *api.WriteOptions) (*api.JobValidateResponse, *api.WriteMeta, error)
+	Revert(jobID string, version uint64, enforcePriorVersion *uint64, q *api.WriteOptions,
+		consulToken, vaultToken string) (*api.JobRegisterResponse, *api.WriteMeta, error)
+}
+
+// AllocationsAPI is implemented by *api.Allocations
+type AllocationsAPI interface {
+	List(q *api.QueryOptions) ([]*api.AllocationListStub, *api.QueryMeta, error)
+	Info(allocID string, q *api.QueryOptions) (*api.Allocation, *api.QueryMeta, error)
+	Restart(alloc *api.Allocation, taskName string, q *api.QueryOptions) error
+	Stop(alloc *api.Allocation, q *api.QueryOptions) (*api.AllocStopResponse, error)
+	Stats(alloc *api.Allocation, q *api.QueryOptions) (*api.AllocResourceUsage, error)
+	Checks(allocID string, q *api.QueryOptions) (api.AllocCheckStatuses, error)
+}
+
+// AllocFSAPI is implemented by *api.AllocFS
+type AllocFSAPI interface {
+	List(alloc *api.Allocation, path string, q *api.QueryOptions) ([]*api.AllocFileInfo, *api.QueryMeta, error)
+	Stat(alloc *api.Allocation, path string, q *api.QueryOptions) (*api.AllocFileInfo, *api.QueryMeta, error)
+	Cat(alloc *api.Allocation, path string, q *api.QueryOptions) (io.ReadCloser, error)
+	ReadAt(alloc *api.Allocation, path string, offset, limit int64, q *api.QueryOptions) (io.ReadCloser, error)
+	Logs(alloc *api.Allocation, follow bool, task, logType, origin string, offset int64,
+		cancel <-chan struct{}, q *api.QueryOptions) (<-chan *api.StreamFrame, <-chan error)
+	Stream(alloc *api.Allocation, path, origin string, offset int64,
+		cancel <-chan struct{}, q *api.QueryOptions) (<-chan *api.StreamFrame, <-chan error)
+}
+
+// NodesAPI is implemented by *api.Nodes
+type NodesAPI interface {
+	List(q *api.QueryOptions) ([]*api.NodeListStub, *api.QueryMeta, error)
+	Info(nodeID string, q *api.QueryOptions) (*api.Node, *api.QueryMeta, error)
+	Allocations(nodeID string, q *api.QueryOptions) ([]*api.Allocation, *api.QueryMeta, error)
+	Stats(nodeID string, q *api.QueryOptions) (*api.HostStats, error)
+	UpdateDrain(nodeID string, spec *api.DrainSpec, markEligible bool,
+		q *api.WriteOptions) (*api.NodeDrainUpdateResponse, error)
+	ToggleEligibility(nodeID string, eligible bool, q *api.WriteOptions) (*api.NodeEligibilityUpdateResponse, error)
+	Purge(nodeID string, q *api.QueryOptions) (*api.NodePurgeResponse, *api.QueryMeta, error)
+}
+
+// EvaluationsAPI is implemented by *api.Evaluations
+type EvaluationsAPI interface {
+	List(q *api.QueryOptions) ([]*api.Evaluation, *api.QueryMeta, error)
+	Info(evalID string, q *api.QueryOptions) (*api.Evaluation, *api.QueryMeta, error)
+	Allocations(evalID string, q *api.QueryOptions) ([]*api.AllocationListStub, *api.QueryMeta, error)
+}
+
+// DeploymentsAPI is implemented by *api.Deployments
+type DeploymentsAPI interface {
+	List(q *api.QueryOptions) ([]*api.Deployment, *api.QueryMeta, error)
+	Info(deploymentID string, q *api.QueryOptions) (*api.Deployment, *api.QueryMeta, error)
+	Allocations(deploymentID string, q *api.QueryOptions) ([]*api.AllocationListStub, *api.QueryMeta, error)
+	PromoteGroups(deploymentID string, groups []string,
+		q *api.WriteOptions) (*api.DeploymentUpdateResponse, *api.WriteMeta, error)
+	Fail(deploymentID string, q *api.WriteOptions) (*api.DeploymentUpdateResponse, *api.WriteMeta, error)
+	Pause(deploymentID string, pause bool, q *api.WriteOptions) (*api.DeploymentUpdateResponse, *api.WriteMeta, error)
+}
+
+// NamespacesAPI is implemented by *api.Namespaces
+type NamespacesAPI interface {
+	List(q *api.QueryOptions) ([]*api.Namespace, *api.QueryMeta, error)
+	Info(name string, q *api.QueryOptions) (*api.Namespace, *api.QueryMeta, error)
+}
+
+// VariablesAPI is implemented by *api.Variables
+type VariablesAPI interface {
+	List(q *api.QueryOptions) ([]*api.VariableMetadata, *api.QueryMeta, error)
+	Read(path string, q *api.QueryOptions) (*api.Variable, *api.QueryMeta, error)
+	Create(v *api.Variable, q *api.WriteOptions) (*api.Variable, *api.WriteMeta, error)
+	Delete(path string, q *api.WriteOptions) (*api.WriteMeta, error)
+}
+
+// ServicesAPI is implemented by *api.Services
+type ServicesAPI interface {
+	List(q *api.QueryOptions) ([]*api.ServiceRegistrationListStub, *api.QueryMeta, error)
+	Get(serviceName string, q *api.QueryOptions) ([]*api.ServiceRegistration, *api.QueryMeta, error)
+}
+
+// RecommendationsAPI is implemented by *api.Recommendations
+type RecommendationsAPI interface {
+	List(q *api.QueryOptions) ([]*api.Recommendation, *api.QueryMeta, error)
+}
+
+// ScalingAPI is implemented by *api.Scaling
+type ScalingAPI interface {
+	ListPolicies(q *api.QueryOptions) ([]*api.ScalingPolicyListStub, *api.QueryMeta, error)
+	GetPolicy(id string, q *api.QueryOptions) (*api.ScalingPolicy, *api.QueryMeta, error)
+}
+
+// ACLTokensAPI is implemented by *api.ACLTokens
+type ACLTokensAPI interface {
+	List(q *api.QueryOptions) ([]*api.ACLTokenListStub, *api.QueryMeta, error)
+	Info(accessorID string, q *api.QueryOptions) (*api.ACLToken, *api.QueryMeta, error)
+	Self(q *api.QueryOptions) (*api.ACLToken, *api.QueryMeta, error)
+	Create(token *api.ACLToken, q *api.WriteOptions) (*api.ACLToken, *api.WriteMeta, error)
+	Delete(accessorID string, q *api.WriteOptions) (*api.WriteMeta, error)
+	BootstrapOpts(btoken string, q *api.WriteOptions) (*api.ACLToken, *api.WriteMeta, error)
+	UpsertOneTimeToken(q *api.WriteOptions) (*api.OneTimeToken, *api.WriteMeta, error)
+	ExchangeOneTimeToken(secret string, q *api.WriteOptions) (*api.ACLToken, *api.WriteMeta, error)
+}
+
+// ACLPoliciesAPI is implemented by *api.ACLPolicies
+type ACLPoliciesAPI interface {
+	List(q *api.QueryOptions) ([]*api.ACLPolicyListStub, *api.QueryMeta, error)
+	Info(policyName string, q *api.QueryOptions) (*api.ACLPolicy, *api.QueryMeta, error)
+	Delete(policyName string, q *api.WriteOptions) (*api.WriteMeta, error)
+}
+
+// ACLAuthMethodsAPI is implemented by *api.ACLAuthMethods
+type ACLAuthMethodsAPI interface {
+	List(q *api.QueryOptions) ([]*api.ACLAuthMethodListStub, *api.QueryMeta, error)
+	Get(authMethodName string, q *api.QueryOptions) (*api.ACLAuthMethod, *api.QueryMeta, error)
+}
+
+// ACLAuthAPI is implemented by *api.ACLAuth
+type ACLAuthAPI interface {
+	GetAuthURL(req *api.ACLOIDCAuthURLRequest, q *api.WriteOptions) (*api.ACLOIDCAuthURLResponse, *api.WriteMeta, error)
+	CompleteAuth(req *api.ACLOIDCCompleteAuthRequest, q *api.WriteOptions) (*api.ACLToken, *api.WriteMeta, error)
+	Login(req *api.ACLLoginRequest, q *api.WriteOptions) (*api.ACLToken, *api.WriteMeta, error)
+}
+
+// EventStreamAPI is implemented by *api.EventStream
+type EventStreamAPI interface {
+	Stream(ctx context.Context, topics map[api.Topic][]string, index uint64,
+		q *api.QueryOptions) (<-chan *api.Events, error)
+}
+
+// StatusAPI is implemented by *api.Status
+type StatusAPI interface {
+	Leader() (string, error)
+}
+
+// SystemAPI is implemented by *api.System
+type SystemAPI interface {
+	GarbageCollect() error
+	ReconcileSummaries() error
+}
+
+// SDKClient implements NomadAPI with the Nomad SDK.
+type SDKClient struct {
+	*api.Client
+}
+
+var _ NomadAPI = (*SDKClient)(nil)
+
+// NewSDKClient wraps an SDK client.
+func NewSDKClient(c *api.Client) *SDKClient {
+	return &SDKClient{Client: c}
+}
+
+func (c *SDKClient) Jobs() JobsAPI                       { return c.Client.Jobs() }
+func (c *SDKClient) Allocations() AllocationsAPI         { return c.Client.Allocations() }
+func (c *SDKClient) AllocFS() AllocFSAPI                 { return c.Client.AllocFS() }
+func (c *SDKClient) Nodes() NodesAPI                     { return c.Client.Nodes() }
+func (c *SDKClient) Evaluations() EvaluationsAPI         { return c.Client.Evaluations() }
+func (c *SDKClient) Deployments() DeploymentsAPI         { return c.Client.Deployments() }
+func (c *SDKClient) Namespaces() NamespacesAPI           { return c.Client.Namespaces() }
+func (c *SDKClient) Variables() VariablesAPI             { return c.Client.Variables() }
+func (c *SDKClient) Services() ServicesAPI               { return c.Client.Services() }
+func (c *SDKClient) Recommendations() RecommendationsAPI { return c.Client.Recommendations() }
+func (c *SDKClient) Scaling() ScalingAPI                 { return c.Client.Scaling() }
+func (c *SDKClient) ACLTokens() ACLTokensAPI             { return c.Client.ACLTokens() }
+func (c *SDKClient) ACLPolicies() ACLPoliciesAPI         { return c.Client.ACLPolicies() }
+func (c *SDKClient) ACLAuthMethods() ACLAuthMethodsAPI   { return c.Client.ACLAuthMethods() }
+func (c *SDKClient) ACLAuth() ACLAuthAPI                 { return c.Client.ACLAuth() }
+func (c *SDKClient) EventStream() EventStreamAPI         { return c.Client.EventStream() }
+func (c *SDKClient) Status() StatusAPI                   { return c.Client.Status() }
+func (c *SDKClient) System() SystemAPI                   { return c.Client.System() }
+
+// ClientFactory creates the client for a cluster context. The token is empty
+// for the handler's shared client, which uses the context's own token.
+type ClientFactory func(ctx *nomadconfig.Context, token string) (NomadAPI, error)
+
+// sdkClientFactory is the default ClientFactory, which talks to the real cluster
+func sdkClientFactory(ctx *nomadconfig.Context, token string) (NomadAPI, error) {
+	client, err := ctx.GetClientWithToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSDKClient(client), nil
+}
+
+// WithClientFactory replaces how the handler creates Nomad clients, e.g. to
+// return mocks in tests or to wrap the SDK client in a decorator.
+func WithClientFactory(factory ClientFactory) Option {
+	return func(h *Handler) {
+		h.newClient = factory
+	}
+}
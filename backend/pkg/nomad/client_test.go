@@ -0,0 +1,195 @@
+package nomad_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomad"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockClient implements only the endpoint groups a test needs; calling any
+// other panics on the nil embedded interface
+type mockClient struct {
+	nomad.NomadAPI
+	jobs nomad.JobsAPI
+}
+
+func (m *mockClient) Jobs() nomad.JobsAPI { return m.jobs }
+
+func (m *mockClient) ACLTokens() nomad.ACLTokensAPI { return mockACLTokens{} }
+
+func (m *mockClient) EventStream() nomad.EventStreamAPI { return mockEventStream{} }
+
+// mockACLTokens resolves every token to the same accessor
+type mockACLTokens struct {
+	nomad.ACLTokensAPI
+}
+
+func (mockACLTokens) Self(*api.QueryOptions) (*api.ACLToken, *api.QueryMeta, error) {
+	return &api.ACLToken{AccessorID: "accessor"}, &api.QueryMeta{}, nil
+}
+
+// mockEventStream never sends events
+type mockEventStream struct{}
+
+func (mockEventStream) Stream(context.Context, map[api.Topic][]string, uint64,
+	*api.QueryOptions,
+) (<-chan *api.Events, error) {
+	return make(chan *api.Events), nil
+}
+
+type mockJobs struct {
+	nomad.JobsAPI
+	info  func(jobID string, q *api.QueryOptions) (*api.Job, *api.QueryMeta, error)
+	calls []string
+	lists int
+}
+
+func (m *mockJobs) List(q *api.QueryOptions) ([]*api.JobListStub, *api.QueryMeta, error) {
+	m.lists++
+	return []*api.JobListStub{{ID: "web", Namespace: q.Namespace}}, &api.QueryMeta{}, nil
+}
+
+func (m *mockJobs) Info(jobID string, q *api.QueryOptions) (*api.Job, *api.QueryMeta, error) {
+	m.calls = append(m.calls, jobID+"@"+q.Namespace)
+	return m.info(jobID, q)
+}
+
+func newMockHandler(jobs *mockJobs, opts ...nomad.Option) *nomad.Handler {
+	store := nomadconfig.NewInMemoryContextStore()
+	store.AddContext(&nomadconfig.Context{Name: cluster, Address: "http://nomad.invalid"})
+
+	opts = append(opts, nomad.WithClientFactory(func(*nomadconfig.Context, string) (nomad.NomadAPI, error) {
+		return &mockClient{jobs: jobs}, nil
+	}))
+
+	return nomad.NewHandler(store, opts...)
+}
+
+func serveGetJob(h *nomad.Handler, target string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job", h.GetJob)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/jobs", h.ListJobs)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+	return rec
+}
+
+func TestGetJobWithMockClient(t *testing.T) {
+	jobs := &mockJobs{info: func(jobID string, _ *api.QueryOptions) (*api.Job, *api.QueryMeta, error) {
+		return &api.Job{ID: &jobID}, &api.QueryMeta{}, nil
+	}}
+
+	rec := serveGetJob(newMockHandler(jobs), "/api/clusters/test/v1/job?id=web&namespace=prod")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var job api.Job
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &job))
+	assert.Equal(t, "web", *job.ID)
+	assert.Equal(t, []string{"web@prod"}, jobs.calls)
+}
+
+func TestGetJobMapsNomadErrors(t *testing.T) {
+	jobs := &mockJobs{info: func(string, *api.QueryOptions) (*api.Job, *api.QueryMeta, error) {
+		return nil, nil, errors.New("Unexpected response code: 403 (Permission denied)")
+	}}
+
+	rec := serveGetJob(newMockHandler(jobs), "/api/clusters/test/v1/job?id=web")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"PERMISSION_DENIED"`)
+}
+
+func TestListJobsIsCached(t *testing.T) {
+	jobs := &mockJobs{}
+	h := newMockHandler(jobs, nomad.WithResponseCache(time.Minute))
+
+	for range 3 {
+		rec := serveGetJob(h, "/api/clusters/test/v1/jobs?namespace=prod")
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	assert.Equal(t, 1, jobs.lists)
+
+	rec := serveGetJob(h, "/api/clusters/test/v1/jobs?namespace=dev")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, jobs.lists, "namespaces are cached separately")
+
+	h.InvalidateClient(cluster)
+
+	serveGetJob(h, "/api/clusters/test/v1/jobs?namespace=prod")
+	assert.Equal(t, 3, jobs.lists)
+}
+
+// countingACLTokens resolves each secret to a token of its own and counts
+// the lookups that reach Nomad
+type countingACLTokens struct {
+	nomad.ACLTokensAPI
+	secret string
+	selfs  map[string]int
+}
+
+func (c countingACLTokens) Self(*api.QueryOptions) (*api.ACLToken, *api.QueryMeta, error) {
+	c.selfs[c.secret]++
+	return &api.ACLToken{AccessorID: "accessor-" + c.secret, SecretID: c.secret}, &api.QueryMeta{}, nil
+}
+
+func (c countingACLTokens) Delete(string, *api.WriteOptions) (*api.WriteMeta, error) {
+	return &api.WriteMeta{}, nil
+}
+
+type aclClient struct {
+	nomad.NomadAPI
+	tokens countingACLTokens
+}
+
+func (c *aclClient) ACLTokens() nomad.ACLTokensAPI { return c.tokens }
+
+func TestSelfTokenIsCached(t *testing.T) {
+	selfs := make(map[string]int)
+
+	store := nomadconfig.NewInMemoryContextStore()
+	store.AddContext(&nomadconfig.Context{Name: cluster, Address: "http://nomad.invalid"})
+	h := nomad.NewHandler(store,
+		nomad.WithClientFactory(func(_ *nomadconfig.Context, token string) (nomad.NomadAPI, error) {
+			return &aclClient{tokens: countingACLTokens{secret: token, selfs: selfs}}, nil
+		}))
+
+	self := func(secret string, q *api.QueryOptions) *api.ACLToken {
+		client, err := h.GetClientWithToken(cluster, secret)
+		require.NoError(t, err)
+
+		token, _, err := client.ACLTokens().Self(q)
+		require.NoError(t, err)
+
+		return token
+	}
+
+	for range 3 {
+		assert.Equal(t, "accessor-alice", self("alice", nil).AccessorID)
+	}
+	assert.Equal(t, "accessor-bob", self("bob", nil).AccessorID)
+	assert.Equal(t, map[string]int{"alice": 1, "bob": 1}, selfs)
+
+	self("alice", &api.QueryOptions{WaitIndex: 10})
+	assert.Equal(t, 2, selfs["alice"], "blocking queries go to Nomad")
+
+	client, err := h.GetClientWithToken(cluster, "admin")
+	require.NoError(t, err)
+	_, err = client.ACLTokens().Delete("accessor-alice", nil)
+	require.NoError(t, err)
+
+	self("alice", nil)
+	self("bob", nil)
+	assert.Equal(t, 3, selfs["alice"], "deleted tokens are dropped")
+	assert.Equal(t, 1, selfs["bob"])
+}
@@ -0,0 +1,117 @@
+package nomad
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/telemetry"
+)
+
+// pendingEvalsFilter lists only the pending evaluations
+const pendingEvalsFilter = `Status == "pending"`
+
+// The states always exported, at 0 when no object is in them, so that
+// series do not come and go with the objects
+var (
+	jobStatuses       = []string{"pending", "running", "dead"}
+	allocClientStates = []string{"pending", "running", "complete", "failed", "lost", "unknown"}
+	nodeStatuses      = []string{"initializing", "ready", "down", "disconnected"}
+)
+
+// ExportClusterMetrics refreshes, every interval until ctx is done, gauges
+// of the jobs, allocations, nodes and pending evaluations of every cluster,
+// which /metrics exports:
+//
+//	nomad_jobs{cluster, status}
+//	nomad_allocations{cluster, client_status}
+//	nomad_nodes{cluster, status}
+//	nomad_evaluations_pending{cluster}
+//
+// The lists are read with the token configured for each cluster, so they
+// only count what that token can read.
+func (h *Handler) ExportClusterMetrics(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	h.followClusters(ctx, interval, func(cluster string) func() {
+		return func() { telemetry.RemoveClusterGauges(cluster) }
+	}, func(cluster string) {
+		if err := h.refreshClusterMetrics(ctx, cluster); err != nil && ctx.Err() == nil {
+			logger.Log(logger.LevelWarn, map[string]string{"cluster": cluster}, err, "refreshing cluster metrics")
+		}
+	})
+}
+
+// refreshClusterMetrics counts the objects of a cluster. When a list cannot
+// be read, the gauges keep their last values.
+func (h *Handler) refreshClusterMetrics(ctx context.Context, cluster string) error {
+	client, err := h.GetClient(cluster)
+	if err != nil {
+		return err
+	}
+
+	var (
+		jobs   []*api.JobListStub
+		allocs []*api.AllocationListStub
+		nodes  []*api.NodeListStub
+		evals  []*api.Evaluation
+	)
+
+	g := fanout.New(ctx, fanout.Options{Timeout: compositeCallTimeout})
+
+	g.Go("jobs", func(ctx context.Context) (err error) {
+		jobs, _, err = client.Jobs().List((&api.QueryOptions{Namespace: "*"}).WithContext(ctx))
+		return err
+	})
+	g.Go("allocations", func(ctx context.Context) (err error) {
+		allocs, _, err = client.Allocations().List((&api.QueryOptions{Namespace: "*"}).WithContext(ctx))
+		return err
+	})
+	g.Go("nodes", func(ctx context.Context) (err error) {
+		nodes, _, err = client.Nodes().List((&api.QueryOptions{}).WithContext(ctx))
+		return err
+	})
+	g.Go("evaluations", func(ctx context.Context) (err error) {
+		evals, _, err = client.Evaluations().List((&api.QueryOptions{
+			Namespace: "*",
+			Filter:    pendingEvalsFilter,
+		}).WithContext(ctx))
+		return err
+	})
+
+	if err := g.Wait().Err(); err != nil {
+		return err
+	}
+
+	values := map[string]float64{}
+	count := func(name, label string, known []string, state func(i int) string, n int) {
+		for _, s := range known {
+			values[fmt.Sprintf(`%s{%s=%q}`, name, label, s)] = 0
+		}
+		for i := 0; i < n; i++ {
+			values[fmt.Sprintf(`%s{%s=%q}`, name, label, state(i))]++
+		}
+	}
+
+	count("nomad_jobs", "status", jobStatuses, func(i int) string { return jobs[i].Status }, len(jobs))
+	count("nomad_allocations", "client_status", allocClientStates,
+		func(i int) string { return allocs[i].ClientStatus }, len(allocs))
+	count("nomad_nodes", "status", nodeStatuses, func(i int) string { return nodes[i].Status }, len(nodes))
+
+	// Servers that do not know the filter return every evaluation
+	values["nomad_evaluations_pending"] = 0
+	for _, eval := range evals {
+		if eval.Status == "pending" {
+			values["nomad_evaluations_pending"]++
+		}
+	}
+
+	telemetry.SetClusterGauges(cluster, values)
+
+	return nil
+}
@@ -0,0 +1,292 @@
+package nomad
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
+)
+
+const (
+	// connectProxyPrefix names the sidecar tasks Nomad injects for Connect
+	// services, as well as their proxy port labels
+	connectProxyPrefix = "connect-proxy-"
+	// maxEnvoyStatsSize bounds the output read from the envoy admin API
+	maxEnvoyStatsSize = 4 << 20
+)
+
+// envoyClusterStatRe splits the stat name of an envoy cluster, such as
+// cluster.db.default.dc1.internal.<trust domain>.consul.upstream_cx_active
+var envoyClusterStatRe = regexp.MustCompile(`^cluster\.(.+)\.([a-z0-9_]+)$`)
+
+// AllocConnect is the Consul Connect setup of an allocation
+type AllocConnect struct {
+	AllocID   string          `json:"allocId"`
+	JobID     string          `json:"jobId"`
+	Namespace string          `json:"namespace"`
+	TaskGroup string          `json:"taskGroup"`
+	Sidecars  []SidecarStatus `json:"sidecars"`
+}
+
+// SidecarStatus is the sidecar proxy of a Connect service. Stats are only
+// read when asked for, by running a command in the allocation that queries
+// the envoy admin API; StatsError tells why they could not be read.
+type SidecarStatus struct {
+	Service    string                `json:"service"`
+	Task       string                `json:"task"`
+	State      string                `json:"state"`
+	Failed     bool                  `json:"failed"`
+	Restarts   uint64                `json:"restarts"`
+	StartedAt  time.Time             `json:"startedAt,omitzero"`
+	LastEvent  string                `json:"lastEvent,omitempty"`
+	ProxyPort  *api.PortMapping      `json:"proxyPort,omitempty"`
+	Upstreams  []*api.ConsulUpstream `json:"upstreams"`
+	Stats      *EnvoyStats           `json:"stats,omitempty"`
+	StatsError string                `json:"statsError,omitempty"`
+}
+
+// EnvoyStats are the envoy statistics that matter when debugging the mesh
+type EnvoyStats struct {
+	Live          bool                    `json:"live"`
+	UptimeSeconds int64                   `json:"uptimeSeconds"`
+	Clusters      map[string]EnvoyCluster `json:"clusters"`
+}
+
+// EnvoyCluster sums up the statistics of one envoy cluster, that is of one
+// upstream or of the local application
+type EnvoyCluster struct {
+	HealthyEndpoints  int64 `json:"healthyEndpoints"`
+	TotalEndpoints    int64 `json:"totalEndpoints"`
+	ActiveConnections int64 `json:"activeConnections"`
+	ConnectFailures   int64 `json:"connectFailures"`
+	ConnectTimeouts   int64 `json:"connectTimeouts"`
+	Requests          int64 `json:"requests"`
+	Requests5xx       int64 `json:"requests5xx"`
+}
+
+// GetAllocationConnect handles GET /clusters/{cluster}/v1/allocation/{allocID}/connect
+//
+// With ?stats=true, the envoy statistics of every sidecar are read through
+// the allocation exec API, in the sidecar task or in the task given by ?task=.
+// The task must have sh and either wget or curl.
+func (h *Handler) GetAllocationConnect(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	allocID := r.PathValue("allocID")
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	alloc, _, err := client.Allocations().Info(allocID, opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	connect := allocConnect(alloc)
+
+	if r.URL.Query().Get("stats") == "true" {
+		nomadCtx, err := h.configStore.GetContext(clusterName)
+		if err != nil {
+			writeNomadError(w, r, err)
+			return
+		}
+
+		for i := range connect.Sidecars {
+			sidecar := &connect.Sidecars[i]
+
+			task := r.URL.Query().Get("task")
+			if task == "" {
+				task = sidecar.Task
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), compositeCallTimeout)
+			sidecar.Stats, err = h.readEnvoyStats(ctx, nomadCtx, token, alloc.ID, task, sidecar.Service)
+			cancel()
+			if err != nil {
+				sidecar.StatsError = err.Error()
+			}
+		}
+	}
+
+	h.writeCompositeJSON(w, r, connect)
+}
+
+func allocConnect(alloc *api.Allocation) AllocConnect {
+	connect := AllocConnect{
+		AllocID:   alloc.ID,
+		JobID:     alloc.JobID,
+		Namespace: alloc.Namespace,
+		TaskGroup: alloc.TaskGroup,
+		Sidecars:  []SidecarStatus{},
+	}
+	if alloc.Job == nil {
+		return connect
+	}
+
+	tg := alloc.Job.LookupTaskGroup(alloc.TaskGroup)
+	if tg == nil {
+		return connect
+	}
+
+	for _, svc := range tg.Services {
+		if svc == nil || svc.Connect == nil || svc.Connect.SidecarService == nil {
+			continue
+		}
+
+		sidecar := SidecarStatus{
+			Service:   svc.Name,
+			Task:      connectProxyPrefix + svc.Name,
+			Upstreams: []*api.ConsulUpstream{},
+		}
+		if proxy := svc.Connect.SidecarService.Proxy; proxy != nil {
+			sidecar.Upstreams = append(sidecar.Upstreams, proxy.Upstreams...)
+		}
+
+		// Nomad injects the sidecar task when the job is registered
+		if state, ok := alloc.TaskStates[sidecar.Task]; ok && state != nil {
+			sidecar.State = state.State
+			sidecar.Failed = state.Failed
+			sidecar.Restarts = state.Restarts
+			sidecar.StartedAt = state.StartedAt
+			if n := len(state.Events); n > 0 && state.Events[n-1] != nil {
+				event := state.Events[n-1]
+				sidecar.LastEvent = event.Type
+				if event.DisplayMessage != "" {
+					sidecar.LastEvent += ": " + event.DisplayMessage
+				}
+			}
+		}
+
+		if res := alloc.AllocatedResources; res != nil {
+			for _, port := range res.Shared.Ports {
+				if port.Label == sidecar.Task {
+					sidecar.ProxyPort = &port
+					break
+				}
+			}
+		}
+
+		connect.Sidecars = append(connect.Sidecars, sidecar)
+	}
+
+	sort.Slice(connect.Sidecars, func(i, j int) bool { return connect.Sidecars[i].Service < connect.Sidecars[j].Service })
+
+	return connect
+}
+
+// readEnvoyStats queries the envoy admin API of a service's sidecar from a
+// task of the allocation. Nomad gives the admin address of each sidecar to
+// every task of the group, in NOMAD_ENVOY_ADMIN_ADDR_<service>.
+func (h *Handler) readEnvoyStats(ctx context.Context, nomadCtx *nomadconfig.Context, token, allocID, task,
+	service string) (*EnvoyStats, error) {
+	url := fmt.Sprintf(`http://${NOMAD_ENVOY_ADMIN_ADDR_%s}/stats`, envName(service))
+	command := []string{"/bin/sh", "-c", fmt.Sprintf(`wget -q -O - "%[1]s" 2>/dev/null || curl -sf "%[1]s"`, url)}
+	if err := h.execPolicy.Check(command); err != nil {
+		return nil, err
+	}
+
+	conn, err := h.dialExec(ctx, nomadCtx, token, allocID, task, false, command)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.CloseNow()
+
+	var stdout, stderr limitedBuffer
+	stdout.limit = maxEnvoyStatsSize
+	stderr.limit = 64 << 10
+
+	code, err := runExec(ctx, conn, nil, &stdout, &stderr)
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("reading the envoy admin API from task %q exited with code %d: %s",
+			task, code, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseEnvoyStats(stdout.Bytes()), nil
+}
+
+// parseEnvoyStats parses the text output of the envoy /stats admin
+// endpoint, made of "name: value" lines, keeping the statistics of
+// EnvoyStats
+func parseEnvoyStats(raw []byte) *EnvoyStats {
+	stats := &EnvoyStats{Clusters: map[string]EnvoyCluster{}}
+
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), ": ")
+		if !ok {
+			continue
+		}
+		// Histograms are not plain numbers and are left out
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch name {
+		case "server.live":
+			stats.Live = n == 1
+			continue
+		case "server.uptime":
+			stats.UptimeSeconds = n
+			continue
+		}
+
+		match := envoyClusterStatRe.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+
+		// Clusters are named after the upstream service first
+		clusterName, _, _ := strings.Cut(match[1], ".")
+		cluster := stats.Clusters[clusterName]
+		switch match[2] {
+		case "membership_healthy":
+			cluster.HealthyEndpoints = n
+		case "membership_total":
+			cluster.TotalEndpoints = n
+		case "upstream_cx_active":
+			cluster.ActiveConnections = n
+		case "upstream_cx_connect_fail":
+			cluster.ConnectFailures = n
+		case "upstream_cx_connect_timeout":
+			cluster.ConnectTimeouts = n
+		case "upstream_rq_total":
+			cluster.Requests = n
+		case "upstream_rq_5xx":
+			cluster.Requests5xx = n
+		default:
+			continue
+		}
+		stats.Clusters[clusterName] = cluster
+	}
+
+	return stats
+}
+
+// envName turns a service name into the suffix of the environment variables
+// Nomad sets for it
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
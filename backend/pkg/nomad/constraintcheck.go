@@ -0,0 +1,215 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+	"github.com/caravan-nomad/caravan/backend/pkg/placement"
+)
+
+// Outcomes of a constraint check on a node
+const (
+	placementMatch    = "match"
+	placementPartial  = "partial"
+	placementExcluded = "excluded"
+)
+
+// ConstraintCheckRequest holds the placement rules of a task group. Empty
+// datacenters and node pool select all nodes.
+type ConstraintCheckRequest struct {
+	Constraints []*api.Constraint `json:"constraints"`
+	Affinities  []*api.Affinity   `json:"affinities"`
+	Datacenters []string          `json:"datacenters"`
+	NodePool    string            `json:"nodePool"`
+}
+
+// ConstraintCheck tells for each node of the cluster whether it could get
+// an allocation of the group: it is excluded when it fails a constraint or
+// cannot be scheduled on, and a partial match when it misses some of the
+// affinities.
+type ConstraintCheck struct {
+	Matched  int                   `json:"matched"`
+	Partial  int                   `json:"partial"`
+	Excluded int                   `json:"excluded"`
+	Nodes    []NodeConstraintCheck `json:"nodes"`
+	// Errors maps the nodes that could not be read to the error
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// NodeConstraintCheck is the outcome on one node. Score is the affinity
+// score the scheduler would give it, from -1 to 1.
+type NodeConstraintCheck struct {
+	NodeID      string              `json:"nodeId"`
+	Name        string              `json:"name"`
+	Datacenter  string              `json:"datacenter"`
+	NodePool    string              `json:"nodePool"`
+	NodeClass   string              `json:"nodeClass"`
+	Outcome     string              `json:"outcome"`
+	Score       float64             `json:"score"`
+	Reasons     []string            `json:"reasons"`
+	Constraints []RuleCheck         `json:"constraints"`
+	Affinities  []AffinityRuleCheck `json:"affinities"`
+}
+
+// RuleCheck is the outcome of one constraint on a node
+type RuleCheck struct {
+	Rule string `json:"rule"`
+	placement.Result
+}
+
+// AffinityRuleCheck is the outcome of one affinity on a node. Satisfied is
+// whether the node is as the affinity wants, which for negative weights
+// means not matching.
+type AffinityRuleCheck struct {
+	RuleCheck
+	Weight    int8 `json:"weight"`
+	Satisfied bool `json:"satisfied"`
+}
+
+// CheckConstraints handles POST /clusters/{cluster}/v1/utils/constraint-check
+func (h *Handler) CheckConstraints(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+
+	var req ConstraintCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+	for _, c := range req.Constraints {
+		if c == nil {
+			writeError(w, r, fmt.Errorf("constraints must not be null"), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, a := range req.Affinities {
+		if a == nil {
+			writeError(w, r, fmt.Errorf("affinities must not be null"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	stubs, _, err := client.Nodes().List(getQueryOptions(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	// Stubs lack the node meta, so the nodes are read in full
+	opts := getQueryOptions(r)
+	results := fanout.Map(r.Context(), stubs, fanout.Options{Timeout: compositeCallTimeout},
+		func(ctx context.Context, stub *api.NodeListStub) (*api.Node, error) {
+			node, _, err := client.Nodes().Info(stub.ID, opts.WithContext(ctx))
+			return node, err
+		})
+
+	resp := ConstraintCheck{Nodes: []NodeConstraintCheck{}, Errors: map[string]string{}}
+	for i, result := range results {
+		if result.Err != nil {
+			resp.Errors[stubs[i].ID] = result.Err.Error()
+			continue
+		}
+
+		check := checkNode(&req, result.Value)
+		switch check.Outcome {
+		case placementMatch:
+			resp.Matched++
+		case placementPartial:
+			resp.Partial++
+		default:
+			resp.Excluded++
+		}
+		resp.Nodes = append(resp.Nodes, check)
+	}
+
+	sort.Slice(resp.Nodes, func(i, j int) bool { return resp.Nodes[i].Name < resp.Nodes[j].Name })
+
+	writeJSON(w, resp)
+}
+
+func checkNode(req *ConstraintCheckRequest, node *api.Node) NodeConstraintCheck {
+	check := NodeConstraintCheck{
+		NodeID:      node.ID,
+		Name:        node.Name,
+		Datacenter:  node.Datacenter,
+		NodePool:    node.NodePool,
+		NodeClass:   node.NodeClass,
+		Reasons:     []string{},
+		Constraints: []RuleCheck{},
+		Affinities:  []AffinityRuleCheck{},
+	}
+
+	if !matchesDatacenters(req.Datacenters, node.Datacenter) {
+		check.Reasons = append(check.Reasons, fmt.Sprintf("datacenter %q is not one of the group's", node.Datacenter))
+	}
+	if req.NodePool != "" && req.NodePool != api.NodePoolAll && node.NodePool != req.NodePool {
+		check.Reasons = append(check.Reasons, fmt.Sprintf("node pool %q is not %q", node.NodePool, req.NodePool))
+	}
+	switch {
+	case node.Status != api.NodeStatusReady:
+		check.Reasons = append(check.Reasons, fmt.Sprintf("node is %s", node.Status))
+	case node.SchedulingEligibility != api.NodeSchedulingEligible:
+		check.Reasons = append(check.Reasons, "node is ineligible for scheduling")
+	case node.Drain:
+		check.Reasons = append(check.Reasons, "node is draining")
+	}
+
+	for _, c := range req.Constraints {
+		rule := RuleCheck{Rule: placement.Describe(c.LTarget, c.Operand, c.RTarget), Result: placement.Check(c, node)}
+		if !rule.Matched {
+			check.Reasons = append(check.Reasons, fmt.Sprintf("constraint %s: %s", rule.Rule, rule.Reason))
+		}
+		check.Constraints = append(check.Constraints, rule)
+	}
+
+	var total, score float64
+	satisfied := true
+	for _, a := range req.Affinities {
+		weight := int8(50)
+		if a.Weight != nil {
+			weight = *a.Weight
+		}
+
+		rule := AffinityRuleCheck{
+			RuleCheck: RuleCheck{
+				Rule:   placement.Describe(a.LTarget, a.Operand, a.RTarget),
+				Result: placement.CheckAffinity(a, node),
+			},
+			Weight: weight,
+		}
+		rule.Satisfied = rule.Matched == (weight > 0)
+		satisfied = satisfied && rule.Satisfied
+
+		total += math.Abs(float64(weight))
+		if rule.Matched && rule.Evaluated {
+			score += float64(weight)
+		}
+		check.Affinities = append(check.Affinities, rule)
+	}
+	if total > 0 {
+		check.Score = score / total
+	}
+
+	switch {
+	case len(check.Reasons) > 0:
+		check.Outcome = placementExcluded
+	case satisfied:
+		check.Outcome = placementMatch
+	default:
+		check.Outcome = placementPartial
+	}
+
+	return check
+}
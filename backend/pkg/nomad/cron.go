@@ -0,0 +1,79 @@
+package nomad
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/cronexpr"
+	"github.com/hashicorp/nomad/api"
+)
+
+const (
+	defaultCronPreview = 10
+	maxCronPreview     = 100
+)
+
+// CronPreview are the next launch times of a periodic schedule
+type CronPreview struct {
+	Expr     string `json:"expr"`
+	TimeZone string `json:"timeZone"`
+	// Launches are in the schedule's time zone, so their offsets show how
+	// daylight saving time moves them
+	Launches []time.Time `json:"launches"`
+}
+
+// CronNext handles GET /api/utils/cron/next?expr=&tz=&count=10
+// The launches are computed the way Nomad's periodic dispatcher does, each
+// from the previous one, so a schedule can be checked while it is written.
+func (h *Handler) CronNext(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	expr := query.Get("expr")
+	if expr == "" {
+		writeError(w, r, fmt.Errorf("expr is required"), http.StatusBadRequest)
+		return
+	}
+	if _, err := cronexpr.Parse(expr); err != nil {
+		writeError(w, r, fmt.Errorf("invalid cron expression: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	count := defaultCronPreview
+	if v := query.Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxCronPreview {
+			writeError(w, r, fmt.Errorf("count must be between 1 and %d", maxCronPreview), http.StatusBadRequest)
+			return
+		}
+		count = n
+	}
+
+	specType, tz := api.PeriodicSpecCron, query.Get("tz")
+	periodic := &api.PeriodicConfig{Spec: &expr, SpecType: &specType, TimeZone: &tz}
+	location, err := periodic.GetLocation()
+	if err != nil {
+		writeError(w, r, fmt.Errorf("invalid time zone: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	preview := CronPreview{Expr: expr, TimeZone: location.String(), Launches: []time.Time{}}
+
+	from := time.Now().In(location)
+	for len(preview.Launches) < count {
+		next, err := periodic.Next(from)
+		if err != nil {
+			writeError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		// Expressions such as a past year stop launching
+		if next.IsZero() {
+			break
+		}
+		preview.Launches = append(preview.Launches, next.In(location))
+		from = next
+	}
+
+	writeJSON(w, preview)
+}
@@ -0,0 +1,267 @@
+package nomad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+// deploymentHistoryBucket holds one DeploymentRecord per finished deployment
+const deploymentHistoryBucket = "deployment-history"
+
+// DeploymentRecord is the outcome of a finished deployment
+type DeploymentRecord struct {
+	ID                string    `json:"id"`
+	JobID             string    `json:"jobId"`
+	Namespace         string    `json:"namespace"`
+	JobVersion        uint64    `json:"jobVersion"`
+	Status            string    `json:"status"`
+	StatusDescription string    `json:"statusDescription"`
+	Rollback          bool      `json:"rollback"`
+	StartedAt         time.Time `json:"startedAt,omitempty"`
+	FinishedAt        time.Time `json:"finishedAt,omitempty"`
+	DurationSeconds   float64   `json:"durationSeconds"`
+}
+
+// DeploymentHistory aggregates the recorded deployments of a job
+type DeploymentHistory struct {
+	JobID      string `json:"jobId"`
+	Namespace  string `json:"namespace"`
+	Total      int    `json:"total"`
+	Successful int    `json:"successful"`
+	Failed     int    `json:"failed"`
+	Cancelled  int    `json:"cancelled"`
+	Rollbacks  int    `json:"rollbacks"`
+	// SuccessRate is successful / (successful + failed); cancelled
+	// deployments were superseded and say nothing about the change itself
+	SuccessRate           float64            `json:"successRate"`
+	MeanDurationSeconds   float64            `json:"meanDurationSeconds"`
+	MedianDurationSeconds float64            `json:"medianDurationSeconds"`
+	Deployments           []DeploymentRecord `json:"deployments"`
+}
+
+// isFinishedDeployment reports whether a deployment reached a final status
+func isFinishedDeployment(d *api.Deployment) bool {
+	switch d.Status {
+	case api.DeploymentStatusSuccessful, api.DeploymentStatusFailed, api.DeploymentStatusCancelled:
+		return true
+	}
+
+	return false
+}
+
+func newDeploymentRecord(d *api.Deployment) DeploymentRecord {
+	record := DeploymentRecord{
+		ID:                d.ID,
+		JobID:             d.JobID,
+		Namespace:         d.Namespace,
+		JobVersion:        d.JobVersion,
+		Status:            d.Status,
+		StatusDescription: d.StatusDescription,
+		// Nomad marks the failed deployment when it reverts to the last stable version
+		Rollback: strings.Contains(strings.ToLower(d.StatusDescription), "rolling back"),
+	}
+
+	// Older Nomad versions do not report deployment times
+	if d.CreateTime > 0 && d.ModifyTime >= d.CreateTime {
+		record.StartedAt = time.Unix(0, d.CreateTime).UTC()
+		record.FinishedAt = time.Unix(0, d.ModifyTime).UTC()
+		record.DurationSeconds = record.FinishedAt.Sub(record.StartedAt).Seconds()
+	}
+
+	return record
+}
+
+// jobKeyPrefix is the store key prefix of the records kept about a job. The
+// parts are escaped since job IDs of dispatched and periodic jobs contain slashes.
+func jobKeyPrefix(cluster, namespace, jobID string) string {
+	return url.PathEscape(cluster) + "/" + url.PathEscape(namespace) + "/" + url.PathEscape(jobID) + "/"
+}
+
+// recordDeployments stores the finished deployments among deployments
+func (h *Handler) recordDeployments(ctx context.Context, cluster string, deployments []*api.Deployment) error {
+	for _, d := range deployments {
+		if !isFinishedDeployment(d) {
+			continue
+		}
+
+		key := jobKeyPrefix(cluster, d.Namespace, d.JobID) + d.ID
+
+		var existing DeploymentRecord
+		err := store.GetJSON(ctx, h.store, deploymentHistoryBucket, key, &existing)
+		if err == nil && existing.Status == d.Status {
+			continue
+		}
+
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+
+		if err := store.PutJSON(ctx, h.store, deploymentHistoryBucket, key, newDeploymentRecord(d)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TrackDeployments records the finished deployments of all clusters until
+// ctx is done. Deployments are recorded as the event bus reports them
+// finished; every interval, the clusters are polled to catch up on what the
+// streams missed and to follow clusters added since. It uses the token
+// configured for each cluster, so only the deployments that token can read
+// are tracked; the history endpoint records the deployments it sees as well.
+func (h *Handler) TrackDeployments(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	h.followClusters(ctx, interval, func(cluster string) func() {
+		return h.followDeployments(ctx, cluster)
+	}, func(cluster string) {
+		h.pollDeployments(ctx, cluster)
+	})
+}
+
+// followDeployments records the deployments of a cluster as soon as they finish
+func (h *Handler) followDeployments(ctx context.Context, cluster string) (unsubscribe func()) {
+	return h.events.Subscribe(cluster, []api.Topic{api.TopicDeployment}, 0, func(event api.Event) {
+		d, err := event.Deployment()
+		if err == nil && d != nil {
+			err = h.recordDeployments(ctx, cluster, []*api.Deployment{d})
+		}
+
+		if err != nil && ctx.Err() == nil {
+			logger.Log(logger.LevelWarn, map[string]string{"cluster": cluster}, err, "recording deployment history")
+		}
+	})
+}
+
+func (h *Handler) pollDeployments(ctx context.Context, cluster string) {
+	client, err := h.GetClient(cluster)
+	if err == nil {
+		var deployments []*api.Deployment
+
+		deployments, _, err = client.Deployments().List((&api.QueryOptions{Namespace: "*"}).WithContext(ctx))
+		if err == nil {
+			err = h.recordDeployments(ctx, cluster, deployments)
+		}
+	}
+
+	if err != nil && ctx.Err() == nil {
+		logger.Log(logger.LevelWarn, map[string]string{"cluster": cluster}, err, "recording deployment history")
+	}
+}
+
+// GetJobDeploymentHistory handles GET /clusters/{cluster}/v1/job/deployment-history?id=jobID
+// Deployments Nomad still knows about are recorded first, so the history is
+// complete even when background tracking is disabled.
+func (h *Handler) GetJobDeploymentHistory(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = api.DefaultNamespace
+	}
+
+	// A job that was purged has no deployments left in Nomad, but its
+	// history is still worth showing. Any other error, in particular a
+	// permission error, must not fall through to the stored records.
+	deployments, _, err := client.Jobs().Deployments(jobID, true, opts)
+	if err != nil && classifyNomadError(err).Status() != http.StatusNotFound {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	if err := h.recordDeployments(r.Context(), clusterName, deployments); err != nil {
+		writeError(w, r, fmt.Errorf("recording deployments: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	records, err := store.ListJSON[DeploymentRecord](r.Context(), h.store, deploymentHistoryBucket,
+		jobKeyPrefix(clusterName, namespace, jobID))
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, summarizeDeployments(jobID, namespace, records))
+}
+
+func summarizeDeployments(jobID, namespace string, records []DeploymentRecord) DeploymentHistory {
+	history := DeploymentHistory{
+		JobID:       jobID,
+		Namespace:   namespace,
+		Total:       len(records),
+		Deployments: records,
+	}
+
+	var durations []float64
+
+	for _, record := range records {
+		switch record.Status {
+		case api.DeploymentStatusSuccessful:
+			history.Successful++
+		case api.DeploymentStatusFailed:
+			history.Failed++
+		case api.DeploymentStatusCancelled:
+			history.Cancelled++
+		}
+
+		if record.Rollback {
+			history.Rollbacks++
+		}
+
+		if record.DurationSeconds > 0 {
+			durations = append(durations, record.DurationSeconds)
+		}
+	}
+
+	if decided := history.Successful + history.Failed; decided > 0 {
+		history.SuccessRate = float64(history.Successful) / float64(decided)
+	}
+
+	if len(durations) > 0 {
+		sum := 0.0
+		for _, d := range durations {
+			sum += d
+		}
+
+		sort.Float64s(durations)
+
+		history.MeanDurationSeconds = sum / float64(len(durations))
+		history.MedianDurationSeconds = durations[len(durations)/2]
+		if len(durations)%2 == 0 {
+			history.MedianDurationSeconds = (durations[len(durations)/2-1] + durations[len(durations)/2]) / 2
+		}
+	}
+
+	// Newest first, like the deployments tab
+	sort.Slice(history.Deployments, func(i, j int) bool {
+		return history.Deployments[i].JobVersion > history.Deployments[j].JobVersion
+	})
+
+	return history
+}
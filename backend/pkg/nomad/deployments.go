@@ -3,8 +3,16 @@ package nomad
 import (
 	"encoding/json"
 	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
 )
 
+// deploymentProgressWait bounds each blocking query of a progress stream, so
+// that a vanished client is noticed
+const deploymentProgressWait = time.Minute
+
 // ListDeployments handles GET /clusters/{cluster}/v1/deployments
 func (h *Handler) ListDeployments(w http.ResponseWriter, r *http.Request) {
 	clusterName := getClusterName(r)
@@ -12,18 +20,22 @@ func (h *Handler) ListDeployments(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	opts := getQueryOptions(r)
-	deployments, _, err := client.Deployments().List(opts)
+	opts, err := h.listOptions(r)
+	if err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	deployments, meta, err := client.Deployments().List(opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, deployments)
+	writeList(h, w, r, opts, deployments, meta)
 }
 
 // GetDeployment handles GET /clusters/{cluster}/v1/deployment/{deployID}
@@ -34,18 +46,18 @@ func (h *Handler) GetDeployment(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	deployment, _, err := client.Deployments().Info(deployID, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, deployment)
+	writeIndexed(w, r, deployment.ModifyIndex, deployment)
 }
 
 // PromoteDeployment handles POST /clusters/{cluster}/v1/deployment/{deployID}/promote
@@ -56,7 +68,7 @@ func (h *Handler) PromoteDeployment(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -72,7 +84,7 @@ func (h *Handler) PromoteDeployment(w http.ResponseWriter, r *http.Request) {
 	opts := getWriteOptions(r)
 	resp, _, err := client.Deployments().PromoteGroups(deployID, promoteReq.Groups, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -87,14 +99,14 @@ func (h *Handler) FailDeployment(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getWriteOptions(r)
 	resp, _, err := client.Deployments().Fail(deployID, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -109,18 +121,18 @@ func (h *Handler) GetDeploymentAllocations(w http.ResponseWriter, r *http.Reques
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	allocs, _, err := client.Deployments().Allocations(deployID, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, allocs)
+	writeJSONArray(w, allocs)
 }
 
 // PauseDeployment handles POST /clusters/{cluster}/v1/deployment/{deployID}/pause
@@ -131,7 +143,7 @@ func (h *Handler) PauseDeployment(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -146,9 +158,125 @@ func (h *Handler) PauseDeployment(w http.ResponseWriter, r *http.Request) {
 	opts := getWriteOptions(r)
 	resp, _, err := client.Deployments().Pause(deployID, pauseReq.Pause, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	writeJSON(w, resp)
 }
+
+// DeploymentProgress is the state of a deployment sent by its progress stream
+type DeploymentProgress struct {
+	ID                string                         `json:"id"`
+	JobID             string                         `json:"jobId"`
+	Namespace         string                         `json:"namespace"`
+	JobVersion        uint64                         `json:"jobVersion"`
+	Status            string                         `json:"status"`
+	StatusDescription string                         `json:"statusDescription"`
+	Groups            map[string]GroupDeployProgress `json:"groups"`
+	Index             uint64                         `json:"index"`
+}
+
+// GroupDeployProgress counts the allocations of a task group in a deployment
+type GroupDeployProgress struct {
+	DesiredTotal    int  `json:"desiredTotal"`
+	DesiredCanaries int  `json:"desiredCanaries"`
+	PlacedCanaries  int  `json:"placedCanaries"`
+	Placed          int  `json:"placed"`
+	Healthy         int  `json:"healthy"`
+	Unhealthy       int  `json:"unhealthy"`
+	Promoted        bool `json:"promoted"`
+}
+
+func newDeploymentProgress(d *api.Deployment) DeploymentProgress {
+	progress := DeploymentProgress{
+		ID:                d.ID,
+		JobID:             d.JobID,
+		Namespace:         d.Namespace,
+		JobVersion:        d.JobVersion,
+		Status:            d.Status,
+		StatusDescription: d.StatusDescription,
+		Groups:            map[string]GroupDeployProgress{},
+		Index:             d.ModifyIndex,
+	}
+
+	for name, state := range d.TaskGroups {
+		progress.Groups[name] = GroupDeployProgress{
+			DesiredTotal:    state.DesiredTotal,
+			DesiredCanaries: state.DesiredCanaries,
+			PlacedCanaries:  len(state.PlacedCanaries),
+			Placed:          state.PlacedAllocs,
+			Healthy:         state.HealthyAllocs,
+			Unhealthy:       state.UnhealthyAllocs,
+			Promoted:        state.Promoted,
+		}
+	}
+
+	return progress
+}
+
+// StreamDeploymentProgress handles GET /clusters/{cluster}/v1/deployment/{deployID}/watch
+//
+// It sends a "progress" event with the deployment's state and group counts
+// when the stream starts and each time they change, following the deployment
+// with blocking queries. A "done" event with the final state ends the stream
+// once the deployment finished.
+func (h *Handler) StreamDeploymentProgress(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	deployID := r.PathValue("deployID")
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	deployment, meta, err := client.Deployments().Info(deployID, opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	sse, ok := h.newSSEWriter(w, r, "deployment")
+	if !ok {
+		return
+	}
+	defer sse.Close()
+
+	send := func(event string, progress DeploymentProgress) error {
+		data, _ := json.Marshal(progress)
+		return sse.Send(event, "", data)
+	}
+
+	last := newDeploymentProgress(deployment)
+	for {
+		if isFinishedDeployment(deployment) {
+			send("done", last)
+			return
+		}
+		if send("progress", last) != nil {
+			return
+		}
+
+		for reflect.DeepEqual(last, newDeploymentProgress(deployment)) {
+			if r.Context().Err() != nil {
+				return
+			}
+
+			opts := getQueryOptions(r)
+			opts.WaitIndex = meta.LastIndex
+			opts.WaitTime = deploymentProgressWait
+			deployment, meta, err = client.Deployments().Info(deployID, opts.WithContext(r.Context()))
+			if err != nil {
+				if r.Context().Err() == nil {
+					sse.Error(err)
+				}
+				return
+			}
+		}
+
+		last = newDeploymentProgress(deployment)
+	}
+}
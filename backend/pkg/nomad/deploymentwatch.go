@@ -0,0 +1,619 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/eventbridge"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+// deploymentWatchBucket holds the DeploymentWatch of each watched job
+const deploymentWatchBucket = "deployment-watch"
+
+// deploymentWatcherActor is the actor of the audit entries of the watcher
+const deploymentWatcherActor = "deployment-watcher"
+
+// watchDelegateTTL is how long the token the watcher acts with on behalf of
+// a watch's owner lives, should it not be deleted after the action
+const watchDelegateTTL = 5 * time.Minute
+
+// Actions of the deployment watcher
+const (
+	ActionDeploymentPromote = "deployment.promote"
+	ActionDeploymentFail    = "deployment.fail"
+	ActionJobRevert         = "job.revert"
+)
+
+// Types of the events the deployment watcher notifies
+const (
+	watchEventUnhealthy = "DeploymentUnhealthy"
+	watchEventPromoted  = "DeploymentPromoted"
+	watchEventFailed    = "DeploymentFailed"
+	watchEventReverted  = "JobReverted"
+)
+
+// DeploymentWatch is what Caravan does about the deployments of a job while
+// they run, a lightweight progressive delivery layer on top of Nomad's own
+// canaries and auto revert
+type DeploymentWatch struct {
+	JobID     string `json:"jobId"`
+	Namespace string `json:"namespace"`
+	// AutoPromote promotes the canaries of a deployment once all of them have
+	// been healthy for PromoteAfterMinutes
+	AutoPromote         bool `json:"autoPromote"`
+	PromoteAfterMinutes int  `json:"promoteAfterMinutes,omitempty"`
+	// AutoFail fails a deployment as soon as one of its allocations is
+	// unhealthy, and reverts the job to its last stable version unless Nomad
+	// reverts it itself
+	AutoFail bool `json:"autoFail"`
+	// Notify is sent the unhealthy deployments and every action taken
+	Notify    *eventbridge.SinkConfig `json:"notify,omitempty"`
+	UpdatedBy string                  `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time               `json:"updatedAt"`
+	// Owner is the accessor ID of the token that configured the watch, whose
+	// rights the watcher acts with. Clusters without ACLs have none.
+	Owner string `json:"owner,omitempty"`
+}
+
+// deploymentWatcher remembers, for the deployments in progress, since when
+// their canaries are healthy and what was already done about them
+type deploymentWatcher struct {
+	mu           sync.Mutex
+	healthySince map[string]time.Time
+	done         map[string]struct{}
+}
+
+func newDeploymentWatcher() *deploymentWatcher {
+	return &deploymentWatcher{
+		healthySince: make(map[string]time.Time),
+		done:         make(map[string]struct{}),
+	}
+}
+
+// once reports whether what is identified by key was not done yet, and marks
+// it as done
+func (w *deploymentWatcher) once(key string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.done[key]; ok {
+		return false
+	}
+
+	w.done[key] = struct{}{}
+
+	return true
+}
+
+// healthyFor returns for how long the canaries of a deployment are healthy,
+// restarting the count when they are not
+func (w *deploymentWatcher) healthyFor(deploymentID string, healthy bool) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !healthy {
+		delete(w.healthySince, deploymentID)
+		return 0
+	}
+
+	since, ok := w.healthySince[deploymentID]
+	if !ok {
+		since = time.Now()
+		w.healthySince[deploymentID] = since
+	}
+
+	return time.Since(since)
+}
+
+// forget drops what is remembered of a finished deployment
+func (w *deploymentWatcher) forget(deploymentID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.healthySince, deploymentID)
+	for key := range w.done {
+		if strings.HasPrefix(key, deploymentID+"/") {
+			delete(w.done, key)
+		}
+	}
+}
+
+func deploymentWatchKey(cluster, namespace, jobID string) string {
+	return jobKeyPrefix(cluster, namespace, jobID)
+}
+
+// WatchDeployments acts on the deployments of the watched jobs of all
+// clusters until ctx is done. Deployments are checked as the event bus
+// reports changes to them, and every interval, which is when canaries that
+// became healthy earlier get promoted. The watcher reads with the token
+// configured for each cluster, and acts with the rights of each watch's
+// owner; like requests, it does nothing in dry-run mode or during a freeze.
+func (h *Handler) WatchDeployments(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	h.followClusters(ctx, interval, func(cluster string) func() {
+		return h.events.Subscribe(cluster, []api.Topic{api.TopicDeployment}, 0, func(event api.Event) {
+			if d, err := event.Deployment(); err == nil && d != nil {
+				h.checkWatchedDeployment(ctx, cluster, d)
+			}
+		})
+	}, func(cluster string) {
+		h.pollWatchedDeployments(ctx, cluster)
+	})
+}
+
+func (h *Handler) pollWatchedDeployments(ctx context.Context, cluster string) {
+	watches, err := store.ListJSON[DeploymentWatch](ctx, h.store, deploymentWatchBucket, url.PathEscape(cluster)+"/")
+	if err != nil {
+		logger.Log(logger.LevelWarn, map[string]string{"cluster": cluster}, err, "listing deployment watches")
+		return
+	}
+
+	if len(watches) == 0 {
+		return
+	}
+
+	client, err := h.GetClient(cluster)
+	if err != nil {
+		return
+	}
+
+	for _, watch := range watches {
+		d, _, err := client.Jobs().LatestDeployment(watch.JobID,
+			(&api.QueryOptions{Namespace: watch.Namespace}).WithContext(ctx))
+		if err != nil || d == nil {
+			continue
+		}
+
+		h.actOnDeployment(ctx, cluster, client, watch, d)
+	}
+}
+
+// checkWatchedDeployment acts on a deployment the event bus reported, if its
+// job is watched
+func (h *Handler) checkWatchedDeployment(ctx context.Context, cluster string, d *api.Deployment) {
+	var watch DeploymentWatch
+
+	err := store.GetJSON(ctx, h.store, deploymentWatchBucket, deploymentWatchKey(cluster, d.Namespace, d.JobID), &watch)
+	if errors.Is(err, store.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		logger.Log(logger.LevelWarn, map[string]string{"cluster": cluster}, err, "reading deployment watch")
+		return
+	}
+
+	client, err := h.GetClient(cluster)
+	if err != nil {
+		return
+	}
+
+	h.actOnDeployment(ctx, cluster, client, watch, d)
+}
+
+// actOnDeployment takes the actions a watch configures for the current
+// state of a deployment
+func (h *Handler) actOnDeployment(ctx context.Context, cluster string, client NomadAPI, watch DeploymentWatch,
+	d *api.Deployment,
+) {
+	if d.Status != api.DeploymentStatusRunning {
+		h.deploymentWatcher.forget(d.ID)
+		return
+	}
+
+	unhealthy := 0
+	var toPromote []string
+	canariesHealthy := true
+
+	for group, state := range d.TaskGroups {
+		unhealthy += state.UnhealthyAllocs
+
+		if state.DesiredCanaries > 0 && !state.Promoted {
+			toPromote = append(toPromote, group)
+			canariesHealthy = canariesHealthy && state.HealthyAllocs >= state.DesiredCanaries
+		}
+	}
+	sort.Strings(toPromote)
+
+	if unhealthy > 0 {
+		if h.deploymentWatcher.once(d.ID + "/unhealthy") {
+			h.notifyWatch(ctx, cluster, watch, d, watchEventUnhealthy,
+				fmt.Sprintf("%d allocations are unhealthy", unhealthy))
+		}
+
+		if !watch.AutoFail || h.watchHeldBack(ctx, cluster, watch, d, ActionDeploymentFail, watchEventFailed) ||
+			!h.deploymentWatcher.once(d.ID+"/fail") {
+			return
+		}
+
+		owner, release, err := h.watchOwnerClient(ctx, cluster, client, watch)
+		if err != nil {
+			h.recordWatchAction(ctx, cluster, watch, d, ActionDeploymentFail, watchEventFailed, "", err)
+			return
+		}
+		defer release()
+
+		h.failWatchedDeployment(ctx, cluster, owner, watch, d)
+
+		return
+	}
+
+	if !watch.AutoPromote || len(toPromote) == 0 {
+		return
+	}
+
+	wait := time.Duration(watch.PromoteAfterMinutes) * time.Minute
+	if h.deploymentWatcher.healthyFor(d.ID, canariesHealthy) < wait || !canariesHealthy {
+		return
+	}
+
+	if h.watchHeldBack(ctx, cluster, watch, d, ActionDeploymentPromote, watchEventPromoted) ||
+		!h.deploymentWatcher.once(d.ID+"/promote") {
+		return
+	}
+
+	owner, release, err := h.watchOwnerClient(ctx, cluster, client, watch)
+	if err != nil {
+		h.recordWatchAction(ctx, cluster, watch, d, ActionDeploymentPromote, watchEventPromoted, "", err)
+		return
+	}
+	defer release()
+
+	_, _, err = owner.Deployments().PromoteGroups(d.ID, toPromote,
+		(&api.WriteOptions{Namespace: d.Namespace}).WithContext(ctx))
+	h.recordWatchAction(ctx, cluster, watch, d, ActionDeploymentPromote, watchEventPromoted,
+		"promoted the canaries of "+strings.Join(toPromote, ", "), err)
+}
+
+// watchHeldBack reports whether dry-run mode or a freeze window keeps the
+// watcher from taking an action on a deployment now. The action is recorded
+// once as refused, and taken once nothing holds it back any more.
+func (h *Handler) watchHeldBack(ctx context.Context, cluster string, watch DeploymentWatch, d *api.Deployment,
+	action, eventType string,
+) bool {
+	var err error
+	if h.DryRun() {
+		err = errors.New("dry-run mode: the action was not taken")
+	} else if active := h.activeFreeze(cluster, d.Namespace, ""); len(active) > 0 {
+		err = errors.New("changes are frozen: " + active[0].Name)
+	}
+
+	if err == nil {
+		return false
+	}
+
+	if h.deploymentWatcher.once(d.ID + "/" + action + "/held") {
+		h.recordWatchAction(ctx, cluster, watch, d, action, eventType, "", err)
+	}
+
+	return true
+}
+
+// watchOwnerClient returns the client to act on a watched job with, which
+// has the rights the watch's owner has now: a watch ends with its owner's
+// token, and does no more than they may. Unless the owner holds a
+// management token, that is a short-lived token with the owner's policies
+// and roles, which release deletes.
+func (h *Handler) watchOwnerClient(ctx context.Context, cluster string, client NomadAPI, watch DeploymentWatch,
+) (NomadAPI, func(), error) {
+	nomadCtx, err := h.configStore.GetContext(cluster)
+	if err != nil {
+		return nil, nil, err
+	}
+	if nomadCtx.ACLDisabled {
+		return client, func() {}, nil
+	}
+
+	if watch.Owner == "" {
+		// Only watches of clusters without ACLs are saved without an owner
+		if _, _, err := client.ACLTokens().Self((&api.QueryOptions{}).WithContext(ctx)); err == nil {
+			return nil, nil, errors.New("the watch has no owner, it must be saved again")
+		}
+		return client, func() {}, nil
+	}
+
+	owner, _, err := client.ACLTokens().Info(watch.Owner, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, nil, fmt.Errorf("looking up the token of the watch's owner: %w", err)
+	}
+	if owner.ExpirationTime != nil && !time.Now().Before(*owner.ExpirationTime) {
+		return nil, nil, errors.New("the token of the watch's owner has expired")
+	}
+	if owner.Type == "management" {
+		return client, func() {}, nil
+	}
+
+	delegate, _, err := client.ACLTokens().Create(&api.ACLToken{
+		Name:          "Caravan deployment watch of " + actorName(owner),
+		Type:          "client",
+		Policies:      owner.Policies,
+		Roles:         owner.Roles,
+		ExpirationTTL: watchDelegateTTL,
+	}, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating a token with the rights of the watch's owner: %w", err)
+	}
+
+	release := func() {
+		if _, err := client.ACLTokens().Delete(delegate.AccessorID, nil); err != nil {
+			logger.Log(logger.LevelWarn, map[string]string{"cluster": cluster}, err, "deleting deployment watch token")
+		}
+	}
+
+	delegated, err := h.GetClientWithToken(cluster, delegate.SecretID)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return delegated, release, nil
+}
+
+// failWatchedDeployment fails a deployment and reverts its job to the last
+// stable version, unless one of its groups has Nomad revert it
+func (h *Handler) failWatchedDeployment(ctx context.Context, cluster string, client NomadAPI, watch DeploymentWatch,
+	d *api.Deployment,
+) {
+	opts := (&api.WriteOptions{Namespace: d.Namespace}).WithContext(ctx)
+
+	_, _, err := client.Deployments().Fail(d.ID, opts)
+	h.recordWatchAction(ctx, cluster, watch, d, ActionDeploymentFail, watchEventFailed,
+		"failed the deployment of version "+fmt.Sprint(d.JobVersion), err)
+	if err != nil {
+		return
+	}
+
+	for _, state := range d.TaskGroups {
+		if state.AutoRevert {
+			return
+		}
+	}
+
+	versions, _, _, err := client.Jobs().Versions(d.JobID, false,
+		(&api.QueryOptions{Namespace: d.Namespace}).WithContext(ctx))
+	if err != nil {
+		h.recordWatchAction(ctx, cluster, watch, d, ActionJobRevert, watchEventReverted, "", err)
+		return
+	}
+
+	var stable *api.Job
+	for _, v := range versions {
+		if v.Version == nil || *v.Version >= d.JobVersion || v.Stable == nil || !*v.Stable {
+			continue
+		}
+		if stable == nil || *v.Version > *stable.Version {
+			stable = v
+		}
+	}
+
+	if stable == nil {
+		h.recordWatchAction(ctx, cluster, watch, d, ActionJobRevert, watchEventReverted, "",
+			errors.New("no stable version to revert to"))
+		return
+	}
+
+	// Reverting only if the job is still at the failed version leaves newer
+	// versions alone
+	_, _, err = client.Jobs().Revert(d.JobID, *stable.Version, &d.JobVersion, opts, "", "")
+	h.recordWatchAction(ctx, cluster, watch, d, ActionJobRevert, watchEventReverted,
+		fmt.Sprintf("reverted to version %d", *stable.Version), err)
+}
+
+// recordWatchAction audits an action of the watcher and notifies it
+func (h *Handler) recordWatchAction(ctx context.Context, cluster string, watch DeploymentWatch, d *api.Deployment,
+	action, eventType, message string, err error,
+) {
+	entry := AuditEntry{
+		Cluster:   cluster,
+		Action:    action,
+		Namespace: d.Namespace,
+		Target:    d.JobID,
+		Actor:     deploymentWatcherActor,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		message = err.Error()
+		eventType += "Error"
+	}
+
+	h.audit(ctx, entry)
+	h.notifyWatch(ctx, cluster, watch, d, eventType, message)
+}
+
+// notifyWatch sends an event about a deployment to the sink of a watch
+func (h *Handler) notifyWatch(ctx context.Context, cluster string, watch DeploymentWatch, d *api.Deployment,
+	eventType, message string,
+) {
+	if watch.Notify == nil {
+		return
+	}
+
+	fields := map[string]string{"cluster": cluster, "job": d.JobID, "deployment": d.ID}
+
+	sink, err := eventbridge.NewSink(*watch.Notify)
+	if err != nil {
+		logger.Log(logger.LevelWarn, fields, err, "creating deployment watch sink")
+		return
+	}
+	defer sink.Close()
+
+	event := eventbridge.Event{
+		Cluster: cluster,
+		Topic:   string(api.TopicDeployment),
+		Type:    eventType,
+		Key:     d.ID,
+		Index:   d.ModifyIndex,
+		Payload: map[string]interface{}{
+			"JobID":      d.JobID,
+			"Namespace":  d.Namespace,
+			"JobVersion": d.JobVersion,
+			"Message":    message,
+		},
+	}
+
+	if err := sink.Send(ctx, []eventbridge.Event{event}); err != nil {
+		logger.Log(logger.LevelWarn, fields, err, "notifying deployment watch")
+	}
+}
+
+// watchedJob returns the job and namespace a deployment watch request is about
+func watchedJob(r *http.Request) (jobID, namespace string, err error) {
+	jobID = r.URL.Query().Get("id")
+	if jobID == "" {
+		return "", "", errors.New("job id is required")
+	}
+
+	return jobID, namespaceOrDefault(r.URL.Query().Get("namespace")), nil
+}
+
+// GetDeploymentWatch handles GET /clusters/{cluster}/v1/job/deployment-watch?id=jobID
+func (h *Handler) GetDeploymentWatch(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	jobID, namespace, err := watchedJob(r)
+	if err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, getToken(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	// The settings are only shown to those who can read the job
+	if _, _, err := client.Jobs().Info(jobID, &api.QueryOptions{Namespace: namespace}); err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	var watch DeploymentWatch
+	err = store.GetJSON(r.Context(), h.store, deploymentWatchBucket, deploymentWatchKey(clusterName, namespace, jobID),
+		&watch)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, r, fmt.Errorf("job %q is not watched", jobID), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, watch)
+}
+
+// PutDeploymentWatch handles PUT /clusters/{cluster}/v1/job/deployment-watch?id=jobID
+// Only a token that may submit the job may configure it; that is checked by
+// planning the job. The token becomes the owner of the watch, whose rights
+// the watcher acts with.
+func (h *Handler) PutDeploymentWatch(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	jobID, namespace, err := watchedJob(r)
+	if err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	var watch DeploymentWatch
+	if err := json.NewDecoder(r.Body).Decode(&watch); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if watch.PromoteAfterMinutes < 0 {
+		writeError(w, r, errors.New("promoteAfterMinutes must not be negative"), http.StatusBadRequest)
+		return
+	}
+
+	if watch.Notify != nil {
+		sink, err := eventbridge.NewSink(*watch.Notify)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("invalid notify sink: %w", err), http.StatusBadRequest)
+			return
+		}
+		sink.Close()
+	}
+
+	client, err := h.GetClientWithToken(clusterName, getToken(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	job, _, err := client.Jobs().Info(jobID, &api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	if _, _, err := client.Jobs().Plan(job, false, &api.WriteOptions{Namespace: namespace}); err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	watch.JobID = jobID
+	watch.Namespace = namespace
+	watch.UpdatedBy = deployerName(client)
+	watch.UpdatedAt = time.Now().UTC()
+	watch.Owner = ""
+	if self, _, err := client.ACLTokens().Self(nil); err == nil && self != nil {
+		watch.Owner = self.AccessorID
+	}
+
+	err = store.PutJSON(r.Context(), h.store, deploymentWatchBucket, deploymentWatchKey(clusterName, namespace, jobID),
+		watch)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, watch)
+}
+
+// DeleteDeploymentWatch handles DELETE /clusters/{cluster}/v1/job/deployment-watch?id=jobID
+func (h *Handler) DeleteDeploymentWatch(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	jobID, namespace, err := watchedJob(r)
+	if err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, getToken(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	job, _, err := client.Jobs().Info(jobID, &api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	if _, _, err := client.Jobs().Plan(job, false, &api.WriteOptions{Namespace: namespace}); err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	err = h.store.Delete(r.Context(), deploymentWatchBucket, deploymentWatchKey(clusterName, namespace, jobID))
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
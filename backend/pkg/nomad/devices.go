@@ -0,0 +1,223 @@
+package nomad
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+)
+
+// DeviceInventory lists the devices, such as GPUs, fingerprinted on the
+// nodes of a cluster and how many of them allocations hold
+type DeviceInventory struct {
+	// Totals sums the devices of all nodes by vendor, type and name
+	Totals []DeviceCount `json:"totals"`
+	Nodes  []NodeDevices `json:"nodes"`
+	// Errors maps the nodes whose allocations could not be read to the
+	// error; their devices are counted as free
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// DeviceCount counts the instances of a device. Free instances are healthy
+// and not held by an allocation.
+type DeviceCount struct {
+	Vendor    string `json:"vendor"`
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Total     int    `json:"total"`
+	Healthy   int    `json:"healthy"`
+	Allocated int    `json:"allocated"`
+	Free      int    `json:"free"`
+}
+
+// NodeDevices are the devices of one node
+type NodeDevices struct {
+	NodeID     string       `json:"nodeId"`
+	Name       string       `json:"name"`
+	Datacenter string       `json:"datacenter"`
+	NodePool   string       `json:"nodePool"`
+	NodeClass  string       `json:"nodeClass"`
+	Status     string       `json:"status"`
+	Devices    []NodeDevice `json:"devices"`
+}
+
+// NodeDevice is a device of a node with its instances
+type NodeDevice struct {
+	DeviceCount
+	Attributes map[string]string `json:"attributes"`
+	Instances  []DeviceInstance  `json:"instances"`
+}
+
+// DeviceInstance is one instance of a device and the allocation holding it
+type DeviceInstance struct {
+	ID                string `json:"id"`
+	Healthy           bool   `json:"healthy"`
+	HealthDescription string `json:"healthDescription,omitempty"`
+	AllocID           string `json:"allocId,omitempty"`
+	JobID             string `json:"jobId,omitempty"`
+	Namespace         string `json:"namespace,omitempty"`
+	Task              string `json:"task,omitempty"`
+}
+
+// deviceHolder is the allocation and task holding a device instance
+type deviceHolder struct {
+	alloc *api.Allocation
+	task  string
+}
+
+// GetDevices handles GET /clusters/{cluster}/devices
+func (h *Handler) GetDevices(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	// Nodes are listed without their resources unless asked for
+	listOpts := getQueryOptions(r)
+	if listOpts.Params == nil {
+		listOpts.Params = map[string]string{}
+	}
+	listOpts.Params["resources"] = "true"
+
+	stubs, _, err := client.Nodes().List(listOpts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	var withDevices []*api.NodeListStub
+	for _, stub := range stubs {
+		if stub.NodeResources != nil && len(stub.NodeResources.Devices) > 0 {
+			withDevices = append(withDevices, stub)
+		}
+	}
+
+	results := fanout.Map(r.Context(), withDevices, fanout.Options{Timeout: compositeCallTimeout},
+		func(ctx context.Context, stub *api.NodeListStub) ([]*api.Allocation, error) {
+			allocs, _, err := client.Nodes().Allocations(stub.ID, opts.WithContext(ctx))
+			return allocs, err
+		})
+
+	inventory := DeviceInventory{Totals: []DeviceCount{}, Nodes: []NodeDevices{}, Errors: map[string]string{}}
+	totals := map[[3]string]*DeviceCount{}
+
+	for i, result := range results {
+		stub := withDevices[i]
+		if result.Err != nil {
+			inventory.Errors[stub.ID] = result.Err.Error()
+		}
+
+		node := nodeDevices(stub, deviceHolders(result.Value))
+		for _, device := range node.Devices {
+			key := [3]string{device.Vendor, device.Type, device.Name}
+			total, ok := totals[key]
+			if !ok {
+				total = &DeviceCount{Vendor: device.Vendor, Type: device.Type, Name: device.Name}
+				totals[key] = total
+			}
+
+			total.Total += device.Total
+			total.Healthy += device.Healthy
+			total.Allocated += device.Allocated
+			total.Free += device.Free
+		}
+		inventory.Nodes = append(inventory.Nodes, node)
+	}
+
+	for _, total := range totals {
+		inventory.Totals = append(inventory.Totals, *total)
+	}
+	sort.Slice(inventory.Totals, func(i, j int) bool {
+		a, b := inventory.Totals[i], inventory.Totals[j]
+		return a.Vendor+"/"+a.Type+"/"+a.Name < b.Vendor+"/"+b.Type+"/"+b.Name
+	})
+	sort.Slice(inventory.Nodes, func(i, j int) bool { return inventory.Nodes[i].Name < inventory.Nodes[j].Name })
+
+	h.writeCompositeJSON(w, r, inventory)
+}
+
+// deviceHolders maps the device instances held by allocations that still
+// run, or are about to, to their holder
+func deviceHolders(allocs []*api.Allocation) map[string]deviceHolder {
+	holders := map[string]deviceHolder{}
+
+	for _, alloc := range allocs {
+		if alloc.AllocatedResources == nil || alloc.DesiredStatus != api.AllocDesiredStatusRun {
+			continue
+		}
+		if alloc.ClientStatus != api.AllocClientStatusRunning && alloc.ClientStatus != api.AllocClientStatusPending {
+			continue
+		}
+
+		for task, resources := range alloc.AllocatedResources.Tasks {
+			for _, device := range resources.Devices {
+				for _, id := range device.DeviceIDs {
+					holders[id] = deviceHolder{alloc: alloc, task: task}
+				}
+			}
+		}
+	}
+
+	return holders
+}
+
+func nodeDevices(stub *api.NodeListStub, holders map[string]deviceHolder) NodeDevices {
+	node := NodeDevices{
+		NodeID:     stub.ID,
+		Name:       stub.Name,
+		Datacenter: stub.Datacenter,
+		NodePool:   stub.NodePool,
+		NodeClass:  stub.NodeClass,
+		Status:     stub.Status,
+		Devices:    []NodeDevice{},
+	}
+
+	for _, group := range stub.NodeResources.Devices {
+		device := NodeDevice{
+			DeviceCount: DeviceCount{Vendor: group.Vendor, Type: group.Type, Name: group.Name},
+			Attributes:  map[string]string{},
+			Instances:   []DeviceInstance{},
+		}
+		for name, attr := range group.Attributes {
+			if attr != nil {
+				device.Attributes[name] = attr.String()
+			}
+		}
+
+		for _, instance := range group.Instances {
+			di := DeviceInstance{
+				ID:                instance.ID,
+				Healthy:           instance.Healthy,
+				HealthDescription: instance.HealthDescription,
+			}
+
+			device.Total++
+			if instance.Healthy {
+				device.Healthy++
+			}
+
+			if holder, ok := holders[instance.ID]; ok {
+				di.AllocID = holder.alloc.ID
+				di.JobID = holder.alloc.JobID
+				di.Namespace = holder.alloc.Namespace
+				di.Task = holder.task
+				device.Allocated++
+			} else if instance.Healthy {
+				device.Free++
+			}
+
+			device.Instances = append(device.Instances, di)
+		}
+
+		node.Devices = append(node.Devices, device)
+	}
+
+	return node
+}
@@ -0,0 +1,217 @@
+package nomad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+const (
+	// jobDispatchesBucket holds one JobDispatch per dispatched job, so the
+	// history of a parameterized job outlives Nomad's garbage collection
+	jobDispatchesBucket = "job-dispatches"
+	// dispatchLookups bounds how many children dispatched outside Caravan are
+	// read from Nomad per request, for their meta and payload
+	dispatchLookups = 20
+)
+
+// JobDispatches is the dispatch history of a parameterized job
+type JobDispatches struct {
+	JobID     string `json:"jobId"`
+	Namespace string `json:"namespace"`
+	// Dispatches are sorted newest first
+	Dispatches []JobDispatch `json:"dispatches"`
+}
+
+// JobDispatch is one dispatched job
+type JobDispatch struct {
+	ID string `json:"id"`
+	// Status is the status of the dispatched job, empty once Nomad garbage
+	// collected it
+	Status       string    `json:"status"`
+	DispatchedAt time.Time `json:"dispatchedAt"`
+	// DispatchedBy is the name of the token that dispatched through
+	// Caravan, empty for dispatches made elsewhere or without ACLs
+	DispatchedBy string `json:"dispatchedBy,omitempty"`
+	ViaCaravan   bool   `json:"viaCaravan"`
+	// Meta are the values of the parameterized job's meta keys
+	Meta        map[string]string `json:"meta,omitempty"`
+	PayloadSize int               `json:"payloadSize"`
+	// Details is false for jobs dispatched elsewhere whose meta and payload
+	// were not read yet
+	Details bool `json:"details"`
+}
+
+func dispatchKey(cluster, namespace, parentID, jobID string) string {
+	return jobKeyPrefix(cluster, namespace, parentID) + url.PathEscape(jobID)
+}
+
+// recordDispatch stores a dispatch made through Caravan. Retries with an
+// idempotency token return the job dispatched first, whose record is kept.
+func (h *Handler) recordDispatch(ctx context.Context, cluster, namespace, parentID string, client NomadAPI,
+	req DispatchRequest, resp *api.JobDispatchResponse,
+) error {
+	key := dispatchKey(cluster, namespace, parentID, resp.DispatchedJobID)
+
+	var existing JobDispatch
+	err := store.GetJSON(ctx, h.store, jobDispatchesBucket, key, &existing)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+
+	d := JobDispatch{
+		ID:           resp.DispatchedJobID,
+		DispatchedAt: time.Now().UTC(),
+		ViaCaravan:   true,
+		Meta:         req.Meta,
+		PayloadSize:  len(req.Payload),
+		Details:      true,
+	}
+	if _, name, err := tokenIdentity(client); err == nil {
+		d.DispatchedBy = name
+	}
+
+	return store.PutJSON(ctx, h.store, jobDispatchesBucket, key, d)
+}
+
+// GetJobDispatches handles GET /clusters/{cluster}/v1/job/dispatches?id=jobID
+// It merges the dispatches recorded by Caravan with the children Nomad
+// lists, which includes those dispatched with the CLI or the API.
+func (h *Handler) GetJobDispatches(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	job, _, err := client.Jobs().Info(jobID, getQueryOptions(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+	if !job.IsParameterized() {
+		writeError(w, r, fmt.Errorf("job %q is not parameterized", jobID), http.StatusBadRequest)
+		return
+	}
+
+	namespace := namespaceOrDefault(stringValue(job.Namespace))
+
+	recorded, err := store.ListJSON[JobDispatch](r.Context(), h.store, jobDispatchesBucket,
+		jobKeyPrefix(clusterName, namespace, *job.ID))
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	byID := make(map[string]*JobDispatch, len(recorded))
+	for i := range recorded {
+		recorded[i].Status = ""
+		byID[recorded[i].ID] = &recorded[i]
+	}
+
+	listOpts := getQueryOptions(r)
+	listOpts.Namespace = namespace
+	listOpts.Prefix = *job.ID + "/"
+	stubs, _, err := client.Jobs().List(listOpts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	var discovered []*JobDispatch
+	for _, stub := range stubs {
+		if stub.ParentID != *job.ID {
+			continue
+		}
+
+		d, ok := byID[stub.ID]
+		if !ok {
+			d = &JobDispatch{ID: stub.ID, DispatchedAt: time.Unix(0, stub.SubmitTime).UTC()}
+			byID[stub.ID] = d
+		}
+		d.Status = stub.Status
+		if !d.Details {
+			discovered = append(discovered, d)
+		}
+	}
+
+	h.lookupDispatches(r.Context(), clusterName, namespace, job, client, discovered)
+
+	resp := JobDispatches{JobID: *job.ID, Namespace: namespace, Dispatches: make([]JobDispatch, 0, len(byID))}
+	for _, d := range byID {
+		resp.Dispatches = append(resp.Dispatches, *d)
+	}
+	sort.Slice(resp.Dispatches, func(i, j int) bool {
+		a, b := resp.Dispatches[i], resp.Dispatches[j]
+		if !a.DispatchedAt.Equal(b.DispatchedAt) {
+			return a.DispatchedAt.After(b.DispatchedAt)
+		}
+		return a.ID > b.ID
+	})
+
+	h.writeCompositeJSON(w, r, resp)
+}
+
+// lookupDispatches reads the meta and payload of the newest jobs dispatched
+// outside Caravan, and records them so they are read once
+func (h *Handler) lookupDispatches(ctx context.Context, cluster, namespace string, parent *api.Job,
+	client NomadAPI, dispatches []*JobDispatch,
+) {
+	sort.Slice(dispatches, func(i, j int) bool { return dispatches[i].DispatchedAt.After(dispatches[j].DispatchedAt) })
+	if len(dispatches) > dispatchLookups {
+		dispatches = dispatches[:dispatchLookups]
+	}
+
+	keys := append(slices.Clone(parent.ParameterizedJob.MetaRequired), parent.ParameterizedJob.MetaOptional...)
+
+	g := fanout.New(ctx, fanout.Options{Timeout: compositeCallTimeout})
+	for _, d := range dispatches {
+		g.Go(d.ID, func(ctx context.Context) error {
+			child, _, err := client.Jobs().Info(d.ID, (&api.QueryOptions{Namespace: namespace}).WithContext(ctx))
+			if err != nil {
+				return err
+			}
+
+			for _, k := range keys {
+				if v, ok := child.Meta[k]; ok {
+					if d.Meta == nil {
+						d.Meta = map[string]string{}
+					}
+					d.Meta[k] = v
+				}
+			}
+			d.PayloadSize = len(child.Payload)
+			d.Details = true
+
+			record := *d
+			record.Status = ""
+			return store.PutJSON(ctx, h.store, jobDispatchesBucket, dispatchKey(cluster, namespace, *parent.ID, d.ID), record)
+		})
+	}
+
+	if errs := g.Wait(); len(errs) > 0 {
+		logger.Log(logger.LevelWarn, map[string]string{"cluster": cluster, "job": *parent.ID},
+			fmt.Errorf("%d lookups failed", len(errs)), "reading dispatched jobs")
+	}
+}
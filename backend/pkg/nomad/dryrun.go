@@ -0,0 +1,219 @@
+package nomad
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+)
+
+// dryRunMaxBody caps the request body the dry-run mode reads to describe a request
+const dryRunMaxBody = 10 << 20
+
+// DryRunResult describes what a mutating request would have done
+type DryRunResult struct {
+	DryRun bool   `json:"dryRun"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Query and Body echo the request as it would have been sent
+	Query map[string][]string `json:"query,omitempty"`
+	Body  json.RawMessage     `json:"body,omitempty"`
+	// Plan is the scheduler's plan for job registrations and scaling
+	Plan    *api.JobPlanResponse `json:"plan,omitempty"`
+	Message string               `json:"message"`
+}
+
+// WithDryRun turns on dry-run mode, in which no write is sent to Nomad
+func WithDryRun(enabled bool) Option {
+	return func(h *Handler) {
+		h.dryRun = enabled
+	}
+}
+
+// DryRun reports whether the handler runs in dry-run mode
+func (h *Handler) DryRun() bool {
+	return h.dryRun
+}
+
+// DryRunMiddleware answers mutating requests with a description of what they
+// would have done instead of passing them on. Job registrations and scaling
+// are planned by the Nomad scheduler; other requests have their input
+// validated. Approving a pending destructive action is answered the same way,
+// and so are exec sessions and job actions, which run commands although
+// they are GETs.
+func (h *Handler) DryRunMiddleware(next http.Handler) http.Handler {
+	if !h.dryRun {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cluster, rest, ok := splitClusterPath(r.URL.Path)
+		if ok && r.Method == http.MethodGet && isCommand(rest) {
+			logger.Log(logger.LevelInfo, map[string]string{
+				"cluster": cluster,
+				"path":    r.URL.Path,
+			}, nil, "dry-run command")
+
+			writeDryRunCommand(w, r)
+			return
+		}
+
+		if !isMutating(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if ok && isLocalWrite(rest) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !ok && !isApproval(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, dryRunMaxBody))
+		if err != nil {
+			writeError(w, r, fmt.Errorf("reading request body: %w", err), http.StatusBadRequest)
+			return
+		}
+
+		if len(bytes.TrimSpace(body)) > 0 && !json.Valid(body) {
+			writeError(w, r, errors.New("request body is not valid JSON"), http.StatusBadRequest)
+			return
+		}
+
+		result := &DryRunResult{
+			DryRun:  true,
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Query:   r.URL.Query(),
+			Message: "dry-run mode: the request was validated but not sent to Nomad",
+		}
+		if len(bytes.TrimSpace(body)) > 0 {
+			result.Body = body
+		}
+
+		if ok {
+			if err := h.planDryRun(r, cluster, rest, body, result); err != nil {
+				apierror.Write(w, r, classifyNomadError(err).WithCluster(cluster))
+				return
+			}
+		}
+
+		logger.Log(logger.LevelInfo, map[string]string{
+			"cluster": cluster,
+			"method":  r.Method,
+			"path":    r.URL.Path,
+		}, nil, "dry-run request")
+
+		w.Header().Set("X-Caravan-Dry-Run", "true")
+		writeJSON(w, result)
+	})
+}
+
+// planDryRun validates the request against the cluster and, for job
+// registrations and scaling, adds the scheduler's plan to the result
+func (h *Handler) planDryRun(r *http.Request, cluster, path string, body []byte, result *DryRunResult) error {
+	client, err := h.GetClientWithToken(cluster, getTokenForCluster(r, cluster))
+	if err != nil {
+		return err
+	}
+
+	opts := getWriteOptions(r)
+	jobID := r.URL.Query().Get("id")
+
+	switch {
+	case r.Method == http.MethodPost && path == "/v1/job":
+		var job api.Job
+		if err := json.Unmarshal(body, &job); err != nil {
+			return badRequest(err)
+		}
+
+		plan, _, err := client.Jobs().Plan(&job, true, opts)
+		if err != nil {
+			return err
+		}
+
+		result.Plan = plan
+
+	case r.Method == http.MethodPost && path == "/v1/job/scale":
+		var scaleReq struct {
+			Target map[string]string `json:"target"`
+			Count  *int              `json:"count"`
+		}
+		if err := json.Unmarshal(body, &scaleReq); err != nil {
+			return badRequest(err)
+		}
+
+		job, _, err := client.Jobs().Info(jobID, &api.QueryOptions{Namespace: opts.Namespace, Region: opts.Region})
+		if err != nil {
+			return err
+		}
+
+		if scaleReq.Count != nil {
+			group := job.LookupTaskGroup(scaleReq.Target["group"])
+			if group == nil {
+				return badRequest(fmt.Errorf("group %q not found in job %q", scaleReq.Target["group"], jobID))
+			}
+
+			group.Count = scaleReq.Count
+		}
+
+		plan, _, err := client.Jobs().Plan(job, true, opts)
+		if err != nil {
+			return err
+		}
+
+		result.Plan = plan
+
+	case strings.HasPrefix(path, "/v1/job") && jobID != "":
+		// Make sure the job the request targets exists
+		if _, _, err := client.Jobs().Info(jobID, &api.QueryOptions{Namespace: opts.Namespace, Region: opts.Region}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeDryRunCommand answers a request running a command in dry-run mode
+func writeDryRunCommand(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Caravan-Dry-Run", "true")
+	writeJSON(w, &DryRunResult{
+		DryRun:  true,
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.Query(),
+		Message: "dry-run mode: the command was not run",
+	})
+}
+
+// isCommand reports whether the cluster path runs a command: an exec session
+// in an allocation or an action of a job
+func isCommand(rest string) bool {
+	if rest == "/v1/job/action" {
+		return true
+	}
+
+	allocID := allocationFromPath(rest)
+
+	return allocID != "" && strings.HasPrefix(rest, "/v1/allocation/"+allocID+"/exec/")
+}
+
+// isApproval reports whether the path approves a pending action, which runs it
+func isApproval(path string) bool {
+	return strings.Contains(path, "/api/approvals/") && strings.HasSuffix(path, "/approve")
+}
+
+func badRequest(err error) error {
+	return apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, err.Error())
+}
@@ -0,0 +1,41 @@
+package nomad
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// indexETag is the entity tag of an object at a Nomad modify index
+func indexETag(index uint64) string {
+	return `"` + strconv.FormatUint(index, 10) + `"`
+}
+
+// writeIndexed writes an object with its modify index as the ETag, or 304
+// Not Modified when the request's If-None-Match already has that index.
+// Browsers revalidate the object on every read, so it is never stale.
+func writeIndexed(w http.ResponseWriter, r *http.Request, index uint64, v interface{}) {
+	etag := indexETag(index)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeJSON(w, v)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 asks for If-None-Match
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,325 @@
+package nomad
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+const (
+	// churnWindow is the period EvaluationChurn.Recent counts evaluations over
+	churnWindow = time.Hour
+	// churnRetention is how long the counters of a job without evaluations are kept
+	churnRetention = 24 * time.Hour
+	// churnTrackedEvals bounds the evaluation IDs remembered per job to
+	// recognise status updates of an evaluation already counted
+	churnTrackedEvals = 256
+	// clusterRefreshInterval is how often background trackers look for
+	// clusters added or removed
+	clusterRefreshInterval = time.Minute
+)
+
+// EvaluationChurn counts the evaluations of a job seen on the event stream
+// since Since. A job whose evaluations keep being triggered, or blocked,
+// keeps the scheduler busy without making progress.
+type EvaluationChurn struct {
+	Since time.Time `json:"since"`
+	Total int       `json:"total"`
+	// Recent is the number of evaluations created in the last hour
+	Recent int `json:"recent"`
+	// TriggeredBy counts evaluations by their trigger, such as
+	// "job-register", "node-update" or "queued-allocs"
+	TriggeredBy    map[string]int `json:"triggeredBy"`
+	Blocked        int            `json:"blocked"`
+	Failed         int            `json:"failed"`
+	LastEvaluation time.Time      `json:"lastEvaluation,omitempty"`
+}
+
+// JobEvaluationChurn is the evaluation churn of one job of a cluster
+type JobEvaluationChurn struct {
+	JobID     string `json:"jobId"`
+	Namespace string `json:"namespace"`
+	EvaluationChurn
+}
+
+// Flags of the evaluations a job's counters remember
+const (
+	evalBlocked = 1 << iota
+	evalFailed
+)
+
+type churnKey struct {
+	namespace, jobID string
+}
+
+type jobChurn struct {
+	churn  EvaluationChurn
+	recent []time.Time
+	evals  map[string]int
+	order  []string
+}
+
+type clusterChurn struct {
+	since     time.Time
+	jobs      map[churnKey]*jobChurn
+	lastPrune time.Time
+}
+
+// evalChurnTracker keeps the evaluation counters of the clusters it follows
+type evalChurnTracker struct {
+	mu       sync.Mutex
+	clusters map[string]*clusterChurn
+}
+
+func newEvalChurnTracker() *evalChurnTracker {
+	return &evalChurnTracker{clusters: make(map[string]*clusterChurn)}
+}
+
+func (t *evalChurnTracker) start(cluster string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.clusters[cluster]; !ok {
+		t.clusters[cluster] = &clusterChurn{since: now, jobs: make(map[churnKey]*jobChurn), lastPrune: now}
+	}
+}
+
+func (t *evalChurnTracker) forget(cluster string) {
+	t.mu.Lock()
+	delete(t.clusters, cluster)
+	t.mu.Unlock()
+}
+
+// record counts an evaluation. Nomad publishes an event for every status
+// change of an evaluation, it is only counted the first time.
+func (t *evalChurnTracker) record(cluster string, eval *api.Evaluation, now time.Time) {
+	if eval.JobID == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.clusters[cluster]
+	if !ok {
+		return
+	}
+
+	if now.Sub(c.lastPrune) > churnWindow {
+		for key, j := range c.jobs {
+			if now.Sub(j.churn.LastEvaluation) > churnRetention {
+				delete(c.jobs, key)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	key := churnKey{namespace: eval.Namespace, jobID: eval.JobID}
+	j, ok := c.jobs[key]
+	if !ok {
+		j = &jobChurn{
+			churn: EvaluationChurn{TriggeredBy: make(map[string]int)},
+			evals: make(map[string]int),
+		}
+		c.jobs[key] = j
+	}
+
+	flags, seen := j.evals[eval.ID]
+	if !seen {
+		j.churn.Total++
+		j.churn.TriggeredBy[eval.TriggeredBy]++
+		j.recent = append(j.recent, now)
+
+		j.order = append(j.order, eval.ID)
+		if len(j.order) > churnTrackedEvals {
+			delete(j.evals, j.order[0])
+			j.order = j.order[1:]
+		}
+	}
+
+	switch {
+	case eval.Status == api.EvalStatusBlocked && flags&evalBlocked == 0:
+		j.churn.Blocked++
+		flags |= evalBlocked
+	case eval.Status == api.EvalStatusFailed && flags&evalFailed == 0:
+		j.churn.Failed++
+		flags |= evalFailed
+	}
+
+	j.evals[eval.ID] = flags
+	j.churn.LastEvaluation = now
+}
+
+// snapshot returns a copy of the counters of a job, nil if the cluster is
+// not tracked
+func (t *evalChurnTracker) snapshot(cluster, namespace, jobID string, now time.Time) *EvaluationChurn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.clusters[cluster]
+	if !ok {
+		return nil
+	}
+
+	j, ok := c.jobs[churnKey{namespace: namespace, jobID: jobID}]
+	if !ok {
+		return &EvaluationChurn{Since: c.since, TriggeredBy: map[string]int{}}
+	}
+
+	return j.copy(c.since, now)
+}
+
+// list returns the counters of all jobs of a cluster, nil if it is not tracked
+func (t *evalChurnTracker) list(cluster string, now time.Time) []JobEvaluationChurn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c, ok := t.clusters[cluster]
+	if !ok {
+		return nil
+	}
+
+	jobs := make([]JobEvaluationChurn, 0, len(c.jobs))
+	for key, j := range c.jobs {
+		jobs = append(jobs, JobEvaluationChurn{
+			JobID:           key.jobID,
+			Namespace:       key.namespace,
+			EvaluationChurn: *j.copy(c.since, now),
+		})
+	}
+
+	return jobs
+}
+
+func (j *jobChurn) copy(since, now time.Time) *EvaluationChurn {
+	for len(j.recent) > 0 && now.Sub(j.recent[0]) > churnWindow {
+		j.recent = j.recent[1:]
+	}
+
+	churn := j.churn
+	churn.Since = since
+	churn.Recent = len(j.recent)
+	churn.TriggeredBy = make(map[string]int, len(j.churn.TriggeredBy))
+	for trigger, n := range j.churn.TriggeredBy {
+		churn.TriggeredBy[trigger] = n
+	}
+
+	return &churn
+}
+
+// TrackEvaluations counts the evaluations of all clusters, as the event bus
+// reports them, until ctx is done. The counters are kept in memory; they
+// start over when Caravan restarts.
+func (h *Handler) TrackEvaluations(ctx context.Context) {
+	h.followClusters(ctx, clusterRefreshInterval, func(cluster string) func() {
+		h.evalChurn.start(cluster, time.Now())
+
+		unsubscribe := h.events.Subscribe(cluster, []api.Topic{api.TopicEvaluation}, 0, func(event api.Event) {
+			if eval, err := event.Evaluation(); err == nil && eval != nil {
+				h.evalChurn.record(cluster, eval, time.Now())
+			}
+		})
+
+		return func() {
+			unsubscribe()
+			h.evalChurn.forget(cluster)
+		}
+	}, nil)
+}
+
+// followClusters calls follow for each configured cluster, and for those
+// added later, and the function it returns once the cluster is removed or
+// ctx is done. poll, if set, is called for every cluster each interval.
+func (h *Handler) followClusters(ctx context.Context, interval time.Duration,
+	follow func(cluster string) (stop func()), poll func(cluster string),
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	subscriptions := make(map[string]func())
+	defer func() {
+		for _, stop := range subscriptions {
+			stop()
+		}
+	}()
+
+	for {
+		current := make(map[string]bool)
+
+		for _, c := range h.configStore.GetContexts() {
+			current[c.Name] = true
+
+			if _, ok := subscriptions[c.Name]; !ok {
+				subscriptions[c.Name] = follow(c.Name)
+			}
+
+			if poll != nil {
+				poll(c.Name)
+			}
+		}
+
+		for cluster, stop := range subscriptions {
+			if !current[cluster] {
+				stop()
+				delete(subscriptions, cluster)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// GetEvaluationChurn handles GET /clusters/{cluster}/v1/jobs/evaluation-churn?namespace=
+// It lists the jobs the token can read by their evaluations in the last
+// hour, busiest first.
+func (h *Handler) GetEvaluationChurn(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+
+	client, err := h.GetClientWithToken(clusterName, getToken(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	if opts.Namespace == "" {
+		opts.Namespace = "*"
+	}
+
+	// The counters come from the cluster's own token, only show the jobs
+	// the user can see
+	stubs, _, err := client.Jobs().List(opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	visible := make(map[churnKey]bool, len(stubs))
+	for _, stub := range stubs {
+		visible[churnKey{namespace: stub.Namespace, jobID: stub.ID}] = true
+	}
+
+	jobs := []JobEvaluationChurn{}
+	for _, job := range h.evalChurn.list(clusterName, time.Now()) {
+		if visible[churnKey{namespace: job.Namespace, jobID: job.JobID}] {
+			jobs = append(jobs, job)
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Recent != jobs[j].Recent {
+			return jobs[i].Recent > jobs[j].Recent
+		}
+		return jobs[i].Total > jobs[j].Total
+	})
+
+	writeJSON(w, jobs)
+}
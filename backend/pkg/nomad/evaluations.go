@@ -11,18 +11,22 @@ func (h *Handler) ListEvaluations(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	opts := getQueryOptions(r)
-	evals, _, err := client.Evaluations().List(opts)
+	opts, err := h.listOptions(r)
 	if err != nil {
-		writeNomadError(w, err)
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	evals, meta, err := client.Evaluations().List(opts)
+	if err != nil {
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, evals)
+	writeList(h, w, r, opts, evals, meta)
 }
 
 // GetEvaluation handles GET /clusters/{cluster}/v1/evaluation/{evalID}
@@ -33,18 +37,20 @@ func (h *Handler) GetEvaluation(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	eval, _, err := client.Evaluations().Info(evalID, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, eval)
+	hints := h.runbookHints(r.Context(), clusterName, placementFailures(eval))
+
+	writeJSON(w, EvaluationWithHints{Evaluation: eval, RunbookHints: hints})
 }
 
 // GetEvaluationAllocations handles GET /clusters/{cluster}/v1/evaluation/{evalID}/allocations
@@ -55,16 +61,16 @@ func (h *Handler) GetEvaluationAllocations(w http.ResponseWriter, r *http.Reques
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	allocs, _, err := client.Evaluations().Allocations(evalID, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, allocs)
+	writeJSONArray(w, allocs)
 }
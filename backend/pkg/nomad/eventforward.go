@@ -0,0 +1,108 @@
+package nomad
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/eventbridge"
+)
+
+// Forwards send a cluster's events, read with the token of whoever created
+// them, to any URL, so they are left to the cluster's managers.
+
+// ListEventForwards handles GET /clusters/{cluster}/v1/event/forward
+// The sink headers, which carry its credentials, are redacted.
+func (h *Handler) ListEventForwards(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	if _, ok := h.requireManagement(w, r, clusterName); !ok {
+		return
+	}
+
+	forwards := h.eventBridge.List(clusterName)
+	for i := range forwards {
+		forwards[i].Sink = forwards[i].Sink.Redacted()
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"forwards":  forwards,
+		"sinkTypes": eventbridge.SinkTypes(),
+	})
+}
+
+// PutEventForward handles PUT /clusters/{cluster}/v1/event/forward
+// The forward streams events with the token of the user creating it, so it only
+// sees the events that user is allowed to read.
+func (h *Handler) PutEventForward(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+
+	if _, ok := h.requireManagement(w, r, clusterName); !ok {
+		return
+	}
+
+	var fwd eventbridge.Forward
+	if err := json.NewDecoder(r.Body).Decode(&fwd); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if fwd.ID == "" {
+		fwd.ID = newID()
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	namespace := fwd.Namespace
+	subscribe := func(ctx context.Context, topics map[api.Topic][]string, index uint64) (<-chan *api.Events, error) {
+		return client.EventStream().Stream(ctx, topics, index, &api.QueryOptions{Namespace: namespace})
+	}
+
+	if err := h.eventBridge.Start(clusterName, fwd, subscribe); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fwd.Sink = fwd.Sink.Redacted()
+	json.NewEncoder(w).Encode(fwd)
+}
+
+// DeleteEventForward handles DELETE /clusters/{cluster}/v1/event/forward?id=
+func (h *Handler) DeleteEventForward(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	id := r.URL.Query().Get("id")
+
+	if _, ok := h.requireManagement(w, r, clusterName); !ok {
+		return
+	}
+
+	if id == "" {
+		writeError(w, r, fmt.Errorf("forward id is required"), http.StatusBadRequest)
+		return
+	}
+
+	if !h.eventBridge.Stop(clusterName, id) {
+		writeError(w, r, fmt.Errorf("event forward %q not found", id), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newID returns a random identifier for records Caravan creates
+func newID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -17,7 +17,7 @@ func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -40,23 +40,53 @@ func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	h.writeEventStream(w, r, client, topics)
+}
+
+// StreamJobEvents handles GET /clusters/{cluster}/v1/job/events?id=jobID
+// It streams, as SSE, the events of a single job and of its deployments,
+// allocations and evaluations, which Nomad filters by job ID.
+func (h *Handler) StreamJobEvents(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	jobID := r.URL.Query().Get("id")
+
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job ID is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	topics := map[api.Topic][]string{
+		api.TopicJob:        {jobID},
+		api.TopicDeployment: {jobID},
+		api.TopicAllocation: {jobID},
+		api.TopicEvaluation: {jobID},
+	}
+
+	h.writeEventStream(w, r, client, topics)
+}
+
+// writeEventStream streams the events of topics as SSE, until the client
+// goes away or the stream fails
+func (h *Handler) writeEventStream(w http.ResponseWriter, r *http.Request, client NomadAPI,
+	topics map[api.Topic][]string) {
 	// Get starting index from query params
 	var index uint64
 	if indexStr := r.URL.Query().Get("index"); indexStr != "" {
 		fmt.Sscanf(indexStr, "%d", &index)
 	}
 
-	// Set up SSE headers
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
-
-	flusher, ok := w.(http.Flusher)
+	sse, ok := h.newSSEWriter(w, r, "events")
 	if !ok {
-		writeError(w, fmt.Errorf("streaming not supported"), http.StatusInternalServerError)
 		return
 	}
+	defer sse.Close()
 
 	// Create context for cancellation
 	ctx, cancel := context.WithCancel(r.Context())
@@ -71,7 +101,7 @@ func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
 	opts := getQueryOptions(r)
 	eventsCh, err := client.EventStream().Stream(ctx, topics, index, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -84,8 +114,7 @@ func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
 			}
 
 			if events.Err != nil {
-				fmt.Fprintf(w, "event: error\ndata: %s\n\n", events.Err.Error())
-				flusher.Flush()
+				sse.Error(events.Err)
 				return
 			}
 
@@ -101,8 +130,14 @@ func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
 					continue
 				}
 
-				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Topic, string(data))
-				flusher.Flush()
+				sse.Event(string(event.Topic), "", data)
+				if sse.Full() && sse.Flush() != nil {
+					return
+				}
+			}
+			// A batch of events goes out at once
+			if err := sse.Flush(); err != nil {
+				return
 			}
 
 		case <-ctx.Done():
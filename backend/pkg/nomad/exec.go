@@ -3,21 +3,53 @@ package nomad
 import (
 	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
+	pathpkg "path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/coder/websocket"
 	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/execpolicy"
 	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
 )
 
+// WithExecPolicy restricts the commands users may exec into allocations
+func WithExecPolicy(p *execpolicy.Policy) Option {
+	return func(h *Handler) {
+		h.execPolicy = p
+	}
+}
+
+// defaultExecHeartbeat keeps exec sessions from idling out behind proxies
+const defaultExecHeartbeat = 10 * time.Second
+
+// WithExecHeartbeat sets how often exec sessions send Nomad an empty frame
+// while idle, for proxies closing idle WebSockets sooner than the default
+func WithExecHeartbeat(interval time.Duration) Option {
+	return func(h *Handler) {
+		if interval > 0 {
+			h.execHeartbeat = interval
+		}
+	}
+}
+
+// ExecPresets returns the preset commands of the exec policy, for the UI
+func (h *Handler) ExecPresets() []execpolicy.Preset {
+	return h.execPolicy.Presets()
+}
+
 // NomadExecStreamingInput matches Nomad's ExecStreamingInput structure
 type NomadExecStreamingInput struct {
 	Stdin   *NomadExecStreamingIOOperation `json:"stdin,omitempty"`
@@ -58,9 +90,21 @@ func sendWSError(ctx context.Context, conn *websocket.Conn, errMsg string) {
 	conn.Write(ctx, websocket.MessageText, msg)
 }
 
+// execData is stdin or output data as it travels in the client's messages:
+// as text, or base64 encoded in binary mode, where bytes that are not valid
+// UTF-8 would otherwise be replaced on the way
+func execData(data []byte, binary bool) string {
+	if binary {
+		return base64.StdEncoding.EncodeToString(data)
+	}
+	return string(data)
+}
+
 // ExecAllocation handles WebSocket connection for exec into an allocation
 // This creates a WebSocket proxy to Nomad's exec endpoint
-// GET /clusters/{cluster}/v1/allocation/{allocID}/exec/{task}
+// GET /clusters/{cluster}/v1/allocation/{allocID}/exec/{task}?command=&tty=&encoding=
+// With encoding=base64, which needs tty=false, the data of stdin and output
+// messages is base64 encoded so that binary data is piped through unchanged.
 func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 	clusterName := getClusterName(r)
 	token := getToken(r)
@@ -73,20 +117,50 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 		"task":    task,
 	}, nil, "ExecAllocation: Starting exec request")
 
-	// Get command from query params
-	cmdStr := r.URL.Query().Get("command")
-	if cmdStr == "" {
-		cmdStr = "/bin/sh"
+	// Get command from query params, the default shell is chosen once the
+	// allocation's namespace is known
+	var command []string
+	if cmdStr := r.URL.Query().Get("command"); cmdStr != "" {
+		// Parse command - Nomad expects JSON array
+		command = strings.Split(cmdStr, " ")
 	}
-	// Parse command - Nomad expects JSON array
-	command := strings.Split(cmdStr, " ")
 
 	// Check if TTY is requested
 	tty := r.URL.Query().Get("tty") != "false"
 
+	// A TTY rewrites the bytes written to it, so binary data is only piped
+	// without one
+	var binary bool
+	switch encoding := r.URL.Query().Get("encoding"); encoding {
+	case "":
+	case "base64":
+		if tty {
+			writeError(w, r, errors.New("encoding=base64 requires tty=false"), http.StatusBadRequest)
+			return
+		}
+		binary = true
+	default:
+		writeError(w, r, fmt.Errorf("unknown encoding %q", encoding), http.StatusBadRequest)
+		return
+	}
+
+	// A freeze applies to the namespace of the allocation
+	var namespace string
+	if h.freeze != nil && !h.DryRun() {
+		var err error
+		if namespace, err = h.allocationNamespace(r, clusterName, allocID); err != nil {
+			writeNomadError(w, r, err)
+			return
+		}
+	}
+	if h.commandHeldBack(w, r, clusterName, namespace, token) {
+		return
+	}
+
 	// FIRST: Upgrade the client connection to WebSocket using coder/websocket
 	clientConn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"}, // Allow all origins for now
+		OriginPatterns:  []string{"*"}, // Allow all origins for now
+		CompressionMode: h.wsCompression,
 	})
 	if err != nil {
 		logger.Log(logger.LevelError, nil, err, "ExecAllocation: Failed to upgrade client connection")
@@ -133,75 +207,66 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 		"task":    task,
 	}, nil, "ExecAllocation: Got allocation info")
 
-	// Build the Nomad WebSocket URL
-	nomadURL, err := url.Parse(nomadCtx.Address)
-	if err != nil {
-		errMsg := fmt.Sprintf("Invalid Nomad address: %v", err)
-		logger.Log(logger.LevelError, nil, err, errMsg)
-		sendWSError(ctx, clientConn, errMsg)
-		return
+	// Apply the exec policy before anything reaches Nomad
+	command = h.execPolicy.Command(alloc.Namespace, command)
+	if len(command) == 0 {
+		command = []string{"/bin/sh"}
 	}
 
-	// Convert HTTP(S) to WS(S)
-	scheme := "ws"
-	if nomadURL.Scheme == "https" {
-		scheme = "wss"
+	if err := h.execPolicy.Check(command); err != nil {
+		logger.Log(logger.LevelWarn, map[string]string{
+			"cluster": clusterName,
+			"allocID": allocID,
+			"command": strings.Join(command, " "),
+		}, err, "ExecAllocation: Command rejected")
+		sendWSError(ctx, clientConn, err.Error())
+		clientConn.Close(websocket.StatusPolicyViolation, "command not allowed")
+		return
 	}
 
-	// Build query params for Nomad
-	commandJSON, _ := json.Marshal(command)
-	nomadParams := url.Values{}
-	nomadParams.Set("task", task)
-	nomadParams.Set("tty", fmt.Sprintf("%t", tty))
-	nomadParams.Set("command", string(commandJSON))
+	// Connect to Nomad WebSocket
+	nomadConn, err := h.dialExec(ctx, nomadCtx, token, allocID, task, tty, command)
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "ExecAllocation: Failed to connect to Nomad exec")
+		sendWSError(ctx, clientConn, err.Error())
+		return
+	}
+	defer nomadConn.CloseNow()
 
-	nomadExecURL := fmt.Sprintf("%s://%s/v1/client/allocation/%s/exec?%s",
-		scheme, nomadURL.Host, allocID, nomadParams.Encode())
+	logger.Log(logger.LevelInfo, nil, nil, "ExecAllocation: WebSocket proxy established, starting message relay")
 
-	logger.Log(logger.LevelInfo, map[string]string{
-		"url": nomadExecURL,
-	}, nil, "ExecAllocation: Connecting to Nomad WebSocket")
+	h.relayExec(ctx, "ExecAllocation", clientConn, nomadConn, binary)
+}
 
-	// Build dial options for Nomad connection
-	dialOpts := &websocket.DialOptions{
-		HTTPHeader: http.Header{},
+// commandHeldBack answers the request and returns true when dry-run mode or
+// a freeze window of namespace keeps a command from running. Exec sessions
+// and job actions are GET WebSockets, which the middlewares holding back
+// changes let through as reads, so their handlers check before upgrading.
+func (h *Handler) commandHeldBack(w http.ResponseWriter, r *http.Request, cluster, namespace, token string) bool {
+	if h.DryRun() {
+		writeDryRunCommand(w, r)
+		return true
 	}
 
-	// Add token header if present
-	if token != "" {
-		dialOpts.HTTPHeader.Set("X-Nomad-Token", token)
-	}
+	if active := h.activeFreeze(cluster, namespaceOrDefault(namespace), token); len(active) > 0 {
+		logger.Log(logger.LevelInfo, map[string]string{
+			"cluster":   cluster,
+			"namespace": namespaceOrDefault(namespace),
+			"window":    active[0].Name,
+			"path":      r.URL.Path,
+		}, nil, "blocked command during freeze window")
 
-	// Configure TLS
-	if scheme == "wss" {
-		tlsConfig := &tls.Config{}
-		if nomadCtx.TLS != nil && nomadCtx.TLS.Insecure {
-			tlsConfig.InsecureSkipVerify = true
-		}
-		dialOpts.HTTPClient = &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: tlsConfig,
-			},
-		}
+		writeFrozen(w, r, cluster, active)
+		return true
 	}
 
-	// Connect to Nomad WebSocket
-	nomadConn, resp, err := websocket.Dial(ctx, nomadExecURL, dialOpts)
-	if err != nil {
-		errMsg := fmt.Sprintf("Failed to connect to Nomad exec: %v", err)
-		if resp != nil {
-			body, _ := io.ReadAll(resp.Body)
-			resp.Body.Close()
-			errMsg = fmt.Sprintf("%s - Response: %d %s", errMsg, resp.StatusCode, string(body))
-		}
-		logger.Log(logger.LevelError, nil, err, errMsg)
-		sendWSError(ctx, clientConn, errMsg)
-		return
-	}
-	defer nomadConn.CloseNow()
-
-	logger.Log(logger.LevelInfo, nil, nil, "ExecAllocation: WebSocket proxy established, starting message relay")
+	return false
+}
 
+// relayExec relays an exec session between the client's WebSocket, in
+// Caravan's message format, and Nomad's, until either side closes. Name
+// prefixes the log messages; binary has stdin and output data base64 encoded.
+func (h *Handler) relayExec(ctx context.Context, name string, clientConn, nomadConn *websocket.Conn, binary bool) {
 	// Create a mutex for writing to each connection
 	var clientWriteMu sync.Mutex
 	var nomadWriteMu sync.Mutex
@@ -222,7 +287,7 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 			msgType, message, err := clientConn.Read(proxyCtx)
 			if err != nil {
 				if websocket.CloseStatus(err) != websocket.StatusNormalClosure {
-					logger.Log(logger.LevelError, nil, err, "ExecAllocation: Client read error")
+					logger.Log(logger.LevelError, nil, err, name+": Client read error")
 				}
 				return
 			}
@@ -233,11 +298,12 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 
 			// Parse client message (our custom format)
 			var clientMsg struct {
-				Type string          `json:"type"`
-				Data json.RawMessage `json:"data"`
+				Type  string          `json:"type"`
+				Data  json.RawMessage `json:"data"`
+				Close bool            `json:"close"`
 			}
 			if err := json.Unmarshal(message, &clientMsg); err != nil {
-				logger.Log(logger.LevelWarn, nil, err, "ExecAllocation: Failed to parse client message")
+				logger.Log(logger.LevelWarn, nil, err, name+": Failed to parse client message")
 				continue
 			}
 
@@ -246,13 +312,25 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 
 			switch clientMsg.Type {
 			case "stdin":
+				// close, with or without data, ends the command's stdin, as
+				// piped input needs
 				var data string
-				if err := json.Unmarshal(clientMsg.Data, &data); err != nil {
-					logger.Log(logger.LevelWarn, nil, err, "ExecAllocation: Failed to parse stdin data")
-					continue
+				if len(clientMsg.Data) > 0 || !clientMsg.Close {
+					if err := json.Unmarshal(clientMsg.Data, &data); err != nil {
+						logger.Log(logger.LevelWarn, nil, err, name+": Failed to parse stdin data")
+						continue
+					}
+				}
+				stdin := []byte(data)
+				if binary {
+					if stdin, err = base64.StdEncoding.DecodeString(data); err != nil {
+						logger.Log(logger.LevelWarn, nil, err, name+": Failed to decode stdin data")
+						continue
+					}
 				}
 				nomadInput.Stdin = &NomadExecStreamingIOOperation{
-					Data: []byte(data),
+					Data:  stdin,
+					Close: clientMsg.Close,
 				}
 			case "resize":
 				var size struct {
@@ -260,7 +338,7 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 					Height int `json:"height"`
 				}
 				if err := json.Unmarshal(clientMsg.Data, &size); err != nil {
-					logger.Log(logger.LevelWarn, nil, err, "ExecAllocation: Failed to parse resize data")
+					logger.Log(logger.LevelWarn, nil, err, name+": Failed to parse resize data")
 					continue
 				}
 				nomadInput.TTYSize = &NomadTerminalSize{
@@ -268,18 +346,18 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 					Height: size.Height,
 				}
 			default:
-				logger.Log(logger.LevelWarn, map[string]string{"type": clientMsg.Type}, nil, "ExecAllocation: Unknown client message type")
+				logger.Log(logger.LevelWarn, map[string]string{"type": clientMsg.Type}, nil, name+": Unknown client message type")
 				continue
 			}
 
 			// Send to Nomad
 			nomadMsg, _ := json.Marshal(nomadInput)
 			nomadWriteMu.Lock()
-			err = nomadConn.Write(proxyCtx, websocket.MessageText, nomadMsg)
+			err = h.writeWS(proxyCtx, nomadConn, "", nomadMsg)
 			nomadWriteMu.Unlock()
 
 			if err != nil {
-				logger.Log(logger.LevelError, nil, err, "ExecAllocation: Failed to send to Nomad")
+				logger.Log(logger.LevelError, nil, err, name+": Failed to send to Nomad")
 				return
 			}
 		}
@@ -293,7 +371,7 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 			msgType, message, err := nomadConn.Read(proxyCtx)
 			if err != nil {
 				if websocket.CloseStatus(err) != websocket.StatusNormalClosure {
-					logger.Log(logger.LevelError, nil, err, "ExecAllocation: Nomad read error")
+					logger.Log(logger.LevelError, nil, err, name+": Nomad read error")
 				}
 				return
 			}
@@ -305,7 +383,7 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 			// Parse Nomad message
 			var nomadOutput NomadExecStreamingOutput
 			if err := json.Unmarshal(message, &nomadOutput); err != nil {
-				logger.Log(logger.LevelWarn, nil, err, "ExecAllocation: Failed to parse Nomad message")
+				logger.Log(logger.LevelWarn, nil, err, name+": Failed to parse Nomad message")
 				continue
 			}
 
@@ -315,12 +393,12 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 			if nomadOutput.Stdout != nil && len(nomadOutput.Stdout.Data) > 0 {
 				clientMsg = map[string]interface{}{
 					"type": "stdout",
-					"data": string(nomadOutput.Stdout.Data),
+					"data": execData(nomadOutput.Stdout.Data, binary),
 				}
 			} else if nomadOutput.Stderr != nil && len(nomadOutput.Stderr.Data) > 0 {
 				clientMsg = map[string]interface{}{
 					"type": "stderr",
-					"data": string(nomadOutput.Stderr.Data),
+					"data": execData(nomadOutput.Stderr.Data, binary),
 				}
 			} else if nomadOutput.Exited && nomadOutput.Result != nil {
 				clientMsg = map[string]interface{}{
@@ -334,11 +412,11 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 
 			clientMsgBytes, _ := json.Marshal(clientMsg)
 			clientWriteMu.Lock()
-			err = clientConn.Write(proxyCtx, websocket.MessageText, clientMsgBytes)
+			err = h.writeWS(proxyCtx, clientConn, "exec", clientMsgBytes)
 			clientWriteMu.Unlock()
 
 			if err != nil {
-				logger.Log(logger.LevelError, nil, err, "ExecAllocation: Failed to send to client")
+				logger.Log(logger.LevelError, nil, err, name+": Failed to send to client")
 				return
 			}
 
@@ -351,7 +429,7 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 
 	// Send periodic heartbeats to Nomad
 	go func() {
-		ticker := time.NewTicker(10 * time.Second)
+		ticker := time.NewTicker(h.execHeartbeat)
 		defer ticker.Stop()
 
 		for {
@@ -364,7 +442,7 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 				// Send empty heartbeat to Nomad
 				heartbeat, _ := json.Marshal(NomadExecStreamingInput{})
 				nomadWriteMu.Lock()
-				err := nomadConn.Write(proxyCtx, websocket.MessageText, heartbeat)
+				err := h.writeWS(proxyCtx, nomadConn, "", heartbeat)
 				nomadWriteMu.Unlock()
 				if err != nil {
 					return
@@ -375,13 +453,88 @@ func (h *Handler) ExecAllocation(w http.ResponseWriter, r *http.Request) {
 
 	// Wait for either connection to close
 	<-done
-	logger.Log(logger.LevelInfo, nil, nil, "ExecAllocation: WebSocket proxy closed")
+	logger.Log(logger.LevelInfo, nil, nil, name+": WebSocket proxy closed")
 
 	// Close connections gracefully
 	clientConn.Close(websocket.StatusNormalClosure, "session ended")
 	nomadConn.Close(websocket.StatusNormalClosure, "session ended")
 }
 
+// dialExec starts command in a task through Nomad's exec WebSocket
+func (h *Handler) dialExec(ctx context.Context, nomadCtx *nomadconfig.Context, token, allocID, task string,
+	tty bool, command []string,
+) (*websocket.Conn, error) {
+	// Build query params for Nomad
+	commandJSON, _ := json.Marshal(command)
+	nomadParams := url.Values{}
+	nomadParams.Set("task", task)
+	nomadParams.Set("tty", fmt.Sprintf("%t", tty))
+	nomadParams.Set("command", string(commandJSON))
+
+	return h.dialNomadWebSocket(ctx, nomadCtx, token, "/v1/client/allocation/"+allocID+"/exec", nomadParams)
+}
+
+// dialNomadWebSocket opens a WebSocket to path on the Nomad API of a cluster
+func (h *Handler) dialNomadWebSocket(ctx context.Context, nomadCtx *nomadconfig.Context, token, path string,
+	params url.Values,
+) (*websocket.Conn, error) {
+	// Build the Nomad WebSocket URL
+	nomadURL, err := url.Parse(nomadCtx.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Nomad address: %w", err)
+	}
+
+	// Convert HTTP(S) to WS(S)
+	scheme := "ws"
+	if nomadURL.Scheme == "https" {
+		scheme = "wss"
+	}
+
+	nomadExecURL := fmt.Sprintf("%s://%s%s?%s", scheme, nomadURL.Host, path, params.Encode())
+
+	logger.Log(logger.LevelInfo, map[string]string{
+		"url": nomadExecURL,
+	}, nil, "Connecting to Nomad exec WebSocket")
+
+	// Build dial options for Nomad connection
+	dialOpts := &websocket.DialOptions{
+		HTTPHeader:      http.Header{},
+		CompressionMode: h.wsCompression,
+	}
+
+	// Add token header if present
+	if token != "" {
+		dialOpts.HTTPHeader.Set("X-Nomad-Token", token)
+	}
+
+	// Configure TLS
+	if scheme == "wss" {
+		tlsConfig := &tls.Config{}
+		if nomadCtx.TLS != nil && nomadCtx.TLS.Insecure {
+			tlsConfig.InsecureSkipVerify = true
+		}
+		dialOpts.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+		}
+	}
+
+	nomadConn, resp, err := websocket.Dial(ctx, nomadExecURL, dialOpts)
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed to connect to Nomad exec: %v", err)
+		if resp != nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			errMsg = fmt.Sprintf("%s - Response: %d %s", errMsg, resp.StatusCode, string(body))
+		}
+
+		return nil, errors.New(errMsg)
+	}
+
+	return nomadConn, nil
+}
+
 // GetAllocFS handles GET /clusters/{cluster}/v1/allocation/{allocID}/fs
 // Returns file listing for an allocation
 func (h *Handler) GetAllocFS(w http.ResponseWriter, r *http.Request) {
@@ -396,7 +549,7 @@ func (h *Handler) GetAllocFS(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -406,7 +559,7 @@ func (h *Handler) GetAllocFS(w http.ResponseWriter, r *http.Request) {
 
 	files, _, err := client.AllocFS().List(alloc, path, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -414,21 +567,43 @@ func (h *Handler) GetAllocFS(w http.ResponseWriter, r *http.Request) {
 }
 
 // ReadAllocFile handles GET /clusters/{cluster}/v1/allocation/{allocID}/file
-// Returns content of a file in an allocation
+// Returns content of a file in an allocation. With offset and limit, or a
+// Range header for a single byte range, only that part of the file is read,
+// so that large files can be paged through. Partial responses are 206 with a
+// Content-Range giving the size of the file; limit=0 reads to the end.
+// With download=true the file comes as an attachment named after it, typed
+// from its extension, so that browsers save it rather than show it.
 func (h *Handler) ReadAllocFile(w http.ResponseWriter, r *http.Request) {
 	clusterName := getClusterName(r)
 	token := getToken(r)
 	allocID := r.PathValue("allocID")
+	query := r.URL.Query()
 
-	path := r.URL.Query().Get("path")
+	path := query.Get("path")
 	if path == "" {
-		writeError(w, os.ErrInvalid, http.StatusBadRequest)
+		writeError(w, r, os.ErrInvalid, http.StatusBadRequest)
 		return
 	}
 
+	download := query.Get("download") == "true"
+
+	ranged := query.Has("offset") || query.Has("limit")
+	var offset, limit int64
+	if ranged {
+		var err error
+		if offset, err = parseFileBound(query.Get("offset")); err != nil {
+			writeError(w, r, fmt.Errorf("invalid offset: %w", err), http.StatusBadRequest)
+			return
+		}
+		if limit, err = parseFileBound(query.Get("limit")); err != nil {
+			writeError(w, r, fmt.Errorf("invalid limit: %w", err), http.StatusBadRequest)
+			return
+		}
+	}
+
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -436,13 +611,303 @@ func (h *Handler) ReadAllocFile(w http.ResponseWriter, r *http.Request) {
 	opts := getQueryOptions(r)
 	opts.AuthToken = token // Required for client endpoints like /v1/client/fs/cat
 
-	rc, err := client.AllocFS().Cat(alloc, path, opts)
+	// Other units and multiple ranges are ignored, as RFC 9110 allows
+	header := r.Header.Get("Range")
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		header = ""
+	}
+
+	if !ranged && header == "" {
+		rc, err := client.AllocFS().Cat(alloc, path, opts)
+		if err != nil {
+			writeNomadError(w, r, err)
+			return
+		}
+		defer rc.Close()
+
+		setFileHeaders(w, path, download)
+		io.Copy(w, rc)
+		return
+	}
+
+	// The size bounds the range and goes into Content-Range
+	info, _, err := client.AllocFS().Stat(alloc, path, opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+	if info.IsDir {
+		writeError(w, r, fmt.Errorf("%s is a directory", path), http.StatusBadRequest)
+		return
+	}
+	size := info.Size
+
+	if !ranged {
+		var ok bool
+		if offset, limit, ok = parseByteRange(header, size); !ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			writeError(w, r, fmt.Errorf("range %q is not satisfiable for %d bytes", header, size),
+				http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+
+	offset = min(offset, size)
+	if limit == 0 || limit > size-offset {
+		limit = size - offset
+	}
+
+	setFileHeaders(w, path, download)
+	w.Header().Set("Content-Length", strconv.FormatInt(limit, 10))
+	if limit == 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusPartialContent)
+		return
+	}
+
+	rc, err := client.AllocFS().ReadAt(alloc, path, offset, limit, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		w.Header().Del("Content-Length")
+		writeNomadError(w, r, err)
 		return
 	}
 	defer rc.Close()
 
-	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+limit-1, size))
+	w.WriteHeader(http.StatusPartialContent)
 	io.Copy(w, rc)
 }
+
+// setFileHeaders sets the headers of a file read by ReadAllocFile. Only
+// downloads are typed from their extension: shown inline, an HTML file of a
+// task would otherwise run as a page of Caravan's origin.
+func setFileHeaders(w http.ResponseWriter, file string, download bool) {
+	contentType := "application/octet-stream"
+	if download {
+		if t := mime.TypeByExtension(pathpkg.Ext(file)); t != "" {
+			contentType = t
+		}
+		w.Header().Set("Content-Disposition", attachment(pathpkg.Base(file)))
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Accept-Ranges", "bytes")
+}
+
+// defaultFileTail is how much of a file StreamAllocFile sends from the end
+// when no offset is given, like StreamLogs
+const defaultFileTail = 50000
+
+// FileChunk is a message of StreamAllocFile: data read from the file, and
+// the offset in the file after it
+type FileChunk struct {
+	Data   string `json:"data,omitempty"`
+	Offset int64  `json:"offset"`
+}
+
+// StreamAllocFile handles GET /clusters/{cluster}/v1/allocation/{allocID}/file/stream
+// Streams a file of an allocation as SSE, starting offset bytes from its
+// start or end (origin, end by default). With follow=true it keeps sending
+// what is appended to the file, like tail -f; otherwise it stops with an eof
+// event at the size the file had when the request came in.
+// Chunks carry the offset after them as their event ID, so a reconnecting
+// EventSource resumes where it left off. truncated and deleted events tell
+// when the file is cut or removed while followed.
+func (h *Handler) StreamAllocFile(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	allocID := r.PathValue("allocID")
+	query := r.URL.Query()
+
+	path := query.Get("path")
+	if path == "" {
+		writeError(w, r, os.ErrInvalid, http.StatusBadRequest)
+		return
+	}
+
+	follow := query.Get("follow") == "true"
+
+	origin := query.Get("origin")
+	if origin == "" {
+		origin = "end"
+	}
+	if origin != "start" && origin != "end" {
+		writeError(w, r, fmt.Errorf("origin must be start or end, not %q", origin), http.StatusBadRequest)
+		return
+	}
+
+	offset, err := parseFileBound(query.Get("offset"))
+	if err != nil {
+		writeError(w, r, fmt.Errorf("invalid offset: %w", err), http.StatusBadRequest)
+		return
+	}
+	if !query.Has("offset") && origin == "end" {
+		offset = defaultFileTail
+	}
+
+	if id, err := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64); err == nil && id >= 0 {
+		origin, offset = "start", id
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	alloc := &api.Allocation{ID: allocID}
+	opts := getQueryOptions(r)
+	opts.AuthToken = token // Required for client endpoints like /v1/client/fs/stream
+
+	// Checking the file first answers a missing one with a proper error
+	// rather than an event, and gives the size to stop at without follow
+	info, _, err := client.AllocFS().Stat(alloc, path, opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+	if info.IsDir {
+		writeError(w, r, fmt.Errorf("%s is a directory", path), http.StatusBadRequest)
+		return
+	}
+
+	start := offset
+	if origin == "end" {
+		start = info.Size - offset
+	}
+	start = max(0, min(start, info.Size))
+
+	sse, ok := h.newSSEWriter(w, r, "file")
+	if !ok {
+		return
+	}
+	defer sse.Close()
+
+	// Chunks carry their offset as ID, other events do not
+	add := func(event string, chunk FileChunk) {
+		data, _ := json.Marshal(chunk)
+		id := ""
+		if event == "" {
+			id = strconv.FormatInt(chunk.Offset, 10)
+		}
+		sse.Event(event, id, data)
+	}
+
+	if !follow && start >= info.Size {
+		add("eof", FileChunk{Offset: start})
+		sse.Flush()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	frames, errCh := client.AllocFS().Stream(alloc, path, "start", start, ctx.Done(), opts)
+
+	// The SDK's reader blocks on frames nobody receives, so they are
+	// drained until it notices the request is gone
+	defer func() {
+		if frames != nil {
+			go func() {
+				for range frames {
+				}
+			}()
+		}
+	}()
+
+	for {
+		select {
+		case frame, ok := <-frames:
+			if !ok {
+				frames = nil
+				continue
+			}
+
+			switch frame.FileEvent {
+			case "file deleted":
+				add("deleted", FileChunk{Offset: frame.Offset})
+				sse.Flush()
+				return
+			case "file truncated":
+				add("truncated", FileChunk{Offset: frame.Offset})
+			}
+
+			// Chunks are capped like log lines, each with the offset after it
+			for data := frame.Data; len(data) > 0; {
+				chunk := data[:min(len(data), maxSSELine)]
+				data = data[len(chunk):]
+				add("", FileChunk{Data: string(chunk), Offset: frame.Offset - int64(len(data))})
+				if sse.Full() && sse.Flush() != nil {
+					return
+				}
+			}
+
+			eof := !follow && frame.Offset >= info.Size
+			if eof {
+				add("eof", FileChunk{Offset: frame.Offset})
+			}
+			if err := sse.Flush(); err != nil || eof {
+				return
+			}
+		case err := <-errCh:
+			if err != nil && err != io.EOF && ctx.Err() == nil {
+				sse.Error(err)
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// parseFileBound parses an offset or limit, empty meaning 0
+func parseFileBound(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err == nil && n < 0 {
+		err = errors.New("must not be negative")
+	}
+
+	return n, err
+}
+
+// parseByteRange returns the offset and length of the single byte range of
+// a Range header, for a file of size bytes. ok is false if the range cannot
+// be satisfied.
+func parseByteRange(header string, size int64) (offset, length int64, ok bool) {
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	// bytes=-N is the last N bytes
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+
+		n = min(n, size)
+		return size - n, n, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+
+	return start, end - start + 1, true
+}
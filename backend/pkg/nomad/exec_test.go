@@ -0,0 +1,363 @@
+package nomad_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomad"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadfake"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// execSession is an exec WebSocket Caravan opened on a scriptedExec, which
+// the test plays Nomad's side of frame by frame
+type execSession struct {
+	query  url.Values
+	header http.Header
+	conn   *websocket.Conn
+}
+
+// scriptedExec is a fake Nomad whose exec WebSockets are handed to the test
+// rather than run by the fake cluster, which serves every other request
+type scriptedExec struct {
+	*nomadtest.Server
+	sessions chan *execSession
+	// fail answers exec requests with an error status instead, if set
+	fail int
+}
+
+func newScriptedExec(t *testing.T) *scriptedExec {
+	t.Helper()
+
+	c := nomadfake.New()
+	c.UpsertNode(&api.Node{Name: "client-1"})
+
+	// Sessions are held open until the test ends, then released before the
+	// server closes
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &scriptedExec{sessions: make(chan *execSession, 4)}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", c.Handler())
+	mux.HandleFunc("GET /v1/client/allocation/{id}/exec", func(w http.ResponseWriter, r *http.Request) {
+		if s.fail != 0 {
+			http.Error(w, "task not running", s.fail)
+			return
+		}
+
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+
+		s.sessions <- &execSession{query: r.URL.Query(), header: r.Header, conn: conn}
+		<-ctx.Done()
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Cleanup(cancel)
+
+	s.Server = &nomadtest.Server{Cluster: c, URL: srv.URL}
+
+	return s
+}
+
+// next waits for the exec session Caravan opens
+func (s *scriptedExec) next(t *testing.T) *execSession {
+	t.Helper()
+
+	select {
+	case session := <-s.sessions:
+		return session
+	case <-time.After(5 * time.Second):
+		t.Fatal("no exec session was opened")
+		return nil
+	}
+}
+
+// read returns the next frame Caravan sent to Nomad, as raw JSON
+func (s *execSession) read(ctx context.Context, t *testing.T) map[string]any {
+	t.Helper()
+
+	typ, data, err := s.conn.Read(ctx)
+	require.NoError(t, err)
+	require.Equal(t, websocket.MessageText, typ)
+
+	var frame map[string]any
+	require.NoError(t, json.Unmarshal(data, &frame))
+
+	return frame
+}
+
+// send writes a frame to Caravan as Nomad would
+func (s *execSession) send(ctx context.Context, t *testing.T, frame string) {
+	t.Helper()
+
+	require.NoError(t, s.conn.Write(ctx, websocket.MessageText, []byte(frame)))
+}
+
+// execMessage is a message Caravan sends the browser
+type execMessage struct {
+	Type     string `json:"type"`
+	Data     string `json:"data"`
+	Error    string `json:"error"`
+	ExitCode int    `json:"exitCode"`
+}
+
+func dialExec(ctx context.Context, t *testing.T, srv *httptest.Server, allocID, query string) *websocket.Conn {
+	t.Helper()
+
+	u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/clusters/test/v1/allocation/" + allocID + "/exec/web" + query
+	conn, _, err := websocket.Dial(ctx, u, &websocket.DialOptions{
+		HTTPHeader: http.Header{"X-Nomad-Token": {"secret"}},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.CloseNow() })
+
+	return conn
+}
+
+func readExec(ctx context.Context, t *testing.T, conn *websocket.Conn) execMessage {
+	t.Helper()
+
+	_, data, err := conn.Read(ctx)
+	require.NoError(t, err)
+
+	var msg execMessage
+	require.NoError(t, json.Unmarshal(data, &msg))
+
+	return msg
+}
+
+func writeExec(ctx context.Context, t *testing.T, conn *websocket.Conn, msg string) {
+	t.Helper()
+
+	require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(msg)))
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestExecConformance(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("dial", func(t *testing.T) {
+		nomadSrv := newScriptedExec(t)
+		allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+		srv := newTestServer(t, nomadSrv.Server)
+
+		dialExec(ctx, t, srv, allocs[0].ID, "?command=ls%20-la%20/tmp")
+		session := nomadSrv.next(t)
+		assert.Equal(t, "web", session.query.Get("task"))
+		assert.Equal(t, "true", session.query.Get("tty"))
+		assert.Equal(t, `["ls","-la","/tmp"]`, session.query.Get("command"))
+		assert.Equal(t, "secret", session.header.Get("X-Nomad-Token"))
+
+		dialExec(ctx, t, srv, allocs[0].ID, "?tty=false")
+		session = nomadSrv.next(t)
+		assert.Equal(t, "false", session.query.Get("tty"))
+		assert.Equal(t, `["/bin/sh"]`, session.query.Get("command"))
+	})
+
+	t.Run("input", func(t *testing.T) {
+		nomadSrv := newScriptedExec(t)
+		allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+		srv := newTestServer(t, nomadSrv.Server)
+
+		conn := dialExec(ctx, t, srv, allocs[0].ID, "")
+		session := nomadSrv.next(t)
+
+		// Stdin travels base64 encoded, as Nomad's byte slices do
+		writeExec(ctx, t, conn, `{"type":"stdin","data":"ls -l\r"}`)
+		assert.Equal(t, map[string]any{"stdin": map[string]any{"data": b64("ls -l\r")}}, session.read(ctx, t))
+
+		writeExec(ctx, t, conn, `{"type":"stdin","data":"été ✓ \u001b[A"}`)
+		assert.Equal(t, map[string]any{"stdin": map[string]any{"data": b64("été ✓ \x1b[A")}}, session.read(ctx, t))
+
+		writeExec(ctx, t, conn, `{"type":"resize","data":{"width":120,"height":40}}`)
+		assert.Equal(t, map[string]any{"tty_size": map[string]any{"width": 120.0, "height": 40.0}}, session.read(ctx, t))
+
+		// Messages Caravan does not understand are dropped, and the session
+		// carries on
+		writeExec(ctx, t, conn, `not json`)
+		writeExec(ctx, t, conn, `{"type":"signal","data":"SIGINT"}`)
+		writeExec(ctx, t, conn, `{"type":"stdin","data":42}`)
+		writeExec(ctx, t, conn, `{"type":"resize","data":"big"}`)
+		require.NoError(t, conn.Write(ctx, websocket.MessageBinary, []byte{0, 1, 2}))
+		writeExec(ctx, t, conn, `{"type":"stdin","data":"x"}`)
+		assert.Equal(t, map[string]any{"stdin": map[string]any{"data": b64("x")}}, session.read(ctx, t))
+	})
+
+	t.Run("output", func(t *testing.T) {
+		nomadSrv := newScriptedExec(t)
+		allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+		srv := newTestServer(t, nomadSrv.Server)
+
+		conn := dialExec(ctx, t, srv, allocs[0].ID, "")
+		session := nomadSrv.next(t)
+
+		session.send(ctx, t, `{"stdout":{"data":"`+b64("total 0\r\n")+`"}}`)
+		assert.Equal(t, execMessage{Type: "stdout", Data: "total 0\r\n"}, readExec(ctx, t, conn))
+
+		session.send(ctx, t, `{"stderr":{"data":"`+b64("\x1b[31mdenied ✗\x1b[0m\n")+`"}}`)
+		assert.Equal(t, execMessage{Type: "stderr", Data: "\x1b[31mdenied ✗\x1b[0m\n"}, readExec(ctx, t, conn))
+
+		// Heartbeats, empty output, stream closes and frames Caravan cannot
+		// parse never reach the browser
+		session.send(ctx, t, `{}`)
+		session.send(ctx, t, `{"stdout":{}}`)
+		session.send(ctx, t, `{"stdout":{"close":true}}`)
+		session.send(ctx, t, `{"stdout":`)
+		session.send(ctx, t, `{"exited":true,"result":{"exit_code":42}}`)
+		assert.Equal(t, execMessage{Type: "exit", ExitCode: 42}, readExec(ctx, t, conn))
+
+		// The exit code ends the session on both sides
+		_, _, err := conn.Read(ctx)
+		assert.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err))
+
+		_, _, err = session.conn.Read(ctx)
+		assert.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err))
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		nomadSrv := newScriptedExec(t)
+		allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+		srv := newTestServer(t, nomadSrv.Server)
+
+		conn := dialExec(ctx, t, srv, allocs[0].ID, "?command=cat&tty=false&encoding=base64")
+		session := nomadSrv.next(t)
+		assert.Equal(t, "false", session.query.Get("tty"))
+
+		// Bytes that are not valid UTF-8 go through both ways unchanged
+		raw := "\x00\xff\xfe\x1f\x8b\r\n\x80"
+		writeExec(ctx, t, conn, `{"type":"stdin","data":"`+b64(raw)+`"}`)
+		assert.Equal(t, map[string]any{"stdin": map[string]any{"data": b64(raw)}}, session.read(ctx, t))
+
+		writeExec(ctx, t, conn, `{"type":"stdin","close":true}`)
+		assert.Equal(t, map[string]any{"stdin": map[string]any{"close": true}}, session.read(ctx, t))
+
+		// Data that is not base64 is dropped
+		writeExec(ctx, t, conn, `{"type":"stdin","data":"not base64!"}`)
+
+		session.send(ctx, t, `{"stdout":{"data":"`+b64(raw)+`"}}`)
+		assert.Equal(t, execMessage{Type: "stdout", Data: b64(raw)}, readExec(ctx, t, conn))
+
+		session.send(ctx, t, `{"stderr":{"data":"`+b64("\xc3")+`"}}`)
+		assert.Equal(t, execMessage{Type: "stderr", Data: b64("\xc3")}, readExec(ctx, t, conn))
+
+		for _, query := range []string{"?encoding=base64", "?tty=true&encoding=base64", "?tty=false&encoding=hex"} {
+			u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/clusters/test/v1/allocation/" + allocs[0].ID + "/exec/web" + query
+			_, resp, err := websocket.Dial(ctx, u, nil)
+			require.Error(t, err, query)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+		}
+	})
+
+	t.Run("exit code zero", func(t *testing.T) {
+		nomadSrv := newScriptedExec(t)
+		allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+		srv := newTestServer(t, nomadSrv.Server)
+
+		conn := dialExec(ctx, t, srv, allocs[0].ID, "")
+		session := nomadSrv.next(t)
+
+		// exit_code is always sent, even when it is zero
+		session.send(ctx, t, `{"exited":true,"result":{"exit_code":0}}`)
+		assert.Equal(t, execMessage{Type: "exit"}, readExec(ctx, t, conn))
+	})
+
+	t.Run("heartbeats", func(t *testing.T) {
+		nomadSrv := newScriptedExec(t)
+		allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+		srv := newTestServer(t, nomadSrv.Server, nomad.WithExecHeartbeat(20*time.Millisecond))
+
+		dialExec(ctx, t, srv, allocs[0].ID, "")
+		session := nomadSrv.next(t)
+
+		for range 3 {
+			assert.Equal(t, map[string]any{}, session.read(ctx, t))
+		}
+	})
+
+	t.Run("nomad disconnects", func(t *testing.T) {
+		nomadSrv := newScriptedExec(t)
+		allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+		srv := newTestServer(t, nomadSrv.Server)
+
+		conn := dialExec(ctx, t, srv, allocs[0].ID, "")
+		session := nomadSrv.next(t)
+
+		session.send(ctx, t, `{"stdout":{"data":"`+b64("$ ")+`"}}`)
+		assert.Equal(t, "$ ", readExec(ctx, t, conn).Data)
+
+		// A dropped connection, without a close frame or an exit code,
+		// still ends the browser's session
+		session.conn.CloseNow()
+
+		_, _, err := conn.Read(ctx)
+		assert.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err))
+	})
+
+	t.Run("browser disconnects", func(t *testing.T) {
+		nomadSrv := newScriptedExec(t)
+		allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+		srv := newTestServer(t, nomadSrv.Server)
+
+		conn := dialExec(ctx, t, srv, allocs[0].ID, "")
+		session := nomadSrv.next(t)
+
+		conn.CloseNow()
+
+		// Nomad's side is closed rather than left running the command
+		_, _, err := session.conn.Read(ctx)
+		require.Error(t, err)
+		assert.Equal(t, websocket.StatusNormalClosure, websocket.CloseStatus(err))
+	})
+
+	t.Run("nomad refuses", func(t *testing.T) {
+		nomadSrv := newScriptedExec(t)
+		nomadSrv.fail = http.StatusInternalServerError
+		allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+		srv := newTestServer(t, nomadSrv.Server)
+
+		conn := dialExec(ctx, t, srv, allocs[0].ID, "")
+
+		msg := readExec(ctx, t, conn)
+		assert.Equal(t, "error", msg.Type)
+		assert.Contains(t, msg.Error, "500")
+		assert.Contains(t, msg.Error, "task not running")
+	})
+
+	t.Run("unknown allocation", func(t *testing.T) {
+		nomadSrv := newScriptedExec(t)
+		srv := newTestServer(t, nomadSrv.Server)
+
+		conn := dialExec(ctx, t, srv, "00000000-0000-0000-0000-000000000000", "")
+
+		msg := readExec(ctx, t, conn)
+		assert.Equal(t, "error", msg.Type)
+		assert.Contains(t, msg.Error, "Failed to get allocation info")
+
+		select {
+		case <-nomadSrv.sessions:
+			t.Fatal("an exec session was opened for an unknown allocation")
+		default:
+		}
+	})
+}
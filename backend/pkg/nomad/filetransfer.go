@@ -0,0 +1,273 @@
+package nomad
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/coder/websocket"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+)
+
+// defaultFileTransferLimit is the largest file uploaded to or downloaded from
+// a task unless WithFileTransferLimit says otherwise
+const defaultFileTransferLimit = 32 << 20
+
+// transferChunkSize is how much stdin is sent to Nomad per exec frame
+const transferChunkSize = 32 << 10
+
+// errTransferTooLarge is returned once a transfer exceeds the size limit
+var errTransferTooLarge = errors.New("file exceeds the transfer size limit")
+
+// WithFileTransferLimit sets the largest file, in bytes, that may be uploaded
+// to or downloaded from a task
+func WithFileTransferLimit(limit int64) Option {
+	return func(h *Handler) {
+		if limit > 0 {
+			h.fileTransferLimit = limit
+		}
+	}
+}
+
+// transferCommand is the command that copies a file, or with archive a
+// directory as a tar stream, in or out of a task. The commands read and
+// write raw bytes; Nomad's exec protocol carries them base64 encoded.
+func transferCommand(upload, archive bool, target string) []string {
+	switch {
+	case upload && archive:
+		return []string{"tar", "-xf", "-", "-C", target}
+	case upload:
+		return []string{"dd", "of=" + target}
+	case archive:
+		return []string{"tar", "-cf", "-", "-C", target, "."}
+	default:
+		return []string{"cat", target}
+	}
+}
+
+// UploadAllocFile handles POST /clusters/{cluster}/v1/allocation/{allocID}/upload/{task}?path=
+// The request body is written to path in the task. With archive=true the body
+// is a tar archive that is extracted into the directory path.
+func (h *Handler) UploadAllocFile(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.fileTransferLimit))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, r, errTransferTooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		writeError(w, r, fmt.Errorf("reading request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	h.transferFile(w, r, true, body)
+}
+
+// DownloadAllocFile handles GET /clusters/{cluster}/v1/allocation/{allocID}/download/{task}?path=
+// Unlike the fs endpoints, which only reach the allocation directory, it reads
+// anywhere in the task's filesystem. With archive=true the directory path is
+// downloaded as a tar archive.
+func (h *Handler) DownloadAllocFile(w http.ResponseWriter, r *http.Request) {
+	h.transferFile(w, r, false, nil)
+}
+
+// transferFile runs a transfer command in the task and writes the result.
+// The output is buffered so a failed command is reported as an error rather
+// than as a truncated file.
+func (h *Handler) transferFile(w http.ResponseWriter, r *http.Request, upload bool, body []byte) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	allocID := r.PathValue("allocID")
+	task := r.PathValue("task")
+	archive := r.URL.Query().Get("archive") == "true"
+
+	target := r.URL.Query().Get("path")
+	if !path.IsAbs(target) {
+		writeError(w, r, errors.New("path must be an absolute path in the task"), http.StatusBadRequest)
+		return
+	}
+	target = path.Clean(target)
+
+	nomadCtx, err := h.configStore.GetContext(clusterName)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	alloc, _, err := client.Allocations().Info(allocID, getQueryOptions(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	command := transferCommand(upload, archive, target)
+	if err := h.execPolicy.Check(command); err != nil {
+		writeError(w, r, err, http.StatusForbidden)
+		return
+	}
+
+	action := "download"
+	if upload {
+		action = "upload"
+	}
+
+	accessor, actor, _ := tokenIdentity(client)
+	entry := AuditEntry{
+		Cluster:       clusterName,
+		Action:        action,
+		Namespace:     alloc.Namespace,
+		Target:        allocID + "/" + task + ":" + target,
+		Actor:         actor,
+		ActorAccessor: accessor,
+	}
+
+	conn, err := h.dialExec(r.Context(), nomadCtx, token, allocID, task, false, command)
+	if err != nil {
+		entry.Error = err.Error()
+		h.audit(r.Context(), entry)
+		writeError(w, r, err, http.StatusBadGateway)
+		return
+	}
+	defer conn.CloseNow()
+
+	var stdout, stderr limitedBuffer
+	stdout.limit = h.fileTransferLimit
+	stderr.limit = 64 << 10
+
+	code, err := runExec(r.Context(), conn, body, &stdout, &stderr)
+	if err == nil && code != 0 {
+		err = fmt.Errorf("%s exited with code %d: %s", command[0], code, strings.TrimSpace(stderr.String()))
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	h.audit(r.Context(), entry)
+
+	switch {
+	case errors.Is(err, errTransferTooLarge):
+		writeError(w, r, err, http.StatusRequestEntityTooLarge)
+		return
+	case err != nil && strings.Contains(stderr.String(), "No such file or directory"):
+		writeError(w, r, err, http.StatusNotFound)
+		return
+	case err != nil:
+		writeError(w, r, err, http.StatusBadGateway)
+		return
+	}
+
+	logger.Log(logger.LevelInfo, map[string]string{
+		"cluster": clusterName,
+		"allocID": allocID,
+		"task":    task,
+		"path":    target,
+		"bytes":   strconv.Itoa(len(body) + stdout.Len()),
+	}, nil, "file "+action+" finished")
+
+	if upload {
+		writeJSON(w, map[string]interface{}{"path": target, "size": len(body)})
+		return
+	}
+
+	name := path.Base(target)
+	contentType := "application/octet-stream"
+	if archive {
+		name += ".tar"
+		contentType = "application/x-tar"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", attachment(name))
+	w.Header().Set("Content-Length", strconv.Itoa(stdout.Len()))
+	w.Write(stdout.Bytes())
+}
+
+// attachment returns the Content-Disposition of a download saved as name.
+// Names that are not printable ASCII are encoded as RFC 2231 allows.
+func attachment(name string) string {
+	for _, c := range name {
+		if c < ' ' || c > '~' {
+			return mime.FormatMediaType("attachment", map[string]string{"filename": name})
+		}
+	}
+
+	return fmt.Sprintf("attachment; filename=%q", name)
+}
+
+// runExec runs a non-interactive exec session: it sends stdin, closes it and
+// collects the output until the command exits, returning its exit code
+func runExec(ctx context.Context, conn *websocket.Conn, stdin []byte, stdout, stderr io.Writer) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		for len(stdin) > 0 {
+			n := min(len(stdin), transferChunkSize)
+			msg, _ := json.Marshal(NomadExecStreamingInput{Stdin: &NomadExecStreamingIOOperation{Data: stdin[:n]}})
+			if err := conn.Write(ctx, websocket.MessageText, msg); err != nil {
+				return
+			}
+			stdin = stdin[n:]
+		}
+
+		msg, _ := json.Marshal(NomadExecStreamingInput{Stdin: &NomadExecStreamingIOOperation{Close: true}})
+		conn.Write(ctx, websocket.MessageText, msg)
+	}()
+
+	for {
+		_, message, err := conn.Read(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("exec session ended before the command exited: %w", err)
+		}
+
+		var output NomadExecStreamingOutput
+		if err := json.Unmarshal(message, &output); err != nil {
+			return 0, fmt.Errorf("parsing exec output: %w", err)
+		}
+
+		if output.Stdout != nil && len(output.Stdout.Data) > 0 {
+			if _, err := stdout.Write(output.Stdout.Data); err != nil {
+				return 0, err
+			}
+		}
+
+		if output.Stderr != nil && len(output.Stderr.Data) > 0 {
+			stderr.Write(output.Stderr.Data)
+		}
+
+		if output.Exited && output.Result != nil {
+			conn.Close(websocket.StatusNormalClosure, "")
+			return output.Result.ExitCode, nil
+		}
+	}
+}
+
+// limitedBuffer is a bytes.Buffer that fails writes past its limit. runExec
+// ignores the failures for stderr, which only loses the end of the message.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if int64(b.Len()+len(p)) > b.limit {
+		return 0, errTransferTooLarge
+	}
+
+	return b.Buffer.Write(p)
+}
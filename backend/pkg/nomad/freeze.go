@@ -0,0 +1,179 @@
+package nomad
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/freeze"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+)
+
+// localWritePaths are mutating cluster endpoints that do not write to Nomad,
+// such as logging in, planning a job or storing Caravan's own data about a
+// cluster
+var localWritePaths = []string{
+	"/v1/auth/",
+	"/v1/job/plan",
+	"/v1/job/preflight",
+	"/v1/acl/oidc/",
+	"/v1/job/version/annotation",
+	"/v1/event/forward",
+}
+
+// WithFreezeSchedule blocks changes to clusters during the schedule's freeze windows
+func WithFreezeSchedule(s *freeze.Schedule) Option {
+	return func(h *Handler) {
+		h.freeze = s
+	}
+}
+
+// FreezeMiddleware rejects mutating cluster requests with 423 Locked while a
+// freeze window applies to the cluster and namespace, unless the token is a
+// management token or holds one of the schedule's override policies
+func (h *Handler) FreezeMiddleware(next http.Handler) http.Handler {
+	if h.freeze == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cluster, rest, ok := splitClusterPath(r.URL.Path)
+		if !ok || !isMutating(r.Method) || isLocalWrite(rest) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A job names its namespace in the body, whatever the query says, and
+		// those that do not go to the query's
+		namespaces, unnamed, err := peekBodyNamespaces(r, rest)
+		if err != nil {
+			writeError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if ns := r.URL.Query().Get("namespace"); ns != "" || unnamed {
+			namespaces = append(namespaces, namespaceOrDefault(ns))
+		}
+
+		var namespace string
+		var active []freeze.Occurrence
+		for _, namespace = range namespaces {
+			if active = h.activeFreeze(cluster, namespace, getTokenForCluster(r, cluster)); len(active) > 0 {
+				break
+			}
+		}
+		if len(active) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logger.Log(logger.LevelInfo, map[string]string{
+			"cluster":   cluster,
+			"namespace": namespace,
+			"window":    active[0].Name,
+			"path":      r.URL.Path,
+		}, nil, "blocked change during freeze window")
+
+		writeFrozen(w, r, cluster, active)
+	})
+}
+
+// activeFreeze returns the freeze windows that block the token from changing
+// the cluster and namespace right now
+func (h *Handler) activeFreeze(cluster, namespace, token string) []freeze.Occurrence {
+	active := h.freeze.Active(cluster, namespace, time.Now())
+	if len(active) == 0 || h.canOverrideFreeze(cluster, token) {
+		return nil
+	}
+
+	return active
+}
+
+func writeFrozen(w http.ResponseWriter, r *http.Request, cluster string, active []freeze.Occurrence) {
+	apierror.Write(w, r, apierror.New(http.StatusLocked, apierror.CodeChangeFrozen,
+		"changes are frozen: "+active[0].Name).
+		WithCluster(cluster).
+		WithDetails(active))
+}
+
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return "default"
+	}
+
+	return namespace
+}
+
+// FreezeWindows returns the freeze windows that are active or start within
+// the given period, for the UI to warn about
+func (h *Handler) FreezeWindows(within time.Duration) []freeze.Occurrence {
+	return h.freeze.Upcoming(time.Now(), within)
+}
+
+// canOverrideFreeze reports whether the token may make changes during a freeze
+func (h *Handler) canOverrideFreeze(cluster, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	client, err := h.GetClientWithToken(cluster, token)
+	if err != nil {
+		return false
+	}
+
+	self, _, err := client.ACLTokens().Self(nil)
+	if err != nil || self == nil {
+		return false
+	}
+
+	if self.Type == "management" {
+		return true
+	}
+
+	for _, policy := range self.Policies {
+		if slices.Contains(h.freeze.OverridePolicies(), policy) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitClusterPath splits /api/clusters/{cluster}/rest, which can be
+// prefixed by the base URL, into the cluster name and the rest of the path.
+// The mux has not matched the route yet, so PathValue is not available
+func splitClusterPath(path string) (cluster, rest string, ok bool) {
+	const prefix = "/api/clusters/"
+
+	i := strings.Index(path, prefix)
+	if i < 0 {
+		return "", "", false
+	}
+
+	cluster, rest, ok = strings.Cut(path[i+len(prefix):], "/")
+	if !ok || cluster == "" {
+		return "", "", false
+	}
+
+	return cluster, "/" + rest, true
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func isLocalWrite(path string) bool {
+	for _, local := range localWritePaths {
+		if strings.HasPrefix(path, local) {
+			return true
+		}
+	}
+
+	return false
+}
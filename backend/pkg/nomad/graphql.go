@@ -0,0 +1,482 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/graphql"
+	"github.com/caravan-nomad/caravan/backend/pkg/nsscope"
+)
+
+const (
+	// graphQLConcurrency bounds the Nomad calls a request makes in parallel.
+	graphQLConcurrency = 8
+	// graphQLMaxResolverCalls bounds the Nomad calls a request makes in all,
+	// such as those of jobs { allocations { … } } over a large cluster.
+	graphQLMaxResolverCalls = 200
+)
+
+type graphQLContextKey int
+
+const (
+	graphQLRequestKey graphQLContextKey = iota
+	graphQLClientKey
+	graphQLScopeKey
+)
+
+// graphQLCluster is the source value of the Cluster type.
+type graphQLCluster struct {
+	Name string
+}
+
+// GraphQL handles POST /api/graphql
+//
+// The root Query type exposes the configured clusters; everything below a
+// cluster is fetched from Nomad only when it is selected, with the token of
+// the request for that cluster.
+func (h *Handler) GraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphql.Request
+
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				writeError(w, r, fmt.Errorf("invalid variables: %w", err), http.StatusBadRequest)
+				return
+			}
+		}
+	default:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.Query == "" {
+		writeError(w, r, fmt.Errorf("query is required"), http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), graphQLRequestKey, r)
+
+	writeJSON(w, h.graphQLSchema().Execute(ctx, req))
+}
+
+// graphQLSchema returns the schema, building it on first use.
+func (h *Handler) graphQLSchema() *graphql.Schema {
+	h.graphQLOnce.Do(func() {
+		h.graphQL = h.buildGraphQLSchema()
+	})
+
+	return h.graphQL
+}
+
+func (h *Handler) buildGraphQLSchema() *graphql.Schema {
+	clusterType := graphql.NewObject("Cluster")
+	jobType := graphql.NewObject("Job")
+	allocType := graphql.NewObject("Allocation")
+	nodeType := graphql.NewObject("Node")
+	deploymentType := graphql.NewObject("Deployment")
+	evalType := graphql.NewObject("Evaluation")
+
+	query := graphql.NewObject("Query").
+		AddField("clusters", &graphql.FieldDef{
+			Type: clusterType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				clusters := []interface{}{}
+				for _, c := range h.configStore.GetContexts() {
+					scoped, err := h.graphQLClusterScope(ctx, c.Name)
+					if err != nil {
+						return nil, err
+					}
+
+					clusters = append(clusters, scoped)
+				}
+
+				return clusters, nil
+			},
+		}).
+		AddField("cluster", &graphql.FieldDef{
+			Type: clusterType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				name, err := stringArg(p, "name", true)
+				if err != nil {
+					return nil, err
+				}
+
+				return h.graphQLClusterScope(ctx, name)
+			},
+		})
+
+	clusterType.
+		AddField("jobs", &graphql.FieldDef{
+			Type: jobType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				jobs, _, err := graphQLClient(ctx).Jobs().List(graphQLQueryOptions(p))
+				return jobs, err
+			},
+		}).
+		AddField("job", &graphql.FieldDef{
+			Type: jobType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				id, err := stringArg(p, "id", true)
+				if err != nil {
+					return nil, err
+				}
+
+				job, _, err := graphQLClient(ctx).Jobs().Info(id, graphQLQueryOptions(p))
+				return job, err
+			},
+		}).
+		AddField("allocations", &graphql.FieldDef{
+			Type: allocType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				allocs, _, err := graphQLClient(ctx).Allocations().List(graphQLQueryOptions(p))
+				return allocs, err
+			},
+		}).
+		AddField("allocation", &graphql.FieldDef{
+			Type: allocType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				id, err := stringArg(p, "id", true)
+				if err != nil {
+					return nil, err
+				}
+
+				alloc, _, err := graphQLClient(ctx).Allocations().Info(id, graphQLQueryOptions(p))
+				return alloc, err
+			},
+		}).
+		AddField("nodes", &graphql.FieldDef{
+			Type: nodeType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				nodes, _, err := graphQLClient(ctx).Nodes().List(graphQLQueryOptions(p))
+				return nodes, err
+			},
+		}).
+		AddField("node", &graphql.FieldDef{
+			Type: nodeType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				id, err := stringArg(p, "id", true)
+				if err != nil {
+					return nil, err
+				}
+
+				node, _, err := graphQLClient(ctx).Nodes().Info(id, graphQLQueryOptions(p))
+				return node, err
+			},
+		}).
+		AddField("deployments", &graphql.FieldDef{
+			Type: deploymentType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				deployments, _, err := graphQLClient(ctx).Deployments().List(graphQLQueryOptions(p))
+				return deployments, err
+			},
+		}).
+		AddField("deployment", &graphql.FieldDef{
+			Type: deploymentType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				id, err := stringArg(p, "id", true)
+				if err != nil {
+					return nil, err
+				}
+
+				deployment, _, err := graphQLClient(ctx).Deployments().Info(id, graphQLQueryOptions(p))
+				return deployment, err
+			},
+		})
+
+	jobType.
+		AddField("allocations", &graphql.FieldDef{
+			Type: allocType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				id, ns := graphQLJobRef(p.Source)
+				allocs, _, err := graphQLClient(ctx).Jobs().Allocations(id, false, &api.QueryOptions{Namespace: ns})
+				return allocs, err
+			},
+		}).
+		AddField("evaluations", &graphql.FieldDef{
+			Type: evalType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				id, ns := graphQLJobRef(p.Source)
+				evals, _, err := graphQLClient(ctx).Jobs().Evaluations(id, &api.QueryOptions{Namespace: ns})
+				return evals, err
+			},
+		}).
+		AddField("deployments", &graphql.FieldDef{
+			Type: deploymentType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				id, ns := graphQLJobRef(p.Source)
+				deployments, _, err := graphQLClient(ctx).Jobs().Deployments(id, false, &api.QueryOptions{Namespace: ns})
+				return deployments, err
+			},
+		}).
+		AddField("latestDeployment", &graphql.FieldDef{
+			Type: deploymentType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				id, ns := graphQLJobRef(p.Source)
+				deployment, _, err := graphQLClient(ctx).Jobs().LatestDeployment(id, &api.QueryOptions{Namespace: ns})
+				return deployment, err
+			},
+		}).
+		AddField("summary", &graphql.FieldDef{
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				id, ns := graphQLJobRef(p.Source)
+				summary, _, err := graphQLClient(ctx).Jobs().Summary(id, &api.QueryOptions{Namespace: ns})
+				return summary, err
+			},
+		})
+
+	allocType.
+		AddField("job", &graphql.FieldDef{
+			Type: jobType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				ref := graphQLAllocRef(p.Source)
+				job, _, err := graphQLClient(ctx).Jobs().Info(ref.JobID, &api.QueryOptions{Namespace: ref.Namespace})
+				return job, err
+			},
+		}).
+		AddField("node", &graphql.FieldDef{
+			Type: nodeType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				node, _, err := graphQLClient(ctx).Nodes().Info(graphQLAllocRef(p.Source).NodeID, nil)
+				return node, err
+			},
+		}).
+		AddField("deployment", &graphql.FieldDef{
+			Type: deploymentType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				ref := graphQLAllocRef(p.Source)
+				if _, isStub := p.Source.(api.AllocationListStub); isStub {
+					alloc, _, err := graphQLClient(ctx).Allocations().Info(ref.ID, &api.QueryOptions{Namespace: ref.Namespace})
+					if err != nil {
+						return nil, err
+					}
+
+					ref.DeploymentID = alloc.DeploymentID
+				}
+
+				if ref.DeploymentID == "" {
+					return nil, nil
+				}
+
+				deployment, _, err := graphQLClient(ctx).Deployments().Info(ref.DeploymentID,
+					&api.QueryOptions{Namespace: ref.Namespace})
+				return deployment, err
+			},
+		})
+
+	nodeType.
+		AddField("allocations", &graphql.FieldDef{
+			Type: allocType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				var id string
+				switch n := p.Source.(type) {
+				case api.NodeListStub:
+					id = n.ID
+				case api.Node:
+					id = n.ID
+				}
+
+				allocs, _, err := graphQLClient(ctx).Nodes().Allocations(id, nil)
+				return allocs, err
+			},
+		})
+
+	deploymentType.
+		AddField("job", &graphql.FieldDef{
+			Type: jobType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				d := p.Source.(api.Deployment)
+				job, _, err := graphQLClient(ctx).Jobs().Info(d.JobID, &api.QueryOptions{Namespace: d.Namespace})
+				return job, err
+			},
+		}).
+		AddField("allocations", &graphql.FieldDef{
+			Type: allocType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				d := p.Source.(api.Deployment)
+				allocs, _, err := graphQLClient(ctx).Deployments().Allocations(d.ID, &api.QueryOptions{Namespace: d.Namespace})
+				return allocs, err
+			},
+		})
+
+	evalType.
+		AddField("job", &graphql.FieldDef{
+			Type: jobType,
+			Resolve: func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+				e := p.Source.(api.Evaluation)
+				job, _, err := graphQLClient(ctx).Jobs().Info(e.JobID, &api.QueryOptions{Namespace: e.Namespace})
+				return job, err
+			},
+		})
+
+	// Scoped tokens only get the objects of their namespaces, whichever
+	// field they are reached through
+	for _, object := range []*graphql.Object{clusterType, jobType, allocType, nodeType, deploymentType, evalType} {
+		for _, def := range object.Fields {
+			def.Resolve = scopedGraphQLResolve(def.Resolve)
+		}
+	}
+
+	return &graphql.Schema{
+		Query:            query,
+		Concurrency:      graphQLConcurrency,
+		MaxResolverCalls: graphQLMaxResolverCalls,
+	}
+}
+
+// graphQLClusterScope creates the client for a cluster and scopes it, with
+// the namespace scope of the token, to the fields selected below that cluster.
+func (h *Handler) graphQLClusterScope(ctx context.Context, name string) (interface{}, error) {
+	r, _ := ctx.Value(graphQLRequestKey).(*http.Request)
+	token := getTokenForCluster(r, name)
+
+	client, err := h.GetClientWithToken(name, token)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = context.WithValue(ctx, graphQLClientKey, client)
+	ctx = context.WithValue(ctx, graphQLScopeKey, h.NamespaceScope(name, token))
+
+	return graphql.Scoped(ctx, graphQLCluster{Name: name}), nil
+}
+
+// scopedGraphQLResolve enforces the namespace scope of the cluster on a
+// resolver, like NamespaceScopeMiddleware does on the Nomad API: a namespace
+// argument outside of the scope is refused, objects of other namespaces are
+// dropped from lists and a single such object is refused.
+func scopedGraphQLResolve(resolve graphql.ResolveFunc) graphql.ResolveFunc {
+	return func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+		scope, ok := ctx.Value(graphQLScopeKey).(nsscope.Scope)
+		if !ok || scope.Unrestricted() {
+			return resolve(ctx, p)
+		}
+
+		// Reads of all namespaces are filtered below
+		if ns, _ := p.Args["namespace"].(string); ns != "" && ns != "*" && !scope.Allows(ns) {
+			return nil, fmt.Errorf("namespace %q is outside of your scope", ns)
+		}
+
+		value, err := resolve(ctx, p)
+		if err != nil || value == nil {
+			return value, err
+		}
+
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Slice {
+			if ns, ok := graphQLNamespace(v); ok && !scope.Allows(ns) {
+				return nil, fmt.Errorf("namespace %q is outside of your scope", namespaceOrDefault(ns))
+			}
+
+			return value, nil
+		}
+
+		kept := reflect.MakeSlice(v.Type(), 0, v.Len())
+		for i := range v.Len() {
+			if ns, ok := graphQLNamespace(v.Index(i)); ok && !scope.Allows(ns) {
+				continue
+			}
+			kept = reflect.Append(kept, v.Index(i))
+		}
+
+		return kept.Interface(), nil
+	}
+}
+
+// graphQLNamespace returns the namespace of a Nomad object, if it has one.
+func graphQLNamespace(v reflect.Value) (string, bool) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", false
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return "", false
+	}
+
+	f := v.FieldByName("Namespace")
+	if f.Kind() == reflect.Pointer {
+		if f.IsNil() {
+			return "", true
+		}
+		f = f.Elem()
+	}
+
+	if f.Kind() != reflect.String {
+		return "", false
+	}
+
+	return f.String(), true
+}
+
+func graphQLClient(ctx context.Context) NomadAPI {
+	return ctx.Value(graphQLClientKey).(NomadAPI)
+}
+
+// graphQLQueryOptions builds query options from the namespace, region and
+// prefix arguments of a field.
+func graphQLQueryOptions(p graphql.ResolveParams) *api.QueryOptions {
+	opts := &api.QueryOptions{}
+	opts.Namespace, _ = p.Args["namespace"].(string)
+	opts.Region, _ = p.Args["region"].(string)
+	opts.Prefix, _ = p.Args["prefix"].(string)
+
+	return opts
+}
+
+func stringArg(p graphql.ResolveParams, name string, required bool) (string, error) {
+	v, ok := p.Args[name].(string)
+	if required && (!ok || v == "") {
+		return "", fmt.Errorf("argument %q is required", name)
+	}
+
+	return v, nil
+}
+
+// graphQLJobRef returns the ID and namespace of a job list stub or job.
+func graphQLJobRef(source interface{}) (string, string) {
+	switch j := source.(type) {
+	case api.JobListStub:
+		return j.ID, j.Namespace
+	case api.Job:
+		if j.ID == nil || j.Namespace == nil {
+			return "", ""
+		}
+
+		return *j.ID, *j.Namespace
+	}
+
+	return "", ""
+}
+
+// allocRef holds the references of an allocation list stub or allocation.
+// List stubs do not carry the deployment ID.
+type allocRef struct {
+	ID           string
+	JobID        string
+	Namespace    string
+	NodeID       string
+	DeploymentID string
+}
+
+func graphQLAllocRef(source interface{}) allocRef {
+	switch a := source.(type) {
+	case api.AllocationListStub:
+		return allocRef{ID: a.ID, JobID: a.JobID, Namespace: a.Namespace, NodeID: a.NodeID}
+	case api.Allocation:
+		return allocRef{ID: a.ID, JobID: a.JobID, Namespace: a.Namespace, NodeID: a.NodeID, DeploymentID: a.DeploymentID}
+	}
+
+	return allocRef{}
+}
@@ -1,41 +1,187 @@
 package nomad
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/coder/websocket"
 	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
 	"github.com/caravan-nomad/caravan/backend/pkg/auth"
+	"github.com/caravan-nomad/caravan/backend/pkg/eventbridge"
+	"github.com/caravan-nomad/caravan/backend/pkg/eventbus"
+	"github.com/caravan-nomad/caravan/backend/pkg/execpolicy"
+	"github.com/caravan-nomad/caravan/backend/pkg/forwarded"
+	"github.com/caravan-nomad/caravan/backend/pkg/freeze"
+	"github.com/caravan-nomad/caravan/backend/pkg/graphql"
+	"github.com/caravan-nomad/caravan/backend/pkg/killswitch"
+	"github.com/caravan-nomad/caravan/backend/pkg/lint"
 	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
+	"github.com/caravan-nomad/caravan/backend/pkg/nsscope"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+	"github.com/caravan-nomad/caravan/backend/pkg/streams"
 )
 
 // Handler provides HTTP handlers for Nomad API endpoints
 type Handler struct {
 	configStore nomadconfig.ContextStore
-	clients     map[string]*api.Client
+	clients     map[string]*pooledClient
 	mutex       sync.RWMutex
+	// newClient creates the Nomad clients of the handler
+	newClient ClientFactory
+	// wsCompression is the compression mode negotiated on exec WebSockets
+	wsCompression websocket.CompressionMode
+	// execHeartbeat is how often idle exec sessions ping Nomad
+	execHeartbeat time.Duration
+	// streamWriteTimeout is how long a write to a stream's client may block
+	streamWriteTimeout time.Duration
+	// eventBridge runs the event forwards configured through the API
+	eventBridge *eventbridge.Bridge
+	// events shares each cluster's event stream between the handler's caches
+	events      *eventbus.Bus
+	graphQL     *graphql.Schema
+	graphQLOnce sync.Once
+	// store persists data Caravan keeps about the clusters, such as deployment history
+	store store.Store
+	// freeze is the schedule of change freezes, nil if changes are never frozen
+	freeze *freeze.Schedule
+	// requireApprovals holds destructive actions until a second user approves them
+	requireApprovals bool
+	approvalsMutex   sync.Mutex
+	// dryRun answers mutating requests without writing to Nomad
+	dryRun bool
+	// responseCache caches hot list responses, nil if caching is disabled
+	responseCache *responseCache
+	// execPolicy restricts what may be exec'd into allocations, nil allows all
+	execPolicy *execpolicy.Policy
+	// fileTransferLimit is the largest file uploaded to or downloaded from a task
+	fileTransferLimit int64
+	// linter holds the rules jobs are linted with, nil applies the built-in ones
+	linter *lint.Linter
+	// evalChurn counts the evaluations of each job while TrackEvaluations runs
+	evalChurn *evalChurnTracker
+	// vaultMutex serializes updates of the token vaults
+	vaultMutex sync.Mutex
+	// ssoSessions are the OIDC sign-ons in progress, by session ID
+	ssoMutex    sync.Mutex
+	ssoSessions map[string]*ssoSession
+	// bootstrapConfirmations are the ACL bootstraps waiting to be confirmed,
+	// by confirmation token
+	bootstrapMutex         sync.Mutex
+	bootstrapConfirmations map[string]aclBootstrapConfirmation
+	// setupMutex serializes the setup wizard's writes, which may only happen once
+	setupMutex sync.Mutex
+	// scopes limit the namespaces tokens may use, nil leaves it to their ACLs
+	scopes     *nsscope.Scopes
+	scopeMutex sync.Mutex
+	scopeCache map[scopeCacheKey]cachedScope
+	// logins throttles clients and clusters failing to log in
+	logins *loginGuard
+	// aclCache remembers token and policy lookups for a short time
+	aclCache *aclCache
+	// deploymentWatcher tracks the deployments of watched jobs while WatchDeployments runs
+	deploymentWatcher *deploymentWatcher
+	// shareLinkKeyCache is the key share links are signed with, once read from the store
+	shareLinkMutex    sync.Mutex
+	shareLinkKeyCache []byte
+	// announcementListener is told the active announcements when they change
+	announcementListener func([]Announcement)
+	// displayLocation is the time zone times are shown in, nil for the browser's
+	displayLocation *time.Location
+	// listLimits bound the size of list responses
+	listLimits ListLimits
+	// streams are the open SSE streams and WebSockets
+	streams *streams.Registry
+	// killSwitches turn expensive features off at runtime
+	killSwitches *killswitch.Set
 }
 
+// Option configures optional Handler behaviour
+type Option func(*Handler)
+
+// WithWSCompression enables permessage-deflate on the exec WebSocket, both
+// towards the browser and towards Nomad
+func WithWSCompression(enabled bool) Option {
+	return func(h *Handler) {
+		if enabled {
+			h.wsCompression = websocket.CompressionContextTakeover
+		}
+	}
+}
+
+// WithStore sets the store Caravan's own data is persisted in. Without it
+// the handler keeps that data in memory
+func WithStore(s store.Store) Option {
+	return func(h *Handler) {
+		h.store = s
+	}
+}
+
+// SecretBuckets are the buckets of the store holding secrets, such as the
+// token vaults, the share link signing key and the OIDC client secret, which
+// an encrypted store encrypts
+var SecretBuckets = []string{tokenVaultBucket, shareLinksBucket, setupBucket}
+
 // NewHandler creates a new Nomad handler
-func NewHandler(configStore nomadconfig.ContextStore) *Handler {
-	return &Handler{
-		configStore: configStore,
-		clients:     make(map[string]*api.Client),
+func NewHandler(configStore nomadconfig.ContextStore, opts ...Option) *Handler {
+	h := &Handler{
+		configStore:   configStore,
+		clients:       make(map[string]*pooledClient),
+		newClient:     sdkClientFactory,
+		wsCompression: websocket.CompressionDisabled,
+		execHeartbeat: defaultExecHeartbeat,
+		eventBridge:   eventbridge.New(),
+		store:         store.NewMemory(),
+		evalChurn:     newEvalChurnTracker(),
+		logins:        newLoginGuard(),
+		aclCache:      newACLCache(),
+		streams:       streams.NewRegistry(),
+
+		deploymentWatcher:  newDeploymentWatcher(),
+		fileTransferLimit:  defaultFileTransferLimit,
+		streamWriteTimeout: defaultStreamWriteTimeout,
+	}
+
+	h.events = eventbus.New(h.streamEvents)
+	// New fails only on unknown features, and none are disabled here
+	h.killSwitches, _ = killswitch.New(nil)
+
+	for _, opt := range opts {
+		opt(h)
 	}
+
+	return h
+}
+
+// streamEvents opens the event stream of a cluster for the event bus, with
+// the cluster's own token
+func (h *Handler) streamEvents(ctx context.Context, cluster string, topics map[api.Topic][]string,
+	index uint64,
+) (<-chan *api.Events, error) {
+	client, err := h.GetClient(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.EventStream().Stream(ctx, topics, index, &api.QueryOptions{Namespace: "*"})
 }
 
 // GetClient returns a Nomad client for the given cluster
 // It caches clients for reuse
-func (h *Handler) GetClient(clusterName string) (*api.Client, error) {
+func (h *Handler) GetClient(clusterName string) (NomadAPI, error) {
 	h.mutex.RLock()
-	client, exists := h.clients[clusterName]
+	pooled, exists := h.clients[clusterName]
 	h.mutex.RUnlock()
 
 	if exists {
-		return client, nil
+		pooled.uses.Add(1)
+		return pooled.client, nil
 	}
 
 	ctx, err := h.configStore.GetContext(clusterName)
@@ -43,13 +189,17 @@ func (h *Handler) GetClient(clusterName string) (*api.Client, error) {
 		return nil, err
 	}
 
-	client, err = ctx.GetClient()
+	client, err := h.newClient(ctx, "")
 	if err != nil {
 		return nil, err
 	}
+	client = h.withResponseCache(client, clusterName, "")
+
+	pooled = &pooledClient{client: client, createdAt: time.Now()}
+	pooled.uses.Add(1)
 
 	h.mutex.Lock()
-	h.clients[clusterName] = client
+	h.clients[clusterName] = pooled
 	h.mutex.Unlock()
 
 	return client, nil
@@ -57,20 +207,30 @@ func (h *Handler) GetClient(clusterName string) (*api.Client, error) {
 
 // GetClientWithToken returns a Nomad client configured with the given token
 // This does not cache the client as tokens may vary per request
-func (h *Handler) GetClientWithToken(clusterName, token string) (*api.Client, error) {
+func (h *Handler) GetClientWithToken(clusterName, token string) (NomadAPI, error) {
 	ctx, err := h.configStore.GetContext(clusterName)
 	if err != nil {
 		return nil, err
 	}
 
-	return ctx.GetClientWithToken(token)
+	client, err := h.newClient(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.withResponseCache(h.withACLCache(client, clusterName, token), clusterName, token), nil
 }
 
 // InvalidateClient removes a cached client for the given cluster
 func (h *Handler) InvalidateClient(clusterName string) {
 	h.mutex.Lock()
-	defer h.mutex.Unlock()
 	delete(h.clients, clusterName)
+	h.mutex.Unlock()
+
+	if h.responseCache != nil {
+		h.responseCache.forget(clusterName)
+	}
+	h.aclCache.forget(clusterName)
 }
 
 // getClusterName extracts the cluster name from the request using Go 1.22+ PathValue
@@ -80,6 +240,28 @@ func getClusterName(r *http.Request) string {
 
 // getToken extracts the Nomad token from the request header, query param, or cookie
 func getToken(r *http.Request) string {
+	return getTokenForCluster(r, getClusterName(r))
+}
+
+// getTokenForCluster extracts the Nomad token for the given cluster, for
+// endpoints that are not scoped to a single cluster by their path
+func getTokenForCluster(r *http.Request, cluster string) string {
+	if r == nil {
+		return ""
+	}
+
+	// An admin acting as someone else uses the scoped token, whichever way
+	// they send their own
+	if token := impersonationToken(r, cluster); token != "" {
+		return token
+	}
+
+	return ownToken(r, cluster)
+}
+
+// ownToken extracts the token of the user for the given cluster, ignoring
+// any impersonation
+func ownToken(r *http.Request, cluster string) string {
 	// Try X-Nomad-Token header first
 	if token := r.Header.Get("X-Nomad-Token"); token != "" {
 		return token
@@ -91,14 +273,14 @@ func getToken(r *http.Request) string {
 	}
 
 	// Fall back to HTTPOnly cookie
-	cluster := getClusterName(r)
 	if cluster != "" {
 		if token, err := auth.GetTokenFromCookie(r, cluster); err == nil && token != "" {
 			return token
 		}
 	}
 
-	return ""
+	// Last, the token registered for the cluster in the browser's vault
+	return vaultToken(r.Context(), cluster)
 }
 
 // writeJSON writes a JSON response
@@ -109,36 +291,75 @@ func writeJSON(w http.ResponseWriter, data interface{}) {
 	}
 }
 
-// writeError writes an error response
-func writeError(w http.ResponseWriter, err error, status int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+// writeError writes an error response, deriving the error code from the status
+func writeError(w http.ResponseWriter, r *http.Request, err error, status int) {
+	var apiErr *apierror.Error
+	if !errors.As(err, &apiErr) {
+		apiErr = apierror.FromStatus(status, err)
+	}
+
+	apierror.Write(w, r, apiErr.WithCluster(getClusterName(r)))
 }
 
 // writeNomadError writes an error response with proper status code detection from Nomad errors
-// This examines the error message to determine the appropriate HTTP status code
-func writeNomadError(w http.ResponseWriter, err error) {
+// This examines the error to determine the appropriate HTTP status code and error code
+func writeNomadError(w http.ResponseWriter, r *http.Request, err error) {
+	apierror.Write(w, r, classifyNomadError(err).WithCluster(getClusterName(r)))
+}
+
+// classifyNomadError maps an error returned while talking to Nomad to an API error.
+// The upstream status is taken from the Nomad SDK error when available, falling
+// back to inspecting the error message.
+func classifyNomadError(err error) *apierror.Error {
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
 	errStr := err.Error()
-	var status int
+
+	if errors.Is(err, nomadconfig.ErrContextNotFound) {
+		return apierror.New(http.StatusNotFound, apierror.CodeClusterNotFound, errStr)
+	}
+
+	upstreamStatus := 0
+
+	var unexpected api.UnexpectedResponseError
+	if errors.As(err, &unexpected) && unexpected.HasStatusCode() {
+		upstreamStatus = unexpected.StatusCode()
+	}
+
+	var e *apierror.Error
 
 	switch {
-	case contains403(errStr):
-		status = http.StatusForbidden // 403
-	case contains401(errStr):
-		status = http.StatusUnauthorized // 401
-	case strings.Contains(errStr, "not found") || strings.Contains(errStr, "Unknown"):
-		status = http.StatusNotFound // 404
+	case strings.Contains(errStr, "ACL token expired"):
+		e = apierror.New(http.StatusForbidden, apierror.CodeTokenExpired, errStr)
+	case strings.Contains(errStr, "ACL token not found"):
+		e = apierror.New(http.StatusForbidden, apierror.CodeTokenInvalid, errStr)
+	case upstreamStatus == http.StatusForbidden || contains403(errStr):
+		e = apierror.New(http.StatusForbidden, apierror.CodePermissionDenied, errStr)
+	case upstreamStatus == http.StatusUnauthorized || contains401(errStr):
+		e = apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, errStr)
+	case upstreamStatus == http.StatusNotFound ||
+		strings.Contains(errStr, "not found") || strings.Contains(errStr, "Unknown"):
+		e = apierror.New(http.StatusNotFound, apierror.CodeNotFound, errStr)
+	case upstreamStatus == http.StatusBadRequest:
+		e = apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, errStr)
 	case containsConnectionError(errStr):
-		status = http.StatusBadGateway // 502
+		e = apierror.New(http.StatusBadGateway, apierror.CodeNomadUnreachable, errStr)
 	default:
-		status = http.StatusInternalServerError // 500
+		e = apierror.New(http.StatusInternalServerError, apierror.CodeInternal, errStr)
 	}
 
-	writeError(w, err, status)
+	if upstreamStatus != 0 {
+		e.WithUpstreamStatus(upstreamStatus)
+	}
+
+	return e
 }
 
-// getQueryOptions extracts common query options from the request
+// getQueryOptions extracts common query options from the request. They carry
+// its context, so that the calls are cancelled with it and timed.
 func getQueryOptions(r *http.Request) *api.QueryOptions {
 	q := r.URL.Query()
 	opts := &api.QueryOptions{}
@@ -153,10 +374,11 @@ func getQueryOptions(r *http.Request) *api.QueryOptions {
 		opts.Prefix = prefix
 	}
 
-	return opts
+	return opts.WithContext(r.Context())
 }
 
-// getWriteOptions extracts common write options from the request
+// getWriteOptions extracts common write options from the request, carrying
+// its context like getQueryOptions
 func getWriteOptions(r *http.Request) *api.WriteOptions {
 	q := r.URL.Query()
 	opts := &api.WriteOptions{}
@@ -168,7 +390,7 @@ func getWriteOptions(r *http.Request) *api.WriteOptions {
 		opts.Region = region
 	}
 
-	return opts
+	return opts.WithContext(r.Context())
 }
 
 // AuthHandler provides auth-related HTTP handlers
@@ -191,26 +413,30 @@ type LoginRequest struct {
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	cluster := getClusterName(r)
 	if cluster == "" {
-		writeError(w, fmt.Errorf("cluster name is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("cluster name is required"), http.StatusBadRequest)
 		return
 	}
 
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, fmt.Errorf("invalid request body"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("invalid request body"), http.StatusBadRequest)
 		return
 	}
 
 	if req.Token == "" {
-		writeError(w, fmt.Errorf("token is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("token is required"), http.StatusBadRequest)
 		return
 	}
 
 	// Validate the token by calling ACL self endpoint
 	if h.nomadHandler != nil {
+		if !h.nomadHandler.allowLogin(w, r, cluster) {
+			return
+		}
+
 		client, err := h.nomadHandler.GetClientWithToken(cluster, req.Token)
 		if err != nil {
-			writeError(w, fmt.Errorf("failed to create client: %v", err), http.StatusInternalServerError)
+			writeNomadError(w, r, fmt.Errorf("failed to create client: %w", err))
 			return
 		}
 
@@ -218,46 +444,52 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		tokenInfo, _, err := client.ACLTokens().Self(nil)
 		if err != nil {
 			// Token is invalid
-			writeNomadError(w, fmt.Errorf("invalid token: %v", err))
+			h.nomadHandler.loginFailed(r, cluster, err)
+			writeNomadError(w, r, fmt.Errorf("invalid token: %w", err))
 			return
 		}
+		h.nomadHandler.loginSucceeded(r)
 
 		// Token is valid, set the cookie
-		auth.SetTokenCookie(w, r, cluster, req.Token, h.baseURL)
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": "ok",
-			"token": map[string]interface{}{
-				"name":       tokenInfo.Name,
-				"type":       tokenInfo.Type,
-				"policies":   tokenInfo.Policies,
-				"global":     tokenInfo.Global,
-				"createTime": tokenInfo.CreateTime,
-			},
-		})
+		auth.SetTokenCookie(w, r, cluster, req.Token, forwarded.BasePath(r, h.baseURL))
+		writeLoginResponse(w, tokenInfo)
 		return
 	}
 
 	// Fallback if nomadHandler is not set (shouldn't happen in production)
-	auth.SetTokenCookie(w, r, cluster, req.Token, h.baseURL)
+	auth.SetTokenCookie(w, r, cluster, req.Token, forwarded.BasePath(r, h.baseURL))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
+// writeLoginResponse describes the token a session was opened with
+func writeLoginResponse(w http.ResponseWriter, token *api.ACLToken) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"token": map[string]interface{}{
+			"name":       token.Name,
+			"type":       token.Type,
+			"policies":   token.Policies,
+			"global":     token.Global,
+			"createTime": token.CreateTime,
+		},
+	})
+}
+
 // Logout handles user logout by clearing the HTTPOnly cookie
 func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	cluster := getClusterName(r)
 	if cluster == "" {
-		writeError(w, fmt.Errorf("cluster name is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("cluster name is required"), http.StatusBadRequest)
 		return
 	}
 
 	// Clear the HTTPOnly cookie
-	auth.ClearTokenCookie(w, r, cluster, h.baseURL)
+	auth.ClearTokenCookie(w, r, cluster, forwarded.BasePath(r, h.baseURL))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -268,7 +500,7 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 func (h *AuthHandler) CheckAuth(w http.ResponseWriter, r *http.Request) {
 	cluster := getClusterName(r)
 	if cluster == "" {
-		writeError(w, fmt.Errorf("cluster name is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("cluster name is required"), http.StatusBadRequest)
 		return
 	}
 
@@ -294,7 +526,7 @@ type ClusterHealthResponse struct {
 func (h *Handler) ClusterHealth(w http.ResponseWriter, r *http.Request) {
 	cluster := getClusterName(r)
 	if cluster == "" {
-		writeError(w, fmt.Errorf("cluster name is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("cluster name is required"), http.StatusBadRequest)
 		return
 	}
 
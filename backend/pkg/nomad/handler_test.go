@@ -0,0 +1,3743 @@
+package nomad_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/eventbridge"
+	"github.com/caravan-nomad/caravan/backend/pkg/execpolicy"
+	"github.com/caravan-nomad/caravan/backend/pkg/freeze"
+	"github.com/caravan-nomad/caravan/backend/pkg/jsonpatch"
+	"github.com/caravan-nomad/caravan/backend/pkg/killswitch"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomad"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadfake"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadtest"
+	"github.com/caravan-nomad/caravan/backend/pkg/nsscope"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+	"github.com/caravan-nomad/caravan/backend/pkg/streams"
+	"github.com/caravan-nomad/caravan/backend/pkg/telemetry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const cluster = "test"
+
+// newTestServer serves the handler's routes, wrapped in its middlewares,
+// against a fake Nomad cluster
+func newTestServer(t *testing.T, nomadSrv *nomadtest.Server, opts ...nomad.Option) *httptest.Server {
+	t.Helper()
+
+	h := nomad.NewHandler(nomadSrv.ContextStore(cluster), opts...)
+	// Stops background streams, such as the response cache's, before the fake closes
+	t.Cleanup(func() { h.InvalidateClient(cluster) })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/auth/login", nomad.NewAuthHandler("/", h).Login)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/auth/onetime/exchange", nomad.NewAuthHandler("/", h).ExchangeOneTimeToken)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/jobs", h.ListJobs)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/jobs/stop", h.StopJobs)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job", h.GetJob)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job", h.UpdateJob)
+	mux.HandleFunc("DELETE /api/clusters/{cluster}/v1/job", h.DeleteJob)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job/plan", h.PlanJob)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job/preflight", h.PreflightJob)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job/run-hcl", h.RunHCL)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job/scale", h.ScaleJob)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/scale-status", h.GetJobScaleStatus)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/allocations", h.GetJobAllocations)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/evaluations", h.GetJobEvaluations)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/diff", h.GetJobDiff)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/deployments", h.GetJobDeployments)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/deployment", h.GetJobLatestDeployment)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/detail", h.GetJobDetail)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job/dispatch", h.DispatchJob)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/children", h.GetJobChildren)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/dispatches", h.GetJobDispatches)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/coverage", h.GetSystemJobCoverage)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/utils/constraint-check", h.CheckConstraints)
+	mux.HandleFunc("GET /api/utils/cron/next", h.CronNext)
+	mux.HandleFunc("GET /api/clusters/{cluster}/devices", h.GetDevices)
+	mux.HandleFunc("GET /api/clusters/{cluster}/graph", h.GetServiceGraph)
+	mux.HandleFunc("POST /api/graphql", h.GraphQL)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/node/{nodeID}/networks", h.GetNodeNetworks)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/deployment/{deployID}/canary-compare", h.GetCanaryComparison)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/deployment/{deployID}/watch", h.StreamDeploymentProgress)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}", h.GetAllocation)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/logs/{task}", h.StreamLogs)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/exec/{task}", h.ExecAllocation)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/actions", h.ListJobActions)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/job/evaluate", h.EvaluateJob)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/action", h.RunJobAction)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/connect", h.GetAllocationConnect)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/allocation/{allocID}/upload/{task}", h.UploadAllocFile)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/download/{task}", h.DownloadAllocFile)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/file", h.ReadAllocFile)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/file/stream", h.StreamAllocFile)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/event/stream", h.StreamEvents)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/watch", h.Watch)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/event/forward", h.ListEventForwards)
+	mux.HandleFunc("PUT /api/clusters/{cluster}/v1/event/forward", h.PutEventForward)
+	mux.HandleFunc("DELETE /api/clusters/{cluster}/v1/event/forward", h.DeleteEventForward)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/events", h.StreamJobEvents)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/history", h.GetJobHistory)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/acl/token/{tokenID}/activity", h.GetACLTokenActivity)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/acl/bootstrap", h.BootstrapACL)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/acl/token/self", h.GetSelfToken)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/acl/token/onetime", h.CreateOneTimeToken)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/acl/impersonation", h.GetImpersonation)
+	mux.HandleFunc("POST /api/clusters/{cluster}/v1/acl/impersonation", h.StartImpersonation)
+	mux.HandleFunc("DELETE /api/clusters/{cluster}/v1/acl/impersonation", h.StopImpersonation)
+	mux.HandleFunc("PUT /api/clusters/{cluster}/v1/system/gc", h.GarbageCollect)
+	mux.HandleFunc("PUT /api/clusters/{cluster}/v1/system/reconcile/summaries", h.ReconcileSummaries)
+	mux.HandleFunc("POST /api/share-links", h.CreateShareLink)
+	mux.HandleFunc("GET /api/share-links/{token}", nomad.NewAuthHandler("/", h).OpenShareLink)
+	mux.HandleFunc("GET /api/views", h.ListViews)
+	mux.HandleFunc("POST /api/views", h.CreateView)
+	mux.HandleFunc("GET /api/views/{id}", h.GetView)
+	mux.HandleFunc("PUT /api/views/{id}", h.UpdateView)
+	mux.HandleFunc("DELETE /api/views/{id}", h.DeleteView)
+	mux.HandleFunc("GET /api/ownership", h.ListOwnershipRules)
+	mux.HandleFunc("POST /api/ownership", h.CreateOwnershipRule)
+	mux.HandleFunc("PUT /api/ownership/{id}", h.UpdateOwnershipRule)
+	mux.HandleFunc("DELETE /api/ownership/{id}", h.DeleteOwnershipRule)
+	mux.HandleFunc("GET /api/runbooks", h.ListRunbookRules)
+	mux.HandleFunc("POST /api/runbooks", h.CreateRunbookRule)
+	mux.HandleFunc("PUT /api/runbooks/{id}", h.UpdateRunbookRule)
+	mux.HandleFunc("DELETE /api/runbooks/{id}", h.DeleteRunbookRule)
+	mux.HandleFunc("GET /api/announcements", h.ListAnnouncements)
+	mux.HandleFunc("POST /api/announcements", h.CreateAnnouncement)
+	mux.HandleFunc("PUT /api/announcements/{id}", h.UpdateAnnouncement)
+	mux.HandleFunc("DELETE /api/announcements/{id}", h.DeleteAnnouncement)
+	mux.HandleFunc("GET /api/audit", h.ListAuditLog)
+	mux.HandleFunc("GET /api/approvals", h.ListApprovals)
+	mux.HandleFunc("GET /api/approvals/{id}", h.GetApproval)
+	mux.HandleFunc("POST /api/approvals/{id}/approve", h.ApproveApproval)
+	mux.HandleFunc("DELETE /api/approvals/{id}", h.CancelApproval)
+	mux.HandleFunc("GET /api/tokens", h.ListVaultTokens)
+	mux.HandleFunc("PUT /api/tokens", h.RegisterVaultToken)
+	mux.HandleFunc("DELETE /api/tokens/{cluster}", h.DeleteVaultToken)
+	mux.HandleFunc("POST /api/sso/oidc/start", h.StartSSO)
+	mux.HandleFunc("POST /api/sso/oidc/complete", h.CompleteSSO)
+	mux.HandleFunc("POST /api/sso/jwt", h.LoginSSOJWT)
+	mux.HandleFunc("GET /api/admin/streams", h.ListStreams)
+	mux.HandleFunc("DELETE /api/admin/streams/{id}", h.CloseStream)
+	mux.HandleFunc("GET /api/admin/cache", h.GetCacheStats)
+	mux.HandleFunc("DELETE /api/admin/cache", h.PurgeCache)
+	mux.HandleFunc("GET /api/admin/clients", h.ListPooledClients)
+	mux.HandleFunc("DELETE /api/admin/clients", h.PurgePooledClients)
+	mux.HandleFunc("GET /api/admin/kill-switches", h.ListKillSwitches)
+	mux.HandleFunc("PUT /api/admin/kill-switches/{feature}", h.ToggleKillSwitch)
+
+	handler := h.NamespaceScopeMiddleware(h.FreezeMiddleware(h.DryRunMiddleware(h.StreamRegistryMiddleware(mux))))
+	handler = h.KillSwitchMiddleware(handler)
+	srv := httptest.NewServer(h.TokenVaultMiddleware(handler))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func do(t *testing.T, method, url, token, body string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+
+	if token != "" {
+		req.Header.Set("X-Nomad-Token", token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func decode[T any](t *testing.T, resp *http.Response) T {
+	t.Helper()
+
+	var v T
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&v))
+
+	return v
+}
+
+func TestListAndGetJobs(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 2))
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/jobs", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	jobs := decode[[]api.JobListStub](t, resp)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "web", jobs[0].ID)
+	assert.Equal(t, "running", jobs[0].Status)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job?id=missing", "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	body := decode[map[string]map[string]interface{}](t, resp)
+	assert.Equal(t, "NOT_FOUND", body["error"]["code"])
+	assert.Equal(t, cluster, body["error"]["cluster"])
+}
+
+func TestListLimits(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		nomadSrv.RunJob(t, nomadtest.BatchJob(id))
+	}
+	srv := newTestServer(t, nomadSrv,
+		nomad.WithListLimits(nomad.ListLimits{DefaultPageSize: 2, MaxListSize: 3}),
+		nomad.WithResponseCache(time.Minute))
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/jobs", "", "")
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	apiErr := decode[apierror.Envelope](t, resp).Error
+	assert.Equal(t, apierror.CodeListTooLarge, apiErr.Code)
+	assert.Equal(t, map[string]any{"maxListSize": 3.0, "perPage": 2.0}, apiErr.Details)
+
+	page := func(query string) ([]string, string) {
+		resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/jobs?"+query, "", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var ids []string
+		for _, job := range decode[[]api.JobListStub](t, resp) {
+			ids = append(ids, job.ID)
+		}
+		return ids, resp.Header.Get("X-Nomad-NextToken")
+	}
+
+	ids, next := page("per_page=3")
+	assert.Equal(t, []string{"a", "b", "c"}, ids)
+	ids, next = page("next_token=" + next)
+	assert.Equal(t, []string{"d", "e"}, ids, "the default page size applies")
+	assert.Empty(t, next)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/jobs?prefix=a", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "lists within the limit need no pagination")
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/jobs?per_page=4", "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestLargeListsStream(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 300))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("idle", 0))
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/allocations?id=web", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	// Encoded an item at a time, the list comes out as it would whole
+	allocs, _, err := nomadSrv.Client(t, "").Jobs().Allocations("web", false, nil)
+	require.NoError(t, err)
+	require.Len(t, allocs, 300)
+	want, err := json.Marshal(allocs)
+	require.NoError(t, err)
+	assert.Equal(t, string(want)+"\n", string(body))
+
+	// Empty lists too
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/allocations?id=idle", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "[]\n", string(body))
+}
+
+func TestScaleJob(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/scale?id=web", "",
+		`{"target":{"group":"web"},"count":3}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Len(t, nomadSrv.Allocations("", "web"), 3)
+}
+
+func TestResponseCacheFollowsEvents(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv, nomad.WithResponseCache(time.Hour))
+
+	listJobs := func() []api.JobListStub {
+		return decode[[]api.JobListStub](t, do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/jobs", "", ""))
+	}
+	require.Len(t, listJobs(), 1)
+
+	// The job event invalidates the cached list long before the TTL
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("api", 1))
+	assert.Eventually(t, func() bool { return len(listJobs()) == 2 }, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestAdminCache(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+	srv := newTestServer(t, nomadSrv, nomad.WithResponseCache(time.Hour))
+
+	for i := 0; i < 3; i++ {
+		resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/jobs", admin.SecretID, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	jobStats := func() nomad.CacheStats {
+		resp := do(t, http.MethodGet, srv.URL+"/api/admin/cache?cluster=test", admin.SecretID, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		report := decode[nomad.CacheReport](t, resp)
+		assert.Equal(t, time.Hour.Seconds(), report.ResponseCacheTTLSeconds)
+		for _, stats := range report.Caches {
+			if stats.Cache == "response" && stats.Resource == "jobs" {
+				return stats
+			}
+		}
+		require.Fail(t, "no stats for the cached job lists")
+		return nomad.CacheStats{}
+	}
+
+	stats := jobStats()
+	assert.Equal(t, 1, stats.Entries)
+	assert.EqualValues(t, 2, stats.Hits)
+	assert.EqualValues(t, 1, stats.Misses)
+	assert.InDelta(t, 2.0/3, stats.HitRate, 1e-9)
+
+	resp := do(t, http.MethodDelete, srv.URL+"/api/admin/cache?cluster=test", admin.SecretID, "")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Zero(t, jobStats().Entries)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/admin/cache?cluster=missing", admin.SecretID, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// The event stream invalidating the cache uses the pooled client
+	clients := func() []nomad.PooledClientInfo {
+		resp := do(t, http.MethodGet, srv.URL+"/api/admin/clients", admin.SecretID, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return decode[[]nomad.PooledClientInfo](t, resp)
+	}
+	require.Eventually(t, func() bool { return clients()[0].Pooled }, 5*time.Second, 10*time.Millisecond)
+	pooled := clients()
+	require.Len(t, pooled, 1)
+	assert.Equal(t, "test", pooled[0].Cluster)
+	assert.Equal(t, nomadSrv.URL, pooled[0].Address)
+	assert.Positive(t, pooled[0].Uses)
+
+	resp = do(t, http.MethodDelete, srv.URL+"/api/admin/clients", admin.SecretID, "")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.False(t, clients()[0].Pooled)
+}
+
+func TestScaleStatusHistory(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/scale?id=web", "",
+		`{"target":{"group":"web"},"count":2}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/scale?id=web", "",
+		`{"target":{"group":"web"},"count":4}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/scale-status?id=web", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	status := decode[nomad.JobScaleStatus](t, resp)
+	assert.Equal(t, 4, status.Status.TaskGroups["web"].Desired)
+	require.Len(t, status.Events, 2)
+	assert.Equal(t, int64(4), *status.Events[0].Count)
+	assert.Equal(t, int64(2), status.Events[0].PreviousCount)
+	assert.Equal(t, int64(1), status.Events[1].PreviousCount)
+	assert.Equal(t, "web", status.Events[1].Group)
+	assert.False(t, status.RecommendationsAvailable, "the fake has no recommendations API")
+	assert.Empty(t, status.Errors)
+}
+
+func TestAutoscalerHealth(t *testing.T) {
+	autoscaler := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(autoscaler.Close)
+
+	nomadSrv := nomadtest.NewServer(t)
+	store := nomadSrv.ContextStore(cluster)
+	h := nomad.NewHandler(store)
+	t.Cleanup(func() { h.InvalidateClient(cluster) })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/clusters/{cluster}/autoscaler/health", h.GetAutoscalerHealth)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/autoscaler/health", "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "no autoscaler is configured yet")
+
+	ctx, err := store.GetContext(cluster)
+	require.NoError(t, err)
+	ctx.AutoscalerAddress = autoscaler.URL
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/autoscaler/health", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	health := decode[nomad.AutoscalerHealth](t, resp)
+	assert.True(t, health.Healthy)
+	assert.Equal(t, autoscaler.URL, health.Address)
+
+	autoscaler.Close()
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/autoscaler/health", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	health = decode[nomad.AutoscalerHealth](t, resp)
+	assert.False(t, health.Healthy)
+	assert.NotEmpty(t, health.Error)
+}
+
+func TestStreamLogs(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	require.NoError(t, nomadSrv.AppendLog(allocs[0].ID, "web", "stdout", []byte("line one\nline two\n")))
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/allocation/"+allocs[0].ID+"/logs/web?origin=start", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	var data []string
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			data = append(data, line)
+		}
+	}
+
+	assert.Contains(t, data, "line one")
+	assert.Contains(t, data, "line two")
+}
+
+func TestStreamLogsLongLines(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	long := strings.Repeat("x", 100000)
+	require.NoError(t, nomadSrv.AppendLog(allocs[0].ID, "web", "stdout", []byte(long+"\r\nend\n")))
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/allocation/"+allocs[0].ID+"/logs/web?origin=start", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var data []string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if line, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			data = append(data, line)
+		}
+	}
+	require.NoError(t, scanner.Err())
+
+	// After the task's start, a line too long for one event is split over several
+	require.Greater(t, len(data), 2)
+	assert.Contains(t, data[0], "starting web")
+	assert.Equal(t, "end", data[len(data)-1])
+	for _, line := range data {
+		assert.LessOrEqual(t, len(line), 32<<10)
+	}
+	assert.True(t, strings.Join(data[1:len(data)-1], "") == long, "the long line is sent whole")
+}
+
+func TestStreamWriteTimeout(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+
+	h := nomad.NewHandler(nomadSrv.ContextStore(cluster), nomad.WithStreamWriteTimeout(200*time.Millisecond))
+	t.Cleanup(func() { h.InvalidateClient(cluster) })
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/allocation/{allocID}/logs/{task}", h.StreamLogs)
+	srv := httptest.NewUnstartedServer(h.StreamRegistryMiddleware(mux))
+	// Small socket buffers fill up quickly
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conn.(*net.TCPConn).SetWriteBuffer(4096)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err == nil {
+				conn.(*net.TCPConn).SetReadBuffer(4096)
+			}
+			return conn, err
+		},
+	}}
+
+	// The client follows the log, and never reads it
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		srv.URL+"/api/clusters/test/v1/allocation/"+allocs[0].ID+"/logs/web?follow=true&origin=start", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Len(t, h.Streams().List(), 1)
+
+	// The task writes far more than the socket buffers hold
+	var writer sync.WaitGroup
+	writer.Add(1)
+	go func() {
+		defer writer.Done()
+		chunk := []byte(strings.Repeat("y", 1023) + "\n")
+		for ctx.Err() == nil && len(h.Streams().List()) > 0 {
+			for range 64 {
+				nomadSrv.AppendLog(allocs[0].ID, "web", "stdout", chunk)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	assert.Eventually(t, func() bool { return len(h.Streams().List()) == 0 }, 15*time.Second, 50*time.Millisecond,
+		"a client that stops reading is dropped")
+	cancel()
+	writer.Wait()
+
+	metrics := httptest.NewRecorder()
+	telemetry.MetricsHandler().ServeHTTP(metrics, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, metrics.Body.String(), `stream_write_timeouts_total{stream="logs"}`)
+	assert.Contains(t, metrics.Body.String(), `stream_bytes_sent_total{stream="logs"}`)
+}
+
+func TestStreamEvents(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.BatchJob("once"))
+	srv := newTestServer(t, nomadSrv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Starting at index 1 replays the registration that already happened
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		srv.URL+"/api/clusters/test/v1/event/stream?topic=Job&index=1", nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		assert.Equal(t, "Job", event["topic"])
+		assert.Equal(t, "once", event["key"])
+
+		return
+	}
+
+	t.Fatal("event stream ended without a job event")
+}
+
+func TestStreamJobEvents(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("other", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/events", "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		srv.URL+"/api/clusters/test/v1/job/events?id=web&index=1", nil)
+	require.NoError(t, err)
+
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Every topic of the job shows up, and nothing of the other job
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(resp.Body)
+	for len(seen) < 4 && scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		var event struct {
+			Topic   string                            `json:"topic"`
+			Payload map[string]map[string]interface{} `json:"payload"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+
+		obj := event.Payload[event.Topic]
+		jobID := obj["JobID"]
+		if event.Topic == "Job" {
+			jobID = obj["ID"]
+		}
+		assert.Equal(t, "web", jobID, "%s event", event.Topic)
+
+		seen[event.Topic] = true
+	}
+
+	assert.Equal(t, map[string]bool{"Job": true, "Evaluation": true, "Allocation": true, "Deployment": true}, seen)
+}
+
+func TestExecAllocation(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/clusters/test/v1/allocation/" + allocs[0].ID +
+		"/exec/web?tty=false&command=/bin/sh"
+
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	require.NoError(t, err)
+	defer conn.CloseNow()
+
+	send := func(data string) {
+		msg, _ := json.Marshal(map[string]string{"type": "stdin", "data": data})
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, msg))
+	}
+
+	send("echo hello from exec\n")
+	send("exit 3\n")
+
+	var stdout strings.Builder
+
+	for {
+		_, data, err := conn.Read(ctx)
+		require.NoError(t, err)
+
+		var msg struct {
+			Type     string `json:"type"`
+			Data     string `json:"data"`
+			ExitCode int    `json:"exitCode"`
+		}
+		require.NoError(t, json.Unmarshal(data, &msg))
+
+		if msg.Type == "stdout" {
+			stdout.WriteString(msg.Data)
+			continue
+		}
+
+		require.Equal(t, "exit", msg.Type)
+		assert.Equal(t, 3, msg.ExitCode)
+
+		break
+	}
+
+	assert.Equal(t, "hello from exec\n", stdout.String())
+}
+
+func TestEvaluateJob(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	require.NoError(t, nomadSrv.SetAllocationStatus(allocs[0].ID, api.AllocClientStatusFailed, "exit code 1"))
+
+	running := func() int {
+		count := 0
+		for _, a := range nomadSrv.Allocations("", "web") {
+			if a.ClientStatus == api.AllocClientStatusRunning {
+				count++
+			}
+		}
+		return count
+	}
+
+	// A plain evaluation leaves the failed allocation to its reschedule policy
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/evaluate?id=web", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	evalID := decode[nomad.EvaluateJobResponse](t, resp).EvalID
+	assert.NotEmpty(t, evalID)
+	assert.Equal(t, 0, running())
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/evaluate?id=web", "", `{"forceReschedule":true}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, evalID, decode[nomad.EvaluateJobResponse](t, resp).EvalID)
+	assert.Equal(t, 1, running())
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/evaluate?id=missing", "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestJobActions(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	job := nomadtest.ServiceJob("web", 1)
+	job.TaskGroups[0].Tasks[0].Actions = []*api.Action{
+		{Name: "greet", Command: "echo", Args: []string{"hello", "from", "action"}},
+		{Name: "fail", Command: "false"},
+	}
+	nomadSrv.RunJob(t, job)
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/actions?id=web", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	actions := decode[[]nomad.JobAction](t, resp)
+	require.Len(t, actions, 2)
+	assert.Equal(t, nomad.JobAction{
+		Name: "greet", Command: "echo", Args: []string{"hello", "from", "action"}, TaskName: "web", TaskGroupName: "web",
+	}, actions[0])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	run := func(action string) (string, int) {
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/clusters/test/v1/job/action?id=web&action=" + action
+
+		conn, _, err := websocket.Dial(ctx, url, nil)
+		require.NoError(t, err)
+		defer conn.CloseNow()
+
+		var out strings.Builder
+		for {
+			_, data, err := conn.Read(ctx)
+			require.NoError(t, err)
+
+			var msg struct {
+				Type     string `json:"type"`
+				Data     string `json:"data"`
+				Error    string `json:"error"`
+				ExitCode int    `json:"exitCode"`
+			}
+			require.NoError(t, json.Unmarshal(data, &msg))
+
+			switch msg.Type {
+			case "stdout", "stderr":
+				out.WriteString(msg.Data)
+			case "error":
+				return msg.Error, -1
+			default:
+				require.Equal(t, "exit", msg.Type)
+				return out.String(), msg.ExitCode
+			}
+		}
+	}
+
+	out, code := run("greet")
+	assert.Equal(t, "hello from action\n", out)
+	assert.Equal(t, 0, code)
+
+	_, code = run("fail")
+	assert.Equal(t, 1, code)
+
+	out, code = run("missing")
+	assert.Equal(t, -1, code)
+	assert.Contains(t, out, `no action "missing"`)
+
+	// Running an action is a change, which dry-run mode and freezes hold back
+	dryRun := newTestServer(t, nomadSrv, nomad.WithDryRun(true))
+	resp = do(t, http.MethodGet, dryRun.URL+"/api/clusters/test/v1/job/action?id=web&action=greet", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("X-Caravan-Dry-Run"))
+	assert.True(t, decode[nomad.DryRunResult](t, resp).DryRun)
+
+	schedule, err := freeze.Parse([]byte(`{"windows":[{"name":"release",
+		"start":"2000-01-01T00:00:00Z","end":"2999-01-01T00:00:00Z"}]}`))
+	require.NoError(t, err)
+	frozen := newTestServer(t, nomadSrv, nomad.WithFreezeSchedule(schedule))
+	_, resp, err = websocket.Dial(ctx, "ws"+strings.TrimPrefix(frozen.URL, "http")+
+		"/api/clusters/test/v1/job/action?id=web&action=greet", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+}
+
+func TestExecHeldBack(t *testing.T) {
+	schedule, err := freeze.Parse([]byte(`{"windows":[{"name":"release","namespaces":["team-a"],
+		"start":"2000-01-01T00:00:00Z","end":"2999-01-01T00:00:00Z"}]}`))
+	require.NoError(t, err)
+
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.AddNamespace("team-a", "")
+	frozenJob := nomadtest.ServiceJob("api", 1)
+	frozenJob.Namespace = pointerOf("team-a")
+	frozenAllocs := nomadSrv.RunJob(t, frozenJob)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	execURL := func(srv *httptest.Server, allocID, task string) string {
+		return srv.URL + "/api/clusters/test/v1/allocation/" + allocID + "/exec/" + task + "?tty=false&command=/bin/sh"
+	}
+
+	t.Run("dry run", func(t *testing.T) {
+		srv := newTestServer(t, nomadSrv, nomad.WithDryRun(true))
+
+		resp := do(t, http.MethodGet, execURL(srv, allocs[0].ID, "web"), "", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "true", resp.Header.Get("X-Caravan-Dry-Run"))
+		assert.True(t, decode[nomad.DryRunResult](t, resp).DryRun)
+	})
+
+	t.Run("freeze", func(t *testing.T) {
+		srv := newTestServer(t, nomadSrv, nomad.WithFreezeSchedule(schedule))
+
+		// The allocation's namespace is frozen, whatever the query says
+		_, resp, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(execURL(srv, frozenAllocs[0].ID, "api"), "http"), nil)
+		require.Error(t, err)
+		assert.Equal(t, http.StatusLocked, resp.StatusCode)
+
+		conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(execURL(srv, allocs[0].ID, "web"), "http"), nil)
+		require.NoError(t, err, "other namespaces are not frozen")
+		conn.CloseNow()
+	})
+}
+
+func TestExecPolicyRejectsCommand(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+
+	policy, err := execpolicy.Parse([]byte(`{"deny": ["rm"]}`))
+	require.NoError(t, err)
+	srv := newTestServer(t, nomadSrv, nomad.WithExecPolicy(policy))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/clusters/test/v1/allocation/" + allocs[0].ID +
+		"/exec/web?tty=false&command=/bin/rm%20-rf%20/"
+
+	conn, _, err := websocket.Dial(ctx, url, nil)
+	require.NoError(t, err)
+	defer conn.CloseNow()
+
+	_, data, err := conn.Read(ctx)
+	require.NoError(t, err)
+
+	var msg struct {
+		Type  string `json:"type"`
+		Error string `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(data, &msg))
+	assert.Equal(t, "error", msg.Type)
+	assert.Contains(t, msg.Error, "not allowed")
+
+	_, _, err = conn.Read(ctx)
+	assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+}
+
+func TestFileTransfer(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+
+	// The task's filesystem: dd writes stdin to a file, cat prints one
+	files := map[string][]byte{}
+	nomadSrv.SetExec(func(ctx context.Context, req nomadfake.ExecRequest, stdin io.Reader, stdout, stderr io.Writer) int {
+		switch {
+		case req.Command[0] == "dd":
+			data, _ := io.ReadAll(stdin)
+			files[strings.TrimPrefix(req.Command[1], "of=")] = data
+		case req.Command[0] == "cat" && files[req.Command[1]] != nil:
+			stdout.Write(files[req.Command[1]])
+		default:
+			fmt.Fprintf(stderr, "%s: %s: No such file or directory\n", req.Command[0], req.Command[1])
+			return 1
+		}
+
+		return 0
+	})
+
+	srv := newTestServer(t, nomadSrv, nomad.WithFileTransferLimit(1024))
+	base := srv.URL + "/api/clusters/test/v1/allocation/" + allocs[0].ID
+
+	content := "binary\x00data\xff"
+	resp := do(t, http.MethodPost, base+"/upload/web?path=/tmp/../tmp/data.bin", "", content)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []byte(content), files["/tmp/data.bin"])
+
+	resp = do(t, http.MethodGet, base+"/download/web?path=/tmp/data.bin", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `attachment; filename="data.bin"`, resp.Header.Get("Content-Disposition"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(body))
+
+	resp = do(t, http.MethodGet, base+"/download/web?path=/missing", "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do(t, http.MethodPost, base+"/upload/web?path=/tmp/big", "", strings.Repeat("x", 2048))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	files["/tmp/big"] = []byte(strings.Repeat("x", 2048))
+	resp = do(t, http.MethodGet, base+"/download/web?path=/tmp/big", "", "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, base+"/download/web?path=relative", "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestReadAllocFileRanges(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	require.NoError(t, nomadSrv.WriteFile(allocs[0].ID, "web/local/data.txt", []byte("0123456789")))
+	srv := newTestServer(t, nomadSrv)
+	base := srv.URL + "/api/clusters/test/v1/allocation/" + allocs[0].ID + "/file?path=web/local/data.txt"
+
+	read := func(query, rangeHeader string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, base+query, nil)
+		require.NoError(t, err)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp, string(body)
+	}
+
+	resp, body := read("", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "0123456789", body)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+
+	for _, c := range []struct {
+		query, rangeHeader, body, contentRange string
+	}{
+		{query: "&offset=2&limit=3", body: "234", contentRange: "bytes 2-4/10"},
+		{query: "&offset=7", body: "789", contentRange: "bytes 7-9/10"},
+		{query: "&offset=8&limit=100", body: "89", contentRange: "bytes 8-9/10"},
+		{rangeHeader: "bytes=0-0", body: "0", contentRange: "bytes 0-0/10"},
+		{rangeHeader: "bytes=5-", body: "56789", contentRange: "bytes 5-9/10"},
+		{rangeHeader: "bytes=-4", body: "6789", contentRange: "bytes 6-9/10"},
+		{rangeHeader: "bytes=3-1000", body: "3456789", contentRange: "bytes 3-9/10"},
+	} {
+		resp, body := read(c.query, c.rangeHeader)
+		require.Equal(t, http.StatusPartialContent, resp.StatusCode, c)
+		assert.Equal(t, c.body, body, c)
+		assert.Equal(t, c.contentRange, resp.Header.Get("Content-Range"), c)
+	}
+
+	// Past the end, offsets read nothing while ranges are not satisfiable
+	resp, body = read("&offset=20", "")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Empty(t, body)
+	assert.Equal(t, "bytes */10", resp.Header.Get("Content-Range"))
+
+	resp, _ = read("", "bytes=20-30")
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+	assert.Equal(t, "bytes */10", resp.Header.Get("Content-Range"))
+
+	// Multiple ranges are ignored
+	resp, body = read("", "bytes=0-1,4-5")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "0123456789", body)
+
+	resp, _ = read("&offset=-1", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestReadAllocFileDownload(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	require.NoError(t, nomadSrv.WriteFile(allocs[0].ID, "web/local/index.html", []byte("<h1>hi</h1>")))
+	require.NoError(t, nomadSrv.WriteFile(allocs[0].ID, "web/local/résumé.json", []byte("{}")))
+	srv := newTestServer(t, nomadSrv)
+	base := srv.URL + "/api/clusters/test/v1/allocation/" + allocs[0].ID + "/file?path="
+
+	get := func(query string) *http.Response {
+		resp, err := http.Get(base + query)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	// Shown inline, files are never typed
+	resp := get("web/local/index.html")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+	assert.Empty(t, resp.Header.Get("Content-Disposition"))
+
+	resp = get("web/local/index.html&download=true")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="index.html"`, resp.Header.Get("Content-Disposition"))
+
+	resp = get(url.QueryEscape("web/local/résumé.json") + "&download=true")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "attachment; filename*=utf-8''r%C3%A9sum%C3%A9.json", resp.Header.Get("Content-Disposition"))
+
+	// Ranged downloads are attachments too
+	resp = get("web/local/index.html&download=true&offset=4")
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, `attachment; filename="index.html"`, resp.Header.Get("Content-Disposition"))
+}
+
+func TestStreamAllocFile(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	require.NoError(t, nomadSrv.WriteFile(allocs[0].ID, "web/local/app.log", []byte("one\ntwo\n")))
+	srv := newTestServer(t, nomadSrv)
+	base := srv.URL + "/api/clusters/test/v1/allocation/" + allocs[0].ID + "/file/stream?path=web/local/app.log"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	type event struct {
+		id, name string
+		chunk    nomad.FileChunk
+	}
+
+	stream := func(query, lastEventID string) <-chan event {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+query, nil)
+		require.NoError(t, err)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		events := make(chan event)
+		go func() {
+			defer close(events)
+
+			var e event
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if id, ok := strings.CutPrefix(line, "id: "); ok {
+					e.id = id
+				}
+				if name, ok := strings.CutPrefix(line, "event: "); ok {
+					e.name = name
+				}
+				if data, ok := strings.CutPrefix(line, "data: "); ok {
+					assert.NoError(t, json.Unmarshal([]byte(data), &e.chunk))
+					events <- e
+					e = event{}
+				}
+			}
+		}()
+
+		return events
+	}
+
+	all := func(events <-chan event) []event {
+		var list []event
+		for e := range events {
+			list = append(list, e)
+		}
+		return list
+	}
+
+	// Without follow the stream ends at the current end of the file
+	assert.Equal(t, []event{
+		{id: "8", chunk: nomad.FileChunk{Data: "one\ntwo\n", Offset: 8}},
+		{name: "eof", chunk: nomad.FileChunk{Offset: 8}},
+	}, all(stream("&origin=start", "")))
+
+	assert.Equal(t, []event{
+		{id: "8", chunk: nomad.FileChunk{Data: "two\n", Offset: 8}},
+		{name: "eof", chunk: nomad.FileChunk{Offset: 8}},
+	}, all(stream("&offset=4", "")))
+
+	// A reconnecting EventSource resumes after the last chunk it got
+	assert.Equal(t, []event{
+		{id: "8", chunk: nomad.FileChunk{Data: "two\n", Offset: 8}},
+		{name: "eof", chunk: nomad.FileChunk{Offset: 8}},
+	}, all(stream("&origin=start", "4")))
+	assert.Equal(t, []event{{name: "eof", chunk: nomad.FileChunk{Offset: 8}}}, all(stream("", "8")))
+
+	// With follow it sends what is appended until the file goes away
+	events := stream("&follow=true", "")
+	assert.Equal(t, event{id: "8", chunk: nomad.FileChunk{Data: "one\ntwo\n", Offset: 8}}, <-events)
+
+	require.NoError(t, nomadSrv.AppendFile(allocs[0].ID, "web/local/app.log", []byte("three\n")))
+	assert.Equal(t, event{id: "14", chunk: nomad.FileChunk{Data: "three\n", Offset: 14}}, <-events)
+
+	require.NoError(t, nomadSrv.WriteFile(allocs[0].ID, "web/local/app.log", []byte("new\n")))
+	assert.Equal(t, event{name: "truncated"}, <-events)
+	assert.Equal(t, event{id: "4", chunk: nomad.FileChunk{Data: "new\n", Offset: 4}}, <-events)
+
+	nomadSrv.RemoveFile(allocs[0].ID, "web/local/app.log")
+	assert.Equal(t, event{name: "deleted", chunk: nomad.FileChunk{Offset: 4}}, <-events)
+	_, open := <-events
+	assert.False(t, open)
+
+	for query, status := range map[string]int{
+		"":               http.StatusNotFound,
+		"&origin=middle": http.StatusBadRequest,
+		"&offset=-1":     http.StatusBadRequest,
+	} {
+		resp := do(t, http.MethodGet, base+query, "", "")
+		assert.Equal(t, status, resp.StatusCode, query)
+	}
+
+	resp := do(t, http.MethodGet, strings.Replace(base, "/local/app.log", "", 1), "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "directory")
+}
+
+func TestDryRunDoesNotWrite(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv, nomad.WithDryRun(true))
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/scale?id=web", "",
+		`{"target":{"group":"web"},"count":5}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("X-Caravan-Dry-Run"))
+
+	result := decode[nomad.DryRunResult](t, resp)
+	assert.True(t, result.DryRun)
+	assert.NotNil(t, result.Plan)
+
+	assert.Len(t, nomadSrv.Allocations("", "web"), 1, "dry run must not scale the job")
+
+	// Exec sessions and job actions run commands, although they are GETs
+	h := nomad.NewHandler(nomadSrv.ContextStore(cluster), nomad.WithDryRun(true))
+	guarded := h.DryRunMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("%s reached the handler", r.URL.Path)
+	}))
+	for _, path := range []string{
+		"/api/clusters/test/v1/allocation/a1/exec/web?command=/bin/sh",
+		"/api/clusters/test/v1/job/action?id=web&action=greet",
+	} {
+		rec := httptest.NewRecorder()
+		guarded.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Equal(t, "true", rec.Header().Get("X-Caravan-Dry-Run"), path)
+	}
+}
+
+func TestFreezeBlocksWrites(t *testing.T) {
+	schedule, err := freeze.Parse([]byte(`{"windows":[{"name":"release","reason":"Release week",
+		"start":"2000-01-01T00:00:00Z","end":"2999-01-01T00:00:00Z"}]}`))
+	require.NoError(t, err)
+
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv, nomad.WithFreezeSchedule(schedule))
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/scale?id=web", "",
+		`{"target":{"group":"web"},"count":2}`)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+	assert.Len(t, nomadSrv.Allocations("", "web"), 1)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/jobs", "", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "reads are not frozen")
+}
+
+func TestFreezeChecksJobNamespace(t *testing.T) {
+	schedule, err := freeze.Parse([]byte(`{"windows":[{"name":"prod release","namespaces":["prod"],
+		"start":"2000-01-01T00:00:00Z","end":"2999-01-01T00:00:00Z"}]}`))
+	require.NoError(t, err)
+
+	nomadSrv := nomadtest.NewServer(t)
+	srv := newTestServer(t, nomadSrv, nomad.WithFreezeSchedule(schedule))
+
+	// Without ?namespace, the job's own namespace is the one frozen
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job", "",
+		`{"ID": "api", "Name": "api", "Namespace": "prod", "Type": "service"}`)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job?namespace=default", "",
+		`{"Job": {"ID": "api", "Namespace": "prod"}}`)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode, "the query does not hide the job's namespace")
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job", "",
+		`{"ID": "api", "Name": "api", "Namespace": "default", "Type": "service"}`)
+	assert.NotEqual(t, http.StatusLocked, resp.StatusCode)
+
+	// Handlers decode the body whatever its Content-Type, and stop at the
+	// first JSON value
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/clusters/test/v1/job",
+		strings.NewReader(`{"ID": "api", "Name": "api", "Namespace": "prod", "Type": "service"} trailing`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+	assert.Empty(t, nomadSrv.Allocations("prod", "api"))
+
+	// Jobs of a bulk request without a namespace of their own go to the
+	// request's
+	schedule, err = freeze.Parse([]byte(`{"windows":[{"name":"release","namespaces":["default"],
+		"start":"2000-01-01T00:00:00Z","end":"2999-01-01T00:00:00Z"}]}`))
+	require.NoError(t, err)
+	srv = newTestServer(t, nomadSrv, nomad.WithFreezeSchedule(schedule))
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/jobs/stop", "",
+		`{"jobs": [{"id": "api", "namespace": "prod"}, {"id": "web"}]}`)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+}
+
+func TestPurgeNeedsSecondApprover(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	alice := nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "management"})
+	bob := nomadSrv.AddToken(&api.ACLToken{Name: "bob", Type: "management"})
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv, nomad.WithApprovals(true))
+
+	resp := do(t, http.MethodDelete, srv.URL+"/api/clusters/test/v1/job?id=web&purge=true", alice.SecretID, "")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	approval := decode[nomad.Approval](t, resp)
+	assert.Equal(t, nomad.ApprovalPending, approval.Status)
+
+	// Pending actions are only shown to those with a token for the cluster
+	resp = do(t, http.MethodGet, srv.URL+"/api/approvals", "", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = do(t, http.MethodGet, srv.URL+"/api/approvals/"+approval.ID, "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = do(t, http.MethodGet, srv.URL+"/api/approvals", bob.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, decode[[]nomad.Approval](t, resp), 1)
+	resp = do(t, http.MethodGet, srv.URL+"/api/approvals/"+approval.ID, bob.SecretID, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err := nomadSrv.Job("", "web")
+	require.NoError(t, err, "the job stays until the purge is approved")
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/approvals/"+approval.ID+"/approve", alice.SecretID, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "requesters cannot approve their own actions")
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/approvals/"+approval.ID+"/approve", bob.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, nomad.ApprovalExecuted, decode[nomad.Approval](t, resp).Status)
+
+	_, err = nomadSrv.Job("", "web")
+	assert.Error(t, err)
+}
+
+func TestApprovalsThroughImpersonation(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	alice := nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "management"})
+	bob := nomadSrv.AddToken(&api.ACLToken{Name: "bob", Type: "management"})
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("api", 1))
+	srv := newTestServer(t, nomadSrv, nomad.WithApprovals(true))
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	browser := &http.Client{Jar: jar}
+
+	// Alice keeps sending her own token, the impersonation cookie wins
+	send := func(method, path, token string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(`{"policies": ["readonly"]}`))
+		require.NoError(t, err)
+		req.Header.Set("X-Nomad-Token", token)
+
+		resp, err := browser.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp
+	}
+	impersonate := func(start bool) {
+		method := http.MethodPost
+		if !start {
+			method = http.MethodDelete
+		}
+		require.Equal(t, http.StatusOK, send(method, "/api/clusters/test/v1/acl/impersonation", alice.SecretID).StatusCode)
+	}
+
+	resp := send(http.MethodDelete, "/api/clusters/test/v1/job?id=web&purge=true", alice.SecretID)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	own := decode[nomad.Approval](t, resp)
+
+	impersonate(true)
+	resp = send(http.MethodPost, "/api/approvals/"+own.ID+"/approve", alice.SecretID)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "impersonating does not make another approver")
+
+	resp = send(http.MethodDelete, "/api/clusters/test/v1/job?id=api&purge=true", alice.SecretID)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	impersonated := decode[nomad.Approval](t, resp)
+	assert.Equal(t, alice.AccessorID, impersonated.ImpersonatorAccessor)
+
+	impersonate(false)
+	resp = send(http.MethodPost, "/api/approvals/"+impersonated.ID+"/approve", alice.SecretID)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "requests made while impersonating are the admin's")
+
+	// Only the requester withdraws a request
+	resp = send(http.MethodDelete, "/api/approvals/"+own.ID, bob.SecretID)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = send(http.MethodDelete, "/api/approvals/"+own.ID, alice.SecretID)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, nomad.ApprovalCancelled, decode[nomad.Approval](t, resp).Status)
+	resp = send(http.MethodPost, "/api/approvals/"+own.ID+"/approve", bob.SecretID)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	impersonate(true)
+	resp = send(http.MethodDelete, "/api/approvals/"+impersonated.ID, alice.SecretID)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "whichever way the requester acts")
+	impersonate(false)
+
+	_, err = nomadSrv.Job("", "web")
+	assert.NoError(t, err)
+}
+
+func TestResourceETags(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	allocs := nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	getIfNoneMatch := func(url, etag string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+		req.Header.Set("If-None-Match", etag)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	jobURL := srv.URL + "/api/clusters/test/v1/job?id=web"
+	resp := do(t, http.MethodGet, jobURL, "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	job := decode[api.Job](t, resp)
+	assert.Equal(t, `"`+strconv.FormatUint(*job.ModifyIndex, 10)+`"`, etag)
+
+	resp = getIfNoneMatch(jobURL, etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+
+	resp = getIfNoneMatch(jobURL, `"1", W/`+etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode, "weak tags and lists match")
+
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 2))
+	resp = getIfNoneMatch(jobURL, etag)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "the job changed")
+	assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+
+	require.NotEmpty(t, allocs)
+	allocURL := srv.URL + "/api/clusters/test/v1/allocation/" + allocs[0].ID
+	resp = do(t, http.MethodGet, allocURL, "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag = resp.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	resp = getIfNoneMatch(allocURL, etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+}
+
+func TestRunHCL(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	srv := newTestServer(t, nomadSrv)
+
+	spec := `job "web" {
+  group "web" {
+    count = 2
+
+    task "server" {
+      driver = "docker"
+    }
+  }
+}
+`
+	body, err := json.Marshal(nomad.HCLRequest{HCL: spec})
+	require.NoError(t, err)
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/run-hcl?plan=true", "", string(body))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	run := decode[nomad.HCLRunResponse](t, resp)
+
+	require.NotNil(t, run.Plan)
+	assert.Equal(t, "Added", run.Plan.Diff.Type)
+	assert.NotEmpty(t, run.EvalID)
+	assert.Equal(t, "default", *run.Job.Namespace)
+
+	job, err := nomadSrv.Job("", "web")
+	require.NoError(t, err)
+	assert.Equal(t, 2, *job.TaskGroups[0].Count)
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/run-hcl?plan=true", "", string(body))
+	require.Equal(t, http.StatusOK, resp.StatusCode, "the plan of a registered job enforces its index")
+	assert.Equal(t, "None", decode[nomad.HCLRunResponse](t, resp).Plan.Diff.Type)
+
+	body, err = json.Marshal(nomad.HCLRequest{HCL: `job "web" {`})
+	require.NoError(t, err)
+	resp = do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/run-hcl", "", string(body))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	var invalid apierror.Envelope
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&invalid))
+	assert.NotEmpty(t, invalid.Error.Details, "the diagnostics are the details")
+
+	body, err = json.Marshal(nomad.HCLRequest{HCL: strings.Replace(spec, "{", "{\n  namespace = \"prod\"", 1)})
+	require.NoError(t, err)
+	resp = do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/run-hcl?namespace=dev", "", string(body))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&invalid))
+	assert.Contains(t, invalid.Error.Message, `the job is in namespace "prod"`)
+}
+
+func TestStopJobs(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("api", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	body := `{"jobs":[{"id":"web"},{"id":"api","purge":true},{"id":"missing"}]}`
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/jobs/stop", "", body)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	stopped := decode[nomad.StopJobsResponse](t, resp)
+	require.Len(t, stopped.Results, 3)
+	assert.Equal(t, 1, stopped.Failed)
+
+	assert.Equal(t, "web", stopped.Results[0].ID)
+	assert.Equal(t, "default", stopped.Results[0].Namespace)
+	assert.NotEmpty(t, stopped.Results[0].EvalID)
+	assert.Nil(t, stopped.Results[0].Error)
+
+	assert.True(t, stopped.Results[1].Purge)
+	assert.NotEmpty(t, stopped.Results[1].EvalID)
+
+	require.NotNil(t, stopped.Results[2].Error)
+	assert.Equal(t, apierror.CodeNotFound, stopped.Results[2].Error.Code)
+
+	web, err := nomadSrv.Job("", "web")
+	require.NoError(t, err)
+	assert.True(t, *web.Stop)
+
+	_, err = nomadSrv.Job("", "api")
+	assert.Error(t, err, "purged")
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/jobs/stop", "", `{"jobs":[{"namespace":"default"}]}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStopJobsPurgeNeedsApproval(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	alice := nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "management"})
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv, nomad.WithApprovals(true))
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/jobs/stop", alice.SecretID, `{"jobs":[{"id":"web","purge":true}]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	stopped := decode[nomad.StopJobsResponse](t, resp)
+	require.Len(t, stopped.Results, 1)
+	require.NotNil(t, stopped.Results[0].Approval)
+	assert.Equal(t, nomad.ApprovalPending, stopped.Results[0].Approval.Status)
+	assert.Empty(t, stopped.Results[0].EvalID)
+
+	_, err := nomadSrv.Job("", "web")
+	assert.NoError(t, err, "the job stays until the purge is approved")
+}
+
+func TestEvaluationChurn(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+
+	h := nomad.NewHandler(nomadSrv.ContextStore(cluster))
+	t.Cleanup(func() { h.InvalidateClient(cluster) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go h.TrackEvaluations(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/detail", h.GetJobDetail)
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/jobs/evaluation-churn", h.GetEvaluationChurn)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	// Evaluations created before the stream is open are not counted, so
+	// keep triggering them until one is
+	require.Eventually(t, func() bool {
+		_, err := nomadSrv.EvaluateJob("default", "web")
+		require.NoError(t, err)
+
+		detail := decode[nomad.JobDetail](t, do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/detail?id=web", "", ""))
+		return detail.EvaluationChurn != nil && detail.EvaluationChurn.Total > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	churn := decode[[]nomad.JobEvaluationChurn](t, do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/jobs/evaluation-churn", "", ""))
+	require.Len(t, churn, 1)
+	assert.Equal(t, "web", churn[0].JobID)
+	assert.Equal(t, churn[0].Total, churn[0].TriggeredBy["job-register"])
+	assert.Equal(t, churn[0].Total, churn[0].Recent)
+}
+
+func TestACLTokenActivity(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+	alice := nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "management"})
+	idle := nomadSrv.AddToken(&api.ACLToken{Name: "idle", Type: "client"})
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodDelete, srv.URL+"/api/clusters/test/v1/job?id=web&purge=true", alice.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/acl/token/"+alice.AccessorID+"/activity", admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	activity := decode[nomad.TokenActivity](t, resp)
+	assert.Equal(t, 1, activity.Total)
+	assert.Equal(t, 1, activity.Actions[nomad.ActionJobPurge])
+	assert.NotNil(t, activity.LastUsed)
+	require.Len(t, activity.Recent, 1)
+	assert.Equal(t, "web", activity.Recent[0].Target)
+	assert.False(t, activity.Stale)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/acl/token/"+idle.AccessorID+"/activity?staleAfter=1ns",
+		admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	activity = decode[nomad.TokenActivity](t, resp)
+	assert.Zero(t, activity.Total)
+	assert.Nil(t, activity.LastUsed)
+	assert.True(t, activity.Stale)
+}
+
+func TestTokenVault(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	alice := nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "management"})
+	srv := newTestServer(t, nomadSrv)
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	browser := &http.Client{Jar: jar}
+
+	send := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+
+		resp, err := browser.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp
+	}
+
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/clusters/test/v1/jobs", "").StatusCode)
+
+	resp := send(http.MethodPut, "/api/tokens", `{"token": "`+alice.SecretID+`"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "the token is only sent to the clusters named")
+
+	resp = send(http.MethodPut, "/api/tokens", `{"token": "not-a-token", "clusters": ["test"]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	rejected := decode[nomad.RegisterTokenResponse](t, resp)
+	assert.Empty(t, rejected.Registered)
+	assert.Contains(t, rejected.Errors, cluster)
+
+	resp = send(http.MethodPut, "/api/tokens", `{"token": "`+alice.SecretID+`", "clusters": ["test"]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	registered := decode[nomad.RegisterTokenResponse](t, resp)
+	require.Len(t, registered.Registered, 1)
+	assert.Equal(t, alice.AccessorID, registered.Registered[0].AccessorID)
+
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/api/clusters/test/v1/jobs", "").StatusCode,
+		"requests without a token use the vault's")
+
+	tokens := decode[[]nomad.VaultToken](t, send(http.MethodGet, "/api/tokens", ""))
+	require.Len(t, tokens, 1)
+	assert.Equal(t, "alice", tokens[0].Name)
+
+	assert.Equal(t, http.StatusNoContent, send(http.MethodDelete, "/api/tokens/test", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/clusters/test/v1/jobs", "").StatusCode)
+}
+
+func TestSingleSignOn(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.AddAuthMethod(&api.ACLAuthMethod{Name: "sso", Type: api.ACLAuthMethodTypeOIDC})
+	nomadSrv.AddAuthMethod(&api.ACLAuthMethod{Name: "ci", Type: api.ACLAuthMethodTypeJWT})
+	alice := nomadSrv.AllowLogin("code-1", &api.ACLToken{Name: "alice", Type: "management"})
+	bob := nomadSrv.AllowLogin("jwt-1", &api.ACLToken{Name: "bob", Type: "management"})
+	srv := newTestServer(t, nomadSrv)
+
+	login := func(t *testing.T, steps func(send func(path, body string) *http.Response)) {
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		browser := &http.Client{Jar: jar}
+
+		send := func(path, body string) *http.Response {
+			req, err := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body))
+			require.NoError(t, err)
+
+			resp, err := browser.Do(req)
+			require.NoError(t, err)
+			t.Cleanup(func() { resp.Body.Close() })
+
+			return resp
+		}
+
+		steps(send)
+
+		resp, err := browser.Get(srv.URL + "/api/clusters/test/v1/jobs")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "requests without a token use the signed-on one")
+	}
+
+	t.Run("oidc", func(t *testing.T) {
+		login(t, func(send func(path, body string) *http.Response) {
+			resp := send("/api/sso/oidc/start", `{"auth_method_name": "sso", "redirect_uri": "http://caravan/callback"}`)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			started := decode[nomad.SSOResponse](t, resp)
+			assert.Equal(t, []string{cluster}, started.Clusters)
+			require.NotNil(t, started.Next)
+			assert.Equal(t, cluster, started.Next.Cluster)
+			assert.Contains(t, started.Next.AuthURL, "redirect_uri=")
+
+			resp = send("/api/sso/oidc/complete", `{"session": "`+started.Session+`", "code": "code-1"}`)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			completed := decode[nomad.SSOResponse](t, resp)
+			assert.Empty(t, completed.Errors)
+			assert.Nil(t, completed.Next)
+			require.Len(t, completed.Registered, 1)
+			assert.Equal(t, alice.AccessorID, completed.Registered[0].AccessorID)
+
+			resp = send("/api/sso/oidc/complete", `{"session": "`+started.Session+`", "code": "code-1"}`)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode, "a finished session cannot be replayed")
+		})
+	})
+
+	t.Run("jwt", func(t *testing.T) {
+		login(t, func(send func(path, body string) *http.Response) {
+			resp := send("/api/sso/jwt", `{"auth_method_name": "sso", "jwt": "jwt-1"}`)
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode, "sso is an OIDC method")
+
+			resp = send("/api/sso/jwt", `{"auth_method_name": "ci", "jwt": "jwt-1"}`)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			loggedIn := decode[nomad.SSOResponse](t, resp)
+			assert.Empty(t, loggedIn.Errors)
+			require.Len(t, loggedIn.Registered, 1)
+			assert.Equal(t, bob.AccessorID, loggedIn.Registered[0].AccessorID)
+		})
+	})
+}
+
+func TestNamespaceScopes(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.AddNamespace("team-a", "")
+	nomadSrv.AddNamespace("team-b", "")
+	alice := nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "client", Policies: []string{"team-a"}})
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+
+	web := nomadtest.ServiceJob("web", 1)
+	web.Namespace = pointerOf("team-a")
+	nomadSrv.RunJob(t, web)
+
+	worker := nomadtest.ServiceJob("worker", 1)
+	worker.Namespace = pointerOf("team-b")
+	workerAllocs := nomadSrv.RunJob(t, worker)
+	require.NotEmpty(t, workerAllocs)
+
+	scopes, err := nsscope.Parse([]byte(`{"rules": [{"policies": ["team-a"], "namespaces": ["team-a"]}]}`))
+	require.NoError(t, err)
+	srv := newTestServer(t, nomadSrv, nomad.WithNamespaceScopes(scopes))
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/jobs?namespace=*", alice.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	jobs := decode[[]api.JobListStub](t, resp)
+	require.Len(t, jobs, 1, "lists only show the namespaces in scope")
+	assert.Equal(t, "web", jobs[0].ID)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/jobs?namespace=*", admin.SecretID, "")
+	assert.Len(t, decode[[]api.JobListStub](t, resp), 2, "management tokens are not scoped")
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job?id=worker&namespace=team-b", alice.SecretID, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	body, err := json.Marshal(worker)
+	require.NoError(t, err)
+	resp = do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job?namespace=team-a", alice.SecretID, string(body))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "the namespace of the job itself counts too")
+
+	// Handlers decode bodies as JSON whatever their Content-Type says
+	for path, body := range map[string]string{
+		"/v1/job?namespace=team-a":       string(body),
+		"/v1/jobs/stop?namespace=team-a": `{"jobs": [{"id": "web"}, {"id": "worker", "namespace": "team-b"}]}`,
+	} {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/clusters/test"+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-Nomad-Token", alice.SecretID)
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, path)
+	}
+	assert.Len(t, nomadSrv.Allocations("team-b", "worker"), len(workerAllocs))
+
+	resp = do(t, http.MethodGet,
+		srv.URL+"/api/clusters/test/v1/allocation/"+workerAllocs[0].ID+"/logs/worker", alice.SecretID, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "allocations are checked by their namespace")
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/graph", alice.SecretID, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "the graph spans all namespaces by default")
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/graph?namespace=team-a", alice.SecretID, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/devices", alice.SecretID, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Composite views name their namespace in lower case
+	deployment, _, err := nomadSrv.Client(t, admin.SecretID).Jobs().LatestDeployment("worker", &api.QueryOptions{Namespace: "team-b"})
+	require.NoError(t, err)
+	require.NotNil(t, deployment)
+	resp = do(t, http.MethodGet,
+		srv.URL+"/api/clusters/test/v1/deployment/"+deployment.ID+"/canary-compare", alice.SecretID, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "the comparison is of a deployment outside of the scope")
+
+	type graphQLJobs struct {
+		Data struct {
+			Cluster struct {
+				Jobs []struct {
+					ID          string
+					Allocations []struct{ Namespace string }
+				}
+				Job *struct{ ID string }
+			}
+		}
+		Errors []struct{ Message string }
+	}
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/graphql", alice.SecretID,
+		`{"query": "{ cluster(name: \"test\") { jobs(namespace: \"*\") { id allocations { namespace } } } }"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	result := decode[graphQLJobs](t, resp)
+	require.Len(t, result.Data.Cluster.Jobs, 1, "GraphQL lists are scoped too")
+	assert.Equal(t, "web", result.Data.Cluster.Jobs[0].ID)
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/graphql", alice.SecretID,
+		`{"query": "{ cluster(name: \"test\") { job(id: \"worker\", namespace: \"team-b\") { id } } }"}`)
+	result = decode[graphQLJobs](t, resp)
+	assert.Nil(t, result.Data.Cluster.Job)
+	require.Len(t, result.Errors, 1)
+	assert.Contains(t, result.Errors[0].Message, "outside of your scope")
+
+	resp = do(t, http.MethodDelete, srv.URL+"/api/clusters/test/v1/job?id=web&namespace=team-a", alice.SecretID, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestLoginLockout(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	token := nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "client"})
+	srv := newTestServer(t, nomadSrv)
+
+	login := func(secret string) *http.Response {
+		return do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/auth/login", "",
+			fmt.Sprintf(`{"token": %q}`, secret))
+	}
+
+	require.Equal(t, http.StatusOK, login(token.SecretID).StatusCode)
+
+	for range 5 {
+		assert.Equal(t, http.StatusForbidden, login("guess").StatusCode)
+	}
+
+	resp := login(token.SecretID)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "even a valid token has to wait")
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	assert.Equal(t, apierror.CodeTooManyRequests, decode[apierror.Envelope](t, resp).Error.Code)
+
+	time.Sleep(time.Second)
+	require.Equal(t, http.StatusOK, login(token.SecretID).StatusCode)
+	assert.Equal(t, http.StatusForbidden, login("guess").StatusCode, "a login clears the client's failures")
+}
+
+func TestTokenRegistrationLockout(t *testing.T) {
+	for _, tc := range []struct {
+		name, method, path, guess, valid string
+	}{
+		{name: "vault", method: http.MethodPut, path: "/api/tokens",
+			guess: `{"token": "guess", "clusters": ["test"]}`, valid: `{"token": "alice-secret", "clusters": ["test"]}`},
+		{name: "jwt", method: http.MethodPost, path: "/api/sso/jwt",
+			guess: `{"auth_method_name": "ci", "jwt": "guess"}`, valid: `{"auth_method_name": "ci", "jwt": "jwt-1"}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			nomadSrv := nomadtest.NewServer(t)
+			nomadSrv.AddAuthMethod(&api.ACLAuthMethod{Name: "ci", Type: api.ACLAuthMethodTypeJWT})
+			nomadSrv.AllowLogin("jwt-1", &api.ACLToken{Name: "bob", Type: "client"})
+			nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "client", SecretID: "alice-secret"})
+			srv := newTestServer(t, nomadSrv)
+
+			for range 5 {
+				resp := do(t, tc.method, srv.URL+tc.path, "", tc.guess)
+				assert.Empty(t, decode[nomad.RegisterTokenResponse](t, resp).Registered)
+			}
+
+			resp := do(t, tc.method, srv.URL+tc.path, "", tc.valid)
+			registered := decode[nomad.RegisterTokenResponse](t, resp)
+			assert.Empty(t, registered.Registered, "even a valid token has to wait")
+			assert.Contains(t, registered.Errors[cluster], "too many failed logins")
+
+			time.Sleep(time.Second)
+			resp = do(t, tc.method, srv.URL+tc.path, "", tc.valid)
+			assert.Len(t, decode[nomad.RegisterTokenResponse](t, resp).Registered, 1)
+		})
+	}
+}
+
+func TestJobDiff(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 2))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 3))
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/diff?id=web&from=0", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	diff := decode[nomad.JobVersionDiff](t, resp)
+	assert.EqualValues(t, 0, diff.From)
+	assert.EqualValues(t, 2, diff.To, "to the latest version by default")
+	require.Len(t, diff.Diff.TaskGroups, 1)
+	assert.Equal(t, []*api.FieldDiff{{Type: "Edited", Name: "Count", Old: "1", New: "3"}}, diff.Diff.TaskGroups[0].Fields)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/diff?id=web&from=2&to=1", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	diff = decode[nomad.JobVersionDiff](t, resp)
+	assert.Equal(t, "3", diff.Diff.TaskGroups[0].Fields[0].Old, "versions compare in either order")
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/diff?id=web&from=0&to=9", "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/diff?id=web", "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestJobDeployments(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 2))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("api", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/deployments?id=web", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	deployments := decode[[]*api.Deployment](t, resp)
+	require.Len(t, deployments, 2, "only the job's deployments")
+	for _, d := range deployments {
+		assert.Equal(t, "web", d.JobID)
+	}
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/deployment?id=web", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.EqualValues(t, 1, decode[api.Deployment](t, resp).JobVersion)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/deployment?id=missing", "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/deployments", "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestJobEvaluations(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 2))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("api", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/evaluations?id=web", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	evals := decode[[]*api.Evaluation](t, resp)
+	require.Len(t, evals, 2, "only the job's evaluations")
+	assert.Equal(t, "web", evals[0].JobID)
+	assert.Greater(t, evals[0].CreateIndex, evals[1].CreateIndex, "newest first")
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/evaluations", "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestDeploymentWatch(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 2))
+
+	d, _, err := nomadSrv.Client(t, "").Jobs().LatestDeployment("web", nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, d.JobVersion)
+
+	var hookMutex sync.Mutex
+	var notified []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct{ Events []eventbridge.Event }
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+
+		hookMutex.Lock()
+		defer hookMutex.Unlock()
+		for _, e := range payload.Events {
+			notified = append(notified, e.Type)
+		}
+	}))
+	t.Cleanup(hook.Close)
+
+	h := nomad.NewHandler(nomadSrv.ContextStore(cluster))
+	t.Cleanup(func() { h.InvalidateClient(cluster) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go h.WatchDeployments(ctx, 50*time.Millisecond)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/deployment-watch", h.GetDeploymentWatch)
+	mux.HandleFunc("PUT /api/clusters/{cluster}/v1/job/deployment-watch", h.PutDeploymentWatch)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/deployment-watch?id=web", "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do(t, http.MethodPut, srv.URL+"/api/clusters/test/v1/job/deployment-watch?id=web", "",
+		fmt.Sprintf(`{"autoPromote": true, "autoFail": true, "notify": {"type": "webhook", "url": %q}}`, hook.URL))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/deployment-watch?id=web", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	watch := decode[nomad.DeploymentWatch](t, resp)
+	assert.True(t, watch.AutoPromote)
+	assert.Equal(t, "default", watch.Namespace)
+
+	deployment := func() *api.Deployment {
+		d, _, err := nomadSrv.Client(t, "").Deployments().Info(d.ID, nil)
+		require.NoError(t, err)
+		return d
+	}
+
+	require.NoError(t, nomadSrv.SetDeploymentState(d.ID, api.DeploymentStatusRunning, map[string]*api.DeploymentState{
+		"web": {DesiredCanaries: 1, DesiredTotal: 2, PlacedAllocs: 1, HealthyAllocs: 1},
+	}))
+	require.Eventually(t, func() bool {
+		return deployment().TaskGroups["web"].Promoted
+	}, 5*time.Second, 20*time.Millisecond, "healthy canaries are promoted")
+
+	require.NoError(t, nomadSrv.SetDeploymentState(d.ID, api.DeploymentStatusRunning, map[string]*api.DeploymentState{
+		"web": {DesiredCanaries: 1, Promoted: true, DesiredTotal: 2, PlacedAllocs: 2, HealthyAllocs: 1, UnhealthyAllocs: 1},
+	}))
+	require.Eventually(t, func() bool {
+		job, err := nomadSrv.Job("default", "web")
+		require.NoError(t, err)
+		return *job.Version == 2 && *job.TaskGroups[0].Count == 1
+	}, 5*time.Second, 20*time.Millisecond, "unhealthy deployments revert the job to its last stable version")
+	assert.Equal(t, api.DeploymentStatusFailed, deployment().Status)
+
+	require.Eventually(t, func() bool {
+		hookMutex.Lock()
+		defer hookMutex.Unlock()
+		return len(notified) == 4
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.ElementsMatch(t, []string{"DeploymentPromoted", "DeploymentUnhealthy", "DeploymentFailed", "JobReverted"}, notified)
+}
+
+func TestDeploymentWatchActsAsOwner(t *testing.T) {
+	schedule, err := freeze.Parse([]byte(`{"windows":[{"name":"release","namespaces":["default"],
+		"start":"2000-01-01T00:00:00Z","end":"2999-01-01T00:00:00Z"}]}`))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name         string
+		opts         []nomad.Option
+		deleteOwner  bool
+		wantPromoted bool
+	}{
+		{name: "owner", wantPromoted: true},
+		{name: "deleted owner", deleteOwner: true},
+		{name: "dry run", opts: []nomad.Option{nomad.WithDryRun(true)}},
+		{name: "freeze", opts: []nomad.Option{nomad.WithFreezeSchedule(schedule)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			nomadSrv := nomadtest.NewServer(t)
+			server := nomadSrv.AddToken(&api.ACLToken{Name: "caravan", Type: "management"})
+			alice := nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "client", Policies: []string{"deploy"}})
+			nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+			nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 2))
+
+			admin := nomadSrv.Client(t, server.SecretID)
+			d, _, err := admin.Jobs().LatestDeployment("web", nil)
+			require.NoError(t, err)
+
+			nomadCtx := nomadSrv.Context(cluster)
+			nomadCtx.Token = server.SecretID
+			contexts := nomadconfig.NewInMemoryContextStore()
+			contexts.AddContext(nomadCtx)
+
+			h := nomad.NewHandler(contexts, tc.opts...)
+			t.Cleanup(func() { h.InvalidateClient(cluster) })
+
+			mux := http.NewServeMux()
+			mux.HandleFunc("PUT /api/clusters/{cluster}/v1/job/deployment-watch", h.PutDeploymentWatch)
+			srv := httptest.NewServer(mux)
+			t.Cleanup(srv.Close)
+
+			resp := do(t, http.MethodPut, srv.URL+"/api/clusters/test/v1/job/deployment-watch?id=web", alice.SecretID,
+				`{"autoPromote": true, "owner": "someone-else"}`)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, alice.AccessorID, decode[nomad.DeploymentWatch](t, resp).Owner)
+
+			if tc.deleteOwner {
+				_, err := admin.ACLTokens().Delete(alice.AccessorID, nil)
+				require.NoError(t, err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+			go h.WatchDeployments(ctx, 20*time.Millisecond)
+
+			require.NoError(t, nomadSrv.SetDeploymentState(d.ID, api.DeploymentStatusRunning, map[string]*api.DeploymentState{
+				"web": {DesiredCanaries: 1, DesiredTotal: 2, PlacedAllocs: 1, HealthyAllocs: 1},
+			}))
+
+			promoted := func() bool {
+				d, _, err := admin.Deployments().Info(d.ID, nil)
+				require.NoError(t, err)
+				return d.TaskGroups["web"].Promoted
+			}
+			if !tc.wantPromoted {
+				assert.Never(t, promoted, 300*time.Millisecond, 20*time.Millisecond)
+				return
+			}
+
+			require.Eventually(t, promoted, 5*time.Second, 20*time.Millisecond)
+			assert.Eventually(t, func() bool {
+				tokens, _, err := admin.ACLTokens().List(nil)
+				require.NoError(t, err)
+				return len(tokens) == 2
+			}, 5*time.Second, 20*time.Millisecond, "the token acting for the owner is deleted")
+		})
+	}
+}
+
+func TestCanaryComparison(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 3))
+	srv := newTestServer(t, nomadSrv)
+
+	client := nomadSrv.Client(t, "")
+	d, _, err := client.Jobs().LatestDeployment("web", nil)
+	require.NoError(t, err)
+	allocs, _, err := client.Jobs().Allocations("web", false, nil)
+	require.NoError(t, err)
+	require.Len(t, allocs, 3)
+
+	canary := allocs[0].ID
+	require.NoError(t, nomadSrv.SetDeploymentState(d.ID, api.DeploymentStatusRunning, map[string]*api.DeploymentState{
+		"web": {DesiredCanaries: 1, DesiredTotal: 3, PlacedCanaries: []string{canary}, PlacedAllocs: 1},
+	}))
+	require.NoError(t, nomadSrv.RestartAllocation(canary, ""))
+	require.NoError(t, nomadSrv.SetChecks(canary, api.AllocCheckStatuses{
+		"c1": {ID: "c1", Check: "healthy", Status: "failure"},
+		"c2": {ID: "c2", Check: "alive", Status: "success"},
+	}))
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/deployment/"+d.ID+"/canary-compare", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	comparison := decode[nomad.CanaryComparison](t, resp)
+
+	assert.Equal(t, "web", comparison.JobID)
+	assert.Empty(t, comparison.Errors)
+	require.Len(t, comparison.Groups, 1)
+
+	group := comparison.Groups[0]
+	assert.Equal(t, 1, group.Canary.Count)
+	assert.Equal(t, canary, group.Canary.Allocations[0].ID)
+	assert.EqualValues(t, 1, group.Canary.Restarts)
+	assert.Equal(t, 1, group.Canary.CheckFailures)
+	assert.Equal(t, 2, group.Canary.Allocations[0].Checks)
+	assert.Positive(t, group.Canary.MeanMemoryBytes)
+
+	assert.Equal(t, 2, group.Stable.Count)
+	assert.Zero(t, group.Stable.Restarts)
+	assert.Zero(t, group.Stable.CheckFailures)
+	assert.Positive(t, group.Stable.MeanCPUTicks)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/deployment/missing/canary-compare", "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestWatch(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv)
+	client := nomadSrv.Client(t, "")
+
+	type event struct {
+		id, name string
+		job      api.Job
+	}
+	watch := func(lastEventID string) <-chan event {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		t.Cleanup(cancel)
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/clusters/test/v1/watch?type=job&id=web", nil)
+		require.NoError(t, err)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		events := make(chan event)
+		go func() {
+			defer close(events)
+
+			var e event
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if id, ok := strings.CutPrefix(line, "id: "); ok {
+					e.id = id
+				}
+				if name, ok := strings.CutPrefix(line, "event: "); ok {
+					e.name = name
+				}
+				if data, ok := strings.CutPrefix(line, "data: "); ok {
+					assert.NoError(t, json.Unmarshal([]byte(data), &e.job))
+					events <- e
+					e = event{}
+				}
+			}
+		}()
+
+		return events
+	}
+
+	events := watch("")
+	e := <-events
+	assert.Equal(t, "update", e.name)
+	assert.Equal(t, "web", *e.job.ID)
+	assert.Equal(t, strconv.FormatUint(*e.job.ModifyIndex, 10), e.id)
+	first := e.id
+
+	// Changes to other objects wake the blocking query without an update
+	nomadSrv.RunJob(t, nomadtest.BatchJob("other"))
+
+	_, _, err := client.Jobs().Scale("web", "web", pointerOf(2), "", false, nil, nil)
+	require.NoError(t, err)
+	e = <-events
+	assert.Equal(t, "update", e.name)
+	assert.Equal(t, 2, *e.job.TaskGroups[0].Count)
+
+	resumed := watch(first)
+	e = <-resumed
+	assert.Equal(t, 2, *e.job.TaskGroups[0].Count, "a reconnect skips the version it has")
+
+	_, _, err = client.Jobs().Deregister("web", true, nil)
+	require.NoError(t, err)
+	e = <-events
+	assert.Equal(t, "deleted", e.name)
+	_, open := <-events
+	assert.False(t, open)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/watch?type=volume&id=web", "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/watch?type=job&id=missing", "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+
+func TestWatchPatches(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv)
+	client := nomadSrv.Client(t, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		srv.URL+"/api/clusters/test/v1/watch?type=job&id=web&patches=true", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	next := func() (string, string) {
+		var name string
+		for scanner.Scan() {
+			if v, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+				name = v
+			}
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				return name, data
+			}
+		}
+		require.NoError(t, scanner.Err())
+		return "", ""
+	}
+
+	name, data := next()
+	require.Equal(t, "update", name)
+	var job any
+	require.NoError(t, json.Unmarshal([]byte(data), &job))
+
+	_, _, err = client.Jobs().Scale("web", "web", pointerOf(2), "", false, nil, nil)
+	require.NoError(t, err)
+
+	name, data = next()
+	require.Equal(t, "patch", name)
+	var patch []jsonpatch.Operation
+	require.NoError(t, json.Unmarshal([]byte(data), &patch))
+
+	job, err = jsonpatch.Apply(job, patch)
+	require.NoError(t, err)
+	patched, err := json.Marshal(job)
+	require.NoError(t, err)
+
+	var scaled api.Job
+	require.NoError(t, json.Unmarshal(patched, &scaled))
+	assert.Equal(t, 2, *scaled.TaskGroups[0].Count)
+}
+
+func TestAdminStreams(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+	reader := nomadSrv.AddToken(&api.ACLToken{Name: "reader", Policies: []string{"readonly"}})
+	srv := newTestServer(t, nomadSrv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		srv.URL+"/api/clusters/test/v1/watch?type=job&id=web&token=secret", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Nomad-Token", admin.SecretID)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	// Wait for the first event, so that the stream has sent something
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() && !strings.HasPrefix(scanner.Text(), "data: ") {
+	}
+
+	list := do(t, http.MethodGet, srv.URL+"/api/admin/streams", reader.SecretID, "")
+	assert.Equal(t, http.StatusForbidden, list.StatusCode)
+
+	list = do(t, http.MethodGet, srv.URL+"/api/admin/streams", admin.SecretID, "")
+	require.Equal(t, http.StatusOK, list.StatusCode)
+	open := decode[[]streams.Info](t, list)
+	require.Len(t, open, 1)
+	assert.Equal(t, streams.KindSSE, open[0].Kind)
+	assert.Equal(t, "test", open[0].Cluster)
+	assert.Equal(t, "admin", open[0].User)
+	assert.Equal(t, "/api/clusters/test/v1/watch?id=web&type=job", open[0].Topic, "tokens are left out")
+	assert.Positive(t, open[0].BytesSent)
+
+	closed := do(t, http.MethodDelete, srv.URL+"/api/admin/streams/"+open[0].ID, admin.SecretID, "")
+	require.Equal(t, http.StatusNoContent, closed.StatusCode)
+
+	// The stream ends once it is closed
+	for scanner.Scan() {
+	}
+	assert.Eventually(t, func() bool {
+		list := do(t, http.MethodGet, srv.URL+"/api/admin/streams", admin.SecretID, "")
+		return len(decode[[]streams.Info](t, list)) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	closed = do(t, http.MethodDelete, srv.URL+"/api/admin/streams/"+open[0].ID, admin.SecretID, "")
+	assert.Equal(t, http.StatusNotFound, closed.StatusCode)
+}
+
+func TestEventForwards(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+	reader := nomadSrv.AddToken(&api.ACLToken{Name: "reader", Policies: []string{"readonly"}})
+	srv := newTestServer(t, nomadSrv)
+	endpoint := srv.URL + "/api/clusters/test/v1/event/forward"
+
+	forward := `{"id": "audit", "topics": ["Job"], "sink": {"type": "webhook", "url": "http://127.0.0.1:1/hook",
+		"headers": {"Authorization": "Bearer sink-secret"}}}`
+
+	for _, token := range []string{"", reader.SecretID} {
+		assert.Equal(t, http.StatusForbidden, do(t, http.MethodGet, endpoint, token, "").StatusCode)
+		assert.Equal(t, http.StatusForbidden, do(t, http.MethodPut, endpoint, token, forward).StatusCode)
+		assert.Equal(t, http.StatusForbidden, do(t, http.MethodDelete, endpoint+"?id=audit", token, "").StatusCode)
+	}
+
+	resp := do(t, http.MethodPut, endpoint, admin.SecretID, forward)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	t.Cleanup(func() { do(t, http.MethodDelete, endpoint+"?id=audit", admin.SecretID, "") })
+	created := decode[eventbridge.Forward](t, resp)
+	assert.Equal(t, map[string]string{"Authorization": "redacted"}, created.Sink.Headers)
+
+	// Managers see the forwards, but not the credentials of their sinks
+	resp = do(t, http.MethodGet, endpoint, admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"id":"audit"`)
+	assert.NotContains(t, string(body), "sink-secret")
+
+	assert.Equal(t, http.StatusNoContent, do(t, http.MethodDelete, endpoint+"?id=audit", admin.SecretID, "").StatusCode)
+}
+
+func TestKillSwitches(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+	switches, err := killswitch.Parse("aggregation")
+	require.NoError(t, err)
+	srv := newTestServer(t, nomadSrv, nomad.WithKillSwitches(switches))
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/graph", admin.SecretID, "")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, apierror.CodeFeatureDisabled, decode[apierror.Envelope](t, resp).Error.Code)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/clusters/test/v1/watch?type=job&id=web", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Nomad-Token", admin.SecretID)
+	stream, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { stream.Body.Close() })
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+
+	scanner := bufio.NewScanner(stream.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() && !strings.HasPrefix(scanner.Text(), "data: ") {
+	}
+
+	// Switching events off closes the open watch and refuses new ones
+	resp = do(t, http.MethodPut, srv.URL+"/api/admin/kill-switches/events", admin.SecretID,
+		`{"disabled": true, "reason": "event storm"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	sw := decode[killswitch.Switch](t, resp)
+	assert.True(t, sw.Disabled)
+	assert.Equal(t, "admin", sw.ChangedBy)
+
+	for scanner.Scan() {
+	}
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/watch?type=job&id=web", admin.SecretID, "")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/admin/kill-switches", admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	disabled := map[string]bool{}
+	for _, sw := range decode[[]killswitch.Switch](t, resp) {
+		disabled[sw.Feature] = sw.Disabled
+	}
+	assert.Equal(t, map[string]bool{"events": true, "logs": false, "aggregation": true}, disabled)
+
+	resp = do(t, http.MethodPut, srv.URL+"/api/admin/kill-switches/aggregation", admin.SecretID, `{"disabled": false}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/graph", admin.SecretID, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(t, http.MethodPut, srv.URL+"/api/admin/kill-switches/typo", admin.SecretID, `{"disabled": true}`)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGlobalAdminNeedsEveryCluster(t *testing.T) {
+	prod := nomadtest.NewServer(t)
+	staging := nomadtest.NewServer(t)
+	open := nomadtest.NewServer(t)
+	admin := prod.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+	staging.AddToken(&api.ACLToken{Name: "deployer", Type: "client", SecretID: admin.SecretID})
+
+	contexts := nomadconfig.NewInMemoryContextStore()
+	contexts.AddContext(prod.Context("prod"))
+	contexts.AddContext(staging.Context("staging"))
+	openCtx := open.Context("open")
+	openCtx.ACLDisabled = true
+	contexts.AddContext(openCtx)
+
+	h := nomad.NewHandler(contexts)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/admin/kill-switches", h.ListKillSwitches)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	// Managing prod, and anything on the cluster without ACLs, is not enough
+	resp := do(t, http.MethodGet, srv.URL+"/api/admin/kill-switches", admin.SecretID, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = do(t, http.MethodGet, srv.URL+"/api/admin/kill-switches", "", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	staging.AddToken(&api.ACLToken{Name: "admin", Type: "management", SecretID: "staging-" + admin.SecretID})
+	resp = do(t, http.MethodGet, srv.URL+"/api/admin/kill-switches", "staging-"+admin.SecretID, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	staging.AddToken(&api.ACLToken{Name: "admin", Type: "management", SecretID: admin.SecretID + "-both"})
+	prod.AddToken(&api.ACLToken{Name: "admin", Type: "management", SecretID: admin.SecretID + "-both"})
+	resp = do(t, http.MethodGet, srv.URL+"/api/admin/kill-switches", admin.SecretID+"-both", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// With only clusters without ACLs, only the Caravan admin is
+	contexts = nomadconfig.NewInMemoryContextStore()
+	contexts.AddContext(openCtx)
+	h = nomad.NewHandler(contexts)
+	rec := httptest.NewRecorder()
+	h.ListKillSwitches(rec, httptest.NewRequest(http.MethodGet, "/api/admin/kill-switches", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestStreamDeploymentProgress(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 2))
+	srv := newTestServer(t, nomadSrv)
+
+	d, _, err := nomadSrv.Client(t, "").Jobs().LatestDeployment("web", nil)
+	require.NoError(t, err)
+	require.NoError(t, nomadSrv.SetDeploymentState(d.ID, api.DeploymentStatusRunning, map[string]*api.DeploymentState{
+		"web": {DesiredTotal: 2},
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		srv.URL+"/api/clusters/test/v1/deployment/"+d.ID+"/watch", nil)
+	require.NoError(t, err)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	type event struct {
+		name     string
+		progress nomad.DeploymentProgress
+	}
+	events := make(chan event)
+	go func() {
+		defer close(events)
+
+		var name string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if n, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+				name = n
+			}
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				var progress nomad.DeploymentProgress
+				assert.NoError(t, json.Unmarshal([]byte(data), &progress))
+				events <- event{name: name, progress: progress}
+			}
+		}
+	}()
+
+	e := <-events
+	assert.Equal(t, "progress", e.name)
+	assert.Equal(t, api.DeploymentStatusRunning, e.progress.Status)
+	assert.Equal(t, 2, e.progress.Groups["web"].DesiredTotal)
+	assert.Zero(t, e.progress.Groups["web"].Healthy)
+
+	require.NoError(t, nomadSrv.SetDeploymentState(d.ID, api.DeploymentStatusRunning, map[string]*api.DeploymentState{
+		"web": {DesiredTotal: 2, PlacedAllocs: 2, HealthyAllocs: 1},
+	}))
+	e = <-events
+	assert.Equal(t, "progress", e.name)
+	assert.Equal(t, 2, e.progress.Groups["web"].Placed)
+	assert.Equal(t, 1, e.progress.Groups["web"].Healthy)
+
+	require.NoError(t, nomadSrv.SetDeploymentState(d.ID, api.DeploymentStatusSuccessful, map[string]*api.DeploymentState{
+		"web": {DesiredTotal: 2, PlacedAllocs: 2, HealthyAllocs: 2},
+	}))
+	e = <-events
+	assert.Equal(t, "done", e.name)
+	assert.Equal(t, api.DeploymentStatusSuccessful, e.progress.Status)
+
+	_, open := <-events
+	assert.False(t, open, "the stream ends with the deployment")
+}
+
+func TestDispatchJob(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+
+	report := nomadtest.BatchJob("report")
+	report.ParameterizedJob = &api.ParameterizedJobConfig{
+		Payload:      "forbidden",
+		MetaRequired: []string{"customer"},
+		MetaOptional: []string{"region"},
+	}
+	report.Meta = map[string]string{"region": "eu", "team": "billing"}
+	nomadSrv.RunJob(t, report)
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/detail?id=report", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, &nomad.DispatchParameters{
+		Payload:      "forbidden",
+		MetaRequired: []string{"customer"},
+		MetaOptional: []string{"region"},
+		MetaDefaults: map[string]string{"region": "eu"},
+	}, decode[nomad.JobDetail](t, resp).Parameters)
+
+	dispatch := func(req nomad.DispatchRequest) *http.Response {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		return do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/dispatch?id=report", "", string(body))
+	}
+
+	req := nomad.DispatchRequest{Meta: map[string]string{"customer": "acme"}, IDPrefix: "acme", IdempotencyToken: "run-1"}
+	resp = dispatch(req)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	first := decode[api.JobDispatchResponse](t, resp)
+	assert.True(t, strings.HasPrefix(first.DispatchedJobID, "report/dispatch-acme-"), first.DispatchedJobID)
+
+	resp = dispatch(req)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, first.DispatchedJobID, decode[api.JobDispatchResponse](t, resp).DispatchedJobID,
+		"a retry with the same token dispatches nothing new")
+
+	resp = dispatch(nomad.DispatchRequest{Meta: map[string]string{"customer": "acme"}, IdempotencyToken: "run-2"})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotEqual(t, first.DispatchedJobID, decode[api.JobDispatchResponse](t, resp).DispatchedJobID)
+
+	resp = dispatch(nomad.DispatchRequest{Meta: map[string]string{"region": "us"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "customer is required")
+}
+
+func TestJobDispatches(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	alice := nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "management"})
+
+	report := nomadtest.BatchJob("report")
+	report.ParameterizedJob = &api.ParameterizedJobConfig{Payload: "optional", MetaOptional: []string{"customer"}}
+	nomadSrv.RunJob(t, report)
+	nomadSrv.RunJob(t, nomadtest.BatchJob("backup"))
+	srv := newTestServer(t, nomadSrv)
+
+	body, err := json.Marshal(nomad.DispatchRequest{Payload: []byte("hello"), Meta: map[string]string{"customer": "acme"}})
+	require.NoError(t, err)
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/dispatch?id=report", alice.SecretID, string(body))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	viaCaravan := decode[api.JobDispatchResponse](t, resp).DispatchedJobID
+
+	cli, err := nomadSrv.DispatchJob("default", "report", []byte("hi"), map[string]string{"customer": "globex"})
+	require.NoError(t, err)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/dispatches?id=report", alice.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	history := decode[nomad.JobDispatches](t, resp)
+
+	assert.Equal(t, "report", history.JobID)
+	require.Len(t, history.Dispatches, 2)
+
+	latest := history.Dispatches[0]
+	assert.Equal(t, cli.DispatchedJobID, latest.ID, "newest first")
+	assert.False(t, latest.ViaCaravan)
+	assert.Empty(t, latest.DispatchedBy)
+	assert.True(t, latest.Details)
+	assert.Equal(t, map[string]string{"customer": "globex"}, latest.Meta)
+	assert.Equal(t, 2, latest.PayloadSize)
+
+	first := history.Dispatches[1]
+	assert.Equal(t, viaCaravan, first.ID)
+	assert.True(t, first.ViaCaravan)
+	assert.Equal(t, "alice", first.DispatchedBy)
+	assert.Equal(t, map[string]string{"customer": "acme"}, first.Meta)
+	assert.Equal(t, 5, first.PayloadSize)
+	assert.NotEmpty(t, first.Status)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/dispatches?id=backup", alice.SecretID, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "backup is not parameterized")
+}
+
+func TestJobChildren(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+
+	report := nomadtest.BatchJob("report")
+	report.ParameterizedJob = &api.ParameterizedJobConfig{Payload: "optional"}
+	nomadSrv.RunJob(t, report)
+
+	backup := nomadtest.BatchJob("backup")
+	backup.Periodic = &api.PeriodicConfig{Spec: pointerOf("0 3 * * *"), TimeZone: pointerOf("Europe/Paris")}
+	nomadSrv.RunJob(t, backup)
+
+	nomadSrv.RunJob(t, nomadtest.BatchJob("report-other"))
+
+	for range 2 {
+		_, err := nomadSrv.DispatchJob("default", "report", nil, nil)
+		require.NoError(t, err)
+	}
+
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/children?id=report", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	children := decode[nomad.JobChildren](t, resp)
+
+	assert.Equal(t, "parameterized", children.Kind)
+	assert.Nil(t, children.Periodic)
+	require.Len(t, children.Children, 2)
+	for _, child := range children.Children {
+		assert.True(t, strings.HasPrefix(child.ID, "report/dispatch-"), child.ID)
+	}
+	assert.EqualValues(t, 2, children.Summary.Pending+children.Summary.Running+children.Summary.Dead)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/children?id=backup", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	children = decode[nomad.JobChildren](t, resp)
+
+	assert.Equal(t, "periodic", children.Kind)
+	assert.Empty(t, children.Children)
+	require.NotNil(t, children.Periodic)
+	require.NotNil(t, children.Periodic.NextLaunch)
+	assert.True(t, children.Periodic.NextLaunch.After(time.Now()))
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	assert.Equal(t, 3, children.Periodic.NextLaunch.In(paris).Hour(), "launches follow the job's time zone")
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/children?id=report-other", "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestSystemJobCoverage(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+
+	agent := nomadtest.ServiceJob("agent", 1)
+	agent.Type = pointerOf(api.JobTypeSystem)
+	agent.Datacenters = []string{"dc*"}
+	nomadSrv.RunJob(t, agent)
+
+	// Registered after the job was scheduled, so nothing runs on them
+	nomadSrv.UpsertNode(&api.Node{Name: "client-2"})
+	nomadSrv.UpsertNode(&api.Node{Name: "client-3", SchedulingEligibility: api.NodeSchedulingIneligible})
+	nomadSrv.UpsertNode(&api.Node{Name: "edge-1", Datacenter: "edge"})
+
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/coverage?id=agent", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	coverage := decode[nomad.SystemJobCoverage](t, resp)
+
+	assert.Equal(t, 2, coverage.EligibleNodes)
+	assert.Equal(t, 1, coverage.CoveredNodes)
+	require.Len(t, coverage.Covered, 1)
+	assert.Equal(t, "client-1", coverage.Covered[0].Name)
+	assert.Equal(t, api.AllocClientStatusRunning, coverage.Covered[0].AllocStatus)
+	require.Len(t, coverage.Missing, 1)
+	assert.Equal(t, "client-2", coverage.Missing[0].Name)
+	require.Len(t, coverage.Ineligible, 1)
+	assert.Equal(t, "client-3", coverage.Ineligible[0].Name)
+
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/coverage?id=web", "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestCronNext(t *testing.T) {
+	srv := newTestServer(t, nomadtest.NewServer(t))
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/utils/cron/next?expr="+url.QueryEscape("30 2 * * *")+"&tz=America/New_York&count=3", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	preview := decode[nomad.CronPreview](t, resp)
+
+	assert.Equal(t, "America/New_York", preview.TimeZone)
+	require.Len(t, preview.Launches, 3)
+	newYork := mustLoadLocation(t, "America/New_York")
+	for i, launch := range preview.Launches {
+		assert.Equal(t, 30, launch.In(newYork).Minute())
+		assert.True(t, launch.After(time.Now()))
+		if i > 0 {
+			assert.True(t, launch.After(preview.Launches[i-1]))
+		}
+	}
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/utils/cron/next?expr="+url.QueryEscape("0 0 1 1 * 2000"), "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, decode[nomad.CronPreview](t, resp).Launches, "a past year never launches")
+
+	for _, query := range []string{
+		"",
+		"expr=" + url.QueryEscape("61 * * * *"),
+		"expr=" + url.QueryEscape("@daily") + "&tz=Mars/Olympus",
+		"expr=" + url.QueryEscape("@daily") + "&count=0",
+	} {
+		resp = do(t, http.MethodGet, srv.URL+"/api/utils/cron/next?"+query, "", "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	location, err := time.LoadLocation(name)
+	require.NoError(t, err)
+
+	return location
+}
+
+func TestCompositeTimestamps(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+	srv := newTestServer(t, nomadSrv, nomad.WithDisplayTimezone(berlin))
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/detail?id=web", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotZero(t, *decode[nomad.JobDetail](t, resp).Job.SubmitTime, "epochs are kept by default")
+
+	submitTime := func(query string) string {
+		resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/detail?id=web&timestamps=rfc3339"+query, "", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		detail := decode[map[string]any](t, resp)
+		return detail["job"].(map[string]any)["SubmitTime"].(string)
+	}
+
+	submitted, err := time.Parse(time.RFC3339Nano, submitTime(""))
+	require.NoError(t, err)
+	_, offset := submitted.Zone()
+	_, berlinOffset := submitted.In(berlin).Zone()
+	assert.Equal(t, berlinOffset, offset, "in the display time zone")
+
+	assert.True(t, strings.HasSuffix(submitTime("&tz=Asia/Kolkata"), "+05:30"))
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/detail?id=web&timestamps=unix", "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/detail?id=web&timestamps=rfc3339&tz=Mars/Olympus", "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestCheckConstraints(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.UpsertNode(&api.Node{
+		Name:       "gpu-1",
+		Attributes: map[string]string{"kernel.name": "linux"},
+		Meta:       map[string]string{"gpu": "a100", "rack": "r1"},
+	})
+	nomadSrv.UpsertNode(&api.Node{
+		Name:       "gpu-2",
+		Attributes: map[string]string{"kernel.name": "linux"},
+		Meta:       map[string]string{"gpu": "a100", "rack": "r2"},
+	})
+	nomadSrv.UpsertNode(&api.Node{
+		Name:       "gpu-3",
+		Datacenter: "dc2",
+		Attributes: map[string]string{"kernel.name": "linux"},
+		Meta:       map[string]string{"gpu": "a100"},
+	})
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/utils/constraint-check", "", `{
+		"datacenters": ["dc1"],
+		"constraints": [{"LTarget": "${meta.gpu}", "RTarget": "a100"}],
+		"affinities": [{"LTarget": "${meta.rack}", "Operand": "=", "RTarget": "r1", "Weight": 100}]
+	}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	check := decode[nomad.ConstraintCheck](t, resp)
+
+	assert.Equal(t, 1, check.Matched)
+	assert.Equal(t, 1, check.Partial)
+	assert.Equal(t, 2, check.Excluded)
+	require.Len(t, check.Nodes, 4)
+
+	nodes := map[string]nomad.NodeConstraintCheck{}
+	for _, node := range check.Nodes {
+		nodes[node.Name] = node
+	}
+
+	assert.Equal(t, "match", nodes["gpu-1"].Outcome)
+	assert.InDelta(t, 1.0, nodes["gpu-1"].Score, 0.001)
+	assert.Equal(t, "partial", nodes["gpu-2"].Outcome)
+	assert.False(t, nodes["gpu-2"].Affinities[0].Satisfied)
+	assert.Equal(t, "r2", nodes["gpu-2"].Affinities[0].Value)
+
+	assert.Equal(t, "excluded", nodes["client-1"].Outcome)
+	require.Len(t, nodes["client-1"].Reasons, 1)
+	assert.Contains(t, nodes["client-1"].Reasons[0], "${meta.gpu} is not set")
+	assert.Equal(t, "excluded", nodes["gpu-3"].Outcome)
+	assert.Contains(t, nodes["gpu-3"].Reasons[0], "dc2")
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/utils/constraint-check", "", `{"constraints": [null]}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestDevices(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+
+	gpus := func(ids ...string) *api.NodeResources {
+		device := &api.NodeDeviceResource{
+			Vendor:     "nvidia",
+			Type:       "gpu",
+			Name:       "A100",
+			Attributes: map[string]*api.Attribute{"memory": {IntVal: pointerOf(int64(40)), Unit: "GiB"}},
+		}
+		for _, id := range ids {
+			device.Instances = append(device.Instances, &api.NodeDevice{ID: id, Healthy: id != "gpu-bad"})
+		}
+		return &api.NodeResources{Devices: []*api.NodeDeviceResource{device}}
+	}
+	// Sorted before client-1, so the job lands there
+	nomadSrv.UpsertNode(&api.Node{Name: "a-gpu-1", NodeResources: gpus("gpu-0", "gpu-1", "gpu-bad")})
+
+	train := nomadtest.BatchJob("train")
+	train.TaskGroups[0].Tasks[0].Resources = &api.Resources{
+		Devices: []*api.RequestedDevice{{Name: "nvidia/gpu", Count: pointerOf(uint64(1))}},
+	}
+	nomadSrv.RunJob(t, train)
+
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/devices", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	inventory := decode[nomad.DeviceInventory](t, resp)
+
+	require.Len(t, inventory.Totals, 1)
+	assert.Equal(t, nomad.DeviceCount{
+		Vendor: "nvidia", Type: "gpu", Name: "A100", Total: 3, Healthy: 2, Allocated: 1, Free: 1,
+	}, inventory.Totals[0])
+
+	require.Len(t, inventory.Nodes, 1, "nodes without devices are left out")
+	device := inventory.Nodes[0].Devices[0]
+	assert.Equal(t, "40 GiB", device.Attributes["memory"])
+	assert.Equal(t, "train", device.Instances[0].JobID)
+	assert.Empty(t, device.Instances[1].AllocID)
+}
+
+func TestNodeNetworks(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+
+	// Sorted before client-1, so the job lands there
+	node := nomadSrv.UpsertNode(&api.Node{
+		Name: "a-net-1",
+		Attributes: map[string]string{
+			"kernel.name":                 "linux",
+			"plugins.cni.version.bridge":  "v1.4.0",
+			"plugins.cni.version.portmap": "v1.4.0",
+		},
+		HostNetworks: map[string]*api.HostNetworkInfo{
+			"public": {Name: "public", CIDR: "203.0.113.0/24", Interface: "eth1"},
+		},
+		NodeResources: &api.NodeResources{
+			Networks:       []*api.NetworkResource{{Mode: "host", Device: "eth0", CIDR: "10.0.0.5/32", IP: "10.0.0.5"}},
+			MinDynamicPort: 20000,
+			MaxDynamicPort: 20009,
+		},
+		ReservedResources: &api.NodeReservedResources{
+			Networks: api.NodeReservedNetworkResources{ReservedHostPorts: "22,20000-20001"},
+		},
+	})
+
+	web := nomadtest.ServiceJob("web", 1)
+	web.TaskGroups[0].Networks = []*api.NetworkResource{{
+		ReservedPorts: []api.Port{{Label: "admin", Value: 9090}},
+		DynamicPorts:  []api.Port{{Label: "http", To: 8080}},
+	}}
+	nomadSrv.RunJob(t, web)
+
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/node/"+node.ID+"/networks", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	networks := decode[nomad.NodeNetworks](t, resp)
+
+	assert.Equal(t, map[string]string{"bridge": "v1.4.0", "portmap": "v1.4.0"}, networks.CNIPlugins)
+	assert.True(t, networks.BridgeNetwork)
+	require.Len(t, networks.HostNetworks, 1)
+	assert.Equal(t, "eth1", networks.HostNetworks[0].Interface)
+	assert.Equal(t, "22,20000-20001", networks.ReservedHostPorts)
+
+	require.Len(t, networks.Ports, 1)
+	usage := networks.Ports[0]
+	assert.Equal(t, "10.0.0.5", usage.HostIP)
+	assert.Equal(t, 10, usage.DynamicRange)
+	assert.Equal(t, 2, usage.Reserved)
+	assert.Equal(t, 1, usage.UsedDynamic)
+	assert.Equal(t, 1, usage.UsedStatic)
+	assert.Equal(t, 7, usage.Free)
+
+	require.Len(t, usage.Allocations, 2)
+	assert.Equal(t, "admin", usage.Allocations[0].Label)
+	assert.Equal(t, 20002, usage.Allocations[1].Port, "reserved ports are skipped")
+	assert.Equal(t, "web", usage.Allocations[1].JobID)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/node/missing/networks", "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServiceGraph(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+
+	apiJob := nomadtest.ServiceJob("api", 2)
+	apiJob.TaskGroups[0].Services = []*api.Service{{
+		Name:     "api",
+		Provider: "nomad",
+		Connect: &api.ConsulConnect{SidecarService: &api.ConsulSidecarService{Proxy: &api.ConsulProxy{
+			Upstreams: []*api.ConsulUpstream{{DestinationName: "db", LocalBindPort: 5432}},
+		}}},
+	}}
+	nomadSrv.RunJob(t, apiJob)
+
+	web := nomadtest.ServiceJob("web", 1)
+	web.TaskGroups[0].Tasks[0].Templates = []*api.Template{{
+		EmbeddedTmpl: pointerOf(`{{ range nomadService "api" }}API={{ .Address }}{{ end }}
+{{ with service "primary.redis@dc2" }}{{ end }}`),
+	}}
+	nomadSrv.RunJob(t, web)
+
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/graph", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	graph := decode[nomad.ServiceGraph](t, resp)
+
+	nodes := map[string]nomad.GraphNode{}
+	for _, node := range graph.Nodes {
+		nodes[node.ID] = node
+	}
+	assert.Len(t, nodes, 5)
+	assert.Equal(t, 2, nodes["service:default/api"].Instances)
+	assert.Equal(t, "nomad", nodes["service:default/api"].Provider)
+	assert.Equal(t, "service", nodes["service:default/redis"].Kind)
+	assert.Equal(t, "job", nodes["job:default/web"].Kind)
+
+	edges := map[string][]string{}
+	for _, edge := range graph.Edges {
+		edges[edge.From+" "+edge.Kind+" "+edge.To] = edge.Via
+	}
+	assert.Equal(t, map[string][]string{
+		"job:default/api provides service:default/api":     {"registration", "spec"},
+		"job:default/api depends-on service:default/db":    {"connect"},
+		"job:default/web depends-on service:default/api":   {"template"},
+		"job:default/web depends-on service:default/redis": {"template"},
+	}, edges)
+}
+
+func TestAllocationConnect(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+
+	job := nomadtest.ServiceJob("api", 1)
+	job.TaskGroups[0].Services = []*api.Service{{
+		Name: "api",
+		Connect: &api.ConsulConnect{SidecarService: &api.ConsulSidecarService{Proxy: &api.ConsulProxy{
+			Upstreams: []*api.ConsulUpstream{{DestinationName: "db", LocalBindPort: 5432}},
+		}}},
+	}}
+	// As Nomad injects it on registration
+	job.TaskGroups[0].AddTask(&api.Task{Name: "connect-proxy-api", Driver: "docker", Kind: "connect-proxy:api"})
+	allocs := nomadSrv.RunJob(t, job)
+
+	nomadSrv.SetExec(func(ctx context.Context, req nomadfake.ExecRequest, stdin io.Reader, stdout, stderr io.Writer) int {
+		if req.Task != "connect-proxy-api" || !strings.Contains(req.Command[2], "NOMAD_ENVOY_ADMIN_ADDR_api") {
+			return 1
+		}
+
+		fmt.Fprint(stdout, `server.live: 1
+server.uptime: 3600
+cluster.db.default.dc1.internal.5f2b.consul.membership_healthy: 1
+cluster.db.default.dc1.internal.5f2b.consul.membership_total: 2
+cluster.db.default.dc1.internal.5f2b.consul.upstream_cx_connect_fail: 7
+cluster.db.default.dc1.internal.5f2b.consul.upstream_rq_time: P0(nan,1.0) P25(nan,2.0)
+cluster.local_app.upstream_cx_active: 3
+`)
+		return 0
+	})
+
+	srv := newTestServer(t, nomadSrv)
+	base := srv.URL + "/api/clusters/test/v1/allocation/" + allocs[0].ID + "/connect"
+
+	resp := do(t, http.MethodGet, base, "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	connect := decode[nomad.AllocConnect](t, resp)
+
+	require.Len(t, connect.Sidecars, 1)
+	sidecar := connect.Sidecars[0]
+	assert.Equal(t, "connect-proxy-api", sidecar.Task)
+	assert.Equal(t, "running", sidecar.State)
+	require.Len(t, sidecar.Upstreams, 1)
+	assert.Equal(t, 5432, sidecar.Upstreams[0].LocalBindPort)
+	assert.Nil(t, sidecar.Stats, "stats are only read when asked for")
+
+	resp = do(t, http.MethodGet, base+"?stats=true", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	connect = decode[nomad.AllocConnect](t, resp)
+
+	sidecar = connect.Sidecars[0]
+	require.NotNil(t, sidecar.Stats, sidecar.StatsError)
+	assert.True(t, sidecar.Stats.Live)
+	assert.Equal(t, int64(3600), sidecar.Stats.UptimeSeconds)
+	assert.Equal(t, nomad.EnvoyCluster{HealthyEndpoints: 1, TotalEndpoints: 2, ConnectFailures: 7},
+		sidecar.Stats.Clusters["db"])
+	assert.Equal(t, int64(3), sidecar.Stats.Clusters["local_app"].ActiveConnections)
+
+	resp = do(t, http.MethodGet, base+"?stats=true&task=api", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	connect = decode[nomad.AllocConnect](t, resp)
+	assert.Nil(t, connect.Sidecars[0].Stats)
+	assert.Contains(t, connect.Sidecars[0].StatsError, "exited with code 1")
+}
+
+func TestBootstrapACL(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	srv := newTestServer(t, nomadSrv)
+	url := srv.URL + "/api/clusters/test/v1/acl/bootstrap"
+
+	// Nothing happens until the bootstrap is confirmed
+	resp := do(t, http.MethodPost, url, "", "")
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	confirmation := decode[nomad.ACLBootstrapConfirmation](t, resp)
+	assert.Equal(t, "test", confirmation.Cluster)
+	require.NotEmpty(t, confirmation.ConfirmationToken)
+
+	resp = do(t, http.MethodPost, url, "", `{"confirmationToken": "guess"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do(t, http.MethodPost, url, "", `{"confirmationToken": "`+confirmation.ConfirmationToken+`"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	bootstrap := decode[nomad.ACLBootstrapResponse](t, resp)
+	assert.Equal(t, "management", bootstrap.Type)
+	require.NotEmpty(t, bootstrap.SecretID)
+
+	// The token is the cluster's management token
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/acl/token/self", bootstrap.SecretID, "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Confirmation tokens are single use, and a cluster is only bootstrapped once
+	resp = do(t, http.MethodPost, url, "", `{"confirmationToken": "`+confirmation.ConfirmationToken+`"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do(t, http.MethodPost, url, "", "")
+	confirmation = decode[nomad.ACLBootstrapConfirmation](t, resp)
+	resp = do(t, http.MethodPost, url, "", `{"confirmationToken": "`+confirmation.ConfirmationToken+`"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+}
+
+func TestSetupAdminOnConfiguredInstall(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+
+	// An install upgraded from before the setup wizard has clusters but no
+	// admin password
+	h := nomad.NewHandler(nomadSrv.ContextStore(cluster))
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /api/setup/admin", h.SaveSetupAdmin)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	resp := do(t, http.MethodPut, srv.URL+"/api/setup/admin", "", `{"password": "correct horse battery"}`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = do(t, http.MethodPut, srv.URL+"/api/setup/admin", admin.SecretID, `{"password": "correct horse battery"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestSetup(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+
+	h := nomad.NewHandler(nomadconfig.NewInMemoryContextStore())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/setup", h.GetSetupStatus)
+	mux.HandleFunc("POST /api/setup/validate", h.ValidateSetupCluster)
+	mux.HandleFunc("POST /api/setup/cluster", h.AddSetupCluster)
+	mux.HandleFunc("PUT /api/setup/admin", h.SaveSetupAdmin)
+	mux.HandleFunc("POST /api/admin/login", h.AdminLogin)
+	mux.HandleFunc("POST /api/admin/logout", h.AdminLogout)
+	mux.HandleFunc("GET /api/admin/kill-switches", h.ListKillSwitches)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	resp := do(t, http.MethodGet, srv.URL+"/api/setup", "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, nomad.SetupStatus{Step: nomad.SetupStepCluster}, decode[nomad.SetupStatus](t, resp))
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/setup/validate", "", `{"address": "`+nomadSrv.URL+`", "token": "wrong"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	validation := decode[nomad.SetupValidation](t, resp)
+	assert.True(t, validation.Reachable)
+	assert.False(t, validation.TokenValid)
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/setup/validate", "",
+		`{"address": "`+nomadSrv.URL+`", "token": "`+admin.SecretID+`"}`)
+	validation = decode[nomad.SetupValidation](t, resp)
+	assert.True(t, validation.TokenValid)
+	assert.Equal(t, "admin", validation.TokenName)
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/setup/validate", "", `{"address": "127.0.0.1:4646"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Without a token, the next step is logging in
+	resp = do(t, http.MethodPost, srv.URL+"/api/setup/cluster", "", `{"name": "prod", "address": "`+nomadSrv.URL+`"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, nomad.SetupStepLogin, decode[nomad.SetupStatus](t, resp).Step)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/setup", admin.SecretID, "")
+	status := decode[nomad.SetupStatus](t, resp)
+	assert.Equal(t, 1, status.Clusters)
+	assert.Equal(t, nomad.SetupStepDone, status.Step)
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/setup/cluster", "", `{"name": "dev", "address": "`+nomadSrv.URL+`"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "setup only adds the first cluster")
+
+	resp = do(t, http.MethodPut, srv.URL+"/api/setup/admin", "", `{"password": "short"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/admin/login", "", `{"password": "correct horse battery"}`)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "no password is set yet")
+
+	// With a cluster configured, the password makes its holder Caravan's
+	// admin, so only the cluster's admin may set it
+	resp = do(t, http.MethodPut, srv.URL+"/api/setup/admin", "", `{"password": "correct horse battery"}`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = do(t, http.MethodPut, srv.URL+"/api/setup/admin", admin.SecretID, `{"password": "correct horse battery"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, decode[nomad.SetupStatus](t, resp).AdminConfigured)
+
+	resp = do(t, http.MethodPut, srv.URL+"/api/setup/admin", admin.SecretID, `{"password": "another long password"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "admin settings are only saved once")
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	browser := &http.Client{Jar: jar}
+	send := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+
+		resp, err := browser.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp
+	}
+
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/admin/kill-switches", "").StatusCode)
+
+	resp = send(http.MethodPost, "/api/admin/login", `{"password": "wrong horse battery"}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = send(http.MethodPost, "/api/admin/login", `{"password": "correct horse battery"}`)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/api/admin/kill-switches", "").StatusCode,
+		"the admin session is Caravan's admin")
+
+	jar.SetCookies(resp.Request.URL, []*http.Cookie{{Name: "caravan-admin", Value: "4102444800.forged", Path: "/"}})
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/admin/kill-switches", "").StatusCode,
+		"sessions are signed")
+
+	resp = send(http.MethodPost, "/api/admin/login", `{"password": "correct horse battery"}`)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Equal(t, http.StatusNoContent, send(http.MethodPost, "/api/admin/logout", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/admin/kill-switches", "").StatusCode)
+}
+
+func TestProbeACL(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadCtx := &nomadconfig.Context{Name: "probe", Address: nomadSrv.URL, Token: "unused"}
+
+	require.NoError(t, nomadCtx.ProbeACL(context.Background()))
+	assert.True(t, nomadCtx.ACLDisabled)
+	assert.Equal(t, "none", nomadCtx.AuthType(), "clusters without ACLs need no login")
+
+	nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+
+	require.NoError(t, nomadCtx.ProbeACL(context.Background()))
+	assert.False(t, nomadCtx.ACLDisabled)
+	assert.Equal(t, "token", nomadCtx.AuthType())
+
+	unreachable := &nomadconfig.Context{Name: "unreachable", Address: "http://127.0.0.1:1"}
+	assert.Error(t, unreachable.ProbeACL(context.Background()))
+	assert.Equal(t, "", unreachable.AuthType())
+}
+
+func TestImpersonation(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+	reader := nomadSrv.AddToken(&api.ACLToken{Name: "reader", Policies: []string{"readonly"}})
+	nomadSrv.AddPolicy(&api.ACLPolicy{Name: "readonly", Rules: `namespace "*" { policy = "read" }`})
+	srv := newTestServer(t, nomadSrv)
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	browser := &http.Client{Jar: jar}
+
+	// The admin keeps sending their own token, the impersonation cookie wins
+	send := func(method, path, token, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+"/api/clusters/test/v1/acl"+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("X-Nomad-Token", token)
+
+		resp, err := browser.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp
+	}
+
+	resp := send(http.MethodPost, "/impersonation", reader.SecretID, `{"policies": ["readonly"]}`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "only management tokens impersonate")
+
+	resp = send(http.MethodPost, "/impersonation", admin.SecretID, `{"policies": ["readonly"], "ttl": "24h"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = send(http.MethodPost, "/impersonation", admin.SecretID, `{"policies": ["readonly"], "ttl": "10m"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	started := decode[nomad.Impersonation](t, resp)
+	assert.True(t, started.Active)
+	assert.Equal(t, []string{"readonly"}, started.Policies)
+	require.NotNil(t, started.ExpiresAt)
+
+	resp = send(http.MethodGet, "/token/self", admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	self := decode[api.ACLToken](t, resp)
+	assert.Equal(t, started.AccessorID, self.AccessorID)
+	assert.Equal(t, "client", self.Type)
+	assert.Equal(t, "Caravan impersonation by admin", self.Name)
+
+	resp = send(http.MethodGet, "/impersonation", admin.SecretID, "")
+	assert.Equal(t, started.AccessorID, decode[nomad.Impersonation](t, resp).AccessorID)
+
+	resp = send(http.MethodDelete, "/impersonation", admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, decode[nomad.Impersonation](t, resp).Active)
+
+	resp = send(http.MethodGet, "/token/self", admin.SecretID, "")
+	assert.Equal(t, admin.AccessorID, decode[api.ACLToken](t, resp).AccessorID)
+
+	resp = do(t, http.MethodGet, nomadSrv.URL+"/v1/acl/token/"+started.AccessorID, admin.SecretID, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "stopping deletes the scoped token")
+}
+
+func TestExportClusterMetrics(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 2))
+
+	h := nomad.NewHandler(nomadSrv.ContextStore("metrics"))
+	t.Cleanup(func() { h.InvalidateClient("metrics") })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		h.ExportClusterMetrics(ctx, time.Hour)
+		close(done)
+	}()
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		telemetry.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rec.Body.String()
+	}
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(scrape(), `nomad_allocations{cluster="metrics",client_status="running"} 2`)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	metrics := scrape()
+	assert.Contains(t, metrics, `nomad_jobs{cluster="metrics",status="running"} 1`)
+	assert.Contains(t, metrics, `nomad_allocations{cluster="metrics",client_status="failed"} 0`)
+	assert.Contains(t, metrics, `nomad_nodes{cluster="metrics",status="ready"} 1`)
+	assert.Contains(t, metrics, `nomad_evaluations_pending{cluster="metrics"} 0`)
+
+	// The gauges go away with the exporter
+	cancel()
+	<-done
+	assert.NotContains(t, scrape(), `cluster="metrics"`)
+}
+
+func TestJobHistory(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 2))
+
+	h := nomad.NewHandler(nomadSrv.ContextStore(cluster))
+	t.Cleanup(func() { h.InvalidateClient(cluster) })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/clusters/{cluster}/v1/job/history", h.GetJobHistory)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go h.TrackJobHistory(ctx, time.Hour)
+
+	history := func(jobID string) nomad.JobHistory {
+		resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/history?id="+jobID, "", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return decode[nomad.JobHistory](t, resp)
+	}
+
+	// Nomad does not know the probe job, so it only has a history once
+	// the tracker follows the event stream
+	probe := nomadtest.BatchJob("probe")
+	probe.Canonicalize()
+	require.Eventually(t, func() bool {
+		nomadSrv.Publish(api.TopicJob, "JobRegistered", "probe", "default", map[string]interface{}{"Job": probe})
+		return len(history("probe").Entries) > 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	entries := history("web").Entries
+	require.Len(t, entries, 2)
+	assert.Equal(t, uint64(1), entries[0].Version, "newest first")
+	assert.Equal(t, 2, *entries[0].Job.TaskGroups[0].Count)
+
+	_, err := nomadSrv.DeregisterJob("default", "web", true)
+	require.NoError(t, err)
+
+	// The history outlives the job
+	require.Eventually(t, func() bool { return len(history("web").Entries) == 3 }, 5*time.Second, 50*time.Millisecond)
+
+	entries = history("web").Entries
+	assert.Equal(t, nomad.JobHistoryDeregistered, entries[0].Kind)
+	assert.True(t, entries[0].Stop)
+	assert.Equal(t, uint64(0), entries[2].Version)
+}
+
+func TestSystemGarbageCollect(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("api", 1))
+	_, err := nomadSrv.DeregisterJob("default", "web", false)
+	require.NoError(t, err)
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodPut, srv.URL+"/api/clusters/test/v1/system/gc", admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	_, err = nomadSrv.Job("", "web")
+	assert.Error(t, err, "stopped jobs are collected")
+	_, err = nomadSrv.Job("", "api")
+	assert.NoError(t, err, "running jobs stay")
+
+	resp = do(t, http.MethodPut, srv.URL+"/api/clusters/test/v1/system/reconcile/summaries", admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/acl/token/"+admin.AccessorID+"/activity", admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	activity := decode[nomad.TokenActivity](t, resp)
+	assert.Equal(t, 1, activity.Actions[nomad.ActionSystemGC])
+	assert.Equal(t, 1, activity.Actions[nomad.ActionSystemReconcileSummaries])
+}
+
+func TestAuditLogQuery(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+	reader := nomadSrv.AddToken(&api.ACLToken{Name: "reader", Policies: []string{"readonly"}})
+	srv := newTestServer(t, nomadSrv)
+
+	for i := 0; i < 3; i++ {
+		resp := do(t, http.MethodPut, srv.URL+"/api/clusters/test/v1/system/reconcile/summaries", admin.SecretID, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	resp := do(t, http.MethodPut, srv.URL+"/api/clusters/test/v1/system/gc", admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/audit", reader.SecretID, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "the audit log is for managers")
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/audit?limit=3", admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	page := decode[store.Page[nomad.AuditEntry]](t, resp)
+	require.Len(t, page.Items, 3)
+	assert.Equal(t, nomad.ActionSystemGC, page.Items[0].Action, "newest first")
+	require.NotEmpty(t, page.Next)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/audit?limit=3&cursor="+page.Next, admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	page = decode[store.Page[nomad.AuditEntry]](t, resp)
+	assert.Len(t, page.Items, 1)
+	assert.Empty(t, page.Next)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/audit?cluster=test&action="+nomad.ActionSystemReconcileSummaries, admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, decode[store.Page[nomad.AuditEntry]](t, resp).Items, 3)
+
+	from := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	resp = do(t, http.MethodGet, srv.URL+"/api/audit?from="+from, admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, decode[store.Page[nomad.AuditEntry]](t, resp).Items)
+
+	for _, query := range []string{"limit=0", "order=sideways", "sort=target", "cursor=!"} {
+		resp = do(t, http.MethodGet, srv.URL+"/api/audit?"+query, admin.SecretID, "")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
+
+func TestOneTimeTokenExchange(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	alice := nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "management"})
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/acl/token/onetime", alice.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	ott := decode[nomad.OneTimeToken](t, resp)
+	require.NotEmpty(t, ott.OneTimeSecretID)
+	assert.True(t, ott.ExpiresAt.After(time.Now()))
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	browser := &http.Client{Jar: jar}
+
+	exchange := func() *http.Response {
+		resp, err := browser.Post(srv.URL+"/api/clusters/test/v1/auth/onetime/exchange", "application/json",
+			strings.NewReader(`{"oneTimeSecretId": "`+ott.OneTimeSecretID+`"}`))
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp
+	}
+
+	resp = exchange()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = browser.Get(srv.URL + "/api/clusters/test/v1/acl/token/self")
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, alice.AccessorID, decode[api.ACLToken](t, resp).AccessorID, "the session uses the exchanged token")
+
+	resp = exchange()
+	assert.NotEqual(t, http.StatusOK, resp.StatusCode, "one-time tokens exchange once")
+}
+
+func TestShareLinks(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	alice := nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "management"})
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/share-links", alice.SecretID,
+		`{"cluster": "test", "path": "https://evil.example/"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/share-links", "", `{"cluster": "test", "path": "/jobs"}`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "only users with a session share links")
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/share-links", alice.SecretID,
+		`{"cluster": "test", "path": "/allocations/abc?tab=logs", "ttl": "10m"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	link := decode[nomad.ShareLink](t, resp)
+	assert.Equal(t, "alice", link.SharedBy)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), link.ExpiresAt, time.Minute)
+
+	open := func(url, token string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("X-Nomad-Token", token)
+		}
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+
+		return resp
+	}
+
+	resp = open(link.URL, alice.SecretID)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "/c/test/allocations/abc?tab=logs", resp.Header.Get("Location"))
+
+	resp = open(link.URL, "")
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "/login/test?to=%2Fc%2Ftest%2Fallocations%2Fabc%3Ftab%3Dlogs", resp.Header.Get("Location"))
+
+	resp = open(link.URL+"x", alice.SecretID)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "tampered links are rejected")
+}
+
+func TestSavedViews(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	alice := nomadSrv.AddToken(&api.ACLToken{Name: "alice", Type: "management"})
+	bob := nomadSrv.AddToken(&api.ACLToken{Name: "bob", Type: "management"})
+	srv := newTestServer(t, nomadSrv)
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/views", alice.SecretID,
+		`{"name": "Batch failures", "cluster": "test", "namespace": "batch", "page": "jobs",
+		  "filters": {"status": "dead", "type": "batch"}}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	view := decode[nomad.SavedView](t, resp)
+	assert.Equal(t, "alice", view.Owner)
+	assert.False(t, view.Shared)
+
+	list := func(token string) []nomad.SavedView {
+		resp := do(t, http.MethodGet, srv.URL+"/api/views?cluster=test", token, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return decode[[]nomad.SavedView](t, resp)
+	}
+
+	assert.Len(t, list(alice.SecretID), 1)
+	assert.Empty(t, list(bob.SecretID), "views are private until shared")
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/views/"+view.ID, bob.SecretID, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do(t, http.MethodPut, srv.URL+"/api/views/"+view.ID, alice.SecretID,
+		`{"name": "Batch failures", "cluster": "test", "page": "jobs", "filters": {"status": "dead"}, "shared": true}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	shared := list(bob.SecretID)
+	require.Len(t, shared, 1)
+	assert.Equal(t, map[string]string{"status": "dead"}, shared[0].Filters)
+
+	resp = do(t, http.MethodDelete, srv.URL+"/api/views/"+view.ID, bob.SecretID, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "shared views stay their owner's")
+
+	resp = do(t, http.MethodDelete, srv.URL+"/api/views/"+view.ID, alice.SecretID, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Empty(t, list(alice.SecretID))
+}
+
+func TestJobOwnership(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+	reader := nomadSrv.AddToken(&api.ACLToken{Name: "reader", Policies: []string{"readonly"}})
+	tagged := nomadtest.ServiceJob("web", 1)
+	tagged.Meta = map[string]string{"team": "payments"}
+	nomadSrv.RunJob(t, tagged)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web-canary", 1))
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("api", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	rule := `{"jobPattern": "web*", "team": "platform", "contacts": [{"type": "slack", "value": "#platform"}],
+		"runbooks": [{"title": "Web", "url": "https://runbooks.example/web"}]}`
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/ownership", reader.SecretID, rule)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "only managers edit the registry")
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/ownership", admin.SecretID, rule)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "admin", decode[nomad.OwnershipRule](t, resp).UpdatedBy)
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/ownership", admin.SecretID, `{"cluster": "test", "metaKey": "team"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	owner := func(jobID string) *nomad.JobOwnership {
+		resp := do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/detail?id="+jobID, admin.SecretID, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return decode[nomad.JobDetail](t, resp).Ownership
+	}
+
+	require.NotNil(t, owner("web"))
+	assert.Equal(t, "payments", owner("web").Team, "the team meta wins over the ID pattern")
+
+	canary := owner("web-canary")
+	require.NotNil(t, canary)
+	assert.Equal(t, "platform", canary.Team)
+	assert.Equal(t, []nomad.OwnershipContact{{Type: "slack", Value: "#platform"}}, canary.Contacts)
+	assert.Len(t, canary.Runbooks, 1)
+
+	assert.Nil(t, owner("api"))
+
+	rules := decode[[]nomad.OwnershipRule](t, do(t, http.MethodGet, srv.URL+"/api/ownership", "", ""))
+	require.Len(t, rules, 2)
+
+	resp = do(t, http.MethodDelete, srv.URL+"/api/ownership/"+rules[0].ID, admin.SecretID, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "platform", owner("web").Team)
+}
+
+func TestRunbookHints(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+	reader := nomadSrv.AddToken(&api.ACLToken{Name: "reader", Policies: []string{"readonly"}})
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	allocs := nomadSrv.Allocations("default", "web")
+	require.Len(t, allocs, 1)
+	require.NoError(t, nomadSrv.SetAllocationStatus(allocs[0].ID, api.AllocClientStatusFailed, "OOM Killed"))
+
+	rule := `{"name": "Out of memory", "pattern": "(?i)oom killed", "source": "task-event",
+		"runbookUrl": "https://runbooks.example/oom", "hint": "Raise the task's memory"}`
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/runbooks", reader.SecretID, rule)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "only managers edit runbook rules")
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/runbooks", admin.SecretID, `{"name": "Bad", "pattern": "(", "hint": "x"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/runbooks", admin.SecretID, rule)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	created := decode[nomad.RunbookRule](t, resp)
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/runbooks", admin.SecretID,
+		`{"name": "Placement", "pattern": "OOM", "source": "placement", "hint": "never matches task events"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/allocation/"+allocs[0].ID, admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	alloc := decode[nomad.AllocationWithHints](t, resp)
+	assert.Equal(t, allocs[0].ID, alloc.ID)
+	require.Len(t, alloc.RunbookHints, 1)
+	assert.Equal(t, created.ID, alloc.RunbookHints[0].RuleID)
+	assert.Equal(t, "https://runbooks.example/oom", alloc.RunbookHints[0].RunbookURL)
+	assert.Equal(t, nomad.RunbookSourceTaskEvent, alloc.RunbookHints[0].Source)
+	assert.Contains(t, alloc.RunbookHints[0].Message, "OOM Killed")
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/job/detail?id=web", admin.SecretID, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	hints := decode[nomad.JobDetail](t, resp).Hints
+	require.Len(t, hints, 1)
+	assert.Equal(t, "Out of memory", hints[0].Name)
+
+	resp = do(t, http.MethodDelete, srv.URL+"/api/runbooks/"+created.ID, admin.SecretID, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = do(t, http.MethodGet, srv.URL+"/api/clusters/test/v1/allocation/"+allocs[0].ID, admin.SecretID, "")
+	assert.Empty(t, decode[nomad.AllocationWithHints](t, resp).RunbookHints)
+}
+
+func TestAnnouncements(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	admin := nomadSrv.AddToken(&api.ACLToken{Name: "admin", Type: "management"})
+	reader := nomadSrv.AddToken(&api.ACLToken{Name: "reader", Policies: []string{"readonly"}})
+
+	var pushed [][]nomad.Announcement
+	srv := newTestServer(t, nomadSrv, nomad.WithAnnouncementListener(func(a []nomad.Announcement) {
+		pushed = append(pushed, a)
+	}))
+
+	list := func() []nomad.Announcement {
+		resp := do(t, http.MethodGet, srv.URL+"/api/announcements", "", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return decode[[]nomad.Announcement](t, resp)
+	}
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/announcements", reader.SecretID, `{"message": "Maintenance at 10:00"}`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "only managers make announcements")
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/announcements", admin.SecretID, `{"message": "x", "severity": "loud"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = do(t, http.MethodPost, srv.URL+"/api/announcements", admin.SecretID, `{"message": "Maintenance at 10:00"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	maintenance := decode[nomad.Announcement](t, resp)
+	assert.Equal(t, nomad.AnnouncementInfo, maintenance.Severity)
+	assert.Equal(t, "admin", maintenance.UpdatedBy)
+
+	expiry := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	resp = do(t, http.MethodPost, srv.URL+"/api/announcements", admin.SecretID,
+		`{"message": "The EU cluster is degraded", "severity": "critical", "expiresAt": "`+expiry+`"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	announcements := list()
+	require.Len(t, announcements, 2)
+	assert.Equal(t, nomad.AnnouncementCritical, announcements[0].Severity, "the most severe comes first")
+	require.Len(t, pushed, 2)
+	assert.Len(t, pushed[1], 2)
+
+	resp = do(t, http.MethodDelete, srv.URL+"/api/announcements/"+maintenance.ID, admin.SecretID, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Len(t, list(), 1)
+	require.Len(t, pushed, 3)
+	assert.Len(t, pushed[2], 1)
+
+	resp = do(t, http.MethodDelete, srv.URL+"/api/announcements/"+maintenance.ID, admin.SecretID, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestPlanJob(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv, nomad.WithDryRun(true))
+
+	body, err := json.Marshal(nomadtest.ServiceJob("web", 3))
+	require.NoError(t, err)
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/plan", "", string(body))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("X-Caravan-Dry-Run"), "plans reach Nomad even in dry-run mode")
+
+	plan := decode[api.JobPlanResponse](t, resp)
+	require.NotNil(t, plan.Diff)
+	assert.Equal(t, "Edited", plan.Diff.Type)
+	require.Contains(t, plan.Annotations.DesiredTGUpdates, "web")
+	assert.EqualValues(t, 2, plan.Annotations.DesiredTGUpdates["web"].Place)
+
+	job, err := nomadSrv.Job("", "web")
+	require.NoError(t, err)
+	assert.Equal(t, 1, *job.TaskGroups[0].Count, "planning does not register")
+}
+
+func TestPreflightJob(t *testing.T) {
+	nomadSrv := nomadtest.NewServer(t)
+	nomadSrv.RunJob(t, nomadtest.ServiceJob("web", 1))
+	srv := newTestServer(t, nomadSrv)
+
+	preflight := func(job *api.Job) nomad.PreflightResponse {
+		t.Helper()
+
+		body, err := json.Marshal(nomad.PreflightRequest{Job: job})
+		require.NoError(t, err)
+
+		resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/preflight", "", string(body))
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		return decode[nomad.PreflightResponse](t, resp)
+	}
+
+	result := preflight(nomadtest.ServiceJob("web", 3))
+	assert.True(t, result.Validation.Valid)
+	require.NotNil(t, result.Plan)
+	assert.Equal(t, "Edited", result.Plan.Diff.Type)
+	assert.EqualValues(t, 2, result.Plan.Annotations.DesiredTGUpdates["web"].Place)
+	assert.Equal(t, "default", result.Quota.Namespace)
+	assert.Equal(t, nomad.PreflightWarning, result.Verdict, "the job requests no resources")
+	assert.Equal(t, []string{"resources: No CPU or memory is requested, Nomad's small defaults apply"}, result.Reasons)
+
+	job, err := nomadSrv.Job("", "web")
+	require.NoError(t, err)
+	assert.Equal(t, 1, *job.TaskGroups[0].Count, "a preflight does not register")
+
+	invalid := nomadtest.ServiceJob("web", 1)
+	invalid.TaskGroups[0].Tasks[0].Driver = ""
+	result = preflight(invalid)
+	assert.False(t, result.Validation.Valid)
+	assert.Nil(t, result.Plan, "invalid jobs are not planned")
+	assert.Equal(t, nomad.PreflightBlocked, result.Verdict)
+	require.NotEmpty(t, result.Reasons)
+	assert.Contains(t, result.Reasons[0], "missing a driver")
+
+	resp := do(t, http.MethodPost, srv.URL+"/api/clusters/test/v1/job/preflight", "", "{}")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func pointerOf[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,313 @@
+package nomad
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/hclfmt"
+)
+
+// maxHCLSize is the largest job specification the HCL endpoints accept
+const maxHCLSize = 1 << 20
+
+// HCLRequest is the body of the HCL format and validate endpoints
+type HCLRequest struct {
+	HCL string `json:"hcl"`
+	// Variables is the content of a variables file, used when validating
+	Variables string `json:"variables,omitempty"`
+}
+
+// HCLFormatResponse is the response of the HCL format endpoint
+type HCLFormatResponse struct {
+	HCL         string              `json:"hcl"`
+	Changed     bool                `json:"changed"`
+	Diagnostics []hclfmt.Diagnostic `json:"diagnostics"`
+}
+
+// HCLValidateResponse is the response of the HCL validate endpoint. Job is
+// the parsed job when the specification is valid.
+type HCLValidateResponse struct {
+	Valid       bool                `json:"valid"`
+	Diagnostics []hclfmt.Diagnostic `json:"diagnostics"`
+	Job         *api.Job            `json:"job,omitempty"`
+}
+
+// JobHCLResponse is the response of the job to HCL endpoint
+type JobHCLResponse struct {
+	HCL string `json:"hcl"`
+}
+
+// HCLRunResponse is the response of the run HCL endpoint. Plan is set when
+// a plan was asked for, and the rest once the job is registered.
+type HCLRunResponse struct {
+	Job            *api.Job             `json:"job"`
+	Diagnostics    []hclfmt.Diagnostic  `json:"diagnostics"`
+	Plan           *api.JobPlanResponse `json:"plan,omitempty"`
+	EvalID         string               `json:"evalId,omitempty"`
+	JobModifyIndex uint64               `json:"jobModifyIndex,omitempty"`
+	Warnings       string               `json:"warnings,omitempty"`
+}
+
+func readHCLRequest(w http.ResponseWriter, r *http.Request) (*HCLRequest, bool) {
+	var req HCLRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHCLSize)).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return nil, false
+	}
+
+	return &req, true
+}
+
+// FormatHCL handles POST /api/utils/hcl/format
+// A specification with syntax errors is returned unchanged with the errors.
+func (h *Handler) FormatHCL(w http.ResponseWriter, r *http.Request) {
+	req, ok := readHCLRequest(w, r)
+	if !ok {
+		return
+	}
+
+	formatted, diags := hclfmt.Format([]byte(req.HCL))
+	if diags == nil {
+		diags = []hclfmt.Diagnostic{}
+	}
+
+	writeJSON(w, HCLFormatResponse{
+		HCL:         string(formatted),
+		Changed:     string(formatted) != req.HCL,
+		Diagnostics: diags,
+	})
+}
+
+// JobToHCL handles POST /api/utils/job/to-hcl
+// The body is a job as returned by GetJob, or wrapped in a "Job" key as in
+// register requests.
+func (h *Handler) JobToHCL(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		api.Job
+		Wrapped *api.Job `json:"Job"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHCLSize)).Decode(&body); err != nil {
+		writeError(w, r, fmt.Errorf("invalid job: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	job := &body.Job
+	if body.Wrapped != nil {
+		job = body.Wrapped
+	}
+
+	if job.ID == nil || *job.ID == "" {
+		writeError(w, r, errors.New("job has no ID"), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, JobHCLResponse{HCL: string(hclfmt.JobToHCL(job))})
+}
+
+// ValidateHCL handles POST /api/utils/hcl/validate?cluster=
+// Without a cluster only the syntax is checked. With one, the job is also
+// parsed and validated against the schema by that cluster's Nomad, with the
+// user's token.
+func (h *Handler) ValidateHCL(w http.ResponseWriter, r *http.Request) {
+	req, ok := readHCLRequest(w, r)
+	if !ok {
+		return
+	}
+
+	resp := HCLValidateResponse{Diagnostics: hclfmt.Check([]byte(req.HCL))}
+
+	clusterName := r.URL.Query().Get("cluster")
+	if clusterName != "" && !hclfmt.HasErrors(resp.Diagnostics) {
+		client, err := h.GetClientWithToken(clusterName, getToken(r))
+		if err != nil {
+			writeNomadError(w, r, err)
+			return
+		}
+
+		diags, job, err := validateJobHCL(client, req)
+		if err != nil {
+			writeNomadError(w, r, err)
+			return
+		}
+
+		resp.Diagnostics = append(resp.Diagnostics, diags...)
+		resp.Job = job
+	}
+
+	if resp.Diagnostics == nil {
+		resp.Diagnostics = []hclfmt.Diagnostic{}
+	}
+	resp.Valid = !hclfmt.HasErrors(resp.Diagnostics)
+	if !resp.Valid {
+		resp.Job = nil
+	}
+
+	writeJSON(w, resp)
+}
+
+// validateJobHCL parses and validates a job with Nomad. Problems with the
+// job are returned as diagnostics, failing to reach Nomad as an error.
+func validateJobHCL(client NomadAPI, req *HCLRequest) ([]hclfmt.Diagnostic, *api.Job, error) {
+	job, diags, err := parseJobHCL(client, req, true)
+	if err != nil || job == nil {
+		return diags, nil, err
+	}
+
+	diags, err = validateJob(client, job)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return diags, job, nil
+}
+
+// parseJobHCL parses a job with Nomad, with the defaults Nomad fills in when
+// canonicalize is set. A specification Nomad cannot parse is returned as
+// diagnostics, failing to reach Nomad as an error.
+func parseJobHCL(client NomadAPI, req *HCLRequest, canonicalize bool) (*api.Job, []hclfmt.Diagnostic, error) {
+	job, err := client.Jobs().ParseHCLOpts(&api.JobsParseRequest{
+		JobHCL:       req.HCL,
+		Variables:    req.Variables,
+		Canonicalize: canonicalize,
+	})
+	if err != nil {
+		// Nomad answers specifications it cannot parse with 400 Bad Request
+		var unexpected api.UnexpectedResponseError
+		if errors.As(err, &unexpected) && unexpected.StatusCode() == http.StatusBadRequest {
+			msg := err.Error()
+			if unexpected.HasBody() {
+				msg = unexpected.Body()
+			}
+
+			return nil, hclfmt.FromNomadError(msg), nil
+		}
+
+		return nil, nil, err
+	}
+
+	return job, nil, nil
+}
+
+// validateJob validates a job with Nomad, returning its validation errors
+// and warnings as diagnostics
+func validateJob(client NomadAPI, job *api.Job) ([]hclfmt.Diagnostic, error) {
+	validation, _, err := client.Jobs().Validate(job, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var diags []hclfmt.Diagnostic
+	for _, msg := range validation.ValidationErrors {
+		diags = append(diags, hclfmt.Diagnostic{Severity: hclfmt.SeverityError, Summary: msg})
+	}
+
+	if validation.Error != "" && len(validation.ValidationErrors) == 0 {
+		diags = append(diags, hclfmt.Diagnostic{Severity: hclfmt.SeverityError, Summary: validation.Error})
+	}
+
+	for _, msg := range strings.Split(validation.Warnings, "\n") {
+		if msg = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(msg), "*")); msg != "" {
+			diags = append(diags, hclfmt.Diagnostic{Severity: hclfmt.SeverityWarning, Summary: msg})
+		}
+	}
+
+	return diags, nil
+}
+
+// RunHCL handles POST /clusters/{cluster}/v1/job/run-hcl?plan=true
+// It parses a job specification with Nomad and registers the job, for a
+// "paste the job file and run it" workflow. With ?plan=true the job is
+// planned first, and registered only if it did not change since the plan,
+// as with "nomad job run -check-index". A specification with errors is
+// refused with its diagnostics as the error details.
+func (h *Handler) RunHCL(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+
+	req, ok := readHCLRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if diags := hclfmt.Check([]byte(req.HCL)); hclfmt.HasErrors(diags) {
+		writeInvalidHCL(w, r, diags)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	diags, job, err := validateJobHCL(client, req)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+	if job == nil || hclfmt.HasErrors(diags) {
+		writeInvalidHCL(w, r, diags)
+		return
+	}
+
+	// The namespace of the request applies to jobs that do not set one, and
+	// must not contradict those that do. The namespace scopes only saw the
+	// request's, so the job's is checked here.
+	opts := getWriteOptions(r)
+	namespace := namespaceOrDefault(stringValue(job.Namespace))
+	switch {
+	case opts.Namespace == "" || opts.Namespace == namespace:
+	case namespace == api.DefaultNamespace:
+		// Parsing sets the default namespace on jobs without one
+		namespace = opts.Namespace
+	default:
+		writeError(w, r, fmt.Errorf("the job is in namespace %q, not %q", namespace, opts.Namespace),
+			http.StatusBadRequest)
+		return
+	}
+	if h.scopes != nil && !h.namespaceScope(clusterName, token).Allows(namespace) {
+		writeOutOfScope(w, r, clusterName, namespace)
+		return
+	}
+	job.Namespace = &namespace
+	opts.Namespace = namespace
+
+	if diags == nil {
+		diags = []hclfmt.Diagnostic{}
+	}
+	resp := HCLRunResponse{Job: job, Diagnostics: diags}
+
+	var register *api.JobRegisterResponse
+	if r.URL.Query().Get("plan") == "true" {
+		resp.Plan, _, err = client.Jobs().Plan(job, true, opts)
+		if err != nil {
+			writeNomadError(w, r, err)
+			return
+		}
+
+		register, _, err = client.Jobs().EnforceRegister(job, resp.Plan.JobModifyIndex, opts)
+	} else {
+		register, _, err = client.Jobs().Register(job, opts)
+	}
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	resp.EvalID = register.EvalID
+	resp.JobModifyIndex = register.JobModifyIndex
+	resp.Warnings = register.Warnings
+
+	writeJSON(w, resp)
+}
+
+// writeInvalidHCL refuses a job specification with its diagnostics
+func writeInvalidHCL(w http.ResponseWriter, r *http.Request, diags []hclfmt.Diagnostic) {
+	writeError(w, r, apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, "the job specification is invalid").
+		WithDetails(diags), http.StatusBadRequest)
+}
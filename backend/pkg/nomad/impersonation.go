@@ -0,0 +1,242 @@
+package nomad
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/auth"
+)
+
+const (
+	// impersonationCookiePrefix names the cookie holding the secret of the
+	// scoped token an admin acts with on a cluster
+	impersonationCookiePrefix = "caravan-impersonate-"
+	// defaultImpersonationTTL is how long an impersonation lasts when the
+	// request does not say
+	defaultImpersonationTTL = time.Hour
+	// maxImpersonationTTL bounds the lifetime of impersonation tokens
+	maxImpersonationTTL = 8 * time.Hour
+)
+
+// Audit actions of impersonations
+const (
+	ActionImpersonationStart = "acl.impersonation.start"
+	ActionImpersonationStop  = "acl.impersonation.stop"
+)
+
+// ImpersonationRequest is the body of POST /clusters/{cluster}/v1/acl/impersonation
+type ImpersonationRequest struct {
+	// Policies are the ACL policies to act with
+	Policies []string `json:"policies"`
+	// TTL is a duration such as "30m", one hour by default
+	TTL string `json:"ttl,omitempty"`
+}
+
+// Impersonation is an admin acting with a restricted set of policies, to see
+// what less privileged users see. Nomad cannot impersonate, so Caravan mints
+// a client token with those policies and uses it instead of the admin's own
+// until the impersonation is stopped or the token expires.
+type Impersonation struct {
+	Active     bool       `json:"active"`
+	Cluster    string     `json:"cluster"`
+	AccessorID string     `json:"accessorId,omitempty"`
+	Name       string     `json:"name,omitempty"`
+	Policies   []string   `json:"policies,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+// StartImpersonation handles POST /clusters/{cluster}/v1/acl/impersonation
+func (h *Handler) StartImpersonation(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+
+	var req ImpersonationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Policies) == 0 {
+		writeError(w, r, errors.New("at least one policy is required"), http.StatusBadRequest)
+		return
+	}
+
+	ttl := defaultImpersonationTTL
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 || ttl > maxImpersonationTTL {
+			writeError(w, r, fmt.Errorf("ttl must be a duration of at most %s", maxImpersonationTTL),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Only the admin's own token can start an impersonation, not the
+	// scoped token of a running one
+	client, err := h.GetClientWithToken(clusterName, ownToken(r, clusterName))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	self, _, err := client.ACLTokens().Self(nil)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+	if self.Type != "management" {
+		writeError(w, r, apierror.New(http.StatusForbidden, apierror.CodePermissionDenied,
+			"only management tokens can impersonate"), http.StatusForbidden)
+		return
+	}
+
+	entry := AuditEntry{
+		Cluster:       clusterName,
+		Action:        ActionImpersonationStart,
+		Actor:         self.Name,
+		ActorAccessor: self.AccessorID,
+	}
+
+	// A new impersonation replaces the running one
+	h.revokeImpersonation(r, client, clusterName)
+
+	token, _, err := client.ACLTokens().Create(&api.ACLToken{
+		Name:          "Caravan impersonation by " + actorName(self),
+		Type:          "client",
+		Policies:      req.Policies,
+		ExpirationTTL: ttl,
+	}, nil)
+	if err != nil {
+		entry.Error = err.Error()
+		h.audit(r.Context(), entry)
+		writeNomadError(w, r, err)
+		return
+	}
+
+	entry.Target = token.AccessorID
+	h.audit(r.Context(), entry)
+
+	setImpersonationCookie(w, r, clusterName, token.SecretID, int(ttl.Seconds()))
+	writeJSON(w, impersonation(clusterName, token))
+}
+
+// GetImpersonation handles GET /clusters/{cluster}/v1/acl/impersonation
+func (h *Handler) GetImpersonation(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+
+	secret := impersonationToken(r, clusterName)
+	if secret == "" {
+		writeJSON(w, Impersonation{Cluster: clusterName})
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, secret)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	token, _, err := client.ACLTokens().Self(nil)
+	if err != nil {
+		// The token expired or was deleted, which ends the impersonation
+		if contains403(err.Error()) || contains401(err.Error()) {
+			setImpersonationCookie(w, r, clusterName, "", -1)
+			writeJSON(w, Impersonation{Cluster: clusterName})
+			return
+		}
+		writeNomadError(w, r, err)
+		return
+	}
+
+	writeJSON(w, impersonation(clusterName, token))
+}
+
+// StopImpersonation handles DELETE /clusters/{cluster}/v1/acl/impersonation.
+// The scoped token is deleted with the admin's own token.
+func (h *Handler) StopImpersonation(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+
+	client, err := h.GetClientWithToken(clusterName, ownToken(r, clusterName))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	h.revokeImpersonation(r, client, clusterName)
+	setImpersonationCookie(w, r, clusterName, "", -1)
+
+	writeJSON(w, Impersonation{Cluster: clusterName})
+}
+
+// revokeImpersonation deletes the scoped token of the running impersonation,
+// if any. A token that already expired has nothing left to delete.
+func (h *Handler) revokeImpersonation(r *http.Request, client NomadAPI, clusterName string) {
+	secret := impersonationToken(r, clusterName)
+	if secret == "" {
+		return
+	}
+
+	scoped, err := h.GetClientWithToken(clusterName, secret)
+	if err != nil {
+		return
+	}
+	token, _, err := scoped.ACLTokens().Self(nil)
+	if err != nil {
+		return
+	}
+
+	entry := AuditEntry{Cluster: clusterName, Action: ActionImpersonationStop, Target: token.AccessorID}
+	if self, _, err := client.ACLTokens().Self(nil); err == nil {
+		entry.Actor = self.Name
+		entry.ActorAccessor = self.AccessorID
+	}
+	if _, err := client.ACLTokens().Delete(token.AccessorID, nil); err != nil {
+		entry.Error = err.Error()
+	}
+	h.audit(r.Context(), entry)
+}
+
+func impersonation(clusterName string, token *api.ACLToken) Impersonation {
+	return Impersonation{
+		Active:     true,
+		Cluster:    clusterName,
+		AccessorID: token.AccessorID,
+		Name:       token.Name,
+		Policies:   token.Policies,
+		ExpiresAt:  token.ExpirationTime,
+	}
+}
+
+// actorName names the owner of a token in the tokens it mints
+func actorName(token *api.ACLToken) string {
+	if token.Name != "" {
+		return token.Name
+	}
+	return token.AccessorID
+}
+
+// impersonationToken returns the secret of the scoped token an admin acts
+// with on a cluster, "" if they are not impersonating
+func impersonationToken(r *http.Request, cluster string) string {
+	if cluster == "" {
+		return ""
+	}
+
+	cookie, err := r.Cookie(impersonationCookiePrefix + auth.SanitizeClusterName(cluster))
+	if err != nil {
+		return ""
+	}
+
+	return cookie.Value
+}
+
+// setImpersonationCookie sets the impersonation cookie of a cluster, or with
+// a negative maxAge clears it
+func setImpersonationCookie(w http.ResponseWriter, r *http.Request, cluster, value string, maxAge int) {
+	name := impersonationCookiePrefix + auth.SanitizeClusterName(cluster)
+	http.SetCookie(w, auth.NewCookie(r, name, value, "/", maxAge))
+}
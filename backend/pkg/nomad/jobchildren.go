@@ -0,0 +1,184 @@
+package nomad
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// JobChildren lists the jobs a periodic or parameterized job launched
+type JobChildren struct {
+	JobID     string `json:"jobId"`
+	Namespace string `json:"namespace"`
+	// Kind is "periodic" or "parameterized"
+	Kind          string                      `json:"kind"`
+	Periodic      *PeriodicSchedule           `json:"periodic,omitempty"`
+	Parameterized *api.ParameterizedJobConfig `json:"parameterized,omitempty"`
+	Summary       *api.JobChildrenSummary     `json:"summary"`
+	// Children are sorted newest first
+	Children []JobChild `json:"children"`
+}
+
+// PeriodicSchedule is the periodic configuration of a job with the time of
+// its next launch, which is nil when it is disabled, or when the job is
+// stopped or its spec has no further time
+type PeriodicSchedule struct {
+	Spec            string     `json:"spec"`
+	Specs           []string   `json:"specs,omitempty"`
+	SpecType        string     `json:"specType"`
+	TimeZone        string     `json:"timeZone"`
+	Enabled         bool       `json:"enabled"`
+	ProhibitOverlap bool       `json:"prohibitOverlap"`
+	NextLaunch      *time.Time `json:"nextLaunch"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// JobChild is one launched instance, with the allocations of all its groups
+type JobChild struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"`
+	Stop       bool      `json:"stop"`
+	SubmitTime time.Time `json:"submitTime"`
+	Queued     int       `json:"queued"`
+	Starting   int       `json:"starting"`
+	Running    int       `json:"running"`
+	Complete   int       `json:"complete"`
+	Failed     int       `json:"failed"`
+	Lost       int       `json:"lost"`
+}
+
+// GetJobChildren handles GET /clusters/{cluster}/v1/job/children?id=jobID
+func (h *Handler) GetJobChildren(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	job, _, err := client.Jobs().Info(jobID, opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	resp := JobChildren{JobID: *job.ID, Namespace: namespaceOrDefault(stringValue(job.Namespace)), Children: []JobChild{}}
+
+	switch {
+	case job.IsPeriodic():
+		resp.Kind = "periodic"
+		resp.Periodic = periodicSchedule(job, time.Now())
+	case job.IsParameterized():
+		resp.Kind = "parameterized"
+		resp.Parameterized = job.ParameterizedJob
+	default:
+		writeError(w, r, fmt.Errorf("job %q is neither periodic nor parameterized", jobID), http.StatusBadRequest)
+		return
+	}
+
+	// Children are named after their parent, "<parent>/periodic-<time>" or
+	// "<parent>/dispatch-<time>-<id>"
+	listOpts := getQueryOptions(r)
+	listOpts.Namespace = resp.Namespace
+	listOpts.Prefix = *job.ID + "/"
+	stubs, _, err := client.Jobs().List(listOpts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	resp.Summary = &api.JobChildrenSummary{}
+	for _, stub := range stubs {
+		if stub.ParentID != *job.ID {
+			continue
+		}
+
+		child := JobChild{
+			ID:         stub.ID,
+			Status:     stub.Status,
+			Stop:       stub.Stop,
+			SubmitTime: time.Unix(0, stub.SubmitTime).UTC(),
+		}
+		if stub.JobSummary != nil {
+			for _, group := range stub.JobSummary.Summary {
+				child.Queued += group.Queued
+				child.Starting += group.Starting
+				child.Running += group.Running
+				child.Complete += group.Complete
+				child.Failed += group.Failed
+				child.Lost += group.Lost
+			}
+		}
+
+		switch stub.Status {
+		case "pending":
+			resp.Summary.Pending++
+		case "running":
+			resp.Summary.Running++
+		default:
+			resp.Summary.Dead++
+		}
+
+		resp.Children = append(resp.Children, child)
+	}
+
+	sort.SliceStable(resp.Children, func(i, j int) bool {
+		return resp.Children[i].SubmitTime.After(resp.Children[j].SubmitTime)
+	})
+
+	h.writeCompositeJSON(w, r, resp)
+}
+
+func periodicSchedule(job *api.Job, now time.Time) *PeriodicSchedule {
+	p := *job.Periodic
+	p.Canonicalize()
+
+	schedule := &PeriodicSchedule{
+		Spec:            *p.Spec,
+		Specs:           p.Specs,
+		SpecType:        *p.SpecType,
+		TimeZone:        *p.TimeZone,
+		Enabled:         *p.Enabled,
+		ProhibitOverlap: *p.ProhibitOverlap,
+	}
+
+	if !schedule.Enabled || job.Stop != nil && *job.Stop {
+		return schedule
+	}
+
+	location, err := p.GetLocation()
+	if err != nil {
+		schedule.Error = err.Error()
+		return schedule
+	}
+
+	next, err := p.Next(now.In(location))
+	switch {
+	case err != nil:
+		schedule.Error = err.Error()
+	case !next.IsZero():
+		next = next.UTC()
+		schedule.NextLaunch = &next
+	}
+
+	return schedule
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
@@ -0,0 +1,189 @@
+package nomad
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+)
+
+const (
+	// compositeCallTimeout bounds each upstream call made by the composite
+	// detail endpoints, so one slow Nomad endpoint cannot stall the whole page
+	compositeCallTimeout = 10 * time.Second
+	// jobDetailEvaluations is the number of most recent evaluations returned
+	jobDetailEvaluations = 20
+)
+
+// JobDetail is the response of the job detail endpoint. Sections that could
+// not be fetched are left empty and their error is reported in Errors.
+// EvaluationChurn is nil when evaluations are not tracked, Ownership when no
+// ownership rule matches the job. Hints are the runbook rules matching the
+// failures of the job's allocations and of its latest or blocked evaluations.
+// Parameters are set for parameterized jobs.
+type JobDetail struct {
+	Job              *api.Job                    `json:"job"`
+	Summary          *api.JobSummary             `json:"summary"`
+	LatestDeployment *api.Deployment             `json:"latestDeployment"`
+	Deployments      []*api.Deployment           `json:"deployments"`
+	Evaluations      []*api.Evaluation           `json:"evaluations"`
+	Allocations      []*api.AllocationListStub   `json:"allocations"`
+	ScaleStatus      *api.JobScaleStatusResponse `json:"scaleStatus"`
+	EvaluationChurn  *EvaluationChurn            `json:"evaluationChurn,omitempty"`
+	Ownership        *JobOwnership               `json:"ownership,omitempty"`
+	Hints            []RunbookHint               `json:"hints,omitempty"`
+	Parameters       *DispatchParameters         `json:"parameters,omitempty"`
+	Errors           map[string]string           `json:"errors,omitempty"`
+}
+
+// DispatchParameters are what dispatching a parameterized job takes, from
+// which the dispatch dialog is built
+type DispatchParameters struct {
+	// Payload is required, optional or forbidden
+	Payload      string   `json:"payload"`
+	MetaRequired []string `json:"metaRequired"`
+	MetaOptional []string `json:"metaOptional"`
+	// MetaDefaults are the values of the job's meta for the optional keys,
+	// which dispatched jobs keep unless the dispatch sets them
+	MetaDefaults map[string]string `json:"metaDefaults,omitempty"`
+}
+
+// dispatchParameters returns the parameters of a parameterized job, nil for
+// other jobs
+func dispatchParameters(job *api.Job) *DispatchParameters {
+	if job == nil || job.ParameterizedJob == nil {
+		return nil
+	}
+
+	config := job.ParameterizedJob
+	params := &DispatchParameters{
+		Payload:      config.Payload,
+		MetaRequired: config.MetaRequired,
+		MetaOptional: config.MetaOptional,
+	}
+	// Nomad's default
+	if params.Payload == "" {
+		params.Payload = "optional"
+	}
+	if params.MetaRequired == nil {
+		params.MetaRequired = []string{}
+	}
+	if params.MetaOptional == nil {
+		params.MetaOptional = []string{}
+	}
+
+	for _, k := range params.MetaOptional {
+		if v, ok := job.Meta[k]; ok {
+			if params.MetaDefaults == nil {
+				params.MetaDefaults = map[string]string{}
+			}
+			params.MetaDefaults[k] = v
+		}
+	}
+
+	return params
+}
+
+// GetJobDetail handles GET /clusters/{cluster}/v1/job/detail?id=jobID
+// It fetches everything the job page needs concurrently in one request.
+func (h *Handler) GetJobDetail(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	jobs := client.Jobs()
+
+	var detail JobDetail
+
+	g := fanout.New(r.Context(), fanout.Options{Timeout: compositeCallTimeout})
+
+	g.Go("job", func(ctx context.Context) (err error) {
+		detail.Job, _, err = jobs.Info(jobID, opts.WithContext(ctx))
+		return err
+	})
+	g.Go("summary", func(ctx context.Context) (err error) {
+		detail.Summary, _, err = jobs.Summary(jobID, opts.WithContext(ctx))
+		return err
+	})
+	g.Go("deployments", func(ctx context.Context) (err error) {
+		detail.Deployments, _, err = jobs.Deployments(jobID, false, opts.WithContext(ctx))
+		return err
+	})
+	g.Go("evaluations", func(ctx context.Context) (err error) {
+		detail.Evaluations, _, err = jobs.Evaluations(jobID, opts.WithContext(ctx))
+		return err
+	})
+	g.Go("allocations", func(ctx context.Context) (err error) {
+		detail.Allocations, _, err = jobs.Allocations(jobID, false, opts.WithContext(ctx))
+		return err
+	})
+	g.Go("scaleStatus", func(ctx context.Context) (err error) {
+		detail.ScaleStatus, _, err = jobs.ScaleStatus(jobID, opts.WithContext(ctx))
+		return err
+	})
+
+	errs := g.Wait()
+
+	// Without the job itself there is nothing to show, so fail the request
+	// the same way GET /job does
+	if err := errs["job"]; err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	sort.Slice(detail.Deployments, func(i, j int) bool {
+		return detail.Deployments[i].CreateIndex > detail.Deployments[j].CreateIndex
+	})
+	if len(detail.Deployments) > 0 {
+		detail.LatestDeployment = detail.Deployments[0]
+	}
+
+	sort.Slice(detail.Evaluations, func(i, j int) bool {
+		return detail.Evaluations[i].CreateIndex > detail.Evaluations[j].CreateIndex
+	})
+	if len(detail.Evaluations) > jobDetailEvaluations {
+		detail.Evaluations = detail.Evaluations[:jobDetailEvaluations]
+	}
+
+	detail.Parameters = dispatchParameters(detail.Job)
+
+	detail.EvaluationChurn = h.evalChurn.snapshot(clusterName, *detail.Job.Namespace, jobID, time.Now())
+
+	detail.Errors = errs.Messages()
+
+	if detail.Ownership, err = h.jobOwnership(r.Context(), clusterName, detail.Job); err != nil {
+		if detail.Errors == nil {
+			detail.Errors = map[string]string{}
+		}
+		detail.Errors["ownership"] = err.Error()
+	}
+
+	var failures []failure
+	for _, alloc := range detail.Allocations {
+		failures = append(failures, taskFailures(alloc.ID, alloc.TaskStates)...)
+	}
+	for i, eval := range detail.Evaluations {
+		// Older evaluations failed to place what later ones did place
+		if i == 0 || eval.Status == "blocked" {
+			failures = append(failures, placementFailures(eval)...)
+		}
+	}
+	detail.Hints = h.runbookHints(r.Context(), clusterName, failures)
+
+	h.writeCompositeJSON(w, r, detail)
+}
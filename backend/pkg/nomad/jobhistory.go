@@ -0,0 +1,217 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+const (
+	// jobHistoryBucket holds one JobHistoryEntry per registration and
+	// deregistration of a job
+	jobHistoryBucket = "job-history"
+	// jobHistoryFollowInterval is how soon clusters added later are followed
+	jobHistoryFollowInterval = time.Minute
+	// jobHistoryPruneInterval is how often entries past the retention are removed
+	jobHistoryPruneInterval = time.Hour
+)
+
+// Kinds of job history entries
+const (
+	JobHistoryRegistered   = "registered"
+	JobHistoryDeregistered = "deregistered"
+)
+
+// JobHistoryEntry is a registration of a job, with the specification it was
+// registered with, or its deregistration, with its last specification
+type JobHistoryEntry struct {
+	Kind    string    `json:"kind"`
+	Version uint64    `json:"version"`
+	Index   uint64    `json:"index"`
+	Time    time.Time `json:"time"`
+	Stop    bool      `json:"stop"`
+	Job     *api.Job  `json:"job"`
+}
+
+// JobHistory is what Caravan saw of a job, newest first, including what
+// Nomad has garbage collected since
+type JobHistory struct {
+	JobID     string            `json:"jobId"`
+	Namespace string            `json:"namespace"`
+	Entries   []JobHistoryEntry `json:"entries"`
+}
+
+// TrackJobHistory records the registrations and deregistrations of the jobs
+// of all clusters, as their event streams report them, until ctx is done.
+// Entries older than retention are removed; a retention of 0 disables the
+// tracking. Like the deployment history, it only sees the jobs the token
+// configured for each cluster can read.
+func (h *Handler) TrackJobHistory(ctx context.Context, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+
+	var pruned time.Time
+
+	h.followClusters(ctx, jobHistoryFollowInterval, func(cluster string) func() {
+		return h.followJobs(ctx, cluster)
+	}, func(string) {
+		if time.Since(pruned) < jobHistoryPruneInterval {
+			return
+		}
+		pruned = time.Now()
+
+		if err := h.pruneJobHistory(ctx, time.Now().Add(-retention)); err != nil && ctx.Err() == nil {
+			logger.Log(logger.LevelWarn, nil, err, "pruning job history")
+		}
+	})
+}
+
+// followJobs records the job registrations and deregistrations of a cluster
+func (h *Handler) followJobs(ctx context.Context, cluster string) (unsubscribe func()) {
+	return h.events.Subscribe(cluster, []api.Topic{api.TopicJob}, 0, func(event api.Event) {
+		kind := JobHistoryRegistered
+		switch event.Type {
+		case "JobRegistered":
+		case "JobDeregistered", "JobBatchDeregistered":
+			kind = JobHistoryDeregistered
+		default:
+			return
+		}
+
+		job, err := event.Job()
+		if err == nil && job != nil {
+			err = h.recordJobHistory(ctx, cluster, kind, event.Index, []*api.Job{job})
+		}
+
+		if err != nil && ctx.Err() == nil {
+			logger.Log(logger.LevelWarn, map[string]string{"cluster": cluster}, err, "recording job history")
+		}
+	})
+}
+
+// recordJobHistory stores the registrations of the given job versions, keyed
+// by their job modify index, or their deregistration at index. Entries
+// already recorded are left alone.
+func (h *Handler) recordJobHistory(ctx context.Context, cluster, kind string, index uint64, jobs []*api.Job) error {
+	for _, job := range jobs {
+		entry := JobHistoryEntry{
+			Kind:    kind,
+			Version: uint64Value(job.Version),
+			Index:   index,
+			Time:    time.Now().UTC(),
+			Stop:    job.Stop != nil && *job.Stop,
+			Job:     job,
+		}
+		if kind == JobHistoryRegistered {
+			entry.Index = uint64Value(job.JobModifyIndex)
+			if job.SubmitTime != nil && *job.SubmitTime > 0 {
+				entry.Time = time.Unix(0, *job.SubmitTime).UTC()
+			}
+		}
+
+		namespace := namespaceOrDefault(stringValue(job.Namespace))
+		key := jobKeyPrefix(cluster, namespace, stringValue(job.ID)) + fmt.Sprintf("%020d-%s", entry.Index, kind)
+
+		var existing JobHistoryEntry
+		err := store.GetJSON(ctx, h.store, jobHistoryBucket, key, &existing)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+
+		if err := store.PutJSON(ctx, h.store, jobHistoryBucket, key, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pruneJobHistory removes the entries older than before
+func (h *Handler) pruneJobHistory(ctx context.Context, before time.Time) error {
+	entries, err := h.store.List(ctx, jobHistoryBucket, "")
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		var entry JobHistoryEntry
+		if err := json.Unmarshal(e.Value, &entry); err != nil {
+			return err
+		}
+
+		if entry.Time.Before(before) {
+			if err := h.store.Delete(ctx, jobHistoryBucket, e.Key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func uint64Value(v *uint64) uint64 {
+	if v == nil {
+		return 0
+	}
+
+	return *v
+}
+
+// GetJobHistory handles GET /clusters/{cluster}/v1/job/history?id=jobID
+// The versions Nomad still has are recorded first, so they show up even
+// when background tracking is disabled.
+func (h *Handler) GetJobHistory(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	namespace := namespaceOrDefault(opts.Namespace)
+
+	// Once Nomad garbage collected the job, only the recorded history is
+	// left. Any other error, such as a permission error, must not fall
+	// through to it.
+	versions, _, _, err := client.Jobs().Versions(jobID, false, opts)
+	if err != nil && classifyNomadError(err).Status() != http.StatusNotFound {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	if err := h.recordJobHistory(r.Context(), clusterName, JobHistoryRegistered, 0, versions); err != nil {
+		writeError(w, r, fmt.Errorf("recording job versions: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := store.ListJSON[JobHistoryEntry](r.Context(), h.store, jobHistoryBucket,
+		jobKeyPrefix(clusterName, namespace, jobID))
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Index > entries[j].Index })
+
+	writeJSON(w, JobHistory{JobID: jobID, Namespace: namespace, Entries: entries})
+}
@@ -2,10 +2,15 @@ package nomad
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/jobdiff"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
 )
 
 // ListJobs handles GET /clusters/{cluster}/v1/jobs
@@ -15,18 +20,22 @@ func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	opts := getQueryOptions(r)
-	jobs, _, err := client.Jobs().List(opts)
+	opts, err := h.listOptions(r)
 	if err != nil {
-		writeNomadError(w, err)
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	jobs, meta, err := client.Jobs().List(opts)
+	if err != nil {
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, jobs)
+	writeList(h, w, r, opts, jobs, meta)
 }
 
 // GetJob handles GET /clusters/{cluster}/v1/job?id=jobID
@@ -35,24 +44,29 @@ func (h *Handler) GetJob(w http.ResponseWriter, r *http.Request) {
 	token := getToken(r)
 	jobID := r.URL.Query().Get("id")
 	if jobID == "" {
-		writeError(w, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
 		return
 	}
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	job, _, err := client.Jobs().Info(jobID, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, job)
+	var index uint64
+	if job.ModifyIndex != nil {
+		index = *job.ModifyIndex
+	}
+
+	writeIndexed(w, r, index, job)
 }
 
 // UpdateJob handles POST /clusters/{cluster}/v1/job/{jobID}
@@ -62,56 +76,97 @@ func (h *Handler) UpdateJob(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	var job api.Job
-	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
-		writeError(w, err, http.StatusBadRequest)
+	// The job may carry an annotation for the version being registered
+	var req struct {
+		api.Job
+		CaravanAnnotation *VersionAnnotation `json:"CaravanAnnotation,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
+	job := req.Job
 	opts := getWriteOptions(r)
 	resp, _, err := client.Jobs().Register(&job, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
+	if req.CaravanAnnotation != nil && job.ID != nil {
+		if err := h.annotateRegistration(r, clusterName, client, &job, resp, *req.CaravanAnnotation); err != nil {
+			// The job is registered at this point, so only report the failure
+			logger.Log(logger.LevelWarn, map[string]string{"cluster": clusterName, "job": *job.ID},
+				err, "annotating job version")
+			w.Header().Set("X-Caravan-Annotation-Error", err.Error())
+		}
+	}
+
 	writeJSON(w, resp)
 }
 
+// PlanJob handles POST /clusters/{cluster}/v1/job/plan?diff=true
+// It runs the scheduler on the job without registering it, and returns the
+// annotated diff against the registered version, the scheduler's warnings
+// and the task groups that could not be placed, to preview a change.
+func (h *Handler) PlanJob(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	var job api.Job
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	diff := r.URL.Query().Get("diff") != "false"
+	plan, _, err := client.Jobs().Plan(&job, diff, getWriteOptions(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	writeJSON(w, plan)
+}
+
 // DeleteJob handles DELETE /clusters/{cluster}/v1/job?id=jobID
 func (h *Handler) DeleteJob(w http.ResponseWriter, r *http.Request) {
 	clusterName := getClusterName(r)
 	token := getToken(r)
 	jobID := r.URL.Query().Get("id")
 	if jobID == "" {
-		writeError(w, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
 		return
 	}
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getWriteOptions(r)
 
-	// Check if purge is requested
-	purge := r.URL.Query().Get("purge") == "true"
-
-	var resp string
-	var meta *api.WriteMeta
-	if purge {
-		resp, meta, err = client.Jobs().Deregister(jobID, true, opts)
-	} else {
-		resp, meta, err = client.Jobs().Deregister(jobID, false, opts)
+	// Purging removes the job and its history for good
+	if r.URL.Query().Get("purge") == "true" {
+		h.runDestructive(w, r, client, ActionJobPurge, opts.Namespace, jobID)
+		return
 	}
+
+	resp, meta, err := client.Jobs().Deregister(jobID, false, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -121,65 +176,126 @@ func (h *Handler) DeleteJob(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// DispatchRequest is the body of the dispatch endpoint
+type DispatchRequest struct {
+	Payload []byte            `json:"payload"`
+	Meta    map[string]string `json:"meta"`
+	// IDPrefix goes into the ID of the dispatched job, after the parent's,
+	// such as report/dispatch-acme-1700000000-0d3c…
+	IDPrefix string `json:"idPrefix,omitempty"`
+	// IdempotencyToken makes retrying a dispatch, such as after a timeout,
+	// return the job dispatched first rather than dispatch another
+	IdempotencyToken string `json:"idempotencyToken,omitempty"`
+}
+
 // DispatchJob handles POST /clusters/{cluster}/v1/job/dispatch?id=jobID
 func (h *Handler) DispatchJob(w http.ResponseWriter, r *http.Request) {
 	clusterName := getClusterName(r)
 	token := getToken(r)
 	jobID := r.URL.Query().Get("id")
 	if jobID == "" {
-		writeError(w, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
 		return
 	}
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	var dispatchReq struct {
-		Payload []byte            `json:"payload"`
-		Meta    map[string]string `json:"meta"`
-	}
+	var dispatchReq DispatchRequest
 	if err := json.NewDecoder(r.Body).Decode(&dispatchReq); err != nil {
-		writeError(w, err, http.StatusBadRequest)
+		writeError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
 	opts := getWriteOptions(r)
-	resp, _, err := client.Jobs().Dispatch(jobID, dispatchReq.Meta, dispatchReq.Payload, "", opts)
+	opts.IdempotencyToken = dispatchReq.IdempotencyToken
+	resp, _, err := client.Jobs().Dispatch(jobID, dispatchReq.Meta, dispatchReq.Payload, dispatchReq.IDPrefix, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
+	// The job is dispatched at this point, so only log a failure to record it
+	namespace := namespaceOrDefault(opts.Namespace)
+	if err := h.recordDispatch(r.Context(), clusterName, namespace, jobID, client, dispatchReq, resp); err != nil {
+		logger.Log(logger.LevelWarn, map[string]string{"cluster": clusterName, "job": jobID}, err, "recording dispatch")
+	}
+
 	writeJSON(w, resp)
 }
 
+// EvaluateJobRequest is the optional body of the evaluate endpoint
+type EvaluateJobRequest struct {
+	// ForceReschedule reschedules failed allocations even when their
+	// reschedule policy gave up on them
+	ForceReschedule bool `json:"forceReschedule"`
+}
+
+// EvaluateJobResponse is the response of the evaluate endpoint
+type EvaluateJobResponse struct {
+	EvalID string `json:"evalId"`
+}
+
+// EvaluateJob handles POST /clusters/{cluster}/v1/job/evaluate?id=jobID
+// It creates a new evaluation of the job, to get a stuck job scheduled again
+func (h *Handler) EvaluateJob(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	var evalReq EvaluateJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&evalReq); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	evalID, _, err := client.Jobs().EvaluateWithOpts(jobID, api.EvalOptions{ForceReschedule: evalReq.ForceReschedule},
+		getWriteOptions(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	writeJSON(w, EvaluateJobResponse{EvalID: evalID})
+}
+
 // GetJobAllocations handles GET /clusters/{cluster}/v1/job/allocations?id=jobID
 func (h *Handler) GetJobAllocations(w http.ResponseWriter, r *http.Request) {
 	clusterName := getClusterName(r)
 	token := getToken(r)
 	jobID := r.URL.Query().Get("id")
 	if jobID == "" {
-		writeError(w, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
 		return
 	}
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	allocs, _, err := client.Jobs().Allocations(jobID, false, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, allocs)
+	writeJSONArray(w, allocs)
 }
 
 // GetJobVersions handles GET /clusters/{cluster}/v1/job/versions?id=jobID
@@ -188,42 +304,135 @@ func (h *Handler) GetJobVersions(w http.ResponseWriter, r *http.Request) {
 	token := getToken(r)
 	jobID := r.URL.Query().Get("id")
 	if jobID == "" {
-		writeError(w, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
 		return
 	}
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	versions, diffs, _, err := client.Jobs().Versions(jobID, false, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
+		return
+	}
+
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = api.DefaultNamespace
+	}
+
+	annotations, err := h.versionAnnotations(r.Context(), clusterName, namespace, jobID)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
 	writeJSON(w, map[string]interface{}{
-		"versions": versions,
-		"diffs":    diffs,
+		"versions":    versions,
+		"diffs":       diffs,
+		"annotations": annotations,
 	})
 }
 
+// JobVersionDiff is the response of the job diff endpoint
+type JobVersionDiff struct {
+	From uint64       `json:"from"`
+	To   uint64       `json:"to"`
+	Diff *api.JobDiff `json:"diff"`
+}
+
+// GetJobDiff handles GET /clusters/{cluster}/v1/job/diff?id=jobID&from=N&to=M
+// It compares any two versions of a job, to the latest one without to,
+// rather than only adjacent versions like GetJobVersions.
+func (h *Handler) GetJobDiff(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	query := r.URL.Query()
+	jobID := query.Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	from, err := strconv.ParseUint(query.Get("from"), 10, 64)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("from must be a job version"), http.StatusBadRequest)
+		return
+	}
+
+	var to *uint64
+	if s := query.Get("to"); s != "" {
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("to must be a job version"), http.StatusBadRequest)
+			return
+		}
+		to = &v
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	versions, _, _, err := client.Jobs().Versions(jobID, false, getQueryOptions(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	find := func(version uint64) *api.Job {
+		for _, v := range versions {
+			if v.Version != nil && *v.Version == version {
+				return v
+			}
+		}
+		return nil
+	}
+
+	older := find(from)
+	if older == nil {
+		writeError(w, r, fmt.Errorf("job %q has no version %d", jobID, from), http.StatusNotFound)
+		return
+	}
+
+	// Versions are newest first, and a job has at least one
+	newer := versions[0]
+	if to != nil {
+		if newer = find(*to); newer == nil {
+			writeError(w, r, fmt.Errorf("job %q has no version %d", jobID, *to), http.StatusNotFound)
+			return
+		}
+	}
+
+	diff, err := jobdiff.Jobs(older, newer)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, JobVersionDiff{From: *older.Version, To: *newer.Version, Diff: diff})
+}
+
 // ScaleJob handles POST /clusters/{cluster}/v1/job/scale?id=jobID
 func (h *Handler) ScaleJob(w http.ResponseWriter, r *http.Request) {
 	clusterName := getClusterName(r)
 	token := getToken(r)
 	jobID := r.URL.Query().Get("id")
 	if jobID == "" {
-		writeError(w, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
 		return
 	}
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -234,14 +443,14 @@ func (h *Handler) ScaleJob(w http.ResponseWriter, r *http.Request) {
 		Meta   map[string]interface{} `json:"meta"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&scaleReq); err != nil {
-		writeError(w, err, http.StatusBadRequest)
+		writeError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
 	opts := getWriteOptions(r)
 	resp, _, err := client.Jobs().Scale(jobID, scaleReq.Target["group"], scaleReq.Count, "Scaled via Caravan", scaleReq.Error, scaleReq.Meta, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -254,22 +463,79 @@ func (h *Handler) GetJobEvaluations(w http.ResponseWriter, r *http.Request) {
 	token := getToken(r)
 	jobID := r.URL.Query().Get("id")
 	if jobID == "" {
-		writeError(w, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
 		return
 	}
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	evals, _, err := client.Jobs().Evaluations(jobID, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
+		return
+	}
+
+	writeJSONArray(w, evals)
+}
+
+// GetJobDeployments handles GET /clusters/{cluster}/v1/job/deployments?id=jobID.
+// With all=true it includes the deployments of earlier jobs of the same ID.
+func (h *Handler) GetJobDeployments(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	deployments, _, err := client.Jobs().Deployments(jobID, r.URL.Query().Get("all") == "true", opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	writeJSONArray(w, deployments)
+}
+
+// GetJobLatestDeployment handles GET /clusters/{cluster}/v1/job/deployment?id=jobID
+func (h *Handler) GetJobLatestDeployment(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	deployment, _, err := client.Jobs().LatestDeployment(jobID, opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+	if deployment == nil {
+		writeError(w, r, fmt.Errorf("job %q has no deployment", jobID), http.StatusNotFound)
 		return
 	}
 
-	writeJSON(w, evals)
+	writeJSON(w, deployment)
 }
@@ -0,0 +1,51 @@
+package nomad
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+)
+
+// jsonArrayBuffer is how much of a list writeJSONArray holds before writing
+// it to the client
+const jsonArrayBuffer = 32 << 10
+
+// writeJSONArray writes list as writeJSON would, byte for byte, but encodes
+// its items one at a time into a small buffer that goes out whenever it
+// fills, so that a list of 50k evaluations is never held encoded whole.
+// Once the first bytes are out the status can no longer change: an item
+// failing to encode ends the response early, which clients see as invalid
+// JSON.
+func writeJSONArray[T any](w http.ResponseWriter, list []T) {
+	if list == nil {
+		writeJSON(w, list)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	out := bufio.NewWriterSize(w, jsonArrayBuffer)
+	var item bytes.Buffer
+	enc := json.NewEncoder(&item)
+
+	out.WriteByte('[')
+	for i := range list {
+		item.Reset()
+		if err := enc.Encode(list[i]); err != nil {
+			logger.Log(logger.LevelError, nil, err, "writeJSONArray: Failed to encode list item")
+			out.Flush()
+			return
+		}
+
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		// Without the newline Encode ends each item with
+		out.Write(item.Bytes()[:item.Len()-1])
+	}
+	out.WriteString("]\n")
+	out.Flush()
+}
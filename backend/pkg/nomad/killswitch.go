@@ -0,0 +1,156 @@
+package nomad
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/killswitch"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/streams"
+)
+
+// ActionKillSwitch is the audit action of toggling a kill switch
+const ActionKillSwitch = "killswitch.toggle"
+
+// featureClusterPaths are the patterns of the cluster endpoints each kill
+// switch turns off, relative to /api/clusters/{cluster}
+var featureClusterPaths = map[string][]string{
+	killswitch.Events: {"/v1/event/stream", "/v1/watch", "/v1/job/events", "/v1/deployment/*/watch"},
+	killswitch.Logs:   {"/v1/allocation/*/logs/*", "/v1/allocation/*/file/stream"},
+	killswitch.Aggregation: {
+		"/graph", "/devices", "/v1/jobs/evaluation-churn", "/v1/job/detail", "/v1/job/coverage",
+		"/v1/node/*/detail",
+	},
+}
+
+// featureOf returns the feature serving a request path, "" if no kill
+// switch covers it
+func featureOf(urlPath string) string {
+	switch {
+	case strings.HasSuffix(urlPath, "/wsMultiplexer"):
+		return killswitch.Events
+	case strings.HasSuffix(urlPath, "/api/graphql"):
+		return killswitch.Aggregation
+	}
+
+	_, rest, ok := splitClusterPath(urlPath)
+	if !ok {
+		return ""
+	}
+
+	for _, feature := range killswitch.Features {
+		for _, pattern := range featureClusterPaths[feature] {
+			if matched, _ := path.Match(pattern, rest); matched {
+				return feature
+			}
+		}
+	}
+
+	return ""
+}
+
+// WithKillSwitches sets the kill switches, with the features the
+// configuration disables. Without it every feature starts enabled.
+func WithKillSwitches(s *killswitch.Set) Option {
+	return func(h *Handler) {
+		h.killSwitches = s
+	}
+}
+
+// KillSwitchMiddleware answers requests to switched off features with 503
+func (h *Handler) KillSwitchMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		feature := featureOf(r.URL.Path)
+		if feature == "" || !h.killSwitches.Disabled(feature) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Retry-After", "60")
+		apierror.Write(w, r, apierror.New(http.StatusServiceUnavailable, apierror.CodeFeatureDisabled,
+			feature+" is switched off by an operator").
+			WithDetails(map[string]string{"feature": feature}))
+	})
+}
+
+// KillSwitchToggle is the body of PUT /api/admin/kill-switches/{feature}
+type KillSwitchToggle struct {
+	Disabled bool   `json:"disabled"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// ListKillSwitches handles GET /api/admin/kill-switches
+func (h *Handler) ListKillSwitches(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireManagement(w, r, ""); !ok {
+		return
+	}
+
+	writeJSON(w, h.killSwitches.List())
+}
+
+// ToggleKillSwitch handles PUT /api/admin/kill-switches/{feature}
+// Switching a feature off also closes its open streams, so that the load
+// they cause stops right away rather than when clients disconnect.
+func (h *Handler) ToggleKillSwitch(w http.ResponseWriter, r *http.Request) {
+	self, ok := h.requireManagement(w, r, "")
+	if !ok {
+		return
+	}
+
+	var toggle KillSwitchToggle
+	if err := json.NewDecoder(r.Body).Decode(&toggle); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	actor, accessor := "anonymous", ""
+	if self != nil {
+		actor, accessor = actorName(self), self.AccessorID
+	}
+
+	feature := r.PathValue("feature")
+	sw, err := h.killSwitches.Toggle(feature, toggle.Disabled, toggle.Reason, actor)
+	if errors.Is(err, killswitch.ErrUnknownFeature) {
+		writeError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	entry := AuditEntry{Action: ActionKillSwitch, Target: feature, Actor: actor, ActorAccessor: accessor}
+	if toggle.Disabled {
+		entry.Target += " off"
+	} else {
+		entry.Target += " on"
+	}
+	h.audit(r.Context(), entry)
+
+	if toggle.Disabled {
+		closed := h.closeFeatureStreams(feature)
+		logger.Log(logger.LevelWarn, map[string]string{
+			"feature": feature,
+			"actor":   actor,
+			"reason":  toggle.Reason,
+		}, nil, "feature switched off, closed "+strconv.Itoa(closed)+" streams")
+	}
+
+	writeJSON(w, sw)
+}
+
+// closeFeatureStreams closes the open streams of a feature, returning how
+// many there were
+func (h *Handler) closeFeatureStreams(feature string) int {
+	closed := 0
+	for _, s := range h.streams.List() {
+		topic, _, _ := strings.Cut(s.Topic, "?")
+		subscription := s.Kind == streams.KindSubscription && feature == killswitch.Events
+		if (subscription || featureOf(topic) == feature) && h.streams.Close(s.ID) {
+			closed++
+		}
+	}
+
+	return closed
+}
@@ -0,0 +1,91 @@
+package nomad
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/hclfmt"
+	"github.com/caravan-nomad/caravan/backend/pkg/lint"
+)
+
+// LintRequest is the body of the lint endpoint: either a job, as sent to
+// register it, or an HCL specification with its variables
+type LintRequest struct {
+	Job       *api.Job `json:"job,omitempty"`
+	HCL       string   `json:"hcl,omitempty"`
+	Variables string   `json:"variables,omitempty"`
+}
+
+// LintResponse is the response of the lint endpoint. Diagnostics are the
+// errors of an HCL specification that could not be parsed, in which case
+// there are no findings.
+type LintResponse struct {
+	Findings    []lint.Finding      `json:"findings"`
+	Diagnostics []hclfmt.Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// WithLinter sets the rules jobs are linted with. Without it, or with a nil
+// linter, the built-in rules apply
+func WithLinter(l *lint.Linter) Option {
+	return func(h *Handler) {
+		h.linter = l
+	}
+}
+
+// LintRules handles GET /api/utils/job/lint/rules
+func (h *Handler) LintRules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.linter.Rules())
+}
+
+// LintJob handles POST /api/utils/job/lint?cluster=
+// An HCL specification is parsed by the cluster's Nomad, without the
+// defaults Nomad fills in, so the rules see what was written.
+func (h *Handler) LintJob(w http.ResponseWriter, r *http.Request) {
+	var req LintRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHCLSize)).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	job := req.Job
+
+	if job == nil {
+		if req.HCL == "" {
+			writeError(w, r, errors.New("either job or hcl is required"), http.StatusBadRequest)
+			return
+		}
+
+		if diags := hclfmt.Check([]byte(req.HCL)); hclfmt.HasErrors(diags) {
+			writeJSON(w, LintResponse{Findings: []lint.Finding{}, Diagnostics: diags})
+			return
+		}
+
+		clusterName := r.URL.Query().Get("cluster")
+		if clusterName == "" {
+			writeError(w, r, errors.New("cluster is required to parse HCL"), http.StatusBadRequest)
+			return
+		}
+
+		client, err := h.GetClientWithToken(clusterName, getToken(r))
+		if err != nil {
+			writeNomadError(w, r, err)
+			return
+		}
+
+		var diags []hclfmt.Diagnostic
+		job, diags, err = parseJobHCL(client, &HCLRequest{HCL: req.HCL, Variables: req.Variables}, false)
+		if err != nil {
+			writeNomadError(w, r, err)
+			return
+		}
+		if job == nil {
+			writeJSON(w, LintResponse{Findings: []lint.Finding{}, Diagnostics: diags})
+			return
+		}
+	}
+
+	writeJSON(w, LintResponse{Findings: h.linter.Lint(job)})
+}
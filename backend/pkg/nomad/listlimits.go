@@ -0,0 +1,100 @@
+package nomad
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+)
+
+// nextTokenHeader carries the token of the next page of a list, as Nomad
+// names it
+const nextTokenHeader = "X-Nomad-NextToken"
+
+// ListLimits bound the size of list responses
+type ListLimits struct {
+	// DefaultPageSize is the page size of paginated lists without per_page
+	DefaultPageSize int `json:"defaultPageSize"`
+	// MaxListSize is the most items a list returns without pagination, and
+	// the largest page; 0 is no limit
+	MaxListSize int `json:"maxListSize"`
+}
+
+// ListTooLarge are the details of a list refused for its size
+type ListTooLarge struct {
+	MaxListSize int `json:"maxListSize"`
+	// PerPage is the page size to ask for instead
+	PerPage int `json:"perPage"`
+}
+
+// WithListLimits sets the page size of paginated lists and the most items
+// the list endpoints return without pagination
+func WithListLimits(limits ListLimits) Option {
+	return func(h *Handler) {
+		h.listLimits = limits
+	}
+}
+
+// ListLimits returns the limits of list responses, for the clients to
+// paginate accordingly
+func (h *Handler) ListLimits() ListLimits {
+	return h.listLimits
+}
+
+// listOptions returns the query options of a list endpoint. A list is
+// paginated with ?per_page= or ?next_token=, as with Nomad. Other lists are
+// read one item past the limit, so that reading them costs no more than
+// finding out they are too large.
+func (h *Handler) listOptions(r *http.Request) (*api.QueryOptions, error) {
+	opts := getQueryOptions(r)
+	q := r.URL.Query()
+	limits := h.listLimits
+
+	opts.NextToken = q.Get("next_token")
+
+	if v := q.Get("per_page"); v != "" {
+		perPage, err := strconv.Atoi(v)
+		if err != nil || perPage < 1 {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, "per_page must be a positive number")
+		}
+		if limits.MaxListSize > 0 && perPage > limits.MaxListSize {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeBadRequest,
+				fmt.Sprintf("per_page must be at most %d", limits.MaxListSize))
+		}
+		opts.PerPage = int32(perPage)
+	}
+
+	switch {
+	case opts.PerPage == 0 && opts.NextToken != "":
+		opts.PerPage = int32(limits.DefaultPageSize)
+	case opts.PerPage == 0 && limits.MaxListSize > 0:
+		opts.PerPage = int32(limits.MaxListSize + 1)
+	}
+
+	return opts, nil
+}
+
+// writeList writes a list read with listOptions, or refuses it with 413
+// Payload Too Large if it is past the limit and was not paginated
+func writeList[T any](h *Handler, w http.ResponseWriter, r *http.Request, opts *api.QueryOptions, list []T,
+	meta *api.QueryMeta,
+) {
+	limit := h.listLimits.MaxListSize
+	paginated := r.URL.Query().Get("per_page") != "" || opts.NextToken != ""
+
+	if !paginated && limit > 0 && len(list) > limit {
+		writeError(w, r, apierror.New(http.StatusRequestEntityTooLarge, apierror.CodeListTooLarge,
+			fmt.Sprintf("the list has more than %d items, paginate it with per_page and next_token", limit)).
+			WithDetails(ListTooLarge{MaxListSize: limit, PerPage: h.listLimits.DefaultPageSize}),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if paginated && meta != nil && meta.NextToken != "" {
+		w.Header().Set(nextTokenHeader, meta.NextToken)
+	}
+
+	writeJSONArray(w, list)
+}
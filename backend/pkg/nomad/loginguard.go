@@ -0,0 +1,200 @@
+package nomad
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/forwarded"
+	"github.com/caravan-nomad/caravan/backend/pkg/telemetry"
+)
+
+const (
+	// loginFailuresPerClient is how many logins a client may fail before it
+	// has to wait between attempts
+	loginFailuresPerClient = 5
+	// loginFailuresPerCluster is the same for all clients of a cluster
+	// together, against guessing spread over many addresses
+	loginFailuresPerCluster = 50
+	// loginBackoff is the first wait, doubled with each further failure
+	loginBackoff = time.Second
+	// loginMaxBackoff caps the wait
+	loginMaxBackoff = 15 * time.Minute
+	// loginFailureWindow is how long failures are remembered after the last one
+	loginFailureWindow = 30 * time.Minute
+)
+
+// Scopes of a lockout
+const (
+	lockoutClient  = "client"
+	lockoutCluster = "cluster"
+)
+
+type loginKey struct {
+	scope, value string
+}
+
+type loginFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// loginGuard slows token guessing down by counting the failed logins of each
+// client and cluster, and making them wait ever longer between attempts once
+// they failed too often
+type loginGuard struct {
+	mu       sync.Mutex
+	failures map[loginKey]*loginFailures
+}
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{failures: make(map[loginKey]*loginFailures)}
+}
+
+func loginKeys(r *http.Request, cluster string) []loginKey {
+	return []loginKey{
+		{scope: lockoutClient, value: forwarded.ClientIP(r)},
+		{scope: lockoutCluster, value: cluster},
+	}
+}
+
+// retryAfter returns how long the login attempt must wait, 0 if it may be
+// made now
+func (g *loginGuard) retryAfter(r *http.Request, cluster string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range loginKeys(r, cluster) {
+		if f, ok := g.failures[key]; ok {
+			wait = max(wait, time.Until(f.lockedUntil))
+		}
+	}
+
+	return wait
+}
+
+// fail counts a failed login and returns the scopes it locked out
+func (g *loginGuard) fail(r *http.Request, cluster string) []loginKey {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.prune(now)
+
+	var locked []loginKey
+	for _, key := range loginKeys(r, cluster) {
+		f, ok := g.failures[key]
+		if !ok {
+			f = &loginFailures{}
+			g.failures[key] = f
+		}
+
+		f.count++
+		f.last = now
+
+		limit := loginFailuresPerClient
+		if key.scope == lockoutCluster {
+			limit = loginFailuresPerCluster
+		}
+		if f.count < limit {
+			continue
+		}
+
+		backoff := loginMaxBackoff
+		if exp := f.count - limit; exp < 20 {
+			backoff = min(loginBackoff<<exp, loginMaxBackoff)
+		}
+		f.lockedUntil = now.Add(backoff)
+		locked = append(locked, key)
+	}
+
+	return locked
+}
+
+// succeed forgets the failures of the client. Those of the cluster are
+// kept, a valid token does not tell the other attempts apart.
+func (g *loginGuard) succeed(r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.failures, loginKey{scope: lockoutClient, value: forwarded.ClientIP(r)})
+}
+
+func (g *loginGuard) prune(now time.Time) {
+	for key, f := range g.failures {
+		if now.Sub(f.last) > loginFailureWindow && now.After(f.lockedUntil) {
+			delete(g.failures, key)
+		}
+	}
+}
+
+// allowLogin rejects a login attempt made before the client or cluster may
+// try again, and reports whether the attempt may go on
+func (h *Handler) allowLogin(w http.ResponseWriter, r *http.Request, cluster string) bool {
+	wait := h.logins.retryAfter(r, cluster)
+	if wait <= 0 {
+		return true
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	apierror.Write(w, r, apierror.New(http.StatusTooManyRequests, apierror.CodeTooManyRequests,
+		fmt.Sprintf("too many failed logins, try again in %d seconds", seconds)).
+		WithCluster(cluster))
+
+	return false
+}
+
+// loginFailed counts a failed login if it failed on its credentials; Nomad
+// being unreachable says nothing about them
+func (h *Handler) loginFailed(r *http.Request, cluster string, err error) {
+	switch classifyNomadError(err).Status() {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+	default:
+		return
+	}
+
+	telemetry.RecordLoginFailure(cluster)
+
+	for _, key := range h.logins.fail(r, cluster) {
+		telemetry.RecordLoginLockout(cluster, key.scope)
+		h.audit(context.WithoutCancel(r.Context()), AuditEntry{
+			Cluster: cluster,
+			Action:  "login-lockout",
+			Target:  key.scope + " " + key.value,
+			Actor:   "anonymous",
+			Error:   err.Error(),
+		})
+	}
+}
+
+// loginSucceeded clears the failures of the client
+func (h *Handler) loginSucceeded(r *http.Request) {
+	h.logins.succeed(r)
+}
+
+// errLoginLocked is the error of a cluster a login was not tried on because
+// the client or cluster has to wait. loginFailed does not count it.
+var errLoginLocked = apierror.New(http.StatusTooManyRequests, apierror.CodeTooManyRequests,
+	"too many failed logins, try again later")
+
+// loginsDone settles a login tried on several clusters at once. A token
+// that only some of the clusters know is no guess, so the failures, by
+// cluster, are only counted when the login succeeded on none of them.
+func (h *Handler) loginsDone(r *http.Request, failures map[string]error, succeeded bool) {
+	if succeeded {
+		h.loginSucceeded(r)
+		return
+	}
+
+	for cluster, err := range failures {
+		h.loginFailed(r, cluster, err)
+	}
+}
@@ -0,0 +1,99 @@
+package nomad
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+)
+
+// NodeDrainStatus summarizes the drain state of a node
+type NodeDrainStatus struct {
+	Draining    bool               `json:"draining"`
+	Eligibility string             `json:"eligibility"`
+	Strategy    *api.DrainStrategy `json:"strategy,omitempty"`
+	LastDrain   *api.DrainMetadata `json:"lastDrain,omitempty"`
+	// RemainingAllocations counts the allocations still running on the node
+	RemainingAllocations int `json:"remainingAllocations"`
+}
+
+// NodeDetail is the response of the node detail endpoint. Sections that could
+// not be fetched are left empty and their error is reported in Errors.
+type NodeDetail struct {
+	Node        *api.Node         `json:"node"`
+	Allocations []*api.Allocation `json:"allocations"`
+	Stats       *api.HostStats    `json:"stats"`
+	Drain       *NodeDrainStatus  `json:"drain"`
+	// Index is the Raft index the node was read at
+	Index     uint64            `json:"index"`
+	FetchedAt time.Time         `json:"fetchedAt"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// GetNodeDetail handles GET /clusters/{cluster}/v1/node/{nodeID}/detail
+// Host stats are read from the client agent and are commonly unavailable for
+// down nodes, which is reported in errors rather than failing the request.
+func (h *Handler) GetNodeDetail(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	nodeID := r.PathValue("nodeID")
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	nodes := client.Nodes()
+
+	detail := NodeDetail{FetchedAt: time.Now().UTC()}
+
+	g := fanout.New(r.Context(), fanout.Options{Timeout: compositeCallTimeout})
+
+	g.Go("node", func(ctx context.Context) error {
+		node, meta, err := nodes.Info(nodeID, opts.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		detail.Node = node
+		detail.Index = meta.LastIndex
+
+		return nil
+	})
+	g.Go("allocations", func(ctx context.Context) (err error) {
+		detail.Allocations, _, err = nodes.Allocations(nodeID, opts.WithContext(ctx))
+		return err
+	})
+	g.Go("stats", func(ctx context.Context) (err error) {
+		detail.Stats, err = nodes.Stats(nodeID, opts.WithContext(ctx))
+		return err
+	})
+
+	errs := g.Wait()
+
+	if err := errs["node"]; err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	detail.Drain = &NodeDrainStatus{
+		Draining:    detail.Node.DrainStrategy != nil,
+		Eligibility: detail.Node.SchedulingEligibility,
+		Strategy:    detail.Node.DrainStrategy,
+		LastDrain:   detail.Node.LastDrain,
+	}
+
+	for _, alloc := range detail.Allocations {
+		if !alloc.ClientTerminalStatus() {
+			detail.Drain.RemainingAllocations++
+		}
+	}
+
+	detail.Errors = errs.Messages()
+
+	h.writeCompositeJSON(w, r, detail)
+}
@@ -0,0 +1,276 @@
+package nomad
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+const (
+	// Dynamic port range of nodes that do not configure one
+	defaultMinDynamicPort = 20000
+	defaultMaxDynamicPort = 32000
+	// cniPluginAttribute prefixes the node attributes giving the version of
+	// each CNI plugin found
+	cniPluginAttribute = "plugins.cni.version."
+)
+
+// NodeNetworks is the network setup of a node as fingerprinted by its client
+// and the host ports its allocations hold
+type NodeNetworks struct {
+	NodeID string `json:"nodeId"`
+	Name   string `json:"name"`
+	// HostNetworks are the host_network blocks of the client configuration
+	HostNetworks []HostNetwork `json:"hostNetworks"`
+	// Networks are the fingerprinted interfaces
+	Networks []NodeNetwork `json:"networks"`
+	// CNIPlugins maps the CNI plugins found to their version
+	CNIPlugins map[string]string `json:"cniPlugins"`
+	// BridgeNetwork is whether the node can run bridge networking
+	BridgeNetwork     bool   `json:"bridgeNetwork"`
+	ReservedHostPorts string `json:"reservedHostPorts"`
+	// Ports sums up the port usage of each host address
+	Ports []PortUsage `json:"ports"`
+}
+
+// HostNetwork is a named host network allocations can bind their ports to
+type HostNetwork struct {
+	Name          string `json:"name"`
+	CIDR          string `json:"cidr"`
+	Interface     string `json:"interface"`
+	ReservedPorts string `json:"reservedPorts"`
+}
+
+// NodeNetwork is a fingerprinted network interface
+type NodeNetwork struct {
+	Mode   string `json:"mode"`
+	Device string `json:"device"`
+	CIDR   string `json:"cidr"`
+	IP     string `json:"ip"`
+}
+
+// PortUsage counts the ports of one host address. Free is the number of
+// ports of the dynamic range neither held by allocations nor reserved.
+type PortUsage struct {
+	HostIP         string        `json:"hostIp"`
+	MinDynamicPort int           `json:"minDynamicPort"`
+	MaxDynamicPort int           `json:"maxDynamicPort"`
+	DynamicRange   int           `json:"dynamicRange"`
+	Reserved       int           `json:"reserved"`
+	UsedDynamic    int           `json:"usedDynamic"`
+	UsedStatic     int           `json:"usedStatic"`
+	Free           int           `json:"free"`
+	Allocations    []PortHolding `json:"allocations"`
+}
+
+// PortHolding is a host port held by an allocation
+type PortHolding struct {
+	Port      int    `json:"port"`
+	Label     string `json:"label"`
+	To        int    `json:"to,omitempty"`
+	AllocID   string `json:"allocId"`
+	JobID     string `json:"jobId"`
+	Namespace string `json:"namespace"`
+}
+
+// GetNodeNetworks handles GET /clusters/{cluster}/v1/node/{nodeID}/networks
+func (h *Handler) GetNodeNetworks(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	nodeID := r.PathValue("nodeID")
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	node, _, err := client.Nodes().Info(nodeID, opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	allocs, _, err := client.Nodes().Allocations(nodeID, opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	writeJSON(w, nodeNetworks(node, allocs))
+}
+
+func nodeNetworks(node *api.Node, allocs []*api.Allocation) NodeNetworks {
+	networks := NodeNetworks{
+		NodeID:       node.ID,
+		Name:         node.Name,
+		HostNetworks: []HostNetwork{},
+		Networks:     []NodeNetwork{},
+		CNIPlugins:   map[string]string{},
+		Ports:        []PortUsage{},
+	}
+
+	for _, hn := range node.HostNetworks {
+		networks.HostNetworks = append(networks.HostNetworks, HostNetwork{
+			Name: hn.Name, CIDR: hn.CIDR, Interface: hn.Interface, ReservedPorts: hn.ReservedPorts,
+		})
+	}
+	sort.Slice(networks.HostNetworks, func(i, j int) bool {
+		return networks.HostNetworks[i].Name < networks.HostNetworks[j].Name
+	})
+
+	for name, value := range node.Attributes {
+		if plugin, ok := strings.CutPrefix(name, cniPluginAttribute); ok {
+			networks.CNIPlugins[plugin] = value
+		}
+	}
+	// Bridge networking needs Linux and the bridge CNI plugin
+	networks.BridgeNetwork = networks.CNIPlugins["bridge"] != "" && node.Attributes["kernel.name"] == "linux"
+
+	minPort, maxPort := defaultMinDynamicPort, defaultMaxDynamicPort
+	if res := node.NodeResources; res != nil {
+		for _, n := range res.Networks {
+			networks.Networks = append(networks.Networks, NodeNetwork{
+				Mode: n.Mode, Device: n.Device, CIDR: n.CIDR, IP: n.IP,
+			})
+		}
+		if res.MinDynamicPort > 0 && res.MaxDynamicPort >= res.MinDynamicPort {
+			minPort, maxPort = res.MinDynamicPort, res.MaxDynamicPort
+		}
+	}
+
+	var reserved map[int]bool
+	if res := node.ReservedResources; res != nil {
+		networks.ReservedHostPorts = res.Networks.ReservedHostPorts
+		reserved = parsePortRanges(res.Networks.ReservedHostPorts)
+	}
+
+	usage := map[string]*PortUsage{}
+	usageOf := func(hostIP string) *PortUsage {
+		u, ok := usage[hostIP]
+		if !ok {
+			u = &PortUsage{
+				HostIP:         hostIP,
+				MinDynamicPort: minPort,
+				MaxDynamicPort: maxPort,
+				DynamicRange:   maxPort - minPort + 1,
+				Allocations:    []PortHolding{},
+			}
+			for port := range reserved {
+				if port >= minPort && port <= maxPort {
+					u.Reserved++
+				}
+			}
+			usage[hostIP] = u
+		}
+		return u
+	}
+
+	// Every fingerprinted address has the whole range to itself
+	for _, n := range networks.Networks {
+		if n.IP != "" {
+			usageOf(n.IP)
+		}
+	}
+
+	for _, alloc := range allocs {
+		if alloc.DesiredStatus != api.AllocDesiredStatusRun || alloc.AllocatedResources == nil {
+			continue
+		}
+
+		for _, p := range allocatedPorts(alloc.AllocatedResources) {
+			u := usageOf(p.HostIP)
+			u.Allocations = append(u.Allocations, PortHolding{
+				Port:      p.Value,
+				Label:     p.Label,
+				To:        p.To,
+				AllocID:   alloc.ID,
+				JobID:     alloc.JobID,
+				Namespace: alloc.Namespace,
+			})
+
+			switch {
+			case p.Value >= minPort && p.Value <= maxPort && !reserved[p.Value]:
+				u.UsedDynamic++
+			default:
+				u.UsedStatic++
+			}
+		}
+	}
+
+	for _, u := range usage {
+		u.Free = max(u.DynamicRange-u.Reserved-u.UsedDynamic, 0)
+		sort.Slice(u.Allocations, func(i, j int) bool { return u.Allocations[i].Port < u.Allocations[j].Port })
+		networks.Ports = append(networks.Ports, *u)
+	}
+	sort.Slice(networks.Ports, func(i, j int) bool { return networks.Ports[i].HostIP < networks.Ports[j].HostIP })
+
+	return networks
+}
+
+// allocatedPorts returns the host ports of an allocation: those of its group
+// networks, and the ones of older allocations that set networks per task
+func allocatedPorts(res *api.AllocatedResources) []api.PortMapping {
+	seen := map[string]bool{}
+	var ports []api.PortMapping
+
+	add := func(p api.PortMapping) {
+		key := p.HostIP + ":" + strconv.Itoa(p.Value)
+		if p.Value > 0 && !seen[key] {
+			seen[key] = true
+			ports = append(ports, p)
+		}
+	}
+	addNetworks := func(networks []*api.NetworkResource) {
+		for _, n := range networks {
+			for _, p := range append(append([]api.Port(nil), n.ReservedPorts...), n.DynamicPorts...) {
+				add(api.PortMapping{Label: p.Label, Value: p.Value, To: p.To, HostIP: n.IP})
+			}
+		}
+	}
+
+	for _, p := range res.Shared.Ports {
+		add(p)
+	}
+	addNetworks(res.Shared.Networks)
+	for _, task := range res.Tasks {
+		addNetworks(task.Networks)
+	}
+
+	return ports
+}
+
+// parsePortRanges parses a port specification such as "22,80,8000-8100"
+// into the set of its ports, skipping invalid parts
+func parsePortRanges(spec string) map[int]bool {
+	ports := map[int]bool{}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			continue
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || end < start || end > 65535 {
+				continue
+			}
+		}
+
+		for port := start; port <= end; port++ {
+			ports[port] = true
+		}
+	}
+
+	return ports
+}
@@ -15,18 +15,22 @@ func (h *Handler) ListNodes(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	opts := getQueryOptions(r)
-	nodes, _, err := client.Nodes().List(opts)
+	opts, err := h.listOptions(r)
+	if err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	nodes, meta, err := client.Nodes().List(opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, nodes)
+	writeList(h, w, r, opts, nodes, meta)
 }
 
 // GetNode handles GET /clusters/{cluster}/v1/node/{nodeID}
@@ -37,18 +41,18 @@ func (h *Handler) GetNode(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	node, _, err := client.Nodes().Info(nodeID, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, node)
+	writeIndexed(w, r, node.ModifyIndex, node)
 }
 
 // DrainNode handles POST /clusters/{cluster}/v1/node/{nodeID}/drain
@@ -59,7 +63,7 @@ func (h *Handler) DrainNode(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -70,7 +74,7 @@ func (h *Handler) DrainNode(w http.ResponseWriter, r *http.Request) {
 		IgnoreSystem bool   `json:"ignoreSystem"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&drainReq); err != nil {
-		writeError(w, err, http.StatusBadRequest)
+		writeError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
@@ -82,7 +86,7 @@ func (h *Handler) DrainNode(w http.ResponseWriter, r *http.Request) {
 		if drainReq.Deadline != "" {
 			d, err := time.ParseDuration(drainReq.Deadline)
 			if err != nil {
-				writeError(w, err, http.StatusBadRequest)
+				writeError(w, r, err, http.StatusBadRequest)
 				return
 			}
 			deadline = d
@@ -95,7 +99,7 @@ func (h *Handler) DrainNode(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := client.Nodes().UpdateDrain(nodeID, drainSpec, !drainReq.Force, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -110,7 +114,7 @@ func (h *Handler) SetEligibility(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -118,7 +122,7 @@ func (h *Handler) SetEligibility(w http.ResponseWriter, r *http.Request) {
 		Eligible bool `json:"eligible"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&eligReq); err != nil {
-		writeError(w, err, http.StatusBadRequest)
+		writeError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
@@ -126,13 +130,28 @@ func (h *Handler) SetEligibility(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := client.Nodes().ToggleEligibility(nodeID, eligReq.Eligible, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	writeJSON(w, resp)
 }
 
+// PurgeNode handles POST /clusters/{cluster}/v1/node/{nodeID}/purge
+func (h *Handler) PurgeNode(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	nodeID := r.PathValue("nodeID")
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	h.runDestructive(w, r, client, ActionNodePurge, "", nodeID)
+}
+
 // GetNodeAllocations handles GET /clusters/{cluster}/v1/node/{nodeID}/allocations
 func (h *Handler) GetNodeAllocations(w http.ResponseWriter, r *http.Request) {
 	clusterName := getClusterName(r)
@@ -141,16 +160,16 @@ func (h *Handler) GetNodeAllocations(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	allocs, _, err := client.Nodes().Allocations(nodeID, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, allocs)
+	writeJSONArray(w, allocs)
 }
@@ -0,0 +1,446 @@
+package nomad
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/nsscope"
+)
+
+const (
+	// scopeCacheTTL is how long the scope of a token is remembered, so a
+	// changed policy or role applies within that time
+	scopeCacheTTL = time.Minute
+	// maxScopedBody bounds the request bodies read to find their namespace
+	maxScopedBody = 4 << 20
+)
+
+type scopeCacheKey struct {
+	cluster, token string
+}
+
+type cachedScope struct {
+	scope   nsscope.Scope
+	expires time.Time
+}
+
+// WithNamespaceScopes limits the namespaces tokens may use through Caravan.
+// Without it, or with nil scopes, tokens are only limited by their ACLs
+func WithNamespaceScopes(s *nsscope.Scopes) Option {
+	return func(h *Handler) {
+		h.scopes = s
+	}
+}
+
+// NamespaceScopeMiddleware enforces the namespace scopes on every cluster
+// request. Changes outside the token's namespaces are rejected, and so are
+// reads of one of them; JSON responses are stripped of the objects of the
+// namespaces the token may not see, so lists only show its own.
+//
+// Streams cannot be filtered, so those of all namespaces ("*") are refused
+// to scoped tokens. So are the composite views that span namespaces: the
+// service graph needs one of the token's namespaces, and devices, which list
+// the allocations of every namespace on their nodes, are refused.
+func (h *Handler) NamespaceScopeMiddleware(next http.Handler) http.Handler {
+	if h.scopes == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cluster, rest, ok := splitClusterPath(r.URL.Path)
+		if !ok || !strings.HasPrefix(rest, "/v1/") && rest != "/graph" && rest != "/devices" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scope := h.namespaceScope(cluster, getTokenForCluster(r, cluster))
+		if scope.Unrestricted() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch rest {
+		case "/graph":
+			// The graph defaults to all namespaces
+			namespace := r.URL.Query().Get("namespace")
+			if namespace == "" {
+				namespace = "*"
+			}
+
+			if !scope.Allows(namespace) {
+				writeOutOfScope(w, r, cluster, namespace)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		case "/devices":
+			writeOutOfScope(w, r, cluster, "*")
+			return
+		}
+
+		namespaces := []string{r.URL.Query().Get("namespace")}
+
+		if isMutating(r.Method) {
+			bodyNamespaces, _, err := peekBodyNamespaces(r, rest)
+			if err != nil {
+				writeError(w, r, err, http.StatusBadRequest)
+				return
+			}
+			namespaces = append(namespaces, bodyNamespaces...)
+		}
+
+		if allocID := allocationFromPath(rest); allocID != "" {
+			namespace, err := h.allocationNamespace(r, cluster, allocID)
+			if err != nil {
+				writeNomadError(w, r, err)
+				return
+			}
+			namespaces = append(namespaces, namespace)
+		}
+
+		for _, namespace := range namespaces {
+			// Reads of all namespaces are filtered below
+			if (namespace == "" || namespace == "*") && !isMutating(r.Method) {
+				continue
+			}
+
+			if !scope.Allows(namespace) {
+				writeOutOfScope(w, r, cluster, namespaceOrDefault(namespace))
+				return
+			}
+		}
+
+		if isMutating(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// A refused stream also ends the handler, which would stream on
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		sw := &scopedWriter{ResponseWriter: w, refuseStreams: namespaces[0] == "*"}
+		sw.refuse = func() {
+			writeOutOfScope(w, r, cluster, "*")
+			cancel()
+		}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		sw.finish(r, cluster, scope, strings.HasSuffix(rest, "/v1/namespaces"))
+	})
+}
+
+func writeOutOfScope(w http.ResponseWriter, r *http.Request, cluster, namespace string) {
+	apierror.Write(w, r, apierror.New(http.StatusForbidden, apierror.CodePermissionDenied,
+		fmt.Sprintf("namespace %q is outside of your scope", namespace)).
+		WithCluster(cluster))
+}
+
+// NamespaceScope returns the scope of a token on a cluster, unrestricted
+// when no namespace scopes are configured.
+func (h *Handler) NamespaceScope(cluster, token string) nsscope.Scope {
+	if h.scopes == nil {
+		return nsscope.Unrestricted
+	}
+
+	return h.namespaceScope(cluster, token)
+}
+
+// namespaceScope returns the scope of a token on a cluster. A token Nomad
+// does not know, or a cluster without ACLs, has no identity and only gets
+// the namespaces of the rules that apply to everyone.
+func (h *Handler) namespaceScope(cluster, token string) nsscope.Scope {
+	key := scopeCacheKey{cluster: cluster, token: token}
+
+	h.scopeMutex.Lock()
+	cached, ok := h.scopeCache[key]
+	h.scopeMutex.Unlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		return cached.scope
+	}
+
+	var id nsscope.Identity
+	if token != "" {
+		if client, err := h.GetClientWithToken(cluster, token); err == nil {
+			if self, _, err := client.ACLTokens().Self(nil); err == nil && self != nil {
+				id.Management = self.Type == "management"
+				id.Policies = self.Policies
+				for _, role := range self.Roles {
+					id.Roles = append(id.Roles, role.Name)
+				}
+			}
+		}
+	}
+
+	scope := h.scopes.For(cluster, id)
+
+	h.scopeMutex.Lock()
+	if h.scopeCache == nil {
+		h.scopeCache = make(map[scopeCacheKey]cachedScope)
+	}
+	for k, c := range h.scopeCache {
+		if time.Now().After(c.expires) {
+			delete(h.scopeCache, k)
+		}
+	}
+	h.scopeCache[key] = cachedScope{scope: scope, expires: time.Now().Add(scopeCacheTTL)}
+	h.scopeMutex.Unlock()
+
+	return scope
+}
+
+// peekBodyNamespaces returns the namespaces a request body names, either as
+// a job's own, as that of a wrapped {"Job": ...} or as those of the jobs of a
+// bulk request, and leaves the body to be read again. unnamed reports that
+// the body names no namespace, or that one of its jobs has none and so goes
+// to the request's namespace.
+//
+// Handlers decode JSON whatever the Content-Type, so every body is read as
+// JSON, except file uploads, which go to the namespace of their allocation.
+// Each field is decoded on its own and from the first JSON value only, like
+// the handlers do, so that a malformed sibling cannot hide a namespace.
+func peekBodyNamespaces(r *http.Request, rest string) (namespaces []string, unnamed bool, err error) {
+	if r.Body == nil || r.Body == http.NoBody || isUpload(rest) {
+		return nil, true, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxScopedBody+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("reading request body: %w", err)
+	}
+	if len(body) > maxScopedBody {
+		return nil, false, errors.New("request body is too large")
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	decode := func(v any) bool {
+		return json.NewDecoder(bytes.NewReader(body)).Decode(v) == nil
+	}
+
+	// Both are checked when both are set, as handlers differ in which they use
+	var wrapped struct{ Job *struct{ Namespace string } }
+	if decode(&wrapped) && wrapped.Job != nil && wrapped.Job.Namespace != "" {
+		namespaces = append(namespaces, wrapped.Job.Namespace)
+	}
+	var job struct{ Namespace string }
+	if decode(&job) && job.Namespace != "" {
+		namespaces = append(namespaces, job.Namespace)
+	}
+	unnamed = len(namespaces) == 0
+
+	// Other shapes of jobs are not bulk requests
+	var bulk struct{ Jobs []struct{ Namespace string } }
+	if decode(&bulk) {
+		for _, job := range bulk.Jobs {
+			if job.Namespace == "" {
+				unnamed = true
+				continue
+			}
+			namespaces = append(namespaces, job.Namespace)
+		}
+	}
+
+	return namespaces, unnamed, nil
+}
+
+// isUpload reports whether the path uploads a file to an allocation
+func isUpload(rest string) bool {
+	allocID := allocationFromPath(rest)
+
+	return allocID != "" && strings.HasPrefix(rest, "/v1/allocation/"+allocID+"/upload/")
+}
+
+// allocationFromPath returns the allocation ID of /v1/allocation/{allocID}/...
+// paths, which address an allocation whatever its namespace
+func allocationFromPath(rest string) string {
+	const prefix = "/v1/allocation/"
+	if !strings.HasPrefix(rest, prefix) {
+		return ""
+	}
+
+	allocID, _, _ := strings.Cut(strings.TrimPrefix(rest, prefix), "/")
+
+	return allocID
+}
+
+func (h *Handler) allocationNamespace(r *http.Request, cluster, allocID string) (string, error) {
+	client, err := h.GetClientWithToken(cluster, getTokenForCluster(r, cluster))
+	if err != nil {
+		return "", err
+	}
+
+	opts := getQueryOptions(r)
+	opts.Namespace = "*"
+
+	alloc, _, err := client.Allocations().Info(allocID, opts)
+	if err != nil {
+		return "", err
+	}
+
+	return alloc.Namespace, nil
+}
+
+// scopedWriter holds back successful JSON responses, so that they can be
+// filtered before they are sent. Anything else, such as streams, is passed
+// through as it is written, unless refuseStreams is set.
+type scopedWriter struct {
+	http.ResponseWriter
+	status        int
+	decided       bool
+	buffering     bool
+	buf           bytes.Buffer
+	refuseStreams bool
+	refuse        func()
+	refused       bool
+}
+
+func (sw *scopedWriter) WriteHeader(status int) {
+	if sw.decided {
+		return
+	}
+
+	sw.decided = true
+	sw.status = status
+
+	success := status >= 200 && status < 300
+	sw.buffering = success && strings.HasPrefix(sw.Header().Get("Content-Type"), "application/json")
+
+	switch {
+	case sw.buffering:
+	case success && sw.refuseStreams:
+		sw.refused = true
+		sw.Header().Del("Content-Type")
+		sw.refuse()
+	default:
+		sw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (sw *scopedWriter) Write(b []byte) (int, error) {
+	if !sw.decided {
+		sw.WriteHeader(http.StatusOK)
+	}
+
+	switch {
+	case sw.buffering:
+		return sw.buf.Write(b)
+	case sw.refused:
+		return 0, errors.New("stream of all namespaces refused")
+	}
+
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher for streamed responses
+func (sw *scopedWriter) Flush() {
+	if sw.buffering || sw.refused {
+		return
+	}
+
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker for WebSockets
+func (sw *scopedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if sw.refuseStreams {
+		sw.decided, sw.refused = true, true
+		sw.refuse()
+		return nil, nil, errors.New("stream of all namespaces refused")
+	}
+
+	hijacker, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+
+	return hijacker.Hijack()
+}
+
+// finish filters and sends a held back response. Objects of other
+// namespaces are dropped from lists; a response that is itself such an
+// object is refused.
+func (sw *scopedWriter) finish(r *http.Request, cluster string, scope nsscope.Scope, namespaceList bool) {
+	if !sw.buffering {
+		return
+	}
+
+	dec := json.NewDecoder(&sw.buf)
+	dec.UseNumber()
+
+	var body any
+	if err := dec.Decode(&body); err != nil {
+		logger.Log(logger.LevelWarn, map[string]string{"path": r.URL.Path}, err, "decoding scoped response")
+		writeError(sw.ResponseWriter, r, errors.New("response could not be scoped"), http.StatusInternalServerError)
+		return
+	}
+
+	// Nomad's objects name their namespace "Namespace", the composite views
+	// of Caravan, such as the canary comparison, "namespace"
+	keys := []string{"Namespace", "namespace"}
+	if namespaceList {
+		keys = []string{"Name"}
+	}
+
+	if obj, ok := body.(map[string]any); ok && !namespaceList {
+		if namespace, ok := objectNamespace(obj, keys); ok && !scope.Allows(namespace) {
+			writeOutOfScope(sw.ResponseWriter, r, cluster, namespaceOrDefault(namespace))
+			return
+		}
+	}
+
+	sw.Header().Del("Content-Length")
+	sw.ResponseWriter.WriteHeader(sw.status)
+	if err := json.NewEncoder(sw.ResponseWriter).Encode(filterScoped(body, keys, scope)); err != nil {
+		logger.Log(logger.LevelWarn, map[string]string{"path": r.URL.Path}, err, "writing scoped response")
+	}
+}
+
+// filterScoped drops the objects of lists, at any depth, whose namespace
+// the scope does not allow
+func filterScoped(v any, keys []string, scope nsscope.Scope) any {
+	switch v := v.(type) {
+	case []any:
+		kept := make([]any, 0, len(v))
+		for _, item := range v {
+			if obj, ok := item.(map[string]any); ok {
+				if namespace, ok := objectNamespace(obj, keys); ok && !scope.Allows(namespace) {
+					continue
+				}
+			}
+			kept = append(kept, filterScoped(item, keys, scope))
+		}
+		return kept
+	case map[string]any:
+		for k, item := range v {
+			v[k] = filterScoped(item, keys, scope)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// objectNamespace returns the namespace an object names under one of keys
+func objectNamespace(obj map[string]any, keys []string) (string, bool) {
+	for _, key := range keys {
+		if namespace, ok := obj[key].(string); ok {
+			return namespace, true
+		}
+	}
+	return "", false
+}
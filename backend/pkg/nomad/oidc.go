@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/forwarded"
 )
 
 // OIDCAuthURLRequest is the request body for getting the OIDC auth URL
@@ -55,14 +57,14 @@ func (h *Handler) ListAuthMethods(w http.ResponseWriter, r *http.Request) {
 	// For listing auth methods, we don't need a token - this is a public endpoint
 	client, err := h.GetClient(clusterName)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	methods, _, err := client.ACLAuthMethods().List(opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -80,45 +82,46 @@ func (h *Handler) ListAuthMethods(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetOIDCAuthURL handles POST /clusters/{cluster}/v1/acl/oidc/auth-url
-// Returns the OIDC provider URL to redirect the user to
+// Returns the OIDC provider URL to redirect the user to. A redirect URI
+// given as a path is made absolute, behind a proxy as the browser sees it.
 func (h *Handler) GetOIDCAuthURL(w http.ResponseWriter, r *http.Request) {
 	clusterName := getClusterName(r)
 
 	var req OIDCAuthURLRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, err, http.StatusBadRequest)
+		writeError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
 	if req.AuthMethodName == "" {
-		writeError(w, errMissingField("auth_method_name"), http.StatusBadRequest)
+		writeError(w, r, errMissingField("auth_method_name"), http.StatusBadRequest)
 		return
 	}
 	if req.RedirectURI == "" {
-		writeError(w, errMissingField("redirect_uri"), http.StatusBadRequest)
+		writeError(w, r, errMissingField("redirect_uri"), http.StatusBadRequest)
 		return
 	}
 	if req.ClientNonce == "" {
-		writeError(w, errMissingField("client_nonce"), http.StatusBadRequest)
+		writeError(w, r, errMissingField("client_nonce"), http.StatusBadRequest)
 		return
 	}
 
 	// For getting auth URL, we don't need a token - this is part of the login flow
 	client, err := h.GetClient(clusterName)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	nomadReq := &api.ACLOIDCAuthURLRequest{
 		AuthMethodName: req.AuthMethodName,
-		RedirectURI:    req.RedirectURI,
+		RedirectURI:    forwarded.ResolveURL(r, req.RedirectURI),
 		ClientNonce:    req.ClientNonce,
 	}
 
 	resp, _, err := client.ACLAuth().GetAuthURL(nomadReq, nil)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -134,35 +137,39 @@ func (h *Handler) CompleteOIDCAuth(w http.ResponseWriter, r *http.Request) {
 
 	var req OIDCCompleteAuthRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, err, http.StatusBadRequest)
+		writeError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
 	if req.AuthMethodName == "" {
-		writeError(w, errMissingField("auth_method_name"), http.StatusBadRequest)
+		writeError(w, r, errMissingField("auth_method_name"), http.StatusBadRequest)
 		return
 	}
 	if req.State == "" {
-		writeError(w, errMissingField("state"), http.StatusBadRequest)
+		writeError(w, r, errMissingField("state"), http.StatusBadRequest)
 		return
 	}
 	if req.Code == "" {
-		writeError(w, errMissingField("code"), http.StatusBadRequest)
+		writeError(w, r, errMissingField("code"), http.StatusBadRequest)
 		return
 	}
 	if req.RedirectURI == "" {
-		writeError(w, errMissingField("redirect_uri"), http.StatusBadRequest)
+		writeError(w, r, errMissingField("redirect_uri"), http.StatusBadRequest)
 		return
 	}
 	if req.ClientNonce == "" {
-		writeError(w, errMissingField("client_nonce"), http.StatusBadRequest)
+		writeError(w, r, errMissingField("client_nonce"), http.StatusBadRequest)
+		return
+	}
+
+	if !h.allowLogin(w, r, clusterName) {
 		return
 	}
 
 	// For completing auth, we don't need a token - we're getting one
 	client, err := h.GetClient(clusterName)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -171,14 +178,16 @@ func (h *Handler) CompleteOIDCAuth(w http.ResponseWriter, r *http.Request) {
 		ClientNonce:    req.ClientNonce,
 		State:          req.State,
 		Code:           req.Code,
-		RedirectURI:    req.RedirectURI,
+		RedirectURI:    forwarded.ResolveURL(r, req.RedirectURI),
 	}
 
 	token, _, err := client.ACLAuth().CompleteAuth(nomadReq, nil)
 	if err != nil {
-		writeNomadError(w, err)
+		h.loginFailed(r, clusterName, err)
+		writeNomadError(w, r, err)
 		return
 	}
+	h.loginSucceeded(r)
 
 	// Return the token info
 	response := OIDCCompleteAuthResponse{
@@ -202,13 +211,6 @@ func (h *Handler) CompleteOIDCAuth(w http.ResponseWriter, r *http.Request) {
 
 // Helper to create missing field error
 func errMissingField(field string) error {
-	return &fieldError{field: field}
-}
-
-type fieldError struct {
-	field string
-}
-
-func (e *fieldError) Error() string {
-	return "missing required field: " + e.field
+	return apierror.New(http.StatusBadRequest, apierror.CodeBadRequest, "missing required field: "+field).
+		WithDetails(map[string]string{"field": field})
 }
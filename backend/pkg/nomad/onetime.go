@@ -0,0 +1,101 @@
+package nomad
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/auth"
+	"github.com/caravan-nomad/caravan/backend/pkg/forwarded"
+)
+
+// ActionOneTimeTokenCreate is the audit action of a one-time token creation
+const ActionOneTimeTokenCreate = "acl.token.onetime"
+
+// OneTimeToken is a secret that exchanges once, within minutes, for the
+// token it was created with. Links to Caravan carry it so that whoever
+// follows them arrives signed in.
+type OneTimeToken struct {
+	OneTimeSecretID string    `json:"oneTimeSecretId"`
+	ExpiresAt       time.Time `json:"expiresAt"`
+}
+
+// OneTimeTokenExchangeRequest is the body of
+// POST /clusters/{cluster}/v1/auth/onetime/exchange
+type OneTimeTokenExchangeRequest struct {
+	OneTimeSecretID string `json:"oneTimeSecretId"`
+}
+
+// CreateOneTimeToken handles POST /clusters/{cluster}/v1/acl/token/onetime.
+// The one-time token exchanges for the caller's own token, for deep links
+// that open a session.
+func (h *Handler) CreateOneTimeToken(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	entry := AuditEntry{Cluster: clusterName, Action: ActionOneTimeTokenCreate}
+	if accessor, name, err := tokenIdentity(client); err == nil {
+		entry.Actor = name
+		entry.ActorAccessor = accessor
+		entry.Target = accessor
+	}
+
+	ott, _, err := client.ACLTokens().UpsertOneTimeToken(nil)
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	h.audit(r.Context(), entry)
+
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	writeJSON(w, OneTimeToken{OneTimeSecretID: ott.OneTimeSecretID, ExpiresAt: ott.ExpiresAt})
+}
+
+// ExchangeOneTimeToken handles POST /clusters/{cluster}/v1/auth/onetime/exchange.
+// Nomad exchanges the one-time token for the token it was created with,
+// which then opens a session like a login does.
+func (h *AuthHandler) ExchangeOneTimeToken(w http.ResponseWriter, r *http.Request) {
+	cluster := getClusterName(r)
+
+	var req OneTimeTokenExchangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.OneTimeSecretID == "" {
+		writeError(w, r, errMissingField("oneTimeSecretId"), http.StatusBadRequest)
+		return
+	}
+
+	if !h.nomadHandler.allowLogin(w, r, cluster) {
+		return
+	}
+
+	client, err := h.nomadHandler.GetClient(cluster)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	token, _, err := client.ACLTokens().ExchangeOneTimeToken(req.OneTimeSecretID, nil)
+	if err != nil {
+		h.nomadHandler.loginFailed(r, cluster, err)
+		writeNomadError(w, r, err)
+		return
+	}
+	h.nomadHandler.loginSucceeded(r)
+
+	auth.SetTokenCookie(w, r, cluster, token.SecretID, forwarded.BasePath(r, h.baseURL))
+	writeLoginResponse(w, token)
+}
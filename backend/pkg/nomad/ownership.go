@@ -0,0 +1,278 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+// ownershipBucket holds one OwnershipRule per rule of the ownership registry
+const ownershipBucket = "ownership"
+
+// OwnershipRule maps jobs to the team owning them, by a glob on their ID,
+// by their meta, or both. Cluster and Namespace restrict the rule to a
+// cluster or namespace; empty, they match all of them.
+type OwnershipRule struct {
+	ID        string `json:"id"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// JobPattern is a glob on job IDs, such as "payments-*"
+	JobPattern string `json:"jobPattern,omitempty"`
+	// MetaKey and MetaValue match the jobs with that meta, such as
+	// team=payments. Without a value, any job with the key matches.
+	MetaKey   string `json:"metaKey,omitempty"`
+	MetaValue string `json:"metaValue,omitempty"`
+	// Team owns the matching jobs. Left empty, the team is the value of the
+	// job's MetaKey, so that one rule covers every team tagging its jobs.
+	Team      string             `json:"team,omitempty"`
+	Contacts  []OwnershipContact `json:"contacts,omitempty"`
+	Runbooks  []OwnershipLink    `json:"runbooks,omitempty"`
+	UpdatedBy string             `json:"updatedBy,omitempty"`
+	UpdatedAt time.Time          `json:"updatedAt"`
+}
+
+// OwnershipContact is a way to reach a team, such as a Slack channel
+type OwnershipContact struct {
+	// Type is free-form, such as "slack", "email" or "pagerduty"
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// OwnershipLink is a titled link, such as a runbook
+type OwnershipLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// JobOwnership is who owns a job, as the ownership registry tells
+type JobOwnership struct {
+	Team     string             `json:"team"`
+	Contacts []OwnershipContact `json:"contacts,omitempty"`
+	Runbooks []OwnershipLink    `json:"runbooks,omitempty"`
+	// RuleID is the rule the job matched
+	RuleID string `json:"ruleId"`
+}
+
+// ListOwnershipRules handles GET /api/ownership
+func (h *Handler) ListOwnershipRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.ownershipRules(r.Context())
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, rules)
+}
+
+// CreateOwnershipRule handles POST /api/ownership
+func (h *Handler) CreateOwnershipRule(w http.ResponseWriter, r *http.Request) {
+	h.saveOwnershipRule(w, r, newID(), http.StatusCreated)
+}
+
+// UpdateOwnershipRule handles PUT /api/ownership/{id}
+func (h *Handler) UpdateOwnershipRule(w http.ResponseWriter, r *http.Request) {
+	var existing OwnershipRule
+	if err := store.GetJSON(r.Context(), h.store, ownershipBucket, r.PathValue("id"), &existing); err != nil {
+		writeOwnershipError(w, r, err)
+		return
+	}
+
+	// Moving a rule needs management of the cluster it applied to as well
+	if _, ok := h.requireManagement(w, r, existing.Cluster); !ok {
+		return
+	}
+
+	h.saveOwnershipRule(w, r, existing.ID, http.StatusOK)
+}
+
+// DeleteOwnershipRule handles DELETE /api/ownership/{id}
+func (h *Handler) DeleteOwnershipRule(w http.ResponseWriter, r *http.Request) {
+	var rule OwnershipRule
+	if err := store.GetJSON(r.Context(), h.store, ownershipBucket, r.PathValue("id"), &rule); err != nil {
+		writeOwnershipError(w, r, err)
+		return
+	}
+
+	if _, ok := h.requireManagement(w, r, rule.Cluster); !ok {
+		return
+	}
+
+	if err := h.store.Delete(r.Context(), ownershipBucket, rule.ID); err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveOwnershipRule validates the rule in the request body and stores it
+// under id. Rules are changed by the managers of their cluster, or of any
+// cluster for rules that apply to all of them.
+func (h *Handler) saveOwnershipRule(w http.ResponseWriter, r *http.Request, id string, status int) {
+	var rule OwnershipRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateOwnershipRule(&rule); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if rule.Cluster != "" && !h.configStore.HasContext(rule.Cluster) {
+		writeError(w, r, fmt.Errorf("cluster %q not found", rule.Cluster), http.StatusBadRequest)
+		return
+	}
+
+	self, ok := h.requireManagement(w, r, rule.Cluster)
+	if !ok {
+		return
+	}
+
+	rule.ID = id
+	rule.UpdatedAt = time.Now().UTC()
+	if self != nil {
+		rule.UpdatedBy = actorName(self)
+	}
+
+	if err := store.PutJSON(r.Context(), h.store, ownershipBucket, rule.ID, rule); err != nil {
+		writeError(w, r, fmt.Errorf("saving the ownership rule: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// ownershipRules lists the rules, most specific first: rules on meta before
+// those on IDs only, rules restricted to a cluster or namespace before the
+// others, and longer patterns before shorter ones
+func (h *Handler) ownershipRules(ctx context.Context) ([]OwnershipRule, error) {
+	rules, err := store.ListJSON[OwnershipRule](ctx, h.store, ownershipBucket, "")
+	if err != nil {
+		return nil, err
+	}
+
+	specificity := func(rule OwnershipRule) int {
+		s := 0
+		if rule.MetaKey != "" {
+			s += 8
+		}
+		if rule.MetaValue != "" {
+			s += 4
+		}
+		if rule.Cluster != "" {
+			s += 2
+		}
+		if rule.Namespace != "" {
+			s++
+		}
+		return s
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		si, sj := specificity(rules[i]), specificity(rules[j])
+		if si != sj {
+			return si > sj
+		}
+		return len(rules[i].JobPattern) > len(rules[j].JobPattern)
+	})
+
+	return rules, nil
+}
+
+// jobOwnership returns the owner of a job by the most specific rule it
+// matches, nil if it matches none
+func (h *Handler) jobOwnership(ctx context.Context, cluster string, job *api.Job) (*JobOwnership, error) {
+	rules, err := h.ownershipRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		if team, ok := rule.match(cluster, job); ok {
+			return &JobOwnership{Team: team, Contacts: rule.Contacts, Runbooks: rule.Runbooks, RuleID: rule.ID}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// match reports whether the rule matches the job, with the team owning it
+func (rule *OwnershipRule) match(cluster string, job *api.Job) (string, bool) {
+	if rule.Cluster != "" && rule.Cluster != cluster {
+		return "", false
+	}
+	if rule.Namespace != "" && rule.Namespace != namespaceOrDefault(stringValue(job.Namespace)) {
+		return "", false
+	}
+
+	if rule.JobPattern != "" {
+		if ok, _ := path.Match(rule.JobPattern, stringValue(job.ID)); !ok {
+			return "", false
+		}
+	}
+
+	team := rule.Team
+	if rule.MetaKey != "" {
+		value, ok := job.Meta[rule.MetaKey]
+		if !ok || (rule.MetaValue != "" && value != rule.MetaValue) {
+			return "", false
+		}
+		if team == "" {
+			team = value
+		}
+	}
+
+	return team, team != ""
+}
+
+func validateOwnershipRule(rule *OwnershipRule) error {
+	if rule.JobPattern == "" && rule.MetaKey == "" {
+		return errors.New("a rule needs a jobPattern, a metaKey or both")
+	}
+	if _, err := path.Match(rule.JobPattern, ""); err != nil {
+		return fmt.Errorf("invalid jobPattern: %w", err)
+	}
+	if rule.Team == "" && rule.MetaKey == "" {
+		return errMissingField("team")
+	}
+	if rule.MetaValue != "" && rule.MetaKey == "" {
+		return errors.New("metaValue needs a metaKey")
+	}
+
+	for _, link := range rule.Runbooks {
+		if u, err := url.Parse(link.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("runbook %q must be an http or https URL", link.URL)
+		}
+	}
+
+	for _, contact := range rule.Contacts {
+		if strings.TrimSpace(contact.Value) == "" {
+			return errMissingField("contacts.value")
+		}
+	}
+
+	return nil
+}
+
+func writeOwnershipError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, r, errors.New("ownership rule not found"), http.StatusNotFound)
+		return
+	}
+
+	writeError(w, r, err, http.StatusInternalServerError)
+}
@@ -0,0 +1,209 @@
+package nomad
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/freeze"
+	"github.com/caravan-nomad/caravan/backend/pkg/hclfmt"
+	"github.com/caravan-nomad/caravan/backend/pkg/lint"
+)
+
+// Verdicts of a preflight
+const (
+	// PreflightReady is a job that can be deployed as is
+	PreflightReady = "ready"
+	// PreflightWarning is a job that can be deployed, with warnings to review
+	PreflightWarning = "warning"
+	// PreflightBlocked is a job that would be rejected or not placed
+	PreflightBlocked = "blocked"
+)
+
+// PreflightRequest is the body of the preflight endpoint: either a job, as
+// sent to register it, or an HCL specification with its variables
+type PreflightRequest = LintRequest
+
+// PreflightResponse is the result of every check run before deploying a job,
+// for the review screen
+type PreflightResponse struct {
+	Verdict string `json:"verdict"`
+	// Reasons explain a verdict other than ready, blocking reasons first
+	Reasons []string `json:"reasons"`
+	// Job is the job checked, parsed from the HCL specification if that is
+	// what was sent
+	Job        *api.Job            `json:"job,omitempty"`
+	Validation PreflightValidation `json:"validation"`
+	// Plan is missing when the job is invalid, which Nomad cannot plan
+	Plan   *api.JobPlanResponse `json:"plan,omitempty"`
+	Lint   []lint.Finding       `json:"lint"`
+	Quota  PreflightQuota       `json:"quota"`
+	Freeze []freeze.Occurrence  `json:"freeze,omitempty"`
+}
+
+// PreflightValidation are the errors and warnings of parsing and validating
+// the job
+type PreflightValidation struct {
+	Valid       bool                `json:"valid"`
+	Diagnostics []hclfmt.Diagnostic `json:"diagnostics"`
+}
+
+// PreflightQuota is the quota of the job's namespace, and the task groups
+// the plan could not place because it is used up
+type PreflightQuota struct {
+	Namespace string `json:"namespace"`
+	// Quota is the name of the namespace's quota, empty without one or when
+	// the token cannot read the namespace
+	Quota string `json:"quota,omitempty"`
+	// Exhausted are the exhausted quota dimensions, by task group
+	Exhausted map[string][]string `json:"exhausted,omitempty"`
+}
+
+// PreflightJob handles POST /clusters/{cluster}/v1/job/preflight
+// It validates, plans and lints the job and checks its namespace's quota and
+// the freeze windows, without registering it, so that one review shows
+// whether deploying would go through.
+func (h *Handler) PreflightJob(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+
+	var req PreflightRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxHCLSize)).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+	if req.Job == nil && req.HCL == "" {
+		writeError(w, r, errors.New("either job or hcl is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	resp := PreflightResponse{Lint: []lint.Finding{}, Validation: PreflightValidation{Diagnostics: []hclfmt.Diagnostic{}}}
+
+	job := req.Job
+	if job == nil {
+		// Parsed without Nomad's defaults, so the lint rules see what was
+		// written; validating and planning fill them in
+		if diags := hclfmt.Check([]byte(req.HCL)); hclfmt.HasErrors(diags) {
+			resp.Validation.Diagnostics = diags
+		} else if job, diags, err = parseJobHCL(client, &HCLRequest{HCL: req.HCL, Variables: req.Variables}, false); err != nil {
+			writeNomadError(w, r, err)
+			return
+		} else if job == nil {
+			resp.Validation.Diagnostics = diags
+		}
+	}
+
+	if job != nil {
+		resp.Job = job
+		resp.Lint = h.linter.Lint(job)
+
+		diags, err := validateJob(client, job)
+		if err != nil {
+			writeNomadError(w, r, err)
+			return
+		}
+		resp.Validation.Diagnostics = append(resp.Validation.Diagnostics, diags...)
+	}
+	resp.Validation.Valid = job != nil && !hclfmt.HasErrors(resp.Validation.Diagnostics)
+
+	opts := getWriteOptions(r)
+	namespace := namespaceOrDefault(opts.Namespace)
+	if job != nil && job.Namespace != nil && *job.Namespace != "" {
+		namespace = *job.Namespace
+	}
+	resp.Quota.Namespace = namespace
+
+	if resp.Validation.Valid {
+		resp.Plan, _, err = client.Jobs().Plan(job, true, opts)
+		if err != nil {
+			writeNomadError(w, r, err)
+			return
+		}
+
+		// Quotas are an Enterprise feature; without access to the namespace
+		// the plan still reports exhausted quotas
+		if ns, _, err := client.Namespaces().Info(namespace, nil); err == nil && ns != nil {
+			resp.Quota.Quota = ns.Quota
+		}
+		for group, metric := range resp.Plan.FailedTGAllocs {
+			if metric != nil && len(metric.QuotaExhausted) > 0 {
+				if resp.Quota.Exhausted == nil {
+					resp.Quota.Exhausted = map[string][]string{}
+				}
+				resp.Quota.Exhausted[group] = metric.QuotaExhausted
+			}
+		}
+	}
+
+	if h.freeze != nil {
+		resp.Freeze = h.activeFreeze(clusterName, namespace, token)
+	}
+
+	resp.Verdict, resp.Reasons = preflightVerdict(&resp)
+
+	writeJSON(w, resp)
+}
+
+// preflightVerdict sums up the checks of a preflight
+func preflightVerdict(resp *PreflightResponse) (string, []string) {
+	var blocking, warnings []string
+
+	for _, d := range resp.Validation.Diagnostics {
+		if d.Severity == hclfmt.SeverityError {
+			blocking = append(blocking, "invalid job: "+d.Summary)
+		} else {
+			warnings = append(warnings, d.Summary)
+		}
+	}
+
+	if len(resp.Freeze) > 0 {
+		blocking = append(blocking, "changes are frozen: "+resp.Freeze[0].Name)
+	}
+
+	if resp.Plan != nil {
+		groups := make([]string, 0, len(resp.Plan.FailedTGAllocs))
+		for group := range resp.Plan.FailedTGAllocs {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+
+		for _, group := range groups {
+			if quotas := resp.Quota.Exhausted[group]; len(quotas) > 0 {
+				blocking = append(blocking, fmt.Sprintf("group %q exceeds the quota: %s", group, strings.Join(quotas, ", ")))
+				continue
+			}
+			blocking = append(blocking, fmt.Sprintf("group %q cannot be placed", group))
+		}
+
+		for _, msg := range strings.Split(resp.Plan.Warnings, "\n") {
+			if msg = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(msg), "*")); msg != "" {
+				warnings = append(warnings, msg)
+			}
+		}
+	}
+
+	for _, f := range resp.Lint {
+		if f.Severity == lint.SeverityWarning {
+			warnings = append(warnings, f.Rule+": "+f.Message)
+		}
+	}
+
+	switch {
+	case len(blocking) > 0:
+		return PreflightBlocked, append(blocking, warnings...)
+	case len(warnings) > 0:
+		return PreflightWarning, warnings
+	default:
+		return PreflightReady, []string{}
+	}
+}
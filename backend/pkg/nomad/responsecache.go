@@ -0,0 +1,333 @@
+package nomad
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/cache"
+	"github.com/caravan-nomad/caravan/backend/pkg/eventbus"
+	"github.com/caravan-nomad/caravan/backend/pkg/telemetry"
+)
+
+// Resources whose list responses are cached
+const (
+	cachedJobs       = "jobs"
+	cachedNodes      = "nodes"
+	cachedNamespaces = "namespaces"
+)
+
+// accessorTTL is how long the accessor of a token is remembered
+const accessorTTL = 5 * time.Minute
+
+// anonymousAccessor keys the entries of clusters without ACLs, where every
+// token sees the same data
+const anonymousAccessor = "anonymous"
+
+// responseCache keeps the responses of hot list endpoints for a short time,
+// so a dashboard open in many browsers costs one upstream call per refresh.
+// Entries are keyed by the accessor of the token, since ACLs decide what a
+// list contains, and are dropped early when the event stream reports changes.
+type responseCache struct {
+	ttl     time.Duration
+	entries cache.Cache[interface{}]
+	// accessors maps a hash of a token to its accessor ID
+	accessors cache.Cache[string]
+	lookups   cacheCounters
+
+	// subscriptions holds the event bus subscription of each cluster
+	subscriptionsMutex sync.Mutex
+	subscriptions      map[string]func()
+}
+
+type cachedList[T any] struct {
+	value T
+	meta  *api.QueryMeta
+}
+
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{
+		ttl:           ttl,
+		entries:       cache.New[interface{}](),
+		accessors:     cache.New[string](),
+		subscriptions: make(map[string]func()),
+	}
+}
+
+// WithResponseCache caches the job, node and namespace lists for ttl.
+// 0 disables caching.
+func WithResponseCache(ttl time.Duration) Option {
+	return func(h *Handler) {
+		if ttl > 0 {
+			h.responseCache = newResponseCache(ttl)
+		}
+	}
+}
+
+func cacheKey(cluster, resource, accessor string, q *api.QueryOptions) string {
+	key := cluster + "\x00" + resource + "\x00" + accessor
+	if q != nil {
+		key += "\x00" + q.Namespace + "\x00" + q.Region + "\x00" + q.Prefix +
+			"\x00" + strconv.Itoa(int(q.PerPage)) + "\x00" + q.NextToken
+	}
+
+	return key
+}
+
+// invalidate drops the cached lists of a resource in a cluster
+func (c *responseCache) invalidate(cluster, resource string) {
+	c.drop(func(key string) bool {
+		return strings.HasPrefix(key, cluster+"\x00"+resource+"\x00")
+	})
+}
+
+// invalidateAccessor drops the cached lists of a token, e.g. once it is deleted
+// or its policies change
+func (c *responseCache) invalidateAccessor(cluster, accessor string) {
+	c.drop(func(key string) bool {
+		parts := strings.SplitN(key, "\x00", 4)
+		return len(parts) >= 3 && parts[0] == cluster && parts[2] == accessor
+	})
+}
+
+func (c *responseCache) drop(match cache.Matcher) {
+	ctx := context.Background()
+
+	entries, _ := c.entries.GetAll(ctx, match)
+	for key := range entries {
+		_ = c.entries.Delete(ctx, key)
+	}
+}
+
+// forget drops everything cached for a cluster and stops watching its events
+func (c *responseCache) forget(cluster string) {
+	c.subscriptionsMutex.Lock()
+	if unsubscribe, ok := c.subscriptions[cluster]; ok {
+		unsubscribe()
+		delete(c.subscriptions, cluster)
+	}
+	c.subscriptionsMutex.Unlock()
+
+	c.drop(func(key string) bool {
+		return strings.HasPrefix(key, cluster+"\x00")
+	})
+}
+
+// cachingClient serves list calls from the response cache
+type cachingClient struct {
+	NomadAPI
+	h       *Handler
+	cluster string
+	token   string
+}
+
+// withResponseCache wraps a client in the handler's response cache, if any
+func (h *Handler) withResponseCache(client NomadAPI, cluster, token string) NomadAPI {
+	if h.responseCache == nil {
+		return client
+	}
+
+	return &cachingClient{NomadAPI: client, h: h, cluster: cluster, token: token}
+}
+
+func (c *cachingClient) Jobs() JobsAPI {
+	return &cachingJobs{JobsAPI: c.NomadAPI.Jobs(), c: c}
+}
+
+func (c *cachingClient) Nodes() NodesAPI {
+	return &cachingNodes{NodesAPI: c.NomadAPI.Nodes(), c: c}
+}
+
+func (c *cachingClient) Namespaces() NamespacesAPI {
+	return &cachingNamespaces{NamespacesAPI: c.NomadAPI.Namespaces(), c: c}
+}
+
+// accessor returns the accessor ID of the client's token, "" if it cannot
+// be resolved, in which case nothing is cached for it
+func (c *cachingClient) accessor() string {
+	ctx := context.Background()
+	rc := c.h.responseCache
+
+	sum := sha256.Sum256([]byte(c.cluster + "\x00" + c.token))
+	tokenKey := hex.EncodeToString(sum[:])
+
+	if accessor, err := rc.accessors.Get(ctx, tokenKey); err == nil {
+		return accessor
+	}
+
+	self, _, err := c.NomadAPI.ACLTokens().Self(nil)
+	switch {
+	case err != nil && strings.Contains(err.Error(), "ACL support disabled"):
+		_ = rc.accessors.SetWithTTL(ctx, tokenKey, anonymousAccessor, accessorTTL)
+		return anonymousAccessor
+	case err != nil:
+		return ""
+	}
+
+	_ = rc.accessors.SetWithTTL(ctx, tokenKey, self.AccessorID, accessorTTL)
+
+	return self.AccessorID
+}
+
+// list returns the cached response of a list call, or calls it and caches the
+// result. Blocking queries always go to Nomad.
+func list[T any](c *cachingClient, resource string, q *api.QueryOptions,
+	call func(q *api.QueryOptions) (T, *api.QueryMeta, error),
+) (T, *api.QueryMeta, error) {
+	if q != nil && q.WaitIndex > 0 {
+		return call(q)
+	}
+
+	accessor := c.accessor()
+	if accessor == "" {
+		return call(q)
+	}
+
+	ctx := context.Background()
+	rc := c.h.responseCache
+	key := cacheKey(c.cluster, resource, accessor, q)
+
+	if v, err := rc.entries.Get(ctx, key); err == nil {
+		if entry, ok := v.(cachedList[T]); ok {
+			telemetry.RecordResponseCache(resource, true)
+			rc.lookups.record(c.cluster, resource, true)
+			return entry.value, entry.meta, nil
+		}
+	}
+
+	telemetry.RecordResponseCache(resource, false)
+	rc.lookups.record(c.cluster, resource, false)
+
+	value, meta, err := call(q)
+	if err != nil {
+		return value, meta, err
+	}
+
+	_ = rc.entries.SetWithTTL(ctx, key, cachedList[T]{value: value, meta: meta}, rc.ttl)
+
+	var index uint64
+	if meta != nil {
+		index = meta.LastIndex
+	}
+	rc.watchInvalidations(c.h.events, c.cluster, index)
+
+	return value, meta, nil
+}
+
+type cachingJobs struct {
+	JobsAPI
+	c *cachingClient
+}
+
+func (j *cachingJobs) List(q *api.QueryOptions) ([]*api.JobListStub, *api.QueryMeta, error) {
+	return list(j.c, cachedJobs, q, j.JobsAPI.List)
+}
+
+func (j *cachingJobs) Register(job *api.Job, q *api.WriteOptions) (*api.JobRegisterResponse, *api.WriteMeta, error) {
+	defer j.c.h.responseCache.invalidate(j.c.cluster, cachedJobs)
+	return j.JobsAPI.Register(job, q)
+}
+
+func (j *cachingJobs) EnforceRegister(job *api.Job, modifyIndex uint64, q *api.WriteOptions,
+) (*api.JobRegisterResponse, *api.WriteMeta, error) {
+	defer j.c.h.responseCache.invalidate(j.c.cluster, cachedJobs)
+	return j.JobsAPI.EnforceRegister(job, modifyIndex, q)
+}
+
+func (j *cachingJobs) Deregister(jobID string, purge bool, q *api.WriteOptions) (string, *api.WriteMeta, error) {
+	defer j.c.h.responseCache.invalidate(j.c.cluster, cachedJobs)
+	return j.JobsAPI.Deregister(jobID, purge, q)
+}
+
+func (j *cachingJobs) Scale(jobID, group string, count *int, message string, isError bool,
+	meta map[string]interface{}, q *api.WriteOptions,
+) (*api.JobRegisterResponse, *api.WriteMeta, error) {
+	defer j.c.h.responseCache.invalidate(j.c.cluster, cachedJobs)
+	return j.JobsAPI.Scale(jobID, group, count, message, isError, meta, q)
+}
+
+func (j *cachingJobs) Dispatch(jobID string, meta map[string]string, payload []byte, idPrefixTemplate string,
+	q *api.WriteOptions,
+) (*api.JobDispatchResponse, *api.WriteMeta, error) {
+	defer j.c.h.responseCache.invalidate(j.c.cluster, cachedJobs)
+	return j.JobsAPI.Dispatch(jobID, meta, payload, idPrefixTemplate, q)
+}
+
+func (j *cachingJobs) Revert(jobID string, version uint64, enforcePriorVersion *uint64, q *api.WriteOptions,
+	consulToken, vaultToken string,
+) (*api.JobRegisterResponse, *api.WriteMeta, error) {
+	defer j.c.h.responseCache.invalidate(j.c.cluster, cachedJobs)
+	return j.JobsAPI.Revert(jobID, version, enforcePriorVersion, q, consulToken, vaultToken)
+}
+
+type cachingNodes struct {
+	NodesAPI
+	c *cachingClient
+}
+
+func (n *cachingNodes) List(q *api.QueryOptions) ([]*api.NodeListStub, *api.QueryMeta, error) {
+	return list(n.c, cachedNodes, q, n.NodesAPI.List)
+}
+
+func (n *cachingNodes) UpdateDrain(nodeID string, spec *api.DrainSpec, markEligible bool,
+	q *api.WriteOptions,
+) (*api.NodeDrainUpdateResponse, error) {
+	defer n.c.h.responseCache.invalidate(n.c.cluster, cachedNodes)
+	return n.NodesAPI.UpdateDrain(nodeID, spec, markEligible, q)
+}
+
+func (n *cachingNodes) ToggleEligibility(nodeID string, eligible bool,
+	q *api.WriteOptions,
+) (*api.NodeEligibilityUpdateResponse, error) {
+	defer n.c.h.responseCache.invalidate(n.c.cluster, cachedNodes)
+	return n.NodesAPI.ToggleEligibility(nodeID, eligible, q)
+}
+
+func (n *cachingNodes) Purge(nodeID string, q *api.QueryOptions) (*api.NodePurgeResponse, *api.QueryMeta, error) {
+	defer n.c.h.responseCache.invalidate(n.c.cluster, cachedNodes)
+	return n.NodesAPI.Purge(nodeID, q)
+}
+
+type cachingNamespaces struct {
+	NamespacesAPI
+	c *cachingClient
+}
+
+func (n *cachingNamespaces) List(q *api.QueryOptions) ([]*api.Namespace, *api.QueryMeta, error) {
+	return list(n.c, cachedNamespaces, q, n.NamespacesAPI.List)
+}
+
+// topicACLToken carries token updates and deletions; the SDK has no constant for it
+const topicACLToken api.Topic = "ACLToken"
+
+// invalidationTopics are the events that make cached lists stale. Nomad has
+// no namespace events, so namespace lists only expire.
+var invalidationTopics = []api.Topic{api.TopicJob, api.TopicNode, topicACLToken}
+
+// watchInvalidations subscribes the cache to the events of a cluster, unless
+// it already is, to drop cached lists as soon as they change. index is the
+// index of the first cached response.
+func (c *responseCache) watchInvalidations(bus *eventbus.Bus, cluster string, index uint64) {
+	c.subscriptionsMutex.Lock()
+	defer c.subscriptionsMutex.Unlock()
+
+	if _, ok := c.subscriptions[cluster]; ok {
+		return
+	}
+
+	c.subscriptions[cluster] = bus.Subscribe(cluster, invalidationTopics, index, func(event api.Event) {
+		switch event.Topic {
+		case api.TopicJob:
+			c.invalidate(cluster, cachedJobs)
+		case api.TopicNode:
+			c.invalidate(cluster, cachedNodes)
+		case topicACLToken:
+			c.invalidateAccessor(cluster, event.Key)
+		}
+	})
+}
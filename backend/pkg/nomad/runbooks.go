@@ -0,0 +1,366 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+// runbooksBucket holds one RunbookRule per rule
+const runbooksBucket = "runbooks"
+
+// Kinds of failures runbook rules match
+const (
+	// RunbookSourceTaskEvent is a task event that failed or restarted a task,
+	// such as a driver error
+	RunbookSourceTaskEvent = "task-event"
+	// RunbookSourcePlacement is a task group the scheduler could not place,
+	// such as for exhausted memory or a constraint no node meets
+	RunbookSourcePlacement = "placement"
+)
+
+// failedTaskEvents are the task event types that report a failure, besides
+// the events that fail the task or carry an error
+var failedTaskEvents = map[string]bool{
+	api.TaskDriverFailure:          true,
+	api.TaskSetupFailure:           true,
+	api.TaskFailedValidation:       true,
+	api.TaskArtifactDownloadFailed: true,
+	api.TaskNotRestarting:          true,
+}
+
+// RunbookRule points the failures whose message matches Pattern to a runbook
+// and a remediation hint
+type RunbookRule struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Pattern is a regular expression on the failure message, such as
+	// "OOM Killed|out of memory"
+	Pattern string `json:"pattern"`
+	// Source restricts the rule to task events or placement failures; empty,
+	// it matches both
+	Source     string    `json:"source,omitempty"`
+	RunbookURL string    `json:"runbookUrl,omitempty"`
+	Hint       string    `json:"hint,omitempty"`
+	UpdatedBy  string    `json:"updatedBy,omitempty"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// RunbookHint is a runbook rule matching a failure
+type RunbookHint struct {
+	RuleID     string `json:"ruleId"`
+	Name       string `json:"name"`
+	RunbookURL string `json:"runbookUrl,omitempty"`
+	Hint       string `json:"hint,omitempty"`
+	// Source, Subject and Message describe the failure, such as a task event
+	// of the task "web" of an allocation
+	Source  string `json:"source"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+}
+
+// AllocationWithHints is an allocation with the runbook hints of its failed
+// task events
+type AllocationWithHints struct {
+	*api.Allocation
+	RunbookHints []RunbookHint `json:"RunbookHints,omitempty"`
+}
+
+// EvaluationWithHints is an evaluation with the runbook hints of the task
+// groups it failed to place
+type EvaluationWithHints struct {
+	*api.Evaluation
+	RunbookHints []RunbookHint `json:"RunbookHints,omitempty"`
+}
+
+// failure is a failure message runbook rules are matched against
+type failure struct {
+	source  string
+	subject string
+	message string
+}
+
+// ListRunbookRules handles GET /api/runbooks
+func (h *Handler) ListRunbookRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.runbookRules(r.Context())
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, rules)
+}
+
+// CreateRunbookRule handles POST /api/runbooks
+func (h *Handler) CreateRunbookRule(w http.ResponseWriter, r *http.Request) {
+	h.saveRunbookRule(w, r, newID(), http.StatusCreated)
+}
+
+// UpdateRunbookRule handles PUT /api/runbooks/{id}
+func (h *Handler) UpdateRunbookRule(w http.ResponseWriter, r *http.Request) {
+	var existing RunbookRule
+	if err := store.GetJSON(r.Context(), h.store, runbooksBucket, r.PathValue("id"), &existing); err != nil {
+		writeRunbookError(w, r, err)
+		return
+	}
+
+	h.saveRunbookRule(w, r, existing.ID, http.StatusOK)
+}
+
+// DeleteRunbookRule handles DELETE /api/runbooks/{id}
+func (h *Handler) DeleteRunbookRule(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireManagement(w, r, ""); !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+
+	var rule RunbookRule
+	if err := store.GetJSON(r.Context(), h.store, runbooksBucket, id, &rule); err != nil {
+		writeRunbookError(w, r, err)
+		return
+	}
+
+	if err := h.store.Delete(r.Context(), runbooksBucket, id); err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// saveRunbookRule validates the rule in the request body and stores it
+// under id. Rules apply to every cluster, so the managers of any cluster
+// may change them.
+func (h *Handler) saveRunbookRule(w http.ResponseWriter, r *http.Request, id string, status int) {
+	var rule RunbookRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateRunbookRule(&rule); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	self, ok := h.requireManagement(w, r, "")
+	if !ok {
+		return
+	}
+
+	rule.ID = id
+	rule.UpdatedAt = time.Now().UTC()
+	if self != nil {
+		rule.UpdatedBy = actorName(self)
+	}
+
+	if err := store.PutJSON(r.Context(), h.store, runbooksBucket, rule.ID, rule); err != nil {
+		writeError(w, r, fmt.Errorf("saving the runbook rule: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// runbookRules lists the rules by name
+func (h *Handler) runbookRules(ctx context.Context) ([]RunbookRule, error) {
+	rules, err := store.ListJSON[RunbookRule](ctx, h.store, runbooksBucket, "")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+
+	return rules, nil
+}
+
+// runbookHints matches the failures against the runbook rules. Hints only
+// help, so a failure to read the rules is logged rather than failing the
+// request they are attached to.
+func (h *Handler) runbookHints(ctx context.Context, cluster string, failures []failure) []RunbookHint {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	rules, err := h.runbookRules(ctx)
+	if err != nil {
+		logger.Log(logger.LevelWarn, map[string]string{"cluster": cluster}, err, "reading runbook rules")
+		return nil
+	}
+
+	var hints []RunbookHint
+
+	for _, rule := range rules {
+		// Patterns are validated when saved
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			continue
+		}
+
+		for _, f := range failures {
+			if (rule.Source == "" || rule.Source == f.source) && re.MatchString(f.message) {
+				hints = append(hints, RunbookHint{
+					RuleID:     rule.ID,
+					Name:       rule.Name,
+					RunbookURL: rule.RunbookURL,
+					Hint:       rule.Hint,
+					Source:     f.source,
+					Subject:    f.subject,
+					Message:    f.message,
+				})
+			}
+		}
+	}
+
+	return hints
+}
+
+// taskFailures lists the failed task events of an allocation's tasks
+func taskFailures(allocID string, states map[string]*api.TaskState) []failure {
+	var failures []failure
+
+	tasks := make([]string, 0, len(states))
+	for task := range states {
+		tasks = append(tasks, task)
+	}
+	sort.Strings(tasks)
+
+	for _, task := range tasks {
+		if states[task] == nil {
+			continue
+		}
+
+		for _, e := range states[task].Events {
+			errorText := joinNonEmpty(e.DriverError, e.SetupError, e.DownloadError, e.ValidationError,
+				e.KillError, e.VaultError)
+			failed := e.FailsTask || errorText != "" || failedTaskEvents[e.Type] ||
+				(e.Type == api.TaskTerminated && e.ExitCode != 0)
+			if !failed {
+				continue
+			}
+
+			failures = append(failures, failure{
+				source:  RunbookSourceTaskEvent,
+				subject: shortID(allocID) + "/" + task,
+				message: joinNonEmpty(e.Type, e.DisplayMessage, errorText),
+			})
+		}
+	}
+
+	return failures
+}
+
+// placementFailures lists why the scheduler could not place the task groups
+// of an evaluation
+func placementFailures(eval *api.Evaluation) []failure {
+	var failures []failure
+
+	groups := make([]string, 0, len(eval.FailedTGAllocs))
+	for group := range eval.FailedTGAllocs {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		m := eval.FailedTGAllocs[group]
+		if m == nil {
+			continue
+		}
+
+		var reasons []string
+		for constraint := range m.ConstraintFiltered {
+			reasons = append(reasons, "constraint filtered: "+constraint)
+		}
+		for class := range m.ClassExhausted {
+			reasons = append(reasons, "class exhausted: "+class)
+		}
+		for dimension := range m.DimensionExhausted {
+			reasons = append(reasons, "dimension exhausted: "+dimension)
+		}
+		for _, quota := range m.QuotaExhausted {
+			reasons = append(reasons, "quota exhausted: "+quota)
+		}
+		if m.NodesEvaluated == 0 {
+			reasons = append(reasons, "no nodes were eligible for evaluation")
+		}
+		sort.Strings(reasons)
+
+		for _, reason := range reasons {
+			failures = append(failures, failure{source: RunbookSourcePlacement, subject: group, message: reason})
+		}
+	}
+
+	return failures
+}
+
+func joinNonEmpty(parts ...string) string {
+	kept := parts[:0:0]
+	for _, p := range parts {
+		if p != "" {
+			kept = append(kept, p)
+		}
+	}
+
+	return strings.Join(kept, ": ")
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+
+	return id
+}
+
+func validateRunbookRule(rule *RunbookRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return errMissingField("name")
+	}
+	if rule.Pattern == "" {
+		return errMissingField("pattern")
+	}
+	if _, err := regexp.Compile(rule.Pattern); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	switch rule.Source {
+	case "", RunbookSourceTaskEvent, RunbookSourcePlacement:
+	default:
+		return fmt.Errorf("source must be %q, %q or empty", RunbookSourceTaskEvent, RunbookSourcePlacement)
+	}
+
+	if rule.RunbookURL == "" && rule.Hint == "" {
+		return errors.New("a rule needs a runbookUrl, a hint or both")
+	}
+	if rule.RunbookURL != "" {
+		if u, err := url.Parse(rule.RunbookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errors.New("runbookUrl must be an http or https URL")
+		}
+	}
+
+	return nil
+}
+
+func writeRunbookError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, r, errors.New("runbook rule not found"), http.StatusNotFound)
+		return
+	}
+
+	writeError(w, r, err, http.StatusInternalServerError)
+}
@@ -0,0 +1,146 @@
+package nomad
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+)
+
+// JobScaleStatus is the response of the scale status endpoint: the counts of
+// each task group, what scaled them and what the autoscaler suggests next.
+type JobScaleStatus struct {
+	Status *api.JobScaleStatusResponse `json:"status"`
+	// Events are the scaling events of all task groups, newest first. Nomad
+	// keeps the last 20 events of each group.
+	Events []ScalingEvent `json:"events"`
+	// Recommendations are the pending Autoscaler recommendations for the job.
+	// The recommendations API is Nomad Enterprise only; elsewhere
+	// RecommendationsAvailable is false.
+	Recommendations          []*api.Recommendation `json:"recommendations"`
+	RecommendationsAvailable bool                  `json:"recommendationsAvailable"`
+	Errors                   map[string]string     `json:"errors,omitempty"`
+}
+
+// ScalingEvent is a scaling event of a task group
+type ScalingEvent struct {
+	Group         string                 `json:"group"`
+	Count         *int64                 `json:"count,omitempty"`
+	PreviousCount int64                  `json:"previousCount"`
+	Error         bool                   `json:"error"`
+	Message       string                 `json:"message"`
+	Meta          map[string]interface{} `json:"meta,omitempty"`
+	EvalID        string                 `json:"evalId,omitempty"`
+	Time          time.Time              `json:"time"`
+}
+
+// GetJobScaleStatus handles GET /clusters/{cluster}/v1/job/scale-status?id=jobID
+func (h *Handler) GetJobScaleStatus(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+
+	var (
+		status          JobScaleStatus
+		recommendations []*api.Recommendation
+	)
+
+	g := fanout.New(r.Context(), fanout.Options{Timeout: compositeCallTimeout})
+
+	g.Go("status", func(ctx context.Context) (err error) {
+		status.Status, _, err = client.Jobs().ScaleStatus(jobID, opts.WithContext(ctx))
+		return err
+	})
+	g.Go("recommendations", func(ctx context.Context) (err error) {
+		q := *opts
+		q.Params = map[string]string{"job": jobID}
+
+		recommendations, _, err = client.Recommendations().List(q.WithContext(ctx))
+		return err
+	})
+
+	errs := g.Wait()
+
+	if err := errs["status"]; err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	// Community edition clusters don't serve recommendations at all
+	if err := errs["recommendations"]; err != nil && recommendationsUnsupported(err) {
+		delete(errs, "recommendations")
+	} else if err == nil {
+		status.RecommendationsAvailable = true
+		status.Recommendations = recommendations
+	}
+
+	status.Events = scalingEvents(status.Status)
+	status.Errors = errs.Messages()
+
+	h.writeCompositeJSON(w, r, status)
+}
+
+// recommendationsUnsupported reports whether err means the cluster has no
+// recommendations API, rather than that listing them failed
+func recommendationsUnsupported(err error) bool {
+	var unexpected api.UnexpectedResponseError
+	if errors.As(err, &unexpected) && unexpected.HasStatusCode() {
+		return unexpected.StatusCode() == http.StatusNotImplemented || unexpected.StatusCode() == http.StatusNotFound
+	}
+
+	return classifyNomadError(err).Status() == http.StatusNotFound
+}
+
+// scalingEvents flattens the scaling events of all task groups, newest first
+func scalingEvents(status *api.JobScaleStatusResponse) []ScalingEvent {
+	events := []ScalingEvent{}
+	if status == nil {
+		return events
+	}
+
+	for group, tg := range status.TaskGroups {
+		for _, e := range tg.Events {
+			event := ScalingEvent{
+				Group:         group,
+				Count:         e.Count,
+				PreviousCount: e.PreviousCount,
+				Error:         e.Error,
+				Message:       e.Message,
+				Meta:          e.Meta,
+				Time:          time.Unix(0, int64(e.Time)).UTC(),
+			}
+			if e.EvalID != nil {
+				event.EvalID = *e.EvalID
+			}
+
+			events = append(events, event)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Time.Equal(events[j].Time) {
+			return events[i].Time.After(events[j].Time)
+		}
+
+		return events[i].Group < events[j].Group
+	})
+
+	return events
+}
@@ -0,0 +1,310 @@
+package nomad
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+)
+
+// Kinds of service graph nodes and edges
+const (
+	graphNodeJob     = "job"
+	graphNodeService = "service"
+
+	graphEdgeProvides  = "provides"
+	graphEdgeDependsOn = "depends-on"
+)
+
+// Where a service graph edge comes from
+const (
+	graphViaSpec         = "spec"
+	graphViaRegistration = "registration"
+	graphViaConnect      = "connect"
+	graphViaTemplate     = "template"
+)
+
+// templateServiceRe finds the services a template looks up with the service,
+// connect and nomadService functions, including the sharded form of
+// nomadService that takes a count and a key before the name
+var templateServiceRe = regexp.MustCompile(`\b(?:service|connect|nomadService)\s+(?:\d+\s+"[^"]*"\s+)?"([^"]+)"`)
+
+// ServiceGraph is the dependency graph of the services of a cluster. Jobs
+// provide the services they declare or that are registered for them, and
+// depend on the services their Connect sidecars have as upstreams or their
+// templates look up. Services of the Consul catalog are only known from the
+// job specifications, as Caravan does not talk to Consul.
+type ServiceGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+	// Errors maps the jobs or services that could not be read to the error
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// GraphNode is a job or a service. Instances is the number of Nomad service
+// registrations of a service.
+type GraphNode struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	JobType   string `json:"jobType,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Provider  string `json:"provider,omitempty"`
+	Instances int    `json:"instances"`
+}
+
+// GraphEdge links a job to a service it provides or depends on. Via tells
+// where the link was found, and Groups the task groups it was found in.
+type GraphEdge struct {
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	Kind   string   `json:"kind"`
+	Via    []string `json:"via"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// serviceGraph builds a ServiceGraph, merging the nodes and edges found more
+// than once
+type serviceGraph struct {
+	nodes map[string]*GraphNode
+	edges map[[3]string]*GraphEdge
+}
+
+// GetServiceGraph handles GET /clusters/{cluster}/graph
+func (h *Handler) GetServiceGraph(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+
+	client, err := h.GetClientWithToken(clusterName, getToken(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	if opts.Namespace == "" {
+		opts.Namespace = "*"
+	}
+
+	stubs, _, err := client.Jobs().List(opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	// Dispatched and periodic children share the spec of their parent
+	var parents []*api.JobListStub
+	for _, stub := range stubs {
+		if stub.ParentID == "" {
+			parents = append(parents, stub)
+		}
+	}
+
+	services, _, err := client.Services().List(opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	type serviceRef struct{ namespace, name string }
+	var refs []serviceRef
+	for _, ns := range services {
+		for _, svc := range ns.Services {
+			refs = append(refs, serviceRef{namespace: ns.Namespace, name: svc.ServiceName})
+		}
+	}
+
+	fanoutOpts := fanout.Options{Timeout: compositeCallTimeout}
+	jobs := fanout.Map(r.Context(), parents, fanoutOpts,
+		func(ctx context.Context, stub *api.JobListStub) (*api.Job, error) {
+			job, _, err := client.Jobs().Info(stub.ID, (&api.QueryOptions{
+				Namespace: stub.Namespace,
+				Region:    opts.Region,
+			}).WithContext(ctx))
+			return job, err
+		})
+	registrations := fanout.Map(r.Context(), refs, fanoutOpts,
+		func(ctx context.Context, ref serviceRef) ([]*api.ServiceRegistration, error) {
+			regs, _, err := client.Services().Get(ref.name, (&api.QueryOptions{
+				Namespace: ref.namespace,
+				Region:    opts.Region,
+			}).WithContext(ctx))
+			return regs, err
+		})
+
+	g := &serviceGraph{nodes: map[string]*GraphNode{}, edges: map[[3]string]*GraphEdge{}}
+	graph := ServiceGraph{Errors: map[string]string{}}
+
+	for i, result := range jobs {
+		if result.Err != nil {
+			graph.Errors[jobNodeID(parents[i].Namespace, parents[i].ID)] = result.Err.Error()
+			continue
+		}
+		g.addJob(result.Value)
+	}
+
+	for i, result := range registrations {
+		if result.Err != nil {
+			graph.Errors[serviceNodeID(refs[i].namespace, refs[i].name)] = result.Err.Error()
+			continue
+		}
+		for _, reg := range result.Value {
+			g.addRegistration(reg)
+		}
+	}
+
+	graph.Nodes, graph.Edges = g.sorted()
+
+	h.writeCompositeJSON(w, r, graph)
+}
+
+func jobNodeID(namespace, id string) string {
+	return graphNodeJob + ":" + namespace + "/" + id
+}
+
+func serviceNodeID(namespace, name string) string {
+	return graphNodeService + ":" + namespace + "/" + name
+}
+
+func (g *serviceGraph) addJob(job *api.Job) {
+	namespace := namespaceOrDefault(stringValue(job.Namespace))
+	jobID := jobNodeID(namespace, stringValue(job.ID))
+
+	g.nodes[jobID] = &GraphNode{
+		ID:        jobID,
+		Kind:      graphNodeJob,
+		Name:      stringValue(job.ID),
+		Namespace: namespace,
+		JobType:   stringValue(job.Type),
+		Status:    stringValue(job.Status),
+	}
+
+	for _, tg := range job.TaskGroups {
+		group := stringValue(tg.Name)
+
+		services := append([]*api.Service(nil), tg.Services...)
+		for _, task := range tg.Tasks {
+			services = append(services, task.Services...)
+
+			for _, tmpl := range task.Templates {
+				for _, name := range templateServices(stringValue(tmpl.EmbeddedTmpl)) {
+					g.addEdge(jobID, g.service(namespace, name, ""), graphEdgeDependsOn, graphViaTemplate, group)
+				}
+			}
+		}
+
+		for _, svc := range services {
+			if svc == nil || svc.Name == "" {
+				continue
+			}
+			g.addEdge(jobID, g.service(namespace, svc.Name, svc.Provider), graphEdgeProvides, graphViaSpec, group)
+
+			if svc.Connect == nil || svc.Connect.SidecarService == nil || svc.Connect.SidecarService.Proxy == nil {
+				continue
+			}
+			for _, upstream := range svc.Connect.SidecarService.Proxy.Upstreams {
+				if upstream == nil || upstream.DestinationName == "" {
+					continue
+				}
+
+				upstreamNamespace := namespace
+				if upstream.DestinationNamespace != "" {
+					upstreamNamespace = upstream.DestinationNamespace
+				}
+				service := g.service(upstreamNamespace, upstream.DestinationName, "consul")
+				g.addEdge(jobID, service, graphEdgeDependsOn, graphViaConnect, group)
+			}
+		}
+	}
+}
+
+func (g *serviceGraph) addRegistration(reg *api.ServiceRegistration) {
+	service := g.service(reg.Namespace, reg.ServiceName, "nomad")
+	g.nodes[service].Instances++
+
+	if reg.JobID == "" {
+		return
+	}
+
+	jobID := jobNodeID(reg.Namespace, reg.JobID)
+	if _, ok := g.nodes[jobID]; !ok {
+		g.nodes[jobID] = &GraphNode{ID: jobID, Kind: graphNodeJob, Name: reg.JobID, Namespace: reg.Namespace}
+	}
+	g.addEdge(jobID, service, graphEdgeProvides, graphViaRegistration, "")
+}
+
+// service returns the ID of a service node, adding the node if needed
+func (g *serviceGraph) service(namespace, name, provider string) string {
+	id := serviceNodeID(namespace, name)
+
+	node, ok := g.nodes[id]
+	if !ok {
+		node = &GraphNode{ID: id, Kind: graphNodeService, Name: name, Namespace: namespace}
+		g.nodes[id] = node
+	}
+	if node.Provider == "" && provider != "" {
+		node.Provider = provider
+	}
+
+	return id
+}
+
+func (g *serviceGraph) addEdge(from, to, kind, via, group string) {
+	key := [3]string{from, to, kind}
+
+	edge, ok := g.edges[key]
+	if !ok {
+		edge = &GraphEdge{From: from, To: to, Kind: kind, Via: []string{}}
+		g.edges[key] = edge
+	}
+	if !slices.Contains(edge.Via, via) {
+		edge.Via = append(edge.Via, via)
+	}
+	if group != "" && !slices.Contains(edge.Groups, group) {
+		edge.Groups = append(edge.Groups, group)
+	}
+}
+
+func (g *serviceGraph) sorted() ([]GraphNode, []GraphEdge) {
+	nodes := make([]GraphNode, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		nodes = append(nodes, *node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	edges := make([]GraphEdge, 0, len(g.edges))
+	for _, edge := range g.edges {
+		sort.Strings(edge.Via)
+		sort.Strings(edge.Groups)
+		edges = append(edges, *edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		return a.From+" "+a.To+" "+a.Kind < b.From+" "+b.To+" "+b.Kind
+	})
+
+	return nodes, edges
+}
+
+// templateServices returns the names of the services a template looks up.
+// Lookups may be given as "tag.name@datacenter"; only the name is kept.
+func templateServices(tmpl string) []string {
+	var names []string
+
+	for _, match := range templateServiceRe.FindAllStringSubmatch(tmpl, -1) {
+		name, _, _ := strings.Cut(match[1], "@")
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
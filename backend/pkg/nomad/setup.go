@@ -0,0 +1,346 @@
+package nomad
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+	"github.com/caravan-nomad/caravan/backend/pkg/telemetry"
+)
+
+// setupBucket holds the settings saved by the setup wizard
+const setupBucket = "setup"
+
+// setupAdminKey is the key of the AdminSettings in setupBucket
+const setupAdminKey = "admin"
+
+const (
+	// minAdminPasswordLength is the shortest admin password accepted
+	minAdminPasswordLength = 12
+	// adminPasswordIterations is the PBKDF2 work factor of admin passwords
+	adminPasswordIterations = 600_000
+)
+
+// Steps of the setup wizard
+const (
+	SetupStepCluster = "cluster"
+	SetupStepLogin   = "login"
+	SetupStepDone    = "done"
+)
+
+// SetupStatus tells the frontend whether to show the setup wizard, and at
+// which step: adding the first cluster, logging into it, or done
+type SetupStatus struct {
+	Clusters        int    `json:"clusters"`
+	AdminConfigured bool   `json:"adminConfigured"`
+	Step            string `json:"step"`
+}
+
+// SetupClusterRequest describes the cluster to validate or add
+type SetupClusterRequest struct {
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Region    string `json:"region"`
+	Namespace string `json:"namespace"`
+	Token     string `json:"token"`
+	// AutoscalerAddress is the optional Nomad Autoscaler agent of the cluster
+	AutoscalerAddress string `json:"autoscalerAddress"`
+}
+
+// SetupValidation is the outcome of trying to reach a cluster. Token
+// details are only set when a token was given and Nomad accepted it.
+type SetupValidation struct {
+	Reachable  bool   `json:"reachable"`
+	Leader     string `json:"leader,omitempty"`
+	TokenValid bool   `json:"tokenValid"`
+	TokenName  string `json:"tokenName,omitempty"`
+	TokenType  string `json:"tokenType,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+// SetupAdminRequest is the body of PUT /api/setup/admin
+type SetupAdminRequest struct {
+	Password string `json:"password"`
+}
+
+// AdminSettings are the admin settings saved by the setup wizard. The
+// password, which POST /api/admin/login starts admin sessions with, is only
+// kept as a salted PBKDF2-SHA256 hash.
+type AdminSettings struct {
+	PasswordHash       string    `json:"passwordHash,omitempty"`
+	PasswordSalt       string    `json:"passwordSalt,omitempty"`
+	PasswordIterations int       `json:"passwordIterations,omitempty"`
+	CreatedAt          time.Time `json:"createdAt"`
+}
+
+// GetSetupStatus handles GET /api/setup
+func (h *Handler) GetSetupStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := h.setupStatus(r)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, status)
+}
+
+func (h *Handler) setupStatus(r *http.Request) (SetupStatus, error) {
+	contexts := h.configStore.GetContexts()
+	status := SetupStatus{Clusters: len(contexts), Step: SetupStepDone}
+
+	admin, err := h.adminSettings(r)
+	if err != nil {
+		return status, err
+	}
+	if admin != nil {
+		status.AdminConfigured = admin.PasswordHash != ""
+	}
+
+	// Clusters added with a token of their own, or without ACLs, need no login
+	withToken := false
+	for _, ctx := range contexts {
+		withToken = withToken || ctx.Token != "" || ctx.ACLDisabled
+	}
+
+	switch {
+	case status.Clusters == 0:
+		status.Step = SetupStepCluster
+	case getToken(r) == "" && !withToken:
+		status.Step = SetupStepLogin
+	}
+
+	return status, nil
+}
+
+// ValidateSetupCluster handles POST /api/setup/validate. It checks that the
+// cluster is reachable and the token valid without adding the cluster.
+func (h *Handler) ValidateSetupCluster(w http.ResponseWriter, r *http.Request) {
+	var req SetupClusterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := validateSetupAddress(req.Address); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, h.validateCluster(&req))
+}
+
+// AddSetupCluster handles POST /api/setup/cluster. It only adds the first
+// cluster, and only once Nomad answers at its address; later clusters are
+// added through POST /api/cluster.
+func (h *Handler) AddSetupCluster(w http.ResponseWriter, r *http.Request) {
+	var req SetupClusterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		writeError(w, r, errors.New("name is required"), http.StatusBadRequest)
+		return
+	}
+	if err := validateSetupAddress(req.Address); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	h.setupMutex.Lock()
+	defer h.setupMutex.Unlock()
+
+	if len(h.configStore.GetContexts()) > 0 {
+		writeError(w, r, apierror.New(http.StatusConflict, apierror.CodeConflict,
+			"a cluster is already configured, setup is over"), http.StatusConflict)
+		return
+	}
+
+	validation := h.validateCluster(&req)
+	if !validation.Reachable {
+		apiErr := apierror.New(http.StatusBadGateway, apierror.CodeNomadUnreachable, validation.Message)
+		writeError(w, r, apiErr.WithDetails(validation), http.StatusBadGateway)
+		return
+	}
+
+	nomadCtx := setupContext(&req)
+	if err := nomadCtx.ProbeACL(r.Context()); err != nil {
+		logger.Log(logger.LevelWarn, map[string]string{"cluster": req.Name}, err, "probing ACLs")
+	}
+
+	if err := h.configStore.AddContext(nomadCtx); err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	h.InvalidateClient(req.Name)
+	telemetry.RecordClusterAdded()
+
+	status, err := h.setupStatus(r)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(status)
+}
+
+// SaveSetupAdmin handles PUT /api/setup/admin. The admin settings can only
+// be saved once. Before any cluster is configured the endpoint is open to
+// anyone, like the rest of setup; once clusters exist, which includes
+// installs upgraded from before the wizard, only an admin of all of them
+// may save them, as the password makes its holder Caravan's admin.
+func (h *Handler) SaveSetupAdmin(w http.ResponseWriter, r *http.Request) {
+	var req SetupAdminRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Password) < minAdminPasswordLength {
+		writeError(w, r, fmt.Errorf("the password must have at least %d characters", minAdminPasswordLength),
+			http.StatusBadRequest)
+		return
+	}
+
+	h.setupMutex.Lock()
+	defer h.setupMutex.Unlock()
+
+	if len(h.configStore.GetContexts()) > 0 {
+		if _, ok := h.requireManagement(w, r, ""); !ok {
+			return
+		}
+	}
+
+	existing, err := h.adminSettings(r)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	if existing != nil {
+		writeError(w, r, apierror.New(http.StatusConflict, apierror.CodeConflict,
+			"the admin settings are already saved"), http.StatusConflict)
+		return
+	}
+
+	salt := make([]byte, 16)
+	_, _ = rand.Read(salt)
+
+	hash, err := pbkdf2.Key(sha256.New, req.Password, salt, adminPasswordIterations, sha256.Size)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("hashing the password: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	settings := AdminSettings{
+		PasswordHash:       hex.EncodeToString(hash),
+		PasswordSalt:       hex.EncodeToString(salt),
+		PasswordIterations: adminPasswordIterations,
+		CreatedAt:          time.Now().UTC(),
+	}
+
+	if err := store.PutJSON(r.Context(), h.store, setupBucket, setupAdminKey, settings); err != nil {
+		writeError(w, r, fmt.Errorf("saving the admin settings: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	status, err := h.setupStatus(r)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, status)
+}
+
+// adminSettings reads the saved admin settings, nil if there are none
+func (h *Handler) adminSettings(r *http.Request) (*AdminSettings, error) {
+	var settings AdminSettings
+
+	err := store.GetJSON(r.Context(), h.store, setupBucket, setupAdminKey, &settings)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the admin settings: %w", err)
+	}
+
+	return &settings, nil
+}
+
+func (h *Handler) validateCluster(req *SetupClusterRequest) SetupValidation {
+	var validation SetupValidation
+
+	client, err := h.newClient(setupContext(req), req.Token)
+	if err != nil {
+		validation.Message = fmt.Sprintf("Failed to create client: %v", err)
+		return validation
+	}
+
+	leader, err := client.Status().Leader()
+	switch {
+	case err == nil:
+		validation.Reachable = true
+		validation.Leader = leader
+	case contains403(err.Error()) || contains401(err.Error()):
+		validation.Reachable = true
+		validation.Message = "The cluster needs a valid token"
+		return validation
+	default:
+		validation.Message = fmt.Sprintf("Cannot connect to cluster: %v", err)
+		return validation
+	}
+
+	if req.Token == "" {
+		return validation
+	}
+
+	self, _, err := client.ACLTokens().Self(nil)
+	if err != nil {
+		validation.Message = fmt.Sprintf("The token was not accepted: %v", err)
+		return validation
+	}
+
+	validation.TokenValid = true
+	validation.TokenName = self.Name
+	validation.TokenType = self.Type
+
+	return validation
+}
+
+func setupContext(req *SetupClusterRequest) *nomadconfig.Context {
+	return &nomadconfig.Context{
+		Name:      req.Name,
+		Address:   req.Address,
+		Region:    req.Region,
+		Namespace: req.Namespace,
+		Token:     req.Token,
+		Source:    nomadconfig.DynamicCluster,
+
+		AutoscalerAddress: req.AutoscalerAddress,
+	}
+}
+
+func validateSetupAddress(address string) error {
+	u, err := url.Parse(address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("address must be an http or https URL, such as http://127.0.0.1:4646")
+	}
+
+	return nil
+}
@@ -0,0 +1,250 @@
+package nomad
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/forwarded"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+const (
+	// shareLinksBucket holds the key share links are signed with, so that
+	// links outlive restarts and work on every replica sharing the store
+	shareLinksBucket = "share-links"
+	shareLinkKeyName = "signing-key"
+	// defaultShareLinkTTL is how long a share link works when the request
+	// does not say
+	defaultShareLinkTTL = time.Hour
+	// maxShareLinkTTL bounds the lifetime of share links
+	maxShareLinkTTL = 24 * time.Hour
+)
+
+// ShareLinkRequest is the body of POST /api/share-links
+type ShareLinkRequest struct {
+	Cluster string `json:"cluster"`
+	// Path is the view within the cluster, such as
+	// "/allocations/0d3c…?tab=logs&task=web"
+	Path string `json:"path"`
+	// TTL is a duration such as "30m", one hour by default
+	TTL string `json:"ttl,omitempty"`
+}
+
+// ShareLink is a signed link to a view of a cluster. It carries no
+// credentials: who opens it still needs a session on the cluster.
+type ShareLink struct {
+	URL       string    `json:"url"`
+	Cluster   string    `json:"cluster"`
+	Path      string    `json:"path"`
+	SharedBy  string    `json:"sharedBy,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// shareLinkClaims are what a share link token signs
+type shareLinkClaims struct {
+	Cluster   string `json:"c"`
+	Path      string `json:"p"`
+	SharedBy  string `json:"by,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// CreateShareLink handles POST /api/share-links. Only users with a session
+// on the cluster can share its views.
+func (h *Handler) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	var req ShareLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.Cluster == "" {
+		writeError(w, r, errMissingField("cluster"), http.StatusBadRequest)
+		return
+	}
+	if err := validateSharePath(req.Path); err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	ttl := defaultShareLinkTTL
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 || ttl > maxShareLinkTTL {
+			writeError(w, r, fmt.Errorf("ttl must be a duration of at most %s", maxShareLinkTTL),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	client, err := h.GetClientWithToken(req.Cluster, getTokenForCluster(r, req.Cluster))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	claims := shareLinkClaims{
+		Cluster:   req.Cluster,
+		Path:      req.Path,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}
+
+	if nomadCtx, err := h.configStore.GetContext(req.Cluster); err != nil || !nomadCtx.ACLDisabled {
+		_, name, err := tokenIdentity(client)
+		if err != nil {
+			writeNomadError(w, r, err)
+			return
+		}
+		claims.SharedBy = name
+	}
+
+	token, err := h.signShareLink(r.Context(), claims)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("signing the share link: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, ShareLink{
+		URL:       forwarded.ResolveURL(r, "/api/share-links/"+token),
+		Cluster:   claims.Cluster,
+		Path:      claims.Path,
+		SharedBy:  claims.SharedBy,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+	})
+}
+
+// OpenShareLink handles GET /api/share-links/{token}, where share links
+// point. Visitors with a session on the cluster land in the shared view,
+// the others on its login page, which takes them there once logged in.
+func (h *AuthHandler) OpenShareLink(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.nomadHandler.verifyShareLink(r.Context(), r.PathValue("token"))
+	if err != nil {
+		writeError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	nomadCtx, err := h.nomadHandler.configStore.GetContext(claims.Cluster)
+	if err != nil {
+		writeError(w, r, apierror.New(http.StatusNotFound, apierror.CodeClusterNotFound,
+			fmt.Sprintf("cluster %q no longer exists", claims.Cluster)), http.StatusNotFound)
+		return
+	}
+
+	view := "/c/" + url.PathEscape(claims.Cluster) + claims.Path
+	target := view
+
+	loggedIn := getTokenForCluster(r, claims.Cluster) != "" || nomadCtx.Token != "" || nomadCtx.ACLDisabled
+	if !loggedIn {
+		target = "/login/" + url.PathEscape(claims.Cluster) + "?to=" + url.QueryEscape(view)
+	}
+
+	http.Redirect(w, r, forwarded.BasePath(r, h.baseURL)+target, http.StatusFound)
+}
+
+// signShareLink encodes claims as a token signed with the share link key
+func (h *Handler) signShareLink(ctx context.Context, claims shareLinkClaims) (string, error) {
+	key, err := h.shareLinkKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyShareLink checks the signature and expiry of a share link token
+func (h *Handler) verifyShareLink(ctx context.Context, token string) (*shareLinkClaims, error) {
+	invalid := apierror.New(http.StatusNotFound, apierror.CodeNotFound, "the share link is not valid")
+
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, invalid
+	}
+
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, invalid
+	}
+
+	key, err := h.shareLinkKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, invalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, invalid
+	}
+
+	var claims shareLinkClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, invalid
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "the share link expired")
+	}
+
+	return &claims, nil
+}
+
+// shareLinkKey returns the key share links are signed with, creating it the
+// first time
+func (h *Handler) shareLinkKey(ctx context.Context) ([]byte, error) {
+	h.shareLinkMutex.Lock()
+	defer h.shareLinkMutex.Unlock()
+
+	if h.shareLinkKeyCache != nil {
+		return h.shareLinkKeyCache, nil
+	}
+
+	key, err := h.store.Get(ctx, shareLinksBucket, shareLinkKeyName)
+	if errors.Is(err, store.ErrNotFound) {
+		key = make([]byte, sha256.Size)
+		_, _ = rand.Read(key)
+		err = h.store.Put(ctx, shareLinksBucket, shareLinkKeyName, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	h.shareLinkKeyCache = key
+
+	return key, nil
+}
+
+// validateSharePath only accepts paths within Caravan, so that share links
+// cannot redirect elsewhere
+func validateSharePath(path string) error {
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/") ||
+		strings.HasPrefix(path, "//") || strings.Contains(path, `\`) {
+		return errors.New("path must be a path within the cluster, such as /jobs")
+	}
+
+	return nil
+}
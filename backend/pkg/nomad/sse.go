@@ -0,0 +1,189 @@
+package nomad
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/caravan-nomad/caravan/backend/pkg/telemetry"
+)
+
+// defaultStreamWriteTimeout is how long a write to a stream's client may
+// take before the client is taken to have stopped reading and is dropped
+const defaultStreamWriteTimeout = 10 * time.Second
+
+// maxSSELine is the longest log line sent as one event; longer ones are split
+const maxSSELine = 32 << 10
+
+// sseFlushSize is how much a stream buffers before it flushes, even in the
+// middle of a batch of events
+const sseFlushSize = 64 << 10
+
+// maxPooledBuffer is the largest buffer put back in sseBuffers, so that one
+// huge event does not stay allocated once its stream is gone
+const maxPooledBuffer = 256 << 10
+
+// sseBuffers holds the buffers events are put together in, shared by the
+// streams so that hundreds of them do not each keep their own
+var sseBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// WithStreamWriteTimeout sets how long a write to the client of an SSE
+// stream or WebSocket may block before the client is dropped
+func WithStreamWriteTimeout(timeout time.Duration) Option {
+	return func(h *Handler) {
+		if timeout > 0 {
+			h.streamWriteTimeout = timeout
+		}
+	}
+}
+
+// sseWriter writes server-sent events. Events are put together in a pooled
+// buffer until Flush sends them, within the handler's write timeout, and
+// counts them in the metrics of the stream named name.
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	rc      *http.ResponseController
+	name    string
+	timeout time.Duration
+	buf     *bytes.Buffer
+	events  int
+}
+
+// newSSEWriter sets the headers of an SSE stream on w. It fails, with an
+// error already written, if w cannot stream.
+func (h *Handler) newSSEWriter(w http.ResponseWriter, r *http.Request, name string) (*sseWriter, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, errors.New("streaming not supported"), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable nginx buffering
+
+	return &sseWriter{
+		w:       w,
+		flusher: flusher,
+		rc:      http.NewResponseController(w),
+		name:    name,
+		timeout: h.streamWriteTimeout,
+		buf:     sseBuffers.Get().(*bytes.Buffer),
+	}, true
+}
+
+// Event adds an event to the buffer. id and event are left out when empty.
+// Each line of data goes on a data field of its own, which clients join
+// back together.
+func (s *sseWriter) Event(event, id string, data []byte) {
+	if id != "" {
+		s.buf.WriteString("id: ")
+		s.buf.WriteString(id)
+		s.buf.WriteByte('\n')
+	}
+	if event != "" {
+		s.buf.WriteString("event: ")
+		s.buf.WriteString(event)
+		s.buf.WriteByte('\n')
+	}
+
+	for {
+		line, rest, more := bytes.Cut(data, []byte("\n"))
+		s.buf.WriteString("data: ")
+		s.buf.Write(line)
+		s.buf.WriteByte('\n')
+		if !more {
+			break
+		}
+		data = rest
+	}
+
+	s.buf.WriteByte('\n')
+	s.events++
+}
+
+// Full reports whether the buffer holds enough to be flushed
+func (s *sseWriter) Full() bool {
+	return s.buf.Len() >= sseFlushSize
+}
+
+// Send adds an event and flushes it
+func (s *sseWriter) Send(event, id string, data []byte) error {
+	s.Event(event, id, data)
+	return s.Flush()
+}
+
+// Error sends an error event
+func (s *sseWriter) Error(err error) {
+	s.Send("error", "", []byte(err.Error()))
+}
+
+// Flush sends the buffered events, and the headers if nothing was sent yet.
+// A client that does not take them within the write timeout is dropped.
+func (s *sseWriter) Flush() error {
+	start := time.Now()
+
+	// Writers that cannot set deadlines, such as recorders, never block
+	s.rc.SetWriteDeadline(start.Add(s.timeout))
+	defer s.rc.SetWriteDeadline(time.Time{})
+
+	n, err := s.w.Write(s.buf.Bytes())
+	if err == nil {
+		s.flusher.Flush()
+	}
+
+	// Flush does not report errors, but one still going at the deadline failed
+	elapsed := time.Since(start)
+	if err == nil && elapsed >= s.timeout {
+		err = os.ErrDeadlineExceeded
+	}
+
+	telemetry.RecordStreamWrite(s.name, n, s.events, elapsed.Seconds())
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		telemetry.RecordStreamWriteTimeout(s.name)
+	}
+
+	s.buf.Reset()
+	s.events = 0
+
+	return err
+}
+
+// Close gives the buffer back to the pool. The writer must not be used after.
+func (s *sseWriter) Close() {
+	if s.buf.Cap() <= maxPooledBuffer {
+		s.buf.Reset()
+		sseBuffers.Put(s.buf)
+	}
+	s.buf = nil
+}
+
+// writeWS writes a message to a WebSocket within the handler's write
+// timeout, which closes the WebSocket when it passes. Writes to clients are
+// counted in the metrics of the stream named name; writes to Nomad, with no
+// name, are not.
+func (h *Handler) writeWS(ctx context.Context, conn *websocket.Conn, name string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, h.streamWriteTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := conn.Write(ctx, websocket.MessageText, data)
+	switch {
+	case name == "":
+	case err == nil:
+		telemetry.RecordStreamWrite(name, len(data), 1, time.Since(start).Seconds())
+	case errors.Is(err, context.DeadlineExceeded):
+		telemetry.RecordStreamWriteTimeout(name)
+	}
+
+	return err
+}
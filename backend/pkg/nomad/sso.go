@@ -0,0 +1,518 @@
+package nomad
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/apierror"
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+	"github.com/caravan-nomad/caravan/backend/pkg/forwarded"
+)
+
+// ssoSessionTTL is how long a single sign-on may take to go through all its
+// clusters
+const ssoSessionTTL = 10 * time.Minute
+
+// SSOMethod is an auth method that several clusters share, so a single
+// sign-on can log into all of them
+type SSOMethod struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Clusters []string `json:"clusters"`
+}
+
+// SSOStartRequest is the body of POST /api/sso/oidc/start
+type SSOStartRequest struct {
+	AuthMethodName string `json:"auth_method_name"`
+	RedirectURI    string `json:"redirect_uri"`
+	// Cluster is the one the user chose to log into. Other clusters are only
+	// included when their auth method uses the same provider.
+	Cluster string `json:"cluster,omitempty"`
+	// Clusters limits the sign-on to some of the matching clusters
+	Clusters []string `json:"clusters,omitempty"`
+}
+
+// SSOCompleteRequest is the body of POST /api/sso/oidc/complete
+type SSOCompleteRequest struct {
+	Session string `json:"session"`
+	State   string `json:"state"`
+	Code    string `json:"code"`
+}
+
+// SSOJWTRequest is the body of POST /api/sso/jwt
+type SSOJWTRequest struct {
+	AuthMethodName string   `json:"auth_method_name"`
+	JWT            string   `json:"jwt"`
+	Cluster        string   `json:"cluster,omitempty"`
+	Clusters       []string `json:"clusters,omitempty"`
+}
+
+// SSOStep is the next cluster of an OIDC sign-on, to redirect the user to
+type SSOStep struct {
+	Cluster string `json:"cluster"`
+	AuthURL string `json:"auth_url"`
+}
+
+// SSOResponse reports the progress of a single sign-on. The tokens it got
+// are kept in the browser's token vault. Next is nil once every cluster
+// has been tried.
+type SSOResponse struct {
+	Session    string            `json:"session,omitempty"`
+	Clusters   []string          `json:"clusters"`
+	Registered []VaultToken      `json:"registered"`
+	Errors     map[string]string `json:"errors,omitempty"`
+	Next       *SSOStep          `json:"next,omitempty"`
+}
+
+// ssoSession is an OIDC sign-on going through its clusters one by one. An
+// authorization code can only be exchanged once, by the cluster whose
+// auth URL the provider redirected from, so each cluster gets its own
+// redirect. The provider remembers the user after the first one, so the
+// later redirects come back without asking them again.
+type ssoSession struct {
+	method      string
+	redirectURI string
+	clusters    []string
+	pending     []string
+	current     string
+	nonce       string
+	registered  []VaultToken
+	errors      map[string]string
+	expires     time.Time
+}
+
+// authMethodIssuer identifies the provider an auth method authenticates
+// against, "" if its configuration cannot be read
+func authMethodIssuer(m *api.ACLAuthMethod) string {
+	if m == nil || m.Config == nil {
+		return ""
+	}
+
+	c := m.Config
+
+	switch {
+	case c.OIDCDiscoveryURL != "":
+		return strings.TrimSuffix(c.OIDCDiscoveryURL, "/") + " " + c.OIDCClientID
+	case c.JWKSURL != "":
+		return c.JWKSURL
+	case len(c.BoundIssuer) > 0:
+		return strings.Join(c.BoundIssuer, ",")
+	}
+
+	return ""
+}
+
+// ssoClusters returns the clusters that have an auth method of the given
+// name and type. If primary is set, clusters whose method is known to use
+// another provider than the primary's are left out; reading the method's
+// configuration needs a privileged token, without one the name decides.
+func (h *Handler) ssoClusters(ctx context.Context, method, methodType, primary string) []string {
+	contexts := h.configStore.GetContexts()
+	names := make([]string, 0, len(contexts))
+	for _, c := range contexts {
+		names = append(names, c.Name)
+	}
+
+	type match struct {
+		ok     bool
+		issuer string
+	}
+
+	results := fanout.Map(ctx, names, fanout.Options{Timeout: compositeCallTimeout},
+		func(ctx context.Context, cluster string) (match, error) {
+			client, err := h.GetClient(cluster)
+			if err != nil {
+				return match{}, err
+			}
+
+			stubs, _, err := client.ACLAuthMethods().List((&api.QueryOptions{}).WithContext(ctx))
+			if err != nil {
+				return match{}, err
+			}
+
+			for _, stub := range stubs {
+				if stub.Name == method && stub.Type == methodType {
+					m, _, _ := client.ACLAuthMethods().Get(method, (&api.QueryOptions{}).WithContext(ctx))
+					return match{ok: true, issuer: authMethodIssuer(m)}, nil
+				}
+			}
+
+			return match{}, nil
+		})
+
+	issuer := ""
+	for i, cluster := range names {
+		if cluster == primary {
+			issuer = results[i].Value.issuer
+		}
+	}
+
+	var clusters []string
+	for i, cluster := range names {
+		m := results[i].Value
+		if !m.ok || (issuer != "" && m.issuer != "" && m.issuer != issuer) {
+			continue
+		}
+
+		clusters = append(clusters, cluster)
+	}
+
+	sort.Strings(clusters)
+
+	return clusters
+}
+
+// selectClusters narrows the matching clusters to the requested ones
+func selectClusters(matching, requested []string) []string {
+	if len(requested) == 0 {
+		return matching
+	}
+
+	wanted := make(map[string]bool, len(requested))
+	for _, cluster := range requested {
+		wanted[cluster] = true
+	}
+
+	var selected []string
+	for _, cluster := range matching {
+		if wanted[cluster] {
+			selected = append(selected, cluster)
+		}
+	}
+
+	return selected
+}
+
+// ListSSOMethods handles GET /api/sso/methods
+// It lists the auth methods configured on more than one cluster.
+func (h *Handler) ListSSOMethods(w http.ResponseWriter, r *http.Request) {
+	contexts := h.configStore.GetContexts()
+
+	var mu sync.Mutex
+	methods := make(map[[2]string][]string)
+
+	g := fanout.New(r.Context(), fanout.Options{Timeout: compositeCallTimeout})
+	for _, c := range contexts {
+		cluster := c.Name
+		g.Go(cluster, func(ctx context.Context) error {
+			client, err := h.GetClient(cluster)
+			if err != nil {
+				return err
+			}
+
+			stubs, _, err := client.ACLAuthMethods().List((&api.QueryOptions{}).WithContext(ctx))
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			for _, stub := range stubs {
+				key := [2]string{stub.Name, stub.Type}
+				methods[key] = append(methods[key], cluster)
+			}
+
+			return nil
+		})
+	}
+	g.Wait()
+
+	shared := []SSOMethod{}
+	for key, clusters := range methods {
+		if len(clusters) < 2 {
+			continue
+		}
+
+		sort.Strings(clusters)
+		shared = append(shared, SSOMethod{Name: key[0], Type: key[1], Clusters: clusters})
+	}
+
+	sort.Slice(shared, func(i, j int) bool {
+		if shared[i].Name != shared[j].Name {
+			return shared[i].Name < shared[j].Name
+		}
+		return shared[i].Type < shared[j].Type
+	})
+
+	writeJSON(w, shared)
+}
+
+// StartSSO handles POST /api/sso/oidc/start
+// It starts an OIDC sign-on into every cluster sharing the auth method and
+// returns the auth URL of the first.
+func (h *Handler) StartSSO(w http.ResponseWriter, r *http.Request) {
+	var req SSOStartRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.AuthMethodName == "" {
+		writeError(w, r, errors.New("auth_method_name is required"), http.StatusBadRequest)
+		return
+	}
+	if req.RedirectURI == "" {
+		writeError(w, r, errors.New("redirect_uri is required"), http.StatusBadRequest)
+		return
+	}
+
+	clusters := selectClusters(h.ssoClusters(r.Context(), req.AuthMethodName, api.ACLAuthMethodTypeOIDC, req.Cluster),
+		req.Clusters)
+	if len(clusters) == 0 {
+		apierror.Write(w, r, apierror.New(http.StatusNotFound, apierror.CodeNotFound,
+			fmt.Sprintf("no cluster has an OIDC auth method named %q", req.AuthMethodName)))
+		return
+	}
+
+	session := &ssoSession{
+		method:      req.AuthMethodName,
+		redirectURI: forwarded.ResolveURL(r, req.RedirectURI),
+		clusters:    clusters,
+		pending:     clusters,
+		registered:  []VaultToken{},
+		errors:      map[string]string{},
+		expires:     time.Now().Add(ssoSessionTTL),
+	}
+
+	next := h.nextSSOStep(r.Context(), session)
+
+	id := newSessionID()
+	if next != nil {
+		h.ssoMutex.Lock()
+		if h.ssoSessions == nil {
+			h.ssoSessions = make(map[string]*ssoSession)
+		}
+		h.ssoSessions[id] = session
+		h.ssoMutex.Unlock()
+	}
+
+	writeJSON(w, session.response(id, next))
+}
+
+// CompleteSSO handles POST /api/sso/oidc/complete
+// It completes the login into the session's current cluster, keeps the
+// token in the token vault and returns the next cluster to log into.
+func (h *Handler) CompleteSSO(w http.ResponseWriter, r *http.Request) {
+	var req SSOCompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.Code == "" {
+		writeError(w, r, errors.New("code is required"), http.StatusBadRequest)
+		return
+	}
+
+	// The session is taken out while its cluster is completed, so a code
+	// replayed concurrently finds none
+	h.ssoMutex.Lock()
+	session, ok := h.ssoSessions[req.Session]
+	delete(h.ssoSessions, req.Session)
+	for id, s := range h.ssoSessions {
+		if time.Now().After(s.expires) {
+			delete(h.ssoSessions, id)
+		}
+	}
+	h.ssoMutex.Unlock()
+
+	if !ok || time.Now().After(session.expires) {
+		apierror.Write(w, r, apierror.New(http.StatusNotFound, apierror.CodeNotFound,
+			"the sign-on session does not exist or has expired"))
+		return
+	}
+
+	client, err := h.GetClient(session.current)
+	if err == nil && h.logins.retryAfter(r, session.current) > 0 {
+		err = errors.New("too many failed logins, try again later")
+	}
+	if err == nil {
+		var token *api.ACLToken
+		token, _, err = client.ACLAuth().CompleteAuth(&api.ACLOIDCCompleteAuthRequest{
+			AuthMethodName: session.method,
+			ClientNonce:    session.nonce,
+			State:          req.State,
+			Code:           req.Code,
+			RedirectURI:    session.redirectURI,
+		}, (&api.WriteOptions{}).WithContext(r.Context()))
+
+		if err != nil {
+			h.loginFailed(r, session.current, err)
+		} else {
+			h.loginSucceeded(r)
+			entry := newVaultEntry(session.current, token)
+			if err = h.addToVault(w, r, []vaultEntry{entry}); err == nil {
+				session.registered = append(session.registered, entry.VaultToken)
+			}
+		}
+	}
+
+	if err != nil {
+		session.errors[session.current] = err.Error()
+	}
+
+	next := h.nextSSOStep(r.Context(), session)
+	if next != nil {
+		h.ssoMutex.Lock()
+		h.ssoSessions[req.Session] = session
+		h.ssoMutex.Unlock()
+	}
+
+	writeJSON(w, session.response(req.Session, next))
+}
+
+// nextSSOStep moves the session to its next cluster that gives an auth
+// URL, nil once there is none left
+func (h *Handler) nextSSOStep(ctx context.Context, s *ssoSession) *SSOStep {
+	for len(s.pending) > 0 {
+		cluster := s.pending[0]
+		s.pending = s.pending[1:]
+
+		s.current = cluster
+		s.nonce = newSessionID()
+
+		client, err := h.GetClient(cluster)
+		if err != nil {
+			s.errors[cluster] = err.Error()
+			continue
+		}
+
+		resp, _, err := client.ACLAuth().GetAuthURL(&api.ACLOIDCAuthURLRequest{
+			AuthMethodName: s.method,
+			RedirectURI:    s.redirectURI,
+			ClientNonce:    s.nonce,
+		}, (&api.WriteOptions{}).WithContext(ctx))
+		if err != nil {
+			s.errors[cluster] = err.Error()
+			continue
+		}
+
+		return &SSOStep{Cluster: cluster, AuthURL: resp.AuthURL}
+	}
+
+	return nil
+}
+
+func (s *ssoSession) response(id string, next *SSOStep) SSOResponse {
+	resp := SSOResponse{
+		Clusters:   s.clusters,
+		Registered: s.registered,
+		Next:       next,
+	}
+
+	if next != nil {
+		resp.Session = id
+	}
+
+	if len(s.errors) > 0 {
+		resp.Errors = s.errors
+	}
+
+	return resp
+}
+
+// LoginSSOJWT handles POST /api/sso/jwt
+// It logs into every cluster sharing the JWT auth method at once and keeps
+// the tokens in the token vault.
+func (h *Handler) LoginSSOJWT(w http.ResponseWriter, r *http.Request) {
+	var req SSOJWTRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.AuthMethodName == "" {
+		writeError(w, r, errors.New("auth_method_name is required"), http.StatusBadRequest)
+		return
+	}
+	if req.JWT == "" {
+		writeError(w, r, errors.New("jwt is required"), http.StatusBadRequest)
+		return
+	}
+
+	clusters := selectClusters(h.ssoClusters(r.Context(), req.AuthMethodName, api.ACLAuthMethodTypeJWT, req.Cluster),
+		req.Clusters)
+	if len(clusters) == 0 {
+		apierror.Write(w, r, apierror.New(http.StatusNotFound, apierror.CodeNotFound,
+			fmt.Sprintf("no cluster has a JWT auth method named %q", req.AuthMethodName)))
+		return
+	}
+
+	results := fanout.Map(r.Context(), clusters, fanout.Options{Timeout: compositeCallTimeout},
+		func(ctx context.Context, cluster string) (*api.ACLToken, error) {
+			if h.logins.retryAfter(r, cluster) > 0 {
+				return nil, errLoginLocked
+			}
+
+			client, err := h.GetClient(cluster)
+			if err != nil {
+				return nil, err
+			}
+
+			token, _, err := client.ACLAuth().Login(&api.ACLLoginRequest{
+				AuthMethodName: req.AuthMethodName,
+				LoginToken:     req.JWT,
+			}, (&api.WriteOptions{}).WithContext(ctx))
+
+			return token, err
+		})
+
+	resp := SSOResponse{Clusters: clusters, Registered: []VaultToken{}, Errors: map[string]string{}}
+	var added []vaultEntry
+	failures := map[string]error{}
+
+	for i, result := range results {
+		if result.Err != nil {
+			resp.Errors[clusters[i]] = result.Err.Error()
+			failures[clusters[i]] = result.Err
+			continue
+		}
+
+		entry := newVaultEntry(clusters[i], result.Value)
+		added = append(added, entry)
+		resp.Registered = append(resp.Registered, entry.VaultToken)
+	}
+
+	h.loginsDone(r, failures, len(added) > 0)
+
+	if err := h.addToVault(w, r, added); err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	if len(resp.Errors) == 0 {
+		resp.Errors = nil
+	}
+
+	writeJSON(w, resp)
+}
+
+func newVaultEntry(cluster string, token *api.ACLToken) vaultEntry {
+	name := token.Name
+	if name == "" {
+		name = token.AccessorID
+	}
+
+	return vaultEntry{
+		VaultToken: VaultToken{Cluster: cluster, AccessorID: token.AccessorID, Name: name, AddedAt: time.Now().UTC()},
+		SecretID:   token.SecretID,
+	}
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,106 @@
+package nomad
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+const (
+	// defaultPageSize is the page size of store listings without a limit
+	defaultPageSize = 100
+	// maxPageSize caps the limit of store listings
+	maxPageSize = 1000
+)
+
+// listFields are the fields a store listing filters and sorts by, by their
+// query parameter name
+type listFields[T any] struct {
+	// filter are the fields matched exactly, such as ?cluster=prod
+	filter map[string]func(*T) string
+	// sort are the fields of ?sort=, besides the time
+	sort map[string]func(a, b *T) bool
+}
+
+// listQuery reads the query of a listing of a time-keyed bucket:
+//
+//	from, to   time range, RFC 3339, to excluded
+//	order      asc or desc, newest first by default
+//	sort       a field to sort by instead of the time
+//	cursor     the next of the previous page
+//	limit      the page size, up to maxPageSize
+//
+// plus the filter fields.
+func listQuery[T any](r *http.Request, fields listFields[T]) (store.Query[T], error) {
+	params := r.URL.Query()
+	q := store.Query[T]{Cursor: params.Get("cursor"), Limit: defaultPageSize, Descending: true}
+
+	if s := params.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q", s)
+		}
+		q.Limit = min(limit, maxPageSize)
+	}
+
+	switch order := params.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		q.Descending = false
+	default:
+		return q, fmt.Errorf("order must be asc or desc, not %q", order)
+	}
+
+	for name, key := range map[string]*string{"from": &q.Start, "to": &q.End} {
+		if s := params.Get(name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s %q: %w", name, s, err)
+			}
+			*key = timeKey(t)
+		}
+	}
+
+	if field := params.Get("sort"); field != "" && field != "time" {
+		less, ok := fields.sort[field]
+		if !ok {
+			names := []string{"time"}
+			for name := range fields.sort {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return q, fmt.Errorf("sort must be one of %s", strings.Join(names, ", "))
+		}
+		q.Less = less
+	}
+
+	matches := map[string]string{}
+	for name := range fields.filter {
+		if value := params.Get(name); value != "" {
+			matches[name] = value
+		}
+	}
+	if len(matches) > 0 {
+		q.Filter = func(v *T) bool {
+			for name, value := range matches {
+				if fields.filter[name](v) != value {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	return q, nil
+}
+
+// timeKey is the key prefix of an entry of a time-keyed bucket, which sorts
+// like the times
+func timeKey(t time.Time) string {
+	return fmt.Sprintf("%020d", t.UnixNano())
+}
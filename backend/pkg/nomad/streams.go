@@ -0,0 +1,240 @@
+package nomad
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/streams"
+)
+
+// Streams returns the registry of the open streams, which the multiplexer
+// registers its subscriptions in as well
+func (h *Handler) Streams() *streams.Registry {
+	return h.streams
+}
+
+// StreamRegistryMiddleware registers the server-sent event streams and the
+// WebSockets served by next while they are open, counting the bytes they
+// send, so that they can be listed and closed through the admin API
+func (h *Handler) StreamRegistryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		tw := &trackedWriter{ResponseWriter: w, cancel: cancel}
+		tw.open = func(kind string) *streams.Stream {
+			cluster, _, _ := splitClusterPath(r.URL.Path)
+			token := ""
+			if cluster != "" {
+				token = getTokenForCluster(r, cluster)
+			}
+
+			return h.streams.Open(streams.Options{
+				Kind:    kind,
+				Cluster: cluster,
+				Token:   token,
+				Topic:   streamTopic(r.URL),
+				Close:   tw.close,
+			})
+		}
+		defer tw.done()
+
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			tw.start(streams.KindWebSocket)
+		}
+
+		next.ServeHTTP(tw, r.WithContext(ctx))
+	})
+}
+
+// streamTopic describes what a stream follows by its path and query,
+// leaving out the parameters that could carry secrets
+func streamTopic(u *url.URL) string {
+	query := u.Query()
+	for key := range query {
+		if strings.Contains(strings.ToLower(key), "token") {
+			query.Del(key)
+		}
+	}
+
+	if len(query) == 0 {
+		return u.Path
+	}
+
+	return u.Path + "?" + query.Encode()
+}
+
+// trackedWriter registers the response as a stream once it turns out to be
+// one, and counts what it sends
+type trackedWriter struct {
+	http.ResponseWriter
+	open   func(kind string) *streams.Stream
+	cancel context.CancelFunc
+
+	mutex   sync.Mutex
+	checked bool
+	stream  *streams.Stream
+	conn    net.Conn
+}
+
+func (tw *trackedWriter) start(kind string) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	tw.checked = true
+	tw.stream = tw.open(kind)
+}
+
+// check registers the response as an SSE stream when it is one
+func (tw *trackedWriter) check() {
+	tw.mutex.Lock()
+	checked := tw.checked
+	tw.checked = true
+	tw.mutex.Unlock()
+
+	if !checked && strings.HasPrefix(tw.Header().Get("Content-Type"), "text/event-stream") {
+		stream := tw.open(streams.KindSSE)
+
+		tw.mutex.Lock()
+		tw.stream = stream
+		tw.mutex.Unlock()
+	}
+}
+
+func (tw *trackedWriter) WriteHeader(status int) {
+	tw.check()
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *trackedWriter) Write(b []byte) (int, error) {
+	tw.check()
+	n, err := tw.ResponseWriter.Write(b)
+
+	tw.mutex.Lock()
+	stream := tw.stream
+	tw.mutex.Unlock()
+	stream.Sent(n)
+
+	return n, err
+}
+
+// Flush implements http.Flusher for streamed responses
+func (tw *trackedWriter) Flush() {
+	tw.check()
+	if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (tw *trackedWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// Hijack implements http.Hijacker for WebSockets, counting the bytes sent
+// on the connection
+func (tw *trackedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := tw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tw.mutex.Lock()
+	counted := &countingConn{Conn: conn, stream: tw.stream}
+	tw.conn = counted
+	tw.mutex.Unlock()
+
+	// The writer net/http returns is new and empty, so it can be replaced
+	return counted, bufio.NewReadWriter(rw.Reader, bufio.NewWriter(counted)), nil
+}
+
+// close ends the stream: the handler's context is cancelled, which ends
+// SSE streams, and a hijacked connection is closed
+func (tw *trackedWriter) close() {
+	tw.cancel()
+
+	tw.mutex.Lock()
+	conn := tw.conn
+	tw.mutex.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func (tw *trackedWriter) done() {
+	tw.mutex.Lock()
+	stream := tw.stream
+	tw.mutex.Unlock()
+
+	stream.Done()
+}
+
+// countingConn counts the bytes written to a hijacked connection
+type countingConn struct {
+	net.Conn
+	stream *streams.Stream
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stream.Sent(n)
+
+	return n, err
+}
+
+// ListStreams handles GET /api/admin/streams
+// It lists the open streams, oldest first, for the managers of any cluster.
+// The users of streams are found from their tokens.
+func (h *Handler) ListStreams(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireManagement(w, r, ""); !ok {
+		return
+	}
+
+	list := h.streams.List()
+
+	type tokenKey struct{ cluster, token string }
+	users := make(map[tokenKey]string)
+	for i, s := range list {
+		if s.User != "" || s.Cluster == "" || s.Token == "" {
+			continue
+		}
+
+		key := tokenKey{s.Cluster, s.Token}
+		user, ok := users[key]
+		if !ok {
+			if client, err := h.GetClientWithToken(s.Cluster, s.Token); err == nil {
+				_, user, _ = tokenIdentity(client)
+			}
+			users[key] = user
+		}
+		list[i].User = user
+	}
+
+	writeJSON(w, list)
+}
+
+// CloseStream handles DELETE /api/admin/streams/{id}
+func (h *Handler) CloseStream(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireManagement(w, r, ""); !ok {
+		return
+	}
+
+	if !h.streams.Close(r.PathValue("id")) {
+		writeError(w, r, errors.New("stream not found"), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
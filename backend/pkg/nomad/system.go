@@ -0,0 +1,58 @@
+package nomad
+
+import (
+	"net/http"
+)
+
+// ActionSystemReconcileSummaries is the audit action of a job summary reconciliation
+const ActionSystemReconcileSummaries = "system.reconcile-summaries"
+
+// GarbageCollect handles PUT /clusters/{cluster}/v1/system/gc. It forces a
+// garbage collection of the jobs, evaluations, allocations and nodes Nomad
+// no longer needs, which cannot be undone, so it takes an approval when the
+// two-person rule is on.
+func (h *Handler) GarbageCollect(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	h.runDestructive(w, r, client, ActionSystemGC, "", "")
+}
+
+// ReconcileSummaries handles PUT /clusters/{cluster}/v1/system/reconcile/summaries.
+// Nomad recomputes the summaries of all jobs from their allocations, which
+// fixes counts left wrong by lost updates.
+func (h *Handler) ReconcileSummaries(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	entry := AuditEntry{Cluster: clusterName, Action: ActionSystemReconcileSummaries}
+	if accessor, name, err := tokenIdentity(client); err == nil {
+		entry.Actor = name
+		entry.ActorAccessor = accessor
+	}
+
+	err = client.System().ReconcileSummaries()
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	h.audit(r.Context(), entry)
+
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	writeJSON(w, map[string]string{"status": "reconciled"})
+}
@@ -0,0 +1,198 @@
+package nomad
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/fanout"
+)
+
+// SystemJobCoverage tells which of the nodes a system or sysbatch job should
+// run on have an allocation of it. Nodes are eligible when they are ready,
+// eligible for scheduling, not draining and in the job's datacenters and
+// node pool; the job's constraints are not evaluated, so nodes they exclude
+// show up as missing.
+type SystemJobCoverage struct {
+	JobID         string         `json:"jobId"`
+	Namespace     string         `json:"namespace"`
+	Type          string         `json:"type"`
+	EligibleNodes int            `json:"eligibleNodes"`
+	CoveredNodes  int            `json:"coveredNodes"`
+	Missing       []NodeCoverage `json:"missing"`
+	Covered       []NodeCoverage `json:"covered"`
+	// Ineligible are the nodes of the job's datacenters and pool that cannot
+	// be scheduled on at the moment
+	Ineligible []NodeCoverage `json:"ineligible"`
+}
+
+// NodeCoverage is a node with its latest allocation of the job, if any
+type NodeCoverage struct {
+	NodeID      string `json:"nodeId"`
+	Name        string `json:"name"`
+	Datacenter  string `json:"datacenter"`
+	NodePool    string `json:"nodePool"`
+	Status      string `json:"status"`
+	Eligibility string `json:"eligibility"`
+	Drain       bool   `json:"drain"`
+	AllocID     string `json:"allocId,omitempty"`
+	AllocStatus string `json:"allocStatus,omitempty"`
+}
+
+// GetSystemJobCoverage handles GET /clusters/{cluster}/v1/job/coverage?id=jobID
+func (h *Handler) GetSystemJobCoverage(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		writeError(w, r, fmt.Errorf("job id is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	opts := getQueryOptions(r)
+	job, _, err := client.Jobs().Info(jobID, opts)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	jobType := stringValue(job.Type)
+	if jobType != api.JobTypeSystem && jobType != api.JobTypeSysbatch {
+		writeError(w, r, fmt.Errorf("job %q is a %s job, not a system or sysbatch one", jobID, jobType),
+			http.StatusBadRequest)
+		return
+	}
+
+	var nodes []*api.NodeListStub
+	var allocs []*api.AllocationListStub
+
+	g := fanout.New(r.Context(), fanout.Options{Timeout: compositeCallTimeout})
+	g.Go("nodes", func(ctx context.Context) (err error) {
+		nodes, _, err = client.Nodes().List(getQueryOptions(r).WithContext(ctx))
+		return err
+	})
+	g.Go("allocations", func(ctx context.Context) (err error) {
+		allocs, _, err = client.Jobs().Allocations(jobID, false, opts.WithContext(ctx))
+		return err
+	})
+
+	errs := g.Wait()
+	for _, section := range []string{"nodes", "allocations"} {
+		if err := errs[section]; err != nil {
+			writeNomadError(w, r, err)
+			return
+		}
+	}
+
+	h.writeCompositeJSON(w, r, systemJobCoverage(job, nodes, allocs))
+}
+
+func systemJobCoverage(job *api.Job, nodes []*api.NodeListStub, allocs []*api.AllocationListStub) SystemJobCoverage {
+	coverage := SystemJobCoverage{
+		JobID:      *job.ID,
+		Namespace:  namespaceOrDefault(stringValue(job.Namespace)),
+		Type:       *job.Type,
+		Missing:    []NodeCoverage{},
+		Covered:    []NodeCoverage{},
+		Ineligible: []NodeCoverage{},
+	}
+
+	// The latest allocation of each node, running ones first: a node with a
+	// running allocation is covered whatever failed on it before
+	latest := map[string]*api.AllocationListStub{}
+	for _, alloc := range allocs {
+		prev, ok := latest[alloc.NodeID]
+		if !ok {
+			latest[alloc.NodeID] = alloc
+			continue
+		}
+
+		covers, prevCovers := coversNode(alloc, *job.Type), coversNode(prev, *job.Type)
+		if covers && !prevCovers || covers == prevCovers && alloc.CreateIndex > prev.CreateIndex {
+			latest[alloc.NodeID] = alloc
+		}
+	}
+
+	pool := stringValue(job.NodePool)
+	if pool == "" {
+		pool = api.NodePoolDefault
+	}
+
+	for _, node := range nodes {
+		if !matchesDatacenters(job.Datacenters, node.Datacenter) || pool != api.NodePoolAll && node.NodePool != pool {
+			continue
+		}
+
+		nc := NodeCoverage{
+			NodeID:      node.ID,
+			Name:        node.Name,
+			Datacenter:  node.Datacenter,
+			NodePool:    node.NodePool,
+			Status:      node.Status,
+			Eligibility: node.SchedulingEligibility,
+			Drain:       node.Drain,
+		}
+		alloc := latest[node.ID]
+		if alloc != nil {
+			nc.AllocID = alloc.ID
+			nc.AllocStatus = alloc.ClientStatus
+		}
+
+		switch {
+		case node.Status != api.NodeStatusReady || node.SchedulingEligibility != api.NodeSchedulingEligible ||
+			node.Drain:
+			coverage.Ineligible = append(coverage.Ineligible, nc)
+		case alloc != nil && coversNode(alloc, *job.Type):
+			coverage.Covered = append(coverage.Covered, nc)
+		default:
+			coverage.Missing = append(coverage.Missing, nc)
+		}
+	}
+
+	coverage.CoveredNodes = len(coverage.Covered)
+	coverage.EligibleNodes = len(coverage.Covered) + len(coverage.Missing)
+
+	for _, list := range [][]NodeCoverage{coverage.Missing, coverage.Covered, coverage.Ineligible} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+
+	return coverage
+}
+
+// coversNode reports whether an allocation does what the job should on its
+// node: run for system jobs, run or have completed for sysbatch ones
+func coversNode(alloc *api.AllocationListStub, jobType string) bool {
+	switch alloc.ClientStatus {
+	case api.AllocClientStatusRunning:
+		return true
+	case api.AllocClientStatusComplete:
+		return jobType == api.JobTypeSysbatch
+	}
+
+	return false
+}
+
+// matchesDatacenters reports whether a datacenter is one of a job's, which
+// may be glob patterns; jobs without any run in all datacenters
+func matchesDatacenters(patterns []string, datacenter string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, datacenter); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,69 @@
+package nomad
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/timestamps"
+)
+
+// timestampsRFC3339 is the value of ?timestamps= asking a composite endpoint
+// for RFC 3339 timestamps
+const timestampsRFC3339 = "rfc3339"
+
+// WithDisplayTimezone sets the time zone times are shown in by default. Nil
+// leaves it to each browser.
+func WithDisplayTimezone(loc *time.Location) Option {
+	return func(h *Handler) {
+		h.displayLocation = loc
+	}
+}
+
+// DisplayTimezone returns the name of the time zone times are shown in by
+// default, empty if it is left to each browser.
+func (h *Handler) DisplayTimezone() string {
+	if h.displayLocation == nil {
+		return ""
+	}
+
+	return h.displayLocation.String()
+}
+
+// writeCompositeJSON writes the response of a composite endpoint, which mixes
+// Nomad's nanosecond epochs with RFC 3339 times. With ?timestamps=rfc3339
+// all of them are formatted as RFC 3339 with an explicit offset, in the time
+// zone of ?tz=, or else the display time zone, or else UTC.
+func (h *Handler) writeCompositeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	query := r.URL.Query()
+
+	switch query.Get("timestamps") {
+	case "":
+		writeJSON(w, v)
+		return
+	case timestampsRFC3339:
+	default:
+		writeError(w, r, fmt.Errorf("timestamps must be %s or empty", timestampsRFC3339), http.StatusBadRequest)
+		return
+	}
+
+	loc := time.UTC
+	if h.displayLocation != nil {
+		loc = h.displayLocation
+	}
+	if tz := query.Get("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			writeError(w, r, fmt.Errorf("invalid time zone: %w", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	normalized, err := timestamps.Normalize(v, loc)
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, normalized)
+}
@@ -0,0 +1,118 @@
+package nomad
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+const (
+	// defaultStaleTokenAge is how long a token may go unused through Caravan
+	// before it is reported stale, unless the request says otherwise
+	defaultStaleTokenAge = 90 * 24 * time.Hour
+	// tokenActivityRecent is the number of most recent actions returned
+	tokenActivityRecent = 20
+)
+
+// TokenActivity is what Caravan's audit log knows about the use of a token.
+// Only actions taken through Caravan are seen, and only those recorded
+// with the token's accessor, so a token may be used elsewhere.
+type TokenActivity struct {
+	Token    *api.ACLToken `json:"token"`
+	LastUsed *time.Time    `json:"lastUsed,omitempty"`
+	Total    int           `json:"total"`
+	Failed   int           `json:"failed"`
+	// Actions counts the audited actions by their name
+	Actions map[string]int `json:"actions"`
+	// Recent are the most recent actions, newest first
+	Recent []AuditEntry `json:"recent"`
+	// Stale is set when neither the token's creation nor its last use is
+	// more recent than the stale age
+	Stale bool `json:"stale"`
+}
+
+// GetACLTokenActivity handles GET /clusters/{cluster}/v1/acl/token/{tokenID}/activity?staleAfter=2160h
+// Reading another token's metadata needs a management token, which also
+// keeps the audit log to admins.
+func (h *Handler) GetACLTokenActivity(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	tokenID := r.PathValue("tokenID")
+
+	staleAfter := defaultStaleTokenAge
+	if s := r.URL.Query().Get("staleAfter"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			writeError(w, r, fmt.Errorf("invalid staleAfter %q", s), http.StatusBadRequest)
+			return
+		}
+		staleAfter = d
+	}
+
+	client, err := h.GetClientWithToken(clusterName, getToken(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	aclToken, _, err := client.ACLTokens().Info(tokenID, getQueryOptions(r))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	entries, err := store.ListJSON[AuditEntry](r.Context(), h.store, auditBucket, "")
+	if err != nil {
+		writeError(w, r, fmt.Errorf("reading audit log: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, summarizeTokenActivity(aclToken, clusterName, entries, staleAfter, time.Now()))
+}
+
+func summarizeTokenActivity(token *api.ACLToken, cluster string, entries []AuditEntry,
+	staleAfter time.Duration, now time.Time,
+) TokenActivity {
+	activity := TokenActivity{
+		Token:   token,
+		Actions: map[string]int{},
+		Recent:  []AuditEntry{},
+	}
+
+	for _, e := range entries {
+		if e.Cluster != cluster || e.ActorAccessor != token.AccessorID {
+			continue
+		}
+
+		activity.Total++
+		activity.Actions[e.Action]++
+		if e.Error != "" {
+			activity.Failed++
+		}
+
+		if activity.LastUsed == nil || e.Time.After(*activity.LastUsed) {
+			t := e.Time
+			activity.LastUsed = &t
+		}
+
+		activity.Recent = append(activity.Recent, e)
+	}
+
+	sort.Slice(activity.Recent, func(i, j int) bool {
+		return activity.Recent[i].Time.After(activity.Recent[j].Time)
+	})
+	if len(activity.Recent) > tokenActivityRecent {
+		activity.Recent = activity.Recent[:tokenActivityRecent]
+	}
+
+	lastSeen := token.CreateTime
+	if activity.LastUsed != nil && activity.LastUsed.After(lastSeen) {
+		lastSeen = *activity.LastUsed
+	}
+	activity.Stale = now.Sub(lastSeen) > staleAfter
+
+	return activity
+}
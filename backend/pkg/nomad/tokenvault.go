@@ -0,0 +1,404 @@
+package nomad
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/auth"
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+const (
+	// tokenVaultBucket holds one encrypted vault per browser
+	tokenVaultBucket = "token-vault"
+	// vaultCookie identifies a vault and carries the key it is encrypted with
+	vaultCookie = "caravan-vault"
+	// vaultCookieMaxAge is how long a browser keeps its vault
+	vaultCookieMaxAge = 30 * 24 * time.Hour
+)
+
+// VaultToken is a token registered in the vault, without its secret
+type VaultToken struct {
+	Cluster    string    `json:"cluster"`
+	AccessorID string    `json:"accessorId"`
+	Name       string    `json:"name"`
+	AddedAt    time.Time `json:"addedAt"`
+}
+
+// vaultEntry is a token as stored, encrypted, in the vault
+type vaultEntry struct {
+	VaultToken
+	SecretID string `json:"secretId"`
+}
+
+// storedVault is a vault as written to the store
+type storedVault struct {
+	// Data is the JSON list of entries, sealed with AES-GCM
+	Data      []byte    `json:"data"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// RegisterTokenRequest is the body of PUT /api/tokens
+type RegisterTokenRequest struct {
+	Token string `json:"token"`
+	// Clusters the token is for, at least one. The secret is only sent to
+	// those, so that no other cluster learns it.
+	Clusters []string `json:"clusters"`
+}
+
+// RegisterTokenResponse lists the clusters a token was registered for and
+// why it was not for the others
+type RegisterTokenResponse struct {
+	Registered []VaultToken      `json:"registered"`
+	Errors     map[string]string `json:"errors,omitempty"`
+}
+
+type vaultContextKey struct{}
+
+// vaultKey is a vault's ID and encryption key, as held by the browser. The
+// store only ever sees the sealed vault, so it cannot reveal the tokens.
+type vaultKey struct {
+	id  string
+	key []byte
+}
+
+func newVaultKey() (vaultKey, error) {
+	id := make([]byte, 16)
+	key := make([]byte, 32)
+
+	if _, err := rand.Read(id); err != nil {
+		return vaultKey{}, err
+	}
+	if _, err := rand.Read(key); err != nil {
+		return vaultKey{}, err
+	}
+
+	return vaultKey{id: hex.EncodeToString(id), key: key}, nil
+}
+
+func (k vaultKey) String() string {
+	return k.id + "." + base64.RawURLEncoding.EncodeToString(k.key)
+}
+
+func parseVaultKey(s string) (vaultKey, bool) {
+	id, encoded, ok := strings.Cut(s, ".")
+	if !ok || id == "" {
+		return vaultKey{}, false
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return vaultKey{}, false
+	}
+
+	return vaultKey{id: id, key: key}, true
+}
+
+func vaultKeyFromRequest(r *http.Request) (vaultKey, bool) {
+	cookie, err := r.Cookie(vaultCookie)
+	if err != nil {
+		return vaultKey{}, false
+	}
+
+	return parseVaultKey(cookie.Value)
+}
+
+func (k vaultKey) seal(entries []vaultEntry) ([]byte, error) {
+	plaintext, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := k.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// The vault ID is authenticated too, so a vault cannot be swapped for another
+	return gcm.Seal(nonce, nonce, plaintext, []byte(k.id)), nil
+}
+
+func (k vaultKey) open(data []byte) ([]vaultEntry, error) {
+	gcm, err := k.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("sealed vault is too short")
+	}
+
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(k.id))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []vaultEntry
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (k vaultKey) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// loadVault reads and opens a vault. A vault that does not exist is empty.
+func (h *Handler) loadVault(ctx context.Context, k vaultKey) ([]vaultEntry, error) {
+	var stored storedVault
+	err := store.GetJSON(ctx, h.store, tokenVaultBucket, k.id, &stored)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return k.open(stored.Data)
+}
+
+func (h *Handler) saveVault(ctx context.Context, k vaultKey, entries []vaultEntry) error {
+	if len(entries) == 0 {
+		return h.store.Delete(ctx, tokenVaultBucket, k.id)
+	}
+
+	data, err := k.seal(entries)
+	if err != nil {
+		return err
+	}
+
+	return store.PutJSON(ctx, h.store, tokenVaultBucket, k.id, storedVault{Data: data, UpdatedAt: time.Now().UTC()})
+}
+
+// TokenVaultMiddleware opens the vault of the request's browser, so that
+// getToken falls back to its tokens for clusters the request brings no token
+// for. A vault that cannot be opened is ignored.
+func (h *Handler) TokenVaultMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k, ok := vaultKeyFromRequest(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		entries, err := h.loadVault(r.Context(), k)
+		if err != nil || len(entries) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tokens := make(map[string]string, len(entries))
+		for _, e := range entries {
+			tokens[e.Cluster] = e.SecretID
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), vaultContextKey{}, tokens)))
+	})
+}
+
+// vaultToken returns the vault's token for a cluster, "" if there is none
+func vaultToken(ctx context.Context, cluster string) string {
+	tokens, _ := ctx.Value(vaultContextKey{}).(map[string]string)
+	return tokens[cluster]
+}
+
+// setVaultCookie sets the vault cookie, or with a negative maxAge clears it
+func setVaultCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, auth.NewCookie(r, vaultCookie, value, "/", maxAge))
+}
+
+// ListVaultTokens handles GET /api/tokens
+// Only the clusters and token names are returned, never the secrets.
+func (h *Handler) ListVaultTokens(w http.ResponseWriter, r *http.Request) {
+	tokens := []VaultToken{}
+
+	if k, ok := vaultKeyFromRequest(r); ok {
+		entries, err := h.loadVault(r.Context(), k)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("opening token vault: %w", err), http.StatusInternalServerError)
+			return
+		}
+
+		for _, e := range entries {
+			tokens = append(tokens, e.VaultToken)
+		}
+	}
+
+	writeJSON(w, tokens)
+}
+
+// RegisterVaultToken handles PUT /api/tokens
+// The token is checked against each of the clusters it is for before it is
+// kept. The vault is created, and its cookie set, on the first registration.
+func (h *Handler) RegisterVaultToken(w http.ResponseWriter, r *http.Request) {
+	var req RegisterTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" {
+		writeError(w, r, errors.New("token is required"), http.StatusBadRequest)
+		return
+	}
+
+	// Trying the secret on every cluster would hand it to clusters the
+	// user never meant it for
+	if len(req.Clusters) == 0 {
+		writeError(w, r, errors.New("clusters is required"), http.StatusBadRequest)
+		return
+	}
+	clusters := req.Clusters
+
+	resp := RegisterTokenResponse{Registered: []VaultToken{}, Errors: map[string]string{}}
+	var added []vaultEntry
+	failures := map[string]error{}
+
+	for _, cluster := range clusters {
+		if h.logins.retryAfter(r, cluster) > 0 {
+			resp.Errors[cluster] = errLoginLocked.Error()
+			continue
+		}
+
+		client, err := h.GetClientWithToken(cluster, req.Token)
+		if err == nil {
+			var accessor, name string
+			accessor, name, err = tokenIdentity(client)
+			if err == nil {
+				entry := vaultEntry{
+					VaultToken: VaultToken{Cluster: cluster, AccessorID: accessor, Name: name, AddedAt: time.Now().UTC()},
+					SecretID:   req.Token,
+				}
+				added = append(added, entry)
+				resp.Registered = append(resp.Registered, entry.VaultToken)
+				continue
+			}
+			failures[cluster] = err
+		}
+
+		resp.Errors[cluster] = err.Error()
+	}
+
+	h.loginsDone(r, failures, len(added) > 0)
+
+	if err := h.addToVault(w, r, added); err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, resp)
+}
+
+// addToVault adds tokens to the vault of the request's browser, replacing
+// those for the same clusters. The vault and its cookie are created if the
+// browser has none yet.
+func (h *Handler) addToVault(w http.ResponseWriter, r *http.Request, added []vaultEntry) error {
+	if len(added) == 0 {
+		return nil
+	}
+
+	k, ok := vaultKeyFromRequest(r)
+	if !ok {
+		var err error
+		if k, err = newVaultKey(); err != nil {
+			return fmt.Errorf("creating token vault: %w", err)
+		}
+	}
+
+	h.vaultMutex.Lock()
+	defer h.vaultMutex.Unlock()
+
+	entries, err := h.loadVault(r.Context(), k)
+	if err != nil {
+		return fmt.Errorf("opening token vault: %w", err)
+	}
+
+	for _, entry := range added {
+		entries = removeVaultEntry(entries, entry.Cluster)
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Cluster < entries[j].Cluster })
+
+	if err := h.saveVault(r.Context(), k, entries); err != nil {
+		return fmt.Errorf("storing token vault: %w", err)
+	}
+
+	setVaultCookie(w, r, k.String(), int(vaultCookieMaxAge.Seconds()))
+
+	return nil
+}
+
+// DeleteVaultToken handles DELETE /api/tokens/{cluster}
+func (h *Handler) DeleteVaultToken(w http.ResponseWriter, r *http.Request) {
+	cluster := r.PathValue("cluster")
+
+	k, ok := vaultKeyFromRequest(r)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	h.vaultMutex.Lock()
+	defer h.vaultMutex.Unlock()
+
+	entries, err := h.loadVault(r.Context(), k)
+	if err != nil {
+		writeError(w, r, fmt.Errorf("opening token vault: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.saveVault(r.Context(), k, removeVaultEntry(entries, cluster)); err != nil {
+		writeError(w, r, fmt.Errorf("storing token vault: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteVault handles DELETE /api/tokens
+// It forgets every token of the vault and the vault itself.
+func (h *Handler) DeleteVault(w http.ResponseWriter, r *http.Request) {
+	if k, ok := vaultKeyFromRequest(r); ok {
+		if err := h.store.Delete(r.Context(), tokenVaultBucket, k.id); err != nil {
+			writeError(w, r, fmt.Errorf("deleting token vault: %w", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	setVaultCookie(w, r, "", -1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func removeVaultEntry(entries []vaultEntry, cluster string) []vaultEntry {
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Cluster != cluster {
+			kept = append(kept, e)
+		}
+	}
+
+	return kept
+}
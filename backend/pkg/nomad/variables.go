@@ -15,18 +15,22 @@ func (h *Handler) ListVariables(w http.ResponseWriter, r *http.Request) {
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	opts := getQueryOptions(r)
-	vars, _, err := client.Variables().List(opts)
+	opts, err := h.listOptions(r)
+	if err != nil {
+		writeError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	vars, meta, err := client.Variables().List(opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
-	writeJSON(w, vars)
+	writeList(h, w, r, opts, vars, meta)
 }
 
 // GetVariable handles GET /clusters/{cluster}/v1/var?path=my/var/path
@@ -35,20 +39,20 @@ func (h *Handler) GetVariable(w http.ResponseWriter, r *http.Request) {
 	token := getToken(r)
 	path := r.URL.Query().Get("path")
 	if path == "" {
-		writeError(w, fmt.Errorf("path is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("path is required"), http.StatusBadRequest)
 		return
 	}
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getQueryOptions(r)
 	variable, _, err := client.Variables().Read(path, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -61,13 +65,13 @@ func (h *Handler) PutVariable(w http.ResponseWriter, r *http.Request) {
 	token := getToken(r)
 	path := r.URL.Query().Get("path")
 	if path == "" {
-		writeError(w, fmt.Errorf("path is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("path is required"), http.StatusBadRequest)
 		return
 	}
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -76,7 +80,7 @@ func (h *Handler) PutVariable(w http.ResponseWriter, r *http.Request) {
 		Namespace string            `json:"namespace"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&varReq); err != nil {
-		writeError(w, err, http.StatusBadRequest)
+		writeError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
@@ -93,7 +97,7 @@ func (h *Handler) PutVariable(w http.ResponseWriter, r *http.Request) {
 
 	resp, _, err := client.Variables().Create(variable, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
@@ -106,20 +110,20 @@ func (h *Handler) DeleteVariable(w http.ResponseWriter, r *http.Request) {
 	token := getToken(r)
 	path := r.URL.Query().Get("path")
 	if path == "" {
-		writeError(w, fmt.Errorf("path is required"), http.StatusBadRequest)
+		writeError(w, r, fmt.Errorf("path is required"), http.StatusBadRequest)
 		return
 	}
 
 	client, err := h.GetClientWithToken(clusterName, token)
 	if err != nil {
-		writeError(w, err, http.StatusInternalServerError)
+		writeNomadError(w, r, err)
 		return
 	}
 
 	opts := getWriteOptions(r)
 	_, err = client.Variables().Delete(path, opts)
 	if err != nil {
-		writeNomadError(w, err)
+		writeNomadError(w, r, err)
 		return
 	}
 
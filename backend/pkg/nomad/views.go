@@ -0,0 +1,281 @@
+package nomad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+)
+
+// viewsBucket holds one SavedView per saved search
+const viewsBucket = "views"
+
+// SavedView is a saved set of filters on a page of a cluster, such as the
+// dead batch jobs of the production cluster. Views belong to the user who
+// saved them, known by their token on the view's cluster, and are visible
+// to everyone once shared.
+type SavedView struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Cluster     string `json:"cluster"`
+	Namespace   string `json:"namespace,omitempty"`
+	// Page is the list the filters apply to, such as "jobs" or "allocations"
+	Page string `json:"page"`
+	// Filters are the page's filters, such as {"status": "dead", "type": "batch"}
+	Filters map[string]string `json:"filters,omitempty"`
+	Shared  bool              `json:"shared"`
+	Owner   string            `json:"owner"`
+	// OwnerAccessor identifies the owner's token; only the owner may change the view
+	OwnerAccessor string    `json:"ownerAccessor"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// SavedViewRequest is the body of POST /api/views and PUT /api/views/{id}
+type SavedViewRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Cluster     string            `json:"cluster"`
+	Namespace   string            `json:"namespace,omitempty"`
+	Page        string            `json:"page"`
+	Filters     map[string]string `json:"filters,omitempty"`
+	Shared      bool              `json:"shared"`
+}
+
+// ListViews handles GET /api/views?cluster=. It returns the shared views and
+// the caller's own, by name.
+func (h *Handler) ListViews(w http.ResponseWriter, r *http.Request) {
+	views, err := store.ListJSON[SavedView](r.Context(), h.store, viewsBucket, "")
+	if err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	cluster := r.URL.Query().Get("cluster")
+	accessors := map[string]string{}
+	visible := []SavedView{}
+
+	for _, v := range views {
+		if cluster != "" && v.Cluster != cluster {
+			continue
+		}
+
+		if !v.Shared {
+			accessor, ok := accessors[v.Cluster]
+			if !ok {
+				accessor, _ = h.viewerAccessor(r, v.Cluster)
+				accessors[v.Cluster] = accessor
+			}
+			if accessor == "" || accessor != v.OwnerAccessor {
+				continue
+			}
+		}
+
+		visible = append(visible, v)
+	}
+
+	sort.Slice(visible, func(i, j int) bool {
+		return strings.ToLower(visible[i].Name) < strings.ToLower(visible[j].Name)
+	})
+
+	writeJSON(w, visible)
+}
+
+// GetView handles GET /api/views/{id}
+func (h *Handler) GetView(w http.ResponseWriter, r *http.Request) {
+	view, err := h.getView(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeViewError(w, r, err)
+		return
+	}
+
+	if !view.Shared {
+		if accessor, _ := h.viewerAccessor(r, view.Cluster); accessor != view.OwnerAccessor {
+			writeViewError(w, r, store.ErrNotFound)
+			return
+		}
+	}
+
+	writeJSON(w, view)
+}
+
+// CreateView handles POST /api/views
+func (h *Handler) CreateView(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeViewRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if !h.configStore.HasContext(req.Cluster) {
+		writeError(w, r, fmt.Errorf("cluster %q not found", req.Cluster), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(req.Cluster, getTokenForCluster(r, req.Cluster))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	accessor, name, err := tokenIdentity(client)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	view := SavedView{
+		ID:            newID(),
+		Owner:         name,
+		OwnerAccessor: accessor,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	req.apply(&view)
+
+	if err := store.PutJSON(r.Context(), h.store, viewsBucket, view.ID, view); err != nil {
+		writeError(w, r, fmt.Errorf("saving the view: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(view)
+}
+
+// UpdateView handles PUT /api/views/{id}. Only the owner may change a view,
+// shared or not, and it stays on its cluster.
+func (h *Handler) UpdateView(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeViewRequest(w, r)
+	if !ok {
+		return
+	}
+
+	view, ok := h.ownView(w, r)
+	if !ok {
+		return
+	}
+
+	if req.Cluster != view.Cluster {
+		writeError(w, r, errors.New("a view cannot move to another cluster"), http.StatusBadRequest)
+		return
+	}
+
+	req.apply(view)
+	view.UpdatedAt = time.Now().UTC()
+
+	if err := store.PutJSON(r.Context(), h.store, viewsBucket, view.ID, view); err != nil {
+		writeError(w, r, fmt.Errorf("saving the view: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, view)
+}
+
+// DeleteView handles DELETE /api/views/{id}
+func (h *Handler) DeleteView(w http.ResponseWriter, r *http.Request) {
+	view, ok := h.ownView(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.store.Delete(r.Context(), viewsBucket, view.ID); err != nil {
+		writeError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownView reads the view of the request, failing unless the caller owns it
+func (h *Handler) ownView(w http.ResponseWriter, r *http.Request) (*SavedView, bool) {
+	view, err := h.getView(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeViewError(w, r, err)
+		return nil, false
+	}
+
+	accessor, err := h.viewerAccessor(r, view.Cluster)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return nil, false
+	}
+
+	if accessor != view.OwnerAccessor {
+		if !view.Shared {
+			writeViewError(w, r, store.ErrNotFound)
+			return nil, false
+		}
+		writeError(w, r, errors.New("only the owner of a view can change it"), http.StatusForbidden)
+		return nil, false
+	}
+
+	return view, true
+}
+
+// viewerAccessor identifies the caller by their token on cluster
+func (h *Handler) viewerAccessor(r *http.Request, cluster string) (string, error) {
+	client, err := h.GetClientWithToken(cluster, getTokenForCluster(r, cluster))
+	if err != nil {
+		return "", err
+	}
+
+	accessor, _, err := tokenIdentity(client)
+
+	return accessor, err
+}
+
+func (h *Handler) getView(ctx context.Context, id string) (*SavedView, error) {
+	var view SavedView
+	if err := store.GetJSON(ctx, h.store, viewsBucket, id, &view); err != nil {
+		return nil, err
+	}
+
+	return &view, nil
+}
+
+func decodeViewRequest(w http.ResponseWriter, r *http.Request) (*SavedViewRequest, bool) {
+	var req SavedViewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, fmt.Errorf("invalid request body: %w", err), http.StatusBadRequest)
+		return nil, false
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	for _, f := range []struct{ name, value string }{
+		{"name", req.Name}, {"cluster", req.Cluster}, {"page", req.Page},
+	} {
+		if f.value == "" {
+			writeError(w, r, errMissingField(f.name), http.StatusBadRequest)
+			return nil, false
+		}
+	}
+
+	return &req, true
+}
+
+func (req *SavedViewRequest) apply(view *SavedView) {
+	view.Name = req.Name
+	view.Description = req.Description
+	view.Cluster = req.Cluster
+	view.Namespace = req.Namespace
+	view.Page = req.Page
+	view.Filters = req.Filters
+	view.Shared = req.Shared
+}
+
+func writeViewError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, r, errors.New("view not found"), http.StatusNotFound)
+		return
+	}
+
+	writeError(w, r, err, http.StatusInternalServerError)
+}
@@ -0,0 +1,180 @@
+package nomad
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/jsonpatch"
+)
+
+// watchWait is how long each blocking query of a watch waits for a change
+const watchWait = time.Minute
+
+// watchFetch reads a watched object with q, returning its modify index
+type watchFetch func(client NomadAPI, id string, q *api.QueryOptions) (any, uint64, *api.QueryMeta, error)
+
+// watchTypes are the objects that can be watched, by the type parameter
+var watchTypes = map[string]watchFetch{
+	"job": func(client NomadAPI, id string, q *api.QueryOptions) (any, uint64, *api.QueryMeta, error) {
+		job, meta, err := client.Jobs().Info(id, q)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		var index uint64
+		if job.ModifyIndex != nil {
+			index = *job.ModifyIndex
+		}
+		return job, index, meta, nil
+	},
+	"allocation": func(client NomadAPI, id string, q *api.QueryOptions) (any, uint64, *api.QueryMeta, error) {
+		alloc, meta, err := client.Allocations().Info(id, q)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return alloc, alloc.ModifyIndex, meta, nil
+	},
+	"node": func(client NomadAPI, id string, q *api.QueryOptions) (any, uint64, *api.QueryMeta, error) {
+		node, meta, err := client.Nodes().Info(id, q)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return node, node.ModifyIndex, meta, nil
+	},
+	"deployment": func(client NomadAPI, id string, q *api.QueryOptions) (any, uint64, *api.QueryMeta, error) {
+		deployment, meta, err := client.Deployments().Info(id, q)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return deployment, deployment.ModifyIndex, meta, nil
+	},
+	"evaluation": func(client NomadAPI, id string, q *api.QueryOptions) (any, uint64, *api.QueryMeta, error) {
+		eval, meta, err := client.Evaluations().Info(id, q)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return eval, eval.ModifyIndex, meta, nil
+	},
+}
+
+// Watch handles GET /clusters/{cluster}/v1/watch?type=job&id=jobID
+//
+// It follows one job, allocation, node, deployment or evaluation with
+// blocking queries, and sends an "update" event with the object when the
+// stream starts and each time its ModifyIndex changes. Events carry the
+// ModifyIndex as their ID, so a reconnecting EventSource skips the object it
+// already has. A "deleted" event ends the stream when the object is gone.
+//
+// With ?patches=true, changes after the first update are sent as "patch"
+// events with the RFC 6902 patch from the previous object, when the patch is
+// smaller than the object.
+func (h *Handler) Watch(w http.ResponseWriter, r *http.Request) {
+	clusterName := getClusterName(r)
+	token := getToken(r)
+	query := r.URL.Query()
+
+	patches := query.Get("patches") == "true"
+
+	fetch, ok := watchTypes[query.Get("type")]
+	if !ok {
+		writeError(w, r, fmt.Errorf("type must be job, allocation, node, deployment or evaluation"), http.StatusBadRequest)
+		return
+	}
+	id := query.Get("id")
+	if id == "" {
+		writeError(w, r, fmt.Errorf("id is required"), http.StatusBadRequest)
+		return
+	}
+
+	client, err := h.GetClientWithToken(clusterName, token)
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	obj, index, meta, err := fetch(client, id, getQueryOptions(r).WithContext(r.Context()))
+	if err != nil {
+		writeNomadError(w, r, err)
+		return
+	}
+
+	sse, ok := h.newSSEWriter(w, r, "watch")
+	if !ok {
+		return
+	}
+	defer sse.Close()
+
+	send := func(event string, id uint64, v any) error {
+		data, _ := json.Marshal(v)
+		return sse.Send(event, strconv.FormatUint(id, 10), data)
+	}
+
+	// Sending the headers tells the browser the stream is open
+	if sse.Flush() != nil {
+		return
+	}
+
+	// sent is the last object sent, to patch from
+	var sent any
+	update := func(index uint64, obj any) error {
+		if patches && sent != nil {
+			if patch, ok := smallerPatch(sent, obj); ok {
+				sent = obj
+				return send("patch", index, patch)
+			}
+		}
+		sent = obj
+		return send("update", index, obj)
+	}
+
+	last, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	for {
+		if index != last {
+			if update(index, obj) != nil {
+				return
+			}
+			last = index
+		}
+
+		opts := getQueryOptions(r)
+		opts.WaitIndex = meta.LastIndex
+		opts.WaitTime = watchWait
+
+		var next *api.QueryMeta
+		obj, index, next, err = fetch(client, id, opts.WithContext(r.Context()))
+		switch {
+		case r.Context().Err() != nil:
+			return
+		case err != nil && classifyNomadError(err).Status() == http.StatusNotFound:
+			send("deleted", last, map[string]string{"type": query.Get("type"), "id": id})
+			return
+		case err != nil:
+			sse.Error(err)
+			return
+		}
+		meta = next
+	}
+}
+
+// smallerPatch returns the JSON patch from one object to the next, if it is
+// smaller than the next object
+func smallerPatch(from, to any) (json.RawMessage, bool) {
+	patch, err := jsonpatch.Diff(from, to)
+	if err != nil {
+		return nil, false
+	}
+
+	patchData, err := json.Marshal(patch)
+	if err != nil {
+		return nil, false
+	}
+	objData, err := json.Marshal(to)
+	if err != nil || len(patchData) >= len(objData) {
+		return nil, false
+	}
+
+	return patchData, true
+}
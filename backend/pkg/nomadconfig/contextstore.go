@@ -1,10 +1,17 @@
 package nomadconfig
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/logger"
 )
 
+// ErrContextNotFound is returned when a context with the requested name does not exist.
+var ErrContextNotFound = errors.New("context not found")
+
 // ContextStore is an interface for managing Nomad contexts
 type ContextStore interface {
 	// AddContext adds a context to the store
@@ -57,7 +64,7 @@ func (s *InMemoryContextStore) GetContext(name string) (*Context, error) {
 
 	ctx, exists := s.contexts[name]
 	if !exists {
-		return nil, errors.New("context not found: " + name)
+		return nil, fmt.Errorf("%w: %s", ErrContextNotFound, name)
 	}
 	return ctx, nil
 }
@@ -80,7 +87,7 @@ func (s *InMemoryContextStore) RemoveContext(name string) error {
 	defer s.mutex.Unlock()
 
 	if _, exists := s.contexts[name]; !exists {
-		return errors.New("context not found: " + name)
+		return fmt.Errorf("%w: %s", ErrContextNotFound, name)
 	}
 
 	delete(s.contexts, name)
@@ -100,7 +107,7 @@ func (s *InMemoryContextStore) UpdateContext(ctx *Context) error {
 	defer s.mutex.Unlock()
 
 	if _, exists := s.contexts[ctx.Name]; !exists {
-		return errors.New("context not found: " + ctx.Name)
+		return fmt.Errorf("%w: %s", ErrContextNotFound, ctx.Name)
 	}
 
 	s.contexts[ctx.Name] = ctx
@@ -132,6 +139,11 @@ func LoadAndStoreContexts(store ContextStore) error {
 
 	var errs []error
 	for _, ctx := range contexts {
+		// Clusters without ACLs skip the login screen
+		if err := ctx.ProbeACL(context.Background()); err != nil {
+			logger.Log(logger.LevelWarn, map[string]string{"cluster": ctx.Name}, err, "probing ACLs")
+		}
+
 		if err := store.AddContext(ctx); err != nil {
 			errs = append(errs, err)
 		}
@@ -0,0 +1,186 @@
+package nomadconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/telemetry"
+)
+
+// RequestLimit is the maximum number of concurrent requests Caravan sends to
+// each cluster; further requests queue until a slot frees up. 0 means no limit.
+var RequestLimit = 0
+
+var (
+	limiters      = make(map[string]*Limiter)
+	limitersMutex sync.Mutex
+)
+
+// Limiter bounds the concurrent requests to one cluster. It is shared by all
+// clients and the proxy of a cluster, whatever token they use.
+type Limiter struct {
+	cluster  string
+	slots    chan struct{}
+	inFlight atomic.Int64
+	queued   atomic.Int64
+}
+
+// NewLimiter creates a limiter that admits max concurrent requests.
+func NewLimiter(cluster string, max int) *Limiter {
+	l := &Limiter{cluster: cluster, slots: make(chan struct{}, max)}
+
+	telemetry.RegisterUpstreamLimit(cluster, float64(max),
+		func() float64 { return float64(l.inFlight.Load()) },
+		func() float64 { return float64(l.queued.Load()) })
+
+	return l
+}
+
+// limiterFor returns the limiter of a cluster, nil if requests are not limited
+func limiterFor(cluster string) *Limiter {
+	if RequestLimit <= 0 {
+		return nil
+	}
+
+	limitersMutex.Lock()
+	defer limitersMutex.Unlock()
+
+	l, ok := limiters[cluster]
+	if !ok {
+		l = NewLimiter(cluster, RequestLimit)
+		limiters[cluster] = l
+	}
+
+	return l
+}
+
+// Acquire waits for a free slot, or until ctx is done.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		l.inFlight.Add(1)
+		return nil
+	default:
+	}
+
+	telemetry.RecordUpstreamQueued(l.cluster)
+	l.queued.Add(1)
+	defer l.queued.Add(-1)
+
+	select {
+	case l.slots <- struct{}{}:
+		l.inFlight.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire.
+func (l *Limiter) Release() {
+	l.inFlight.Add(-1)
+	<-l.slots
+}
+
+// RoundTripper limits the requests sent through base. A slot is held until
+// the response body is closed or read to the end.
+func (l *Limiter) RoundTripper(base http.RoundTripper) http.RoundTripper {
+	if l == nil {
+		return base
+	}
+
+	return &limitedRoundTripper{base: base, limiter: l}
+}
+
+type limitedRoundTripper struct {
+	base    http.RoundTripper
+	limiter *Limiter
+}
+
+func (rt *limitedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isLongLived(req) {
+		return rt.base.RoundTrip(req)
+	}
+
+	if err := rt.limiter.Acquire(req.Context()); err != nil {
+		return nil, err
+	}
+
+	resp, err := rt.base.RoundTrip(req)
+	if err != nil {
+		rt.limiter.Release()
+		return nil, err
+	}
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: rt.limiter.Release}
+
+	return resp, nil
+}
+
+// isLongLived reports whether a request stays open for long without loading
+// the servers: streams, exec sessions and blocking queries. Counting them
+// would starve the limit.
+func isLongLived(req *http.Request) bool {
+	path := req.URL.Path
+
+	switch {
+	case strings.HasPrefix(path, "/v1/event/stream"),
+		strings.HasPrefix(path, "/v1/client/fs/logs/"),
+		strings.HasPrefix(path, "/v1/client/fs/stream/"),
+		strings.HasSuffix(path, "/exec"):
+		return true
+	}
+
+	q := req.URL.Query()
+
+	return q.Get("follow") == "true" || (q.Get("index") != "" && q.Get("index") != "0")
+}
+
+// releasingBody releases the limiter slot of a response once, when the body
+// is closed or read to the end
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.release)
+	}
+
+	return n, err
+}
+
+func (b *releasingBody) Close() error {
+	b.once.Do(b.release)
+	return b.ReadCloser.Close()
+}
+
+// newHTTPClient returns an HTTP client for the SDK that applies the TLS
+// settings, shares the cluster's request limit and times the requests
+func (c *Context) newHTTPClient(tlsConfig *api.TLSConfig) (*http.Client, error) {
+	// Same settings as the SDK's default client; HTTP/1 keeps exec working
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	transport.ForceAttemptHTTP2 = false
+
+	httpClient := &http.Client{Transport: transport}
+	if err := api.ConfigureTLS(httpClient, tlsConfig); err != nil {
+		return nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+
+	httpClient.Transport = limiterFor(c.Name).RoundTripper(&timedRoundTripper{base: transport})
+
+	return httpClient, nil
+}
@@ -0,0 +1,44 @@
+package nomadconfig_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterQueuesUntilBodyClosed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: nomadconfig.NewLimiter("limit-test", 1).RoundTripper(http.DefaultTransport)}
+
+	first, err := client.Get(srv.URL + "/v1/jobs")
+	require.NoError(t, err)
+
+	// The first response still holds the only slot
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/nodes", nil)
+	_, err = client.Do(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Blocking queries are not limited
+	resp, err := client.Get(srv.URL + "/v1/jobs?index=42")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	first.Body.Close()
+
+	resp, err = client.Get(srv.URL + "/v1/nodes")
+	require.NoError(t, err)
+	resp.Body.Close()
+}
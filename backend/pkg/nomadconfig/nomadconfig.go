@@ -1,6 +1,8 @@
 package nomadconfig
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
@@ -8,6 +10,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/nomad/api"
 	"github.com/caravan-nomad/caravan/backend/pkg/logger"
@@ -23,8 +26,12 @@ const (
 	EnvVar = 1 << iota
 	DynamicCluster
 	InCluster
+	Demo
 )
 
+// aclProbeTimeout bounds the request of ProbeACL
+const aclProbeTimeout = 5 * time.Second
+
 // DefaultClusterName is the name used when a single cluster is configured via env vars
 const DefaultClusterName = "default"
 
@@ -49,6 +56,13 @@ type Context struct {
 	Error     string                 `json:"error,omitempty"`
 	proxy     *httputil.ReverseProxy `json:"-"`
 	client    *api.Client            `json:"-"`
+
+	// AutoscalerAddress is the HTTP address of the cluster's Nomad Autoscaler
+	// agent, if it runs one
+	AutoscalerAddress string `json:"autoscalerAddress,omitempty"`
+
+	// ACLDisabled is set by ProbeACL when the cluster runs without ACLs
+	ACLDisabled bool `json:"aclDisabled,omitempty"`
 }
 
 // userAgentRoundTripper wraps an http.RoundTripper and adds a Caravan User-Agent header
@@ -98,6 +112,12 @@ func (c *Context) GetClient() (*api.Client, error) {
 		}
 	}
 
+	httpClient, err := c.newHTTPClient(cfg.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	cfg.HttpClient = httpClient
+
 	client, err := api.NewClient(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Nomad client: %w", err)
@@ -137,6 +157,12 @@ func (c *Context) GetClientWithToken(token string) (*api.Client, error) {
 		}
 	}
 
+	httpClient, err := c.newHTTPClient(cfg.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	cfg.HttpClient = httpClient
+
 	return api.NewClient(cfg)
 }
 
@@ -157,7 +183,7 @@ func (c *Context) SetupProxy() error {
 	}
 
 	proxy.Transport = &userAgentRoundTripper{
-		base:      transport,
+		base:      limiterFor(c.Name).RoundTripper(&timedRoundTripper{base: transport}),
 		userAgent: buildUserAgent(),
 	}
 
@@ -191,19 +217,56 @@ func (c *Context) SourceStr() string {
 		return "dynamic_cluster"
 	case InCluster:
 		return "incluster"
+	case Demo:
+		return "demo"
 	default:
 		return "unknown"
 	}
 }
 
-// AuthType returns the authentication type for the context
+// AuthType returns the authentication type for the context: "none" when the
+// cluster has ACLs disabled, so that there is nothing to log in to
 func (c *Context) AuthType() string {
+	if c.ACLDisabled {
+		return "none"
+	}
 	if c.Token != "" {
 		return "token"
 	}
 	return ""
 }
 
+// ProbeACL sets ACLDisabled by reading the agent configuration without a
+// token. With ACLs enabled, Nomad refuses the anonymous request.
+func (c *Context) ProbeACL(ctx context.Context) error {
+	anonymous := &Context{Name: c.Name, Address: c.Address, Region: c.Region, TLS: c.TLS}
+	client, err := anonymous.GetClientWithToken("")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, aclProbeTimeout)
+	defer cancel()
+
+	var self api.AgentSelf
+	_, err = client.Raw().Query("/v1/agent/self", &self, (&api.QueryOptions{}).WithContext(ctx))
+
+	var respErr api.UnexpectedResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode() == http.StatusForbidden {
+		c.ACLDisabled = false
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("probing the ACL system of %s: %w", c.Name, err)
+	}
+
+	acl, _ := self.Config["ACL"].(map[string]interface{})
+	enabled, _ := acl["Enabled"].(bool)
+	c.ACLDisabled = !enabled
+
+	return nil
+}
+
 // LoadFromEnv loads a single context from standard Nomad environment variables
 // Environment variables used:
 // - NOMAD_ADDR: Nomad server address (required)
@@ -215,6 +278,7 @@ func (c *Context) AuthType() string {
 // - NOMAD_CLIENT_KEY: Path to client key
 // - NOMAD_SKIP_VERIFY: Skip TLS verification
 // - NOMAD_CLUSTER_NAME: Optional cluster name (defaults to "default")
+// - NOMAD_AUTOSCALER_ADDR: Optional Nomad Autoscaler agent address
 //
 // Returns nil if NOMAD_ADDR is not set (no default cluster created).
 func LoadFromEnv() (*Context, error) {
@@ -237,6 +301,8 @@ func LoadFromEnv() (*Context, error) {
 		Namespace: os.Getenv("NOMAD_NAMESPACE"),
 		Token:     os.Getenv("NOMAD_TOKEN"),
 		Source:    EnvVar,
+
+		AutoscalerAddress: os.Getenv("NOMAD_AUTOSCALER_ADDR"),
 	}
 
 	// Load TLS config from env vars
@@ -266,6 +332,7 @@ func LoadFromEnv() (*Context, error) {
 // - NOMAD_NAMESPACE_<CLUSTER>: Namespace for specific cluster (uppercase)
 // - NOMAD_CACERT_<CLUSTER>: CA cert path for specific cluster (uppercase)
 // - NOMAD_SKIP_VERIFY_<CLUSTER>: Skip TLS verify for specific cluster (uppercase)
+// - NOMAD_AUTOSCALER_ADDR_<CLUSTER>: Nomad Autoscaler agent for specific cluster (uppercase)
 //
 // Returns an empty list if no clusters are configured (no error).
 func LoadMultiClusterFromEnv() ([]*Context, error) {
@@ -323,6 +390,11 @@ func LoadMultiClusterFromEnv() ([]*Context, error) {
 			namespace = os.Getenv("NOMAD_NAMESPACE")
 		}
 
+		autoscalerAddr := os.Getenv("NOMAD_AUTOSCALER_ADDR_" + upperName)
+		if autoscalerAddr == "" && len(contexts) == 0 {
+			autoscalerAddr = os.Getenv("NOMAD_AUTOSCALER_ADDR")
+		}
+
 		ctx := &Context{
 			Name:      makeDNSFriendly(name),
 			Address:   addr,
@@ -330,6 +402,8 @@ func LoadMultiClusterFromEnv() ([]*Context, error) {
 			Namespace: namespace,
 			Token:     token,
 			Source:    EnvVar,
+
+			AutoscalerAddress: autoscalerAddr,
 		}
 
 		// Load TLS config
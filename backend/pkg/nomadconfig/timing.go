@@ -0,0 +1,56 @@
+package nomadconfig
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// UpstreamTiming adds up the requests a Caravan request sends to Nomad and
+// the time they take until Nomad answers, so that slow requests can be told
+// apart from slow clusters.
+type UpstreamTiming struct {
+	calls atomic.Int64
+	nanos atomic.Int64
+}
+
+type upstreamTimingKey struct{}
+
+// WithUpstreamTiming returns a context whose requests to Nomad are added up
+// in the returned timing.
+func WithUpstreamTiming(ctx context.Context) (context.Context, *UpstreamTiming) {
+	t := &UpstreamTiming{}
+	return context.WithValue(ctx, upstreamTimingKey{}, t), t
+}
+
+// Calls returns the number of requests sent to Nomad.
+func (t *UpstreamTiming) Calls() int64 {
+	return t.calls.Load()
+}
+
+// Duration returns the time spent waiting on Nomad's responses, not counting
+// the time queued behind the request limit or reading bodies.
+func (t *UpstreamTiming) Duration() time.Duration {
+	return time.Duration(t.nanos.Load())
+}
+
+// timedRoundTripper adds the requests it sends to the UpstreamTiming of
+// their context, if any
+type timedRoundTripper struct {
+	base http.RoundTripper
+}
+
+func (rt *timedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	t, ok := req.Context().Value(upstreamTimingKey{}).(*UpstreamTiming)
+	if !ok || isLongLived(req) {
+		return rt.base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := rt.base.RoundTrip(req)
+	t.calls.Add(1)
+	t.nanos.Add(int64(time.Since(start)))
+
+	return resp, err
+}
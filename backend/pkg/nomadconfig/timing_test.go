@@ -0,0 +1,40 @@
+package nomadconfig_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpstreamTiming(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+
+	nomadCtx := &nomadconfig.Context{Name: "timing-test", Address: srv.URL}
+	client, err := nomadCtx.GetClientWithToken("")
+	require.NoError(t, err)
+
+	ctx, timing := nomadconfig.WithUpstreamTiming(context.Background())
+	q := (&api.QueryOptions{}).WithContext(ctx)
+	for i := 0; i < 2; i++ {
+		_, _, err := client.Jobs().List(q)
+		require.NoError(t, err)
+	}
+
+	// Calls without a timing in their context are not counted
+	_, _, err = client.Jobs().List(nil)
+	require.NoError(t, err)
+
+	assert.EqualValues(t, 2, timing.Calls())
+	assert.GreaterOrEqual(t, timing.Duration(), 40*time.Millisecond)
+}
@@ -0,0 +1,1850 @@
+// Package nomadfake is an in-memory implementation of the Nomad HTTP API.
+//
+// A Cluster keeps jobs, allocations, nodes, evaluations, deployments,
+// namespaces and variables in memory and "schedules" jobs instantly: placing
+// a job creates running allocations on the ready nodes, a completed
+// evaluation and a successful deployment. Every change is published on the
+// event stream, and allocations have task logs, a small file system, and
+// exec sessions and task actions run by a pluggable ExecFunc.
+//
+// It is served over HTTP by Handler, so the regular Nomad SDK, and with it
+// all of Caravan, can talk to it. It backs the --demo mode and is used in
+// tests that need a Nomad cluster without running one.
+package nomadfake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/nomad/api"
+)
+
+// eventHistory is how many events are kept to replay for subscribers that
+// start the stream at an index
+const eventHistory = 1024
+
+// oneTimeTokenTTL is how long one-time tokens can be exchanged, as in Nomad
+const oneTimeTokenTTL = 10 * time.Minute
+
+// ErrNotFound is returned for objects that do not exist in the cluster
+var ErrNotFound = errors.New("not found")
+
+type nsKey struct {
+	namespace string
+	id        string
+}
+
+// Cluster is the state of a fake Nomad cluster.
+type Cluster struct {
+	mutex sync.RWMutex
+	index uint64
+	// changed is closed and replaced on every write to wake blocking queries
+	changed chan struct{}
+
+	region     string
+	namespaces map[string]*api.Namespace
+	jobs       map[nsKey]*api.Job
+	versions   map[nsKey][]*api.Job
+	allocs     map[string]*api.Allocation
+	nodes      map[string]*api.Node
+	evals      map[string]*api.Evaluation
+	deploys    map[string]*api.Deployment
+	variables  map[nsKey]*api.Variable
+	scaling    map[nsKey]map[string][]api.ScalingEvent
+	tokens     map[string]*api.ACLToken
+	policies   map[string]*api.ACLPolicy
+	// authMethods are the ACL auth methods by name, logins the accessor IDs
+	// of the tokens their credentials log in as
+	authMethods map[string]*api.ACLAuthMethod
+	logins      map[string]string
+	// oneTime are the one-time tokens not exchanged yet, by secret
+	oneTime map[string]*api.OneTimeToken
+
+	logs  map[string]*logBuffer
+	files map[string]map[string][]byte
+	// filesChanged is closed and replaced on every file or log write to
+	// wake file streams
+	filesChanged chan struct{}
+	// checks are the service check results of each allocation
+	checks map[string]api.AllocCheckStatuses
+
+	events      []api.Event
+	subscribers map[*subscriber]struct{}
+
+	// exec runs the commands of exec sessions
+	exec ExecFunc
+
+	// now is the clock, replaceable in tests
+	now func() time.Time
+}
+
+// New creates an empty cluster with a default namespace.
+func New() *Cluster {
+	c := &Cluster{
+		changed:     make(chan struct{}),
+		region:      "global",
+		namespaces:  make(map[string]*api.Namespace),
+		jobs:        make(map[nsKey]*api.Job),
+		versions:    make(map[nsKey][]*api.Job),
+		allocs:      make(map[string]*api.Allocation),
+		nodes:       make(map[string]*api.Node),
+		evals:       make(map[string]*api.Evaluation),
+		deploys:     make(map[string]*api.Deployment),
+		variables:   make(map[nsKey]*api.Variable),
+		scaling:     make(map[nsKey]map[string][]api.ScalingEvent),
+		tokens:      make(map[string]*api.ACLToken),
+		policies:    make(map[string]*api.ACLPolicy),
+		authMethods: make(map[string]*api.ACLAuthMethod),
+		logins:      make(map[string]string),
+		oneTime:     make(map[string]*api.OneTimeToken),
+		logs:        make(map[string]*logBuffer),
+		files:       make(map[string]map[string][]byte),
+		checks:      make(map[string]api.AllocCheckStatuses),
+		subscribers: make(map[*subscriber]struct{}),
+		exec:        Shell,
+		now:         time.Now,
+
+		filesChanged: make(chan struct{}),
+	}
+
+	c.AddNamespace("default", "Default shared namespace")
+
+	return c
+}
+
+// Index returns the current raft index of the cluster.
+func (c *Cluster) Index() uint64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.index
+}
+
+// bump advances the index and wakes blocking queries. The caller holds the lock.
+func (c *Cluster) bump() uint64 {
+	c.index++
+	close(c.changed)
+	c.changed = make(chan struct{})
+
+	return c.index
+}
+
+// clone deep copies v through JSON, so callers never share state with the cluster
+func clone[T any](v *T) *T {
+	if v == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	var out T
+	if err := json.Unmarshal(data, &out); err != nil {
+		panic(err)
+	}
+
+	return &out
+}
+
+func newID() string {
+	return uuid.NewString()
+}
+
+func namespaceOf(ns string) string {
+	if ns == "" {
+		return "default"
+	}
+
+	return ns
+}
+
+func matchNamespace(filter, ns string) bool {
+	return filter == "*" || namespaceOf(filter) == ns
+}
+
+// AddNamespace creates or updates a namespace.
+func (c *Cluster) AddNamespace(name, description string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	index := c.bump()
+	c.namespaces[name] = &api.Namespace{Name: name, Description: description, CreateIndex: index, ModifyIndex: index}
+}
+
+// AddToken adds an ACL token, which turns on ACL enforcement: requests then
+// need the secret ID of a known token. Capabilities are not modelled, every
+// known token can do everything.
+func (c *Cluster) AddToken(token *api.ACLToken) *api.ACLToken {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	t := clone(token)
+	if t.AccessorID == "" {
+		t.AccessorID = newID()
+	}
+
+	if t.SecretID == "" {
+		t.SecretID = newID()
+	}
+
+	if t.Type == "" {
+		t.Type = "client"
+	}
+
+	t.CreateIndex = c.bump()
+	t.ModifyIndex = t.CreateIndex
+	c.tokens[t.AccessorID] = t
+
+	return clone(t)
+}
+
+// Bootstrap creates the initial management token, turning on ACL
+// enforcement, with secret as its secret ID if it is set. Like Nomad, it
+// fails once the ACL system has a token.
+func (c *Cluster) Bootstrap(secret string) (*api.ACLToken, error) {
+	c.mutex.RLock()
+	bootstrapped := len(c.tokens) > 0
+	c.mutex.RUnlock()
+
+	if bootstrapped {
+		return nil, errors.New("ACL bootstrap already done")
+	}
+
+	now := c.now()
+
+	return c.AddToken(&api.ACLToken{
+		SecretID:   secret,
+		Name:       "Bootstrap Token",
+		Type:       "management",
+		Global:     true,
+		CreateTime: now,
+	}), nil
+}
+
+// AddPolicy adds an ACL policy.
+func (c *Cluster) AddPolicy(policy *api.ACLPolicy) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	p := clone(policy)
+	p.CreateIndex = c.bump()
+	p.ModifyIndex = p.CreateIndex
+	c.policies[p.Name] = p
+}
+
+// tokenBySecret returns the token with the secret, nil if ACLs are disabled.
+// The boolean is false if ACLs are enabled and the secret is unknown or its
+// token expired.
+func (c *Cluster) tokenBySecret(secret string) (*api.ACLToken, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if len(c.tokens) == 0 {
+		return nil, true
+	}
+
+	for _, t := range c.tokens {
+		if t.SecretID == secret {
+			if t.ExpirationTime != nil && !c.now().Before(*t.ExpirationTime) {
+				return nil, false
+			}
+			return t, true
+		}
+	}
+
+	return nil, false
+}
+
+// AddAuthMethod adds an ACL auth method.
+func (c *Cluster) AddAuthMethod(method *api.ACLAuthMethod) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	m := clone(method)
+	m.CreateIndex = c.bump()
+	m.ModifyIndex = m.CreateIndex
+	c.authMethods[m.Name] = m
+}
+
+// AllowLogin makes logins with credential, a JWT or an OIDC authorization
+// code, succeed on any auth method of the matching type. They return token,
+// which is added as AddToken adds it.
+func (c *Cluster) AllowLogin(credential string, token *api.ACLToken) *api.ACLToken {
+	t := c.AddToken(token)
+
+	c.mutex.Lock()
+	c.logins[credential] = t.AccessorID
+	c.mutex.Unlock()
+
+	return t
+}
+
+// login returns the token a credential logs in as with an auth method
+func (c *Cluster) login(methodName, methodType, credential string) (*api.ACLToken, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	m, ok := c.authMethods[methodName]
+	if !ok || m.Type != methodType {
+		return nil, fmt.Errorf("%s auth method %q %w", methodType, methodName, ErrNotFound)
+	}
+
+	accessor, ok := c.logins[credential]
+	if !ok {
+		return nil, errors.New("failed to authenticate: invalid credential")
+	}
+
+	return clone(c.tokens[accessor]), nil
+}
+
+// CreateOneTimeToken creates a one-time token that exchanges for the token
+// of accessorID.
+func (c *Cluster) CreateOneTimeToken(accessorID string) *api.OneTimeToken {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ott := &api.OneTimeToken{
+		OneTimeSecretID: newID(),
+		AccessorID:      accessorID,
+		ExpiresAt:       c.now().Add(oneTimeTokenTTL),
+	}
+	ott.CreateIndex = c.bump()
+	ott.ModifyIndex = ott.CreateIndex
+	c.oneTime[ott.OneTimeSecretID] = ott
+
+	return clone(ott)
+}
+
+// ExchangeOneTimeToken returns the token a one-time token was created for.
+// Like in Nomad, each one-time token can only be exchanged once.
+func (c *Cluster) ExchangeOneTimeToken(secret string) (*api.ACLToken, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ott, ok := c.oneTime[secret]
+	delete(c.oneTime, secret)
+
+	if !ok || !c.now().Before(ott.ExpiresAt) {
+		return nil, fmt.Errorf("one-time token %w", ErrNotFound)
+	}
+
+	t, ok := c.tokens[ott.AccessorID]
+	if !ok {
+		return nil, fmt.Errorf("ACL token %q %w", ott.AccessorID, ErrNotFound)
+	}
+
+	return clone(t), nil
+}
+
+// UpsertNode adds or replaces a client node. Missing fields get defaults of
+// a ready, eligible node.
+func (c *Cluster) UpsertNode(node *api.Node) *api.Node {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	n := clone(node)
+	if n.ID == "" {
+		n.ID = newID()
+	}
+
+	if n.Name == "" {
+		n.Name = n.ID[:8]
+	}
+
+	if n.Datacenter == "" {
+		n.Datacenter = "dc1"
+	}
+
+	if n.NodePool == "" {
+		n.NodePool = "default"
+	}
+
+	if n.Status == "" {
+		n.Status = api.NodeStatusReady
+	}
+
+	if n.SchedulingEligibility == "" {
+		n.SchedulingEligibility = api.NodeSchedulingEligible
+	}
+
+	index := c.bump()
+	if old, ok := c.nodes[n.ID]; ok {
+		n.CreateIndex = old.CreateIndex
+	} else {
+		n.CreateIndex = index
+	}
+
+	n.ModifyIndex = index
+	n.StatusUpdatedAt = c.now().Unix()
+	c.nodes[n.ID] = n
+
+	c.publish(api.TopicNode, "NodeRegistration", n.ID, "", map[string]interface{}{"Node": n})
+
+	return clone(n)
+}
+
+// Node returns a node by ID.
+func (c *Cluster) Node(id string) (*api.Node, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	n, ok := c.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("node %q %w", id, ErrNotFound)
+	}
+
+	return clone(n), nil
+}
+
+// Job returns the current version of a job.
+func (c *Cluster) Job(namespace, id string) (*api.Job, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	j, ok := c.jobs[nsKey{namespaceOf(namespace), id}]
+	if !ok {
+		return nil, fmt.Errorf("job %q %w", id, ErrNotFound)
+	}
+
+	return clone(j), nil
+}
+
+// Allocation returns an allocation by ID.
+func (c *Cluster) Allocation(id string) (*api.Allocation, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	a, ok := c.allocs[id]
+	if !ok {
+		return nil, fmt.Errorf("alloc %q %w", id, ErrNotFound)
+	}
+
+	return clone(a), nil
+}
+
+// Allocations returns the allocations of a job, or of all jobs if jobID is
+// empty, sorted by name.
+func (c *Cluster) Allocations(namespace, jobID string) []*api.Allocation {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	var allocs []*api.Allocation
+
+	for _, a := range c.allocs {
+		if matchNamespace(namespace, a.Namespace) && (jobID == "" || a.JobID == jobID) {
+			allocs = append(allocs, clone(a))
+		}
+	}
+
+	sort.Slice(allocs, func(i, j int) bool {
+		if allocs[i].Name != allocs[j].Name {
+			return allocs[i].Name < allocs[j].Name
+		}
+
+		return allocs[i].CreateIndex < allocs[j].CreateIndex
+	})
+
+	return allocs
+}
+
+// RegisterJob registers a new version of a job and schedules it.
+func (c *Cluster) RegisterJob(job *api.Job) (*api.JobRegisterResponse, error) {
+	if job == nil || job.ID == nil || *job.ID == "" {
+		return nil, errors.New("job ID is required")
+	}
+
+	j := clone(job)
+	j.Canonicalize()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := nsKey{*j.Namespace, *j.ID}
+	if _, ok := c.namespaces[key.namespace]; !ok {
+		return nil, fmt.Errorf("namespace %q %w", key.namespace, ErrNotFound)
+	}
+
+	index := c.bump()
+	now := c.now()
+
+	version := uint64(0)
+	j.CreateIndex = &index
+
+	if old, ok := c.jobs[key]; ok {
+		version = *old.Version + 1
+		j.CreateIndex = old.CreateIndex
+	}
+
+	j.Version = &version
+	j.ModifyIndex = &index
+	j.JobModifyIndex = &index
+	j.SubmitTime = ptr(now.UnixNano())
+	j.Stable = ptr(false)
+	j.Status = ptr("pending")
+
+	c.jobs[key] = j
+	c.versions[key] = append([]*api.Job{clone(j)}, c.versions[key]...)
+
+	eval := c.schedule(j, "job-register")
+
+	c.publish(api.TopicJob, "JobRegistered", key.id, key.namespace, map[string]interface{}{"Job": j})
+
+	return &api.JobRegisterResponse{
+		EvalID:          eval.ID,
+		EvalCreateIndex: eval.CreateIndex,
+		JobModifyIndex:  index,
+	}, nil
+}
+
+// DeregisterJob stops a job, and removes it with all its objects if purge is set.
+func (c *Cluster) DeregisterJob(namespace, id string, purge bool) (*api.JobDeregisterResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := nsKey{namespaceOf(namespace), id}
+
+	j, ok := c.jobs[key]
+	if !ok {
+		return nil, fmt.Errorf("job %q %w", id, ErrNotFound)
+	}
+
+	index := c.bump()
+	j.Stop = ptr(true)
+	j.ModifyIndex = &index
+	j.JobModifyIndex = &index
+
+	eval := c.schedule(j, "job-deregister")
+
+	if purge {
+		delete(c.jobs, key)
+		delete(c.versions, key)
+
+		for allocID, a := range c.allocs {
+			if a.Namespace == key.namespace && a.JobID == id {
+				delete(c.allocs, allocID)
+			}
+		}
+
+		for deployID, d := range c.deploys {
+			if d.Namespace == key.namespace && d.JobID == id {
+				delete(c.deploys, deployID)
+			}
+		}
+	}
+
+	c.publish(api.TopicJob, "JobDeregistered", id, key.namespace, map[string]interface{}{"Job": j})
+
+	return &api.JobDeregisterResponse{EvalID: eval.ID, EvalCreateIndex: eval.CreateIndex, JobModifyIndex: index}, nil
+}
+
+// GarbageCollect forces a garbage collection, which in the fake purges the
+// stopped jobs along with their allocations, deployments and evaluations.
+func (c *Cluster) GarbageCollect() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, j := range c.jobs {
+		if j.Stop == nil || !*j.Stop {
+			continue
+		}
+
+		delete(c.jobs, key)
+		delete(c.versions, key)
+
+		for allocID, a := range c.allocs {
+			if a.Namespace == key.namespace && a.JobID == key.id {
+				delete(c.allocs, allocID)
+			}
+		}
+		for deployID, d := range c.deploys {
+			if d.Namespace == key.namespace && d.JobID == key.id {
+				delete(c.deploys, deployID)
+			}
+		}
+		for evalID, e := range c.evals {
+			if e.Namespace == key.namespace && e.JobID == key.id {
+				delete(c.evals, evalID)
+			}
+		}
+	}
+
+	c.bump()
+}
+
+// ScaleJob sets the count of a task group, which registers a new job version.
+func (c *Cluster) ScaleJob(namespace, id, group string, count int) (*api.JobRegisterResponse, error) {
+	j, err := c.Job(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+
+	tg := j.LookupTaskGroup(group)
+	if tg == nil {
+		return nil, fmt.Errorf("task group %q %w", group, ErrNotFound)
+	}
+
+	tg.Count = &count
+
+	return c.RegisterJob(j)
+}
+
+// trackedScalingEvents is how many scaling events Nomad keeps per task group
+const trackedScalingEvents = 20
+
+// RecordScalingEvent adds a scaling event to the history of a task group.
+func (c *Cluster) RecordScalingEvent(namespace, id, group string, event api.ScalingEvent) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := nsKey{namespaceOf(namespace), id}
+	if c.scaling[key] == nil {
+		c.scaling[key] = make(map[string][]api.ScalingEvent)
+	}
+
+	event.CreateIndex = c.bump()
+	if event.Time == 0 {
+		event.Time = uint64(c.now().UnixNano())
+	}
+
+	// Newest first, like Nomad
+	events := append([]api.ScalingEvent{event}, c.scaling[key][group]...)
+	if len(events) > trackedScalingEvents {
+		events = events[:trackedScalingEvents]
+	}
+	c.scaling[key][group] = events
+}
+
+// ScalingEvents returns the scaling events of a job's task groups, newest first.
+func (c *Cluster) ScalingEvents(namespace, id string) map[string][]api.ScalingEvent {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	events := make(map[string][]api.ScalingEvent)
+	for group, groupEvents := range c.scaling[nsKey{namespaceOf(namespace), id}] {
+		events[group] = append([]api.ScalingEvent(nil), groupEvents...)
+	}
+
+	return events
+}
+
+// DispatchJob creates and schedules a child of a parameterized job.
+func (c *Cluster) DispatchJob(namespace, id string, payload []byte, meta map[string]string) (*api.JobDispatchResponse, error) {
+	return c.DispatchJobOpts(namespace, id, DispatchOptions{Payload: payload, Meta: meta})
+}
+
+// DispatchOptions are the parameters of a dispatch
+type DispatchOptions struct {
+	Payload []byte
+	Meta    map[string]string
+	// IDPrefix goes into the child's ID, after the parent's
+	IDPrefix string
+	// IdempotencyToken makes dispatching again with the same token return
+	// the child dispatched first
+	IdempotencyToken string
+}
+
+// DispatchJobOpts creates and schedules a child of a parameterized job,
+// checking the payload and meta against the job's parameters like Nomad.
+func (c *Cluster) DispatchJobOpts(namespace, id string, opts DispatchOptions) (*api.JobDispatchResponse, error) {
+	parent, err := c.Job(namespace, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !parent.IsParameterized() {
+		return nil, fmt.Errorf("job %q is not parameterized", id)
+	}
+
+	if err := checkDispatch(parent.ParameterizedJob, opts); err != nil {
+		return nil, err
+	}
+
+	if opts.IdempotencyToken != "" {
+		c.mutex.RLock()
+		for _, j := range c.jobs {
+			if j.ParentID != nil && *j.ParentID == id && *j.Namespace == *parent.Namespace &&
+				j.DispatchIdempotencyToken != nil && *j.DispatchIdempotencyToken == opts.IdempotencyToken {
+				c.mutex.RUnlock()
+				return &api.JobDispatchResponse{DispatchedJobID: *j.ID, JobCreateIndex: *j.CreateIndex}, nil
+			}
+		}
+		c.mutex.RUnlock()
+	}
+
+	prefix := ""
+	if opts.IDPrefix != "" {
+		prefix = opts.IDPrefix + "-"
+	}
+
+	child := clone(parent)
+	child.ID = ptr(fmt.Sprintf("%s/dispatch-%s%d-%s", id, prefix, c.now().Unix(), newID()[:8]))
+	child.Name = child.ID
+	child.ParentID = parent.ID
+	child.ParameterizedJob = nil
+	child.Dispatched = true
+	child.Payload = opts.Payload
+	if opts.IdempotencyToken != "" {
+		child.DispatchIdempotencyToken = ptr(opts.IdempotencyToken)
+	}
+
+	for k, v := range opts.Meta {
+		if child.Meta == nil {
+			child.Meta = map[string]string{}
+		}
+
+		child.Meta[k] = v
+	}
+
+	resp, err := c.RegisterJob(child)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.JobDispatchResponse{
+		DispatchedJobID: *child.ID,
+		EvalID:          resp.EvalID,
+		EvalCreateIndex: resp.EvalCreateIndex,
+		JobCreateIndex:  resp.JobModifyIndex,
+	}, nil
+}
+
+// checkDispatch rejects payloads and meta the parameters do not allow
+func checkDispatch(params *api.ParameterizedJobConfig, opts DispatchOptions) error {
+	switch {
+	case params.Payload == "required" && len(opts.Payload) == 0:
+		return errors.New("payload is not provided but required by parameterized job")
+	case params.Payload == "forbidden" && len(opts.Payload) > 0:
+		return errors.New("payload provided but forbidden by parameterized job")
+	}
+
+	var missing, disallowed []string
+	for _, k := range params.MetaRequired {
+		if _, ok := opts.Meta[k]; !ok {
+			missing = append(missing, k)
+		}
+	}
+	for k := range opts.Meta {
+		if !slices.Contains(params.MetaRequired, k) && !slices.Contains(params.MetaOptional, k) {
+			disallowed = append(disallowed, k)
+		}
+	}
+	sort.Strings(disallowed)
+
+	if len(missing) > 0 {
+		return fmt.Errorf("dispatch did not provide required meta keys: %v", missing)
+	}
+	if len(disallowed) > 0 {
+		return fmt.Errorf("dispatch provided disallowed meta keys: %v", disallowed)
+	}
+
+	return nil
+}
+
+// schedule reconciles the allocations of a job with its spec and records the
+// evaluation and, for service jobs, the deployment. The caller holds the lock.
+func (c *Cluster) schedule(j *api.Job, triggeredBy string) *api.Evaluation {
+	now := c.now()
+	index := c.bump()
+
+	eval := &api.Evaluation{
+		ID:                newID(),
+		Priority:          *j.Priority,
+		Type:              *j.Type,
+		TriggeredBy:       triggeredBy,
+		Namespace:         *j.Namespace,
+		JobID:             *j.ID,
+		JobModifyIndex:    *j.JobModifyIndex,
+		Status:            "complete",
+		QueuedAllocations: map[string]int{},
+		CreateIndex:       index,
+		ModifyIndex:       index,
+		CreateTime:        now.UnixNano(),
+		ModifyTime:        now.UnixNano(),
+	}
+
+	// Templates of dispatched and periodic children never run themselves
+	stopped := j.Stop != nil && *j.Stop
+	template := j.IsParameterized() || j.IsPeriodic()
+
+	var deployment *api.Deployment
+	if *j.Type == api.JobTypeService && !stopped && !template {
+		deployment = &api.Deployment{
+			ID:                newID(),
+			Namespace:         *j.Namespace,
+			JobID:             *j.ID,
+			JobVersion:        *j.Version,
+			JobModifyIndex:    *j.JobModifyIndex,
+			JobCreateIndex:    *j.CreateIndex,
+			TaskGroups:        map[string]*api.DeploymentState{},
+			Status:            api.DeploymentStatusSuccessful,
+			StatusDescription: "Deployment completed successfully",
+			CreateIndex:       index,
+			ModifyIndex:       index,
+			CreateTime:        now.UnixNano(),
+			ModifyTime:        now.UnixNano(),
+		}
+		eval.DeploymentID = deployment.ID
+	}
+
+	// Stop the allocations of removed groups, of changed tasks and beyond the
+	// count; allocations whose tasks did not change are updated in place
+	system := *j.Type == api.JobTypeSystem || *j.Type == api.JobTypeSysbatch
+
+	for _, a := range c.allocs {
+		if a.Namespace != *j.Namespace || a.JobID != *j.ID || a.DesiredStatus != api.AllocDesiredStatusRun {
+			continue
+		}
+
+		tg := j.LookupTaskGroup(a.TaskGroup)
+
+		switch {
+		case stopped || template || tg == nil || a.Job == nil:
+			c.stopAlloc(a, "alloc not needed due to job update")
+		case *a.Job.Version != *j.Version && !sameTasks(a.Job.LookupTaskGroup(a.TaskGroup), tg):
+			c.stopAlloc(a, "alloc not needed due to job update")
+		case !system && allocIndex(a.Name) >= *tg.Count:
+			c.stopAlloc(a, "alloc not needed due to scale down")
+		case *a.Job.Version != *j.Version:
+			a.Job = clone(j)
+			a.ModifyIndex = index
+		}
+	}
+
+	if !stopped && !template {
+		for _, tg := range j.TaskGroups {
+			placed := c.place(j, tg, eval, deployment)
+			eval.QueuedAllocations[*tg.Name] = 0
+
+			if deployment != nil {
+				deployment.TaskGroups[*tg.Name] = &api.DeploymentState{
+					DesiredTotal:  *tg.Count,
+					PlacedAllocs:  placed,
+					HealthyAllocs: placed,
+				}
+			}
+		}
+	}
+
+	c.evals[eval.ID] = eval
+	c.publish(api.TopicEvaluation, "EvaluationUpdated", eval.ID, eval.Namespace, map[string]interface{}{"Evaluation": eval})
+
+	if deployment != nil {
+		c.deploys[deployment.ID] = deployment
+		c.publish(api.TopicDeployment, "DeploymentStatusUpdate", deployment.ID, deployment.Namespace,
+			map[string]interface{}{"Deployment": deployment})
+
+		j.Stable = ptr(true)
+		c.versions[nsKey{*j.Namespace, *j.ID}][0].Stable = ptr(true)
+	}
+
+	c.updateJobStatus(j)
+
+	return eval
+}
+
+// place creates the missing running allocations of a task group on the
+// ready nodes, one per node for system jobs. It returns the number of
+// allocations the group has afterwards. The caller holds the lock.
+func (c *Cluster) place(j *api.Job, tg *api.TaskGroup, eval *api.Evaluation, d *api.Deployment) int {
+	nodes := c.readyNodes()
+	if len(nodes) == 0 {
+		eval.Status = "blocked"
+		return 0
+	}
+
+	count := *tg.Count
+	if *j.Type == api.JobTypeSystem || *j.Type == api.JobTypeSysbatch {
+		count = len(nodes)
+	}
+
+	running := map[string]bool{}
+
+	for _, a := range c.allocs {
+		if a.Namespace == *j.Namespace && a.JobID == *j.ID && a.TaskGroup == *tg.Name &&
+			a.DesiredStatus == api.AllocDesiredStatusRun {
+			running[a.Name] = true
+		}
+	}
+
+	now := c.now()
+
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("%s.%s[%d]", *j.ID, *tg.Name, i)
+		if running[name] {
+			continue
+		}
+
+		node := nodes[i%len(nodes)]
+		index := c.bump()
+
+		a := &api.Allocation{
+			ID:            newID(),
+			Namespace:     *j.Namespace,
+			EvalID:        eval.ID,
+			Name:          name,
+			NodeID:        node.ID,
+			NodeName:      node.Name,
+			JobID:         *j.ID,
+			Job:           clone(j),
+			TaskGroup:     *tg.Name,
+			DesiredStatus: api.AllocDesiredStatusRun,
+			ClientStatus:  api.AllocClientStatusRunning,
+			TaskStates:    map[string]*api.TaskState{},
+			CreateIndex:   index,
+			ModifyIndex:   index,
+			CreateTime:    now.UnixNano(),
+			ModifyTime:    now.UnixNano(),
+		}
+
+		if d != nil {
+			a.DeploymentID = d.ID
+			a.DeploymentStatus = &api.AllocDeploymentStatus{Healthy: ptr(true), Timestamp: now, ModifyIndex: index}
+		}
+
+		if ports := c.assignPorts(node, tg.Networks); len(ports) > 0 {
+			a.AllocatedResources = &api.AllocatedResources{
+				Tasks:  map[string]*api.AllocatedTaskResources{},
+				Shared: api.AllocatedSharedResources{Ports: ports},
+			}
+		}
+
+		for _, t := range tg.Tasks {
+			a.TaskStates[t.Name] = &api.TaskState{
+				State:     "running",
+				StartedAt: now,
+				Events: []*api.TaskEvent{
+					{Type: "Received", Time: now.UnixNano(), DisplayMessage: "Task received by client"},
+					{Type: "Started", Time: now.UnixNano(), DisplayMessage: "Task started by client"},
+				},
+			}
+			c.writeLog(a.ID, t.Name, "stdout", []byte(fmt.Sprintf("%s starting %s\n", now.Format(time.RFC3339), t.Name)))
+
+			if t.Resources != nil && len(t.Resources.Devices) > 0 {
+				if a.AllocatedResources == nil {
+					a.AllocatedResources = &api.AllocatedResources{Tasks: map[string]*api.AllocatedTaskResources{}}
+				}
+				a.AllocatedResources.Tasks[t.Name] = &api.AllocatedTaskResources{
+					Devices: c.assignDevices(node, a, t.Resources.Devices),
+				}
+			}
+		}
+
+		c.allocs[a.ID] = a
+		c.publish(api.TopicAllocation, "AllocationUpdated", a.ID, a.Namespace, map[string]interface{}{"Allocation": a})
+	}
+
+	return count
+}
+
+// assignDevices picks free healthy instances of the devices a task
+// requests on a node, fewer if the node runs out. Requests name a device by
+// type, vendor/type or vendor/type/name. The caller holds the lock.
+func (c *Cluster) assignDevices(node *api.Node, alloc *api.Allocation,
+	requests []*api.RequestedDevice) []*api.AllocatedDeviceResource {
+	if node.NodeResources == nil {
+		return nil
+	}
+
+	used := map[string]bool{}
+	markUsed := func(a *api.Allocation) {
+		if a.NodeID != node.ID || a.DesiredStatus != api.AllocDesiredStatusRun || a.AllocatedResources == nil {
+			return
+		}
+
+		for _, task := range a.AllocatedResources.Tasks {
+			for _, device := range task.Devices {
+				for _, id := range device.DeviceIDs {
+					used[id] = true
+				}
+			}
+		}
+	}
+
+	for _, other := range c.allocs {
+		markUsed(other)
+	}
+	// Earlier tasks of the allocation being placed
+	markUsed(alloc)
+
+	var assigned []*api.AllocatedDeviceResource
+
+	for _, req := range requests {
+		want := uint64(1)
+		if req.Count != nil {
+			want = *req.Count
+		}
+
+		for _, group := range node.NodeResources.Devices {
+			if !matchesDevice(req.Name, group) {
+				continue
+			}
+
+			device := &api.AllocatedDeviceResource{Vendor: group.Vendor, Type: group.Type, Name: group.Name}
+			for _, instance := range group.Instances {
+				if uint64(len(device.DeviceIDs)) == want {
+					break
+				}
+				if instance.Healthy && !used[instance.ID] {
+					used[instance.ID] = true
+					device.DeviceIDs = append(device.DeviceIDs, instance.ID)
+				}
+			}
+
+			if len(device.DeviceIDs) > 0 {
+				assigned = append(assigned, device)
+			}
+
+			break
+		}
+	}
+
+	return assigned
+}
+
+// Dynamic port range of nodes that do not set one, as in Nomad
+const (
+	defaultMinDynamicPort = 20000
+	defaultMaxDynamicPort = 32000
+)
+
+// assignPorts maps the ports of a group's networks on a node: static ones
+// as asked, dynamic ones to the lowest free port of the node's dynamic
+// range. The caller holds the lock.
+func (c *Cluster) assignPorts(node *api.Node, networks []*api.NetworkResource) []api.PortMapping {
+	hostIP := strings.Split(node.HTTPAddr, ":")[0]
+	minPort, maxPort := defaultMinDynamicPort, defaultMaxDynamicPort
+	if r := node.NodeResources; r != nil {
+		if len(r.Networks) > 0 && r.Networks[0].IP != "" {
+			hostIP = r.Networks[0].IP
+		}
+		if r.MinDynamicPort > 0 && r.MaxDynamicPort >= r.MinDynamicPort {
+			minPort, maxPort = r.MinDynamicPort, r.MaxDynamicPort
+		}
+	}
+
+	used := map[int]bool{}
+	if r := node.ReservedResources; r != nil {
+		for _, part := range strings.Split(r.Networks.ReservedHostPorts, ",") {
+			from, to, _ := strings.Cut(strings.TrimSpace(part), "-")
+			start, err := strconv.Atoi(from)
+			if err != nil {
+				continue
+			}
+			end := start
+			if to != "" {
+				if end, err = strconv.Atoi(to); err != nil {
+					continue
+				}
+			}
+			for port := start; port <= end; port++ {
+				used[port] = true
+			}
+		}
+	}
+	for _, a := range c.allocs {
+		if a.NodeID != node.ID || a.DesiredStatus != api.AllocDesiredStatusRun || a.AllocatedResources == nil {
+			continue
+		}
+		for _, p := range a.AllocatedResources.Shared.Ports {
+			used[p.Value] = true
+		}
+	}
+
+	var ports []api.PortMapping
+
+	for _, network := range networks {
+		for _, p := range network.ReservedPorts {
+			used[p.Value] = true
+			ports = append(ports, api.PortMapping{Label: p.Label, Value: p.Value, To: p.To, HostIP: hostIP})
+		}
+	}
+
+	next := minPort
+	for _, network := range networks {
+		for _, p := range network.DynamicPorts {
+			for next <= maxPort && used[next] {
+				next++
+			}
+			if next > maxPort {
+				return ports
+			}
+
+			used[next] = true
+			ports = append(ports, api.PortMapping{Label: p.Label, Value: next, To: p.To, HostIP: hostIP})
+		}
+	}
+
+	return ports
+}
+
+func matchesDevice(name string, group *api.NodeDeviceResource) bool {
+	parts := strings.Split(name, "/")
+
+	switch len(parts) {
+	case 1:
+		return parts[0] == group.Type
+	case 2:
+		return parts[0] == group.Vendor && parts[1] == group.Type
+	case 3:
+		return parts[0] == group.Vendor && parts[1] == group.Type && parts[2] == group.Name
+	}
+
+	return false
+}
+
+// readyNodes returns the nodes that can receive allocations, sorted by name.
+// The caller holds the lock.
+func (c *Cluster) readyNodes() []*api.Node {
+	var nodes []*api.Node
+
+	for _, n := range c.nodes {
+		if n.Status == api.NodeStatusReady && n.SchedulingEligibility == api.NodeSchedulingEligible && !n.Drain {
+			nodes = append(nodes, n)
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+
+	return nodes
+}
+
+// stopAlloc marks an allocation as stopped. The caller holds the lock.
+func (c *Cluster) stopAlloc(a *api.Allocation, reason string) {
+	now := c.now()
+
+	a.DesiredStatus = api.AllocDesiredStatusStop
+	a.DesiredDescription = reason
+	a.ClientStatus = api.AllocClientStatusComplete
+	a.ModifyIndex = c.bump()
+	a.ModifyTime = now.UnixNano()
+
+	for _, ts := range a.TaskStates {
+		ts.State = "dead"
+		ts.FinishedAt = now
+		ts.Events = append(ts.Events, &api.TaskEvent{Type: "Killed", Time: now.UnixNano(), DisplayMessage: "Task successfully killed"})
+	}
+
+	c.publish(api.TopicAllocation, "AllocationUpdated", a.ID, a.Namespace, map[string]interface{}{"Allocation": a})
+}
+
+// updateJobStatus derives the job status from its allocations. The caller holds the lock.
+func (c *Cluster) updateJobStatus(j *api.Job) {
+	status := "pending"
+
+	switch {
+	case j.Stop != nil && *j.Stop:
+		status = "dead"
+	case j.IsParameterized() || j.IsPeriodic():
+		status = "running"
+	default:
+		for _, a := range c.allocs {
+			if a.Namespace == *j.Namespace && a.JobID == *j.ID && a.ClientStatus == api.AllocClientStatusRunning {
+				status = "running"
+				break
+			}
+		}
+	}
+
+	j.Status = &status
+}
+
+// StopAllocation stops an allocation and places a replacement, like Nomad
+// does for allocations of jobs that should still run.
+func (c *Cluster) StopAllocation(id string) (*api.AllocStopResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	a, ok := c.allocs[id]
+	if !ok {
+		return nil, fmt.Errorf("alloc %q %w", id, ErrNotFound)
+	}
+
+	c.stopAlloc(a, "alloc is being stopped by user")
+
+	j := c.jobs[nsKey{a.Namespace, a.JobID}]
+	if j == nil {
+		return &api.AllocStopResponse{}, nil
+	}
+
+	eval := c.schedule(j, "alloc-stop")
+
+	return &api.AllocStopResponse{EvalID: eval.ID}, nil
+}
+
+// RestartAllocation restarts the tasks of an allocation, or only task if set.
+func (c *Cluster) RestartAllocation(id, task string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	a, ok := c.allocs[id]
+	if !ok {
+		return fmt.Errorf("alloc %q %w", id, ErrNotFound)
+	}
+
+	now := c.now()
+
+	for name, ts := range a.TaskStates {
+		if task != "" && name != task {
+			continue
+		}
+
+		ts.Restarts++
+		ts.LastRestart = now
+		ts.Events = append(ts.Events,
+			&api.TaskEvent{Type: "Restart Signaled", Time: now.UnixNano(), DisplayMessage: "User requested task to restart"},
+			&api.TaskEvent{Type: "Restarting", Time: now.UnixNano(), DisplayMessage: "Task restarting in 0s"},
+			&api.TaskEvent{Type: "Started", Time: now.UnixNano(), DisplayMessage: "Task started by client"},
+		)
+		c.writeLog(a.ID, name, "stdout", []byte(fmt.Sprintf("%s restarting %s\n", now.Format(time.RFC3339), name)))
+	}
+
+	a.ModifyIndex = c.bump()
+	a.ModifyTime = now.UnixNano()
+	c.publish(api.TopicAllocation, "AllocationUpdated", a.ID, a.Namespace, map[string]interface{}{"Allocation": a})
+
+	return nil
+}
+
+// SetChecks sets the service check results of an allocation.
+func (c *Cluster) SetChecks(allocID string, checks api.AllocCheckStatuses) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.allocs[allocID]; !ok {
+		return fmt.Errorf("alloc %q %w", allocID, ErrNotFound)
+	}
+
+	c.checks[allocID] = checks
+
+	return nil
+}
+
+// Checks returns the service check results of an allocation, none unless
+// set with SetChecks.
+func (c *Cluster) Checks(allocID string) (api.AllocCheckStatuses, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if _, ok := c.allocs[allocID]; !ok {
+		return nil, fmt.Errorf("alloc %q %w", allocID, ErrNotFound)
+	}
+
+	checks := api.AllocCheckStatuses{}
+	for id, check := range c.checks[allocID] {
+		checks[id] = check
+	}
+
+	return checks, nil
+}
+
+// SetAllocationStatus sets the client status of an allocation, e.g. to
+// simulate a failure, and updates its tasks accordingly.
+func (c *Cluster) SetAllocationStatus(id, clientStatus, description string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	a, ok := c.allocs[id]
+	if !ok {
+		return fmt.Errorf("alloc %q %w", id, ErrNotFound)
+	}
+
+	now := c.now()
+
+	a.ClientStatus = clientStatus
+	a.ClientDescription = description
+	a.ModifyIndex = c.bump()
+	a.ModifyTime = now.UnixNano()
+
+	for _, ts := range a.TaskStates {
+		switch clientStatus {
+		case api.AllocClientStatusRunning:
+			ts.State = "running"
+		case api.AllocClientStatusPending:
+			ts.State = "pending"
+		default:
+			ts.State = "dead"
+			ts.FinishedAt = now
+			ts.Failed = clientStatus == api.AllocClientStatusFailed
+		}
+
+		ts.Events = append(ts.Events, &api.TaskEvent{
+			Type:           "Terminated",
+			Time:           now.UnixNano(),
+			DisplayMessage: description,
+			FailsTask:      clientStatus == api.AllocClientStatusFailed,
+		})
+	}
+
+	if j := c.jobs[nsKey{a.Namespace, a.JobID}]; j != nil {
+		c.updateJobStatus(j)
+	}
+
+	c.publish(api.TopicAllocation, "AllocationUpdated", a.ID, a.Namespace, map[string]interface{}{"Allocation": a})
+
+	return nil
+}
+
+// UpdateDrain sets or clears the drain strategy of a node and migrates its allocations.
+func (c *Cluster) UpdateDrain(nodeID string, spec *api.DrainSpec, markEligible bool) (*api.NodeDrainUpdateResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	n, ok := c.nodes[nodeID]
+	if !ok {
+		return nil, fmt.Errorf("node %q %w", nodeID, ErrNotFound)
+	}
+
+	now := c.now()
+
+	if spec == nil {
+		n.Drain = false
+		n.DrainStrategy = nil
+
+		if markEligible {
+			n.SchedulingEligibility = api.NodeSchedulingEligible
+		}
+	} else {
+		n.Drain = true
+		n.SchedulingEligibility = api.NodeSchedulingIneligible
+		n.DrainStrategy = &api.DrainStrategy{DrainSpec: *spec, StartedAt: now}
+	}
+
+	n.ModifyIndex = c.bump()
+	c.publish(api.TopicNode, "NodeDrain", n.ID, "", map[string]interface{}{"Node": n})
+
+	var evalIDs []string
+
+	if spec != nil {
+		// Migrate the allocations right away, the drain completes at once
+		affected := map[nsKey]bool{}
+
+		for _, a := range c.allocs {
+			if a.NodeID == nodeID && a.DesiredStatus == api.AllocDesiredStatusRun {
+				c.stopAlloc(a, "alloc is being migrated")
+				affected[nsKey{a.Namespace, a.JobID}] = true
+			}
+		}
+
+		for key := range affected {
+			if j := c.jobs[key]; j != nil {
+				evalIDs = append(evalIDs, c.schedule(j, "node-drain").ID)
+			}
+		}
+
+		n.Drain = false
+		n.DrainStrategy = nil
+		n.LastDrain = &api.DrainMetadata{StartedAt: now, UpdatedAt: c.now(), Status: "complete"}
+	}
+
+	return &api.NodeDrainUpdateResponse{NodeModifyIndex: n.ModifyIndex, EvalIDs: evalIDs}, nil
+}
+
+// SetEligibility marks a node as eligible or ineligible for scheduling.
+func (c *Cluster) SetEligibility(nodeID string, eligible bool) (*api.NodeEligibilityUpdateResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	n, ok := c.nodes[nodeID]
+	if !ok {
+		return nil, fmt.Errorf("node %q %w", nodeID, ErrNotFound)
+	}
+
+	n.SchedulingEligibility = api.NodeSchedulingIneligible
+	if eligible {
+		n.SchedulingEligibility = api.NodeSchedulingEligible
+	}
+
+	n.ModifyIndex = c.bump()
+	c.publish(api.TopicNode, "NodeEligibility", n.ID, "", map[string]interface{}{"Node": n})
+
+	return &api.NodeEligibilityUpdateResponse{NodeModifyIndex: n.ModifyIndex}, nil
+}
+
+// PurgeNode removes a node and its allocations.
+func (c *Cluster) PurgeNode(nodeID string) (*api.NodePurgeResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	n, ok := c.nodes[nodeID]
+	if !ok {
+		return nil, fmt.Errorf("node %q %w", nodeID, ErrNotFound)
+	}
+
+	for id, a := range c.allocs {
+		if a.NodeID == nodeID {
+			delete(c.allocs, id)
+		}
+	}
+
+	delete(c.nodes, nodeID)
+	index := c.bump()
+	c.publish(api.TopicNode, "NodeDeregistration", n.ID, "", map[string]interface{}{"Node": n})
+
+	return &api.NodePurgeResponse{NodeModifyIndex: index}, nil
+}
+
+// UpdateDeployment applies a promote, fail or pause to a deployment.
+func (c *Cluster) UpdateDeployment(id, action string, pause bool) (*api.DeploymentUpdateResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	d, ok := c.deploys[id]
+	if !ok {
+		return nil, fmt.Errorf("deployment %q %w", id, ErrNotFound)
+	}
+
+	switch action {
+	case "promote":
+		for _, s := range d.TaskGroups {
+			s.Promoted = true
+		}
+	case "fail":
+		d.Status = api.DeploymentStatusFailed
+		d.StatusDescription = "Deployment marked as failed"
+	case "pause":
+		if pause {
+			d.Status = api.DeploymentStatusPaused
+			d.StatusDescription = "Deployment is paused"
+		} else {
+			d.Status = api.DeploymentStatusRunning
+			d.StatusDescription = "Deployment is running"
+		}
+	default:
+		return nil, fmt.Errorf("unknown deployment action %q", action)
+	}
+
+	d.ModifyIndex = c.bump()
+	d.ModifyTime = c.now().UnixNano()
+	c.publish(api.TopicDeployment, "DeploymentStatusUpdate", d.ID, d.Namespace, map[string]interface{}{"Deployment": d})
+
+	return &api.DeploymentUpdateResponse{DeploymentModifyIndex: d.ModifyIndex}, nil
+}
+
+// SetDeploymentState replaces the status and task group states of a
+// deployment, for tests of deployments still in progress.
+func (c *Cluster) SetDeploymentState(id, status string, groups map[string]*api.DeploymentState) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	d, ok := c.deploys[id]
+	if !ok {
+		return fmt.Errorf("deployment %q %w", id, ErrNotFound)
+	}
+
+	d.Status = status
+	d.StatusDescription = "Deployment is " + status
+	d.TaskGroups = groups
+	d.ModifyIndex = c.bump()
+	d.ModifyTime = c.now().UnixNano()
+	c.publish(api.TopicDeployment, "DeploymentStatusUpdate", d.ID, d.Namespace, map[string]interface{}{"Deployment": d})
+
+	return nil
+}
+
+// PutVariable creates or updates a variable.
+func (c *Cluster) PutVariable(v *api.Variable) *api.Variable {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	v = clone(v)
+	v.Namespace = namespaceOf(v.Namespace)
+
+	now := c.now().UnixNano()
+	index := c.bump()
+	key := nsKey{v.Namespace, v.Path}
+
+	if old, ok := c.variables[key]; ok {
+		v.CreateIndex = old.CreateIndex
+		v.CreateTime = old.CreateTime
+	} else {
+		v.CreateIndex = index
+		v.CreateTime = now
+	}
+
+	v.ModifyIndex = index
+	v.ModifyTime = now
+	c.variables[key] = v
+
+	return clone(v)
+}
+
+// DeleteVariable removes a variable.
+func (c *Cluster) DeleteVariable(namespace, path string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := nsKey{namespaceOf(namespace), path}
+	if _, ok := c.variables[key]; !ok {
+		return fmt.Errorf("variable %q %w", path, ErrNotFound)
+	}
+
+	delete(c.variables, key)
+	c.bump()
+
+	return nil
+}
+
+// jobSummary counts the allocations of a job by status per task group.
+// The caller holds the lock.
+func (c *Cluster) jobSummary(j *api.Job) *api.JobSummary {
+	summary := &api.JobSummary{
+		JobID:       *j.ID,
+		Namespace:   *j.Namespace,
+		Summary:     map[string]api.TaskGroupSummary{},
+		CreateIndex: *j.CreateIndex,
+		ModifyIndex: *j.ModifyIndex,
+	}
+
+	for _, tg := range j.TaskGroups {
+		summary.Summary[*tg.Name] = api.TaskGroupSummary{}
+	}
+
+	for _, a := range c.allocs {
+		if a.Namespace != *j.Namespace || a.JobID != *j.ID {
+			continue
+		}
+
+		s := summary.Summary[a.TaskGroup]
+
+		switch a.ClientStatus {
+		case api.AllocClientStatusRunning:
+			s.Running++
+		case api.AllocClientStatusPending:
+			s.Starting++
+		case api.AllocClientStatusComplete:
+			s.Complete++
+		case api.AllocClientStatusFailed:
+			s.Failed++
+		case api.AllocClientStatusLost:
+			s.Lost++
+		default:
+			s.Unknown++
+		}
+
+		summary.Summary[a.TaskGroup] = s
+	}
+
+	if j.IsParameterized() || j.IsPeriodic() {
+		children := &api.JobChildrenSummary{}
+
+		for _, child := range c.jobs {
+			if child.ParentID == nil || *child.ParentID != *j.ID || *child.Namespace != *j.Namespace {
+				continue
+			}
+
+			switch *child.Status {
+			case "running":
+				children.Running++
+			case "pending":
+				children.Pending++
+			default:
+				children.Dead++
+			}
+		}
+
+		summary.Children = children
+	}
+
+	return summary
+}
+
+// jobStub converts a job to its list representation. The caller holds the lock.
+func (c *Cluster) jobStub(j *api.Job) *api.JobListStub {
+	stub := &api.JobListStub{
+		ID:               *j.ID,
+		Name:             *j.Name,
+		Namespace:        *j.Namespace,
+		Datacenters:      j.Datacenters,
+		Type:             *j.Type,
+		Priority:         *j.Priority,
+		Periodic:         j.IsPeriodic(),
+		ParameterizedJob: j.IsParameterized(),
+		Stop:             j.Stop != nil && *j.Stop,
+		Status:           *j.Status,
+		JobSummary:       c.jobSummary(j),
+		CreateIndex:      *j.CreateIndex,
+		ModifyIndex:      *j.ModifyIndex,
+		JobModifyIndex:   *j.JobModifyIndex,
+		SubmitTime:       *j.SubmitTime,
+		Meta:             j.Meta,
+	}
+
+	if j.ParentID != nil {
+		stub.ParentID = *j.ParentID
+	}
+
+	return stub
+}
+
+func nodeStub(n *api.Node) *api.NodeListStub {
+	return &api.NodeListStub{
+		Address:               strings.Split(n.HTTPAddr, ":")[0],
+		ID:                    n.ID,
+		Attributes:            n.Attributes,
+		Datacenter:            n.Datacenter,
+		Name:                  n.Name,
+		NodeClass:             n.NodeClass,
+		NodePool:              n.NodePool,
+		Version:               n.Attributes["nomad.version"],
+		Drain:                 n.Drain,
+		SchedulingEligibility: n.SchedulingEligibility,
+		Status:                n.Status,
+		StatusDescription:     n.StatusDescription,
+		Drivers:               n.Drivers,
+		NodeResources:         n.NodeResources,
+		ReservedResources:     n.ReservedResources,
+		LastDrain:             n.LastDrain,
+		CreateIndex:           n.CreateIndex,
+		ModifyIndex:           n.ModifyIndex,
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// Plan returns what registering the job would change, without changing anything.
+func (c *Cluster) Plan(job *api.Job) (*api.JobPlanResponse, error) {
+	if job == nil || job.ID == nil || *job.ID == "" {
+		return nil, errors.New("job ID is required")
+	}
+
+	j := clone(job)
+	j.Canonicalize()
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	old := c.jobs[nsKey{*j.Namespace, *j.ID}]
+	resp := &api.JobPlanResponse{
+		Annotations: &api.PlanAnnotations{DesiredTGUpdates: map[string]*api.DesiredUpdates{}},
+		Diff:        &api.JobDiff{Type: "Added", ID: *j.ID},
+	}
+
+	if old != nil {
+		resp.JobModifyIndex = *old.JobModifyIndex
+		resp.Diff.Type = "None"
+
+		if !sameSpec(old, j) {
+			resp.Diff.Type = "Edited"
+		}
+	}
+
+	nodes := len(c.readyNodes())
+
+	for _, tg := range j.TaskGroups {
+		desired := *tg.Count
+		if *j.Type == api.JobTypeSystem || *j.Type == api.JobTypeSysbatch {
+			desired = nodes
+		}
+
+		u := &api.DesiredUpdates{}
+		resp.Annotations.DesiredTGUpdates[*tg.Name] = u
+
+		var oldGroup *api.TaskGroup
+		if old != nil {
+			oldGroup = old.LookupTaskGroup(*tg.Name)
+		}
+
+		if oldGroup == nil {
+			u.Place = uint64(desired)
+			continue
+		}
+
+		existing := 0
+
+		for _, a := range c.allocs {
+			if a.Namespace == *j.Namespace && a.JobID == *j.ID && a.TaskGroup == *tg.Name &&
+				a.DesiredStatus == api.AllocDesiredStatusRun {
+				existing++
+			}
+		}
+
+		kept := min(existing, desired)
+		if desired > existing {
+			u.Place = uint64(desired - existing)
+		} else {
+			u.Stop = uint64(existing - desired)
+		}
+
+		if sameTasks(oldGroup, tg) {
+			u.Ignore = uint64(kept)
+		} else {
+			u.DestructiveUpdate = uint64(kept)
+		}
+	}
+
+	if nodes == 0 {
+		resp.FailedTGAllocs = map[string]*api.AllocationMetric{}
+		for _, tg := range j.TaskGroups {
+			resp.FailedTGAllocs[*tg.Name] = &api.AllocationMetric{NodesEvaluated: 0}
+		}
+	}
+
+	return resp, nil
+}
+
+// Validate checks the parts of a job Nomad requires, such as a driver for
+// every task
+func (c *Cluster) Validate(job *api.Job) *api.JobValidateResponse {
+	resp := &api.JobValidateResponse{DriverConfigValidated: true}
+
+	switch {
+	case job == nil:
+		resp.ValidationErrors = append(resp.ValidationErrors, "job is required")
+	case job.ID == nil || *job.ID == "":
+		resp.ValidationErrors = append(resp.ValidationErrors, "Missing job ID")
+	case len(job.TaskGroups) == 0:
+		resp.ValidationErrors = append(resp.ValidationErrors, "Missing job task groups")
+	}
+
+	if job != nil {
+		for _, tg := range job.TaskGroups {
+			name := "<unnamed>"
+			if tg.Name != nil {
+				name = *tg.Name
+			}
+			if len(tg.Tasks) == 0 {
+				resp.ValidationErrors = append(resp.ValidationErrors, fmt.Sprintf("Task group %s has no tasks", name))
+			}
+			for _, t := range tg.Tasks {
+				if t.Driver == "" {
+					resp.ValidationErrors = append(resp.ValidationErrors, fmt.Sprintf("Task %s in group %s is missing a driver", t.Name, name))
+				}
+			}
+		}
+	}
+
+	if len(resp.ValidationErrors) > 0 {
+		resp.Error = strings.Join(resp.ValidationErrors, "; ")
+	}
+
+	return resp
+}
+
+// sameSpec compares the user controlled parts of two jobs
+func sameSpec(a, b *api.Job) bool {
+	strip := func(j *api.Job) string {
+		j = clone(j)
+		j.Version, j.CreateIndex, j.ModifyIndex, j.JobModifyIndex = nil, nil, nil, nil
+		j.SubmitTime, j.Stable, j.Status, j.StatusDescription = nil, nil, nil, nil
+
+		data, _ := json.Marshal(j)
+
+		return string(data)
+	}
+
+	return strip(a) == strip(b)
+}
+
+// allocIndex returns the index in an allocation name such as "web.web[2]"
+func allocIndex(name string) int {
+	i, err := strconv.Atoi(name[strings.LastIndex(name, "[")+1 : len(name)-1])
+	if err != nil {
+		return -1
+	}
+
+	return i
+}
+
+func sameTasks(a, b *api.TaskGroup) bool {
+	if a == nil || b == nil {
+		return false
+	}
+
+	ta, _ := json.Marshal(a.Tasks)
+	tb, _ := json.Marshal(b.Tasks)
+
+	return string(ta) == string(tb)
+}
+
+// RevertJob registers an earlier version of a job again.
+func (c *Cluster) RevertJob(namespace, id string, version uint64) (*api.JobRegisterResponse, error) {
+	c.mutex.RLock()
+
+	var target *api.Job
+
+	for _, v := range c.versions[nsKey{namespaceOf(namespace), id}] {
+		if *v.Version == version {
+			target = clone(v)
+		}
+	}
+
+	c.mutex.RUnlock()
+
+	if target == nil {
+		return nil, fmt.Errorf("job %q version %d %w", id, version, ErrNotFound)
+	}
+
+	target.Stop = ptr(false)
+
+	return c.RegisterJob(target)
+}
+
+// SetStable marks a job version as stable or unstable.
+func (c *Cluster) SetStable(namespace, id string, version uint64, stable bool) (uint64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	key := nsKey{namespaceOf(namespace), id}
+
+	for _, v := range c.versions[key] {
+		if *v.Version == version {
+			v.Stable = &stable
+
+			if j := c.jobs[key]; *j.Version == version {
+				j.Stable = &stable
+			}
+
+			return c.bump(), nil
+		}
+	}
+
+	return 0, fmt.Errorf("job %q version %d %w", id, version, ErrNotFound)
+}
+
+// EvaluateJob creates a new evaluation for a job, placing missing allocations.
+func (c *Cluster) EvaluateJob(namespace, id string) (*api.Evaluation, error) {
+	return c.EvaluateJobOpts(namespace, id, api.EvalOptions{})
+}
+
+// EvaluateJobOpts is EvaluateJob with options. With ForceReschedule, failed
+// allocations are replaced too.
+func (c *Cluster) EvaluateJobOpts(namespace, id string, opts api.EvalOptions) (*api.Evaluation, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	j, ok := c.jobs[nsKey{namespaceOf(namespace), id}]
+	if !ok {
+		return nil, fmt.Errorf("job %q %w", id, ErrNotFound)
+	}
+
+	if opts.ForceReschedule {
+		now := c.now()
+
+		for _, a := range c.allocs {
+			if a.Namespace != *j.Namespace || a.JobID != *j.ID || a.DesiredStatus != api.AllocDesiredStatusRun ||
+				a.ClientStatus != api.AllocClientStatusFailed {
+				continue
+			}
+
+			a.DesiredStatus = api.AllocDesiredStatusStop
+			a.DesiredDescription = "alloc was rescheduled because it failed"
+			a.ModifyIndex = c.bump()
+			a.ModifyTime = now.UnixNano()
+			c.publish(api.TopicAllocation, "AllocationUpdated", a.ID, a.Namespace, map[string]interface{}{"Allocation": a})
+		}
+	}
+
+	return clone(c.schedule(j, "job-register")), nil
+}
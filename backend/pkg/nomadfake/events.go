@@ -0,0 +1,160 @@
+package nomadfake
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// subscriber receives the events of the topics it subscribed to
+type subscriber struct {
+	topics    map[api.Topic][]string
+	namespace string
+	events    chan api.Event
+}
+
+// matches reports whether the subscriber wants an event
+func (s *subscriber) matches(e api.Event, namespace string) bool {
+	if namespace != "" && s.namespace != "*" && namespaceOf(s.namespace) != namespace {
+		return false
+	}
+
+	for _, topic := range []api.Topic{e.Topic, api.TopicAll} {
+		keys, ok := s.topics[topic]
+		if !ok {
+			continue
+		}
+
+		for _, key := range keys {
+			if key == "*" || key == e.Key || len(e.FilterKeys) > 1 && slices.Contains(e.FilterKeys[1:], key) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// publish records an event and hands it to the matching subscribers. The
+// payload is copied, so later changes to the objects do not leak into it.
+// The first filter key of an event is its namespace.
+// The caller holds the lock.
+func (c *Cluster) publish(topic api.Topic, typ, key, namespace string, payload map[string]interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		panic(err)
+	}
+
+	var copied map[string]interface{}
+	if err := json.Unmarshal(data, &copied); err != nil {
+		panic(err)
+	}
+
+	e := api.Event{
+		Topic:   topic,
+		Type:    typ,
+		Key:     key,
+		Index:   c.index,
+		Payload: copied,
+	}
+	if namespace != "" {
+		e.FilterKeys = []string{namespace}
+
+		// Like Nomad, the events of a job's evaluations, allocations and
+		// deployments can also be subscribed to by job and deployment ID
+		if obj, ok := copied[string(topic)].(map[string]interface{}); ok && topic != api.TopicJob {
+			for _, field := range []string{"JobID", "DeploymentID"} {
+				if id, _ := obj[field].(string); id != "" {
+					e.FilterKeys = append(e.FilterKeys, id)
+				}
+			}
+		}
+	}
+
+	c.events = append(c.events, e)
+	if len(c.events) > eventHistory {
+		c.events = c.events[len(c.events)-eventHistory:]
+	}
+
+	for s := range c.subscribers {
+		if !s.matches(e, namespace) {
+			continue
+		}
+
+		// A subscriber that does not keep up loses events rather than
+		// stalling the cluster
+		select {
+		case s.events <- e:
+		default:
+		}
+	}
+}
+
+// Publish publishes a custom event, e.g. to test how event consumers
+// handle event types the fake does not generate itself.
+func (c *Cluster) Publish(topic api.Topic, typ, key, namespace string, payload map[string]interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.bump()
+	c.publish(topic, typ, key, namespace, payload)
+}
+
+// subscribe registers a subscriber for topics given as Nomad's topic query
+// parameters ("Job", "Job:example", "*"). Events after index are replayed.
+func (c *Cluster) subscribe(topics []string, namespace string, index uint64) *subscriber {
+	s := &subscriber{
+		topics:    parseTopics(topics),
+		namespace: namespace,
+		events:    make(chan api.Event, eventHistory),
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if index > 0 {
+		for _, e := range c.events {
+			ns := ""
+			if len(e.FilterKeys) > 0 {
+				ns = e.FilterKeys[0]
+			}
+
+			if e.Index > index && s.matches(e, ns) {
+				s.events <- e
+			}
+		}
+	}
+
+	c.subscribers[s] = struct{}{}
+
+	return s
+}
+
+func (c *Cluster) unsubscribe(s *subscriber) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.subscribers, s)
+}
+
+func parseTopics(topics []string) map[api.Topic][]string {
+	parsed := map[api.Topic][]string{}
+
+	if len(topics) == 0 {
+		parsed[api.TopicAll] = []string{"*"}
+		return parsed
+	}
+
+	for _, t := range topics {
+		topic, key, found := strings.Cut(t, ":")
+		if !found {
+			key = "*"
+		}
+
+		parsed[api.Topic(topic)] = append(parsed[api.Topic(topic)], key)
+	}
+
+	return parsed
+}
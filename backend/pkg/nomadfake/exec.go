@@ -0,0 +1,347 @@
+package nomadfake
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coder/websocket"
+	"github.com/hashicorp/nomad/api"
+)
+
+// ExecRequest describes a command started in a task through the exec endpoint.
+type ExecRequest struct {
+	AllocID string
+	Task    string
+	Command []string
+	TTY     bool
+	// Env is the environment of the task
+	Env map[string]string
+}
+
+// ExecFunc runs a command for an exec session. It reads the command's input
+// from stdin, which ends when the client closes it, and returns the exit code.
+type ExecFunc func(ctx context.Context, req ExecRequest, stdin io.Reader, stdout, stderr io.Writer) int
+
+// SetExec replaces how exec sessions run commands; the default is Shell.
+func (c *Cluster) SetExec(fn ExecFunc) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.exec = fn
+}
+
+// execFrameWriter writes output to an exec WebSocket as Nomad's stdout or stderr frames
+type execFrameWriter struct {
+	ctx    context.Context
+	conn   *websocket.Conn
+	mutex  *sync.Mutex
+	stderr bool
+}
+
+func (w *execFrameWriter) Write(p []byte) (int, error) {
+	op := &api.ExecStreamingIOOperation{Data: p}
+
+	out := api.ExecStreamingOutput{Stdout: op}
+	if w.stderr {
+		out = api.ExecStreamingOutput{Stderr: op}
+	}
+
+	if err := w.send(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (w *execFrameWriter) send(out api.ExecStreamingOutput) error {
+	data, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.conn.Write(w.ctx, websocket.MessageText, data)
+}
+
+// execAllocation serves Nomad's exec WebSocket
+func (s *server) execAllocation(w http.ResponseWriter, r *http.Request) {
+	a, err := s.c.Allocation(r.PathValue("id"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	task := r.URL.Query().Get("task")
+	if _, ok := a.TaskStates[task]; !ok {
+		fail(w, fmt.Errorf("task %q %w", task, ErrNotFound))
+		return
+	}
+
+	var command []string
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("command")), &command); err != nil || len(command) == 0 {
+		http.Error(w, "command is not a valid JSON array", http.StatusBadRequest)
+		return
+	}
+
+	s.serveExec(w, r, execRequest(a, task, command, r.URL.Query().Get("tty") == "true"))
+}
+
+// jobAction serves Nomad's action WebSocket, which runs a command declared
+// in a task's action block like an exec session does
+func (s *server) jobAction(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	j, err := s.c.Job(namespace(r), r.PathValue("id"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	a, err := s.c.Allocation(q.Get("allocID"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+	if a.Namespace != *j.Namespace || a.JobID != *j.ID {
+		fail(w, fmt.Errorf("allocation %s does not belong to job %s", a.ID, *j.ID))
+		return
+	}
+
+	task := q.Get("task")
+	var action *api.Action
+	for _, tg := range j.TaskGroups {
+		if *tg.Name != a.TaskGroup {
+			continue
+		}
+		for _, t := range tg.Tasks {
+			if t.Name != task {
+				continue
+			}
+			for _, act := range t.Actions {
+				if act.Name == q.Get("action") {
+					action = act
+				}
+			}
+		}
+	}
+	if action == nil {
+		fail(w, fmt.Errorf("action %q of task %q %w", q.Get("action"), task, ErrNotFound))
+		return
+	}
+
+	command := append([]string{action.Command}, action.Args...)
+	s.serveExec(w, r, execRequest(a, task, command, q.Get("tty") == "true"))
+}
+
+func execRequest(a *api.Allocation, task string, command []string, tty bool) ExecRequest {
+	return ExecRequest{
+		AllocID: a.ID,
+		Task:    task,
+		Command: command,
+		TTY:     tty,
+		Env: map[string]string{
+			"NOMAD_ALLOC_ID":   a.ID,
+			"NOMAD_ALLOC_NAME": a.Name,
+			"NOMAD_JOB_NAME":   a.JobID,
+			"NOMAD_GROUP_NAME": a.TaskGroup,
+			"NOMAD_TASK_NAME":  task,
+			"NOMAD_NAMESPACE":  a.Namespace,
+			"HOSTNAME":         a.NodeName,
+		},
+	}
+}
+
+// serveExec runs req over an exec WebSocket: stdin frames are fed to the
+// ExecFunc, its output is sent back as frames and its exit code ends the
+// session
+func (s *server) serveExec(w http.ResponseWriter, r *http.Request, req ExecRequest) {
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		InsecureSkipVerify: true,
+		CompressionMode:    websocket.CompressionContextTakeover,
+	})
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	stdin, stdinWriter := io.Pipe()
+
+	// Feed stdin frames to the command. A lost connection ends the session.
+	go func() {
+		defer cancel()
+
+		for {
+			_, data, err := conn.Read(ctx)
+			if err != nil {
+				stdinWriter.CloseWithError(err)
+				return
+			}
+
+			var in api.ExecStreamingInput
+			if err := json.Unmarshal(data, &in); err != nil || in.Stdin == nil {
+				continue
+			}
+
+			if len(in.Stdin.Data) > 0 {
+				if _, err := stdinWriter.Write(in.Stdin.Data); err != nil {
+					return
+				}
+			}
+
+			if in.Stdin.Close {
+				stdinWriter.Close()
+			}
+		}
+	}()
+
+	s.c.mutex.RLock()
+	exec := s.c.exec
+	s.c.mutex.RUnlock()
+
+	writeMutex := &sync.Mutex{}
+	stdout := &execFrameWriter{ctx: ctx, conn: conn, mutex: writeMutex}
+	stderr := &execFrameWriter{ctx: ctx, conn: conn, mutex: writeMutex, stderr: true}
+
+	code := exec(ctx, req, stdin, stdout, stderr)
+	stdin.Close()
+
+	if err := stdout.send(api.ExecStreamingOutput{Exited: true, Result: &api.ExecStreamingExitResult{ExitCode: code}}); err != nil {
+		return
+	}
+
+	conn.Close(websocket.StatusNormalClosure, "")
+}
+
+// shells are the commands Shell treats as an interactive shell
+var shells = map[string]bool{"sh": true, "bash": true, "/bin/sh": true, "/bin/bash": true, "/bin/ash": true}
+
+// Shell is the default ExecFunc. It behaves like a tiny shell with a few
+// built-in commands (echo, cat, env, hostname, pwd, ls, exit): started as a
+// shell it runs the lines read from stdin, otherwise it runs the command
+// itself. With a TTY, input is echoed back like a terminal does.
+func Shell(ctx context.Context, req ExecRequest, stdin io.Reader, stdout, stderr io.Writer) int {
+	if !shells[req.Command[0]] {
+		return builtin(req, req.Command, stdin, stdout, stderr)
+	}
+
+	newline := "\n"
+	if req.TTY {
+		newline = "\r\n"
+	}
+
+	prompt := func() {
+		if req.TTY {
+			fmt.Fprintf(stdout, "/ # ")
+		}
+	}
+
+	prompt()
+
+	lines := bufio.NewReader(stdin)
+	var line strings.Builder
+
+	for ctx.Err() == nil {
+		b, err := lines.ReadByte()
+		if err != nil {
+			return 0
+		}
+
+		if b != '\r' && b != '\n' {
+			if req.TTY {
+				stdout.Write([]byte{b})
+			}
+
+			line.WriteByte(b)
+
+			continue
+		}
+
+		if req.TTY {
+			io.WriteString(stdout, newline)
+		}
+
+		args := strings.Fields(line.String())
+		line.Reset()
+
+		if len(args) > 0 && args[0] == "exit" {
+			code := 0
+			if len(args) > 1 {
+				code, _ = strconv.Atoi(args[1])
+			}
+
+			return code
+		}
+
+		if len(args) > 0 {
+			builtin(req, args, nil, &newlineWriter{w: stdout, newline: newline}, &newlineWriter{w: stderr, newline: newline})
+		}
+
+		prompt()
+	}
+
+	return 130
+}
+
+// newlineWriter translates line endings for a terminal
+type newlineWriter struct {
+	w       io.Writer
+	newline string
+}
+
+func (w *newlineWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, strings.ReplaceAll(string(p), "\n", w.newline)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// builtin runs one of Shell's commands
+func builtin(req ExecRequest, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	switch args[0] {
+	case "echo":
+		fmt.Fprintln(stdout, strings.Join(args[1:], " "))
+	case "cat":
+		if stdin != nil {
+			io.Copy(stdout, stdin)
+		}
+	case "env":
+		keys := make([]string, 0, len(req.Env))
+		for k := range req.Env {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			fmt.Fprintf(stdout, "%s=%s\n", k, req.Env[k])
+		}
+	case "hostname":
+		fmt.Fprintln(stdout, req.Env["HOSTNAME"])
+	case "pwd":
+		fmt.Fprintln(stdout, "/")
+	case "ls":
+		fmt.Fprintln(stdout, "alloc\nlocal\nsecrets")
+	case "true":
+	case "false":
+		return 1
+	default:
+		fmt.Fprintf(stderr, "sh: %s: not found\n", args[0])
+		return 127
+	}
+
+	return 0
+}
@@ -0,0 +1,134 @@
+package nomadfake
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// NewDemo creates a cluster seeded with a small, realistic workload: three
+// client nodes in two datacenters and a mix of service, batch, system,
+// periodic and parameterized jobs in two namespaces.
+func NewDemo() *Cluster {
+	c := New()
+	c.AddNamespace("platform", "Shared platform services")
+
+	for i, dc := range []string{"dc1", "dc1", "dc2"} {
+		c.UpsertNode(&api.Node{
+			Name:       fmt.Sprintf("demo-client-%d", i+1),
+			Datacenter: dc,
+			HTTPAddr:   "",
+			Drivers: map[string]*api.DriverInfo{
+				"docker": {Detected: true, Healthy: true},
+				"exec":   {Detected: true, Healthy: true},
+			},
+			Attributes: map[string]string{
+				"kernel.name":       "linux",
+				"os.name":           "ubuntu",
+				"cpu.arch":          "amd64",
+				"nomad.version":     "1.9.0",
+				"unique.hostname":   fmt.Sprintf("demo-client-%d", i+1),
+				"unique.network.ip": fmt.Sprintf("10.0.0.%d", i+11),
+			},
+			NodeResources: &api.NodeResources{
+				Cpu:    api.NodeCpuResources{CpuShares: 8000, TotalCpuCores: 4},
+				Memory: api.NodeMemoryResources{MemoryMB: 16384},
+				Disk:   api.NodeDiskResources{DiskMB: 102400},
+			},
+		})
+	}
+
+	for _, j := range demoJobs() {
+		if _, err := c.RegisterJob(j); err != nil {
+			panic(err)
+		}
+	}
+
+	for _, a := range c.Allocations("*", "") {
+		for task := range a.TaskStates {
+			for i := 0; i < 20; i++ {
+				c.AppendLog(a.ID, task, "stdout", []byte(demoLogLine(a.JobID, i)))
+			}
+		}
+
+		c.WriteFile(a.ID, "alloc/data/README", []byte("Demo allocation data directory\n"))
+	}
+
+	return c
+}
+
+func demoTask(name, image string, cpu, memory int) *api.Task {
+	return &api.Task{
+		Name:   name,
+		Driver: "docker",
+		Config: map[string]interface{}{"image": image},
+		Resources: &api.Resources{
+			CPU:      ptr(cpu),
+			MemoryMB: ptr(memory),
+		},
+	}
+}
+
+func demoGroup(name string, count int, tasks ...*api.Task) *api.TaskGroup {
+	tg := api.NewTaskGroup(name, count)
+	tg.Tasks = tasks
+
+	return tg
+}
+
+func demoJobs() []*api.Job {
+	web := api.NewServiceJob("web", "web", "global", 50)
+	web.Datacenters = []string{"dc1", "dc2"}
+	webGroup := demoGroup("frontend", 3, demoTask("nginx", "nginx:1.27", 200, 128))
+	webGroup.Services = []*api.Service{{Name: "web", Provider: "nomad", PortLabel: "http", Tags: []string{"http", "public"}}}
+	web.TaskGroups = []*api.TaskGroup{webGroup}
+	web.Update = &api.UpdateStrategy{MaxParallel: ptr(1), Canary: ptr(1), AutoRevert: ptr(true)}
+
+	apiJob := api.NewServiceJob("api", "api", "global", 50)
+	apiJob.Datacenters = []string{"dc1"}
+	apiGroup := demoGroup("api", 2, demoTask("server", "ghcr.io/example/api:2.3.1", 500, 256))
+	apiGroup.Services = []*api.Service{{Name: "api", Provider: "nomad", PortLabel: "http", Tags: []string{"http"}}}
+	apiJob.TaskGroups = []*api.TaskGroup{apiGroup}
+	apiJob.Meta = map[string]string{"team": "backend"}
+
+	redis := api.NewServiceJob("redis", "redis", "global", 70)
+	redis.Namespace = ptr("platform")
+	redis.Datacenters = []string{"dc1"}
+	redis.TaskGroups = []*api.TaskGroup{demoGroup("cache", 1, demoTask("redis", "redis:7", 300, 512))}
+
+	logs := api.NewSystemJob("log-shipper", "log-shipper", "global", 80)
+	logs.Namespace = ptr("platform")
+	logs.Datacenters = []string{"dc1", "dc2"}
+	logs.TaskGroups = []*api.TaskGroup{demoGroup("shipper", 1, demoTask("vector", "timberio/vector:0.40", 100, 64))}
+
+	migrate := api.NewBatchJob("db-migrate", "db-migrate", "global", 50)
+	migrate.Datacenters = []string{"dc1"}
+	migrate.TaskGroups = []*api.TaskGroup{demoGroup("migrate", 1, demoTask("migrate", "ghcr.io/example/api:2.3.1", 100, 128))}
+
+	backup := api.NewBatchJob("backup", "backup", "global", 50)
+	backup.Datacenters = []string{"dc1"}
+	backup.Periodic = &api.PeriodicConfig{Enabled: ptr(true), Spec: ptr("0 3 * * *"), SpecType: ptr("cron"), ProhibitOverlap: ptr(true)}
+	backup.TaskGroups = []*api.TaskGroup{demoGroup("backup", 1, demoTask("restic", "restic/restic:0.17", 200, 256))}
+
+	report := api.NewBatchJob("report", "report", "global", 50)
+	report.Datacenters = []string{"dc1"}
+	report.ParameterizedJob = &api.ParameterizedJobConfig{Payload: "optional", MetaRequired: []string{"customer"}}
+	report.TaskGroups = []*api.TaskGroup{demoGroup("render", 1, demoTask("render", "ghcr.io/example/report:1.0", 200, 256))}
+
+	return []*api.Job{web, apiJob, redis, logs, migrate, backup, report}
+}
+
+// demoLogLines are the messages task logs are made of
+var demoLogLines = []string{
+	"GET /healthz 200 0.4ms",
+	"GET /api/v1/items 200 12.1ms",
+	"POST /api/v1/orders 201 48.7ms",
+	"cache hit ratio 0.93",
+	"connection pool: 12 active, 4 idle",
+	"GET /api/v1/items/42 404 1.2ms",
+	"background sync completed in 230ms",
+}
+
+func demoLogLine(jobID string, n int) string {
+	return fmt.Sprintf("[%s] %s\n", jobID, demoLogLines[n%len(demoLogLines)])
+}
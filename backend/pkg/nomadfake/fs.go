@@ -0,0 +1,501 @@
+package nomadfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// maxLogSize caps each task log; older output is dropped like Nomad rotates logs
+const maxLogSize = 1 << 20
+
+// logBuffer is the output of one task stream, which followers wait on
+type logBuffer struct {
+	mutex sync.Mutex
+	data  []byte
+	// dropped counts the bytes cut from the front, so offsets stay stable
+	dropped int64
+	changed chan struct{}
+}
+
+func newLogBuffer() *logBuffer {
+	return &logBuffer{changed: make(chan struct{})}
+}
+
+func (b *logBuffer) append(p []byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.data = append(b.data, p...)
+	if over := len(b.data) - maxLogSize; over > 0 {
+		b.data = append([]byte(nil), b.data[over:]...)
+		b.dropped += int64(over)
+	}
+
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// readFrom returns the data from the absolute offset on, the offset of the
+// end and a channel closed on the next write
+func (b *logBuffer) readFrom(offset int64) ([]byte, int64, <-chan struct{}) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	end := b.dropped + int64(len(b.data))
+
+	start := offset - b.dropped
+	if start < 0 {
+		start = 0
+	}
+
+	if start > int64(len(b.data)) {
+		start = int64(len(b.data))
+	}
+
+	return append([]byte(nil), b.data[start:]...), end, b.changed
+}
+
+func (b *logBuffer) size() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.dropped + int64(len(b.data))
+}
+
+func logKey(allocID, task, logType string) string {
+	return allocID + "/" + task + "/" + logType
+}
+
+// writeLog appends to a task log. The caller holds the lock.
+func (c *Cluster) writeLog(allocID, task, logType string, p []byte) {
+	key := logKey(allocID, task, logType)
+
+	b, ok := c.logs[key]
+	if !ok {
+		b = newLogBuffer()
+		c.logs[key] = b
+	}
+
+	b.append(p)
+	c.fileWritten()
+}
+
+// AppendLog appends output to the stdout or stderr log of a task.
+func (c *Cluster) AppendLog(allocID, task, logType string, p []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.allocs[allocID]; !ok {
+		return fmt.Errorf("alloc %q %w", allocID, ErrNotFound)
+	}
+
+	c.writeLog(allocID, task, logType, p)
+
+	return nil
+}
+
+func (c *Cluster) logBuffer(allocID, task, logType string) (*logBuffer, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	a, ok := c.allocs[allocID]
+	if !ok {
+		return nil, fmt.Errorf("alloc %q %w", allocID, ErrNotFound)
+	}
+
+	if _, ok := a.TaskStates[task]; !ok {
+		return nil, fmt.Errorf("task %q %w", task, ErrNotFound)
+	}
+
+	key := logKey(allocID, task, logType)
+	if _, ok := c.logs[key]; !ok {
+		c.logs[key] = newLogBuffer()
+	}
+
+	return c.logs[key], nil
+}
+
+// WriteFile puts a file into the file system of an allocation.
+func (c *Cluster) WriteFile(allocID, name string, data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.allocs[allocID]; !ok {
+		return fmt.Errorf("alloc %q %w", allocID, ErrNotFound)
+	}
+
+	if c.files[allocID] == nil {
+		c.files[allocID] = map[string][]byte{}
+	}
+
+	c.files[allocID][cleanPath(name)] = append([]byte(nil), data...)
+	c.fileWritten()
+
+	return nil
+}
+
+// AppendFile appends to a file in the file system of an allocation,
+// creating it if needed.
+func (c *Cluster) AppendFile(allocID, name string, data []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.allocs[allocID]; !ok {
+		return fmt.Errorf("alloc %q %w", allocID, ErrNotFound)
+	}
+
+	if c.files[allocID] == nil {
+		c.files[allocID] = map[string][]byte{}
+	}
+
+	name = cleanPath(name)
+	c.files[allocID][name] = append(append([]byte(nil), c.files[allocID][name]...), data...)
+	c.fileWritten()
+
+	return nil
+}
+
+// RemoveFile deletes a file from the file system of an allocation.
+func (c *Cluster) RemoveFile(allocID, name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.files[allocID], cleanPath(name))
+	c.fileWritten()
+}
+
+// fileWritten wakes the file streams. The caller holds the lock.
+func (c *Cluster) fileWritten() {
+	close(c.filesChanged)
+	c.filesChanged = make(chan struct{})
+}
+
+func cleanPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// allocFiles returns the files of an allocation: the written ones and the
+// task logs under alloc/logs. The caller holds the lock.
+func (c *Cluster) allocFiles(a *api.Allocation) map[string][]byte {
+	files := map[string][]byte{}
+
+	for name, data := range c.files[a.ID] {
+		files[name] = data
+	}
+
+	for task := range a.TaskStates {
+		for _, logType := range []string{"stdout", "stderr"} {
+			var data []byte
+			if b, ok := c.logs[logKey(a.ID, task, logType)]; ok {
+				data, _, _ = b.readFrom(0)
+			}
+
+			files[fmt.Sprintf("alloc/logs/%s.%s.0", task, logType)] = data
+		}
+
+		// Tasks always have these directories, even if they are empty
+		files[task+"/local/.keep"] = nil
+		files[task+"/secrets/.keep"] = nil
+	}
+
+	return files
+}
+
+// listDir lists a directory of an allocation's file system.
+func (c *Cluster) listDir(allocID, dir string) ([]*api.AllocFileInfo, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	a, ok := c.allocs[allocID]
+	if !ok {
+		return nil, fmt.Errorf("alloc %q %w", allocID, ErrNotFound)
+	}
+
+	dir = cleanPath(dir)
+	prefix := dir + "/"
+
+	if dir == "" {
+		prefix = ""
+	}
+
+	modTime := time.Unix(0, a.CreateTime)
+	entries := map[string]*api.AllocFileInfo{}
+
+	for name, data := range c.allocFiles(a) {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(name, prefix)
+		child, _, isDir := strings.Cut(rest, "/")
+
+		if child == ".keep" {
+			continue
+		}
+
+		if isDir {
+			entries[child] = &api.AllocFileInfo{Name: child, IsDir: true, FileMode: "drwxrwxrwx", ModTime: modTime}
+			continue
+		}
+
+		entries[child] = &api.AllocFileInfo{
+			Name:        child,
+			Size:        int64(len(data)),
+			FileMode:    "-rw-r--r--",
+			ModTime:     modTime,
+			ContentType: "text/plain; charset=utf-8",
+		}
+	}
+
+	if len(entries) == 0 && dir != "" {
+		return nil, fmt.Errorf("directory %q %w", dir, ErrNotFound)
+	}
+
+	list := make([]*api.AllocFileInfo, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+// readFile returns the content of a file in an allocation's file system.
+func (c *Cluster) readFile(allocID, name string) ([]byte, error) {
+	data, _, err := c.watchFile(allocID, name)
+	return data, err
+}
+
+// watchFile returns the content of a file in an allocation's file system
+// and a channel closed on the next write to a file.
+func (c *Cluster) watchFile(allocID, name string) ([]byte, <-chan struct{}, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	a, ok := c.allocs[allocID]
+	if !ok {
+		return nil, c.filesChanged, fmt.Errorf("alloc %q %w", allocID, ErrNotFound)
+	}
+
+	data, ok := c.allocFiles(a)[cleanPath(name)]
+	if !ok {
+		return nil, c.filesChanged, fmt.Errorf("file %q %w", name, ErrNotFound)
+	}
+
+	return data, c.filesChanged, nil
+}
+
+func (s *server) listFiles(w http.ResponseWriter, r *http.Request) {
+	list, err := s.c.listDir(r.PathValue("id"), r.URL.Query().Get("path"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, list)
+}
+
+func (s *server) statFile(w http.ResponseWriter, r *http.Request) {
+	name := cleanPath(r.URL.Query().Get("path"))
+
+	data, err := s.c.readFile(r.PathValue("id"), name)
+	if err == nil {
+		a, _ := s.c.Allocation(r.PathValue("id"))
+		s.reply(w, &api.AllocFileInfo{
+			Name:        path.Base(name),
+			Size:        int64(len(data)),
+			FileMode:    "-rw-r--r--",
+			ModTime:     time.Unix(0, a.CreateTime),
+			ContentType: "text/plain; charset=utf-8",
+		})
+
+		return
+	}
+
+	// Not a file, but maybe a directory
+	if _, dirErr := s.c.listDir(r.PathValue("id"), name); dirErr != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, &api.AllocFileInfo{Name: path.Base(name), IsDir: true, FileMode: "drwxrwxrwx"})
+}
+
+func (s *server) catFile(w http.ResponseWriter, r *http.Request) {
+	data, err := s.c.readFile(r.PathValue("id"), r.URL.Query().Get("path"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	w.Write(data)
+}
+
+func (s *server) readAtFile(w http.ResponseWriter, r *http.Request) {
+	data, err := s.c.readFile(r.PathValue("id"), r.URL.Query().Get("path"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	limit, _ := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64)
+
+	if offset < 0 || offset > int64(len(data)) {
+		http.Error(w, "offset out of range", http.StatusBadRequest)
+		return
+	}
+
+	data = data[offset:]
+	if limit > 0 && limit < int64(len(data)) {
+		data = data[:limit]
+	}
+
+	w.Write(data)
+}
+
+// streamFile streams a file as Nomad does: as JSON stream frames from the
+// offset on, then what is written to it until the client goes away. Frames
+// with a file event tell when the file is truncated or deleted.
+func (s *server) streamFile(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	id, name := r.PathValue("id"), q.Get("path")
+
+	data, changed, err := s.c.watchFile(id, name)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	offset, _ := strconv.ParseInt(q.Get("offset"), 10, 64)
+	if q.Get("origin") == "end" {
+		offset = int64(len(data)) - offset
+	}
+	offset = max(0, min(offset, int64(len(data))))
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	send := func(frame *api.StreamFrame) bool {
+		frame.File = name
+		if err := enc.Encode(frame); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	for {
+		switch {
+		case err != nil:
+			send(&api.StreamFrame{Offset: offset, FileEvent: "file deleted"})
+			return
+		case int64(len(data)) < offset:
+			offset = 0
+			if !send(&api.StreamFrame{FileEvent: "file truncated"}) {
+				return
+			}
+		}
+
+		if int64(len(data)) > offset {
+			chunk := data[offset:]
+			offset = int64(len(data))
+			if !send(&api.StreamFrame{Offset: offset, Data: chunk}) {
+				return
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+
+		data, changed, err = s.c.watchFile(id, name)
+	}
+}
+
+// logs streams a task log as Nomad does: as JSON stream frames, or as raw
+// bytes with plain=true. With follow=true it keeps streaming new output.
+func (s *server) logs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	logType := q.Get("type")
+	if logType != "stdout" && logType != "stderr" {
+		http.Error(w, "must provide a valid log type (stdout or stderr)", http.StatusBadRequest)
+		return
+	}
+
+	b, err := s.c.logBuffer(r.PathValue("id"), q.Get("task"), logType)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	offset, _ := strconv.ParseInt(q.Get("offset"), 10, 64)
+	if q.Get("origin") == "end" {
+		offset = b.size() - offset
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	follow := q.Get("follow") == "true"
+	plain := q.Get("plain") == "true"
+	file := fmt.Sprintf("alloc/logs/%s.%s.0", q.Get("task"), logType)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		data, end, changed := b.readFrom(offset)
+
+		if len(data) > 0 {
+			if plain {
+				_, err = w.Write(data)
+			} else {
+				err = enc.Encode(&api.StreamFrame{Offset: end, Data: data, File: file})
+			}
+
+			if err != nil {
+				return
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		offset = end
+
+		if !follow {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
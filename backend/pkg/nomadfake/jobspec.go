@@ -0,0 +1,125 @@
+package nomadfake
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+var (
+	blockRe = regexp.MustCompile(`^([a-z_]+)(?:\s+"([^"]*)")?\s*\{$`)
+	attrRe  = regexp.MustCompile(`^([a-z_]+)\s*=\s*(.+)$`)
+)
+
+// parseJobspec parses the part of the HCL jobspec the fake understands: the
+// job with its namespace, region, type and datacenters, its groups with
+// their count and their tasks with their driver. Other blocks and
+// attributes are skipped. Variables are not supported.
+func parseJobspec(src string) (*api.Job, error) {
+	var (
+		job   *api.Job
+		group *api.TaskGroup
+		task  *api.Task
+		// stack holds the names of the open blocks
+		stack []string
+	)
+
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if j := strings.Index(line, "#"); j >= 0 && !strings.Contains(line[:j], `"`) {
+			line = strings.TrimSpace(line[:j])
+		}
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		if line == "}" {
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: unexpected }", i+1)
+			}
+			switch stack[len(stack)-1] {
+			case "group":
+				group = nil
+			case "task":
+				task = nil
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		if m := blockRe.FindStringSubmatch(line); m != nil {
+			switch {
+			case m[1] == "job" && len(stack) == 0:
+				if job != nil {
+					return nil, fmt.Errorf("line %d: only one job is allowed", i+1)
+				}
+				job = api.NewServiceJob(m[2], m[2], "global", 50)
+			case m[1] == "group" && len(stack) == 1 && job != nil:
+				group = api.NewTaskGroup(m[2], 1)
+				job.AddTaskGroup(group)
+			case m[1] == "task" && len(stack) == 2 && group != nil:
+				task = api.NewTask(m[2], "")
+				group.AddTask(task)
+			}
+			stack = append(stack, m[1])
+			continue
+		}
+
+		m := attrRe.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: invalid syntax", i+1)
+		}
+
+		name, value := m[1], m[2]
+		var err error
+		switch {
+		case len(stack) == 1 && job != nil:
+			switch name {
+			case "namespace":
+				job.Namespace, err = stringAttr(value)
+			case "region":
+				job.Region, err = stringAttr(value)
+			case "type":
+				job.Type, err = stringAttr(value)
+			case "datacenters":
+				job.Datacenters = strings.FieldsFunc(strings.Trim(value, "[]"), func(r rune) bool {
+					return r == ',' || r == ' ' || r == '"'
+				})
+			}
+		case len(stack) == 2 && group != nil && name == "count":
+			var count int
+			count, err = strconv.Atoi(value)
+			group.Count = &count
+		case len(stack) == 3 && task != nil && name == "driver":
+			var driver *string
+			driver, err = stringAttr(value)
+			if driver != nil {
+				task.Driver = *driver
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s: %w", i+1, name, err)
+		}
+	}
+
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("unclosed %s block", stack[len(stack)-1])
+	}
+	if job == nil {
+		return nil, fmt.Errorf("no job block")
+	}
+
+	return job, nil
+}
+
+func stringAttr(value string) (*string, error) {
+	s, err := strconv.Unquote(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
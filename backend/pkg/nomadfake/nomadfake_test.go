@@ -0,0 +1,213 @@
+package nomadfake_test
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadfake"
+	"github.com/hashicorp/nomad/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClient(t *testing.T, c *nomadfake.Cluster) *api.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(c.Handler())
+	t.Cleanup(srv.Close)
+
+	client, err := api.NewClient(&api.Config{Address: srv.URL})
+	require.NoError(t, err)
+
+	return client
+}
+
+func TestDemoCluster(t *testing.T) {
+	client := newClient(t, nomadfake.NewDemo())
+
+	jobs, _, err := client.Jobs().List(&api.QueryOptions{Namespace: "*"})
+	require.NoError(t, err)
+	assert.Len(t, jobs, 7)
+
+	nodes, _, err := client.Nodes().List(nil)
+	require.NoError(t, err)
+	assert.Len(t, nodes, 3)
+
+	allocs, _, err := client.Jobs().Allocations("web", false, nil)
+	require.NoError(t, err)
+	assert.Len(t, allocs, 3)
+
+	system, _, err := client.Jobs().Allocations("log-shipper", false, &api.QueryOptions{Namespace: "platform"})
+	require.NoError(t, err)
+	assert.Len(t, system, 3, "system jobs run on every node")
+
+	periodic, _, err := client.Jobs().Allocations("backup", false, nil)
+	require.NoError(t, err)
+	assert.Empty(t, periodic, "periodic parents do not run themselves")
+}
+
+func TestRegisterScaleDeregister(t *testing.T) {
+	c := nomadfake.New()
+	c.UpsertNode(&api.Node{Name: "client-1"})
+	client := newClient(t, c)
+
+	job := api.NewServiceJob("app", "app", "global", 50)
+	job.AddTaskGroup(api.NewTaskGroup("app", 2).AddTask(api.NewTask("app", "docker")))
+
+	resp, _, err := client.Jobs().Register(job, nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.EvalID)
+
+	allocs, _, err := client.Jobs().Allocations("app", false, nil)
+	require.NoError(t, err)
+	assert.Len(t, allocs, 2)
+
+	_, _, err = client.Jobs().Scale("app", "app", ptr(4), "", false, nil, nil)
+	require.NoError(t, err)
+
+	status, _, err := client.Jobs().ScaleStatus("app", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 4, status.TaskGroups["app"].Desired)
+	assert.Equal(t, 4, status.TaskGroups["app"].Running)
+
+	versions, _, _, err := client.Jobs().Versions("app", false, nil)
+	require.NoError(t, err)
+	assert.Len(t, versions, 2)
+
+	_, _, err = client.Jobs().Deregister("app", true, nil)
+	require.NoError(t, err)
+
+	_, _, err = client.Jobs().Info("app", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "404")
+}
+
+func TestBlockingQuery(t *testing.T) {
+	c := nomadfake.New()
+	client := newClient(t, c)
+
+	_, meta, err := client.Nodes().List(nil)
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		c.UpsertNode(&api.Node{Name: "late"})
+	}()
+
+	nodes, next, err := client.Nodes().List(&api.QueryOptions{WaitIndex: meta.LastIndex, WaitTime: 5 * time.Second})
+	require.NoError(t, err)
+	assert.Len(t, nodes, 1)
+	assert.Greater(t, next.LastIndex, meta.LastIndex)
+}
+
+func TestEventStream(t *testing.T) {
+	c := nomadfake.New()
+	c.UpsertNode(&api.Node{Name: "client-1"})
+	client := newClient(t, c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.EventStream().Stream(ctx, map[api.Topic][]string{api.TopicJob: {"*"}}, 0, nil)
+	require.NoError(t, err)
+
+	job := api.NewBatchJob("once", "once", "global", 50)
+	job.AddTaskGroup(api.NewTaskGroup("once", 1).AddTask(api.NewTask("once", "exec")))
+
+	_, _, err = client.Jobs().Register(job, nil)
+	require.NoError(t, err)
+
+	for events := range stream {
+		require.NoError(t, events.Err)
+
+		for _, e := range events.Events {
+			assert.Equal(t, api.TopicJob, e.Topic)
+			assert.Equal(t, "once", e.Key)
+
+			return
+		}
+	}
+
+	t.Fatal("stream closed without a job event")
+}
+
+func TestLogsAndFiles(t *testing.T) {
+	c := nomadfake.New()
+	c.UpsertNode(&api.Node{Name: "client-1"})
+	client := newClient(t, c)
+
+	job := api.NewServiceJob("app", "app", "global", 50)
+	job.AddTaskGroup(api.NewTaskGroup("app", 1).AddTask(api.NewTask("web", "docker")))
+
+	_, _, err := client.Jobs().Register(job, nil)
+	require.NoError(t, err)
+
+	stubs, _, err := client.Jobs().Allocations("app", false, nil)
+	require.NoError(t, err)
+	require.Len(t, stubs, 1)
+
+	require.NoError(t, c.AppendLog(stubs[0].ID, "web", "stdout", []byte("hello from the fake\n")))
+	require.NoError(t, c.WriteFile(stubs[0].ID, "web/local/config.yml", []byte("port: 8080\n")))
+
+	alloc, _, err := client.Allocations().Info(stubs[0].ID, nil)
+	require.NoError(t, err)
+
+	cancel := make(chan struct{})
+	defer close(cancel)
+
+	frames, errs := client.AllocFS().Logs(alloc, false, "web", "stdout", "start", 0, cancel, nil)
+
+	var out strings.Builder
+
+	for done := false; !done; {
+		select {
+		case f, ok := <-frames:
+			if !ok {
+				done = true
+				break
+			}
+
+			out.Write(f.Data)
+		case err := <-errs:
+			require.NoError(t, err)
+		}
+	}
+
+	assert.Contains(t, out.String(), "hello from the fake")
+
+	files, _, err := client.AllocFS().List(alloc, "web/local", nil)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "config.yml", files[0].Name)
+
+	r, err := client.AllocFS().Cat(alloc, "web/local/config.yml", nil)
+	require.NoError(t, err)
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "port: 8080\n", string(data))
+}
+
+func TestACLEnforcement(t *testing.T) {
+	c := nomadfake.New()
+	token := c.AddToken(&api.ACLToken{Name: "operator", Type: "management"})
+	client := newClient(t, c)
+
+	_, _, err := client.Jobs().List(nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403")
+
+	client.SetSecretID(token.SecretID)
+
+	self, _, err := client.ACLTokens().Self(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "operator", self.Name)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
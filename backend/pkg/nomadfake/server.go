@@ -0,0 +1,1518 @@
+package nomadfake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+const (
+	// maxBlockingWait caps the wait of blocking queries
+	maxBlockingWait = 5 * time.Minute
+	// heartbeatInterval is how often idle event streams get a heartbeat
+	heartbeatInterval = 10 * time.Second
+	// leaderAddress is what /v1/status/leader reports
+	leaderAddress = "127.0.0.1:4647"
+)
+
+// server serves the Nomad HTTP API of a cluster
+type server struct {
+	c   *Cluster
+	mux *http.ServeMux
+}
+
+// Handler returns the HTTP handler that serves the cluster's Nomad API.
+func (c *Cluster) Handler() http.Handler {
+	s := &server{c: c, mux: http.NewServeMux()}
+	s.routes()
+
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !anonymous[r.URL.Path] {
+		if _, ok := s.c.tokenBySecret(r.Header.Get("X-Nomad-Token")); !ok {
+			http.Error(w, "ACL token not found", http.StatusForbidden)
+			return
+		}
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// anonymous are the paths Nomad serves without a token, those of the login flows
+var anonymous = map[string]bool{
+	"/v1/status/leader":              true,
+	"/v1/acl/bootstrap":              true,
+	"/v1/acl/auth-methods":           true,
+	"/v1/acl/login":                  true,
+	"/v1/acl/token/onetime/exchange": true,
+	"/v1/acl/oidc/auth-url":          true,
+	"/v1/acl/oidc/complete-auth":     true,
+}
+
+// write registers a handler for both PUT and POST, which Nomad accepts alike for writes
+func (s *server) write(pattern string, h http.HandlerFunc) {
+	s.mux.HandleFunc("PUT "+pattern, h)
+	s.mux.HandleFunc("POST "+pattern, h)
+}
+
+func (s *server) routes() {
+	m := s.mux
+
+	m.HandleFunc("GET /v1/status/leader", s.leader)
+	m.HandleFunc("GET /v1/status/peers", s.peers)
+	m.HandleFunc("GET /v1/regions", s.regions)
+	m.HandleFunc("GET /v1/agent/self", s.agentSelf)
+	m.HandleFunc("PUT /v1/system/gc", s.garbageCollect)
+	m.HandleFunc("PUT /v1/system/reconcile/summaries", s.reconcileSummaries)
+
+	m.HandleFunc("GET /v1/jobs", s.listJobs)
+	s.write("/v1/jobs", s.registerJob)
+	s.write("/v1/jobs/parse", s.parseJob)
+	m.HandleFunc("GET /v1/job/{id}", s.getJob)
+	s.write("/v1/job/{id}", s.registerJob)
+	m.HandleFunc("DELETE /v1/job/{id}", s.deregisterJob)
+	m.HandleFunc("GET /v1/job/{id}/allocations", s.jobAllocations)
+	m.HandleFunc("GET /v1/job/{id}/evaluations", s.jobEvaluations)
+	m.HandleFunc("GET /v1/job/{id}/deployments", s.jobDeployments)
+	m.HandleFunc("GET /v1/job/{id}/deployment", s.jobLatestDeployment)
+	m.HandleFunc("GET /v1/job/{id}/summary", s.jobSummary)
+	m.HandleFunc("GET /v1/job/{id}/versions", s.jobVersions)
+	m.HandleFunc("GET /v1/job/{id}/scale", s.jobScaleStatus)
+	s.write("/v1/job/{id}/scale", s.scaleJob)
+	s.write("/v1/job/{id}/plan", s.planJob)
+	s.write("/v1/validate/job", s.validateJob)
+	s.write("/v1/job/{id}/dispatch", s.dispatchJob)
+	s.write("/v1/job/{id}/evaluate", s.evaluateJob)
+	s.write("/v1/job/{id}/revert", s.revertJob)
+	s.write("/v1/job/{id}/stable", s.stableJob)
+	m.HandleFunc("GET /v1/job/{id}/action", s.jobAction)
+
+	m.HandleFunc("GET /v1/allocations", s.listAllocations)
+	m.HandleFunc("GET /v1/allocation/{id}", s.getAllocation)
+	s.write("/v1/allocation/{id}/stop", s.stopAllocation)
+	s.write("/v1/client/allocation/{id}/restart", s.restartAllocation)
+	s.write("/v1/client/allocation/{id}/signal", s.signalAllocation)
+	m.HandleFunc("GET /v1/client/allocation/{id}/stats", s.allocationStats)
+	m.HandleFunc("GET /v1/client/allocation/{id}/checks", s.allocationChecks)
+	m.HandleFunc("GET /v1/client/allocation/{id}/exec", s.execAllocation)
+	m.HandleFunc("GET /v1/client/fs/ls/{id}", s.listFiles)
+	m.HandleFunc("GET /v1/client/fs/stat/{id}", s.statFile)
+	m.HandleFunc("GET /v1/client/fs/cat/{id}", s.catFile)
+	m.HandleFunc("GET /v1/client/fs/readat/{id}", s.readAtFile)
+	m.HandleFunc("GET /v1/client/fs/stream/{id}", s.streamFile)
+	m.HandleFunc("GET /v1/client/fs/logs/{id}", s.logs)
+	m.HandleFunc("GET /v1/client/stats", s.nodeStats)
+
+	m.HandleFunc("GET /v1/nodes", s.listNodes)
+	m.HandleFunc("GET /v1/node/{id}", s.getNode)
+	m.HandleFunc("GET /v1/node/{id}/allocations", s.nodeAllocations)
+	s.write("/v1/node/{id}/drain", s.drainNode)
+	s.write("/v1/node/{id}/eligibility", s.nodeEligibility)
+	s.write("/v1/node/{id}/purge", s.purgeNode)
+
+	m.HandleFunc("GET /v1/evaluations", s.listEvaluations)
+	m.HandleFunc("GET /v1/evaluation/{id}", s.getEvaluation)
+	m.HandleFunc("GET /v1/evaluation/{id}/allocations", s.evaluationAllocations)
+
+	m.HandleFunc("GET /v1/deployments", s.listDeployments)
+	m.HandleFunc("GET /v1/deployment/{id}", s.getDeployment)
+	m.HandleFunc("GET /v1/deployment/allocations/{id}", s.deploymentAllocations)
+	s.write("/v1/deployment/promote/{id}", s.deploymentAction("promote"))
+	s.write("/v1/deployment/fail/{id}", s.deploymentAction("fail"))
+	s.write("/v1/deployment/pause/{id}", s.deploymentAction("pause"))
+
+	m.HandleFunc("GET /v1/namespaces", s.listNamespaces)
+	m.HandleFunc("GET /v1/namespace/{name}", s.getNamespace)
+
+	m.HandleFunc("GET /v1/services", s.listServices)
+	m.HandleFunc("GET /v1/service/{name}", s.getService)
+
+	m.HandleFunc("GET /v1/vars", s.listVariables)
+	m.HandleFunc("GET /v1/var/{path...}", s.getVariable)
+	s.write("/v1/var/{path...}", s.putVariable)
+	m.HandleFunc("DELETE /v1/var/{path...}", s.deleteVariable)
+
+	s.write("/v1/acl/bootstrap", s.bootstrap)
+	s.write("/v1/acl/token", s.createToken)
+	s.write("/v1/acl/token/onetime", s.createOneTimeToken)
+	s.write("/v1/acl/token/onetime/exchange", s.exchangeOneTimeToken)
+	m.HandleFunc("GET /v1/acl/token/self", s.tokenSelf)
+	m.HandleFunc("GET /v1/acl/tokens", s.listTokens)
+	m.HandleFunc("GET /v1/acl/token/{id}", s.getToken)
+	m.HandleFunc("DELETE /v1/acl/token/{id}", s.deleteToken)
+	m.HandleFunc("GET /v1/acl/policies", s.listPolicies)
+	m.HandleFunc("GET /v1/acl/policy/{name}", s.getPolicy)
+	m.HandleFunc("DELETE /v1/acl/policy/{name}", s.deletePolicy)
+	m.HandleFunc("GET /v1/acl/auth-methods", s.listAuthMethods)
+	m.HandleFunc("GET /v1/acl/auth-method/{name}", s.getAuthMethod)
+	s.write("/v1/acl/oidc/auth-url", s.oidcAuthURL)
+	s.write("/v1/acl/oidc/complete-auth", s.oidcCompleteAuth)
+	s.write("/v1/acl/login", s.login)
+
+	m.HandleFunc("GET /v1/event/stream", s.eventStream)
+}
+
+// block waits for a blocking query: until the cluster index passes the
+// requested index or the wait time is over
+func (s *server) block(r *http.Request) {
+	minIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	if minIndex == 0 {
+		return
+	}
+
+	wait := maxBlockingWait
+	if d, err := time.ParseDuration(r.URL.Query().Get("wait")); err == nil && d > 0 && d < wait {
+		wait = d
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		s.c.mutex.RLock()
+		index, changed := s.c.index, s.c.changed
+		s.c.mutex.RUnlock()
+
+		if index > minIndex {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// reply writes v with the query meta headers the Nomad SDK expects
+func (s *server) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Nomad-Index", strconv.FormatUint(s.c.Index(), 10))
+	w.Header().Set("X-Nomad-LastContact", "0")
+	w.Header().Set("X-Nomad-KnownLeader", "true")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// fail writes an error the way Nomad does, as plain text with a status code
+func fail(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, ErrNotFound) {
+		status = http.StatusNotFound
+	}
+
+	http.Error(w, err.Error(), status)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+func namespace(r *http.Request) string {
+	return r.URL.Query().Get("namespace")
+}
+
+func hasPrefix(r *http.Request, id string) bool {
+	return strings.HasPrefix(id, r.URL.Query().Get("prefix"))
+}
+
+// paginate returns the page of items asked for with per_page and next_token,
+// setting the token of the next page: the ID of its first item
+func paginate[T any](w http.ResponseWriter, r *http.Request, items []T, id func(T) string) []T {
+	q := r.URL.Query()
+
+	if token := q.Get("next_token"); token != "" {
+		start := slices.IndexFunc(items, func(item T) bool { return id(item) == token })
+		if start < 0 {
+			return items[:0]
+		}
+		items = items[start:]
+	}
+
+	if perPage, _ := strconv.Atoi(q.Get("per_page")); perPage > 0 && len(items) > perPage {
+		w.Header().Set("X-Nomad-NextToken", id(items[perPage]))
+		items = items[:perPage]
+	}
+
+	return items
+}
+
+func (s *server) leader(w http.ResponseWriter, _ *http.Request) {
+	s.reply(w, leaderAddress)
+}
+
+func (s *server) peers(w http.ResponseWriter, _ *http.Request) {
+	s.reply(w, []string{leaderAddress})
+}
+
+func (s *server) regions(w http.ResponseWriter, _ *http.Request) {
+	s.reply(w, []string{s.c.region})
+}
+
+func (s *server) agentSelf(w http.ResponseWriter, _ *http.Request) {
+	s.c.mutex.RLock()
+	aclEnabled := len(s.c.tokens) > 0
+	s.c.mutex.RUnlock()
+
+	s.reply(w, map[string]interface{}{
+		"config": map[string]interface{}{"Region": s.c.region, "Datacenter": "dc1", "Version": map[string]string{"Version": "1.9.0"},
+			"ACL": map[string]bool{"Enabled": aclEnabled}},
+		"member": map[string]interface{}{"Name": "nomadfake.global", "Addr": "127.0.0.1", "Port": 4648,
+			"Tags": map[string]string{"region": s.c.region, "dc": "dc1", "build": "1.9.0"}},
+		"stats": map[string]interface{}{},
+	})
+}
+
+// Jobs
+
+func (s *server) listJobs(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	s.c.mutex.RLock()
+	stubs := []*api.JobListStub{}
+
+	for key, j := range s.c.jobs {
+		if matchNamespace(namespace(r), key.namespace) && hasPrefix(r, key.id) {
+			stubs = append(stubs, s.c.jobStub(j))
+		}
+	}
+	s.c.mutex.RUnlock()
+
+	sort.Slice(stubs, func(i, j int) bool { return stubs[i].ID < stubs[j].ID })
+	s.reply(w, paginate(w, r, stubs, func(j *api.JobListStub) string { return j.ID }))
+}
+
+func (s *server) getJob(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	j, err := s.c.Job(namespace(r), r.PathValue("id"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, j)
+}
+
+func (s *server) registerJob(w http.ResponseWriter, r *http.Request) {
+	var req api.JobRegisterRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if req.Job == nil {
+		http.Error(w, "job is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Job.Namespace == nil && namespace(r) != "" {
+		req.Job.Namespace = ptr(namespace(r))
+	}
+
+	if req.EnforceIndex {
+		if current, err := s.c.Job(ptrValue(req.Job.Namespace), ptrValue(req.Job.ID)); err == nil &&
+			*current.JobModifyIndex != req.JobModifyIndex {
+			http.Error(w, fmt.Sprintf("Enforcing job modify index %d: job exists with conflicting job modify index: %d",
+				req.JobModifyIndex, *current.JobModifyIndex), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	resp, err := s.c.RegisterJob(req.Job)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, resp)
+}
+
+func ptrValue(p *string) string {
+	if p == nil {
+		return ""
+	}
+
+	return *p
+}
+
+func (s *server) deregisterJob(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.c.DeregisterJob(namespace(r), r.PathValue("id"), r.URL.Query().Get("purge") == "true")
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, resp)
+}
+
+func (s *server) jobAllocations(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	if _, err := s.c.Job(namespace(r), r.PathValue("id")); err != nil {
+		fail(w, err)
+		return
+	}
+
+	stubs := []*api.AllocationListStub{}
+	for _, a := range s.c.Allocations(namespace(r), r.PathValue("id")) {
+		stubs = append(stubs, a.Stub())
+	}
+
+	s.reply(w, stubs)
+}
+
+func (s *server) jobEvaluations(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	s.reply(w, s.evaluations(func(e *api.Evaluation) bool {
+		return matchNamespace(namespace(r), e.Namespace) && e.JobID == r.PathValue("id")
+	}))
+}
+
+func (s *server) jobDeployments(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	s.reply(w, s.deployments(func(d *api.Deployment) bool {
+		return matchNamespace(namespace(r), d.Namespace) && d.JobID == r.PathValue("id")
+	}))
+}
+
+func (s *server) jobLatestDeployment(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	deployments := s.deployments(func(d *api.Deployment) bool {
+		return matchNamespace(namespace(r), d.Namespace) && d.JobID == r.PathValue("id")
+	})
+
+	if len(deployments) == 0 {
+		s.reply(w, nil)
+		return
+	}
+
+	s.reply(w, deployments[0])
+}
+
+func (s *server) jobSummary(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	s.c.mutex.RLock()
+	defer s.c.mutex.RUnlock()
+
+	j, ok := s.c.jobs[nsKey{namespaceOf(namespace(r)), r.PathValue("id")}]
+	if !ok {
+		fail(w, fmt.Errorf("job %q %w", r.PathValue("id"), ErrNotFound))
+		return
+	}
+
+	summary := s.c.jobSummary(j)
+
+	w.Header().Set("X-Nomad-Index", strconv.FormatUint(s.c.index, 10))
+	w.Header().Set("X-Nomad-LastContact", "0")
+	w.Header().Set("X-Nomad-KnownLeader", "true")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+func (s *server) jobVersions(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	s.c.mutex.RLock()
+	versions, ok := s.c.versions[nsKey{namespaceOf(namespace(r)), r.PathValue("id")}]
+
+	resp := api.JobVersionsResponse{}
+	for _, v := range versions {
+		resp.Versions = append(resp.Versions, clone(v))
+	}
+	s.c.mutex.RUnlock()
+
+	if !ok {
+		fail(w, fmt.Errorf("job %q %w", r.PathValue("id"), ErrNotFound))
+		return
+	}
+
+	if r.URL.Query().Get("diffs") == "true" {
+		resp.Diffs = make([]*api.JobDiff, 0, len(resp.Versions))
+		for i := 0; i+1 < len(resp.Versions); i++ {
+			diff := &api.JobDiff{Type: "None", ID: r.PathValue("id")}
+			if !sameSpec(resp.Versions[i], resp.Versions[i+1]) {
+				diff.Type = "Edited"
+			}
+
+			resp.Diffs = append(resp.Diffs, diff)
+		}
+	}
+
+	s.reply(w, resp)
+}
+
+func (s *server) jobScaleStatus(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	j, err := s.c.Job(namespace(r), r.PathValue("id"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	resp := api.JobScaleStatusResponse{
+		JobID:          *j.ID,
+		Namespace:      *j.Namespace,
+		JobCreateIndex: *j.CreateIndex,
+		JobModifyIndex: *j.JobModifyIndex,
+		JobStopped:     j.Stop != nil && *j.Stop,
+		TaskGroups:     map[string]api.TaskGroupScaleStatus{},
+	}
+
+	events := s.c.ScalingEvents(*j.Namespace, *j.ID)
+	for _, tg := range j.TaskGroups {
+		resp.TaskGroups[*tg.Name] = api.TaskGroupScaleStatus{Desired: *tg.Count, Events: events[*tg.Name]}
+	}
+
+	for _, a := range s.c.Allocations(*j.Namespace, *j.ID) {
+		if a.DesiredStatus != api.AllocDesiredStatusRun {
+			continue
+		}
+
+		status := resp.TaskGroups[a.TaskGroup]
+		status.Placed++
+
+		if a.ClientStatus == api.AllocClientStatusRunning {
+			status.Running++
+			status.Healthy++
+		}
+
+		resp.TaskGroups[a.TaskGroup] = status
+	}
+
+	s.reply(w, resp)
+}
+
+func (s *server) scaleJob(w http.ResponseWriter, r *http.Request) {
+	var req api.ScalingRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	j, err := s.c.Job(namespace(r), r.PathValue("id"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	group := req.Target["Group"]
+
+	tg := j.LookupTaskGroup(group)
+	if tg == nil {
+		fail(w, fmt.Errorf("task group %q %w", group, ErrNotFound))
+		return
+	}
+
+	event := api.ScalingEvent{
+		Count:         req.Count,
+		PreviousCount: int64(*tg.Count),
+		Error:         req.Error,
+		Message:       req.Message,
+		Meta:          req.Meta,
+	}
+
+	// Scaling events without a count only record a message
+	resp := &api.JobRegisterResponse{JobModifyIndex: *j.JobModifyIndex}
+	if req.Count != nil {
+		resp, err = s.c.ScaleJob(*j.Namespace, *j.ID, group, int(*req.Count))
+		if err != nil {
+			fail(w, err)
+			return
+		}
+
+		event.EvalID = &resp.EvalID
+	}
+
+	s.c.RecordScalingEvent(*j.Namespace, *j.ID, group, event)
+
+	s.reply(w, resp)
+}
+
+func (s *server) planJob(w http.ResponseWriter, r *http.Request) {
+	var req api.JobPlanRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if req.Job != nil && req.Job.Namespace == nil && namespace(r) != "" {
+		req.Job.Namespace = ptr(namespace(r))
+	}
+
+	resp, err := s.c.Plan(req.Job)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, resp)
+}
+
+func (s *server) parseJob(w http.ResponseWriter, r *http.Request) {
+	var req api.JobsParseRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	job, err := parseJobspec(req.JobHCL)
+	if err != nil {
+		http.Error(w, "error parsing: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Canonicalize {
+		job.Canonicalize()
+	}
+
+	s.reply(w, job)
+}
+
+func (s *server) validateJob(w http.ResponseWriter, r *http.Request) {
+	var req api.JobValidateRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.reply(w, s.c.Validate(req.Job))
+}
+
+func (s *server) dispatchJob(w http.ResponseWriter, r *http.Request) {
+	var req api.JobDispatchRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	resp, err := s.c.DispatchJobOpts(namespace(r), r.PathValue("id"), DispatchOptions{
+		Payload:          req.Payload,
+		Meta:             req.Meta,
+		IDPrefix:         req.IdPrefixTemplate,
+		IdempotencyToken: r.URL.Query().Get("idempotency_token"),
+	})
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, resp)
+}
+
+func (s *server) evaluateJob(w http.ResponseWriter, r *http.Request) {
+	var req api.JobEvaluateRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	eval, err := s.c.EvaluateJobOpts(namespace(r), r.PathValue("id"), req.EvalOptions)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, api.JobRegisterResponse{EvalID: eval.ID, EvalCreateIndex: eval.CreateIndex})
+}
+
+func (s *server) revertJob(w http.ResponseWriter, r *http.Request) {
+	var req api.JobRevertRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	resp, err := s.c.RevertJob(namespace(r), r.PathValue("id"), req.JobVersion)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, resp)
+}
+
+func (s *server) stableJob(w http.ResponseWriter, r *http.Request) {
+	var req api.JobStabilityRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	index, err := s.c.SetStable(namespace(r), r.PathValue("id"), req.JobVersion, req.Stable)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, api.JobStabilityResponse{JobModifyIndex: index})
+}
+
+// Allocations
+
+func (s *server) listAllocations(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	stubs := []*api.AllocationListStub{}
+
+	for _, a := range s.c.Allocations(namespace(r), "") {
+		if hasPrefix(r, a.ID) {
+			stubs = append(stubs, a.Stub())
+		}
+	}
+
+	s.reply(w, paginate(w, r, stubs, func(a *api.AllocationListStub) string { return a.ID }))
+}
+
+func (s *server) getAllocation(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	a, err := s.c.Allocation(r.PathValue("id"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, a)
+}
+
+func (s *server) stopAllocation(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.c.StopAllocation(r.PathValue("id"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, resp)
+}
+
+func (s *server) restartAllocation(w http.ResponseWriter, r *http.Request) {
+	var req api.AllocationRestartRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	if err := s.c.RestartAllocation(r.PathValue("id"), req.TaskName); err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, struct{}{})
+}
+
+func (s *server) signalAllocation(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.c.Allocation(r.PathValue("id")); err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, struct{}{})
+}
+
+// allocationStats reports usage that drifts over time, so graphs have something to show
+func (s *server) allocationStats(w http.ResponseWriter, r *http.Request) {
+	a, err := s.c.Allocation(r.PathValue("id"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	now := s.c.now()
+	usage := &api.AllocResourceUsage{Tasks: map[string]*api.TaskResourceUsage{}, Timestamp: now.UnixNano()}
+	total := &api.ResourceUsage{MemoryStats: &api.MemoryStats{}, CpuStats: &api.CpuStats{}}
+
+	for task := range a.TaskStates {
+		ru := syntheticUsage(a.ID+task, now)
+		usage.Tasks[task] = &api.TaskResourceUsage{ResourceUsage: ru, Timestamp: now.UnixNano()}
+
+		total.MemoryStats.RSS += ru.MemoryStats.RSS
+		total.MemoryStats.Usage += ru.MemoryStats.Usage
+		total.CpuStats.Percent += ru.CpuStats.Percent
+		total.CpuStats.TotalTicks += ru.CpuStats.TotalTicks
+	}
+
+	usage.ResourceUsage = total
+	s.reply(w, usage)
+}
+
+func (s *server) allocationChecks(w http.ResponseWriter, r *http.Request) {
+	checks, err := s.c.Checks(r.PathValue("id"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, checks)
+}
+
+func (s *server) nodeStats(w http.ResponseWriter, r *http.Request) {
+	n, err := s.c.Node(r.URL.Query().Get("node_id"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	now := s.c.now()
+	ru := syntheticUsage(n.ID, now)
+	total := uint64(16 << 30)
+
+	s.reply(w, &api.HostStats{
+		Memory: &api.HostMemoryStats{Total: total, Used: ru.MemoryStats.RSS * 8, Available: total - ru.MemoryStats.RSS*8,
+			Free: total - ru.MemoryStats.RSS*8},
+		CPU: []*api.HostCPUStats{
+			{CPU: "cpu0", User: ru.CpuStats.Percent, System: ru.CpuStats.Percent / 4, Idle: 100 - ru.CpuStats.Percent*1.25},
+		},
+		Uptime:           uint64(now.Unix() - n.StatusUpdatedAt),
+		CPUTicksConsumed: ru.CpuStats.TotalTicks * 8,
+	})
+}
+
+// Nodes
+
+func (s *server) listNodes(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	s.c.mutex.RLock()
+	stubs := []*api.NodeListStub{}
+
+	for _, n := range s.c.nodes {
+		if hasPrefix(r, n.ID) {
+			stubs = append(stubs, nodeStub(n))
+		}
+	}
+	s.c.mutex.RUnlock()
+
+	sort.Slice(stubs, func(i, j int) bool { return stubs[i].Name < stubs[j].Name })
+	s.reply(w, paginate(w, r, stubs, func(n *api.NodeListStub) string { return n.ID }))
+}
+
+func (s *server) getNode(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	n, err := s.c.Node(r.PathValue("id"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, n)
+}
+
+func (s *server) nodeAllocations(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	if _, err := s.c.Node(r.PathValue("id")); err != nil {
+		fail(w, err)
+		return
+	}
+
+	allocs := []*api.Allocation{}
+
+	for _, a := range s.c.Allocations("*", "") {
+		if a.NodeID == r.PathValue("id") {
+			allocs = append(allocs, a)
+		}
+	}
+
+	s.reply(w, allocs)
+}
+
+func (s *server) drainNode(w http.ResponseWriter, r *http.Request) {
+	var req api.NodeUpdateDrainRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	resp, err := s.c.UpdateDrain(r.PathValue("id"), req.DrainSpec, req.MarkEligible)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, resp)
+}
+
+func (s *server) nodeEligibility(w http.ResponseWriter, r *http.Request) {
+	var req api.NodeUpdateEligibilityRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	resp, err := s.c.SetEligibility(r.PathValue("id"), req.Eligibility == api.NodeSchedulingEligible)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, resp)
+}
+
+func (s *server) garbageCollect(w http.ResponseWriter, _ *http.Request) {
+	s.c.GarbageCollect()
+	s.reply(w, nil)
+}
+
+// reconcileSummaries has nothing to do: the fake computes job summaries
+// from the allocations on every read
+func (s *server) reconcileSummaries(w http.ResponseWriter, _ *http.Request) {
+	s.reply(w, nil)
+}
+
+func (s *server) purgeNode(w http.ResponseWriter, r *http.Request) {
+	resp, err := s.c.PurgeNode(r.PathValue("id"))
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, resp)
+}
+
+// Evaluations
+
+// evaluations returns the evaluations matching keep, newest first
+func (s *server) evaluations(keep func(*api.Evaluation) bool) []*api.Evaluation {
+	s.c.mutex.RLock()
+	defer s.c.mutex.RUnlock()
+
+	evals := []*api.Evaluation{}
+
+	for _, e := range s.c.evals {
+		if keep(e) {
+			evals = append(evals, clone(e))
+		}
+	}
+
+	sort.Slice(evals, func(i, j int) bool { return evals[i].CreateIndex > evals[j].CreateIndex })
+
+	return evals
+}
+
+func (s *server) listEvaluations(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	evals := s.evaluations(func(e *api.Evaluation) bool {
+		return matchNamespace(namespace(r), e.Namespace) && hasPrefix(r, e.ID)
+	})
+	s.reply(w, paginate(w, r, evals, func(e *api.Evaluation) string { return e.ID }))
+}
+
+func (s *server) getEvaluation(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	evals := s.evaluations(func(e *api.Evaluation) bool { return e.ID == r.PathValue("id") })
+	if len(evals) == 0 {
+		fail(w, fmt.Errorf("eval %q %w", r.PathValue("id"), ErrNotFound))
+		return
+	}
+
+	s.reply(w, evals[0])
+}
+
+func (s *server) evaluationAllocations(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	stubs := []*api.AllocationListStub{}
+
+	for _, a := range s.c.Allocations("*", "") {
+		if a.EvalID == r.PathValue("id") {
+			stubs = append(stubs, a.Stub())
+		}
+	}
+
+	s.reply(w, stubs)
+}
+
+// Deployments
+
+// deployments returns the deployments matching keep, newest first
+func (s *server) deployments(keep func(*api.Deployment) bool) []*api.Deployment {
+	s.c.mutex.RLock()
+	defer s.c.mutex.RUnlock()
+
+	deployments := []*api.Deployment{}
+
+	for _, d := range s.c.deploys {
+		if keep(d) {
+			deployments = append(deployments, clone(d))
+		}
+	}
+
+	sort.Slice(deployments, func(i, j int) bool { return deployments[i].CreateIndex > deployments[j].CreateIndex })
+
+	return deployments
+}
+
+func (s *server) listDeployments(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	deployments := s.deployments(func(d *api.Deployment) bool {
+		return matchNamespace(namespace(r), d.Namespace) && hasPrefix(r, d.ID)
+	})
+	s.reply(w, paginate(w, r, deployments, func(d *api.Deployment) string { return d.ID }))
+}
+
+func (s *server) getDeployment(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	deployments := s.deployments(func(d *api.Deployment) bool { return d.ID == r.PathValue("id") })
+	if len(deployments) == 0 {
+		fail(w, fmt.Errorf("deployment %q %w", r.PathValue("id"), ErrNotFound))
+		return
+	}
+
+	s.reply(w, deployments[0])
+}
+
+func (s *server) deploymentAllocations(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	stubs := []*api.AllocationListStub{}
+
+	for _, a := range s.c.Allocations("*", "") {
+		if a.DeploymentID == r.PathValue("id") {
+			stubs = append(stubs, a.Stub())
+		}
+	}
+
+	s.reply(w, stubs)
+}
+
+func (s *server) deploymentAction(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req api.DeploymentPauseRequest
+		if !decode(w, r, &req) {
+			return
+		}
+
+		resp, err := s.c.UpdateDeployment(r.PathValue("id"), action, req.Pause)
+		if err != nil {
+			fail(w, err)
+			return
+		}
+
+		s.reply(w, resp)
+	}
+}
+
+// Namespaces
+
+func (s *server) listNamespaces(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	s.c.mutex.RLock()
+	namespaces := []*api.Namespace{}
+
+	for _, ns := range s.c.namespaces {
+		namespaces = append(namespaces, clone(ns))
+	}
+	s.c.mutex.RUnlock()
+
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	s.reply(w, namespaces)
+}
+
+func (s *server) getNamespace(w http.ResponseWriter, r *http.Request) {
+	s.c.mutex.RLock()
+	ns, ok := s.c.namespaces[r.PathValue("name")]
+	ns = clone(ns)
+	s.c.mutex.RUnlock()
+
+	if !ok {
+		fail(w, fmt.Errorf("namespace %q %w", r.PathValue("name"), ErrNotFound))
+		return
+	}
+
+	s.reply(w, ns)
+}
+
+// Services are derived from the group and task services of running allocations
+
+func (s *server) services(r *http.Request) []*api.ServiceRegistration {
+	var regs []*api.ServiceRegistration
+
+	for _, a := range s.c.Allocations(namespace(r), "") {
+		if a.ClientStatus != api.AllocClientStatusRunning || a.Job == nil {
+			continue
+		}
+
+		tg := a.Job.LookupTaskGroup(a.TaskGroup)
+		if tg == nil {
+			continue
+		}
+
+		services := append([]*api.Service(nil), tg.Services...)
+		for _, t := range tg.Tasks {
+			services = append(services, t.Services...)
+		}
+
+		for i, svc := range services {
+			if svc.Provider != "nomad" {
+				continue
+			}
+
+			regs = append(regs, &api.ServiceRegistration{
+				ID:          fmt.Sprintf("_nomad-task-%s-%s-%d", a.ID, svc.Name, i),
+				ServiceName: svc.Name,
+				Namespace:   a.Namespace,
+				NodeID:      a.NodeID,
+				Datacenter:  "dc1",
+				JobID:       a.JobID,
+				AllocID:     a.ID,
+				Tags:        svc.Tags,
+				Address:     "127.0.0.1",
+				Port:        20000 + i,
+				CreateIndex: a.CreateIndex,
+				ModifyIndex: a.ModifyIndex,
+			})
+		}
+	}
+
+	return regs
+}
+
+func (s *server) listServices(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	byNamespace := map[string]map[string]*api.ServiceRegistrationStub{}
+
+	for _, reg := range s.services(r) {
+		if byNamespace[reg.Namespace] == nil {
+			byNamespace[reg.Namespace] = map[string]*api.ServiceRegistrationStub{}
+		}
+
+		byNamespace[reg.Namespace][reg.ServiceName] = &api.ServiceRegistrationStub{ServiceName: reg.ServiceName, Tags: reg.Tags}
+	}
+
+	list := []*api.ServiceRegistrationListStub{}
+
+	for ns, services := range byNamespace {
+		stub := &api.ServiceRegistrationListStub{Namespace: ns}
+		for _, svc := range services {
+			stub.Services = append(stub.Services, svc)
+		}
+
+		sort.Slice(stub.Services, func(i, j int) bool { return stub.Services[i].ServiceName < stub.Services[j].ServiceName })
+		list = append(list, stub)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Namespace < list[j].Namespace })
+	s.reply(w, list)
+}
+
+func (s *server) getService(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	regs := []*api.ServiceRegistration{}
+
+	for _, reg := range s.services(r) {
+		if reg.ServiceName == r.PathValue("name") {
+			regs = append(regs, reg)
+		}
+	}
+
+	s.reply(w, regs)
+}
+
+// Variables
+
+func (s *server) listVariables(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	s.c.mutex.RLock()
+	metas := []*api.VariableMetadata{}
+
+	for key, v := range s.c.variables {
+		if matchNamespace(namespace(r), key.namespace) && hasPrefix(r, key.id) {
+			metas = append(metas, &api.VariableMetadata{
+				Namespace: v.Namespace, Path: v.Path,
+				CreateIndex: v.CreateIndex, ModifyIndex: v.ModifyIndex,
+				CreateTime: v.CreateTime, ModifyTime: v.ModifyTime,
+			})
+		}
+	}
+	s.c.mutex.RUnlock()
+
+	sort.Slice(metas, func(i, j int) bool { return metas[i].Path < metas[j].Path })
+	s.reply(w, paginate(w, r, metas, func(v *api.VariableMetadata) string { return v.Path }))
+}
+
+func (s *server) getVariable(w http.ResponseWriter, r *http.Request) {
+	s.block(r)
+
+	s.c.mutex.RLock()
+	v, ok := s.c.variables[nsKey{namespaceOf(namespace(r)), r.PathValue("path")}]
+	v = clone(v)
+	s.c.mutex.RUnlock()
+
+	if !ok {
+		fail(w, fmt.Errorf("variable %q %w", r.PathValue("path"), ErrNotFound))
+		return
+	}
+
+	s.reply(w, v)
+}
+
+func (s *server) putVariable(w http.ResponseWriter, r *http.Request) {
+	var v api.Variable
+	if !decode(w, r, &v) {
+		return
+	}
+
+	v.Path = r.PathValue("path")
+	if v.Namespace == "" {
+		v.Namespace = namespace(r)
+	}
+
+	s.reply(w, s.c.PutVariable(&v))
+}
+
+func (s *server) deleteVariable(w http.ResponseWriter, r *http.Request) {
+	if err := s.c.DeleteVariable(namespace(r), r.PathValue("path")); err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, nil)
+}
+
+// ACL
+
+func (s *server) aclDisabled(w http.ResponseWriter) bool {
+	s.c.mutex.RLock()
+	disabled := len(s.c.tokens) == 0
+	s.c.mutex.RUnlock()
+
+	if disabled {
+		http.Error(w, "ACL support disabled", http.StatusBadRequest)
+	}
+
+	return disabled
+}
+
+func (s *server) bootstrap(w http.ResponseWriter, r *http.Request) {
+	var req api.BootstrapRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	token, err := s.c.Bootstrap(req.BootstrapSecret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.reply(w, token)
+}
+
+func (s *server) createToken(w http.ResponseWriter, r *http.Request) {
+	if s.aclDisabled(w) {
+		return
+	}
+
+	var token api.ACLToken
+	if err := json.NewDecoder(r.Body).Decode(&token); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token.CreateTime = s.c.now()
+	if token.ExpirationTTL > 0 {
+		expires := token.CreateTime.Add(token.ExpirationTTL)
+		token.ExpirationTime = &expires
+	}
+
+	s.reply(w, s.c.AddToken(&token))
+}
+
+func (s *server) createOneTimeToken(w http.ResponseWriter, r *http.Request) {
+	if s.aclDisabled(w) {
+		return
+	}
+
+	t, _ := s.c.tokenBySecret(r.Header.Get("X-Nomad-Token"))
+	s.reply(w, api.OneTimeTokenUpsertResponse{OneTimeToken: s.c.CreateOneTimeToken(t.AccessorID)})
+}
+
+func (s *server) exchangeOneTimeToken(w http.ResponseWriter, r *http.Request) {
+	var req api.OneTimeTokenExchangeRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	token, err := s.c.ExchangeOneTimeToken(req.OneTimeSecretID)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, api.OneTimeTokenExchangeResponse{Token: token})
+}
+
+func (s *server) tokenSelf(w http.ResponseWriter, r *http.Request) {
+	if s.aclDisabled(w) {
+		return
+	}
+
+	t, _ := s.c.tokenBySecret(r.Header.Get("X-Nomad-Token"))
+	s.reply(w, clone(t))
+}
+
+func (s *server) listTokens(w http.ResponseWriter, r *http.Request) {
+	if s.aclDisabled(w) {
+		return
+	}
+
+	s.c.mutex.RLock()
+	stubs := []*api.ACLTokenListStub{}
+
+	for _, t := range s.c.tokens {
+		stubs = append(stubs, &api.ACLTokenListStub{
+			AccessorID: t.AccessorID, Name: t.Name, Type: t.Type, Policies: t.Policies, Global: t.Global,
+			CreateTime: t.CreateTime, CreateIndex: t.CreateIndex, ModifyIndex: t.ModifyIndex,
+		})
+	}
+	s.c.mutex.RUnlock()
+
+	sort.Slice(stubs, func(i, j int) bool { return stubs[i].Name < stubs[j].Name })
+	s.reply(w, stubs)
+}
+
+func (s *server) getToken(w http.ResponseWriter, r *http.Request) {
+	if s.aclDisabled(w) {
+		return
+	}
+
+	s.c.mutex.RLock()
+	t, ok := s.c.tokens[r.PathValue("id")]
+	t = clone(t)
+	s.c.mutex.RUnlock()
+
+	if !ok {
+		fail(w, fmt.Errorf("ACL token %q %w", r.PathValue("id"), ErrNotFound))
+		return
+	}
+
+	s.reply(w, t)
+}
+
+func (s *server) deleteToken(w http.ResponseWriter, r *http.Request) {
+	if s.aclDisabled(w) {
+		return
+	}
+
+	s.c.mutex.Lock()
+	_, ok := s.c.tokens[r.PathValue("id")]
+	delete(s.c.tokens, r.PathValue("id"))
+	s.c.bump()
+	s.c.mutex.Unlock()
+
+	if !ok {
+		fail(w, fmt.Errorf("ACL token %q %w", r.PathValue("id"), ErrNotFound))
+		return
+	}
+
+	s.reply(w, nil)
+}
+
+func (s *server) listPolicies(w http.ResponseWriter, r *http.Request) {
+	if s.aclDisabled(w) {
+		return
+	}
+
+	s.c.mutex.RLock()
+	stubs := []*api.ACLPolicyListStub{}
+
+	for _, p := range s.c.policies {
+		stubs = append(stubs, &api.ACLPolicyListStub{Name: p.Name, Description: p.Description,
+			CreateIndex: p.CreateIndex, ModifyIndex: p.ModifyIndex})
+	}
+	s.c.mutex.RUnlock()
+
+	sort.Slice(stubs, func(i, j int) bool { return stubs[i].Name < stubs[j].Name })
+	s.reply(w, stubs)
+}
+
+func (s *server) getPolicy(w http.ResponseWriter, r *http.Request) {
+	if s.aclDisabled(w) {
+		return
+	}
+
+	s.c.mutex.RLock()
+	p, ok := s.c.policies[r.PathValue("name")]
+	p = clone(p)
+	s.c.mutex.RUnlock()
+
+	if !ok {
+		fail(w, fmt.Errorf("ACL policy %q %w", r.PathValue("name"), ErrNotFound))
+		return
+	}
+
+	s.reply(w, p)
+}
+
+func (s *server) deletePolicy(w http.ResponseWriter, r *http.Request) {
+	if s.aclDisabled(w) {
+		return
+	}
+
+	s.c.mutex.Lock()
+	_, ok := s.c.policies[r.PathValue("name")]
+	delete(s.c.policies, r.PathValue("name"))
+	s.c.bump()
+	s.c.mutex.Unlock()
+
+	if !ok {
+		fail(w, fmt.Errorf("ACL policy %q %w", r.PathValue("name"), ErrNotFound))
+		return
+	}
+
+	s.reply(w, nil)
+}
+
+func (s *server) listAuthMethods(w http.ResponseWriter, _ *http.Request) {
+	s.c.mutex.RLock()
+	stubs := []*api.ACLAuthMethodListStub{}
+	for _, m := range s.c.authMethods {
+		stubs = append(stubs, &api.ACLAuthMethodListStub{
+			Name:        m.Name,
+			Type:        m.Type,
+			Default:     m.Default,
+			CreateIndex: m.CreateIndex,
+			ModifyIndex: m.ModifyIndex,
+		})
+	}
+	s.c.mutex.RUnlock()
+
+	sort.Slice(stubs, func(i, j int) bool { return stubs[i].Name < stubs[j].Name })
+
+	s.reply(w, stubs)
+}
+
+func (s *server) getAuthMethod(w http.ResponseWriter, r *http.Request) {
+	s.c.mutex.RLock()
+	m, ok := s.c.authMethods[r.PathValue("name")]
+	m = clone(m)
+	s.c.mutex.RUnlock()
+
+	if !ok {
+		fail(w, fmt.Errorf("ACL auth method %q %w", r.PathValue("name"), ErrNotFound))
+		return
+	}
+
+	s.reply(w, m)
+}
+
+// oidcAuthURL answers with a URL of a made-up provider that carries the
+// request, the code to complete the login with is up to the test
+func (s *server) oidcAuthURL(w http.ResponseWriter, r *http.Request) {
+	var req api.ACLOIDCAuthURLRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.c.mutex.RLock()
+	m, ok := s.c.authMethods[req.AuthMethodName]
+	s.c.mutex.RUnlock()
+
+	if !ok || m.Type != api.ACLAuthMethodTypeOIDC {
+		fail(w, fmt.Errorf("OIDC auth method %q %w", req.AuthMethodName, ErrNotFound))
+		return
+	}
+
+	q := url.Values{"redirect_uri": {req.RedirectURI}, "state": {req.ClientNonce}}
+	s.reply(w, &api.ACLOIDCAuthURLResponse{AuthURL: "https://idp.example/authorize?" + q.Encode()})
+}
+
+func (s *server) oidcCompleteAuth(w http.ResponseWriter, r *http.Request) {
+	var req api.ACLOIDCCompleteAuthRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	token, err := s.c.login(req.AuthMethodName, api.ACLAuthMethodTypeOIDC, req.Code)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, token)
+}
+
+func (s *server) login(w http.ResponseWriter, r *http.Request) {
+	var req api.ACLLoginRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	token, err := s.c.login(req.AuthMethodName, api.ACLAuthMethodTypeJWT, req.LoginToken)
+	if err != nil {
+		fail(w, err)
+		return
+	}
+
+	s.reply(w, token)
+}
+
+// Event stream
+
+func (s *server) eventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+
+	sub := s.c.subscribe(r.URL.Query()["topic"], r.URL.Query().Get("namespace"), index)
+	defer s.c.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	heartbeat := time.NewTicker(heartbeatInterval)
+
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, "{}\n"); err != nil {
+				return
+			}
+		case e := <-sub.events:
+			if err := enc.Encode(api.Events{Index: e.Index, Events: []api.Event{e}}); err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
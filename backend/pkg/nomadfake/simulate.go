@@ -0,0 +1,101 @@
+package nomadfake
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// Simulate keeps the cluster alive until ctx is done: every interval running
+// tasks write log lines, and now and then an allocation fails and is
+// replaced, finishes a batch run or restarts, which also generates events.
+func (c *Cluster) Simulate(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	for tick := 0; ; tick++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.step(rnd, tick)
+	}
+}
+
+func (c *Cluster) step(rnd *rand.Rand, tick int) {
+	var running []*api.Allocation
+
+	for _, a := range c.Allocations("*", "") {
+		if a.ClientStatus != api.AllocClientStatusRunning {
+			continue
+		}
+
+		running = append(running, a)
+
+		for task := range a.TaskStates {
+			c.AppendLog(a.ID, task, "stdout", []byte(demoLogLine(a.JobID, tick+rnd.Intn(len(demoLogLines)))))
+
+			if rnd.Intn(10) == 0 {
+				c.AppendLog(a.ID, task, "stderr", []byte("warning: slow upstream response\n"))
+			}
+		}
+	}
+
+	if len(running) == 0 {
+		return
+	}
+
+	a := running[rnd.Intn(len(running))]
+
+	switch {
+	case a.Job != nil && *a.Job.Type == api.JobTypeBatch:
+		c.SetAllocationStatus(a.ID, api.AllocClientStatusComplete, "Exit Code: 0")
+	case rnd.Intn(20) == 0:
+		c.SetAllocationStatus(a.ID, api.AllocClientStatusFailed, "Exit Code: 137, Exit Message: \"OOM Killed\"")
+		c.reschedule(a)
+	case rnd.Intn(5) == 0:
+		c.RestartAllocation(a.ID, "")
+	}
+}
+
+// reschedule replaces a failed allocation like the scheduler would
+func (c *Cluster) reschedule(failed *api.Allocation) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// The failed allocation keeps its client status, only its desired status changes
+	if a, ok := c.allocs[failed.ID]; ok {
+		a.DesiredStatus = api.AllocDesiredStatusStop
+		a.DesiredDescription = "alloc was rescheduled because it failed"
+		a.ModifyIndex = c.bump()
+	}
+
+	if j, ok := c.jobs[nsKey{failed.Namespace, failed.JobID}]; ok && (j.Stop == nil || !*j.Stop) {
+		c.schedule(j, "alloc-failure")
+	}
+}
+
+// syntheticUsage returns resource usage that follows a smooth, per-object
+// curve over time, so repeated polls draw believable graphs
+func syntheticUsage(seed string, now time.Time) *api.ResourceUsage {
+	h := fnv.New32a()
+	h.Write([]byte(seed))
+	phase := float64(h.Sum32()%1000) / 1000 * 2 * math.Pi
+
+	wave := (math.Sin(float64(now.Unix())/30+phase) + 1) / 2
+	cpu := 5 + 40*wave
+	rss := uint64(64<<20) + uint64(wave*float64(96<<20))
+
+	return &api.ResourceUsage{
+		MemoryStats: &api.MemoryStats{RSS: rss, Usage: rss + 8<<20, Measured: []string{"RSS", "Usage"}},
+		CpuStats:    &api.CpuStats{Percent: cpu, TotalTicks: cpu * 25, Measured: []string{"Percent", "Total Ticks"}},
+	}
+}
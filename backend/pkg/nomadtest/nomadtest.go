@@ -0,0 +1,115 @@
+// Package nomadtest runs a fake Nomad API for tests.
+//
+// A Server serves an in-memory nomadfake cluster over httptest, including
+// blocking queries, the event stream, task logs and exec WebSockets, so
+// handler and backend tests can exercise real HTTP round trips through the
+// Nomad SDK without Docker or a Nomad binary:
+//
+//	srv := nomadtest.NewServer(t)
+//	allocs := srv.RunJob(t, nomadtest.ServiceJob("web", 2))
+//	h := nomad.NewHandler(srv.ContextStore("test"))
+package nomadtest
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadconfig"
+	"github.com/caravan-nomad/caravan/backend/pkg/nomadfake"
+)
+
+// Server is a fake Nomad cluster served over HTTP. The embedded Cluster
+// sets up state and injects failures, logs and events directly.
+type Server struct {
+	*nomadfake.Cluster
+	// URL is the address of the fake Nomad API
+	URL string
+}
+
+// NewServer starts a fake cluster with one ready client node. It is shut
+// down when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	c := nomadfake.New()
+	c.UpsertNode(&api.Node{Name: "client-1"})
+
+	return NewServerWithCluster(t, c)
+}
+
+// NewServerWithCluster serves the given cluster, e.g. nomadfake.NewDemo()
+// for a populated one. It is shut down when the test ends.
+func NewServerWithCluster(t testing.TB, c *nomadfake.Cluster) *Server {
+	t.Helper()
+
+	srv := httptest.NewServer(c.Handler())
+	t.Cleanup(srv.Close)
+
+	return &Server{Cluster: c, URL: srv.URL}
+}
+
+// Client returns a Nomad SDK client for the server, using token if it is not empty.
+func (s *Server) Client(t testing.TB, token string) *api.Client {
+	t.Helper()
+
+	client, err := api.NewClient(&api.Config{Address: s.URL, SecretID: token})
+	if err != nil {
+		t.Fatalf("creating nomad client: %v", err)
+	}
+
+	return client
+}
+
+// Context returns a cluster context that points at the server.
+func (s *Server) Context(name string) *nomadconfig.Context {
+	return &nomadconfig.Context{
+		Name:    name,
+		Address: s.URL,
+		Region:  "global",
+		Source:  nomadconfig.DynamicCluster,
+	}
+}
+
+// ContextStore returns a context store holding only the server's context,
+// ready to pass to nomad.NewHandler.
+func (s *Server) ContextStore(name string) *nomadconfig.InMemoryContextStore {
+	store := nomadconfig.NewInMemoryContextStore()
+	store.AddContext(s.Context(name))
+
+	return store
+}
+
+// RunJob registers a job and returns its allocations, which the fake
+// places immediately.
+func (s *Server) RunJob(t testing.TB, job *api.Job) []*api.Allocation {
+	t.Helper()
+
+	if _, err := s.RegisterJob(job); err != nil {
+		t.Fatalf("registering job: %v", err)
+	}
+
+	namespace := ""
+	if job.Namespace != nil {
+		namespace = *job.Namespace
+	}
+
+	return s.Allocations(namespace, *job.ID)
+}
+
+// ServiceJob returns a service job with one group of count allocations,
+// each running a single docker task named after the job.
+func ServiceJob(id string, count int) *api.Job {
+	job := api.NewServiceJob(id, id, "global", 50)
+	job.AddTaskGroup(api.NewTaskGroup(id, count).AddTask(api.NewTask(id, "docker")))
+
+	return job
+}
+
+// BatchJob returns a batch job with one allocation running a single task.
+func BatchJob(id string) *api.Job {
+	job := api.NewBatchJob(id, id, "global", 50)
+	job.AddTaskGroup(api.NewTaskGroup(id, 1).AddTask(api.NewTask(id, "exec")))
+
+	return job
+}
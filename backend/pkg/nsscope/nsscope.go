@@ -0,0 +1,173 @@
+// Package nsscope maps the identities of Nomad tokens to the namespaces
+// they may use through Caravan, so one Caravan can be shared by many teams
+// that each only see and change their own namespaces.
+//
+// An identity is given by the policies and roles of its token; roles are
+// what SSO groups are usually bound to. Rules grant namespaces to the
+// identities holding one of their policies or roles, and a token gets the
+// namespaces of every rule it matches. Management tokens are never scoped.
+//
+// Scopes narrow what Nomad's ACLs already allow, they do not replace them: a
+// token is still limited by its own policies.
+package nsscope
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+)
+
+// Rule grants namespaces to identities.
+type Rule struct {
+	// Policies and Roles select the tokens holding any of them. A rule with
+	// neither applies to every token.
+	Policies []string `json:"policies,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	// Clusters limit the rule to some clusters, empty means all.
+	Clusters []string `json:"clusters,omitempty"`
+	// Namespaces granted, as glob patterns such as "team-a-*". "*" grants
+	// all namespaces.
+	Namespaces []string `json:"namespaces"`
+}
+
+// Config is the content of a namespace scopes file.
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Scopes is a validated set of rules. A nil Scopes restricts nobody.
+type Scopes struct {
+	cfg Config
+}
+
+// Identity is what a token is scoped by.
+type Identity struct {
+	Management bool
+	Policies   []string
+	Roles      []string
+}
+
+// Scope is the set of namespaces an identity may use on a cluster.
+type Scope struct {
+	unrestricted bool
+	patterns     []string
+}
+
+// Unrestricted is the scope of identities that may use every namespace.
+var Unrestricted = Scope{unrestricted: true}
+
+// Load reads scopes from a JSON file.
+func Load(path string) (*Scopes, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading namespace scopes: %w", err)
+	}
+
+	return Parse(data)
+}
+
+// Parse parses and validates JSON scopes.
+func Parse(data []byte) (*Scopes, error) {
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing namespace scopes: %w", err)
+	}
+
+	return New(cfg)
+}
+
+// New validates cfg and creates scopes from it.
+func New(cfg Config) (*Scopes, error) {
+	for i, rule := range cfg.Rules {
+		if len(rule.Namespaces) == 0 {
+			return nil, fmt.Errorf("rule %d grants no namespace", i)
+		}
+
+		for _, pattern := range rule.Namespaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid namespace pattern %q: %w", i, pattern, err)
+			}
+		}
+	}
+
+	return &Scopes{cfg: cfg}, nil
+}
+
+// For returns the scope of an identity on a cluster. An identity no rule
+// matches gets no namespace at all.
+func (s *Scopes) For(cluster string, id Identity) Scope {
+	if s == nil || id.Management {
+		return Unrestricted
+	}
+
+	var scope Scope
+	for _, rule := range s.cfg.Rules {
+		if !rule.matches(cluster, id) {
+			continue
+		}
+
+		if slices.Contains(rule.Namespaces, "*") {
+			return Unrestricted
+		}
+
+		scope.patterns = append(scope.patterns, rule.Namespaces...)
+	}
+
+	return scope
+}
+
+func (r Rule) matches(cluster string, id Identity) bool {
+	if len(r.Clusters) > 0 && !slices.Contains(r.Clusters, cluster) {
+		return false
+	}
+
+	if len(r.Policies) == 0 && len(r.Roles) == 0 {
+		return true
+	}
+
+	for _, policy := range id.Policies {
+		if slices.Contains(r.Policies, policy) {
+			return true
+		}
+	}
+
+	for _, role := range id.Roles {
+		if slices.Contains(r.Roles, role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Unrestricted reports whether the scope allows every namespace.
+func (s Scope) Unrestricted() bool {
+	return s.unrestricted
+}
+
+// Allows reports whether the scope includes a namespace. The empty namespace
+// is Nomad's "default" one; "*", all namespaces, is only allowed by an
+// unrestricted scope.
+func (s Scope) Allows(namespace string) bool {
+	if s.unrestricted {
+		return true
+	}
+
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	if namespace == "*" {
+		return false
+	}
+
+	for _, pattern := range s.patterns {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+
+	return false
+}
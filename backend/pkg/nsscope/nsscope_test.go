@@ -0,0 +1,47 @@
+package nsscope_test
+
+import (
+	"testing"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/nsscope"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopes(t *testing.T) {
+	s, err := nsscope.Parse([]byte(`{
+		"rules": [
+			{"policies": ["team-a"], "namespaces": ["team-a-*"]},
+			{"roles": ["sre"], "clusters": ["prod"], "namespaces": ["*"]},
+			{"namespaces": ["shared"]}
+		]
+	}`))
+	require.NoError(t, err)
+
+	teamA := s.For("prod", nsscope.Identity{Policies: []string{"team-a", "readonly"}})
+	assert.False(t, teamA.Unrestricted())
+	assert.True(t, teamA.Allows("team-a-web"))
+	assert.True(t, teamA.Allows("shared"), "rules without policies or roles apply to everyone")
+	assert.False(t, teamA.Allows("team-b-web"))
+	assert.False(t, teamA.Allows(""), "the empty namespace is the default one")
+	assert.False(t, teamA.Allows("*"))
+
+	assert.True(t, s.For("prod", nsscope.Identity{Roles: []string{"sre"}}).Unrestricted())
+	assert.False(t, s.For("staging", nsscope.Identity{Roles: []string{"sre"}}).Unrestricted(),
+		"rules can be limited to clusters")
+	assert.True(t, s.For("staging", nsscope.Identity{Management: true}).Unrestricted())
+}
+
+func TestInvalidScopes(t *testing.T) {
+	_, err := nsscope.Parse([]byte(`{"rules": [{"policies": ["team-a"]}]}`))
+	assert.Error(t, err)
+
+	_, err = nsscope.Parse([]byte(`{"rules": [{"namespaces": ["team-[a"]}]}`))
+	assert.Error(t, err)
+}
+
+func TestNilScopesRestrictNobody(t *testing.T) {
+	var s *nsscope.Scopes
+
+	assert.True(t, s.For("prod", nsscope.Identity{}).Allows("anything"))
+}
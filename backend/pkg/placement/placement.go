@@ -0,0 +1,228 @@
+// Package placement evaluates the constraints and affinities of Nomad jobs
+// against nodes the way the scheduler does, to tell why a node would or
+// would not get an allocation without submitting anything.
+//
+// Targets are interpolated from the node's fingerprint: ${attr.*},
+// ${meta.*}, ${node.unique.id}, ${node.unique.name}, ${node.datacenter},
+// ${node.class} and ${node.pool}. The distinct_hosts and distinct_property
+// operands depend on the other allocations of the job and are not evaluated.
+package placement
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// Result is the outcome of one constraint or affinity on one node.
+type Result struct {
+	// Matched is true when the node satisfies the rule, and for rules that
+	// are not evaluated
+	Matched bool `json:"matched"`
+	// Evaluated is false for the operands that depend on other allocations
+	Evaluated bool `json:"evaluated"`
+	// Value is what the left target resolved to on the node
+	Value string `json:"value,omitempty"`
+	// Reason explains a mismatch or why the rule was not evaluated
+	Reason string `json:"reason,omitempty"`
+}
+
+// Operands not evaluated, as they depend on other allocations
+const (
+	operandDistinctHosts    = "distinct_hosts"
+	operandDistinctProperty = "distinct_property"
+)
+
+var interpolation = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// Resolve interpolates a target for a node. It reports false when the
+// target names a property the node does not have.
+func Resolve(target string, node *api.Node) (string, bool) {
+	found := true
+
+	value := interpolation.ReplaceAllStringFunc(target, func(m string) string {
+		v, ok := property(strings.TrimSpace(m[2:len(m)-1]), node)
+		if !ok {
+			found = false
+		}
+		return v
+	})
+
+	return value, found
+}
+
+func property(name string, node *api.Node) (string, bool) {
+	switch name {
+	case "node.unique.id":
+		return node.ID, true
+	case "node.unique.name":
+		return node.Name, true
+	case "node.datacenter":
+		return node.Datacenter, true
+	case "node.class":
+		return node.NodeClass, true
+	case "node.pool":
+		return node.NodePool, true
+	}
+
+	if key, ok := strings.CutPrefix(name, "attr."); ok {
+		v, ok := node.Attributes[key]
+		return v, ok
+	}
+	if key, ok := strings.CutPrefix(name, "meta."); ok {
+		v, ok := node.Meta[key]
+		return v, ok
+	}
+
+	return "", false
+}
+
+// Check evaluates a constraint on a node.
+func Check(c *api.Constraint, node *api.Node) Result {
+	return evaluate(c.LTarget, c.Operand, c.RTarget, node)
+}
+
+// CheckAffinity evaluates whether a node has an affinity's property; its
+// weight then tells whether that is wanted.
+func CheckAffinity(a *api.Affinity, node *api.Node) Result {
+	return evaluate(a.LTarget, a.Operand, a.RTarget, node)
+}
+
+// Describe renders a constraint or affinity the way it is written in a job.
+func Describe(lTarget, operand, rTarget string) string {
+	return strings.TrimSpace(strings.Join([]string{lTarget, operandOrDefault(operand), rTarget}, " "))
+}
+
+func operandOrDefault(operand string) string {
+	if operand == "" {
+		return "="
+	}
+
+	return operand
+}
+
+func evaluate(lTarget, operand, rTarget string, node *api.Node) Result {
+	operand = operandOrDefault(operand)
+
+	switch operand {
+	case operandDistinctHosts, operandDistinctProperty:
+		return Result{Matched: true, Reason: operand + " depends on the job's other allocations"}
+	}
+
+	lValue, lFound := Resolve(lTarget, node)
+	rValue, rFound := Resolve(rTarget, node)
+
+	result := Result{Evaluated: true, Value: lValue}
+
+	switch operand {
+	case "is_set":
+		result.Matched = lFound
+		if !lFound {
+			result.Reason = fmt.Sprintf("%s is not set", lTarget)
+		}
+		return result
+	case "is_not_set":
+		result.Matched = !lFound
+		if lFound {
+			result.Reason = fmt.Sprintf("%s is set", lTarget)
+		}
+		return result
+	}
+
+	if !lFound {
+		result.Reason = fmt.Sprintf("%s is not set", lTarget)
+		return result
+	}
+	if !rFound {
+		result.Reason = fmt.Sprintf("%s is not set", rTarget)
+		return result
+	}
+
+	var err error
+
+	switch operand {
+	case "=", "==", "is":
+		result.Matched = lValue == rValue
+	case "!=", "not":
+		result.Matched = lValue != rValue
+	case "<", "<=", ">", ">=":
+		result.Matched = compareOrdered(operand, lValue, rValue)
+	case "regexp":
+		var re *regexp.Regexp
+		if re, err = regexp.Compile(rValue); err == nil {
+			result.Matched = re.MatchString(lValue)
+		}
+	case "version", "semver":
+		result.Matched, err = MatchVersion(lValue, rValue, operand == "semver")
+	case "set_contains", "set_contains_all":
+		result.Matched = setContains(lValue, rValue, true)
+	case "set_contains_any":
+		result.Matched = setContains(lValue, rValue, false)
+	default:
+		err = fmt.Errorf("unknown operand %q", operand)
+	}
+
+	switch {
+	case err != nil:
+		result.Matched = false
+		result.Reason = err.Error()
+	case !result.Matched:
+		result.Reason = fmt.Sprintf("%q does not satisfy %s %s", lValue, operand, rValue)
+	}
+
+	return result
+}
+
+// compareOrdered compares numbers numerically and anything else lexically,
+// as the scheduler does
+func compareOrdered(operand, l, r string) bool {
+	cmp := strings.Compare(l, r)
+
+	lf, lErr := strconv.ParseFloat(l, 64)
+	rf, rErr := strconv.ParseFloat(r, 64)
+	if lErr == nil && rErr == nil {
+		switch {
+		case lf < rf:
+			cmp = -1
+		case lf > rf:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+
+	switch operand {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// setContains reports whether the comma separated set l holds all, or any,
+// of the items of r
+func setContains(l, r string, all bool) bool {
+	have := map[string]bool{}
+	for _, item := range strings.Split(l, ",") {
+		have[strings.TrimSpace(item)] = true
+	}
+
+	for _, item := range strings.Split(r, ",") {
+		ok := have[strings.TrimSpace(item)]
+		if ok && !all {
+			return true
+		}
+		if !ok && all {
+			return false
+		}
+	}
+
+	return all
+}
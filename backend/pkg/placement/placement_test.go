@@ -0,0 +1,76 @@
+package placement_test
+
+import (
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/caravan-nomad/caravan/backend/pkg/placement"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	node := &api.Node{
+		ID:         "node-1",
+		Name:       "client-1",
+		Datacenter: "dc1",
+		Attributes: map[string]string{
+			"kernel.name":        "linux",
+			"cpu.numcores":       "16",
+			"nomad.version":      "1.7.2",
+			"driver.docker":      "1",
+			"unique.platform":    "aws",
+			"platform.aws.zones": "a,b,c",
+		},
+		Meta: map[string]string{"rack": "r12"},
+	}
+
+	tests := []struct {
+		lTarget, operand, rTarget string
+		matched, evaluated        bool
+	}{
+		{"${attr.kernel.name}", "", "linux", true, true},
+		{"${attr.kernel.name}", "!=", "linux", false, true},
+		{"${attr.cpu.numcores}", ">=", "8", true, true},
+		{"${attr.cpu.numcores}", "<", "9", false, true},
+		{"${meta.rack}", "regexp", "^r1[0-9]$", true, true},
+		{"${attr.nomad.version}", "version", ">= 1.6, < 2.0", true, true},
+		{"${attr.nomad.version}", "semver", "~> 1.6.0", false, true},
+		{"${attr.nomad.version}", "version", "~> 1.6", true, true},
+		{"${attr.platform.aws.zones}", "set_contains", "a,c", true, true},
+		{"${attr.platform.aws.zones}", "set_contains_any", "d,b", true, true},
+		{"${attr.platform.aws.zones}", "set_contains_all", "a,d", false, true},
+		{"${attr.driver.docker}", "is_set", "", true, true},
+		{"${attr.driver.podman}", "is_set", "", false, true},
+		{"${attr.driver.podman}", "is_not_set", "", true, true},
+		{"${attr.driver.podman}", "=", "1", false, true},
+		{"${node.datacenter}-${meta.rack}", "=", "dc1-r12", true, true},
+		{"", "distinct_hosts", "true", true, false},
+	}
+
+	for _, tt := range tests {
+		name := placement.Describe(tt.lTarget, tt.operand, tt.rTarget)
+		result := placement.Check(&api.Constraint{LTarget: tt.lTarget, Operand: tt.operand, RTarget: tt.rTarget}, node)
+
+		assert.Equal(t, tt.matched, result.Matched, name)
+		assert.Equal(t, tt.evaluated, result.Evaluated, name)
+		if !tt.matched {
+			assert.NotEmpty(t, result.Reason, name)
+		}
+	}
+}
+
+func TestMatchVersion(t *testing.T) {
+	ok, err := placement.MatchVersion("1.7.2-beta.1", "< 1.7.2", false)
+	assert.NoError(t, err)
+	assert.True(t, ok, "pre-releases come before their release")
+
+	ok, err = placement.MatchVersion("v1.7", ">= 1.7.0", false)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = placement.MatchVersion("1.7", ">= 1.7.0", true)
+	assert.Error(t, err, "semver needs three segments")
+
+	_, err = placement.MatchVersion("1.7.0", ">= one", false)
+	assert.Error(t, err)
+}
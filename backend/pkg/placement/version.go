@@ -0,0 +1,136 @@
+package placement
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// version is a parsed version number, such as 1.7.2-beta.1
+type version struct {
+	segments   []int
+	prerelease string
+}
+
+func parseVersion(s string, strict bool) (version, error) {
+	var v version
+
+	raw := strings.TrimSpace(s)
+	if !strict {
+		raw = strings.TrimPrefix(raw, "v")
+	}
+	raw, _, _ = strings.Cut(raw, "+")
+	raw, v.prerelease, _ = strings.Cut(raw, "-")
+
+	parts := strings.Split(raw, ".")
+	if strict && len(parts) != 3 {
+		return v, fmt.Errorf("%q is not a semantic version", s)
+	}
+
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("%q is not a version", s)
+		}
+		v.segments = append(v.segments, n)
+	}
+
+	return v, nil
+}
+
+func (v version) compare(o version) int {
+	for i := 0; i < max(len(v.segments), len(o.segments)); i++ {
+		a, b := segment(v.segments, i), segment(o.segments, i)
+		if a != b {
+			if a < b {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case v.prerelease == o.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1
+	case o.prerelease == "":
+		return -1
+	}
+
+	return strings.Compare(v.prerelease, o.prerelease)
+}
+
+func segment(segments []int, i int) int {
+	if i < len(segments) {
+		return segments[i]
+	}
+
+	return 0
+}
+
+// MatchVersion reports whether a version satisfies comma separated
+// constraints such as ">= 1.2, < 2.0" or "~> 1.4". Strict requires both to
+// be semantic versions, as the semver operand does.
+func MatchVersion(v, constraints string, strict bool) (bool, error) {
+	have, err := parseVersion(v, strict)
+	if err != nil {
+		return false, err
+	}
+
+	for _, clause := range strings.Split(constraints, ",") {
+		clause = strings.TrimSpace(clause)
+
+		op := "="
+		for _, candidate := range []string{"~>", ">=", "<=", "!=", ">", "<", "="} {
+			if rest, ok := strings.CutPrefix(clause, candidate); ok {
+				op, clause = candidate, strings.TrimSpace(rest)
+				break
+			}
+		}
+
+		want, err := parseVersion(clause, strict)
+		if err != nil {
+			return false, err
+		}
+
+		cmp := have.compare(want)
+
+		var ok bool
+		switch op {
+		case "=":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		case "~>":
+			ok = cmp >= 0 && have.compare(pessimisticBound(want)) < 0
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// pessimisticBound is the first version "~> v" excludes: ~> 1.2.3 allows
+// up to 1.3, and ~> 1.2 up to 2.0
+func pessimisticBound(v version) version {
+	if len(v.segments) < 2 {
+		return version{segments: []int{v.segments[0] + 1}}
+	}
+
+	bound := append([]int(nil), v.segments[:len(v.segments)-1]...)
+	bound[len(bound)-1]++
+
+	return version{segments: bound}
+}
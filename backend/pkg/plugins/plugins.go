@@ -2,6 +2,8 @@ package plugins
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -23,6 +25,9 @@ const (
 	PluginListKey           = "PLUGIN_LIST"
 	PluginCanSendRefreshKey = "PLUGIN_CAN_SEND_REFRESH"
 	subFolderWatchInterval  = 5 * time.Second
+	// WatchDebounce is how long the plugin directories must be quiet after a
+	// change before the plugins are refreshed
+	WatchDebounce = 500 * time.Millisecond
 )
 
 // PluginMetadata represents metadata about a plugin including its source type.
@@ -33,6 +38,11 @@ type PluginMetadata struct {
 	Type string `json:"type"`
 	// Name is the plugin's folder name
 	Name string `json:"name"`
+	// Hash is the subresource integrity hash of the plugin's main.js. It
+	// changes with the bundle, so it also busts caches of the old one.
+	Hash string `json:"hash,omitempty"`
+	// ModTime is when the plugin's main.js or package.json last changed
+	ModTime time.Time `json:"modTime"`
 }
 
 const (
@@ -61,6 +71,48 @@ func Watch(path string, notify chan<- string) {
 	}
 }
 
+// Debounce forwards the events of in to out once no more arrive for wait, so
+// a burst of changes, such as a plugin build writing many files, is passed on
+// as its last event. A burst that does not settle is still passed on every
+// 10 waits. out is closed once in is.
+func Debounce(in <-chan string, out chan<- string, wait time.Duration) {
+	defer close(out)
+
+	timer := time.NewTimer(wait)
+	timer.Stop()
+
+	var (
+		pending string
+		first   time.Time
+	)
+
+	for {
+		select {
+		case event, ok := <-in:
+			if !ok {
+				if !first.IsZero() {
+					out <- pending
+				}
+				return
+			}
+
+			if first.IsZero() {
+				first = time.Now()
+			}
+			pending = event
+
+			delay := wait
+			if left := time.Until(first.Add(10 * wait)); left < delay {
+				delay = max(left, 0)
+			}
+			timer.Reset(delay)
+		case <-timer.C:
+			out <- pending
+			first = time.Time{}
+		}
+	}
+}
+
 // periodicallyWatchSubfolders periodically walks the path and adds any new directories to the watcher.
 // This is needed because fsnotify doesn't watch subfolders.
 func periodicallyWatchSubfolders(watcher *fsnotify.Watcher, path string, interval time.Duration) {
@@ -153,22 +205,12 @@ func GeneratePluginPaths(
 
 	// Add shipped plugins (lowest priority)
 	for _, pluginURL := range pluginListURLStatic {
-		pluginName := filepath.Base(pluginURL)
-		pluginList = append(pluginList, PluginMetadata{
-			Path: pluginURL,
-			Type: "shipped",
-			Name: pluginName,
-		})
+		pluginList = append(pluginList, newPluginMetadata(staticPluginDir, pluginURL, PluginTypeShipped))
 	}
 
 	// Add user-installed plugins (medium priority)
 	for _, pluginURL := range pluginListURLUser {
-		pluginName := filepath.Base(pluginURL)
-		pluginList = append(pluginList, PluginMetadata{
-			Path: pluginURL,
-			Type: "user",
-			Name: pluginName,
-		})
+		pluginList = append(pluginList, newPluginMetadata(userPluginDir, pluginURL, PluginTypeUser))
 	}
 
 	// Add development plugins (highest priority)
@@ -189,16 +231,41 @@ func GeneratePluginPaths(
 			}, nil, "Treating catalog-installed plugin in development directory as user plugin")
 		}
 
-		pluginList = append(pluginList, PluginMetadata{
-			Path: pluginURL,
-			Type: pluginType,
-			Name: pluginName,
-		})
+		pluginList = append(pluginList, newPluginMetadata(pluginDir, pluginURL, pluginType))
 	}
 
 	return pluginList, nil
 }
 
+// newPluginMetadata describes the plugin served at pluginURL from its folder
+// in pluginDir, with the hash and modification time of its bundle.
+func newPluginMetadata(pluginDir, pluginURL, pluginType string) PluginMetadata {
+	pluginName := filepath.Base(pluginURL)
+	metadata := PluginMetadata{
+		Path: pluginURL,
+		Type: pluginType,
+		Name: pluginName,
+	}
+
+	mainJS, err := os.ReadFile(filepath.Join(pluginDir, pluginName, "main.js"))
+	if err != nil {
+		// The plugin is being removed or rewritten, the next event refreshes it
+		return metadata
+	}
+
+	sum := sha256.Sum256(mainJS)
+	metadata.Hash = "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+
+	for _, file := range []string{"main.js", "package.json"} {
+		info, err := os.Stat(filepath.Join(pluginDir, pluginName, file))
+		if err == nil && info.ModTime().After(metadata.ModTime) {
+			metadata.ModTime = info.ModTime().UTC()
+		}
+	}
+
+	return metadata
+}
+
 // isCatalogInstalledPlugin checks if a plugin was installed via the catalog.
 // Catalog-installed plugins have isManagedByCaravanPlugin: true in their package.json.
 func isCatalogInstalledPlugin(pluginDir, pluginName string) bool {
@@ -362,25 +429,35 @@ func HandlePluginEvents(staticPluginDir, userPluginDir, pluginDir string,
 	notify <-chan string, cache cache.Cache[interface{}],
 ) {
 	for range notify {
-		// Set the refresh signal only if we cannot send it. We prevent it here
-		// because we only want to send refresh signals that *happen after* we are
-		// allowed to send them.
-		err := cache.Set(context.Background(), PluginRefreshKey, canSendRefresh(cache))
-		if err != nil {
-			logger.Log(logger.LevelError, nil, err, "setting plugin refresh key")
-		}
+		RefreshPluginsCache(staticPluginDir, userPluginDir, pluginDir, cache)
+	}
+}
 
-		// generate the plugin list
-		pluginList, err := GeneratePluginPaths(staticPluginDir, userPluginDir, pluginDir)
-		if err != nil && !os.IsNotExist(err) {
-			logger.Log(logger.LevelError, nil, err, "generating plugins path")
-		}
+// RefreshPluginsCache updates the plugin list and plugin refresh key in the
+// cache after a change to the plugins, and returns the new list.
+func RefreshPluginsCache(staticPluginDir, userPluginDir, pluginDir string,
+	cache cache.Cache[interface{}],
+) []PluginMetadata {
+	// Set the refresh signal only if we cannot send it. We prevent it here
+	// because we only want to send refresh signals that *happen after* we are
+	// allowed to send them.
+	err := cache.Set(context.Background(), PluginRefreshKey, canSendRefresh(cache))
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "setting plugin refresh key")
+	}
 
-		err = cache.Set(context.Background(), PluginListKey, pluginList)
-		if err != nil {
-			logger.Log(logger.LevelError, nil, err, "setting plugin list key")
-		}
+	// generate the plugin list
+	pluginList, err := GeneratePluginPaths(staticPluginDir, userPluginDir, pluginDir)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Log(logger.LevelError, nil, err, "generating plugins path")
+	}
+
+	err = cache.Set(context.Background(), PluginListKey, pluginList)
+	if err != nil {
+		logger.Log(logger.LevelError, nil, err, "setting plugin list key")
 	}
+
+	return pluginList
 }
 
 // PopulatePluginsCache populates the plugin list and plugin refresh key in the cache.
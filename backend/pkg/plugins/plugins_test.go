@@ -2,10 +2,12 @@ package plugins_test
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"net/http/httptest"
 	"os"
 	"path"
+	"strings"
 	"testing"
 	"time"
 
@@ -613,3 +615,62 @@ func TestDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestDebounce(t *testing.T) {
+	in := make(chan string)
+	out := make(chan string, 10)
+
+	go plugins.Debounce(in, out, 50*time.Millisecond)
+
+	// A build writing many files in quick succession
+	for i := 0; i < 20; i++ {
+		in <- fmt.Sprintf("plugin/file-%d.js:WRITE", i)
+	}
+
+	select {
+	case event := <-out:
+		require.Equal(t, "plugin/file-19.js:WRITE", event)
+	case <-time.After(time.Second):
+		t.Fatal("no event after the burst settled")
+	}
+
+	// A change that is still pending when the watchers stop is not lost
+	in <- "plugin/main.js:WRITE"
+	close(in)
+
+	events := []string{}
+	for event := range out {
+		events = append(events, event)
+	}
+
+	require.Equal(t, []string{"plugin/main.js:WRITE"}, events)
+}
+
+func TestPluginBundleHash(t *testing.T) {
+	dir := t.TempDir()
+	pluginDir := path.Join(dir, "my-plugin")
+	require.NoError(t, os.Mkdir(pluginDir, 0o755))
+	require.NoError(t, os.WriteFile(path.Join(pluginDir, "package.json"), []byte(`{}`), 0o600))
+
+	mainJS := path.Join(pluginDir, "main.js")
+	require.NoError(t, os.WriteFile(mainJS, []byte("console.log('v1')"), 0o600))
+
+	pluginList, err := plugins.GeneratePluginPaths("", "", dir)
+	require.NoError(t, err)
+	require.Len(t, pluginList, 1)
+
+	first := pluginList[0]
+	assert.True(t, strings.HasPrefix(first.Hash, "sha256-"), first.Hash)
+	assert.False(t, first.ModTime.IsZero())
+
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.WriteFile(mainJS, []byte("console.log('v2')"), 0o600))
+	require.NoError(t, os.Chtimes(mainJS, later, later))
+
+	pluginList, err = plugins.GeneratePluginPaths("", "", dir)
+	require.NoError(t, err)
+	require.Len(t, pluginList, 1)
+
+	assert.NotEqual(t, first.Hash, pluginList[0].Hash, "a new bundle has a new hash")
+	assert.True(t, pluginList[0].ModTime.After(first.ModTime))
+}
@@ -0,0 +1,54 @@
+package spa_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/spa"
+)
+
+// serveBench serves path with handler once per iteration
+func serveBench(b *testing.B, handler http.Handler, path string) {
+	b.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			b.Fatalf("%s returned %d", path, rr.Code)
+		}
+	}
+}
+
+func BenchmarkSpaHandler(b *testing.B) {
+	handler := spa.NewHandler(staticTestPath, "index.html", "/caravan")
+
+	b.Run("asset", func(b *testing.B) {
+		serveBench(b, handler, "/caravan/example.css")
+	})
+
+	b.Run("index_fallback", func(b *testing.B) {
+		serveBench(b, handler, "/caravan/jobs/web")
+	})
+}
+
+func BenchmarkEmbeddedSpaHandler(b *testing.B) {
+	handler := spa.NewEmbeddedHandler(createTestFS(map[string]*fstest.MapFile{
+		"static/index.html":  {Data: []byte(getTestHTML())},
+		"static/example.css": {Data: []byte(".somecss { color: red; }")},
+	}), "index.html", "/caravan")
+
+	b.Run("asset", func(b *testing.B) {
+		serveBench(b, handler, "/caravan/example.css")
+	})
+
+	// The index has its base URL rewritten on every request
+	b.Run("index", func(b *testing.B) {
+		serveBench(b, handler, "/caravan/")
+	})
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// envelopePrefix marks encrypted values, telling them from the plain ones
+// written before encryption was enabled
+var envelopePrefix = []byte("caravan-envelope:v1:")
+
+// envelope is an encrypted value as stored: the value sealed with a data key
+// of its own, and that key wrapped with a master key of the keyring
+type envelope struct {
+	KeyID string `json:"kid"`
+	// DataKey is the wrapped data key
+	DataKey []byte `json:"dk"`
+	// Data is the nonce and the sealed value
+	Data []byte `json:"data"`
+}
+
+type encryptedStore struct {
+	Store
+	keys    Keyring
+	buckets map[string]bool
+}
+
+// NewEncrypted encrypts the values of buckets, such as those holding tokens,
+// before they reach s. Each value is sealed with AES-GCM under a new data
+// key, which keys wraps with its master key. Values of buckets are also
+// bound to their key, so they cannot be swapped around in the store.
+//
+// Plain values written before encryption was enabled are still read, until
+// Reencrypt rewrites them.
+func NewEncrypted(s Store, keys Keyring, buckets ...string) Store {
+	e := &encryptedStore{Store: s, keys: keys, buckets: map[string]bool{}}
+	for _, b := range buckets {
+		e.buckets[b] = true
+	}
+
+	return e
+}
+
+func (s *encryptedStore) Get(ctx context.Context, bucket, key string) ([]byte, error) {
+	value, err := s.Store.Get(ctx, bucket, key)
+	if err != nil || !s.buckets[bucket] {
+		return value, err
+	}
+
+	return s.open(ctx, bucket, key, value)
+}
+
+func (s *encryptedStore) Put(ctx context.Context, bucket, key string, value []byte) error {
+	if !s.buckets[bucket] {
+		return s.Store.Put(ctx, bucket, key, value)
+	}
+
+	sealed, err := s.seal(ctx, bucket, key, value)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.Put(ctx, bucket, key, sealed)
+}
+
+func (s *encryptedStore) List(ctx context.Context, bucket, prefix string) ([]Entry, error) {
+	entries, err := s.Store.List(ctx, bucket, prefix)
+	if err != nil || !s.buckets[bucket] {
+		return entries, err
+	}
+
+	for i, e := range entries {
+		if entries[i].Value, err = s.open(ctx, bucket, e.Key, e.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *encryptedStore) Scan(ctx context.Context, bucket string, r KeyRange, limit int) ([]Entry, error) {
+	entries, err := s.Store.Scan(ctx, bucket, r, limit)
+	if err != nil || !s.buckets[bucket] {
+		return entries, err
+	}
+
+	for i, e := range entries {
+		if entries[i].Value, err = s.open(ctx, bucket, e.Key, e.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *encryptedStore) seal(ctx context.Context, bucket, key string, value []byte) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	keyID, wrapped, err := s.keys.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrapping data key: %w", err)
+	}
+
+	data, err := sealGCM(dataKey, value, []byte(bucket+"/"+key))
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := json.Marshal(envelope{KeyID: keyID, DataKey: wrapped, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, envelopePrefix...), env...), nil
+}
+
+func (s *encryptedStore) open(ctx context.Context, bucket, key string, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, envelopePrefix) {
+		return value, nil
+	}
+
+	var env envelope
+	if err := json.Unmarshal(value[len(envelopePrefix):], &env); err != nil {
+		return nil, fmt.Errorf("decoding encrypted %s/%s: %w", bucket, key, err)
+	}
+
+	dataKey, err := s.keys.Unwrap(ctx, env.KeyID, env.DataKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping the data key of %s/%s: %w", bucket, key, err)
+	}
+
+	plain, err := openGCM(dataKey, env.Data, []byte(bucket+"/"+key))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s/%s: %w", bucket, key, err)
+	}
+
+	return plain, nil
+}
+
+// Reencrypt rewrites every value of the encrypted buckets of s, a store of
+// NewEncrypted, under the current master key of its keyring. It returns how
+// many values were rewritten. After a rotation, once it returned, the
+// previous master keys are no longer needed.
+func Reencrypt(ctx context.Context, s Store) (int, error) {
+	e, ok := s.(*encryptedStore)
+	if !ok {
+		return 0, errors.New("the store is not encrypted")
+	}
+
+	n := 0
+	for bucket := range e.buckets {
+		entries, err := e.List(ctx, bucket, "")
+		if err != nil {
+			return n, err
+		}
+
+		for _, entry := range entries {
+			if err := e.Put(ctx, bucket, entry.Key, entry.Value); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func sealGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
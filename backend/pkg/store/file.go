@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type fileStore struct {
+	dir     string
+	buckets map[string]map[string][]byte
+	mutex   sync.Mutex
+}
+
+// NewFile creates a store that writes every bucket to <dir>/<bucket>.json.
+// Buckets are loaded on first use and rewritten atomically on every change.
+func NewFile(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating data directory: %w", err)
+	}
+
+	return &fileStore{dir: dir, buckets: make(map[string]map[string][]byte)}, nil
+}
+
+// bucket returns the loaded bucket, reading it from disk if needed.
+// The caller must hold the mutex.
+func (s *fileStore) bucket(name string) (map[string][]byte, error) {
+	if err := validateBucket(name); err != nil {
+		return nil, err
+	}
+
+	if b, ok := s.buckets[name]; ok {
+		return b, nil
+	}
+
+	b := make(map[string][]byte)
+
+	data, err := os.ReadFile(s.path(name))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, fmt.Errorf("reading bucket %s: %w", name, err)
+		}
+	}
+
+	s.buckets[name] = b
+
+	return b, nil
+}
+
+func (s *fileStore) path(bucket string) string {
+	return filepath.Join(s.dir, bucket+".json")
+}
+
+// flush writes a bucket to disk. The caller must hold the mutex.
+func (s *fileStore) flush(name string) error {
+	data, err := json.Marshal(s.buckets[name])
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(name))
+}
+
+func (s *fileStore) Get(ctx context.Context, bucket, key string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, err := s.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := b[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return clone(value), nil
+}
+
+func (s *fileStore) Put(ctx context.Context, bucket, key string, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, err := s.bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	old, existed := b[key]
+	b[key] = clone(value)
+
+	if err := s.flush(bucket); err != nil {
+		if existed {
+			b[key] = old
+		} else {
+			delete(b, key)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *fileStore) Delete(ctx context.Context, bucket, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, err := s.bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	old, existed := b[key]
+	if !existed {
+		return nil
+	}
+
+	delete(b, key)
+
+	if err := s.flush(bucket); err != nil {
+		b[key] = old
+		return err
+	}
+
+	return nil
+}
+
+func (s *fileStore) List(ctx context.Context, bucket, prefix string) ([]Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, err := s.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return listBucket(b, prefix), nil
+}
+
+func (s *fileStore) Scan(ctx context.Context, bucket string, r KeyRange, limit int) ([]Entry, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, err := s.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanBucket(b, r, limit), nil
+}
+
+func (s *fileStore) Close() error {
+	return nil
+}
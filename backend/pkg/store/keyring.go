@@ -0,0 +1,221 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Keyring wraps the data keys of encrypted values with a master key.
+type Keyring interface {
+	// Wrap encrypts a data key with the current master key, and returns the
+	// ID of that key along with it.
+	Wrap(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// Unwrap decrypts a data key wrapped with the master key of keyID.
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// vaultTransitScheme prefixes the master key setting of a Vault Transit key
+const vaultTransitScheme = "vault-transit:"
+
+// OpenKeyring returns the keyring of a master key setting, either:
+//
+//	<base64 key>                  a local AES-256 master key, such as from
+//	                              openssl rand -base64 32
+//	vault-transit:<mount>/<key>   a key of Vault's Transit engine, reached with
+//	                              VAULT_ADDR and VAULT_TOKEN
+//
+// previous is a comma-separated list of the local master keys rotated out,
+// which still unwrap the data keys of values not re-encrypted yet.
+func OpenKeyring(masterKey, previous string) (Keyring, error) {
+	var old [][]byte
+	for _, p := range strings.Split(previous, ",") {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+		key, err := ParseMasterKey(p)
+		if err != nil {
+			return nil, fmt.Errorf("previous master key: %w", err)
+		}
+		old = append(old, key)
+	}
+
+	if path, ok := strings.CutPrefix(masterKey, vaultTransitScheme); ok {
+		mount, name, ok := strings.Cut(path, "/")
+		if !ok || mount == "" || name == "" {
+			return nil, fmt.Errorf("the Vault Transit key must be %s<mount>/<key>, not %q", vaultTransitScheme, masterKey)
+		}
+
+		addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+		if addr == "" || token == "" {
+			return nil, errors.New("the Vault Transit key needs VAULT_ADDR and VAULT_TOKEN")
+		}
+
+		return NewVaultTransit(addr, token, mount, name, old...), nil
+	}
+
+	key, err := ParseMasterKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLocalKeyring(key, old...), nil
+}
+
+// ParseMasterKey decodes a base64 local master key of 32 bytes.
+func ParseMasterKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("the master key is not base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("the master key must be 32 bytes, not %d", len(key))
+	}
+
+	return key, nil
+}
+
+type localKeyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewLocalKeyring wraps data keys with current, a 32-byte master key, and
+// unwraps them with it or with one of previous.
+func NewLocalKeyring(current []byte, previous ...[]byte) Keyring {
+	k := &localKeyring{current: localKeyID(current), keys: map[string][]byte{}}
+	k.keys[k.current] = current
+	for _, key := range previous {
+		k.keys[localKeyID(key)] = key
+	}
+
+	return k
+}
+
+// localKeyID names a master key without revealing it
+func localKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return "local:" + hex.EncodeToString(sum[:8])
+}
+
+func (k *localKeyring) Wrap(_ context.Context, dataKey []byte) (string, []byte, error) {
+	wrapped, err := sealGCM(k.keys[k.current], dataKey, []byte(k.current))
+	return k.current, wrapped, err
+}
+
+func (k *localKeyring) Unwrap(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %s", keyID)
+	}
+
+	return openGCM(key, wrapped, []byte(keyID))
+}
+
+type vaultTransit struct {
+	addr, token string
+	mount, key  string
+	client      *http.Client
+	local       Keyring
+}
+
+// NewVaultTransit wraps data keys with the Transit key of Vault at addr.
+// Rotating the Transit key in Vault rotates the master key; Reencrypt then
+// rewraps the data keys under its latest version. previous are local master
+// keys, for moving a store from them to Vault.
+func NewVaultTransit(addr, token, mount, key string, previous ...[]byte) Keyring {
+	v := &vaultTransit{
+		addr:   strings.TrimSuffix(addr, "/"),
+		token:  token,
+		mount:  strings.Trim(mount, "/"),
+		key:    key,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	if len(previous) > 0 {
+		v.local = NewLocalKeyring(previous[0], previous[1:]...)
+	}
+
+	return v
+}
+
+func (v *vaultTransit) keyID() string {
+	return vaultTransitScheme + v.mount + "/" + v.key
+}
+
+func (v *vaultTransit) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	var resp struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	err := v.call(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}, &resp)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return v.keyID(), []byte(resp.Ciphertext), nil
+}
+
+func (v *vaultTransit) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != v.keyID() {
+		if v.local != nil && strings.HasPrefix(keyID, "local:") {
+			return v.local.Unwrap(ctx, keyID, wrapped)
+		}
+		return nil, fmt.Errorf("unknown master key %s", keyID)
+	}
+
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := v.call(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &resp); err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
+
+// call posts body to the Transit endpoint op of the key, decoding the data of
+// the response into out
+func (v *vaultTransit) call(ctx context.Context, op string, body any, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	u := v.addr + "/v1/" + v.mount + "/" + op + "/" + url.PathEscape(v.key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault transit %s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("vault transit %s: %s: %s", op, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("vault transit %s: %w", op, err)
+	}
+
+	return json.Unmarshal(envelope.Data, out)
+}
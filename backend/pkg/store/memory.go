@@ -0,0 +1,138 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type memoryStore struct {
+	buckets map[string]map[string][]byte
+	mutex   sync.RWMutex
+}
+
+// NewMemory creates a store that keeps all data in memory.
+func NewMemory() Store {
+	return &memoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *memoryStore) Get(ctx context.Context, bucket, key string) ([]byte, error) {
+	if err := validateBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	value, ok := s.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return clone(value), nil
+}
+
+func (s *memoryStore) Put(ctx context.Context, bucket, key string, value []byte) error {
+	if err := validateBucket(bucket); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string][]byte)
+	}
+
+	s.buckets[bucket][key] = clone(value)
+
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, bucket, key string) error {
+	if err := validateBucket(bucket); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.buckets[bucket], key)
+
+	return nil
+}
+
+func (s *memoryStore) List(ctx context.Context, bucket, prefix string) ([]Entry, error) {
+	if err := validateBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return listBucket(s.buckets[bucket], prefix), nil
+}
+
+func (s *memoryStore) Scan(ctx context.Context, bucket string, r KeyRange, limit int) ([]Entry, error) {
+	if err := validateBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return scanBucket(s.buckets[bucket], r, limit), nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// listBucket returns the entries of a bucket with the given prefix, sorted by key.
+func listBucket(bucket map[string][]byte, prefix string) []Entry {
+	entries := []Entry{}
+
+	for key, value := range bucket {
+		if strings.HasPrefix(key, prefix) {
+			entries = append(entries, Entry{Key: key, Value: clone(value)})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	return entries
+}
+
+// scanBucket returns the first limit entries of a bucket in r, in the order
+// of r. Only the keys are sorted, the values of the page alone are copied.
+func scanBucket(bucket map[string][]byte, r KeyRange, limit int) []Entry {
+	keys := []string{}
+	for key := range bucket {
+		if r.contains(key) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	if r.Descending {
+		slices.Reverse(keys)
+	}
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	entries := make([]Entry, len(keys))
+	for i, key := range keys {
+		entries[i] = Entry{Key: key, Value: clone(bucket[key])}
+	}
+
+	return entries
+}
+
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
@@ -0,0 +1,285 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Migrations are golang-migrate style files per dialect, such as
+// migrations/postgres/0002_add_index.up.sql and its .down.sql
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_\w+\.(up|down)\.sql$`)
+
+// ErrSchemaTooNew is returned for a schema migrated by a newer build, which
+// this one may misread. Running the newer build again, or migrating down
+// with it, fixes it.
+var ErrSchemaTooNew = errors.New("the store schema is newer than this build of Caravan")
+
+// ErrDirtySchema is returned once a migration failed halfway. The schema
+// needs fixing by hand before forcing its version.
+var ErrDirtySchema = errors.New("a migration of the store schema failed halfway")
+
+// Migrator is implemented by the stores with a schema. Memory and file
+// stores have none.
+type Migrator interface {
+	// SchemaVersion returns the version of the schema, 0 for a new
+	// database, and whether a migration to it failed halfway.
+	SchemaVersion(ctx context.Context) (version int, dirty bool, err error)
+	// LatestSchemaVersion is the version this build migrates up to.
+	LatestSchemaVersion() int
+	// MigrateTo migrates the schema up or down to version.
+	MigrateTo(ctx context.Context, version int) error
+	// ForceSchemaVersion records the schema at version, without migrating,
+	// once a failed migration was fixed by hand.
+	ForceSchemaVersion(ctx context.Context, version int) error
+}
+
+// AsMigrator returns the Migrator of s, or of the store an encrypted s wraps.
+func AsMigrator(s Store) (Migrator, bool) {
+	if e, ok := s.(*encryptedStore); ok {
+		s = e.Store
+	}
+
+	m, ok := s.(Migrator)
+	return m, ok
+}
+
+// Migrate migrates the schema of s, if it has one, up to the latest version.
+// It refuses schemas newer than this build, as after a downgrade, and those
+// a failed migration left dirty.
+func Migrate(ctx context.Context, s Store) error {
+	m, ok := AsMigrator(s)
+	if !ok {
+		return nil
+	}
+
+	version, dirty, err := m.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w at version %d", ErrDirtySchema, version)
+	}
+	if latest := m.LatestSchemaVersion(); version > latest {
+		return fmt.Errorf("%w: it is at version %d, this build knows up to %d", ErrSchemaTooNew, version, latest)
+	}
+
+	return m.MigrateTo(ctx, m.LatestSchemaVersion())
+}
+
+type migration struct {
+	version  int
+	up, down string
+}
+
+// loadMigrations reads the migrations of a dialect, by version
+func loadMigrations(dialect string) ([]migration, error) {
+	dir := path.Join("migrations", dialect)
+
+	files, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+
+	for _, f := range files {
+		match := migrationName.FindStringSubmatch(f.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", f.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(migrationFiles, path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{version: version}
+			byVersion[version] = m
+		}
+		if match[2] == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d of %s needs both an up and a down file", m.version, dialect)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+
+	return migrations, nil
+}
+
+// The version table follows golang-migrate's, so its CLI can fix a dirty
+// schema too
+const versionTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version BIGINT NOT NULL PRIMARY KEY,
+	dirty   BOOLEAN NOT NULL
+)`
+
+func (s *sqlStore) LatestSchemaVersion() int {
+	if len(s.migrations) == 0 {
+		return 0
+	}
+
+	return s.migrations[len(s.migrations)-1].version
+}
+
+func (s *sqlStore) SchemaVersion(ctx context.Context) (int, bool, error) {
+	if _, err := s.db.ExecContext(ctx, versionTable); err != nil {
+		return 0, false, err
+	}
+
+	return s.schemaVersion(ctx, s.db)
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *sqlStore) schemaVersion(ctx context.Context, q queryer) (int, bool, error) {
+	var version int
+	var dirty bool
+
+	err := q.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
+
+func (s *sqlStore) MigrateTo(ctx context.Context, target int) error {
+	conn, unlock, err := s.lockMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Read under the lock, another instance may have migrated meanwhile
+	version, dirty, err := s.schemaVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w at version %d", ErrDirtySchema, version)
+	}
+	if target > s.LatestSchemaVersion() || target < 0 {
+		return fmt.Errorf("no schema version %d, this build knows up to %d", target, s.LatestSchemaVersion())
+	}
+	if version > s.LatestSchemaVersion() {
+		return fmt.Errorf("%w: it is at version %d, this build knows up to %d", ErrSchemaTooNew, version, s.LatestSchemaVersion())
+	}
+
+	for version != target {
+		var step migration
+		var next int
+		var script string
+
+		if target > version {
+			i := sort.Search(len(s.migrations), func(i int) bool { return s.migrations[i].version > version })
+			step = s.migrations[i]
+			next, script = step.version, step.up
+		} else {
+			i := sort.Search(len(s.migrations), func(i int) bool { return s.migrations[i].version >= version })
+			if i == len(s.migrations) || s.migrations[i].version != version {
+				return fmt.Errorf("no migration down from schema version %d", version)
+			}
+			step = s.migrations[i]
+			next, script = 0, step.down
+			if i > 0 {
+				next = s.migrations[i-1].version
+			}
+		}
+
+		if err := s.setSchemaVersion(ctx, conn, next, true); err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, script); err != nil {
+			return fmt.Errorf("migrating the store schema to version %d: %w", next, err)
+		}
+		if err := s.setSchemaVersion(ctx, conn, next, false); err != nil {
+			return err
+		}
+
+		version = next
+	}
+
+	return nil
+}
+
+func (s *sqlStore) ForceSchemaVersion(ctx context.Context, version int) error {
+	conn, unlock, err := s.lockMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.setSchemaVersion(ctx, conn, version, false)
+}
+
+// lockMigrations keeps other instances from migrating at the same time, on
+// the connection it returns
+func (s *sqlStore) lockMigrations(ctx context.Context) (*sql.Conn, func(), error) {
+	if _, err := s.db.ExecContext(ctx, versionTable); err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.dialect.lock == "" {
+		return conn, func() { conn.Close() }, nil
+	}
+
+	if _, err := conn.ExecContext(ctx, s.dialect.lock); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("locking the store schema: %w", err)
+	}
+
+	return conn, func() {
+		conn.ExecContext(context.Background(), s.dialect.unlock)
+		conn.Close()
+	}, nil
+}
+
+func (s *sqlStore) setSchemaVersion(ctx context.Context, conn *sql.Conn, version int, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, s.query(`INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)`),
+		version, dirty); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
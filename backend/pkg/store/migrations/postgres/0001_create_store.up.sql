@@ -0,0 +1,8 @@
+-- IF NOT EXISTS adopts the databases created before migrations. Keys
+-- compare byte by byte, like in the other stores, whatever the locale.
+CREATE TABLE IF NOT EXISTS caravan_store (
+	bucket TEXT NOT NULL,
+	key    TEXT COLLATE "C" NOT NULL,
+	value  BYTEA NOT NULL,
+	PRIMARY KEY (bucket, key)
+);
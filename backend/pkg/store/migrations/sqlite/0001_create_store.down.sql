@@ -0,0 +1 @@
+DROP TABLE caravan_store;
@@ -0,0 +1,7 @@
+-- IF NOT EXISTS adopts the databases created before migrations
+CREATE TABLE IF NOT EXISTS caravan_store (
+	bucket TEXT NOT NULL,
+	key    TEXT NOT NULL,
+	value  BLOB NOT NULL,
+	PRIMARY KEY (bucket, key)
+);
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidCursor is returned for a cursor that is malformed or whose entry
+// no longer matches the query, such as after it was deleted.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Query selects a page of the JSON values of a bucket.
+//
+// Entries are read in key order, so buckets keyed by time, such as the audit
+// log, get time ranges from Start and End and newest-first pages from
+// Descending. Unless the values are sorted by a field, a page is scanned from
+// its cursor, without reading the rest of the bucket.
+type Query[T any] struct {
+	// Prefix restricts the query to the keys with that prefix.
+	Prefix string
+	// Start and End bound the keys, Start included and End excluded; empty,
+	// they leave that side open.
+	Start, End string
+	// Filter keeps the values it returns true for; nil keeps all of them.
+	Filter func(*T) bool
+	// Less sorts the values by a field instead of by key. The key breaks ties,
+	// so pages stay stable. Sorting decodes every value in the key range.
+	Less func(a, b *T) bool
+	// Descending reverses the order.
+	Descending bool
+	// Cursor is the Next of the previous page; empty, the query starts from
+	// the first value.
+	Cursor string
+	// Limit is the most values in a page; 0 returns all of them.
+	Limit int
+}
+
+// Page is a page of query results.
+type Page[T any] struct {
+	Items []T `json:"items"`
+	// Next is the cursor of the following page, empty on the last one.
+	Next string `json:"next,omitempty"`
+}
+
+type queryItem[T any] struct {
+	key   string
+	value T
+}
+
+// QueryJSON runs q against bucket.
+func QueryJSON[T any](ctx context.Context, s Store, bucket string, q Query[T]) (Page[T], error) {
+	after, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	decode := func(e Entry) (T, bool, error) {
+		var v T
+		if err := json.Unmarshal(e.Value, &v); err != nil {
+			return v, false, fmt.Errorf("decoding %s/%s: %w", bucket, e.Key, err)
+		}
+
+		return v, q.Filter == nil || q.Filter(&v), nil
+	}
+
+	r := KeyRange{Prefix: q.Prefix, Start: q.Start, End: q.End, Descending: q.Descending}
+
+	// Without a field to sort by, the key order is the query order, so a
+	// page is scanned from the cursor and stops once it is full
+	if q.Less == nil {
+		return keyOrderPage(ctx, s, bucket, r, q.Limit, after, decode)
+	}
+
+	r.Descending = false
+	entries, err := s.Scan(ctx, bucket, r, 0)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	var items []queryItem[T]
+	for _, e := range entries {
+		v, keep, err := decode(e)
+		if err != nil {
+			return Page[T]{}, err
+		}
+		if keep {
+			items = append(items, queryItem[T]{key: e.Key, value: v})
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := &items[i], &items[j]
+		if q.Descending {
+			a, b = b, a
+		}
+		if q.Less(&a.value, &b.value) {
+			return true
+		}
+		if q.Less(&b.value, &a.value) {
+			return false
+		}
+		return a.key < b.key
+	})
+
+	start := 0
+	if after != "" {
+		start = -1
+		for i := range items {
+			if items[i].key == after {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return Page[T]{}, ErrInvalidCursor
+		}
+	}
+
+	page := Page[T]{Items: []T{}}
+	for i := start; i < len(items); i++ {
+		if q.Limit > 0 && len(page.Items) == q.Limit {
+			page.Next = encodeCursor(items[i-1].key)
+			break
+		}
+		page.Items = append(page.Items, items[i].value)
+	}
+
+	return page, nil
+}
+
+// keyOrderPage scans the entries of r from the cursor's key in batches of a
+// page and one more entry, the one telling whether there is a next page.
+// Filtered out entries take more batches. The cursor's entry may have been
+// deleted since, which does not matter: the page resumes past where it was.
+func keyOrderPage[T any](ctx context.Context, s Store, bucket string, r KeyRange, limit int, after string,
+	decode func(Entry) (T, bool, error),
+) (Page[T], error) {
+	batch := 0
+	if limit > 0 {
+		batch = limit + 1
+	}
+
+	page := Page[T]{Items: []T{}}
+	last := ""
+
+	for {
+		r.After = after
+		entries, err := s.Scan(ctx, bucket, r, batch)
+		if err != nil {
+			return Page[T]{}, err
+		}
+
+		for _, e := range entries {
+			v, keep, err := decode(e)
+			if err != nil {
+				return Page[T]{}, err
+			}
+			if !keep {
+				continue
+			}
+
+			if limit > 0 && len(page.Items) == limit {
+				page.Next = encodeCursor(last)
+				return page, nil
+			}
+
+			page.Items = append(page.Items, v)
+			last = e.Key
+		}
+
+		if batch == 0 || len(entries) < batch {
+			return page, nil
+		}
+		after = entries[len(entries)-1].Key
+	}
+}
+
+// Cursors are opaque to clients, so the way pages are keyed may change
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || strings.TrimSpace(string(key)) == "" {
+		return "", ErrInvalidCursor
+	}
+
+	return string(key), nil
+}
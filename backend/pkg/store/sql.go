@@ -0,0 +1,234 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+// sqlDialect is what differs between the SQL databases the store runs on
+type sqlDialect struct {
+	// name is also the directory of the dialect's migrations
+	name   string
+	driver string
+	// numbered placeholders are $1, $2... rather than ?
+	numbered bool
+	// lock and unlock serialize migrations across instances
+	lock, unlock string
+}
+
+var (
+	// SQLite databases are local to one instance, which has one connection
+	sqliteDialect = sqlDialect{
+		name:   BackendSQLite,
+		driver: sqliteDriver,
+	}
+	postgresDialect = sqlDialect{
+		name:     BackendPostgres,
+		driver:   "postgres",
+		numbered: true,
+		lock:     `SELECT pg_advisory_lock(7226846)`,
+		unlock:   `SELECT pg_advisory_unlock(7226846)`,
+	}
+)
+
+type sqlStore struct {
+	db         *sql.DB
+	dialect    sqlDialect
+	migrations []migration
+}
+
+// NewSQLite creates a store in the SQLite database at path, such as
+// <data-dir>/caravan.db. Its schema needs migrating before use.
+func NewSQLite(path string) (Store, error) {
+	if sqliteDriver == "" {
+		return nil, errors.New("this build of Caravan has no SQLite support, which needs cgo")
+	}
+
+	// One connection serializes the writes SQLite would otherwise fail as busy
+	return openSQL(sqliteDialect, "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL", 1)
+}
+
+// NewPostgres creates a store in the Postgres database of dsn, such as
+// postgres://caravan@db.internal/caravan?sslmode=verify-full. Its schema
+// needs migrating before use.
+func NewPostgres(dsn string) (Store, error) {
+	return openSQL(postgresDialect, dsn, 0)
+}
+
+func openSQL(dialect sqlDialect, dsn string, maxConns int) (Store, error) {
+	migrations, err := loadMigrations(dialect.name)
+	if err != nil {
+		return nil, fmt.Errorf("loading the %s migrations: %w", dialect.name, err)
+	}
+
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if maxConns > 0 {
+		db.SetMaxOpenConns(maxConns)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqlStore{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// query rewrites the ? placeholders of q for the dialect
+func (s *sqlStore) query(q string) string {
+	if !s.dialect.numbered {
+		return q
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range q {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func (s *sqlStore) Get(ctx context.Context, bucket, key string) ([]byte, error) {
+	if err := validateBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	var value []byte
+	err := s.db.QueryRowContext(ctx, s.query(`SELECT value FROM caravan_store WHERE bucket = ? AND key = ?`),
+		bucket, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	return value, err
+}
+
+func (s *sqlStore) Put(ctx context.Context, bucket, key string, value []byte) error {
+	if err := validateBucket(bucket); err != nil {
+		return err
+	}
+
+	// A nil value would be stored as NULL
+	if value == nil {
+		value = []byte{}
+	}
+
+	_, err := s.db.ExecContext(ctx, s.query(`INSERT INTO caravan_store (bucket, key, value) VALUES (?, ?, ?)
+		ON CONFLICT (bucket, key) DO UPDATE SET value = excluded.value`), bucket, key, value)
+
+	return err
+}
+
+func (s *sqlStore) Delete(ctx context.Context, bucket, key string) error {
+	if err := validateBucket(bucket); err != nil {
+		return err
+	}
+
+	_, err := s.db.ExecContext(ctx, s.query(`DELETE FROM caravan_store WHERE bucket = ? AND key = ?`), bucket, key)
+
+	return err
+}
+
+func (s *sqlStore) List(ctx context.Context, bucket, prefix string) ([]Entry, error) {
+	if err := validateBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	// A range rather than LIKE, which would need its wildcards escaped in
+	// the prefix; the range is then narrowed to the prefix below
+	rows, err := s.db.QueryContext(ctx, s.query(`SELECT key, value FROM caravan_store
+		WHERE bucket = ? AND key >= ? ORDER BY key`), bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.Key, &e.Value); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(e.Key, prefix) {
+			break
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+func (s *sqlStore) Scan(ctx context.Context, bucket string, r KeyRange, limit int) ([]Entry, error) {
+	if err := validateBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	// The range, the resume key and the limit all go to the primary key's
+	// index, so a page reads its own rows only
+	lo, hi := r.bounds()
+	q := `SELECT key, value FROM caravan_store WHERE bucket = ? AND key >= ?`
+	args := []any{bucket, lo}
+	if hi != "" {
+		q += ` AND key < ?`
+		args = append(args, hi)
+	}
+
+	order := `key`
+	switch {
+	case r.Descending:
+		order = `key DESC`
+		if r.After != "" {
+			q += ` AND key < ?`
+			args = append(args, r.After)
+		}
+	case r.After != "":
+		q += ` AND key > ?`
+		args = append(args, r.After)
+	}
+
+	q += ` ORDER BY ` + order
+	if limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.query(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.Key, &e.Value); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
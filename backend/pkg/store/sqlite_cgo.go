@@ -0,0 +1,8 @@
+//go:build cgo
+
+package store
+
+import _ "github.com/mattn/go-sqlite3"
+
+// sqliteDriver is the database/sql driver of SQLite stores
+const sqliteDriver = "sqlite3"
@@ -0,0 +1,6 @@
+//go:build !cgo
+
+package store
+
+// sqliteDriver is empty in builds without cgo, which the SQLite driver needs
+const sqliteDriver = ""
@@ -0,0 +1,211 @@
+// Package store persists Caravan's own data, such as deployment history or
+// saved views, as opaque values in named buckets.
+//
+// Without a data directory everything is kept in memory and lost on restart;
+// with one every bucket is written to a JSON file in that directory. Larger
+// installs may keep it in SQLite or Postgres instead.
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrNotFound is returned when a key does not exist.
+var ErrNotFound = errors.New("key not found")
+
+// Entry is a key and its value.
+type Entry struct {
+	Key   string
+	Value []byte
+}
+
+// KeyRange selects the keys of a bucket that Scan reads.
+type KeyRange struct {
+	// Prefix restricts the range to the keys with that prefix.
+	Prefix string
+	// Start and End bound the keys, Start included and End excluded; empty,
+	// they leave that side open.
+	Start, End string
+	// After skips the keys up to After, included, in the order of the range,
+	// to resume where a previous scan stopped.
+	After string
+	// Descending reads the range from its end.
+	Descending bool
+}
+
+// bounds returns the range as keys from lo, included, to hi, excluded, hi
+// empty when the range is open at its end. The keys with a prefix are those
+// from the prefix to the prefix with its last character incremented.
+func (r KeyRange) bounds() (lo, hi string) {
+	lo, hi = max(r.Start, r.Prefix), r.End
+
+	runes := []rune(r.Prefix)
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] == utf8.MaxRune {
+			continue
+		}
+
+		runes[i]++
+		if runes[i] >= 0xD800 && runes[i] <= 0xDFFF {
+			// Surrogates are not characters, the next one is
+			runes[i] = 0xE000
+		}
+		if end := string(runes[:i+1]); hi == "" || end < hi {
+			hi = end
+		}
+		break
+	}
+
+	return lo, hi
+}
+
+// contains reports whether key is in the range
+func (r KeyRange) contains(key string) bool {
+	lo, hi := r.bounds()
+
+	if r.After != "" && (r.Descending && key >= r.After || !r.Descending && key <= r.After) {
+		return false
+	}
+
+	return strings.HasPrefix(key, r.Prefix) && key >= lo && (hi == "" || key < hi)
+}
+
+// Store is a bucketed key-value store.
+type Store interface {
+	// Get returns the value of key in bucket or ErrNotFound.
+	Get(ctx context.Context, bucket, key string) ([]byte, error)
+	// Put sets the value of key in bucket.
+	Put(ctx context.Context, bucket, key string, value []byte) error
+	// Delete removes key from bucket. Deleting a missing key is not an error.
+	Delete(ctx context.Context, bucket, key string) error
+	// List returns the entries of bucket whose key starts with prefix, sorted by key.
+	List(ctx context.Context, bucket, prefix string) ([]Entry, error)
+	// Scan returns the first limit entries of bucket in r, in key order or,
+	// when r.Descending, in reverse key order; limit 0 returns all of them.
+	Scan(ctx context.Context, bucket string, r KeyRange, limit int) ([]Entry, error)
+	// Close releases the resources held by the store.
+	Close() error
+}
+
+var bucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// validateBucket makes sure bucket names are safe to use as file names.
+func validateBucket(bucket string) error {
+	if !bucketPattern.MatchString(bucket) {
+		return fmt.Errorf("invalid bucket name %q", bucket)
+	}
+
+	return nil
+}
+
+// Store backends
+const (
+	BackendMemory   = "memory"
+	BackendFile     = "file"
+	BackendSQLite   = "sqlite"
+	BackendPostgres = "postgres"
+)
+
+// New opens the store for dataDir, or an in-memory store if dataDir is empty.
+func New(dataDir string) (Store, error) {
+	if dataDir == "" {
+		return NewMemory(), nil
+	}
+
+	return NewFile(dataDir)
+}
+
+// DefaultBackend returns backend, or when it is empty New's choice of the
+// file store in dataDir or memory.
+func DefaultBackend(backend, dataDir string) string {
+	switch {
+	case backend != "":
+		return backend
+	case dataDir != "":
+		return BackendFile
+	default:
+		return BackendMemory
+	}
+}
+
+// Open opens the store of a backend, or of the DefaultBackend. SQLite keeps
+// its database in dataDir unless dsn is a path to it; Postgres needs the dsn.
+// The schema of SQL stores needs migrating before use.
+func Open(backend, dataDir, dsn string) (Store, error) {
+	switch DefaultBackend(backend, dataDir) {
+	case BackendMemory:
+		return NewMemory(), nil
+	case BackendFile:
+		if dataDir == "" {
+			return nil, errors.New("the file store needs a data directory")
+		}
+		return NewFile(dataDir)
+	case BackendSQLite:
+		if dsn == "" {
+			if dataDir == "" {
+				return nil, errors.New("the SQLite store needs a data directory or a database path")
+			}
+			if err := os.MkdirAll(dataDir, 0o700); err != nil {
+				return nil, fmt.Errorf("creating data directory: %w", err)
+			}
+			dsn = filepath.Join(dataDir, "caravan.db")
+		}
+		return NewSQLite(dsn)
+	case BackendPostgres:
+		if dsn == "" {
+			return nil, errors.New("the Postgres store needs a connection string")
+		}
+		return NewPostgres(dsn)
+	}
+
+	return nil, fmt.Errorf("unknown store backend %q", backend)
+}
+
+// GetJSON reads key from bucket and decodes it into v.
+func GetJSON(ctx context.Context, s Store, bucket, key string, v interface{}) error {
+	data, err := s.Get(ctx, bucket, key)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// PutJSON encodes v and writes it to key in bucket.
+func PutJSON(ctx context.Context, s Store, bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, bucket, key, data)
+}
+
+// ListJSON decodes all values of bucket with the given key prefix, in key order.
+func ListJSON[T any](ctx context.Context, s Store, bucket, prefix string) ([]T, error) {
+	entries, err := s.List(ctx, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]T, 0, len(entries))
+
+	for _, e := range entries {
+		var v T
+		if err := json.Unmarshal(e.Value, &v); err != nil {
+			return nil, fmt.Errorf("decoding %s/%s: %w", bucket, e.Key, err)
+		}
+
+		values = append(values, v)
+	}
+
+	return values, nil
+}
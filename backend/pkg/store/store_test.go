@@ -0,0 +1,412 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T, s store.Store) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	_, err := s.Get(ctx, "views", "missing")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	require.NoError(t, s.Put(ctx, "views", "prod/b", []byte("2")))
+	require.NoError(t, s.Put(ctx, "views", "prod/a", []byte("1")))
+	require.NoError(t, s.Put(ctx, "views", "dev/a", []byte("3")))
+
+	value, err := s.Get(ctx, "views", "prod/a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+
+	entries, err := s.List(ctx, "views", "prod/")
+	require.NoError(t, err)
+	assert.Equal(t, []store.Entry{{Key: "prod/a", Value: []byte("1")}, {Key: "prod/b", Value: []byte("2")}}, entries)
+
+	scan := func(r store.KeyRange, limit int) []string {
+		t.Helper()
+
+		entries, err := s.Scan(ctx, "views", r, limit)
+		require.NoError(t, err)
+
+		keys := []string{}
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+		return keys
+	}
+	// Right after the keys with the prefix prod/
+	require.NoError(t, s.Put(ctx, "views", "prod0", []byte("4")))
+	defer s.Delete(ctx, "views", "prod0")
+
+	assert.Equal(t, []string{"dev/a", "prod/a", "prod/b", "prod0"}, scan(store.KeyRange{}, 0))
+	assert.Equal(t, []string{"prod/a", "prod/b"}, scan(store.KeyRange{Prefix: "prod/"}, 0))
+	assert.Equal(t, []string{"prod/b"}, scan(store.KeyRange{Prefix: "prod/", Descending: true}, 1))
+	assert.Equal(t, []string{"prod/b", "prod0"}, scan(store.KeyRange{After: "prod/a"}, 0))
+	assert.Equal(t, []string{"prod/b"}, scan(store.KeyRange{Prefix: "prod/", After: "prod/a"}, 0))
+	assert.Equal(t, []string{"prod/a", "dev/a"}, scan(store.KeyRange{After: "prod/b", Descending: true}, 0))
+	assert.Equal(t, []string{"prod/a"}, scan(store.KeyRange{Start: "e", End: "prod/b"}, 5))
+	assert.Empty(t, scan(store.KeyRange{Prefix: "prod/", End: "prod/"}, 0))
+
+	require.NoError(t, s.Delete(ctx, "views", "prod/a"))
+	require.NoError(t, s.Delete(ctx, "views", "prod/a"))
+
+	_, err = s.Get(ctx, "views", "prod/a")
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	assert.Error(t, s.Put(ctx, "../escape", "k", nil))
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, store.NewMemory())
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := store.NewFile(dir)
+	require.NoError(t, err)
+	testStore(t, s)
+
+	// A new store on the same directory sees the persisted data.
+	reopened, err := store.NewFile(dir)
+	require.NoError(t, err)
+
+	value, err := reopened.Get(context.Background(), "views", "dev/a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("3"), value)
+}
+
+func TestSQLiteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "caravan.db")
+
+	s := openSQLite(t, path)
+	testStore(t, s)
+	require.NoError(t, s.Close())
+
+	reopened := openSQLite(t, path)
+	defer reopened.Close()
+
+	value, err := reopened.Get(context.Background(), "views", "dev/a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("3"), value)
+}
+
+func TestSQLiteMigrations(t *testing.T) {
+	ctx := context.Background()
+	s := openSQLite(t, filepath.Join(t.TempDir(), "caravan.db"))
+	defer s.Close()
+
+	m := s.(store.Migrator)
+	latest := m.LatestSchemaVersion()
+	require.Positive(t, latest)
+
+	version, dirty, err := m.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, latest, version)
+	assert.False(t, dirty)
+
+	// Down to an empty database and back up
+	require.NoError(t, m.MigrateTo(ctx, 0))
+	assert.Error(t, s.Put(ctx, "views", "a", []byte("1")), "the table is gone")
+	require.NoError(t, store.Migrate(ctx, s))
+	require.NoError(t, s.Put(ctx, "views", "a", []byte("1")))
+
+	assert.Error(t, m.MigrateTo(ctx, latest+1), "there is no such version")
+
+	// A newer build migrated it, this one must not run on it
+	require.NoError(t, m.ForceSchemaVersion(ctx, latest+1))
+	assert.ErrorIs(t, store.Migrate(ctx, s), store.ErrSchemaTooNew)
+}
+
+// openSQLite opens and migrates the SQLite store at path, skipping the test
+// in builds without SQLite
+func openSQLite(t *testing.T, path string) store.Store {
+	t.Helper()
+
+	s, err := store.NewSQLite(path)
+	if err != nil && strings.Contains(err.Error(), "cgo") {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	require.NoError(t, store.Migrate(context.Background(), s))
+
+	return s
+}
+
+// TestPostgresStore runs against the database of CARAVAN_TEST_POSTGRES_DSN,
+// which it leaves a table in
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("CARAVAN_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("CARAVAN_TEST_POSTGRES_DSN is not set")
+	}
+
+	s, err := store.NewPostgres(dsn)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, store.Migrate(context.Background(), s))
+
+	ctx := context.Background()
+	for _, key := range []string{"prod/a", "prod/b", "dev/a"} {
+		require.NoError(t, s.Delete(ctx, "views", key))
+	}
+	testStore(t, s)
+}
+
+func TestOpen(t *testing.T) {
+	_, err := store.Open(store.BackendPostgres, "", "")
+	assert.Error(t, err, "Postgres needs a connection string")
+
+	_, err = store.Open("etcd", "", "")
+	assert.Error(t, err)
+
+	s, err := store.Open(store.BackendFile, t.TempDir(), "")
+	require.NoError(t, err)
+	testStore(t, s)
+}
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+	raw := store.NewMemory()
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+	// A secret written before encryption was enabled
+	require.NoError(t, raw.Put(ctx, "secrets", "legacy", []byte("plain")))
+
+	s := store.NewEncrypted(raw, store.NewLocalKeyring(oldKey), "secrets")
+	testStore(t, store.NewEncrypted(store.NewMemory(), store.NewLocalKeyring(oldKey), "a", "b"))
+
+	require.NoError(t, s.Put(ctx, "secrets", "token", []byte("s3cr3t")))
+	require.NoError(t, s.Put(ctx, "views", "v", []byte("public")))
+
+	stored, err := raw.Get(ctx, "secrets", "token")
+	require.NoError(t, err)
+	assert.NotContains(t, string(stored), "s3cr3t", "secrets are encrypted at rest")
+
+	stored, err = raw.Get(ctx, "views", "v")
+	require.NoError(t, err)
+	assert.Equal(t, "public", string(stored), "other buckets are left alone")
+
+	v, err := s.Get(ctx, "secrets", "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(v), "plain values are still read")
+
+	// A value moved to another key does not decrypt
+	encrypted, _ := raw.Get(ctx, "secrets", "token")
+	require.NoError(t, raw.Put(ctx, "secrets", "moved", encrypted))
+	_, err = s.Get(ctx, "secrets", "moved")
+	assert.Error(t, err)
+	require.NoError(t, raw.Delete(ctx, "secrets", "moved"))
+
+	// Rotation: the new key reads the old values, then re-encrypts them
+	rotated := store.NewEncrypted(raw, store.NewLocalKeyring(newKey, oldKey), "secrets")
+	n, err := store.Reencrypt(ctx, rotated)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	stored, err = raw.Get(ctx, "secrets", "legacy")
+	require.NoError(t, err)
+	assert.NotContains(t, string(stored), "plain")
+
+	newOnly := store.NewEncrypted(raw, store.NewLocalKeyring(newKey), "secrets")
+	entries, err := newOnly.List(ctx, "secrets", "")
+	require.NoError(t, err)
+	assert.Equal(t, []store.Entry{{Key: "legacy", Value: []byte("plain")}, {Key: "token", Value: []byte("s3cr3t")}}, entries)
+
+	_, err = s.Get(ctx, "secrets", "token")
+	assert.Error(t, err, "the previous key alone no longer reads")
+
+	_, err = store.Reencrypt(ctx, raw)
+	assert.Error(t, err)
+}
+
+func TestVaultTransitKeyring(t *testing.T) {
+	ctx := context.Background()
+
+	// A fake Transit engine, "encrypting" by prefixing the key version
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "root", r.Header.Get("X-Vault-Token"))
+
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/caravan":
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"ciphertext": "vault:v1:" + body["plaintext"]}})
+		case "/v1/transit/decrypt/caravan":
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"plaintext": strings.TrimPrefix(body["ciphertext"], "vault:v1:")}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+
+	raw := store.NewMemory()
+	localKey := bytes.Repeat([]byte{1}, 32)
+	local := store.NewEncrypted(raw, store.NewLocalKeyring(localKey), "secrets")
+	require.NoError(t, local.Put(ctx, "secrets", "token", []byte("s3cr3t")))
+
+	// Moving from the local key to Vault
+	keys, err := store.OpenKeyring("vault-transit:transit/caravan", base64.StdEncoding.EncodeToString(localKey))
+	require.NoError(t, err)
+	s := store.NewEncrypted(raw, keys, "secrets")
+
+	_, err = store.Reencrypt(ctx, s)
+	require.NoError(t, err)
+
+	stored, err := raw.Get(ctx, "secrets", "token")
+	require.NoError(t, err)
+	assert.Contains(t, string(stored), "vault-transit:transit/caravan")
+
+	v, err := s.Get(ctx, "secrets", "token")
+	require.NoError(t, err)
+	assert.Equal(t, "s3cr3t", string(v))
+
+	_, err = store.OpenKeyring("vault-transit:transit", "")
+	assert.Error(t, err)
+	_, err = store.OpenKeyring("c2hvcnQ=", "")
+	assert.Error(t, err, "local keys are 32 bytes")
+}
+
+func TestJSONHelpers(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+
+	type view struct {
+		Name string
+	}
+
+	require.NoError(t, store.PutJSON(ctx, s, "views", "a", view{Name: "A"}))
+	require.NoError(t, store.PutJSON(ctx, s, "views", "b", view{Name: "B"}))
+
+	var v view
+	require.NoError(t, store.GetJSON(ctx, s, "views", "a", &v))
+	assert.Equal(t, "A", v.Name)
+
+	views, err := store.ListJSON[view](ctx, s, "views", "")
+	require.NoError(t, err)
+	assert.Equal(t, []view{{Name: "A"}, {Name: "B"}}, views)
+}
+
+func TestQueryJSON(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+
+	type entry struct {
+		N    int
+		Kind string
+	}
+
+	for i := 1; i <= 9; i++ {
+		kind := "odd"
+		if i%2 == 0 {
+			kind = "even"
+		}
+		require.NoError(t, store.PutJSON(ctx, s, "audit", fmt.Sprintf("%02d", i), entry{N: i, Kind: kind}))
+	}
+
+	numbers := func(page store.Page[entry]) []int {
+		var n []int
+		for _, e := range page.Items {
+			n = append(n, e.N)
+		}
+		return n
+	}
+
+	// Key range, newest first, in pages of two
+	q := store.Query[entry]{Start: "03", End: "08", Descending: true, Limit: 2}
+	var all []int
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 5)
+		page, err := store.QueryJSON(ctx, s, "audit", q)
+		require.NoError(t, err)
+		all = append(all, numbers(page)...)
+		if page.Next == "" {
+			break
+		}
+		q.Cursor = page.Next
+	}
+	assert.Equal(t, []int{7, 6, 5, 4, 3}, all)
+
+	// Filter
+	page, err := store.QueryJSON(ctx, s, "audit", store.Query[entry]{
+		Filter: func(e *entry) bool { return e.Kind == "even" },
+		Limit:  3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 4, 6}, numbers(page))
+	require.NotEmpty(t, page.Next)
+
+	page, err = store.QueryJSON(ctx, s, "audit", store.Query[entry]{
+		Filter: func(e *entry) bool { return e.Kind == "even" },
+		Cursor: page.Next,
+		Limit:  3,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []int{8}, numbers(page))
+	assert.Empty(t, page.Next)
+
+	// Sorting by a field, ties broken by key
+	byKind := store.Query[entry]{Less: func(a, b *entry) bool { return a.Kind < b.Kind }, Limit: 5}
+	page, err = store.QueryJSON(ctx, s, "audit", byKind)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 4, 6, 8, 1}, numbers(page))
+
+	byKind.Cursor = page.Next
+	page, err = store.QueryJSON(ctx, s, "audit", byKind)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 5, 7, 9}, numbers(page))
+
+	_, err = store.QueryJSON(ctx, s, "audit", store.Query[entry]{Cursor: "!"})
+	assert.ErrorIs(t, err, store.ErrInvalidCursor)
+
+	// Pages in key order only read their own entries, and the one after
+	counting := &scanCounter{Store: s}
+	page, err = store.QueryJSON(ctx, counting, "audit", store.Query[entry]{Start: "03", Descending: true, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []int{9, 8}, numbers(page))
+	assert.Equal(t, 3, counting.read)
+
+	counting.read = 0
+	page, err = store.QueryJSON(ctx, counting, "audit", store.Query[entry]{Cursor: page.Next, Descending: true, Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []int{7, 6}, numbers(page))
+	assert.Equal(t, 3, counting.read)
+}
+
+// scanCounter counts the entries read from a store by Scan, and fails List
+type scanCounter struct {
+	store.Store
+	read int
+}
+
+func (s *scanCounter) List(context.Context, string, string) ([]store.Entry, error) {
+	return nil, errors.New("the whole bucket was listed")
+}
+
+func (s *scanCounter) Scan(ctx context.Context, bucket string, r store.KeyRange, limit int) ([]store.Entry, error) {
+	entries, err := s.Store.Scan(ctx, bucket, r, limit)
+	s.read += len(entries)
+
+	return entries, err
+}
@@ -0,0 +1,175 @@
+// Package streams keeps track of the long-lived connections Caravan serves:
+// server-sent event streams, WebSockets and the event subscriptions of the
+// multiplexer. Each one is registered while it is open with what it streams
+// and how much it sent, so that leaks can be found, and closed, in
+// production.
+package streams
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kinds of streams
+const (
+	KindSSE          = "sse"
+	KindWebSocket    = "websocket"
+	KindSubscription = "subscription"
+)
+
+// Options describe a stream being opened
+type Options struct {
+	Kind    string
+	Cluster string
+	// User is the name of the user, when known. Otherwise it can be found
+	// from the token.
+	User  string
+	Token string
+	// Topic is what the stream follows, such as a path or event topics
+	Topic string
+	// Close ends the stream. It is called at most once, without locks held.
+	Close func()
+}
+
+// Info describes an open stream
+type Info struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	Cluster    string    `json:"cluster,omitempty"`
+	User       string    `json:"user,omitempty"`
+	Topic      string    `json:"topic"`
+	StartedAt  time.Time `json:"startedAt"`
+	AgeSeconds float64   `json:"ageSeconds"`
+	BytesSent  int64     `json:"bytesSent"`
+	// BytesPerSecond is the stream's throughput since it started
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	// Token is the token of the stream, to find out its user
+	Token string `json:"-"`
+}
+
+// Stream is an open stream. A nil Stream does nothing, so that untracked
+// streams need no special casing.
+type Stream struct {
+	id        string
+	opts      Options
+	startedAt time.Time
+	sent      atomic.Int64
+	closeOnce sync.Once
+	registry  *Registry
+}
+
+// Registry holds the open streams
+type Registry struct {
+	mutex   sync.Mutex
+	streams map[string]*Stream
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{streams: make(map[string]*Stream)}
+}
+
+// Open registers a stream until Done is called
+func (r *Registry) Open(opts Options) *Stream {
+	s := &Stream{id: uuid.NewString(), opts: opts, startedAt: time.Now(), registry: r}
+
+	r.mutex.Lock()
+	r.streams[s.id] = s
+	r.mutex.Unlock()
+
+	return s
+}
+
+// List returns the open streams, oldest first
+func (r *Registry) List() []Info {
+	r.mutex.Lock()
+	list := make([]Info, 0, len(r.streams))
+	for _, s := range r.streams {
+		list = append(list, s.Info())
+	}
+	r.mutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].StartedAt.Equal(list[j].StartedAt) {
+			return list[i].StartedAt.Before(list[j].StartedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+
+	return list
+}
+
+// Close ends the stream with id, reporting false if there is no such stream
+func (r *Registry) Close(id string) bool {
+	r.mutex.Lock()
+	s, ok := r.streams[id]
+	r.mutex.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	s.closeOnce.Do(func() {
+		if s.opts.Close != nil {
+			s.opts.Close()
+		}
+	})
+
+	return true
+}
+
+// ID returns the ID of the stream
+func (s *Stream) ID() string {
+	if s == nil {
+		return ""
+	}
+
+	return s.id
+}
+
+// Sent counts n more bytes sent on the stream
+func (s *Stream) Sent(n int) {
+	if s == nil {
+		return
+	}
+
+	s.sent.Add(int64(n))
+}
+
+// Done unregisters the stream once it has ended
+func (s *Stream) Done() {
+	if s == nil {
+		return
+	}
+
+	s.registry.mutex.Lock()
+	delete(s.registry.streams, s.id)
+	s.registry.mutex.Unlock()
+}
+
+// Info describes the stream
+func (s *Stream) Info() Info {
+	age := time.Since(s.startedAt).Seconds()
+	sent := s.sent.Load()
+
+	info := Info{
+		ID:         s.id,
+		Kind:       s.opts.Kind,
+		Cluster:    s.opts.Cluster,
+		User:       s.opts.User,
+		Topic:      s.opts.Topic,
+		StartedAt:  s.startedAt,
+		AgeSeconds: age,
+		BytesSent:  sent,
+		Token:      s.opts.Token,
+	}
+	if age > 0 {
+		info.BytesPerSecond = float64(sent) / age
+	}
+
+	return info
+}
@@ -0,0 +1,46 @@
+package streams_test
+
+import (
+	"testing"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/streams"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := streams.NewRegistry()
+
+	closed := 0
+	logs := registry.Open(streams.Options{Kind: streams.KindSSE, Cluster: "prod", Topic: "/v1/allocation/a/logs/web"})
+	sub := registry.Open(streams.Options{
+		Kind: streams.KindSubscription, Cluster: "prod", User: "alice", Topic: "Job,Allocation",
+		Close: func() { closed++ },
+	})
+
+	logs.Sent(10)
+	logs.Sent(5)
+
+	list := registry.List()
+	require.Len(t, list, 2)
+	assert.Equal(t, logs.ID(), list[0].ID, "oldest first")
+	assert.EqualValues(t, 15, list[0].BytesSent)
+	assert.Positive(t, list[0].BytesPerSecond)
+	assert.Equal(t, "alice", list[1].User)
+
+	assert.True(t, registry.Close(sub.ID()))
+	assert.True(t, registry.Close(sub.ID()), "the stream is listed until it is done")
+	assert.Equal(t, 1, closed, "streams are closed once")
+
+	sub.Done()
+	assert.False(t, registry.Close(sub.ID()))
+	require.Len(t, registry.List(), 1)
+
+	logs.Done()
+	assert.Empty(t, registry.List())
+
+	var untracked *streams.Stream
+	untracked.Sent(1)
+	untracked.Done()
+	assert.Empty(t, untracked.ID())
+}
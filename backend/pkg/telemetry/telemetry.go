@@ -3,6 +3,7 @@ package telemetry
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/VictoriaMetrics/metrics"
@@ -20,6 +21,26 @@ var (
 	// API proxy metrics
 	apiProxyRequests = metrics.NewCounter("nomad_api_requests_total")
 	apiProxyErrors   = metrics.NewCounter("nomad_api_errors_total")
+
+	// Upstream concurrency limit metrics, per cluster
+	upstreamSaturated   = make(map[string]*metrics.Counter)
+	upstreamSaturatedMu sync.Mutex
+
+	// Response cache metrics, per cached resource
+	responseCacheLookups   = make(map[string]*metrics.Counter)
+	responseCacheLookupsMu sync.Mutex
+
+	// Failed logins and the lockouts they caused, per cluster
+	loginCounters   = make(map[string]*metrics.Counter)
+	loginCountersMu sync.Mutex
+
+	// Throughput and stalls of the SSE streams and WebSockets, per kind of stream
+	streamCounters   = make(map[string]*metrics.Counter)
+	streamCountersMu sync.Mutex
+
+	// Gauges of the objects of each cluster, by full metric name
+	clusterGauges   = make(map[string]map[string]*metrics.Gauge)
+	clusterGaugesMu sync.Mutex
 )
 
 // RecordHTTPRequest records an HTTP request with method, path, and status
@@ -72,6 +93,149 @@ func RecordAPIProxyError() {
 	apiProxyErrors.Inc()
 }
 
+// RegisterUpstreamLimit exposes the concurrency limit of a cluster and
+// gauges reporting its in-flight and queued requests
+func RegisterUpstreamLimit(cluster string, limit float64, inFlight, queued func() float64) {
+	metrics.GetOrCreateGauge(fmt.Sprintf(`nomad_upstream_limit{cluster=%q}`, cluster),
+		func() float64 { return limit })
+	metrics.GetOrCreateGauge(fmt.Sprintf(`nomad_upstream_in_flight{cluster=%q}`, cluster), inFlight)
+	metrics.GetOrCreateGauge(fmt.Sprintf(`nomad_upstream_queued{cluster=%q}`, cluster), queued)
+}
+
+// RecordUpstreamQueued records a request that had to wait because a
+// cluster's concurrency limit was reached
+func RecordUpstreamQueued(cluster string) {
+	key := fmt.Sprintf(`nomad_upstream_saturated_total{cluster=%q}`, cluster)
+
+	upstreamSaturatedMu.Lock()
+	counter, ok := upstreamSaturated[key]
+	if !ok {
+		counter = metrics.NewCounter(key)
+		upstreamSaturated[key] = counter
+	}
+	upstreamSaturatedMu.Unlock()
+
+	counter.Inc()
+}
+
+// RecordResponseCache records a lookup in the response cache of a list endpoint
+func RecordResponseCache(resource string, hit bool) {
+	name := "nomad_response_cache_misses_total"
+	if hit {
+		name = "nomad_response_cache_hits_total"
+	}
+	key := fmt.Sprintf(`%s{resource=%q}`, name, resource)
+
+	responseCacheLookupsMu.Lock()
+	counter, ok := responseCacheLookups[key]
+	if !ok {
+		counter = metrics.NewCounter(key)
+		responseCacheLookups[key] = counter
+	}
+	responseCacheLookupsMu.Unlock()
+
+	counter.Inc()
+}
+
+// RecordLoginFailure records a login that failed on its credentials
+func RecordLoginFailure(cluster string) {
+	incLoginCounter(fmt.Sprintf(`login_failures_total{cluster=%q}`, cluster))
+}
+
+// RecordLoginLockout records a client, or all clients of a cluster, having to
+// wait before logging in again after too many failures
+func RecordLoginLockout(cluster, scope string) {
+	incLoginCounter(fmt.Sprintf(`login_lockouts_total{cluster=%q,scope=%q}`, cluster, scope))
+}
+
+func incLoginCounter(key string) {
+	loginCountersMu.Lock()
+	counter, ok := loginCounters[key]
+	if !ok {
+		counter = metrics.NewCounter(key)
+		loginCounters[key] = counter
+	}
+	loginCountersMu.Unlock()
+
+	counter.Inc()
+}
+
+// RecordStreamWrite records a write of bytes carrying messages to a client
+// of a kind of stream, such as logs or exec, and how long it took to go out
+func RecordStreamWrite(stream string, bytes, messages int, duration float64) {
+	incStreamCounter(fmt.Sprintf(`stream_bytes_sent_total{stream=%q}`, stream), bytes)
+	incStreamCounter(fmt.Sprintf(`stream_messages_sent_total{stream=%q}`, stream), messages)
+	metrics.GetOrCreateHistogram(fmt.Sprintf(`stream_write_duration_seconds{stream=%q}`, stream)).Update(duration)
+}
+
+// RecordStreamWriteTimeout records a client of a kind of stream that stopped
+// reading, and was dropped once a write to it timed out
+func RecordStreamWriteTimeout(stream string) {
+	incStreamCounter(fmt.Sprintf(`stream_write_timeouts_total{stream=%q}`, stream), 1)
+}
+
+func incStreamCounter(key string, n int) {
+	streamCountersMu.Lock()
+	counter, ok := streamCounters[key]
+	if !ok {
+		counter = metrics.NewCounter(key)
+		streamCounters[key] = counter
+	}
+	streamCountersMu.Unlock()
+
+	counter.Add(n)
+}
+
+// SetClusterGauges sets the gauges counting the objects of a cluster, given
+// by metric name without the cluster label, such as
+// nomad_allocations{client_status="running"}. The cluster's gauges missing
+// from values are removed.
+func SetClusterGauges(cluster string, values map[string]float64) {
+	clusterGaugesMu.Lock()
+	defer clusterGaugesMu.Unlock()
+
+	gauges := make(map[string]*metrics.Gauge, len(values))
+	for name, value := range values {
+		key := withClusterLabel(name, cluster)
+
+		gauge, ok := clusterGauges[cluster][key]
+		if !ok {
+			gauge = metrics.GetOrCreateGauge(key, nil)
+		}
+		gauge.Set(value)
+		gauges[key] = gauge
+	}
+
+	for key := range clusterGauges[cluster] {
+		if _, ok := gauges[key]; !ok {
+			metrics.UnregisterMetric(key)
+		}
+	}
+
+	clusterGauges[cluster] = gauges
+}
+
+// RemoveClusterGauges removes the gauges of a cluster, once it is gone
+func RemoveClusterGauges(cluster string) {
+	clusterGaugesMu.Lock()
+	defer clusterGaugesMu.Unlock()
+
+	for key := range clusterGauges[cluster] {
+		metrics.UnregisterMetric(key)
+	}
+	delete(clusterGauges, cluster)
+}
+
+// withClusterLabel adds the cluster label first to a metric name
+func withClusterLabel(name, cluster string) string {
+	label := fmt.Sprintf(`cluster=%q`, cluster)
+
+	if base, labels, ok := strings.Cut(name, "{"); ok {
+		return base + "{" + label + "," + labels
+	}
+	return name + "{" + label + "}"
+}
+
 // MetricsHandler returns an HTTP handler that exposes metrics in Prometheus format
 func MetricsHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
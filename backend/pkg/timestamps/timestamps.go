@@ -0,0 +1,75 @@
+// Package timestamps rewrites the timestamps of a JSON response as RFC 3339
+// strings with an explicit offset, in one time zone.
+//
+// Nomad encodes most times as nanoseconds since the epoch (a job's
+// SubmitTime, an allocation's CreateTime, a task event's Time) and some as
+// RFC 3339 strings in UTC, so a client rendering them consistently has to
+// know which is which. Timestamps are recognised by their field name, which
+// is Time or ends with Time or At, and by their value: an RFC 3339 string, or
+// a number large enough to be nanoseconds since 1973. Durations such as
+// MinHealthyTime stay numbers, as do zero times.
+package timestamps
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// minNanos is the smallest number taken for nanoseconds since the epoch,
+// in 1973; smaller numbers under a timestamp's name are durations
+const minNanos = 1e17
+
+// Normalize returns v as decoded from its JSON encoding, with its timestamps
+// formatted in loc.
+func Normalize(v any, loc *time.Location) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var decoded any
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, err
+	}
+
+	return walk("", decoded, loc), nil
+}
+
+func walk(name string, v any, loc *time.Location) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = walk(k, item, loc)
+		}
+	case []any:
+		// Lists of timestamps keep the name of their field
+		for i, item := range v {
+			v[i] = walk(name, item, loc)
+		}
+	case json.Number:
+		if isTimestamp(name) {
+			if ns, err := v.Int64(); err == nil && ns >= minNanos {
+				return time.Unix(0, ns).In(loc).Format(time.RFC3339Nano)
+			}
+		}
+	case string:
+		if isTimestamp(name) {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil && !t.IsZero() {
+				return t.In(loc).Format(time.RFC3339Nano)
+			}
+		}
+	}
+
+	return v
+}
+
+// isTimestamp reports whether a field name is one of a timestamp. The
+// suffixes are matched with their case, so that Format or Heartbeat are not.
+func isTimestamp(name string) bool {
+	return strings.EqualFold(name, "time") || strings.HasSuffix(name, "Time") || strings.HasSuffix(name, "At")
+}
@@ -0,0 +1,53 @@
+package timestamps_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caravan-nomad/caravan/backend/pkg/timestamps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+
+	submitted := time.Date(2026, 3, 1, 12, 0, 0, 500, time.UTC)
+
+	type event struct {
+		Time    int64
+		Message string
+	}
+	v := struct {
+		SubmitTime     int64
+		MinHealthyTime int64
+		ModifyTime     int64
+		Format         string
+		StartedAt      time.Time
+		FinishedAt     time.Time
+		Events         []event
+		Meta           map[string]string
+	}{
+		SubmitTime:     submitted.UnixNano(),
+		MinHealthyTime: int64(10 * time.Second),
+		Format:         "2026-03-01T12:00:00Z",
+		StartedAt:      submitted,
+		Events:         []event{{Time: submitted.UnixNano(), Message: "Started"}},
+		Meta:           map[string]string{"releasedAt": "2026-03-01T12:00:00Z"},
+	}
+
+	normalized, err := timestamps.Normalize(v, kolkata)
+	require.NoError(t, err)
+	m := normalized.(map[string]any)
+
+	assert.Equal(t, "2026-03-01T17:30:00.0000005+05:30", m["SubmitTime"])
+	assert.Equal(t, "2026-03-01T17:30:00.0000005+05:30", m["StartedAt"])
+	assert.Equal(t, "2026-03-01T17:30:00.0000005+05:30", m["Events"].([]any)[0].(map[string]any)["Time"])
+	assert.Equal(t, "2026-03-01T17:30:00+05:30", m["Meta"].(map[string]any)["releasedAt"])
+
+	assert.EqualValues(t, "10000000000", m["MinHealthyTime"], "durations stay numbers")
+	assert.EqualValues(t, "0", m["ModifyTime"], "zero times stay numbers")
+	assert.Equal(t, "0001-01-01T00:00:00Z", m["FinishedAt"], "zero times are left as is")
+	assert.Equal(t, "2026-03-01T12:00:00Z", m["Format"], "only timestamp names are rewritten")
+}
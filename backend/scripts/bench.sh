@@ -0,0 +1,56 @@
+#!/bin/sh -e
+
+# Runs the backend benchmarks and checks their allocations against
+# bench-budget.txt. The raw results are kept in $BENCH_OUT for benchstat.
+
+cd "$(dirname "$0")/.."
+
+BUDGET=scripts/bench-budget.txt
+BENCH_OUT=${BENCH_OUT:-bench.txt}
+
+# Only packages that define benchmarks, so that unrelated test builds do not
+# get in the way
+packages=$(grep -rl --include='*_test.go' '^func Benchmark' . | xargs -n1 dirname | sort -u)
+
+status=0
+go test -run '^$' -bench . -benchmem -count "${BENCH_COUNT:-1}" $packages > "$BENCH_OUT" || status=$?
+cat "$BENCH_OUT"
+[ $status -eq 0 ] || exit $status
+
+awk '
+  FNR == NR {
+    if ($0 !~ /^#/ && NF >= 2) {
+      allocBudget[$1] = $2
+      if (NF >= 3) byteBudget[$1] = $3
+    }
+    next
+  }
+  /^Benchmark/ {
+    name = $1
+    sub(/-[0-9]+$/, "", name)
+    for (i = 2; i < NF; i++) {
+      if ($(i + 1) == "allocs/op") allocs = $i
+      if ($(i + 1) == "B/op") bytes = $i
+    }
+    seen[name] = 1
+    if (name in allocBudget && allocs > allocBudget[name] + 0) {
+      printf "%s: %d allocs/op, over its budget of %d\n", name, allocs, allocBudget[name]
+      failed = 1
+    }
+    if (name in byteBudget && bytes > byteBudget[name] + 0) {
+      printf "%s: %d B/op, over its budget of %d\n", name, bytes, byteBudget[name]
+      failed = 1
+    }
+  }
+  END {
+    for (name in allocBudget) {
+      if (!(name in seen)) {
+        printf "%s: in the budget but did not run\n", name
+        failed = 1
+      }
+    }
+    exit failed
+  }
+' "$BUDGET" "$BENCH_OUT"
+
+echo "All benchmarks are within their allocation budget"
@@ -24,14 +24,7 @@ import {
   Typography,
   useTheme,
 } from '@mui/material';
-import {
-  getJob,
-  getJobAllocations,
-  deleteJob,
-  listJobs,
-  listDeployments,
-  getJobEvaluations,
-} from '../../../lib/nomad/api';
+import { getJobDetail, deleteJob, listJobs } from '../../../lib/nomad/api';
 import { Job, AllocationListStub, JobListStub, Deployment, Evaluation } from '../../../lib/nomad/types';
 import { SimpleTable } from '../../common';
 import { DateLabel } from '../../common/Label';
@@ -604,26 +597,23 @@ export default function JobDetails() {
 
     try {
       setLoading(true);
-      const [jobData, allocsData, allJobsData, deploymentsData, evaluationsData] = await Promise.all([
-        getJob(name, namespace),
-        getJobAllocations(name, namespace),
+      const [detail, allJobsData] = await Promise.all([
+        getJobDetail(name, namespace),
         listJobs({ namespace: namespace || '*' }),
-        listDeployments({ namespace: namespace || '*' }),
-        getJobEvaluations(name, namespace),
       ]);
-      setJob(jobData);
-      setAllocations(allocsData || []);
+      setJob(detail.job);
+      setAllocations(detail.allocations || []);
 
       const children = (allJobsData || []).filter(
         j => j.ParentID === name || getParentJobId(j.ID) === name
       );
       setChildJobs(children);
 
-      const jobDeployments = (deploymentsData || []).filter(d => d.JobID === name);
+      const jobDeployments = [...(detail.deployments || [])];
       jobDeployments.sort((a, b) => (b.JobCreateIndex || 0) - (a.JobCreateIndex || 0));
       setDeployments(jobDeployments);
 
-      const failures = extractPlacementFailures(evaluationsData || []);
+      const failures = extractPlacementFailures(detail.evaluations || []);
       setPlacementFailures(failures);
 
       setError(null);
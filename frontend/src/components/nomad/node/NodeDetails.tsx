@@ -17,7 +17,7 @@ import {
   Typography,
   useTheme,
 } from '@mui/material';
-import { getNode, getNodeAllocations, setNodeEligibility, drainNode } from '../../../lib/nomad/api';
+import { getNodeDetail, setNodeEligibility, drainNode } from '../../../lib/nomad/api';
 import { Node, AllocationListStub } from '../../../lib/nomad/types';
 import { SimpleTable } from '../../common';
 import { DateLabel } from '../../common/Label';
@@ -181,12 +181,9 @@ export default function NodeDetails() {
 
     try {
       setLoading(true);
-      const [nodeData, allocsData] = await Promise.all([
-        getNode(id),
-        getNodeAllocations(id).catch(() => []),
-      ]);
-      setNode(nodeData);
-      setAllocations(allocsData || []);
+      const detail = await getNodeDetail(id);
+      setNode(detail.node);
+      setAllocations(detail.allocations || []);
       setError(null);
     } catch (err) {
       setError(err as Error);
@@ -0,0 +1,27 @@
+import { applyPatch } from './jsonpatch';
+
+describe('applyPatch', () => {
+  it('should apply the operations the backend sends', () => {
+    const job = { ID: 'web', Meta: { 'a/b': '1', old: 'x' }, Tags: ['a', 'b', 'c'] };
+
+    const patched = applyPatch(job, [
+      { op: 'remove', path: '/Meta/old' },
+      { op: 'replace', path: '/Meta/a~1b', value: '2' },
+      { op: 'add', path: '/Stop', value: null },
+      { op: 'remove', path: '/Tags/2' },
+      { op: 'add', path: '/Tags/-', value: 'd' },
+    ]);
+
+    expect(patched).toEqual({ ID: 'web', Meta: { 'a/b': '2' }, Tags: ['a', 'b', 'd'], Stop: null });
+  });
+
+  it('should leave the document unchanged', () => {
+    const job = { Tags: ['a'] };
+    applyPatch(job, [{ op: 'add', path: '/Tags/-', value: 'b' }]);
+    expect(job.Tags).toEqual(['a']);
+  });
+
+  it('should reject missing paths', () => {
+    expect(() => applyPatch({}, [{ op: 'replace', path: '/a/b', value: 1 }])).toThrow();
+  });
+});
@@ -0,0 +1,63 @@
+/**
+ * An RFC 6902 operation, as the live-updating streams send them.
+ */
+export interface PatchOperation {
+  op: 'add' | 'remove' | 'replace' | 'test';
+  path: string;
+  value?: unknown;
+}
+
+function unescapeToken(token: string): string {
+  return token.replace(/~1/g, '/').replace(/~0/g, '~');
+}
+
+/**
+ * Apply a JSON patch to a copy of a document.
+ *
+ * Only the operations the backend sends are supported: add, remove and
+ * replace, plus test.
+ */
+export function applyPatch<T>(doc: T, patch: PatchOperation[]): T {
+  let result: any = structuredClone(doc);
+
+  for (const operation of patch) {
+    if (operation.path === '') {
+      if (operation.op === 'add' || operation.op === 'replace') {
+        result = structuredClone(operation.value);
+        continue;
+      }
+      throw new Error(`unsupported ${operation.op} on the whole document`);
+    }
+
+    const tokens = operation.path.slice(1).split('/').map(unescapeToken);
+    const last = tokens.pop()!;
+    let parent = result;
+    for (const token of tokens) {
+      parent = parent?.[Array.isArray(parent) ? Number(token) : token];
+      if (parent === undefined) {
+        throw new Error(`path ${operation.path} does not exist`);
+      }
+    }
+
+    if (Array.isArray(parent)) {
+      const index = last === '-' ? parent.length : Number(last);
+      if (operation.op === 'add') {
+        parent.splice(index, 0, operation.value);
+      } else if (operation.op === 'remove') {
+        parent.splice(index, 1);
+      } else if (operation.op === 'replace') {
+        parent[index] = operation.value;
+      }
+    } else if (parent !== null && typeof parent === 'object') {
+      if (operation.op === 'remove') {
+        delete parent[last];
+      } else if (operation.op === 'add' || operation.op === 'replace') {
+        parent[last] = operation.value;
+      }
+    } else {
+      throw new Error(`path ${operation.path} does not exist`);
+    }
+  }
+
+  return result;
+}
@@ -1,5 +1,5 @@
 import { ACLToken, ACLPolicy } from '../types';
-import { get } from './requests';
+import { get, remove } from './requests';
 
 export interface ListACLTokensParams {
   prefix?: string;
@@ -45,3 +45,17 @@ export function listACLPolicies(params?: ListACLPoliciesParams): Promise<ACLPoli
 export function getACLPolicy(policyName: string): Promise<ACLPolicy> {
   return get(`/v1/acl/policy/${encodeURIComponent(policyName)}`);
 }
+
+/**
+ * Delete an ACL token. Returns a pending approval instead when approvals are required.
+ */
+export function deleteACLToken(accessorId: string): Promise<Record<string, any>> {
+  return remove(`/v1/acl/token/${encodeURIComponent(accessorId)}`);
+}
+
+/**
+ * Delete an ACL policy. Returns a pending approval instead when approvals are required.
+ */
+export function deleteACLPolicy(policyName: string): Promise<Record<string, any>> {
+  return remove(`/v1/acl/policy/${encodeURIComponent(policyName)}`);
+}
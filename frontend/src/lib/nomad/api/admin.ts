@@ -0,0 +1,125 @@
+import { getAppUrl } from '../../../helpers/getAppUrl';
+import { applyErrorBody, NomadError } from './requests';
+
+/**
+ * What a cache holds for a resource of a cluster
+ */
+export interface CacheStats {
+  cache: 'response' | 'acl';
+  cluster: string;
+  resource: string;
+  entries: number;
+  hits: number;
+  misses: number;
+  hitRate: number;
+}
+
+export interface CacheReport {
+  /** 0 when the response cache is disabled */
+  responseCacheTTLSeconds: number;
+  caches: CacheStats[];
+}
+
+/**
+ * The shared Nomad client of a cluster
+ */
+export interface PooledClient {
+  cluster: string;
+  address: string;
+  pooled: boolean;
+  createdAt?: string;
+  ageSeconds?: number;
+  uses: number;
+}
+
+export type KillSwitchFeature = 'events' | 'logs' | 'aggregation';
+
+/**
+ * The state of a feature's kill switch. Reason, changedBy and changedAt
+ * describe the last change made at runtime.
+ */
+export interface KillSwitch {
+  feature: KillSwitchFeature;
+  description: string;
+  disabled: boolean;
+  reason?: string;
+  changedBy?: string;
+  changedAt?: string;
+}
+
+/**
+ * The cache, client pool and kill switches span clusters, so the requests
+ * bypass nomadRequest. Managers of any cluster may use them.
+ */
+async function adminRequest<T>(
+  path: string,
+  cluster?: string,
+  method = 'GET',
+  body?: unknown
+): Promise<T> {
+  const query = cluster ? `?cluster=${encodeURIComponent(cluster)}` : '';
+  const response = await fetch(`${getAppUrl()}api/admin/${path}${query}`, {
+    method,
+    credentials: 'include',
+    ...(body !== undefined && {
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body),
+    }),
+  });
+
+  const json = await response.json().catch(() => null);
+  if (!response.ok) {
+    const error = new Error(response.statusText) as NomadError;
+    error.status = response.status;
+    applyErrorBody(error, json, response.statusText);
+    throw error;
+  }
+
+  return json as T;
+}
+
+/**
+ * Get the entries, hits and misses of the caches, for one cluster or all
+ */
+export function getCacheStats(cluster?: string): Promise<CacheReport> {
+  return adminRequest('cache', cluster);
+}
+
+/**
+ * Drop what the caches hold, for one cluster or all
+ */
+export async function purgeCache(cluster?: string): Promise<void> {
+  await adminRequest('cache', cluster, 'DELETE');
+}
+
+/**
+ * List the clusters with their pooled client
+ */
+export function listPooledClients(cluster?: string): Promise<PooledClient[]> {
+  return adminRequest('clients', cluster);
+}
+
+/**
+ * Drop the pooled clients, for one cluster or all
+ */
+export async function purgePooledClients(cluster?: string): Promise<void> {
+  await adminRequest('clients', cluster, 'DELETE');
+}
+
+/**
+ * List the kill switches of the expensive features
+ */
+export function listKillSwitches(): Promise<KillSwitch[]> {
+  return adminRequest('kill-switches');
+}
+
+/**
+ * Switch a feature off, closing its open streams, or back on
+ */
+export function toggleKillSwitch(
+  feature: KillSwitchFeature,
+  disabled: boolean,
+  reason?: string
+): Promise<KillSwitch> {
+  return adminRequest(`kill-switches/${feature}`, undefined, 'PUT', { disabled, reason });
+}
@@ -70,6 +70,54 @@ export function readAllocFile(allocId: string, path: string): Promise<string> {
   );
 }
 
+/**
+ * URL that downloads a file from allocation, saved under its own name
+ */
+export function allocFileDownloadUrl(allocId: string, path: string, cluster?: string): string {
+  const clusterName = cluster || getCluster() || '';
+  if (!clusterName) {
+    throw new Error('No cluster context available. Please select a cluster first.');
+  }
+  const params = new URLSearchParams({ path, download: 'true' });
+
+  return `${window.location.origin}/api/clusters/${clusterName}/v1/allocation/${encodeURIComponent(allocId)}/file?${params}`;
+}
+
+export interface AllocFileChunk {
+  data?: string;
+  /** Offset in the file after the data */
+  offset: number;
+}
+
+export interface AllocFileRange {
+  data: string;
+  offset: number;
+  /** Size of the whole file */
+  size: number;
+}
+
+/**
+ * Read part of a file from allocation, so that large files can be paged
+ * through without downloading them whole
+ */
+export function readAllocFileRange(
+  allocId: string,
+  path: string,
+  offset: number,
+  limit: number
+): Promise<AllocFileRange> {
+  return get(
+    `/v1/allocation/${encodeURIComponent(allocId)}/file`,
+    { path, offset: String(offset), limit: String(limit) },
+    { isJSON: false }
+  ).then(async (response: any) => {
+    // Content-Range is "bytes start-end/size", or "bytes */size" past the end
+    const match = /\/(\d+)$/.exec(response.headers.get('Content-Range') ?? '');
+    const data = await response.text();
+    return { data, offset, size: match ? Number(match[1]) : offset + data.length };
+  });
+}
+
 /**
  * Stream allocation logs
  * Returns an EventSource for server-sent events
@@ -101,20 +149,68 @@ export function streamAllocationLogs(
 }
 
 /**
- * Create WebSocket connection for exec
+ * Stream a file of an allocation, like tail -f when following
+ * Returns an EventSource whose messages are JSON chunks of the file with the
+ * offset after them, plus truncated, deleted and eof events
+ */
+export function streamAllocFile(
+  allocId: string,
+  path: string,
+  follow = true,
+  origin: 'start' | 'end' = 'end',
+  offset?: number,
+  cluster?: string
+): EventSource {
+  const params = new URLSearchParams({ path, follow: follow.toString(), origin });
+  if (offset !== undefined) {
+    params.set('offset', offset.toString());
+  }
+
+  const baseUrl = window.location.origin;
+  const clusterName = cluster || getCluster() || '';
+  if (!clusterName) {
+    throw new Error('No cluster context available. Please select a cluster first.');
+  }
+  const url = `${baseUrl}/api/clusters/${clusterName}/v1/allocation/${encodeURIComponent(allocId)}/file/stream?${params}`;
+
+  return new EventSource(url);
+}
+
+/**
+ * Base URL of the backend's WebSockets
+ */
+function webSocketBaseUrl(): string {
+  // In dev mode, connect through Vite proxy (ws://localhost:3000)
+  if (isDevMode()) {
+    // Use window.location for dev mode to go through Vite proxy
+    const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+    return `${protocol}//${window.location.host}`;
+  }
+
+  // In production, connect directly to backend (ws://backend-url)
+  return getAppUrl().replace(/^http/, 'ws').replace(/\/$/, ''); // Remove trailing slash
+}
+
+/**
+ * Create WebSocket connection for exec. With binary, the command runs
+ * without a TTY and the data of stdin and output messages is base64 encoded,
+ * see encodeExecData and decodeExecData; send
+ * `{ type: 'stdin', close: true }` to end its stdin.
  */
 export function execInAllocation(
   allocId: string,
   taskName: string,
   command: string[],
   tty = true,
-  cluster?: string
+  cluster?: string,
+  binary = false
 ): WebSocket {
   // Backend splits command by space, so join the array
   const params = new URLSearchParams({
     command: command.join(' '),
-    tty: tty.toString(),
+    tty: (tty && !binary).toString(),
   });
+  if (binary) params.set('encoding', 'base64');
 
   const clusterName = cluster || getCluster() || '';
   
@@ -122,19 +218,7 @@ export function execInAllocation(
     throw new Error('No cluster context available. Please select a cluster first.');
   }
   
-  // In dev mode, connect through Vite proxy (ws://localhost:3000)
-  // In production, connect directly to backend (ws://backend-url)
-  let baseUrl: string;
-  if (isDevMode()) {
-    // Use window.location for dev mode to go through Vite proxy
-    const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    baseUrl = `${protocol}//${window.location.host}`;
-  } else {
-    // Use getAppUrl() for production to connect directly to backend
-    baseUrl = getAppUrl().replace(/^http/, 'ws').replace(/\/$/, ''); // Remove trailing slash
-  }
-  
-  const url = `${baseUrl}/api/clusters/${clusterName}/v1/allocation/${encodeURIComponent(allocId)}/exec/${encodeURIComponent(taskName)}?${params}`;
+  const url = `${webSocketBaseUrl()}/api/clusters/${clusterName}/v1/allocation/${encodeURIComponent(allocId)}/exec/${encodeURIComponent(taskName)}?${params}`;
 
   console.log('[execInAllocation] Creating WebSocket:', {
     url,
@@ -158,3 +242,44 @@ export function execInAllocation(
   
   return ws;
 }
+
+/** Encode stdin for a binary exec session */
+export function encodeExecData(data: Uint8Array): string {
+  let text = '';
+  for (const byte of data) text += String.fromCharCode(byte);
+  return btoa(text);
+}
+
+/** Decode the output of a binary exec session */
+export function decodeExecData(data: string): Uint8Array {
+  return Uint8Array.from(atob(data), (c) => c.charCodeAt(0));
+}
+
+export interface RunJobActionOptions {
+  namespace?: string;
+  /** Needed when several tasks declare the action */
+  task?: string;
+  /** Defaults to a running allocation of the task's group */
+  allocId?: string;
+  tty?: boolean;
+  cluster?: string;
+}
+
+/**
+ * Create WebSocket connection running an action declared by a job. It
+ * exchanges the same messages as execInAllocation.
+ */
+export function runJobAction(jobId: string, action: string, options: RunJobActionOptions = {}): WebSocket {
+  const clusterName = options.cluster || getCluster() || '';
+  if (!clusterName) {
+    throw new Error('No cluster context available. Please select a cluster first.');
+  }
+
+  const params = new URLSearchParams({ id: jobId, action });
+  if (options.namespace) params.set('namespace', options.namespace);
+  if (options.task) params.set('task', options.task);
+  if (options.allocId) params.set('allocID', options.allocId);
+  if (options.tty) params.set('tty', 'true');
+
+  return new WebSocket(`${webSocketBaseUrl()}/api/clusters/${clusterName}/v1/job/action?${params}`);
+}
@@ -0,0 +1,82 @@
+import { getAppUrl } from '../../../helpers/getAppUrl';
+import { applyErrorBody, NomadError } from './requests';
+
+export type ApprovalStatus = 'pending' | 'rejected' | 'executed' | 'failed' | 'cancelled';
+
+export type DestructiveAction = 'job.purge' | 'node.purge' | 'acl.token.delete' | 'acl.policy.delete';
+
+/**
+ * A destructive action held back until a second user approves it.
+ * Returned with status 202 by purge and ACL delete endpoints when the
+ * server runs with --require-approvals.
+ */
+export interface Approval {
+  id: string;
+  cluster: string;
+  action: DestructiveAction;
+  namespace?: string;
+  target: string;
+  status: ApprovalStatus;
+  requestedBy: string;
+  requestedAt: string;
+  decidedBy?: string;
+  decidedAt?: string;
+  result?: unknown;
+  error?: string;
+}
+
+/**
+ * Approvals are not scoped to a cluster path, so they bypass nomadRequest.
+ * The backend picks the token for the approval's cluster from the cookies.
+ */
+async function approvalsRequest<T>(path: string, method = 'GET'): Promise<T> {
+  const response = await fetch(`${getAppUrl()}api/approvals${path}`, {
+    method,
+    credentials: 'include',
+  });
+
+  const json = await response.json().catch(() => null);
+  if (!response.ok) {
+    const error = new Error(response.statusText) as NomadError;
+    error.status = response.status;
+    applyErrorBody(error, json, response.statusText);
+    throw error;
+  }
+
+  return json as T;
+}
+
+/**
+ * List approvals, newest first
+ */
+export function listApprovals(status?: ApprovalStatus): Promise<Approval[]> {
+  return approvalsRequest(status ? `?status=${encodeURIComponent(status)}` : '');
+}
+
+/**
+ * Get a single approval
+ */
+export function getApproval(id: string): Promise<Approval> {
+  return approvalsRequest(`/${encodeURIComponent(id)}`);
+}
+
+/**
+ * Approve a pending action, which runs it with the approver's token
+ */
+export function approveAction(id: string): Promise<Approval> {
+  return approvalsRequest(`/${encodeURIComponent(id)}/approve`, 'POST');
+}
+
+/**
+ * Reject a pending action
+ */
+export function rejectAction(id: string): Promise<Approval> {
+  return approvalsRequest(`/${encodeURIComponent(id)}/reject`, 'POST');
+}
+
+/**
+ * Withdraw a pending action, which only its requester can do
+ */
+export function cancelAction(id: string): Promise<Approval> {
+  return approvalsRequest(`/${encodeURIComponent(id)}`, 'DELETE');
+}
@@ -1,6 +1,7 @@
 import { Event } from '../types';
 import { getAppUrl } from '../../../helpers/getAppUrl';
 import { getCluster } from '../../cluster';
+import { applyPatch, PatchOperation } from '../../jsonpatch';
 
 export type EventTopic = 'Job' | 'Allocation' | 'Node' | 'Deployment' | 'Evaluation' | 'Service' | '*';
 
@@ -39,13 +40,45 @@ export function createEventStream(
   return new EventSource(url);
 }
 
+export type WatchType = 'job' | 'allocation' | 'node' | 'deployment' | 'evaluation';
+
+/**
+ * Create an EventSource following one object. It receives an `update` event
+ * with the object each time its ModifyIndex changes, and a `deleted` event
+ * once the object is gone. With `patches`, changes may come as `patch` events
+ * instead, holding the JSON patch from the previous object; see applyPatch.
+ */
+export function createWatch(
+  type: WatchType,
+  id: string,
+  options: { namespace?: string; cluster?: string; patches?: boolean } = {}
+): EventSource {
+  const params = new URLSearchParams({ type, id });
+  if (options.namespace) {
+    params.set('namespace', options.namespace);
+  }
+  if (options.patches) {
+    params.set('patches', 'true');
+  }
+
+  const clusterName = options.cluster || getCluster() || '';
+  const url = `${getAppUrl()}api/clusters/${clusterName}/v1/watch?${params}`;
+
+  return new EventSource(url);
+}
+
 /**
  * WebSocket-based event multiplexer connection
  * Useful for subscribing to events from multiple clusters
+ *
+ * Objects the multiplexer already sent arrive as JSON patches, which are
+ * applied here so that subscribers always get whole payloads.
  */
 export class EventMultiplexer {
   private ws: WebSocket | null = null;
   private subscriptions: Map<string, Set<(event: Event) => void>> = new Map();
+  // Last payload of each object, to apply patches to
+  private snapshots: Map<string, unknown> = new Map();
   private reconnectAttempts = 0;
   private maxReconnectAttempts = 5;
   private reconnectDelay = 1000;
@@ -60,6 +93,8 @@ export class EventMultiplexer {
 
     this.ws.onopen = () => {
       this.reconnectAttempts = 0;
+      // A new connection starts over with whole payloads
+      this.snapshots.clear();
       // Re-subscribe to all existing subscriptions
       this.subscriptions.forEach((_, key) => {
         const [clusterId, userId] = key.split(':');
@@ -73,6 +108,9 @@ export class EventMultiplexer {
         if (message.type === 'DATA') {
           const eventData = JSON.parse(message.data);
           const key = `${message.clusterId}:${message.userId}`;
+          if (!this.resolvePayload(key, eventData)) {
+            return;
+          }
           const callbacks = this.subscriptions.get(key);
           if (callbacks) {
             callbacks.forEach(cb => cb(eventData));
@@ -95,12 +133,41 @@ export class EventMultiplexer {
     };
   }
 
+  /**
+   * Turn a patch into the payload it patches to, and remember the payload.
+   * Returns false for a patch to an object never received.
+   */
+  private resolvePayload(
+    subscription: string,
+    eventData: { topic: string; type: string; key: string; namespace?: string; payload?: unknown; patch?: PatchOperation[] }
+  ): boolean {
+    const object = `${subscription}|${eventData.topic}/${eventData.namespace ?? ''}/${eventData.key}`;
+
+    if (eventData.patch) {
+      const snapshot = this.snapshots.get(object);
+      if (snapshot === undefined) {
+        console.error('Event patch for an unknown object:', object);
+        return false;
+      }
+      eventData.payload = applyPatch(snapshot, eventData.patch);
+      delete eventData.patch;
+    }
+
+    if (/Deregist|Delete/.test(eventData.type)) {
+      this.snapshots.delete(object);
+    } else {
+      this.snapshots.set(object, eventData.payload);
+    }
+    return true;
+  }
+
   private sendSubscribe(clusterId: string, userId: string) {
     if (this.ws?.readyState === WebSocket.OPEN) {
       this.ws.send(JSON.stringify({
         type: 'SUBSCRIBE',
         clusterId,
         userId,
+        patches: true,
       }));
     }
   }
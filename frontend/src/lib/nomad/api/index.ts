@@ -6,15 +6,47 @@ export type { RequestParams, NomadError } from './requests';
 export {
   listJobs,
   getJob,
+  getJobDetail,
   updateJob,
+  runJobHCL,
   deleteJob,
+  stopJobs,
+  listJobActions,
   dispatchJob,
+  evaluateJob,
   getJobAllocations,
   getJobVersions,
+  getJobDiff,
+  getJobDispatches,
   getJobEvaluations,
+  getJobDeployments,
+  getJobLatestDeployment,
+  getJobDeploymentHistory,
+  annotateJobVersion,
   scaleJob,
+  getJobScaleStatus,
+} from './jobs';
+export type {
+  ListJobsParams,
+  JobVersions,
+  JobVersionDiff,
+  JobDispatch,
+  JobDispatches,
+  VersionAnnotation,
+  JobDetail,
+  DispatchParameters,
+  DispatchOptions,
+  DeploymentRecord,
+  DeploymentHistory,
+  ScalingEvent,
+  JobScaleStatus,
+  StopJobTarget,
+  StopJobResult,
+  StopJobsResponse,
+  HCLDiagnostic,
+  RunHCLResponse,
+  JobAction,
 } from './jobs';
-export type { ListJobsParams, JobVersions } from './jobs';
 
 // Allocations API
 export {
@@ -25,20 +57,28 @@ export {
   getAllocationStats,
   listAllocFiles,
   readAllocFile,
+  readAllocFileRange,
+  allocFileDownloadUrl,
   streamAllocationLogs,
+  streamAllocFile,
   execInAllocation,
+  encodeExecData,
+  decodeExecData,
+  runJobAction,
 } from './allocations';
-export type { ListAllocationsParams } from './allocations';
+export type { ListAllocationsParams, RunJobActionOptions, AllocFileRange, AllocFileChunk } from './allocations';
 
 // Nodes API
 export {
   listNodes,
   getNode,
+  getNodeDetail,
   drainNode,
   setNodeEligibility,
+  purgeNode,
   getNodeAllocations,
 } from './nodes';
-export type { ListNodesParams } from './nodes';
+export type { ListNodesParams, NodeDetail, NodeDrainStatus } from './nodes';
 
 // Namespaces API
 export { listNamespaces, getNamespace } from './namespaces';
@@ -74,9 +114,30 @@ export {
   getACLToken,
   listACLPolicies,
   getACLPolicy,
+  deleteACLToken,
+  deleteACLPolicy,
 } from './acl';
 export type { ListACLTokensParams, ListACLPoliciesParams } from './acl';
 
+// Approvals API
+export { listApprovals, getApproval, approveAction, rejectAction, cancelAction } from './approvals';
+export type { Approval, ApprovalStatus, DestructiveAction } from './approvals';
+
+// Open streams API
+export { listStreams, closeStream } from './streams';
+export type { OpenStream, StreamKind } from './streams';
+
+// Cache and client pool API
+export {
+  getCacheStats,
+  purgeCache,
+  listPooledClients,
+  purgePooledClients,
+  listKillSwitches,
+  toggleKillSwitch,
+} from './admin';
+export type { CacheStats, CacheReport, PooledClient, KillSwitch, KillSwitchFeature } from './admin';
+
 // Auth API
 export { login, logout, checkAuth } from './auth';
 export type { LoginResponse, AuthCheckResponse } from './auth';
@@ -84,7 +145,8 @@ export type { LoginResponse, AuthCheckResponse } from './auth';
 // Events API
 export {
   createEventStream,
+  createWatch,
   EventMultiplexer,
   getEventMultiplexer,
 } from './events';
-export type { EventTopic, EventStreamOptions, EventStreamMessage } from './events';
+export type { EventTopic, EventStreamOptions, EventStreamMessage, WatchType } from './events';
@@ -1,5 +1,5 @@
-import { Job, JobListStub, AllocationListStub, Evaluation } from '../types';
-import { get, post, remove } from './requests';
+import { Job, JobListStub, JobSummary, AllocationListStub, Evaluation, Deployment } from '../types';
+import { get, post, put, remove } from './requests';
 
 export interface ListJobsParams {
   namespace?: string;
@@ -7,9 +7,49 @@ export interface ListJobsParams {
   filter?: string;
 }
 
+export interface VersionAnnotation {
+  version: number;
+  reason?: string;
+  ticket?: string;
+  deployer?: string;
+  pinned?: boolean;
+  createdAt?: string;
+}
+
 export interface JobVersions {
   versions: Job[];
   diffs: any[];
+  /** Caravan annotations keyed by job version */
+  annotations: Record<string, VersionAnnotation>;
+}
+
+export interface JobDetail {
+  job: Job;
+  summary: JobSummary | null;
+  latestDeployment: Deployment | null;
+  deployments: Deployment[] | null;
+  evaluations: Evaluation[] | null;
+  allocations: AllocationListStub[] | null;
+  scaleStatus: Record<string, any> | null;
+  /** What dispatching takes, for parameterized jobs */
+  parameters?: DispatchParameters;
+  /** Sections that failed to load, keyed by section name */
+  errors?: Record<string, string>;
+}
+
+export interface DispatchParameters {
+  payload: 'required' | 'optional' | 'forbidden';
+  metaRequired: string[];
+  metaOptional: string[];
+  /** The job's values of optional meta keys, kept unless the dispatch sets them */
+  metaDefaults?: Record<string, string>;
+}
+
+export interface DispatchOptions {
+  /** Goes into the dispatched job's ID, after the parent's */
+  idPrefix?: string;
+  /** Retrying with the same token returns the job dispatched first */
+  idempotencyToken?: string;
 }
 
 /**
@@ -27,10 +67,61 @@ export function getJob(jobId: string, namespace?: string): Promise<Job> {
 }
 
 /**
- * Create or update a job
+ * Get a job together with its summary, deployments, recent evaluations,
+ * allocations and scale status in a single request
+ */
+export function getJobDetail(jobId: string, namespace?: string): Promise<JobDetail> {
+  return get('/v1/job/detail', { id: jobId, namespace });
+}
+
+/**
+ * Create or update a job, optionally annotating the registered version
  */
-export function updateJob(job: Job): Promise<{ EvalID: string; EvalCreateIndex: number; JobModifyIndex: number }> {
-  return post(`/v1/job/${encodeURIComponent(job.ID!)}`, job);
+export function updateJob(
+  job: Job,
+  annotation?: Omit<VersionAnnotation, 'version' | 'createdAt'>
+): Promise<{ EvalID: string; EvalCreateIndex: number; JobModifyIndex: number }> {
+  const body = annotation ? { ...job, CaravanAnnotation: annotation } : job;
+  return post(`/v1/job/${encodeURIComponent(job.ID!)}`, body);
+}
+
+export interface HCLDiagnostic {
+  severity: string;
+  summary: string;
+  detail?: string;
+  range?: {
+    start: { line: number; column: number; byte: number };
+    end: { line: number; column: number; byte: number };
+  };
+}
+
+export interface RunHCLResponse {
+  job: Job;
+  diagnostics: HCLDiagnostic[];
+  plan?: any;
+  evalId?: string;
+  jobModifyIndex?: number;
+  warnings?: string;
+}
+
+/**
+ * Parse a job file and register the job in one call. With `plan`, the job is
+ * planned first and the plan annotations come back with the evaluation.
+ * Invalid job files are refused with their diagnostics as error details.
+ */
+export function runJobHCL(
+  hcl: string,
+  options: { variables?: string; plan?: boolean; namespace?: string } = {}
+): Promise<RunHCLResponse> {
+  const query = new URLSearchParams();
+  if (options.plan) {
+    query.set('plan', 'true');
+  }
+  if (options.namespace) {
+    query.set('namespace', options.namespace);
+  }
+  const suffix = query.toString() ? `?${query.toString()}` : '';
+  return post(`/v1/job/run-hcl${suffix}`, { hcl, variables: options.variables });
 }
 
 /**
@@ -40,17 +131,69 @@ export function deleteJob(jobId: string, purge = false, namespace?: string): Pro
   return remove('/v1/job', { id: jobId, purge, namespace });
 }
 
+export interface StopJobTarget {
+  id: string;
+  namespace?: string;
+  purge?: boolean;
+}
+
+export interface StopJobResult {
+  id: string;
+  namespace: string;
+  purge?: boolean;
+  evalId?: string;
+  /** Pending approval of a purge under the two-person rule */
+  approval?: unknown;
+  error?: { code: string; message: string; details?: unknown };
+}
+
+export interface StopJobsResponse {
+  results: StopJobResult[];
+  failed: number;
+}
+
+/**
+ * Stop several jobs at once. Each job succeeds or fails on its own, so check
+ * the results rather than only the request.
+ */
+export function stopJobs(jobs: StopJobTarget[], namespace?: string): Promise<StopJobsResponse> {
+  const query = namespace ? `?${new URLSearchParams({ namespace }).toString()}` : '';
+  return post(`/v1/jobs/stop${query}`, { jobs });
+}
+
 /**
- * Dispatch a parameterized job
+ * Dispatch a parameterized job, with a base64 payload. Retries should send
+ * the same idempotency token, so that they do not dispatch the job twice.
  */
 export function dispatchJob(
   jobId: string,
   payload?: string,
   meta?: Record<string, string>,
-  namespace?: string
+  namespace?: string,
+  options: DispatchOptions = {}
 ): Promise<{ DispatchedJobID: string; EvalID: string }> {
-  const query = namespace ? `?namespace=${encodeURIComponent(namespace)}` : '';
-  return post(`/v1/job/${encodeURIComponent(jobId)}/dispatch${query}`, { payload, meta });
+  const query = new URLSearchParams({ id: jobId });
+  if (namespace) {
+    query.set('namespace', namespace);
+  }
+  return post(`/v1/job/dispatch?${query.toString()}`, { payload, meta, ...options });
+}
+
+/**
+ * Create a new evaluation of a job, to get a stuck job scheduled again.
+ * forceReschedule also replaces failed allocations whose reschedule policy
+ * gave up on them.
+ */
+export function evaluateJob(
+  jobId: string,
+  forceReschedule = false,
+  namespace?: string
+): Promise<{ evalId: string }> {
+  const query = new URLSearchParams({ id: jobId });
+  if (namespace) {
+    query.set('namespace', namespace);
+  }
+  return post(`/v1/job/evaluate?${query.toString()}`, { forceReschedule });
 }
 
 /**
@@ -67,6 +210,94 @@ export function getJobVersions(jobId: string, namespace?: string): Promise<JobVe
   return get('/v1/job/versions', { id: jobId, namespace });
 }
 
+export interface JobVersionDiff {
+  from: number;
+  to: number;
+  /** In the shape of Nomad's version and plan diffs */
+  diff: any;
+}
+
+/**
+ * Compare two versions of a job; without `to`, against the latest version
+ */
+export function getJobDiff(
+  jobId: string,
+  from: number,
+  to?: number,
+  namespace?: string
+): Promise<JobVersionDiff> {
+  return get('/v1/job/diff', { id: jobId, from, to, namespace });
+}
+
+export interface JobDispatch {
+  id: string;
+  /** Empty once Nomad garbage collected the dispatched job */
+  status: string;
+  dispatchedAt: string;
+  dispatchedBy?: string;
+  viaCaravan: boolean;
+  meta?: Record<string, string>;
+  payloadSize: number;
+  details: boolean;
+}
+
+export interface JobDispatches {
+  jobId: string;
+  namespace: string;
+  dispatches: JobDispatch[];
+}
+
+/**
+ * Get the dispatch history of a parameterized job, newest first
+ */
+export function getJobDispatches(jobId: string, namespace?: string): Promise<JobDispatches> {
+  return get('/v1/job/dispatches', { id: jobId, namespace });
+}
+
+/**
+ * Annotate an existing job version
+ */
+export function annotateJobVersion(
+  jobId: string,
+  version: number,
+  annotation: Omit<VersionAnnotation, 'version' | 'createdAt'>,
+  namespace?: string
+): Promise<VersionAnnotation> {
+  const query = new URLSearchParams({ id: jobId, version: String(version) });
+  if (namespace) {
+    query.set('namespace', namespace);
+  }
+  return put(`/v1/job/version/annotation?${query.toString()}`, annotation);
+}
+
+export interface ScalingEvent {
+  group: string;
+  count?: number;
+  previousCount: number;
+  error: boolean;
+  message: string;
+  meta?: Record<string, any>;
+  evalId?: string;
+  time: string;
+}
+
+export interface JobScaleStatus {
+  /** Nomad's scale status: the running and desired counts of each task group */
+  status: Record<string, any> | null;
+  /** Scaling events of all task groups, newest first */
+  events: ScalingEvent[];
+  recommendations: Record<string, any>[];
+  recommendationsAvailable: boolean;
+  errors?: Record<string, string>;
+}
+
+/**
+ * Get the counts and scaling events of a job's task groups
+ */
+export function getJobScaleStatus(jobId: string, namespace?: string): Promise<JobScaleStatus> {
+  return get('/v1/job/scale-status', { id: jobId, namespace });
+}
+
 /**
  * Scale a job task group
  */
@@ -91,3 +322,74 @@ export function scaleJob(
 export function getJobEvaluations(jobId: string, namespace?: string): Promise<Evaluation[]> {
   return get('/v1/job/evaluations', { id: jobId, namespace });
 }
+
+/**
+ * Get the deployments of a job, newest first; all includes those of earlier
+ * jobs registered under the same ID
+ */
+export function getJobDeployments(
+  jobId: string,
+  namespace?: string,
+  all?: boolean
+): Promise<Deployment[]> {
+  return get('/v1/job/deployments', { id: jobId, namespace, all: all ? 'true' : undefined });
+}
+
+/**
+ * Get the latest deployment of a job
+ */
+export function getJobLatestDeployment(jobId: string, namespace?: string): Promise<Deployment> {
+  return get('/v1/job/deployment', { id: jobId, namespace });
+}
+
+export interface DeploymentRecord {
+  id: string;
+  jobId: string;
+  namespace: string;
+  jobVersion: number;
+  status: 'successful' | 'failed' | 'cancelled';
+  statusDescription: string;
+  rollback: boolean;
+  startedAt?: string;
+  finishedAt?: string;
+  durationSeconds: number;
+}
+
+export interface DeploymentHistory {
+  jobId: string;
+  namespace: string;
+  total: number;
+  successful: number;
+  failed: number;
+  cancelled: number;
+  rollbacks: number;
+  successRate: number;
+  meanDurationSeconds: number;
+  medianDurationSeconds: number;
+  deployments: DeploymentRecord[];
+}
+
+/**
+ * Get the recorded deployment outcomes of a job
+ */
+export function getJobDeploymentHistory(jobId: string, namespace?: string): Promise<DeploymentHistory> {
+  return get('/v1/job/deployment-history', { id: jobId, namespace });
+}
+
+/**
+ * An action declared by a task of a job, run with runJobAction
+ */
+export interface JobAction {
+  name: string;
+  command: string;
+  args?: string[];
+  taskName: string;
+  taskGroupName: string;
+}
+
+/**
+ * List the actions the tasks of a job declare
+ */
+export function listJobActions(jobId: string, namespace?: string): Promise<JobAction[]> {
+  return get('/v1/job/actions', { id: jobId, namespace });
+}
@@ -1,4 +1,4 @@
-import { Node, NodeListStub, AllocationListStub } from '../types';
+import { Node, NodeListStub, AllocationListStub, DrainStrategy } from '../types';
 import { get, post } from './requests';
 
 export interface ListNodesParams {
@@ -6,6 +6,25 @@ export interface ListNodesParams {
   filter?: string;
 }
 
+export interface NodeDrainStatus {
+  draining: boolean;
+  eligibility: string;
+  strategy?: DrainStrategy;
+  lastDrain?: Record<string, any>;
+  remainingAllocations: number;
+}
+
+export interface NodeDetail {
+  node: Node;
+  allocations: AllocationListStub[] | null;
+  stats: Record<string, any> | null;
+  drain: NodeDrainStatus;
+  index: number;
+  fetchedAt: string;
+  /** Sections that failed to load, keyed by section name */
+  errors?: Record<string, string>;
+}
+
 /**
  * List all nodes
  */
@@ -20,6 +39,14 @@ export function getNode(nodeId: string): Promise<Node> {
   return get(`/v1/node/${encodeURIComponent(nodeId)}`);
 }
 
+/**
+ * Get a node together with its allocations, host stats and drain status in a
+ * single request
+ */
+export function getNodeDetail(nodeId: string): Promise<NodeDetail> {
+  return get(`/v1/node/${encodeURIComponent(nodeId)}/detail`);
+}
+
 /**
  * Set node drain status
  */
@@ -48,6 +75,13 @@ export function setNodeEligibility(
   return post(`/v1/node/${encodeURIComponent(nodeId)}/eligibility`, { eligible });
 }
 
+/**
+ * Purge a node. Returns a pending approval instead when approvals are required.
+ */
+export function purgeNode(nodeId: string): Promise<Record<string, any>> {
+  return post(`/v1/node/${encodeURIComponent(nodeId)}/purge`);
+}
+
 /**
  * Get allocations for a node
  */
@@ -73,7 +73,10 @@ async function oidcRequest<T>(
     let errorMessage = response.statusText;
     try {
       const json = await response.json();
-      errorMessage = json.error || json.message || errorMessage;
+      errorMessage =
+        (typeof json.error === 'object' ? json.error?.message : json.error) ||
+        json.message ||
+        errorMessage;
     } catch {
       // Ignore JSON parse errors
     }
@@ -15,6 +15,39 @@ export interface NomadError extends Error {
   status?: number;
   cluster?: string;
   errorType?: 'auth' | 'not_found' | 'connection' | 'unknown';
+  /** Machine-readable error code from the backend, e.g. CLUSTER_NOT_FOUND */
+  code?: string;
+  details?: unknown;
+  upstreamStatus?: number;
+}
+
+/**
+ * Error envelope returned by the backend:
+ * { error: { code, message, details, cluster, upstreamStatus } }
+ */
+export interface APIErrorBody {
+  code: string;
+  message: string;
+  details?: unknown;
+  cluster?: string;
+  upstreamStatus?: number;
+}
+
+/**
+ * Copy the fields of a backend error response onto an error object.
+ * Older responses used { error: "message" }, which is still accepted.
+ */
+export function applyErrorBody(error: NomadError, json: any, fallback: string) {
+  const body = json?.error;
+  if (body && typeof body === 'object') {
+    const apiError = body as APIErrorBody;
+    error.message = apiError.message || fallback;
+    error.code = apiError.code;
+    error.details = apiError.details;
+    error.upstreamStatus = apiError.upstreamStatus;
+    return;
+  }
+  error.message = body || json?.message || fallback;
 }
 
 // Error event for components to react to
@@ -40,6 +73,14 @@ function notifyClusterError(cluster: string, error: NomadError) {
  * Check if an error is an authentication error
  */
 export function isAuthError(error: any): boolean {
+  if (
+    error?.code === 'UNAUTHORIZED' ||
+    error?.code === 'PERMISSION_DENIED' ||
+    error?.code === 'TOKEN_EXPIRED' ||
+    error?.code === 'TOKEN_INVALID'
+  ) {
+    return true;
+  }
   // Check status code first
   if (error?.status === 401 || error?.status === 403) {
     return true;
@@ -57,6 +98,9 @@ export function isAuthError(error: any): boolean {
  * Check if an error is a "context not found" error (cluster not registered)
  */
 export function isContextNotFoundError(error: any): boolean {
+  if (error?.code) {
+    return error.code === 'CLUSTER_NOT_FOUND';
+  }
   return (
     error?.status === 500 &&
     (error?.message?.includes('context not found') ||
@@ -132,7 +176,7 @@ export async function nomadRequest<T = any>(
       error.cluster = cluster;
       try {
         const json = await response.json();
-        error.message = json.error || json.message || response.statusText;
+        applyErrorBody(error, json, response.statusText);
       } catch {
         // Ignore JSON parse errors
       }
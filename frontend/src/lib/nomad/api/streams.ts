@@ -0,0 +1,56 @@
+import { getAppUrl } from '../../../helpers/getAppUrl';
+import { applyErrorBody, NomadError } from './requests';
+
+export type StreamKind = 'sse' | 'websocket' | 'subscription';
+
+/**
+ * A long-lived connection open on the server: a server-sent event stream,
+ * a WebSocket or an event subscription of the multiplexer
+ */
+export interface OpenStream {
+  id: string;
+  kind: StreamKind;
+  cluster?: string;
+  user?: string;
+  topic: string;
+  startedAt: string;
+  ageSeconds: number;
+  bytesSent: number;
+  /** Throughput since the stream started */
+  bytesPerSecond: number;
+}
+
+/**
+ * Streams span clusters, so the requests bypass nomadRequest. Managers of
+ * any cluster may list and close them.
+ */
+async function streamsRequest<T>(path: string, method = 'GET'): Promise<T> {
+  const response = await fetch(`${getAppUrl()}api/admin/streams${path}`, {
+    method,
+    credentials: 'include',
+  });
+
+  const json = await response.json().catch(() => null);
+  if (!response.ok) {
+    const error = new Error(response.statusText) as NomadError;
+    error.status = response.status;
+    applyErrorBody(error, json, response.statusText);
+    throw error;
+  }
+
+  return json as T;
+}
+
+/**
+ * List the open streams, oldest first
+ */
+export function listStreams(): Promise<OpenStream[]> {
+  return streamsRequest('');
+}
+
+/**
+ * Force-close an open stream
+ */
+export async function closeStream(id: string): Promise<void> {
+  await streamsRequest(`/${encodeURIComponent(id)}`, 'DELETE');
+}
@@ -11,6 +11,19 @@ export interface Cluster {
   [key: string]: any;
 }
 
+/**
+ * A period during which changes to matching clusters are blocked.
+ */
+export interface FreezeWindow {
+  name: string;
+  reason?: string;
+  clusters?: string[];
+  namespaces?: string[];
+  start: string;
+  end: string;
+  active: boolean;
+}
+
 export interface ConfigState {
   /**
    * Clusters is a map of cluster names to cluster objects.
@@ -35,6 +48,19 @@ export interface ConfigState {
   allClusters: {
     [clusterName: string]: Cluster;
   } | null;
+  /**
+   * Freeze windows that are active or start within the next week.
+   */
+  freezeWindows?: FreezeWindow[];
+  /**
+   * Whether the backend runs in dry-run mode and never writes to Nomad.
+   */
+  dryRun?: boolean;
+  /**
+   * The time zone the backend is configured to show times in, used until
+   * the user picks one. Undefined leaves it to the browser.
+   */
+  displayTimezone?: string;
   /**
    * Settings is a map of settings names to settings values.
    */
@@ -80,8 +106,24 @@ const configSlice = createSlice({
      * @param state - The current state.
      * @param action - The payload action containing the config.
      */
-    setConfig(state, action: PayloadAction<{ clusters: ConfigState['clusters'] }>) {
+    setConfig(
+      state,
+      action: PayloadAction<{
+        clusters: ConfigState['clusters'];
+        freezeWindows?: FreezeWindow[];
+        dryRun?: boolean;
+        displayTimezone?: string;
+      }>
+    ) {
       state.clusters = action.payload.clusters;
+      state.freezeWindows = action.payload.freezeWindows;
+      state.dryRun = action.payload.dryRun;
+      state.displayTimezone = action.payload.displayTimezone;
+      // Settings saved in this browser take precedence
+      const saved = JSON.parse(localStorage.getItem('settings') || '{}');
+      if (action.payload.displayTimezone && !saved.timezone) {
+        state.settings.timezone = action.payload.displayTimezone;
+      }
     },
     /**
      * Save the config. To both the store, and localStorage.